@@ -20,10 +20,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"os"
 
 	"k8s.io/klog/v2"
 
 	"github.com/llm-d/llm-d-inference-sim/cmd/signals"
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	vllmsim "github.com/llm-d/llm-d-inference-sim/pkg/llm-d-inference-sim"
 )
 
@@ -41,6 +44,11 @@ func main() {
 		return
 	}
 	if err := vllmSim.Start(ctx); err != nil {
+		var validationResult *common.ConfigValidationResult
+		if errors.As(err, &validationResult) {
+			// --validate-config-and-exit
+			os.Exit(validationResult.Print(os.Stdout))
+		}
 		logger.Error(err, "vLLM simulator failed")
 	}
 }
@@ -17,11 +17,16 @@ limitations under the License.
 package common
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,6 +41,13 @@ const (
 	ModeEcho        = "echo"
 	dummy           = "dummy"
 
+	// SchedulingPolicyFCFS - the waiting requests are processed in their arrival order
+	SchedulingPolicyFCFS = "fcfs"
+	// SchedulingPolicyPriority - the waiting requests are processed by their priority, and then by their arrival order
+	SchedulingPolicyPriority = "priority"
+
+	validateConfigAndExitFlag = "validate-config-and-exit"
+
 	// Failure type constants
 	FailureTypeRateLimit      = "rate_limit"
 	FailureTypeInvalidAPIKey  = "invalid_api_key"
@@ -43,15 +55,65 @@ const (
 	FailureTypeServerError    = "server_error"
 	FailureTypeInvalidRequest = "invalid_request"
 	FailureTypeModelNotFound  = "model_not_found"
+	// FailureTypeMissingDone is not an error response, a streaming response ends without the [DONE] sentinel
+	FailureTypeMissingDone = "missing_done"
+	// FailureTypeMissingContentType is not an error response, a non-streaming response is sent without
+	// the Content-Type header
+	FailureTypeMissingContentType = "missing_content_type"
+	// FailureTypeWrongContentType is not an error response, a non-streaming response is sent with
+	// the text/plain Content-Type
+	FailureTypeWrongContentType = "wrong_content_type"
+	// FailureTypeStreamError is not an error response, a streaming response is cut after
+	// StreamFailureAfterChunks token chunks by an error event
+	FailureTypeStreamError = "stream_error"
+	// FailureTypeStreamMalformed is not an error response, a streaming response is cut after
+	// StreamFailureAfterChunks token chunks by a malformed event
+	FailureTypeStreamMalformed = "stream_malformed"
+
+	// Metrics label schema constants
+	MetricsLabelSchemaV0     = "v0"
+	MetricsLabelSchemaV1     = "v1"
+	MetricsLabelSchemaCustom = "custom"
+
+	// Error schema constants
+	ErrorSchemaOpenAI = "openai"
+	ErrorSchemaAzure  = "azure"
+
+	// API flavor constants
+	APIFlavorOpenAI = "openai"
+	APIFlavorAzure  = "azure"
+
+	// P/D role constants
+	PDRolePrefill = "prefill"
+	PDRoleDecode  = "decode"
+
+	// Rate limit key constants
+	RateLimitByModel          = "model"
+	RateLimitByAPIKey         = "api-key"
+	RateLimitByModelAndAPIKey = "model-and-api-key"
+
+	// Decode slowdown model constants
+	DecodeSlowdownNone   = "none"
+	DecodeSlowdownLinear = "linear"
+	DecodeSlowdownLog    = "log"
+
+	// Dataset format constants
+	DatasetFormatSQLite  = "sqlite"
+	DatasetFormatJSONL   = "jsonl"
+	DatasetFormatParquet = "parquet"
 )
 
 type Configuration struct {
 	// Port defines on which port the simulator runs
 	Port int `yaml:"port" json:"port"`
+	// GRPCPort is the port of the KServe v2 gRPC server, 0 means the gRPC server is disabled
+	GRPCPort int `yaml:"grpc-port" json:"grpc-port"`
 	// Model defines the current base model name
 	Model string `yaml:"model" json:"model"`
 	// ServedModelNames is one or many model names exposed by the API
 	ServedModelNames []string `yaml:"served-model-name" json:"served-model-name"`
+	// AdditionalModels is a list of base models served in addition to Model, optional
+	AdditionalModels []ModelConfig `yaml:"additional-models" json:"additional-models"`
 	// MaxLoras defines maximum number of loaded LoRAs
 	MaxLoras int `yaml:"max-loras" json:"max-loras"`
 	// MaxCPULoras defines maximum number of LoRAs to store in CPU memory
@@ -59,13 +121,52 @@ type Configuration struct {
 	// MaxNumSeqs is maximum number of sequences per iteration (the maximum
 	// number of inference requests that could be processed at the same time)
 	MaxNumSeqs int `yaml:"max-num-seqs" json:"max-num-seqs"`
+	// MaxConcurrentPrefills is the maximum number of requests in the prefill (time to first token) phase
+	// at the same time, a request waits for a free prefill slot before its prefill starts, 0 means unlimited
+	MaxConcurrentPrefills int `yaml:"max-concurrent-prefills" json:"max-concurrent-prefills"`
+	// EnableChunkedPrefill enables chunked prefill, the prompt is prefilled in chunks of
+	// at most MaxNumBatchedTokens tokens per scheduling step, shared with the decoding requests
+	EnableChunkedPrefill bool `yaml:"enable-chunked-prefill" json:"enable-chunked-prefill"`
+	// EnableSamplingParams makes the temperature, top_p, presence_penalty and frequency_penalty of the
+	// requests influence the length and the content of the random responses
+	EnableSamplingParams bool `yaml:"enable-sampling-params" json:"enable-sampling-params"`
+	// MaxNumBatchedTokens is the maximum number of tokens processed in a single scheduling step
+	// when chunked prefill is enabled, default value is 2048
+	MaxNumBatchedTokens int `yaml:"max-num-batched-tokens" json:"max-num-batched-tokens"`
 	// MaxModelLen is the model's context window, the maximum number of tokens
 	// in a single request including input and output. Default value is 1024.
 	MaxModelLen int `yaml:"max-model-len" json:"max-model-len"`
+	// VisibleContextTokens is the number of trailing prompt tokens the model "sees",
+	// older tokens are dropped, optional, 0 (the default) means the whole prompt is visible
+	VisibleContextTokens int `yaml:"visible-context-tokens" json:"visible-context-tokens"`
 	// LoraModulesString is a list of LoRA adapters as strings
 	LoraModulesString []string `yaml:"lora-modules" json:"lora-modules"`
 	// LoraModules is a list of LoRA adapters
 	LoraModules []LoraModule
+	// LoraIdleUnloadAfter is the time after which a LoRA adapter that is not used by any request is unloaded,
+	// 0 means that idle adapters are never unloaded
+	LoraIdleUnloadAfter time.Duration `yaml:"lora-idle-unload-after" json:"lora-idle-unload-after"`
+	// LoraIdleUnloadStatic defines whether the idle LoRA adapters from LoraModules are unloaded as well
+	LoraIdleUnloadStatic bool `yaml:"lora-idle-unload-static" json:"lora-idle-unload-static"`
+	// LoraLoadLatency is the time it takes to load a LoRA adapter by /v1/load_lora_adapter
+	LoraLoadLatency time.Duration `yaml:"lora-load-latency" json:"lora-load-latency"`
+	// LoraUnloadLatency is the time it takes to unload a LoRA adapter by /v1/unload_lora_adapter
+	LoraUnloadLatency time.Duration `yaml:"lora-unload-latency" json:"lora-unload-latency"`
+	// MaxDynamicLoras is the maximum number of LoRA adapters loaded by /v1/load_lora_adapter at the same
+	// time, the adapters from LoraModules are not counted. 0 means unlimited
+	MaxDynamicLoras int `yaml:"max-dynamic-loras" json:"max-dynamic-loras"`
+	// LoraLoadFailureRate is the probability (0-100) that loading a LoRA adapter fails because its path
+	// is not found
+	LoraLoadFailureRate int `yaml:"lora-load-failure-rate" json:"lora-load-failure-rate"`
+
+	// HardwareProfile is the name of a predefined hardware profile, which sets the prefill, inter token latency,
+	// kv-cache transfer and max-num-seqs parameters, optional. Values set explicitly in the configuration
+	// file or in the command line override the profile values.
+	HardwareProfile string `yaml:"hardware-profile" json:"hardware-profile"`
+	// LatencyProfile is the path to a YAML or JSON file of latency tables: the time to first token by the number
+	// of prompt tokens, and the inter token latency by the number of generated tokens, optional. The tables
+	// replace the fixed time to first token, prefill and inter token latency parameters.
+	LatencyProfile string `yaml:"latency-profile" json:"latency-profile"`
 
 	// TimeToFirstToken time before the first token will be returned, in milliseconds
 	TimeToFirstToken int `yaml:"time-to-first-token" json:"time-to-first-token"`
@@ -96,6 +197,11 @@ type Configuration struct {
 	PrefillTimePerToken int `yaml:"prefill-time-per-token" json:"prefill-time-per-token"`
 	// PrefillOverheadStdDev similar to TimeToFirstTokenStdDev
 	PrefillTimeStdDev int `yaml:"prefill-time-std-dev" json:"prefill-time-std-dev"`
+	// PrefillTimePerImage is the time to encode an image of a chat completion request, in milliseconds,
+	// it is added to the time to first token of a local prefill
+	PrefillTimePerImage int `yaml:"prefill-time-per-image" json:"prefill-time-per-image"`
+	// ImageTokenCount is the number of prompt tokens of an image of a chat completion request
+	ImageTokenCount int `yaml:"image-token-count" json:"image-token-count"`
 	// $Total KV Cache Transfer Time = n * KVCacheTransferTimePerToken$
 	// the assumption is that the cache blocks are all missed at the remote pod
 	// KVCacheTransfer overhead time taken to transfer kv-cache from another vLLM instance in case P/D is activated,
@@ -103,6 +209,15 @@ type Configuration struct {
 	KVCacheTransferTimePerToken int `yaml:"kv-cache-transfer-time-per-token" json:"kv-cache-transfer-time-per-token"`
 	// KVCacheTransferOverheadStdDev similar to TimeToFirstTokenStdDev
 	KVCacheTransferTimeStdDev int `yaml:"kv-cache-transfer-time-std-dev" json:"kv-cache-transfer-time-std-dev"`
+	// PDRole is the role of the simulator in a disaggregated prefill/decode deployment, prefill or decode,
+	// empty when the simulator does both. In the prefill role every non-streaming request is handled as
+	// a request with do_remote_decode. In the decode role the remote prefill transfers the kv cache of
+	// the blocks that the prefill pod returned
+	PDRole string `yaml:"pd-role" json:"pd-role"`
+	// PrefillEndpoint is the base URL of the prefill pod, e.g. http://prefill:8000, used in the decode role.
+	// A request without do_remote_prefill is first sent to the prefill pod, and is then decoded with the
+	// kv transfer parameters of the prefill response
+	PrefillEndpoint string `yaml:"prefill-endpoint" json:"prefill-endpoint"`
 
 	// TimeFactorUnderLoad is a multiplicative factor that affects the overall time taken for requests when parallel
 	// requests are being processed.
@@ -111,6 +226,24 @@ type Configuration struct {
 	// - When the factor is x (where x > 1.0) and there are MaxNumSeqs requests, the total time will be multiplied by x.
 	// - The extra time then decreases multiplicatively to 1.0 when the number of requests is less than MaxNumSeqs.
 	TimeFactorUnderLoad float64 `yaml:"time-factor-under-load" json:"time-factor-under-load"`
+	// TimeScale accelerates the simulated clock, all the simulated delays (time to first token, inter token
+	// latency, kv cache transfer, etc.) are divided by it, while the latencies are reported in the simulated time.
+	// Must be greater than 0, 1 by default (real time)
+	TimeScale float64 `yaml:"time-scale" json:"time-scale"`
+	// DecodeTimePerSequence is the time each additional sequence decoded in the same scheduling step
+	// adds to a decode step, in milliseconds. The inter token latency grows with the number of
+	// concurrently decoding sequences, as with continuous batching. 0 disables it
+	DecodeTimePerSequence int `yaml:"decode-time-per-sequence" json:"decode-time-per-sequence"`
+	// DecodeSlowdownModel is the growth of the inter token latency with the number of generated tokens n:
+	// none (the default), linear (the latency is multiplied by 1 + DecodeSlowdownCoefficient * n) or
+	// log (the latency is multiplied by 1 + DecodeSlowdownCoefficient * ln(1 + n))
+	DecodeSlowdownModel string `yaml:"decode-slowdown-model" json:"decode-slowdown-model"`
+	// DecodeSlowdownCoefficient is the growth coefficient of DecodeSlowdownModel, must be >= 0
+	DecodeSlowdownCoefficient float64 `yaml:"decode-slowdown-coefficient" json:"decode-slowdown-coefficient"`
+	// PreemptionRate is the probability (0-100) that a request is preempted when it starts running while
+	// there are MaxNumSeqs running requests, the probability decreases linearly with the number of running
+	// requests. A preempted request returns to the waiting queue
+	PreemptionRate int `yaml:"preemption-rate" json:"preemption-rate"`
 
 	// Mode defines the simulator response generation mode, valid values: echo, random
 	Mode string `yaml:"mode" json:"mode"`
@@ -143,14 +276,28 @@ type Configuration struct {
 	// ObjectToolCallNotRequiredParamProbability is the probability to add a field, that is not required,
 	// in an object in a tool call, optional, defaults to 50
 	ObjectToolCallNotRequiredParamProbability int `yaml:"object-tool-call-not-required-field-probability" json:"object-tool-call-not-required-field-probability"`
+	// MaxToolsPerRequest is the maximum number of tool definitions allowed in a single request,
+	// optional, defaults to 128
+	MaxToolsPerRequest int `yaml:"max-tools-per-request" json:"max-tools-per-request"`
+	// MaxToolSchemaDepth is the maximum nesting depth of objects and arrays in a tool's parameters schema,
+	// optional, defaults to 16
+	MaxToolSchemaDepth int `yaml:"max-tool-schema-depth" json:"max-tool-schema-depth"`
 
 	// EnableKVCache defines if kv cache feature will be enabled
 	EnableKVCache bool `yaml:"enable-kvcache" json:"enable-kvcache"`
 	//  KVCacheSize is the maximum number of token blocks in kv cache, the default value is 1024
 	KVCacheSize int `yaml:"kv-cache-size" json:"kv-cache-size"`
+	// EnableMemoryModel enables the simulation of the GPU memory as a pool of kv-cache-size blocks, every running
+	// request holds the blocks of its prompt and generated tokens, requests wait or are preempted when the pool is exhausted
+	EnableMemoryModel bool `yaml:"enable-memory-model" json:"enable-memory-model"`
 
 	// TokenizersCacheDir is the directory for caching tokenizers
 	TokenizersCacheDir string `yaml:"tokenizers-cache-dir" json:"tokenizers-cache-dir"`
+	// TokenizerFailureRate is the probability (0-100) that a tokenization fails, simulates an unreachable
+	// tokenizers hub
+	TokenizerFailureRate int `yaml:"tokenizer-failure-rate" json:"tokenizer-failure-rate"`
+	// TokenizerFailureModels is a list of models whose tokenization always fails
+	TokenizerFailureModels []string `yaml:"tokenizer-failure-models" json:"tokenizer-failure-models"`
 	// TokenBlockSize is token block size for contiguous chunks of tokens, possible values: 8,16,32,64,128, defaults to 16
 	TokenBlockSize int `yaml:"block-size" json:"block-size"`
 	// HashSeed is the seed for hash generation (if not set, is read from PYTHONHASHSEED environment variable)
@@ -163,17 +310,163 @@ type Configuration struct {
 
 	// EventBatchSize is the maximum number of kv-cache events to be sent together, defaults to 16
 	EventBatchSize int `yaml:"event-batch-size" json:"event-batch-size"`
+	// KVEventsRecordFile is the path to a JSONL file to record the published kv-cache event batches in,
+	// so that they can be replayed later, optional
+	KVEventsRecordFile string `yaml:"kv-events-record-file" json:"kv-events-record-file"`
+	// EnableRequestEvents defines if the request lifecycle events are published to the ZMQ endpoint
+	EnableRequestEvents bool `yaml:"enable-request-events" json:"enable-request-events"`
 
 	// FakeMetrics is a set of metrics to send to Prometheus instead of the real data
 	FakeMetrics *Metrics `yaml:"fake-metrics" json:"fake-metrics"`
+	// MetricsLabelSchema defines the label keys attached to the model metrics, possible values:
+	// v0 (model_name, the default), v1 (model_name and engine="0") and custom (defined by MetricsCustomLabels)
+	MetricsLabelSchema string `yaml:"metrics-label-schema" json:"metrics-label-schema"`
+	// MetricsCustomLabels maps a metric name to its list of label keys, used when MetricsLabelSchema is custom.
+	// A label is either a key, whose value is the model name, or key=value for a constant label
+	MetricsCustomLabels map[string][]string `yaml:"metrics-custom-labels" json:"metrics-custom-labels"`
+	// OTLPEndpoint is the URL of the OTLP/HTTP endpoint the request traces are exported to,
+	// e.g. http://localhost:4318, optional, tracing is disabled if not set
+	OTLPEndpoint string `yaml:"otlp-endpoint" json:"otlp-endpoint"`
 
 	// FailureInjectionRate is the probability (0-100) of injecting failures
 	FailureInjectionRate int `yaml:"failure-injection-rate" json:"failure-injection-rate"`
 	// FailureTypes is a list of specific failure types to inject (empty means all types)
 	FailureTypes []string `yaml:"failure-types" json:"failure-types"`
+	// ContextLengthFailureRate is the probability (0-100) of injecting a context_length failure into a request
+	// whose prompt has at least ContextLengthFailureMinPromptTokens tokens
+	ContextLengthFailureRate int `yaml:"context-length-failure-rate" json:"context-length-failure-rate"`
+	// ContextLengthFailureMinPromptTokens is the minimal number of prompt tokens of the requests that
+	// may receive a context_length failure by ContextLengthFailureRate
+	ContextLengthFailureMinPromptTokens int `yaml:"context-length-failure-min-prompt-tokens" json:"context-length-failure-min-prompt-tokens"`
+	// ModelNotFoundFailureModels is a list of model names whose requests always receive a model_not_found failure
+	ModelNotFoundFailureModels []string `yaml:"model-not-found-failure-models" json:"model-not-found-failure-models"`
+	// AllowInjectionHeaders defines whether a request can choose the failure injected into its response
+	// using the x-sim-inject-failure header, regardless of the failure injection rate
+	AllowInjectionHeaders bool `yaml:"allow-injection-headers" json:"allow-injection-headers"`
+	// StreamFailureAfterChunks is the number of token chunks sent before a streaming response is cut
+	// by the stream_error or stream_malformed failure
+	StreamFailureAfterChunks int `yaml:"stream-failure-after-chunks" json:"stream-failure-after-chunks"`
+
+	// RateLimitRequestsPerMinute is the number of requests per minute allowed for a rate limit key,
+	// a request exceeding the limit is rejected with status 429, 0 means no limit
+	RateLimitRequestsPerMinute int `yaml:"rate-limit-rpm" json:"rate-limit-rpm"`
+	// RateLimitTokensPerMinute is the number of tokens (prompt tokens and maximum completion tokens)
+	// per minute allowed for a rate limit key, 0 means no limit
+	RateLimitTokensPerMinute int `yaml:"rate-limit-tpm" json:"rate-limit-tpm"`
+	// RateLimitBy is the key the rate limits are applied by: model, api-key or model-and-api-key
+	RateLimitBy string `yaml:"rate-limit-by" json:"rate-limit-by"`
+
+	// RepeatToolCallIDsInChunks defines whether the tool call id is sent in every chunk of a streamed tool call,
+	// by default it is sent in the first chunk only
+	RepeatToolCallIDsInChunks bool `yaml:"repeat-tool-call-ids-in-chunks" json:"repeat-tool-call-ids-in-chunks"`
+
+	// RefusalRate is the probability (0-100) that a chat completion is refused, the response's message
+	// carries a refusal instead of the content
+	RefusalRate int `yaml:"refusal-rate" json:"refusal-rate"`
+	// RefusalMessages is the list of refusals to choose from (empty means the default refusals)
+	RefusalMessages []string `yaml:"refusal-messages" json:"refusal-messages"`
+
+	// FlexTierLatencyFactor is a multiplicative factor applied to the time to first token and the inter
+	// token latency of chat completion requests processed in the flex service tier, must be >= 1.0
+	FlexTierLatencyFactor float64 `yaml:"flex-tier-latency-factor" json:"flex-tier-latency-factor"`
+	// FlexTierAutoRate is the probability (0-100) that a chat completion request with service tier auto
+	// is processed in the flex service tier, otherwise it is processed in the default service tier
+	FlexTierAutoRate int `yaml:"flex-tier-auto-rate" json:"flex-tier-auto-rate"`
+	// ServiceTierMetrics defines whether the number of requests processed in each service tier is reported
+	ServiceTierMetrics bool `yaml:"service-tier-metrics" json:"service-tier-metrics"`
+
+	// StarvationThreshold is the average queue wait of a model above which the model is considered starving,
+	// 0 disables the starvation detection
+	StarvationThreshold time.Duration `yaml:"starvation-threshold" json:"starvation-threshold"`
+	// StarvationDuration is the time the average queue wait of a model must stay above StarvationThreshold
+	// before the starvation is reported
+	StarvationDuration time.Duration `yaml:"starvation-duration" json:"starvation-duration"`
+
+	// SchedulingPolicy defines the order in which the waiting requests are processed, fcfs (first come
+	// first served) or priority (by the priority field of the requests, a lower value is processed first)
+	SchedulingPolicy string `yaml:"scheduling-policy" json:"scheduling-policy"`
+
+	// ClockSkew is added to all externally visible timestamps (e.g. the created field of the responses),
+	// may be negative, the latencies are computed using the real clock
+	ClockSkew time.Duration `yaml:"clock-skew" json:"clock-skew"`
+
+	// TemplateKwargsTokenDelta is a list of rules that adjust the number of prompt tokens of chat completion
+	// requests whose chat_template_kwargs contain the rule's key with the rule's value
+	TemplateKwargsTokenDelta []TemplateKwargsTokenDeltaRule `yaml:"template-kwargs-token-delta" json:"template-kwargs-token-delta"`
+
+	// UploadBandwidthBytesPerSec simulates a slow upload of the request body, the request is delayed
+	// by the time it takes to read its body at this bandwidth before it is processed, 0 disables the delay
+	UploadBandwidthBytesPerSec int `yaml:"upload-bandwidth-bytes-per-sec" json:"upload-bandwidth-bytes-per-sec"`
+
+	// ErrorSchema defines the format of the error responses' body, possible values:
+	// openai (the default) and azure (Azure OpenAI format)
+	ErrorSchema string `yaml:"error-schema" json:"error-schema"`
+
+	// APIFlavor defines the format of the completion responses, possible values: openai (the default)
+	// and azure, the Azure OpenAI format, without the model in the chunks and with the results of
+	// the content filters
+	APIFlavor string `yaml:"api-flavor" json:"api-flavor"`
+
+	// StrictAccept defines whether requests whose Accept header doesn't allow the response media type
+	// (text/event-stream for streaming, application/json otherwise) are rejected with 406
+	StrictAccept bool `yaml:"strict-accept" json:"strict-accept"`
+
+	// EnableAdminAPI defines whether the admin and debug endpoints (e.g. /debug/queue) are served
+	EnableAdminAPI bool `yaml:"enable-admin-api" json:"enable-admin-api"`
+	// EnablePprof defines whether the Go runtime profiling endpoints are served under /debug/pprof/
+	EnablePprof bool `yaml:"enable-pprof" json:"enable-pprof"`
+
+	// OmitDoneSentinel defines whether streaming responses end without the data: [DONE] sentinel
+	OmitDoneSentinel bool `yaml:"omit-done-sentinel" json:"omit-done-sentinel"`
+	// EmitChunkTiming defines whether every chunk of a streaming response includes the sim_elapsed_ms field,
+	// the cumulative delay the simulator intended for the chunk's token, used by latency tests
+	EmitChunkTiming bool `yaml:"emit-chunk-timing" json:"emit-chunk-timing"`
+	// StreamTokensPerChunk is the number of tokens of a choice sent together in a chunk of a streaming response
+	StreamTokensPerChunk int `yaml:"stream-tokens-per-chunk" json:"stream-tokens-per-chunk"`
+	// StreamFlushInterval is the interval the chunks of a streaming response are flushed to the client at,
+	// 0 means every chunk is flushed when it is sent
+	StreamFlushInterval time.Duration `yaml:"stream-flush-interval" json:"stream-flush-interval"`
+	// NetworkLatency is the simulated network latency of the responses, every response and every chunk
+	// of a streaming response reaches the client this duration after it is written, 0 means no latency
+	NetworkLatency time.Duration `yaml:"network-latency" json:"network-latency"`
+	// NetworkJitter is the maximal random deviation of the network latency of a write, the latency of
+	// every write is uniformly distributed in [NetworkLatency-NetworkJitter, NetworkLatency+NetworkJitter]
+	NetworkJitter time.Duration `yaml:"network-jitter" json:"network-jitter"`
+	// MaxStreamDuration is the maximal duration of a response, a response that would take longer is cut
+	// at this duration and ends with MaxStreamDurationFinishReason, 0 means unlimited
+	MaxStreamDuration time.Duration `yaml:"max-stream-duration" json:"max-stream-duration"`
+	// MaxStreamDurationFinishReason is the finish reason of a response cut at MaxStreamDuration
+	MaxStreamDurationFinishReason string `yaml:"max-stream-duration-finish-reason" json:"max-stream-duration-finish-reason"`
+	// FinishReasonDistribution maps the finish reasons (stop, length, content_filter and tool_calls) to their shares
+	// in percents of the random responses, the shares sum up to 100. The finish reason follows from the length
+	// of the response if it is empty
+	FinishReasonDistribution map[string]int `yaml:"finish-reason-distribution" json:"finish-reason-distribution"`
+	// ServedModelMaxModelLen maps served model names and LoRA adapters to their context windows, overriding
+	// MaxModelLen and the context windows defined in AdditionalModels and LoraModules
+	ServedModelMaxModelLen map[string]int `yaml:"served-model-max-model-len" json:"served-model-max-model-len"`
+
+	// DrainTimeout is the maximal time the simulator waits for the in-flight requests to finish when
+	// it drains before stopping, 0 means the simulator stops without waiting
+	DrainTimeout time.Duration `yaml:"drain-timeout" json:"drain-timeout"`
+
+	// StartupDelay is the time after the simulator start before the model starts loading
+	StartupDelay time.Duration `yaml:"startup-delay" json:"startup-delay"`
+	// ModelLoadTime is the time it takes to load the model after the startup delay, the simulator
+	// is not ready and rejects the inference requests until the model is loaded
+	ModelLoadTime time.Duration `yaml:"model-load-time" json:"model-load-time"`
+
+	// ConfigFile is the path to the configuration file, empty if the simulator was started without one
+	ConfigFile string `yaml:"-" json:"config"`
+	// ConfigReloadInterval is the interval between the checks for changes of the configuration file,
+	// 0 means the file is reloaded only on SIGHUP
+	ConfigReloadInterval time.Duration `yaml:"config-reload-interval" json:"config-reload-interval"`
 
 	// DPSize is data parallel size - a number of ranks to run, minimum is 1, maximum is 8, default is 1
 	DPSize int `yaml:"data-parallel-size" json:"data-parallel-size"`
+	// DPRankOverrides are the parameters of the data parallel ranks that differ from the configuration,
+	// the key is the rank, the value is a map of the parameters and their values for this rank.
+	// Only the latency, failure injection and max-num-seqs parameters can be overridden
+	DPRankOverrides map[int]map[string]any `yaml:"data-parallel-rank-overrides" json:"data-parallel-rank-overrides,omitempty"`
 
 	// SSLCertFile is the path to the SSL certificate file for HTTPS
 	SSLCertFile string `yaml:"ssl-certfile" json:"ssl-certfile"`
@@ -197,6 +490,48 @@ type Configuration struct {
 	DatasetURL string `yaml:"dataset-url" json:"dataset-url"`
 	// DatasetInMemory defines whether to load the entire dataset into memory for faster access.
 	DatasetInMemory bool `yaml:"dataset-in-memory" json:"dataset-in-memory"`
+	// DatasetMaxMemoryBytes is the maximum estimated size of a dataset loaded into memory, when a larger
+	// dataset is loaded, the load is aborted and the dataset file is used instead. 0 means no limit
+	DatasetMaxMemoryBytes int64 `yaml:"dataset-max-memory-bytes" json:"dataset-max-memory-bytes"`
+	// DatasetFormat is the format of the dataset file, sqlite or jsonl, when empty the format is
+	// selected by the extension of the file: .jsonl and .ndjson are jsonl, any other extension is sqlite.
+	// A jsonl dataset is always loaded into memory
+	DatasetFormat string `yaml:"dataset-format" json:"dataset-format"`
+	// ResponseGeneratorURL is the URL of a webhook that generates the responses, it receives every request
+	// and returns its tokens, the dataset is used for the requests the webhook doesn't answer. Optional
+	ResponseGeneratorURL string `yaml:"response-generator-url" json:"response-generator-url"`
+
+	// ReplayFile is the path to a JSONL file of requests that the simulator issues to itself at startup,
+	// every line is a JSON object with the offset_ms, endpoint and body of a request, optional
+	ReplayFile string `yaml:"replay-file" json:"replay-file"`
+	// ReplaySpeed is the speed of the replay, the offsets of the replayed requests are divided by it
+	ReplaySpeed float64 `yaml:"replay-speed" json:"replay-speed"`
+	// RecordTrace is the path to a JSONL file the incoming completion requests are recorded in, in the format
+	// of the replay file, so that the recorded traffic can be replayed by ReplayFile, optional
+	RecordTrace string `yaml:"record-trace" json:"record-trace"`
+
+	// EnableAccessLog defines if an access log entry is written for the HTTP requests, as a JSON object per line
+	EnableAccessLog bool `yaml:"enable-access-log" json:"enable-access-log"`
+	// AccessLogFile is the path to the file the access log is appended to, the access log is written
+	// to the standard output if not set
+	AccessLogFile string `yaml:"access-log-file" json:"access-log-file"`
+	// AccessLogSampleRate is the probability (0-100) that a request is written to the access log
+	AccessLogSampleRate int `yaml:"access-log-sample-rate" json:"access-log-sample-rate"`
+
+	// FileMaxBytes is the maximal size of a file uploaded to the files API
+	FileMaxBytes int64 `yaml:"file-max-bytes" json:"file-max-bytes"`
+	// BatchStartDelay is the time a batch of the batch API is validating before its requests start to run
+	BatchStartDelay time.Duration `yaml:"batch-start-delay" json:"batch-start-delay"`
+	// BatchMaxConcurrentRequests is the maximal number of requests of a batch that run at the same time
+	BatchMaxConcurrentRequests int `yaml:"batch-max-concurrent-requests" json:"batch-max-concurrent-requests"`
+
+	// UsageRetention is the period the usage reported by /v1/usage is kept in memory
+	UsageRetention time.Duration `yaml:"usage-retention" json:"usage-retention"`
+	// UsageExportFile is the path to a JSON file the usage is exported to periodically and at shutdown, optional
+	UsageExportFile string `yaml:"usage-export-file" json:"usage-export-file"`
+
+	// EmbeddingDim is the number of dimensions of the embeddings returned by /v1/embeddings
+	EmbeddingDim int `yaml:"embedding-dim" json:"embedding-dim"`
 }
 
 type Metrics struct {
@@ -211,6 +546,42 @@ type Metrics struct {
 	KVCacheUsagePercentage float32 `yaml:"kv-cache-usage" json:"kv-cache-usage"`
 }
 
+// TemplateKwargsTokenDeltaRule adds tokens to the prompt when a chat template argument has a specific value
+type TemplateKwargsTokenDeltaRule struct {
+	// Key is the name of the chat template argument
+	Key string `yaml:"key" json:"key"`
+	// Value is the value of the argument the rule applies to
+	Value any `yaml:"value" json:"value"`
+	// ExtraTokens is the number of tokens added to the prompt, negative values remove tokens
+	ExtraTokens int `yaml:"extra_tokens" json:"extra_tokens"`
+}
+
+// PromptTokensDelta returns the total number of tokens the matching rules add to a prompt
+// rendered with the given chat template arguments, arguments without rules are ignored
+func (c *Configuration) PromptTokensDelta(chatTemplateKwargs map[string]any) int {
+	delta := 0
+	for _, rule := range c.TemplateKwargsTokenDelta {
+		if value, ok := chatTemplateKwargs[rule.Key]; ok && sameJSONValue(value, rule.Value) {
+			delta += rule.ExtraTokens
+		}
+	}
+	return delta
+}
+
+// sameJSONValue checks whether the two values have the same JSON representation, so that
+// values parsed from yaml and from JSON (e.g. int and float64 numbers) are comparable
+func sameJSONValue(a any, b any) bool {
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aJSON) == string(bJSON)
+}
+
 type LorasMetrics struct {
 	// RunningLoras is a comma separated list of running LoRAs
 	RunningLoras string `json:"running"`
@@ -227,6 +598,176 @@ type LoraModule struct {
 	Path string `json:"path"`
 	// BaseModelName is the LoRA's base model
 	BaseModelName string `json:"base_model_name"`
+	// TimeToFirstToken overrides the time to first token of the LoRA's requests, in milliseconds, optional
+	TimeToFirstToken *int `json:"time_to_first_token,omitempty"`
+	// TimeToFirstTokenStdDev overrides the standard deviation of the time to first token of the LoRA's
+	// requests, in milliseconds, optional
+	TimeToFirstTokenStdDev *int `json:"time_to_first_token_std_dev,omitempty"`
+	// InterTokenLatency overrides the time between generated tokens of the LoRA's requests, in milliseconds, optional
+	InterTokenLatency *int `json:"inter_token_latency,omitempty"`
+	// InterTokenLatencyStdDev overrides the standard deviation of the time between generated tokens of
+	// the LoRA's requests, in milliseconds, optional
+	InterTokenLatencyStdDev *int `json:"inter_token_latency_std_dev,omitempty"`
+	// MaxModelLen overrides the context window of the LoRA, optional
+	MaxModelLen *int `json:"max_model_len,omitempty"`
+}
+
+// ModelConfig is a base model served in addition to the simulator's model
+type ModelConfig struct {
+	// Name is the model's name
+	Name string `yaml:"name" json:"name"`
+	// ServedModelNames is one or many model names exposed by the API, the model's name by default
+	ServedModelNames []string `yaml:"served_model_name" json:"served_model_name,omitempty"`
+	// TimeToFirstToken overrides the time to first token of the model's requests, in milliseconds, optional
+	TimeToFirstToken *int `yaml:"time_to_first_token" json:"time_to_first_token,omitempty"`
+	// TimeToFirstTokenStdDev overrides the standard deviation of the time to first token of the model's
+	// requests, in milliseconds, optional
+	TimeToFirstTokenStdDev *int `yaml:"time_to_first_token_std_dev" json:"time_to_first_token_std_dev,omitempty"`
+	// InterTokenLatency overrides the time between generated tokens of the model's requests, in milliseconds, optional
+	InterTokenLatency *int `yaml:"inter_token_latency" json:"inter_token_latency,omitempty"`
+	// InterTokenLatencyStdDev overrides the standard deviation of the time between generated tokens of
+	// the model's requests, in milliseconds, optional
+	InterTokenLatencyStdDev *int `yaml:"inter_token_latency_std_dev" json:"inter_token_latency_std_dev,omitempty"`
+	// MaxModelLen overrides the context window of the model, optional
+	MaxModelLen *int `yaml:"max_model_len" json:"max_model_len,omitempty"`
+}
+
+// LatencyProfile contains the time to first token and the inter token latency settings of a model
+type LatencyProfile struct {
+	TimeToFirstToken        int
+	TimeToFirstTokenStdDev  int
+	InterTokenLatency       int
+	InterTokenLatencyStdDev int
+}
+
+// GetLatencyProfile returns the latency settings of the given model, a setting defined for
+// a model in additional-models or for a LoRA adapter in lora-modules overrides the simulator's
+// setting, an adapter of an additional model inherits the settings of its base model
+func (c *Configuration) GetLatencyProfile(model string) LatencyProfile {
+	profile := LatencyProfile{
+		TimeToFirstToken:        c.TimeToFirstToken,
+		TimeToFirstTokenStdDev:  c.TimeToFirstTokenStdDev,
+		InterTokenLatency:       c.InterTokenLatency,
+		InterTokenLatencyStdDev: c.InterTokenLatencyStdDev,
+	}
+	if base := c.GetAdditionalModel(model); base != nil {
+		profile.override(base.TimeToFirstToken, base.TimeToFirstTokenStdDev, base.InterTokenLatency,
+			base.InterTokenLatencyStdDev)
+		return profile
+	}
+	for _, lora := range c.LoraModules {
+		if lora.Name != model {
+			continue
+		}
+		if base := c.GetAdditionalModel(lora.BaseModelName); base != nil {
+			profile.override(base.TimeToFirstToken, base.TimeToFirstTokenStdDev, base.InterTokenLatency,
+				base.InterTokenLatencyStdDev)
+		}
+		profile.override(lora.TimeToFirstToken, lora.TimeToFirstTokenStdDev, lora.InterTokenLatency,
+			lora.InterTokenLatencyStdDev)
+		break
+	}
+	return profile
+}
+
+// override replaces the settings of the profile by the given settings that are defined
+func (p *LatencyProfile) override(ttft *int, ttftStdDev *int, itl *int, itlStdDev *int) {
+	if ttft != nil {
+		p.TimeToFirstToken = *ttft
+	}
+	if ttftStdDev != nil {
+		p.TimeToFirstTokenStdDev = *ttftStdDev
+	}
+	if itl != nil {
+		p.InterTokenLatency = *itl
+	}
+	if itlStdDev != nil {
+		p.InterTokenLatencyStdDev = *itlStdDev
+	}
+}
+
+// GetMaxModelLen returns the context window of the given served model name or LoRA adapter. The context
+// window of a served model name in served-model-max-model-len overrides the context window of its model in
+// additional-models, which overrides max-model-len. A LoRA adapter inherits the context window of its base
+// model unless it is defined for the adapter in lora-modules or in served-model-max-model-len
+func (c *Configuration) GetMaxModelLen(model string) int {
+	if maxModelLen, ok := c.ServedModelMaxModelLen[model]; ok {
+		return maxModelLen
+	}
+	if base := c.GetAdditionalModel(model); base != nil {
+		if base.MaxModelLen != nil {
+			return *base.MaxModelLen
+		}
+		return c.MaxModelLen
+	}
+	for _, lora := range c.LoraModules {
+		if lora.Name != model {
+			continue
+		}
+		if lora.MaxModelLen != nil {
+			return *lora.MaxModelLen
+		}
+		return c.GetMaxModelLen(c.loraParent(lora.BaseModelName))
+	}
+	return c.MaxModelLen
+}
+
+// loraParent returns the served model name of the base model of a LoRA adapter, the first served
+// model name of the simulator's model if the adapter has no base model
+func (c *Configuration) loraParent(baseModelName string) string {
+	if baseModelName == "" || baseModelName == c.Model {
+		return c.ServedModelNames[0]
+	}
+	if base := c.GetAdditionalModel(baseModelName); base != nil && len(base.ServedModelNames) > 0 {
+		return base.ServedModelNames[0]
+	}
+	return baseModelName
+}
+
+// GetLargestMaxModelLen returns the largest context window of the served models and the LoRA adapters
+func (c *Configuration) GetLargestMaxModelLen() int {
+	largest := c.MaxModelLen
+	for _, model := range c.AdditionalModels {
+		if model.MaxModelLen != nil {
+			largest = max(largest, *model.MaxModelLen)
+		}
+	}
+	for _, lora := range c.LoraModules {
+		if lora.MaxModelLen != nil {
+			largest = max(largest, *lora.MaxModelLen)
+		}
+	}
+	for _, maxModelLen := range c.ServedModelMaxModelLen {
+		largest = max(largest, maxModelLen)
+	}
+	return largest
+}
+
+// GetAdditionalModel returns the model from additional-models with the given name or served model name,
+// nil if the given model is not an additional model
+func (c *Configuration) GetAdditionalModel(model string) *ModelConfig {
+	for i := range c.AdditionalModels {
+		if c.AdditionalModels[i].Name == model || slices.Contains(c.AdditionalModels[i].ServedModelNames, model) {
+			return &c.AdditionalModels[i]
+		}
+	}
+	return nil
+}
+
+// validateLatencyProfile checks the latency settings of the given model or LoRA adapter
+func validateLatencyProfile(profile LatencyProfile, owner string) []error {
+	var errs []error
+	if profile.TimeToFirstToken < 0 || profile.TimeToFirstTokenStdDev < 0 ||
+		profile.InterTokenLatency < 0 || profile.InterTokenLatencyStdDev < 0 {
+		errs = append(errs, fmt.Errorf("latencies of %s cannot be negative", owner))
+	}
+	if float32(profile.TimeToFirstTokenStdDev) > 0.3*float32(profile.TimeToFirstToken) {
+		errs = append(errs, fmt.Errorf("time to first token standard deviation of %s cannot be more than 30%% of its time to first token", owner))
+	}
+	if float32(profile.InterTokenLatencyStdDev) > 0.3*float32(profile.InterTokenLatency) {
+		errs = append(errs, fmt.Errorf("inter token latency standard deviation of %s cannot be more than 30%% of its inter token latency", owner))
+	}
+	return errs
 }
 
 // Needed to parse values that contain multiple strings
@@ -268,6 +809,51 @@ func (c *Configuration) unmarshalFakeMetrics(fakeMetricsString string) error {
 	return nil
 }
 
+func (c *Configuration) unmarshalMetricsCustomLabels(customLabelsString string) error {
+	var customLabels map[string][]string
+	if err := json.Unmarshal([]byte(customLabelsString), &customLabels); err != nil {
+		return err
+	}
+	c.MetricsCustomLabels = customLabels
+	return nil
+}
+
+func (c *Configuration) unmarshalTemplateKwargsTokenDelta(rulesString string) error {
+	var rules []TemplateKwargsTokenDeltaRule
+	if err := json.Unmarshal([]byte(rulesString), &rules); err != nil {
+		return err
+	}
+	c.TemplateKwargsTokenDelta = rules
+	return nil
+}
+
+func (c *Configuration) unmarshalFinishReasonDistribution(distributionString string) error {
+	var distribution map[string]int
+	if err := json.Unmarshal([]byte(distributionString), &distribution); err != nil {
+		return err
+	}
+	c.FinishReasonDistribution = distribution
+	return nil
+}
+
+func (c *Configuration) unmarshalServedModelMaxModelLen(maxModelLenString string) error {
+	var maxModelLen map[string]int
+	if err := json.Unmarshal([]byte(maxModelLenString), &maxModelLen); err != nil {
+		return err
+	}
+	c.ServedModelMaxModelLen = maxModelLen
+	return nil
+}
+
+func (c *Configuration) unmarshalAdditionalModels(modelsString string) error {
+	var models []ModelConfig
+	if err := json.Unmarshal([]byte(modelsString), &models); err != nil {
+		return err
+	}
+	c.AdditionalModels = models
+	return nil
+}
+
 func (c *Configuration) unmarshalLoraFakeMetrics() error {
 	if c.FakeMetrics != nil {
 		c.FakeMetrics.LoraMetrics = make([]LorasMetrics, 0)
@@ -287,21 +873,43 @@ func newConfig() *Configuration {
 		Port:                                vLLMDefaultPort,
 		MaxLoras:                            1,
 		MaxNumSeqs:                          5,
+		MaxNumBatchedTokens:                 2048,
 		MaxModelLen:                         1024,
 		Mode:                                ModeRandom,
+		SchedulingPolicy:                    SchedulingPolicyFCFS,
 		Seed:                                time.Now().UnixNano(),
 		TimeFactorUnderLoad:                 1.0,
+		TimeScale:                           1.0,
+		DecodeSlowdownModel:                 DecodeSlowdownNone,
+		FlexTierLatencyFactor:               1.0,
 		MaxToolCallIntegerParam:             100,
 		MaxToolCallNumberParam:              100,
 		MaxToolCallArrayParamLength:         5,
 		MinToolCallArrayParamLength:         1,
 		ToolCallNotRequiredParamProbability: 50,
 		ObjectToolCallNotRequiredParamProbability: 50,
-		KVCacheSize:    1024,
-		TokenBlockSize: 16,
-		ZMQEndpoint:    "tcp://localhost:5557",
-		EventBatchSize: 16,
-		DPSize:         1,
+		MaxToolsPerRequest:                        128,
+		MaxToolSchemaDepth:                        16,
+		KVCacheSize:                               1024,
+		TokenBlockSize:                            16,
+		ZMQEndpoint:                               "tcp://localhost:5557",
+		EventBatchSize:                            16,
+		DPSize:                                    1,
+		MetricsLabelSchema:                        MetricsLabelSchemaV0,
+		RateLimitBy:                               RateLimitByModel,
+		ErrorSchema:                               ErrorSchemaOpenAI,
+		APIFlavor:                                 APIFlavorOpenAI,
+		StreamFailureAfterChunks:                  1,
+		MaxStreamDurationFinishReason:             "length",
+		StreamTokensPerChunk:                      1,
+		DrainTimeout:                              30 * time.Second,
+		ReplaySpeed:                               1.0,
+		AccessLogSampleRate:                       100,
+		FileMaxBytes:                              100 * 1024 * 1024,
+		BatchMaxConcurrentRequests:                10,
+		UsageRetention:                            24 * time.Hour,
+		EmbeddingDim:                              384,
+		ImageTokenCount:                           576,
 	}
 }
 
@@ -310,7 +918,11 @@ func (c *Configuration) load(configFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read configuration file: %s", err)
 	}
+	return c.loadData(configBytes)
+}
 
+// loadData loads the configuration from YAML or JSON data
+func (c *Configuration) loadData(configBytes []byte) error {
 	if err := yaml.Unmarshal(configBytes, &c); err != nil {
 		return fmt.Errorf("failed to unmarshal configuration: %s", err)
 	}
@@ -325,9 +937,12 @@ func (c *Configuration) load(configFile string) error {
 	return nil
 }
 
+// validate checks the configuration and sets the default values that depend on other parameters,
+// returns all the validation errors joined in one error
 func (c *Configuration) validate() error {
+	var errs []error
 	if c.Model == "" {
-		return errors.New("model parameter is empty")
+		errs = append(errs, errors.New("model parameter is empty"))
 	}
 	// Upstream vLLM behaviour: when --served-model-name is not provided,
 	// it falls back to using the value of --model as the single public name
@@ -337,175 +952,513 @@ func (c *Configuration) validate() error {
 	}
 
 	if c.Mode != ModeEcho && c.Mode != ModeRandom {
-		return fmt.Errorf("invalid mode '%s', valid values are 'random' and 'echo'", c.Mode)
+		errs = append(errs, fmt.Errorf("invalid mode '%s', valid values are 'random' and 'echo'", c.Mode))
 	}
 	if c.Port <= 0 {
-		return fmt.Errorf("invalid port '%d'", c.Port)
+		errs = append(errs, fmt.Errorf("invalid port '%d'", c.Port))
+	}
+	if c.GRPCPort < 0 {
+		errs = append(errs, fmt.Errorf("invalid grpc port '%d'", c.GRPCPort))
+	}
+	// with data parallel the ranks use the consecutive ports, the http and grpc ports of the ranks must differ
+	if ranks := max(c.DPSize, 1); c.GRPCPort > 0 && c.GRPCPort > c.Port-ranks && c.GRPCPort < c.Port+ranks {
+		errs = append(errs, errors.New("grpc port cannot be one of the ports of the http servers"))
 	}
 	if c.InterTokenLatency < 0 {
-		return errors.New("inter token latency cannot be negative")
+		errs = append(errs, errors.New("inter token latency cannot be negative"))
 	}
 	if c.InterTokenLatencyStdDev < 0 {
-		return errors.New("inter token latency standard deviation cannot be negative")
+		errs = append(errs, errors.New("inter token latency standard deviation cannot be negative"))
 	}
 	if float32(c.InterTokenLatencyStdDev) > 0.3*float32(c.InterTokenLatency) {
-		return errors.New("inter token latency standard deviation cannot be more than 30% of inter token latency")
+		errs = append(errs, errors.New("inter token latency standard deviation cannot be more than 30% of inter token latency"))
 	}
 	if c.TimeToFirstToken < 0 {
-		return errors.New("time to first token cannot be negative")
+		errs = append(errs, errors.New("time to first token cannot be negative"))
 	}
 	if c.TimeToFirstTokenStdDev < 0 {
-		return errors.New("time to first token standard deviation cannot be negative")
+		errs = append(errs, errors.New("time to first token standard deviation cannot be negative"))
 	}
 	if float32(c.TimeToFirstTokenStdDev) > 0.3*float32(c.TimeToFirstToken) {
-		return errors.New("time to first token standard deviation cannot be more than 30% of time to first token")
+		errs = append(errs, errors.New("time to first token standard deviation cannot be more than 30% of time to first token"))
 	}
 
 	if c.PrefillOverhead < 0 {
-		return errors.New("prefill overhead cannot be negative")
+		errs = append(errs, errors.New("prefill overhead cannot be negative"))
 	}
 	if c.PrefillTimePerToken < 0 {
-		return errors.New("prefill time per token cannot be negative")
+		errs = append(errs, errors.New("prefill time per token cannot be negative"))
 	}
 	if c.PrefillTimeStdDev < 0 {
-		return errors.New("prefill time standard deviation cannot be negative")
+		errs = append(errs, errors.New("prefill time standard deviation cannot be negative"))
 	}
 	if float32(c.PrefillTimeStdDev) > 0.3*float32(c.PrefillTimePerToken) {
-		return errors.New("prefill time standard deviation cannot be more than 30% of prefill time per token")
+		errs = append(errs, errors.New("prefill time standard deviation cannot be more than 30% of prefill time per token"))
+	}
+	if c.PrefillTimePerImage < 0 {
+		errs = append(errs, errors.New("prefill time per image cannot be negative"))
+	}
+	if c.ImageTokenCount < 0 {
+		errs = append(errs, errors.New("image token count cannot be negative"))
+	}
+	if c.DecodeTimePerSequence < 0 {
+		errs = append(errs, errors.New("decode time per sequence cannot be negative"))
+	}
+	if c.PreemptionRate < 0 || c.PreemptionRate > 100 {
+		errs = append(errs, errors.New("preemption rate should be between 0 and 100"))
 	}
 
 	if c.KVCacheTransferTimePerToken < 0 {
-		return errors.New("kv-cache tranfer time per token cannot be negative")
+		errs = append(errs, errors.New("kv-cache tranfer time per token cannot be negative"))
 	}
 	if c.KVCacheTransferTimeStdDev < 0 {
-		return errors.New("kv-cache tranfer time standard deviation cannot be negative")
+		errs = append(errs, errors.New("kv-cache tranfer time standard deviation cannot be negative"))
 	}
 	if float32(c.KVCacheTransferTimeStdDev) > 0.3*float32(c.KVCacheTransferTimePerToken) {
-		return errors.New("kv-cache tranfer time standard deviation cannot be more than 30% of kv-cache tranfer time")
+		errs = append(errs, errors.New("kv-cache tranfer time standard deviation cannot be more than 30% of kv-cache tranfer time"))
 	}
 
 	if c.KVCacheTransferLatency < 0 {
-		return errors.New("kv-cache tranfer time cannot be negative")
+		errs = append(errs, errors.New("kv-cache tranfer time cannot be negative"))
 	}
 	if c.KVCacheTransferLatencyStdDev < 0 {
-		return errors.New("kv-cache tranfer time standard deviation cannot be negative")
+		errs = append(errs, errors.New("kv-cache tranfer time standard deviation cannot be negative"))
 	}
 	if float32(c.KVCacheTransferLatencyStdDev) > 0.3*float32(c.KVCacheTransferLatency) {
-		return errors.New("kv-cache tranfer standard deviation cannot be more than 30% of kv-cache tranfer")
+		errs = append(errs, errors.New("kv-cache tranfer standard deviation cannot be more than 30% of kv-cache tranfer"))
 	}
 
 	if c.TimeFactorUnderLoad < 1.0 {
-		return errors.New("time factor under load cannot be less than 1.0")
+		errs = append(errs, errors.New("time factor under load cannot be less than 1.0"))
+	}
+	if c.TimeScale <= 0 {
+		errs = append(errs, errors.New("time scale must be greater than 0"))
+	}
+	switch c.DecodeSlowdownModel {
+	case DecodeSlowdownNone, DecodeSlowdownLinear, DecodeSlowdownLog:
+	default:
+		errs = append(errs, fmt.Errorf("invalid decode slowdown model '%s', valid values are: %s, %s, %s",
+			c.DecodeSlowdownModel, DecodeSlowdownNone, DecodeSlowdownLinear, DecodeSlowdownLog))
+	}
+	if c.DecodeSlowdownCoefficient < 0 {
+		errs = append(errs, errors.New("decode slowdown coefficient cannot be negative"))
 	}
 
 	if c.MaxLoras < 1 {
-		return errors.New("max LoRAs cannot be less than 1")
+		errs = append(errs, errors.New("max LoRAs cannot be less than 1"))
 	}
 	if c.MaxCPULoras == 0 {
 		// max CPU LoRAs by default is same as max LoRAs
 		c.MaxCPULoras = c.MaxLoras
 	}
 	if c.MaxCPULoras < c.MaxLoras {
-		return errors.New("max CPU LoRAs cannot be less than max LoRAs")
+		errs = append(errs, errors.New("max CPU LoRAs cannot be less than max LoRAs"))
+	}
+	if c.LoraIdleUnloadAfter < 0 {
+		errs = append(errs, errors.New("LoRA idle unload time cannot be negative"))
+	}
+	if c.LoraLoadLatency < 0 {
+		errs = append(errs, errors.New("LoRA load latency cannot be negative"))
+	}
+	if c.LoraUnloadLatency < 0 {
+		errs = append(errs, errors.New("LoRA unload latency cannot be negative"))
+	}
+	if c.MaxDynamicLoras < 0 {
+		errs = append(errs, errors.New("max dynamic LoRAs cannot be negative"))
+	}
+	if c.LoraLoadFailureRate < 0 || c.LoraLoadFailureRate > 100 {
+		errs = append(errs, errors.New("LoRA load failure rate should be between 0 and 100"))
 	}
 	if c.MaxModelLen < 1 {
-		return errors.New("max model len cannot be less than 1")
+		errs = append(errs, errors.New("max model len cannot be less than 1"))
+	}
+	if c.VisibleContextTokens < 0 {
+		errs = append(errs, errors.New("visible context tokens cannot be negative"))
 	}
 
 	if c.MaxNumSeqs < 1 {
-		return errors.New("max num seqs cannot be less than 1")
+		errs = append(errs, errors.New("max num seqs cannot be less than 1"))
+	}
+	if c.MaxConcurrentPrefills < 0 {
+		errs = append(errs, errors.New("max concurrent prefills cannot be negative"))
+	}
+	if c.MaxNumBatchedTokens < 1 {
+		errs = append(errs, errors.New("max num batched tokens cannot be less than 1"))
+	}
+	if c.DatasetMaxMemoryBytes < 0 {
+		errs = append(errs, errors.New("dataset max memory bytes cannot be negative"))
+	}
+	if c.ReplaySpeed <= 0 {
+		errs = append(errs, errors.New("replay speed must be positive"))
+	}
+	if c.RecordTrace != "" && c.RecordTrace == c.ReplayFile {
+		errs = append(errs, errors.New("record trace file cannot be the replay file"))
+	}
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 100 {
+		errs = append(errs, errors.New("access log sample rate should be between 0 and 100"))
+	}
+	if c.FileMaxBytes < 1 {
+		errs = append(errs, errors.New("file max bytes cannot be less than 1"))
+	}
+	if c.BatchStartDelay < 0 {
+		errs = append(errs, errors.New("batch start delay cannot be negative"))
+	}
+	if c.BatchMaxConcurrentRequests < 1 {
+		errs = append(errs, errors.New("batch max concurrent requests cannot be less than 1"))
+	}
+	if c.UsageRetention < time.Minute {
+		errs = append(errs, errors.New("usage retention must be at least one minute"))
+	}
+	if c.EmbeddingDim <= 0 {
+		errs = append(errs, errors.New("embedding dimension must be positive"))
+	}
+	if c.UploadBandwidthBytesPerSec < 0 {
+		errs = append(errs, errors.New("upload bandwidth cannot be negative"))
+	}
+
+	modelNames := map[string]bool{c.Model: true}
+	servedModelNames := map[string]bool{}
+	for _, name := range c.ServedModelNames {
+		servedModelNames[name] = true
+	}
+	for i := range c.AdditionalModels {
+		model := &c.AdditionalModels[i]
+		if model.Name == "" {
+			errs = append(errs, errors.New("empty additional model name"))
+			continue
+		}
+		if modelNames[model.Name] {
+			errs = append(errs, fmt.Errorf("model '%s' is defined more than once", model.Name))
+		}
+		modelNames[model.Name] = true
+		if len(model.ServedModelNames) == 0 {
+			model.ServedModelNames = []string{model.Name}
+		}
+		for _, name := range model.ServedModelNames {
+			if servedModelNames[name] {
+				errs = append(errs, fmt.Errorf("served model name '%s' is used by more than one model", name))
+			}
+			servedModelNames[name] = true
+		}
+		errs = append(errs, validateLatencyProfile(c.GetLatencyProfile(model.Name), "model '"+model.Name+"'")...)
+		if model.MaxModelLen != nil && *model.MaxModelLen < 1 {
+			errs = append(errs, fmt.Errorf("max model len of model '%s' cannot be less than 1", model.Name))
+		}
 	}
 
 	for _, lora := range c.LoraModules {
 		if lora.Name == "" {
-			return errors.New("empty LoRA name")
+			errs = append(errs, errors.New("empty LoRA name"))
+		}
+		if lora.BaseModelName != "" && !modelNames[lora.BaseModelName] {
+			errs = append(errs, fmt.Errorf("unknown base model '%s' for LoRA '%s'", lora.BaseModelName, lora.Name))
 		}
-		if lora.BaseModelName != "" && lora.BaseModelName != c.Model {
-			return fmt.Errorf("unknown base model '%s' for LoRA '%s'", lora.BaseModelName, lora.Name)
+		errs = append(errs, validateLatencyProfile(c.GetLatencyProfile(lora.Name), "LoRA '"+lora.Name+"'")...)
+		if lora.MaxModelLen != nil && *lora.MaxModelLen < 1 {
+			errs = append(errs, fmt.Errorf("max model len of LoRA '%s' cannot be less than 1", lora.Name))
+		}
+	}
+
+	for name, maxModelLen := range c.ServedModelMaxModelLen {
+		if !servedModelNames[name] && !slices.ContainsFunc(c.LoraModules, func(lora LoraModule) bool { return lora.Name == name }) {
+			errs = append(errs, fmt.Errorf("unknown served model name or LoRA '%s' in served model max model len", name))
+		}
+		if maxModelLen < 1 {
+			errs = append(errs, fmt.Errorf("max model len of '%s' cannot be less than 1", name))
 		}
 	}
 
 	if c.MaxToolCallIntegerParam < c.MinToolCallIntegerParam {
-		return errors.New("MaxToolCallIntegerParam cannot be less than MinToolCallIntegerParam")
+		errs = append(errs, errors.New("MaxToolCallIntegerParam cannot be less than MinToolCallIntegerParam"))
 	}
 	if c.MaxToolCallNumberParam < c.MinToolCallNumberParam {
-		return errors.New("MaxToolCallNumberParam cannot be less than MinToolCallNumberParam")
+		errs = append(errs, errors.New("MaxToolCallNumberParam cannot be less than MinToolCallNumberParam"))
 	}
 	if c.MaxToolCallArrayParamLength < c.MinToolCallArrayParamLength {
-		return errors.New("MaxToolCallArrayParamLength cannot be less than MinToolCallArrayParamLength")
+		errs = append(errs, errors.New("MaxToolCallArrayParamLength cannot be less than MinToolCallArrayParamLength"))
 	}
 	if c.MinToolCallArrayParamLength < 0 {
-		return errors.New("MinToolCallArrayParamLength cannot be negative")
+		errs = append(errs, errors.New("MinToolCallArrayParamLength cannot be negative"))
 	}
 	if c.ToolCallNotRequiredParamProbability < 0 || c.ToolCallNotRequiredParamProbability > 100 {
-		return errors.New("ToolCallNotRequiredParamProbability should be between 0 and 100")
+		errs = append(errs, errors.New("ToolCallNotRequiredParamProbability should be between 0 and 100"))
 	}
 	if c.ObjectToolCallNotRequiredParamProbability < 0 || c.ObjectToolCallNotRequiredParamProbability > 100 {
-		return errors.New("ObjectToolCallNotRequiredParamProbability should be between 0 and 100")
+		errs = append(errs, errors.New("ObjectToolCallNotRequiredParamProbability should be between 0 and 100"))
+	}
+	if c.MaxToolsPerRequest < 1 {
+		errs = append(errs, errors.New("max tools per request should be a positive number"))
+	}
+	if c.MaxToolSchemaDepth < 1 {
+		errs = append(errs, errors.New("max tool schema depth should be a positive number"))
 	}
 
 	if c.TokenBlockSize != 8 && c.TokenBlockSize != 16 && c.TokenBlockSize != 32 &&
 		c.TokenBlockSize != 64 && c.TokenBlockSize != 128 {
-		return errors.New("token block size should be one of the following: 8, 16, 32, 64, 128")
+		errs = append(errs, errors.New("token block size should be one of the following: 8, 16, 32, 64, 128"))
 	}
 
 	if c.KVCacheSize < 0 {
-		return errors.New("KV cache size cannot be negative")
+		errs = append(errs, errors.New("KV cache size cannot be negative"))
+	}
+	if c.EnableMemoryModel && c.KVCacheSize == 0 {
+		errs = append(errs, errors.New("memory model cannot be enabled with an empty KV cache"))
 	}
 	if c.EventBatchSize < 1 {
-		return errors.New("event batch size cannot less than 1")
+		errs = append(errs, errors.New("event batch size cannot less than 1"))
+	}
+	if c.EnableRequestEvents && c.ZMQEndpoint == "" {
+		errs = append(errs, errors.New("request events cannot be enabled without a zmq endpoint"))
+	}
+	if c.KVEventsRecordFile != "" && !c.EnableKVCache {
+		errs = append(errs, errors.New("kv-cache events cannot be recorded without enable-kvcache"))
 	}
 
 	if c.FailureInjectionRate < 0 || c.FailureInjectionRate > 100 {
-		return errors.New("failure injection rate should be between 0 and 100")
+		errs = append(errs, errors.New("failure injection rate should be between 0 and 100"))
+	}
+
+	if c.ContextLengthFailureRate < 0 || c.ContextLengthFailureRate > 100 {
+		errs = append(errs, errors.New("context length failure rate should be between 0 and 100"))
+	}
+	if c.ContextLengthFailureMinPromptTokens < 0 {
+		errs = append(errs, errors.New("context length failure min prompt tokens cannot be negative"))
 	}
 
-	validFailureTypes := map[string]bool{
-		FailureTypeRateLimit:      true,
-		FailureTypeInvalidAPIKey:  true,
-		FailureTypeContextLength:  true,
-		FailureTypeServerError:    true,
-		FailureTypeInvalidRequest: true,
-		FailureTypeModelNotFound:  true,
+	if c.RateLimitRequestsPerMinute < 0 {
+		errs = append(errs, errors.New("rate limit requests per minute cannot be negative"))
 	}
+	if c.RateLimitTokensPerMinute < 0 {
+		errs = append(errs, errors.New("rate limit tokens per minute cannot be negative"))
+	}
+	switch c.RateLimitBy {
+	case RateLimitByModel, RateLimitByAPIKey, RateLimitByModelAndAPIKey:
+	default:
+		errs = append(errs, fmt.Errorf("invalid rate limit key '%s', valid values are: %s, %s, %s", c.RateLimitBy,
+			RateLimitByModel, RateLimitByAPIKey, RateLimitByModelAndAPIKey))
+	}
+
 	for _, failureType := range c.FailureTypes {
-		if !validFailureTypes[failureType] {
-			return fmt.Errorf("invalid failure type '%s', valid types are: %s, %s, %s, %s, %s, %s", failureType,
-				FailureTypeRateLimit, FailureTypeInvalidAPIKey, FailureTypeContextLength,
-				FailureTypeServerError, FailureTypeInvalidRequest, FailureTypeModelNotFound)
+		if !IsValidFailureType(failureType) {
+			errs = append(errs, fmt.Errorf("invalid failure type '%s', valid types are: %s", failureType, ValidFailureTypesString()))
+		}
+	}
+
+	if c.StreamFailureAfterChunks < 0 {
+		errs = append(errs, errors.New("stream failure after chunks cannot be negative"))
+	}
+
+	if c.TokenizerFailureRate < 0 || c.TokenizerFailureRate > 100 {
+		errs = append(errs, errors.New("tokenizer failure rate should be between 0 and 100"))
+	}
+
+	if c.RefusalRate < 0 || c.RefusalRate > 100 {
+		errs = append(errs, errors.New("refusal rate should be between 0 and 100"))
+	}
+	for _, message := range c.RefusalMessages {
+		if message == "" {
+			errs = append(errs, errors.New("refusal messages cannot be empty"))
+		}
+	}
+
+	if c.FlexTierLatencyFactor < 1.0 {
+		errs = append(errs, errors.New("flex tier latency factor cannot be less than 1.0"))
+	}
+	if c.FlexTierAutoRate < 0 || c.FlexTierAutoRate > 100 {
+		errs = append(errs, errors.New("flex tier auto rate should be between 0 and 100"))
+	}
+
+	if c.StreamTokensPerChunk < 1 {
+		errs = append(errs, errors.New("stream tokens per chunk cannot be less than 1"))
+	}
+	if c.StreamFlushInterval < 0 {
+		errs = append(errs, errors.New("stream flush interval cannot be negative"))
+	}
+	if c.NetworkLatency < 0 {
+		errs = append(errs, errors.New("network latency cannot be negative"))
+	}
+	if c.NetworkJitter < 0 {
+		errs = append(errs, errors.New("network jitter cannot be negative"))
+	}
+	if c.MaxStreamDuration < 0 {
+		errs = append(errs, errors.New("max stream duration cannot be negative"))
+	}
+	if c.MaxStreamDurationFinishReason == "" {
+		errs = append(errs, errors.New("max stream duration finish reason cannot be empty"))
+	}
+	if len(c.FinishReasonDistribution) > 0 {
+		sum := 0
+		for reason, share := range c.FinishReasonDistribution {
+			if !slices.Contains(distributionFinishReasons, reason) {
+				errs = append(errs, fmt.Errorf("invalid finish reason in finish reason distribution: %s, the possible values are %s",
+					reason, strings.Join(distributionFinishReasons, ", ")))
+			}
+			if share < 0 {
+				errs = append(errs, fmt.Errorf("the share of finish reason %s cannot be negative", reason))
+			}
+			sum += share
 		}
+		if sum != 100 {
+			errs = append(errs, errors.New("the shares of the finish reason distribution should sum up to 100"))
+		}
+	}
+	if c.DrainTimeout < 0 {
+		errs = append(errs, errors.New("drain timeout cannot be negative"))
+	}
+	if c.StartupDelay < 0 {
+		errs = append(errs, errors.New("startup delay cannot be negative"))
+	}
+	if c.ModelLoadTime < 0 {
+		errs = append(errs, errors.New("model load time cannot be negative"))
+	}
+	if c.ConfigReloadInterval < 0 {
+		errs = append(errs, errors.New("config reload interval cannot be negative"))
+	}
+
+	if c.StarvationThreshold < 0 {
+		errs = append(errs, errors.New("starvation threshold cannot be negative"))
+	}
+	if c.StarvationDuration < 0 {
+		errs = append(errs, errors.New("starvation duration cannot be negative"))
+	}
+	if c.SchedulingPolicy != SchedulingPolicyFCFS && c.SchedulingPolicy != SchedulingPolicyPriority {
+		errs = append(errs, fmt.Errorf("invalid scheduling policy '%s', valid values are '%s' and '%s'",
+			c.SchedulingPolicy, SchedulingPolicyFCFS, SchedulingPolicyPriority))
 	}
 
 	if c.ZMQMaxConnectAttempts > 10 {
-		return errors.New("zmq retries times cannot be more than 10")
+		errs = append(errs, errors.New("zmq retries times cannot be more than 10"))
 	}
 
 	if c.FakeMetrics != nil {
 		if c.FakeMetrics.RunningRequests < 0 || c.FakeMetrics.WaitingRequests < 0 {
-			return errors.New("fake metrics request counters cannot be negative")
+			errs = append(errs, errors.New("fake metrics request counters cannot be negative"))
 		}
 		if c.FakeMetrics.KVCacheUsagePercentage < 0 || c.FakeMetrics.KVCacheUsagePercentage > 1 {
-			return errors.New("fake metrics KV cache usage must be between 0 ans 1")
+			errs = append(errs, errors.New("fake metrics KV cache usage must be between 0 ans 1"))
+		}
+	}
+
+	switch c.MetricsLabelSchema {
+	case MetricsLabelSchemaV0, MetricsLabelSchemaV1:
+	case MetricsLabelSchemaCustom:
+		if len(c.MetricsCustomLabels) == 0 {
+			errs = append(errs, errors.New("metrics custom labels must be set when metrics label schema is custom"))
+		}
+		for metric, labels := range c.MetricsCustomLabels {
+			for _, label := range labels {
+				if key, _, _ := strings.Cut(label, "="); key == "" {
+					errs = append(errs, fmt.Errorf("invalid label '%s' of metric %s in metrics custom labels", label, metric))
+				}
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid metrics label schema '%s', valid values are: %s, %s, %s", c.MetricsLabelSchema,
+			MetricsLabelSchemaV0, MetricsLabelSchemaV1, MetricsLabelSchemaCustom))
+	}
+
+	if c.OTLPEndpoint != "" {
+		if endpoint, err := url.Parse(c.OTLPEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") ||
+			endpoint.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid OTLP endpoint '%s', must be an http or https URL", c.OTLPEndpoint))
+		}
+	}
+
+	if c.ResponseGeneratorURL != "" {
+		if generatorURL, err := url.Parse(c.ResponseGeneratorURL); err != nil ||
+			(generatorURL.Scheme != "http" && generatorURL.Scheme != "https") || generatorURL.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid response generator URL '%s', must be an http or https URL",
+				c.ResponseGeneratorURL))
+		}
+	}
+
+	for _, rule := range c.TemplateKwargsTokenDelta {
+		if rule.Key == "" {
+			errs = append(errs, errors.New("template kwargs token delta rule key cannot be empty"))
+		}
+	}
+
+	if c.ErrorSchema != ErrorSchemaOpenAI && c.ErrorSchema != ErrorSchemaAzure {
+		errs = append(errs, fmt.Errorf("invalid error schema '%s', valid values are: %s, %s", c.ErrorSchema,
+			ErrorSchemaOpenAI, ErrorSchemaAzure))
+	}
+
+	if c.APIFlavor != APIFlavorOpenAI && c.APIFlavor != APIFlavorAzure {
+		errs = append(errs, fmt.Errorf("invalid api flavor '%s', valid values are: %s, %s", c.APIFlavor,
+			APIFlavorOpenAI, APIFlavorAzure))
+	}
+
+	if c.HardwareProfile != "" {
+		if _, ok := GetHardwareProfile(c.HardwareProfile); !ok {
+			errs = append(errs, fmt.Errorf("invalid hardware profile '%s', valid values are: %s", c.HardwareProfile,
+				strings.Join(HardwareProfileNames(), ", ")))
 		}
 	}
 
 	if c.DPSize < 1 || c.DPSize > 8 {
-		return errors.New("data parallel size must be between 1 ans 8")
+		errs = append(errs, errors.New("data parallel size must be between 1 ans 8"))
 	}
 
 	if (c.SSLCertFile == "") != (c.SSLKeyFile == "") {
-		return errors.New("both ssl-certfile and ssl-keyfile must be provided together")
+		errs = append(errs, errors.New("both ssl-certfile and ssl-keyfile must be provided together"))
 	}
 
 	if c.SelfSignedCerts && (c.SSLCertFile != "" || c.SSLKeyFile != "") {
-		return errors.New("cannot use both self-signed-certs and explicit ssl-certfile/ssl-keyfile")
+		errs = append(errs, errors.New("cannot use both self-signed-certs and explicit ssl-certfile/ssl-keyfile"))
 	}
 
 	if c.DatasetPath == "" && c.DatasetURL != "" {
-		return errors.New("dataset-path is required when dataset-url is set")
+		errs = append(errs, errors.New("dataset-path is required when dataset-url is set"))
 	}
 
-	return nil
+	switch c.PDRole {
+	case "", PDRolePrefill, PDRoleDecode:
+	default:
+		errs = append(errs, fmt.Errorf("invalid pd role '%s', valid values are: %s, %s", c.PDRole,
+			PDRolePrefill, PDRoleDecode))
+	}
+	if c.PrefillEndpoint != "" && c.PDRole != PDRoleDecode {
+		errs = append(errs, errors.New("prefill-endpoint requires pd-role decode"))
+	}
+
+	switch c.DatasetFormat {
+	case "", DatasetFormatSQLite, DatasetFormatJSONL:
+	case DatasetFormatParquet:
+		errs = append(errs, errors.New("parquet datasets are not supported, convert the dataset to jsonl or sqlite"))
+	default:
+		errs = append(errs, fmt.Errorf("invalid dataset format '%s', valid values are: %s, %s", c.DatasetFormat,
+			DatasetFormatSQLite, DatasetFormatJSONL))
+	}
+
+	// the configurations of the overridden ranks are validated only if the configuration is valid,
+	// otherwise they would repeat its errors
+	errs = append(errs, c.validateDPRankOverrides(len(errs) == 0)...)
+
+	return errors.Join(errs...)
+}
+
+var validFailureTypes = []string{
+	FailureTypeRateLimit, FailureTypeInvalidAPIKey, FailureTypeContextLength,
+	FailureTypeServerError, FailureTypeInvalidRequest, FailureTypeModelNotFound, FailureTypeMissingDone,
+	FailureTypeMissingContentType, FailureTypeWrongContentType, FailureTypeStreamError, FailureTypeStreamMalformed,
+}
+
+// distributionFinishReasons are the finish reasons of the finish reason distribution
+var distributionFinishReasons = []string{"stop", "length", "content_filter", "tool_calls"}
+
+// IsValidFailureType checks if the given failure type is one of the supported failure types
+func IsValidFailureType(failureType string) bool {
+	return slices.Contains(validFailureTypes, failureType)
+}
+
+// ValidFailureTypes returns the supported failure types
+func ValidFailureTypes() []string {
+	return slices.Clone(validFailureTypes)
+}
+
+// ValidFailureTypesString returns the supported failure types separated by commas
+func ValidFailureTypesString() string {
+	return strings.Join(validFailureTypes, ", ")
 }
 
 // SSLEnabled returns true if SSL is enabled either via certificate files or self-signed certificates
@@ -523,9 +1476,43 @@ func (c *Configuration) Copy() (*Configuration, error) {
 	return &dst, err
 }
 
+// ConfigValidationResult is the error returned by ParseCommandParamsAndLoadConfig with
+// --validate-config-and-exit, the caller prints the result and exits instead of starting the simulator
+type ConfigValidationResult struct {
+	// Err is the validation error, nil if the configuration is valid
+	Err error
+}
+
+func (r *ConfigValidationResult) Error() string {
+	if r.Err == nil {
+		return "configuration is valid"
+	}
+	return r.Err.Error()
+}
+
+func (r *ConfigValidationResult) Unwrap() error {
+	return r.Err
+}
+
+// Print prints the result of the configuration validation, returns the exit code of --validate-config-and-exit
+func (r *ConfigValidationResult) Print(w io.Writer) int {
+	return printValidationResult(w, r.Err)
+}
+
 // ParseCommandParamsAndLoadConfig loads configuration, parses command line parameters, merges the values
-// (command line values overwrite the config file ones), and validates the configuration
+// (command line values overwrite the config file ones), and validates the configuration.
+// With --validate-config-and-exit the validation result is returned as a *ConfigValidationResult error
 func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
+	config, err := parseCommandParamsAndLoadConfig()
+	if values := getParamValueFromArgs(validateConfigAndExitFlag); values != nil {
+		if len(values) == 0 || values[0] == "true" {
+			return nil, &ConfigValidationResult{Err: err}
+		}
+	}
+	return config, err
+}
+
+func parseCommandParamsAndLoadConfig() (*Configuration, error) {
 	config := newConfig()
 
 	configFileValues := getParamValueFromArgs("config")
@@ -533,20 +1520,59 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 		if err := config.load(configFileValues[0]); err != nil {
 			return nil, err
 		}
+		config.ConfigFile = configFileValues[0]
+	}
+
+	// The hardware profile values are applied on top of the defaults, and are overwritten
+	// by the configuration file and the command line values
+	hardwareProfile := config.HardwareProfile
+	if hardwareProfileValues := getParamValueFromArgs("hardware-profile"); len(hardwareProfileValues) == 1 {
+		hardwareProfile = hardwareProfileValues[0]
+	}
+	if profile, ok := GetHardwareProfile(hardwareProfile); ok {
+		config = newConfig()
+		config.applyHardwareProfile(profile)
+		if len(configFileValues) == 1 {
+			if err := config.load(configFileValues[0]); err != nil {
+				return nil, err
+			}
+			config.ConfigFile = configFileValues[0]
+		}
 	}
 
 	servedModelNames := getParamValueFromArgs("served-model-name")
 	loraModuleNames := getParamValueFromArgs("lora-modules")
 	fakeMetrics := getParamValueFromArgs("fake-metrics")
+	metricsCustomLabels := getParamValueFromArgs("metrics-custom-labels")
+	templateKwargsTokenDelta := getParamValueFromArgs("template-kwargs-token-delta")
+	additionalModels := getParamValueFromArgs("additional-models")
+	finishReasonDistribution := getParamValueFromArgs("finish-reason-distribution")
+	servedModelMaxModelLen := getParamValueFromArgs("served-model-max-model-len")
 
 	f := pflag.NewFlagSet("llm-d-inference-sim flags", pflag.ContinueOnError)
 
 	f.IntVar(&config.Port, "port", config.Port, "Port")
+	f.IntVar(&config.GRPCPort, "grpc-port", config.GRPCPort, "Port of the KServe v2 gRPC server, 0 disables the gRPC server")
 	f.StringVar(&config.Model, "model", config.Model, "Currently 'loaded' model")
 	f.IntVar(&config.MaxNumSeqs, "max-num-seqs", config.MaxNumSeqs, "Maximum number of inference requests that could be processed at the same time (parameter to simulate requests waiting queue)")
+	f.IntVar(&config.MaxConcurrentPrefills, "max-concurrent-prefills", config.MaxConcurrentPrefills, "Maximum number of requests in the prefill phase at the same time, 0 means unlimited")
+	f.BoolVar(&config.EnableChunkedPrefill, "enable-chunked-prefill", config.EnableChunkedPrefill, "Prefill the prompts in chunks that are scheduled together with the decoding requests")
+	f.BoolVar(&config.EnableSamplingParams, "enable-sampling-params", config.EnableSamplingParams, "Make the sampling parameters of the requests influence the length and the content of the random responses")
+	f.IntVar(&config.MaxNumBatchedTokens, "max-num-batched-tokens", config.MaxNumBatchedTokens, "Maximum number of tokens processed in a single scheduling step when chunked prefill is enabled")
 	f.IntVar(&config.MaxLoras, "max-loras", config.MaxLoras, "Maximum number of LoRAs in a single batch")
 	f.IntVar(&config.MaxCPULoras, "max-cpu-loras", config.MaxCPULoras, "Maximum number of LoRAs to store in CPU memory")
+	f.DurationVar(&config.LoraIdleUnloadAfter, "lora-idle-unload-after", config.LoraIdleUnloadAfter, "Time after which an idle LoRA adapter is unloaded, e.g. 10m, 0 means never")
+	f.BoolVar(&config.LoraIdleUnloadStatic, "lora-idle-unload-static", config.LoraIdleUnloadStatic, "Unload the idle LoRA adapters from lora-modules as well")
+	f.DurationVar(&config.LoraLoadLatency, "lora-load-latency", config.LoraLoadLatency, "Time it takes to load a LoRA adapter, e.g. 2s")
+	f.DurationVar(&config.LoraUnloadLatency, "lora-unload-latency", config.LoraUnloadLatency, "Time it takes to unload a LoRA adapter, e.g. 500ms")
+	f.IntVar(&config.MaxDynamicLoras, "max-dynamic-loras", config.MaxDynamicLoras, "Maximum number of LoRA adapters loaded by /v1/load_lora_adapter (0 means unlimited)")
+	f.IntVar(&config.LoraLoadFailureRate, "lora-load-failure-rate", config.LoraLoadFailureRate, "Probability (0-100) of failing to load a LoRA adapter because its path is not found")
 	f.IntVar(&config.MaxModelLen, "max-model-len", config.MaxModelLen, "Model's context window, maximum number of tokens in a single request including input and output")
+	f.IntVar(&config.VisibleContextTokens, "visible-context-tokens", config.VisibleContextTokens, "Number of trailing prompt tokens visible to the model, older tokens are dropped (0 means no truncation)")
+
+	f.StringVar(&config.HardwareProfile, "hardware-profile", config.HardwareProfile, fmt.Sprintf("Name of a predefined hardware profile (%s), explicitly set parameters override the profile values",
+		strings.Join(HardwareProfileNames(), ", ")))
+	f.StringVar(&config.LatencyProfile, "latency-profile", config.LatencyProfile, "Path to a YAML or JSON file of latency tables (time-to-first-token by prompt tokens, inter-token-latency by generated tokens), interpolated at runtime")
 
 	f.StringVar(&config.Mode, "mode", config.Mode, "Simulator mode: echo - returns the same text that was sent in the request, for chat completion returns the last message; random - returns random sentence from a bank of pre-defined sentences")
 	f.IntVar(&config.InterTokenLatency, "inter-token-latency", config.InterTokenLatency, "Time to generate one token (in milliseconds)")
@@ -555,8 +1581,12 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 	f.IntVar(&config.PrefillOverhead, "prefill-overhead", config.PrefillOverhead, "Time to prefill in milliseconds. This argument is ignored if <time-to-first-token> is not 0.")
 	f.IntVar(&config.PrefillTimePerToken, "prefill-time-per-token", config.PrefillTimePerToken, "Time to prefill per token (in milliseconds)")
 	f.IntVar(&config.PrefillTimeStdDev, "prefill-time-std-dev", config.PrefillTimeStdDev, "Standard deviation for time to prefill (in milliseconds)")
+	f.IntVar(&config.PrefillTimePerImage, "prefill-time-per-image", config.PrefillTimePerImage, "Time to encode an image of a chat completion request (in milliseconds)")
+	f.IntVar(&config.ImageTokenCount, "image-token-count", config.ImageTokenCount, "Number of prompt tokens of an image of a chat completion request")
 	f.IntVar(&config.KVCacheTransferTimePerToken, "kv-cache-transfer-time-per-token", config.KVCacheTransferTimePerToken, "Time for KV-cache transfer per token from a remote vLLM (in milliseconds)")
 	f.IntVar(&config.KVCacheTransferTimeStdDev, "kv-cache-transfer-time-std-dev", config.KVCacheTransferTimeStdDev, "Standard deviation for time for KV-cache transfer per token from a remote vLLM (in milliseconds)")
+	f.StringVar(&config.PDRole, "pd-role", config.PDRole, "Role in a disaggregated prefill/decode deployment: prefill or decode")
+	f.StringVar(&config.PrefillEndpoint, "prefill-endpoint", config.PrefillEndpoint, "Base URL of the prefill pod that does the prefill of the requests in the decode role")
 
 	f.IntVar(&config.KVCacheTransferLatency, "kv-cache-transfer-latency", config.KVCacheTransferLatency, "Time for KV-cache transfer from a remote vLLM (in milliseconds)")
 	f.IntVar(&config.InterTokenLatencyStdDev, "inter-token-latency-std-dev", config.InterTokenLatencyStdDev, "Standard deviation for time between generated tokens (in milliseconds)")
@@ -564,6 +1594,11 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 	f.IntVar(&config.KVCacheTransferLatencyStdDev, "kv-cache-transfer-latency-std-dev", config.KVCacheTransferLatencyStdDev, "Standard deviation for time for KV-cache transfer from a remote vLLM (in milliseconds)")
 	f.Int64Var(&config.Seed, "seed", config.Seed, "Random seed for operations (if not set, current Unix time in nanoseconds is used)")
 	f.Float64Var(&config.TimeFactorUnderLoad, "time-factor-under-load", config.TimeFactorUnderLoad, "Time factor under load (must be >= 1.0)")
+	f.Float64Var(&config.TimeScale, "time-scale", config.TimeScale, "Factor the simulated delays are divided by to run faster than real time, the metrics report the simulated latencies (must be > 0)")
+	f.StringVar(&config.DecodeSlowdownModel, "decode-slowdown-model", config.DecodeSlowdownModel, "Growth of the inter token latency with the number of generated tokens: none, linear or log")
+	f.Float64Var(&config.DecodeSlowdownCoefficient, "decode-slowdown-coefficient", config.DecodeSlowdownCoefficient, "Growth coefficient of the decode slowdown model (must be >= 0)")
+	f.IntVar(&config.DecodeTimePerSequence, "decode-time-per-sequence", config.DecodeTimePerSequence, "Time each additional concurrently decoding sequence adds to a decode step (in milliseconds)")
+	f.IntVar(&config.PreemptionRate, "preemption-rate", config.PreemptionRate, "Probability (0-100) of preempting a request that starts running at full load")
 
 	f.IntVar(&config.MaxToolCallIntegerParam, "max-tool-call-integer-param", config.MaxToolCallIntegerParam, "Maximum possible value of integer parameters in a tool call")
 	f.IntVar(&config.MinToolCallIntegerParam, "min-tool-call-integer-param", config.MinToolCallIntegerParam, "Minimum possible value of integer parameters in a tool call")
@@ -573,29 +1608,101 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 	f.IntVar(&config.MinToolCallArrayParamLength, "min-tool-call-array-param-length", config.MinToolCallArrayParamLength, "Minimum possible length of array parameters in a tool call")
 	f.IntVar(&config.ToolCallNotRequiredParamProbability, "tool-call-not-required-param-probability", config.ToolCallNotRequiredParamProbability, "Probability to add a parameter, that is not required, in a tool call")
 	f.IntVar(&config.ObjectToolCallNotRequiredParamProbability, "object-tool-call-not-required-field-probability", config.ObjectToolCallNotRequiredParamProbability, "Probability to add a field, that is not required, in an object in a tool call")
+	f.IntVar(&config.MaxToolsPerRequest, "max-tools-per-request", config.MaxToolsPerRequest, "Maximum number of tool definitions in a single request")
+	f.IntVar(&config.MaxToolSchemaDepth, "max-tool-schema-depth", config.MaxToolSchemaDepth, "Maximum nesting depth of a tool's parameters schema")
 
 	f.BoolVar(&config.EnableKVCache, "enable-kvcache", config.EnableKVCache, "Defines if KV cache feature is enabled")
 	f.IntVar(&config.KVCacheSize, "kv-cache-size", config.KVCacheSize, "Maximum number of token blocks in kv cache")
+	f.BoolVar(&config.EnableMemoryModel, "enable-memory-model", config.EnableMemoryModel, "Defines if the GPU memory is simulated as a pool of kv-cache-size blocks held by the running requests")
 	f.IntVar(&config.TokenBlockSize, "block-size", config.TokenBlockSize, "Token block size for contiguous chunks of tokens, possible values: 8,16,32,64,128")
 	f.StringVar(&config.TokenizersCacheDir, "tokenizers-cache-dir", config.TokenizersCacheDir, "Directory for caching tokenizers")
+	f.IntVar(&config.TokenizerFailureRate, "tokenizer-failure-rate", config.TokenizerFailureRate, "Probability (0-100) of failing a tokenization")
+	tokenizerFailureModels := getParamValueFromArgs("tokenizer-failure-models")
+	var dummyTokenizerFailureModels multiString
+	f.Var(&dummyTokenizerFailureModels, "tokenizer-failure-models", "List of models whose tokenization always fails (a list of space-separated strings)")
+	f.Lookup("tokenizer-failure-models").NoOptDefVal = dummy
 	f.StringVar(&config.HashSeed, "hash-seed", config.HashSeed, "Seed for hash generation (if not set, is read from PYTHONHASHSEED environment variable)")
 	f.StringVar(&config.ZMQEndpoint, "zmq-endpoint", config.ZMQEndpoint, "ZMQ address to publish events")
 	f.UintVar(&config.ZMQMaxConnectAttempts, "zmq-max-connect-attempts", config.ZMQMaxConnectAttempts, "Maximum number of times to try ZMQ connect")
 	f.IntVar(&config.EventBatchSize, "event-batch-size", config.EventBatchSize, "Maximum number of kv-cache events to be sent together")
+	f.StringVar(&config.KVEventsRecordFile, "kv-events-record-file", config.KVEventsRecordFile, "Path to a JSONL file to record the published kv-cache event batches in")
+	f.BoolVar(&config.EnableRequestEvents, "enable-request-events", config.EnableRequestEvents, "Publish the request lifecycle events to the ZMQ endpoint")
 	f.IntVar(&config.DPSize, "data-parallel-size", config.DPSize, "Number of ranks to run")
 
 	f.StringVar(&config.DatasetPath, "dataset-path", config.DatasetPath, "Local path to the sqlite db file for response generation from a dataset")
 	f.StringVar(&config.DatasetURL, "dataset-url", config.DatasetURL, "URL to download the sqlite db file for response generation from a dataset")
 	f.BoolVar(&config.DatasetInMemory, "dataset-in-memory", config.DatasetInMemory, "Load the entire dataset into memory for faster access")
+	f.Int64Var(&config.DatasetMaxMemoryBytes, "dataset-max-memory-bytes", config.DatasetMaxMemoryBytes, "Maximum estimated size of a dataset loaded into memory, a larger dataset is used from the file (0 means no limit)")
+	f.StringVar(&config.DatasetFormat, "dataset-format", config.DatasetFormat, "Format of the dataset file: sqlite or jsonl (by default selected by the file extension)")
+	f.StringVar(&config.ResponseGeneratorURL, "response-generator-url", config.ResponseGeneratorURL, "URL of a webhook that generates the response tokens of the requests")
+
+	f.StringVar(&config.ReplayFile, "replay-file", config.ReplayFile, "Path to a JSONL file of requests (offset_ms, endpoint and body) to replay at startup")
+	f.Float64Var(&config.ReplaySpeed, "replay-speed", config.ReplaySpeed, "Speed of the replay, the offsets of the replayed requests are divided by it")
+	f.StringVar(&config.RecordTrace, "record-trace", config.RecordTrace, "Path to a JSONL file to record the incoming completion requests in, in the format of the replay file")
+	f.BoolVar(&config.EnableAccessLog, "enable-access-log", config.EnableAccessLog, "Enables the JSON access log of the HTTP requests")
+	f.StringVar(&config.AccessLogFile, "access-log-file", config.AccessLogFile, "Path to the file the access log is appended to, the standard output if not set")
+	f.IntVar(&config.AccessLogSampleRate, "access-log-sample-rate", config.AccessLogSampleRate, "Probability (0-100) that a request is written to the access log")
+	f.Int64Var(&config.FileMaxBytes, "file-max-bytes", config.FileMaxBytes, "Maximal size of a file uploaded to the files API")
+	f.DurationVar(&config.BatchStartDelay, "batch-start-delay", config.BatchStartDelay, "Time a batch of the batch API is validating before its requests start to run, e.g. 1s")
+	f.IntVar(&config.BatchMaxConcurrentRequests, "batch-max-concurrent-requests", config.BatchMaxConcurrentRequests, "Maximal number of requests of a batch that run at the same time")
+	f.DurationVar(&config.UsageRetention, "usage-retention", config.UsageRetention, "Period the usage reported by /v1/usage is kept in memory, e.g. 24h")
+	f.StringVar(&config.UsageExportFile, "usage-export-file", config.UsageExportFile, "Path to a JSON file to export the usage to periodically and at shutdown")
+
+	f.IntVar(&config.EmbeddingDim, "embedding-dim", config.EmbeddingDim, "Number of dimensions of the embeddings returned by /v1/embeddings")
+
+	f.StringVar(&config.MetricsLabelSchema, "metrics-label-schema", config.MetricsLabelSchema, "Label keys attached to the model metrics: v0, v1 or custom")
+	f.StringVar(&config.OTLPEndpoint, "otlp-endpoint", config.OTLPEndpoint, "URL of the OTLP/HTTP endpoint the request traces are exported to, e.g. http://localhost:4318, tracing is disabled if not set")
+
+	f.IntVar(&config.RateLimitRequestsPerMinute, "rate-limit-rpm", config.RateLimitRequestsPerMinute, "Requests per minute allowed for a rate limit key (0 means no limit)")
+	f.IntVar(&config.RateLimitTokensPerMinute, "rate-limit-tpm", config.RateLimitTokensPerMinute, "Tokens per minute allowed for a rate limit key (0 means no limit)")
+	f.StringVar(&config.RateLimitBy, "rate-limit-by", config.RateLimitBy, "Key the rate limits are applied by: model, api-key or model-and-api-key")
 
 	f.IntVar(&config.FailureInjectionRate, "failure-injection-rate", config.FailureInjectionRate, "Probability (0-100) of injecting failures")
 	failureTypes := getParamValueFromArgs("failure-types")
 	var dummyFailureTypes multiString
-	failureTypesDescription := fmt.Sprintf("List of specific failure types to inject (%s, %s, %s, %s, %s, %s)",
-		FailureTypeRateLimit, FailureTypeInvalidAPIKey, FailureTypeContextLength, FailureTypeServerError, FailureTypeInvalidRequest,
-		FailureTypeModelNotFound)
+	failureTypesDescription := fmt.Sprintf("List of specific failure types to inject (%s)", ValidFailureTypesString())
 	f.Var(&dummyFailureTypes, "failure-types", failureTypesDescription)
 	f.Lookup("failure-types").NoOptDefVal = dummy
+	f.IntVar(&config.ContextLengthFailureRate, "context-length-failure-rate", config.ContextLengthFailureRate, "Probability (0-100) of injecting a context_length failure into a request with at least context-length-failure-min-prompt-tokens prompt tokens")
+	f.IntVar(&config.ContextLengthFailureMinPromptTokens, "context-length-failure-min-prompt-tokens", config.ContextLengthFailureMinPromptTokens, "Minimal number of prompt tokens of the requests that may receive a context_length failure")
+	modelNotFoundFailureModels := getParamValueFromArgs("model-not-found-failure-models")
+	var dummyModelNotFoundFailureModels multiString
+	f.Var(&dummyModelNotFoundFailureModels, "model-not-found-failure-models", "List of model names whose requests always receive a model_not_found failure (a list of space-separated strings)")
+	f.Lookup("model-not-found-failure-models").NoOptDefVal = dummy
+	f.BoolVar(&config.RepeatToolCallIDsInChunks, "repeat-tool-call-ids-in-chunks", config.RepeatToolCallIDsInChunks, "Send the tool call id in every chunk of a streamed tool call, not only in the first one")
+	f.IntVar(&config.RefusalRate, "refusal-rate", config.RefusalRate, "Probability (0-100) of refusing a chat completion request")
+	refusalMessages := getParamValueFromArgs("refusal-messages")
+	var dummyRefusalMessages multiString
+	f.Var(&dummyRefusalMessages, "refusal-messages", "List of refusals to choose from (a list of space-separated strings)")
+	f.Lookup("refusal-messages").NoOptDefVal = dummy
+	f.Float64Var(&config.FlexTierLatencyFactor, "flex-tier-latency-factor", config.FlexTierLatencyFactor, "Factor applied to the time to first token and the inter token latency of requests in the flex service tier (must be >= 1.0)")
+	f.IntVar(&config.FlexTierAutoRate, "flex-tier-auto-rate", config.FlexTierAutoRate, "Probability (0-100) of processing a request with service tier auto in the flex service tier")
+	f.BoolVar(&config.ServiceTierMetrics, "service-tier-metrics", config.ServiceTierMetrics, "Report the number of requests processed in each service tier")
+	f.BoolVar(&config.OmitDoneSentinel, "omit-done-sentinel", config.OmitDoneSentinel, "End streaming responses without the data: [DONE] sentinel")
+	f.DurationVar(&config.MaxStreamDuration, "max-stream-duration", config.MaxStreamDuration, "Maximal duration of a response, a longer response is cut at this duration, e.g. 30s, 0 means unlimited")
+	f.StringVar(&config.MaxStreamDurationFinishReason, "max-stream-duration-finish-reason", config.MaxStreamDurationFinishReason, "Finish reason of a response cut at the maximal stream duration")
+	f.DurationVar(&config.DrainTimeout, "drain-timeout", config.DrainTimeout, "Maximal time to wait for the in-flight requests to finish before stopping, e.g. 30s, 0 stops without waiting")
+	f.DurationVar(&config.StartupDelay, "startup-delay", config.StartupDelay, "Time after the start before the model starts loading, the simulator is not ready during it, e.g. 10s")
+	f.DurationVar(&config.ModelLoadTime, "model-load-time", config.ModelLoadTime, "Time to load the model after the startup delay, the simulator is not ready during it, e.g. 30s")
+	f.DurationVar(&config.ConfigReloadInterval, "config-reload-interval", config.ConfigReloadInterval, "Interval between the checks for changes of the configuration file, e.g. 10s, 0 means the file is reloaded only on SIGHUP")
+	f.IntVar(&config.StreamTokensPerChunk, "stream-tokens-per-chunk", config.StreamTokensPerChunk, "Number of tokens of a choice sent together in a chunk of a streaming response")
+	f.DurationVar(&config.StreamFlushInterval, "stream-flush-interval", config.StreamFlushInterval, "Interval the chunks of a streaming response are flushed to the client at, e.g. 100ms, 0 flushes every chunk")
+	f.DurationVar(&config.NetworkLatency, "network-latency", config.NetworkLatency, "Simulated network latency of every response and streamed chunk, e.g. 20ms")
+	f.DurationVar(&config.NetworkJitter, "network-jitter", config.NetworkJitter, "Maximal random deviation of the network latency of every response and streamed chunk, e.g. 5ms")
+	f.BoolVar(&config.EmitChunkTiming, "emit-chunk-timing", config.EmitChunkTiming, "Add the intended cumulative delay of the token, sim_elapsed_ms, to every chunk of a streaming response")
+
+	f.IntVar(&config.StreamFailureAfterChunks, "stream-failure-after-chunks", config.StreamFailureAfterChunks, "Number of token chunks sent before a streaming response is cut by the stream_error or stream_malformed failure")
+	f.BoolVar(&config.AllowInjectionHeaders, "allow-injection-headers", config.AllowInjectionHeaders, "Allow requests to choose the injected failure with the x-sim-inject-failure header")
+	f.DurationVar(&config.StarvationThreshold, "starvation-threshold", config.StarvationThreshold, "Average queue wait of a model above which the model is considered starving, e.g. 5s, 0 disables the starvation detection")
+	f.DurationVar(&config.StarvationDuration, "starvation-duration", config.StarvationDuration, "Time the average queue wait of a model must exceed the starvation threshold before the starvation is reported")
+	f.StringVar(&config.SchedulingPolicy, "scheduling-policy", config.SchedulingPolicy, "The order in which the waiting requests are processed: fcfs - by their arrival order; priority - by the priority field of the requests, a lower value first, and then by their arrival order")
+	f.DurationVar(&config.ClockSkew, "clock-skew", config.ClockSkew, "Skew added to the externally visible timestamps, e.g. 1h or -30s")
+	f.IntVar(&config.UploadBandwidthBytesPerSec, "upload-bandwidth-bytes-per-sec", config.UploadBandwidthBytesPerSec, "Simulated upload bandwidth of the request body in bytes per second, 0 disables the delay")
+	f.StringVar(&config.ErrorSchema, "error-schema", config.ErrorSchema, "Format of the error responses' body: openai or azure")
+	f.StringVar(&config.APIFlavor, "api-flavor", config.APIFlavor, "Format of the completion responses: openai or azure (without the model in the chunks and with the results of the content filters)")
+	f.BoolVar(&config.StrictAccept, "strict-accept", config.StrictAccept, "Reject with 406 requests whose Accept header doesn't allow the response media type")
+	f.BoolVar(&config.EnableAdminAPI, "enable-admin-api", config.EnableAdminAPI, "Enable the admin and debug endpoints")
+	f.BoolVar(&config.EnablePprof, "enable-pprof", config.EnablePprof, "Enable the Go runtime profiling endpoints under /debug/pprof/")
 
 	f.StringVar(&config.SSLCertFile, "ssl-certfile", config.SSLCertFile, "Path to SSL certificate file for HTTPS (optional)")
 	f.StringVar(&config.SSLKeyFile, "ssl-keyfile", config.SSLKeyFile, "Path to SSL private key file for HTTPS (optional)")
@@ -608,10 +1715,22 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 	f.Var(&dummyMultiString, "served-model-name", "Model names exposed by the API (a list of space-separated strings)")
 	f.Var(&dummyMultiString, "lora-modules", "List of LoRA adapters (a list of space-separated JSON strings)")
 	f.Var(&dummyMultiString, "fake-metrics", "A set of metrics to report to Prometheus instead of the real metrics")
+	f.Var(&dummyMultiString, "metrics-custom-labels", "JSON map of metric name to a list of label keys, used with the custom metrics label schema")
+	f.Var(&dummyMultiString, "template-kwargs-token-delta", "JSON list of rules adding prompt tokens to chat requests with matching chat_template_kwargs, e.g. [{\"key\":\"enable_thinking\",\"value\":true,\"extra_tokens\":32}]")
 	// In order to allow empty arguments, we set a dummy NoOptDefVal for these flags
 	f.Lookup("served-model-name").NoOptDefVal = dummy
 	f.Lookup("lora-modules").NoOptDefVal = dummy
 	f.Lookup("fake-metrics").NoOptDefVal = dummy
+	f.Lookup("metrics-custom-labels").NoOptDefVal = dummy
+	f.Lookup("template-kwargs-token-delta").NoOptDefVal = dummy
+	f.Var(&dummyMultiString, "additional-models", "JSON list of base models served in addition to the model, e.g. [{\"name\":\"other-model\",\"served_model_name\":[\"other\"],\"time_to_first_token\":500}]")
+	f.Lookup("additional-models").NoOptDefVal = dummy
+	f.Var(&dummyMultiString, "finish-reason-distribution", "JSON map of finish reason to its share in percents of the random responses, e.g. {\"stop\":70,\"length\":20,\"content_filter\":5,\"tool_calls\":5}")
+	f.Lookup("finish-reason-distribution").NoOptDefVal = dummy
+	f.Var(&dummyMultiString, "served-model-max-model-len", "JSON map of served model name or LoRA to its context window, overriding max-model-len, e.g. {\"other\":8192,\"lora1\":2048}")
+	f.Lookup("served-model-max-model-len").NoOptDefVal = dummy
+	var dummyBool bool
+	f.BoolVar(&dummyBool, validateConfigAndExitFlag, false, "Load, merge and validate the configuration, print all the validation errors and exit")
 
 	flagSet := flag.NewFlagSet("simFlagSet", flag.ExitOnError)
 	klog.InitFlags(flagSet)
@@ -637,12 +1756,46 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 			return nil, err
 		}
 	}
+	if metricsCustomLabels != nil {
+		if err := config.unmarshalMetricsCustomLabels(metricsCustomLabels[0]); err != nil {
+			return nil, err
+		}
+	}
+	if templateKwargsTokenDelta != nil {
+		if err := config.unmarshalTemplateKwargsTokenDelta(templateKwargsTokenDelta[0]); err != nil {
+			return nil, err
+		}
+	}
+	if additionalModels != nil {
+		if err := config.unmarshalAdditionalModels(additionalModels[0]); err != nil {
+			return nil, err
+		}
+	}
+	if finishReasonDistribution != nil {
+		if err := config.unmarshalFinishReasonDistribution(finishReasonDistribution[0]); err != nil {
+			return nil, err
+		}
+	}
+	if servedModelMaxModelLen != nil {
+		if err := config.unmarshalServedModelMaxModelLen(servedModelMaxModelLen[0]); err != nil {
+			return nil, err
+		}
+	}
 	if servedModelNames != nil {
 		config.ServedModelNames = servedModelNames
 	}
 	if failureTypes != nil {
 		config.FailureTypes = failureTypes
 	}
+	if modelNotFoundFailureModels != nil {
+		config.ModelNotFoundFailureModels = modelNotFoundFailureModels
+	}
+	if refusalMessages != nil {
+		config.RefusalMessages = refusalMessages
+	}
+	if tokenizerFailureModels != nil {
+		config.TokenizerFailureModels = tokenizerFailureModels
+	}
 
 	if config.HashSeed == "" {
 		hashSeed := os.Getenv("PYTHONHASHSEED")
@@ -658,6 +1811,289 @@ func ParseCommandParamsAndLoadConfig() (*Configuration, error) {
 	return config, nil
 }
 
+// ValidateConfigData loads a configuration from YAML or JSON data on top of the defaults and validates it,
+// returns the effective configuration and all the validation errors joined in one error.
+// The effective configuration is nil if the data cannot be parsed.
+func ValidateConfigData(data []byte) (*Configuration, error) {
+	config := newConfig()
+	if err := config.loadData(data); err != nil {
+		return nil, err
+	}
+	if profile, ok := GetHardwareProfile(config.HardwareProfile); ok {
+		config = newConfig()
+		config.applyHardwareProfile(profile)
+		if err := config.loadData(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.HashSeed == "" {
+		config.HashSeed = os.Getenv("PYTHONHASHSEED")
+	}
+
+	return config, config.validate()
+}
+
+// runtimeParams are the parameters that can be changed while the simulator is running
+var runtimeParams = []string{
+	"time-to-first-token",
+	"time-to-first-token-std-dev",
+	"inter-token-latency",
+	"inter-token-latency-std-dev",
+	"kv-cache-transfer-latency",
+	"kv-cache-transfer-latency-std-dev",
+	"prefill-overhead",
+	"prefill-time-per-token",
+	"prefill-time-std-dev",
+	"kv-cache-transfer-time-per-token",
+	"kv-cache-transfer-time-std-dev",
+	"time-factor-under-load",
+	"decode-time-per-sequence",
+	"decode-slowdown-model",
+	"decode-slowdown-coefficient",
+	"preemption-rate",
+	"failure-injection-rate",
+	"failure-types",
+	"clock-skew",
+}
+
+// durationParams are the runtime parameters whose values are durations, they can be set as duration
+// strings, e.g. 1h or -30s, or as numbers of nanoseconds
+var durationParams = []string{"clock-skew"}
+
+// parseDurationParams replaces the duration strings of the duration parameters in the given parameters
+// by their numbers of nanoseconds, as they are unmarshalled from JSON
+func parseDurationParams(params map[string]any) error {
+	for _, param := range durationParams {
+		value, ok := params[param].(string)
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of '%s': %w", param, err)
+		}
+		params[param] = int64(duration)
+	}
+	return nil
+}
+
+// RuntimeParams returns the names of the parameters that can be changed while the simulator is running
+func RuntimeParams() []string {
+	return slices.Clone(runtimeParams)
+}
+
+// WithRuntimeUpdate returns a copy of the configuration with the parameters in the given JSON object
+// changed, returns an error if the object contains a parameter that cannot be changed at runtime
+// or if the changed configuration is invalid
+func (c *Configuration) WithRuntimeUpdate(data []byte) (*Configuration, error) {
+	var update map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&update); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration update: %w", err)
+	}
+	for param := range update {
+		if !slices.Contains(runtimeParams, param) {
+			return nil, fmt.Errorf("parameter '%s' cannot be changed at runtime, the parameters that can be changed are: %s",
+				param, strings.Join(runtimeParams, ", "))
+		}
+	}
+	if err := parseDurationParams(update); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := c.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, updated); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration update: %w", err)
+	}
+	if err := updated.validate(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// reloadableParams are the parameters that are changed when the configuration file is reloaded: the
+// parameters that can be changed at runtime, the LoRA modules and the fake metrics
+func reloadableParams() []string {
+	return append(slices.Clone(runtimeParams), "lora-modules", "fake-metrics")
+}
+
+// WithReloadedFile returns a copy of the configuration with the parameters that can be reloaded set to
+// their values in the configuration file. The other parameters of the file are ignored, and the parameters
+// that are missing from the file keep their values. Returns an error if the file cannot be read or the
+// changed configuration is invalid
+func (c *Configuration) WithReloadedFile() (*Configuration, error) {
+	if c.ConfigFile == "" {
+		return nil, errors.New("the simulator was started without a configuration file")
+	}
+	configBytes, err := os.ReadFile(c.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %s", err)
+	}
+	var fileParams map[string]any
+	if err := yaml.Unmarshal(configBytes, &fileParams); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal configuration: %s", err)
+	}
+	reloadable := reloadableParams()
+	for param := range fileParams {
+		if !slices.Contains(reloadable, param) {
+			delete(fileParams, param)
+		}
+	}
+	reloadedBytes, err := yaml.Marshal(fileParams)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := c.Copy()
+	if err != nil {
+		return nil, err
+	}
+	_, reloadLoras := fileParams["lora-modules"]
+	_, reloadFakeMetrics := fileParams["fake-metrics"]
+	if reloadFakeMetrics {
+		updated.FakeMetrics = nil
+	}
+	if err := yaml.Unmarshal(reloadedBytes, updated); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal configuration: %s", err)
+	}
+	// the LoRA modules and the fake metrics are parsed only if they were reloaded, the fake metrics
+	// from the command line are parsed from JSON and have no LoRA strings to parse again
+	if reloadLoras {
+		if err := updated.unmarshalLoras(); err != nil {
+			return nil, err
+		}
+	}
+	if reloadFakeMetrics {
+		if err := updated.unmarshalLoraFakeMetrics(); err != nil {
+			return nil, err
+		}
+	}
+	if err := updated.validate(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// dpRankOverrideParams returns the parameters that can be overridden for a data parallel rank: the
+// parameters that can be changed at runtime and max-num-seqs
+func dpRankOverrideParams() []string {
+	return append(slices.Clone(runtimeParams), "max-num-seqs")
+}
+
+// validateDPRankOverrides validates the ranks and the parameters of the data parallel rank overrides,
+// and the configuration of every overridden rank if validateRanks is set
+func (c *Configuration) validateDPRankOverrides(validateRanks bool) []error {
+	if len(c.DPRankOverrides) == 0 {
+		return nil
+	}
+	if c.DPSize < 2 {
+		return []error{errors.New("data parallel rank overrides require data parallel size greater than 1")}
+	}
+	errs := make([]error, 0)
+	overrideParams := dpRankOverrideParams()
+	for _, rank := range slices.Sorted(maps.Keys(c.DPRankOverrides)) {
+		if rank < 0 || rank >= c.DPSize {
+			errs = append(errs, fmt.Errorf("data parallel rank override for rank %d, the rank should be between 0 and %d",
+				rank, c.DPSize-1))
+			continue
+		}
+		valid := true
+		for param := range c.DPRankOverrides[rank] {
+			if !slices.Contains(overrideParams, param) {
+				errs = append(errs, fmt.Errorf("parameter '%s' cannot be overridden for data parallel rank %d, the parameters that can be overridden are: %s",
+					param, rank, strings.Join(overrideParams, ", ")))
+				valid = false
+			}
+		}
+		if !valid || !validateRanks {
+			continue
+		}
+		rankConfig, err := c.Copy()
+		if err == nil {
+			rankConfig.DPRankOverrides = nil
+			err = rankConfig.applyDPRankOverrides(c.DPRankOverrides[rank])
+		}
+		if err == nil {
+			err = rankConfig.validate()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid configuration of data parallel rank %d: %w", rank, err))
+		}
+	}
+	return errs
+}
+
+// WithDPRankOverrides returns a copy of the configuration with the parameters overridden for the given
+// data parallel rank, the copy is the same as the configuration if the rank has no overrides
+func (c *Configuration) WithDPRankOverrides(rank int) (*Configuration, error) {
+	rankConfig, err := c.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if err := rankConfig.applyDPRankOverrides(c.DPRankOverrides[rank]); err != nil {
+		return nil, err
+	}
+	return rankConfig, nil
+}
+
+// applyDPRankOverrides sets the given overridden parameters in the configuration
+func (c *Configuration) applyDPRankOverrides(overrides map[string]any) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	overrides = maps.Clone(overrides)
+	if err := parseDurationParams(overrides); err != nil {
+		return err
+	}
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse the data parallel rank overrides: %w", err)
+	}
+	return nil
+}
+
+// ValidationErrors returns the messages of the errors joined in the given error
+func ValidationErrors(err error) []string {
+	if err == nil {
+		return []string{}
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		messages := make([]string, 0)
+		for _, e := range joined.Unwrap() {
+			messages = append(messages, ValidationErrors(e)...)
+		}
+		return messages
+	}
+	return []string{err.Error()}
+}
+
+// printValidationResult prints the result of the configuration validation,
+// returns the exit code of --validate-config-and-exit
+func printValidationResult(w io.Writer, err error) int {
+	if err == nil {
+		_, _ = fmt.Fprintln(w, "Configuration is valid")
+		return 0
+	}
+	messages := ValidationErrors(err)
+	_, _ = fmt.Fprintf(w, "Configuration is invalid, found %d error(s):\n", len(messages))
+	for _, message := range messages {
+		_, _ = fmt.Fprintf(w, "  - %s\n", message)
+	}
+	return 1
+}
+
 func getParamValueFromArgs(param string) []string {
 	var values []string
 	var readValues bool
@@ -17,7 +17,11 @@ limitations under the License.
 package common
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -81,6 +85,7 @@ var _ = Describe("Simulator configuration", func() {
 	c.Port = 8001
 	c.ServedModelNames = []string{"model1", "model2"}
 	c.LoraModules = []LoraModule{{Name: "lora1", Path: "/path/to/lora1"}, {Name: "lora2", Path: "/path/to/lora2"}}
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name:           "config file",
 		args:           []string{"cmd", "--config", "../../manifests/config.yaml"},
@@ -104,6 +109,7 @@ var _ = Describe("Simulator configuration", func() {
 	}
 	c.EventBatchSize = 5
 	c.ZMQMaxConnectAttempts = 1
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name: "config file with command line args",
 		args: []string{"cmd", "--model", model, "--config", "../../manifests/config.yaml", "--port", "8002",
@@ -124,6 +130,7 @@ var _ = Describe("Simulator configuration", func() {
 		"{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}",
 	}
 	c.ZMQMaxConnectAttempts = 0
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name: "config file with command line args with different format",
 		args: []string{"cmd", "--model", model, "--config", "../../manifests/config.yaml", "--port", "8002",
@@ -141,6 +148,7 @@ var _ = Describe("Simulator configuration", func() {
 	c.LoraModulesString = []string{
 		"{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}",
 	}
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name: "config file with command line args with empty string",
 		args: []string{"cmd", "--model", model, "--config", "../../manifests/config.yaml", "--port", "8002",
@@ -156,6 +164,7 @@ var _ = Describe("Simulator configuration", func() {
 	c.Port = 8001
 	c.ServedModelNames = []string{"model1", "model2"}
 	c.LoraModulesString = []string{}
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name:           "config file with command line args with empty string for loras",
 		args:           []string{"cmd", "--config", "../../manifests/config.yaml", "--lora-modules", ""},
@@ -168,6 +177,7 @@ var _ = Describe("Simulator configuration", func() {
 	c.Port = 8001
 	c.ServedModelNames = []string{"model1", "model2"}
 	c.LoraModulesString = []string{}
+	c.ConfigFile = "../../manifests/config.yaml"
 	test = testCase{
 		name:           "config file with command line args with empty parameter for loras",
 		args:           []string{"cmd", "--config", "../../manifests/config.yaml", "--lora-modules"},
@@ -182,6 +192,7 @@ var _ = Describe("Simulator configuration", func() {
 	c.MaxLoras = 1
 	c.MaxCPULoras = 1
 	c.KVCacheTransferLatency = 50
+	c.ConfigFile = "../../manifests/basic-config.yaml"
 	test = testCase{
 		name:           "basic config file with command line args with time to transfer kv-cache",
 		args:           []string{"cmd", "--config", "../../manifests/basic-config.yaml", "--kv-cache-transfer-latency", "50"},
@@ -204,6 +215,7 @@ var _ = Describe("Simulator configuration", func() {
 			"{\"running\":\"lora1,lora3\",\"waiting\":\"\",\"timestamp\":1257894569}",
 		},
 	}
+	c.ConfigFile = "../../manifests/config_with_fake.yaml"
 	test = testCase{
 		name:           "config with fake metrics file",
 		args:           []string{"cmd", "--config", "../../manifests/config_with_fake.yaml"},
@@ -249,6 +261,7 @@ var _ = Describe("Simulator configuration", func() {
 		},
 		LorasString: nil,
 	}
+	c.ConfigFile = "../../manifests/config_with_fake.yaml"
 	test = testCase{
 		name: "metrics from config file and command line",
 		args: []string{"cmd", "--config", "../../manifests/config_with_fake.yaml",
@@ -259,6 +272,113 @@ var _ = Describe("Simulator configuration", func() {
 	}
 	tests = append(tests, test)
 
+	// Negative clock skew
+	c = newConfig()
+	c.Model = model
+	c.ServedModelNames = []string{c.Model}
+	c.MaxCPULoras = 1
+	c.Seed = 100
+	c.ClockSkew = -30 * time.Minute
+	test = testCase{
+		name:           "negative clock skew",
+		args:           []string{"cmd", "--model", model, "--seed", "100", "--clock-skew", "-30m"},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
+	// Chat template kwargs token delta rules
+	c = newConfig()
+	c.Model = model
+	c.ServedModelNames = []string{c.Model}
+	c.MaxCPULoras = 1
+	c.Seed = 100
+	c.TemplateKwargsTokenDelta = []TemplateKwargsTokenDeltaRule{
+		{Key: "enable_thinking", Value: true, ExtraTokens: 32},
+		{Key: "mode", Value: "short", ExtraTokens: -5},
+	}
+	test = testCase{
+		name: "template kwargs token delta",
+		args: []string{"cmd", "--model", model, "--seed", "100", "--template-kwargs-token-delta",
+			`[{"key":"enable_thinking","value":true,"extra_tokens":32},{"key":"mode","value":"short","extra_tokens":-5}]`},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
+	// Finish reason distribution
+	c = newConfig()
+	c.Model = model
+	c.ServedModelNames = []string{c.Model}
+	c.MaxCPULoras = 1
+	c.Seed = 100
+	c.FinishReasonDistribution = map[string]int{"stop": 70, "length": 20, "content_filter": 5, "tool_calls": 5}
+	test = testCase{
+		name: "finish reason distribution",
+		args: []string{"cmd", "--model", model, "--seed", "100", "--finish-reason-distribution",
+			`{"stop":70,"length":20,"content_filter":5,"tool_calls":5}`},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
+	// Hardware profile
+	c = newConfig()
+	c.Model = model
+	c.ServedModelNames = []string{c.Model}
+	c.Seed = 100
+	c.MaxCPULoras = 1
+	c.HardwareProfile = "h100"
+	c.PrefillOverhead = 20
+	c.PrefillTimePerToken = 1
+	c.InterTokenLatency = 8
+	c.KVCacheTransferTimePerToken = 1
+	c.MaxNumSeqs = 256
+	test = testCase{
+		name:           "hardware profile",
+		args:           []string{"cmd", "--model", model, "--seed", "100", "--hardware-profile", "h100"},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
+	// Hardware profile with command line args that override the profile values
+	c = newConfig()
+	c.Model = model
+	c.ServedModelNames = []string{c.Model}
+	c.Seed = 100
+	c.MaxCPULoras = 1
+	c.HardwareProfile = "l4"
+	c.PrefillOverhead = 80
+	c.PrefillTimePerToken = 5
+	c.InterTokenLatency = 25
+	c.KVCacheTransferTimePerToken = 4
+	c.MaxNumSeqs = 10
+	test = testCase{
+		name: "hardware profile with command line args",
+		args: []string{"cmd", "--model", model, "--seed", "100", "--inter-token-latency", "25",
+			"--hardware-profile", "l4", "--max-num-seqs", "10"},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
+	// Hardware profile from the command line with a config file, the config file values override the profile
+	c = createDefaultConfig(qwenModelName)
+	c.ServedModelNames = []string{"model1", "model2"}
+	c.LoraModules = []LoraModule{{Name: "lora1", Path: "/path/to/lora1"}, {Name: "lora2", Path: "/path/to/lora2"}}
+	c.LoraModulesString = []string{
+		"{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}",
+		"{\"name\":\"lora2\",\"path\":\"/path/to/lora2\"}",
+	}
+	c.Port = 8001
+	c.HardwareProfile = "a100-40g"
+	c.PrefillOverhead = 35
+	c.PrefillTimePerToken = 2
+	c.KVCacheTransferTimePerToken = 2
+	c.ConfigFile = "../../manifests/config.yaml"
+	test = testCase{
+		name:           "hardware profile with config file",
+		args:           []string{"cmd", "--config", "../../manifests/config.yaml", "--hardware-profile", "a100-40g"},
+		expectedConfig: c,
+	}
+	tests = append(tests, test)
+
 	for _, test := range tests {
 		When(test.name, func() {
 			It("should create correct configuration", func() {
@@ -279,6 +399,14 @@ var _ = Describe("Simulator configuration", func() {
 			name: "invalid port",
 			args: []string{"cmd", "--port", "-50", "--config", "../../manifests/config.yaml"},
 		},
+		{
+			name: "invalid grpc port",
+			args: []string{"cmd", "--grpc-port", "-1", "--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "grpc port is the http port",
+			args: []string{"cmd", "--port", "8000", "--grpc-port", "8000", "--config", "../../manifests/config.yaml"},
+		},
 		{
 			name: "invalid max-loras",
 			args: []string{"cmd", "--max-loras", "15", "--config", "../../manifests/config.yaml"},
@@ -292,6 +420,20 @@ var _ = Describe("Simulator configuration", func() {
 			args: []string{"cmd", "--config", "../../manifests/config.yaml",
 				"--lora-modules", "[{\"path\":\"/path/to/lora15\"}]"},
 		},
+		{
+			name: "invalid lora time to first token",
+			args: []string{"cmd", "--config", "../../manifests/config.yaml",
+				"--lora-modules", "{\"name\":\"lora1\",\"time_to_first_token\":-1}"},
+		},
+		{
+			name: "invalid lora inter token latency std dev",
+			args: []string{"cmd", "--config", "../../manifests/config.yaml", "--inter-token-latency-std-dev", "100",
+				"--lora-modules", "{\"name\":\"lora1\",\"inter_token_latency\":10}"},
+		},
+		{
+			name: "invalid scheduling-policy",
+			args: []string{"cmd", "--scheduling-policy", "lifo", "--config", "../../manifests/config.yaml"},
+		},
 		{
 			name: "invalid max-model-len",
 			args: []string{"cmd", "--max-model-len", "0", "--config", "../../manifests/config.yaml"},
@@ -360,6 +502,11 @@ var _ = Describe("Simulator configuration", func() {
 			args: []string{"cmd", "--kv-cache-size", "-35",
 				"--config", "../../manifests/config.yaml"},
 		},
+		{
+			name: "memory model with an empty kv cache",
+			args: []string{"cmd", "--enable-memory-model", "--kv-cache-size", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
 		{
 			name: "invalid block-size",
 			args: []string{"cmd", "--block-size", "35",
@@ -370,6 +517,11 @@ var _ = Describe("Simulator configuration", func() {
 			args: []string{"cmd", "--event-batch-size", "-35",
 				"--config", "../../manifests/config.yaml"},
 		},
+		{
+			name: "request events without zmq endpoint",
+			args: []string{"cmd", "--enable-request-events", "--zmq-endpoint", "",
+				"--config", "../../manifests/config.yaml"},
+		},
 		{
 			name: "invalid failure injection rate > 100",
 			args: []string{"cmd", "--model", "test-model", "--failure-injection-rate", "150"},
@@ -378,11 +530,209 @@ var _ = Describe("Simulator configuration", func() {
 			name: "invalid failure injection rate < 0",
 			args: []string{"cmd", "--model", "test-model", "--failure-injection-rate", "-10"},
 		},
+		{
+			name: "invalid context length failure rate > 100",
+			args: []string{"cmd", "--model", "test-model", "--context-length-failure-rate", "101"},
+		},
+		{
+			name: "invalid context length failure min prompt tokens",
+			args: []string{"cmd", "--model", "test-model", "--context-length-failure-min-prompt-tokens", "-1"},
+		},
+		{
+			name: "invalid stream tokens per chunk",
+			args: []string{"cmd", "--model", "test-model", "--stream-tokens-per-chunk", "0"},
+		},
+		{
+			name: "invalid stream flush interval",
+			args: []string{"cmd", "--model", "test-model", "--stream-flush-interval", "-1s"},
+		},
+		{
+			name: "invalid network latency",
+			args: []string{"cmd", "--model", "test-model", "--network-latency", "-1s"},
+		},
+		{
+			name: "invalid network jitter",
+			args: []string{"cmd", "--model", "test-model", "--network-jitter", "-1ms"},
+		},
+		{
+			name: "invalid refusal rate > 100",
+			args: []string{"cmd", "--model", "test-model", "--refusal-rate", "101"},
+		},
+		{
+			name: "invalid refusal rate < 0",
+			args: []string{"cmd", "--model", "test-model", "--refusal-rate", "-1"},
+		},
+		{
+			name: "invalid max concurrent prefills < 0",
+			args: []string{"cmd", "--model", "test-model", "--max-concurrent-prefills", "-1"},
+		},
+		{
+			name: "invalid additional model without a name",
+			args: []string{"cmd", "--model", "test-model", "--additional-models", `[{"served_model_name":["other"]}]`},
+		},
+		{
+			name: "invalid additional model with the name of the model",
+			args: []string{"cmd", "--model", "test-model", "--additional-models", `[{"name":"test-model"}]`},
+		},
+		{
+			name: "invalid served model name of two models",
+			args: []string{"cmd", "--model", "test-model", "--served-model-name", "alias",
+				"--additional-models", `[{"name":"other","served_model_name":["alias"]}]`},
+		},
+		{
+			name: "invalid additional model latencies",
+			args: []string{"cmd", "--model", "test-model", "--additional-models", `[{"name":"other","inter_token_latency":-1}]`},
+		},
+		{
+			name: "invalid lora base model",
+			args: []string{"cmd", "--model", "test-model", "--additional-models", `[{"name":"other"}]`,
+				"--lora-modules", `{"name":"lora1","base_model_name":"unknown"}`},
+		},
+		{
+			name: "invalid additional model max model len",
+			args: []string{"cmd", "--model", "test-model", "--additional-models", `[{"name":"other","max_model_len":0}]`},
+		},
+		{
+			name: "invalid lora max model len",
+			args: []string{"cmd", "--model", "test-model", "--lora-modules", `{"name":"lora1","max_model_len":-1}`},
+		},
+		{
+			name: "invalid served model max model len",
+			args: []string{"cmd", "--model", "test-model", "--served-model-max-model-len", `{"test-model":0}`},
+		},
+		{
+			name: "unknown served model name in served model max model len",
+			args: []string{"cmd", "--model", "test-model", "--served-model-max-model-len", `{"unknown":2048}`},
+		},
+		{
+			name: "invalid otlp endpoint",
+			args: []string{"cmd", "--model", "test-model", "--otlp-endpoint", "localhost:4318"},
+		},
+		{
+			name: "invalid max num batched tokens < 1",
+			args: []string{"cmd", "--model", "test-model", "--max-num-batched-tokens", "0"},
+		},
+		{
+			name: "invalid lora idle unload after < 0",
+			args: []string{"cmd", "--model", "test-model", "--lora-idle-unload-after", "-1s"},
+		},
+		{
+			name: "invalid lora load latency < 0",
+			args: []string{"cmd", "--model", "test-model", "--lora-load-latency", "-1s"},
+		},
+		{
+			name: "invalid max dynamic loras < 0",
+			args: []string{"cmd", "--model", "test-model", "--max-dynamic-loras", "-1"},
+		},
+		{
+			name: "invalid lora load failure rate",
+			args: []string{"cmd", "--model", "test-model", "--lora-load-failure-rate", "101"},
+		},
+		{
+			name: "invalid access log sample rate",
+			args: []string{"cmd", "--model", "test-model", "--access-log-sample-rate", "101"},
+		},
+		{
+			name: "invalid max stream duration < 0",
+			args: []string{"cmd", "--model", "test-model", "--max-stream-duration", "-1s"},
+		},
+		{
+			name: "invalid empty max stream duration finish reason",
+			args: []string{"cmd", "--model", "test-model", "--max-stream-duration-finish-reason", ""},
+		},
+		{
+			name: "invalid flex tier latency factor < 1",
+			args: []string{"cmd", "--model", "test-model", "--flex-tier-latency-factor", "0.5"},
+		},
+		{
+			name: "invalid flex tier auto rate > 100",
+			args: []string{"cmd", "--model", "test-model", "--flex-tier-auto-rate", "101"},
+		},
 		{
 			name: "invalid failure type",
 			args: []string{"cmd", "--model", "test-model", "--failure-injection-rate", "50",
 				"--failure-types", "invalid_type"},
 		},
+		{
+			name: "invalid image token count",
+			args: []string{"cmd", "--model", "test-model", "--image-token-count", "-1"},
+		},
+		{
+			name: "invalid decode time per sequence",
+			args: []string{"cmd", "--model", "test-model", "--decode-time-per-sequence", "-1"},
+		},
+		{
+			name: "invalid decode slowdown model",
+			args: []string{"cmd", "--model", "test-model", "--decode-slowdown-model", "quadratic"},
+		},
+		{
+			name: "invalid decode slowdown coefficient",
+			args: []string{"cmd", "--model", "test-model", "--decode-slowdown-coefficient", "-0.1"},
+		},
+		{
+			name: "invalid response generator url",
+			args: []string{"cmd", "--model", "test-model", "--response-generator-url", "generator:8080"},
+		},
+		{
+			name: "invalid preemption rate",
+			args: []string{"cmd", "--model", "test-model", "--preemption-rate", "101"},
+		},
+		{
+			name: "invalid pd role",
+			args: []string{"cmd", "--model", "test-model", "--pd-role", "both"},
+		},
+		{
+			name: "prefill endpoint without decode role",
+			args: []string{"cmd", "--model", "test-model", "--pd-role", "prefill", "--prefill-endpoint", "http://prefill:8000"},
+		},
+		{
+			name: "invalid rate limit rpm",
+			args: []string{"cmd", "--model", "test-model", "--rate-limit-rpm", "-1"},
+		},
+		{
+			name: "invalid rate limit tpm",
+			args: []string{"cmd", "--model", "test-model", "--rate-limit-tpm", "-1"},
+		},
+		{
+			name: "invalid rate limit key",
+			args: []string{"cmd", "--model", "test-model", "--rate-limit-by", "user"},
+		},
+		{
+			name: "invalid dataset format",
+			args: []string{"cmd", "--model", "test-model", "--dataset-format", "csv"},
+		},
+		{
+			name: "parquet dataset format",
+			args: []string{"cmd", "--model", "test-model", "--dataset-format", "parquet"},
+		},
+		{
+			name: "invalid drain timeout",
+			args: []string{"cmd", "--model", "test-model", "--drain-timeout", "-1s"},
+		},
+		{
+			name: "record trace in the replay file",
+			args: []string{"cmd", "--model", "test-model", "--replay-file", "trace.jsonl", "--record-trace", "trace.jsonl"},
+		},
+		{
+			name: "invalid usage retention",
+			args: []string{"cmd", "--model", "test-model", "--usage-retention", "30s"},
+		},
+		{
+			name: "invalid startup delay",
+			args: []string{"cmd", "--model", "test-model", "--startup-delay", "-1s"},
+		},
+		{
+			name: "invalid model load time",
+			args: []string{"cmd", "--model", "test-model", "--model-load-time", "-1s"},
+		},
+		{
+			name: "invalid config reload interval",
+			args: []string{"cmd", "--model", "test-model", "--config-reload-interval", "-1s"},
+		},
+		{
+			name: "invalid stream failure after chunks",
+			args: []string{"cmd", "--model", "test-model", "--stream-failure-after-chunks", "-1"},
+		},
 		{
 			name: "invalid fake metrics: negative running requests",
 			args: []string{"cmd", "--fake-metrics", "{\"running-requests\":-10,\"waiting-requests\":30,\"kv-cache-usage\":0.4}",
@@ -426,6 +776,11 @@ var _ = Describe("Simulator configuration", func() {
 			args: []string{"cmd", "--kv-cache-transfer-time-std-dev", "-1",
 				"--config", "../../manifests/config.yaml"},
 		},
+		{
+			name: "kv-events-record-file without enable-kvcache",
+			args: []string{"cmd", "--kv-events-record-file", "kv-events.jsonl",
+				"--config", "../../manifests/config.yaml"},
+		},
 		{
 			name: "invalid data-parallel-size",
 			args: []string{"cmd", "--data-parallel-size", "15",
@@ -451,6 +806,107 @@ var _ = Describe("Simulator configuration", func() {
 			args: []string{"cmd", "--time-factor-under-load", "-1",
 				"--config", "../../manifests/config.yaml"},
 		},
+		{
+			name: "invalid time-scale",
+			args: []string{"cmd", "--time-scale", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid visible-context-tokens",
+			args: []string{"cmd", "--visible-context-tokens", "-1",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid metrics-label-schema",
+			args: []string{"cmd", "--metrics-label-schema", "v2",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "custom metrics-label-schema without labels",
+			args: []string{"cmd", "--metrics-label-schema", "custom",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid metrics-custom-labels",
+			args: []string{"cmd", "--metrics-label-schema", "custom",
+				"--metrics-custom-labels", "{\"vllm:num_requests_running\":[\"=0\"]}",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid finish reason in finish-reason-distribution",
+			args: []string{"cmd", "--finish-reason-distribution", "{\"stop\":90,\"abort\":10}",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "finish-reason-distribution that doesn't sum up to 100",
+			args: []string{"cmd", "--finish-reason-distribution", "{\"stop\":70,\"length\":20}",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid max-tools-per-request",
+			args: []string{"cmd", "--max-tools-per-request", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid hardware-profile",
+			args: []string{"cmd", "--hardware-profile", "tpu",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid max-tool-schema-depth",
+			args: []string{"cmd", "--max-tool-schema-depth", "-1",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid template-kwargs-token-delta",
+			args: []string{"cmd", "--template-kwargs-token-delta", `[{"value":true,"extra_tokens":32}]`,
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid dataset-max-memory-bytes",
+			args: []string{"cmd", "--dataset-max-memory-bytes", "-1",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid replay-speed",
+			args: []string{"cmd", "--replay-speed", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid file-max-bytes",
+			args: []string{"cmd", "--file-max-bytes", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid batch-start-delay",
+			args: []string{"cmd", "--batch-start-delay", "-1s",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid batch-max-concurrent-requests",
+			args: []string{"cmd", "--batch-max-concurrent-requests", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid embedding-dim",
+			args: []string{"cmd", "--embedding-dim", "0",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid error-schema",
+			args: []string{"cmd", "--error-schema", "aws",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid api-flavor",
+			args: []string{"cmd", "--api-flavor", "aws",
+				"--config", "../../manifests/config.yaml"},
+		},
+		{
+			name: "invalid upload-bandwidth-bytes-per-sec",
+			args: []string{"cmd", "--upload-bandwidth-bytes-per-sec", "-1",
+				"--config", "../../manifests/config.yaml"},
+		},
 	}
 
 	for _, test := range invalidTests {
@@ -462,3 +918,370 @@ var _ = Describe("Simulator configuration", func() {
 		})
 	}
 })
+
+// invalidConfig is a configuration with three independent problems
+const invalidConfig = `model: "test-model"
+mode: "unknown"
+port: -1
+failure-injection-rate: 150
+`
+
+var _ = Describe("Configuration validation", func() {
+	It("should report all the validation errors in the command line", func() {
+		configFile := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		err := os.WriteFile(configFile, []byte(invalidConfig), 0o600)
+		Expect(err).NotTo(HaveOccurred())
+
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--config", configFile, "--validate-config-and-exit"}
+		_, err = parseCommandParamsAndLoadConfig()
+		Expect(err).To(HaveOccurred())
+
+		var out bytes.Buffer
+		Expect(printValidationResult(&out, err)).To(Equal(1))
+		Expect(out.String()).To(ContainSubstring("found 3 error(s)"))
+		Expect(out.String()).To(ContainSubstring("invalid mode 'unknown'"))
+		Expect(out.String()).To(ContainSubstring("invalid port '-1'"))
+		Expect(out.String()).To(ContainSubstring("failure injection rate should be between 0 and 100"))
+	})
+
+	It("should report a valid configuration in the command line", func() {
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--config", "../../manifests/config.yaml", "--validate-config-and-exit"}
+		_, err := parseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+
+		var out bytes.Buffer
+		Expect(printValidationResult(&out, err)).To(Equal(0))
+		Expect(out.String()).To(Equal("Configuration is valid\n"))
+	})
+
+	It("should return the validation result instead of the configuration", func() {
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--model", model, "--mode", "unknown", "--validate-config-and-exit"}
+		config, err := ParseCommandParamsAndLoadConfig()
+		Expect(config).To(BeNil())
+		var result *ConfigValidationResult
+		Expect(errors.As(err, &result)).To(BeTrue())
+		Expect(result.Err).To(HaveOccurred())
+		var out bytes.Buffer
+		Expect(result.Print(&out)).To(Equal(1))
+		Expect(out.String()).To(ContainSubstring("invalid mode 'unknown'"))
+
+		os.Args = []string{"cmd", "--model", model, "--validate-config-and-exit"}
+		config, err = ParseCommandParamsAndLoadConfig()
+		Expect(config).To(BeNil())
+		Expect(errors.As(err, &result)).To(BeTrue())
+		Expect(result.Err).NotTo(HaveOccurred())
+		out.Reset()
+		Expect(result.Print(&out)).To(Equal(0))
+	})
+
+	It("should return all the validation errors and the effective configuration", func() {
+		config, err := ValidateConfigData([]byte(invalidConfig))
+		Expect(err).To(HaveOccurred())
+		Expect(ValidationErrors(err)).To(HaveLen(3))
+		Expect(config).NotTo(BeNil())
+		Expect(config.Mode).To(Equal("unknown"))
+		// the default values are applied
+		Expect(config.MaxNumSeqs).To(Equal(5))
+		Expect(config.ServedModelNames).To(Equal([]string{model}))
+	})
+
+	It("should fail for unparsable data", func() {
+		config, err := ValidateConfigData([]byte("model: [unterminated"))
+		Expect(err).To(HaveOccurred())
+		Expect(config).To(BeNil())
+		Expect(ValidationErrors(err)).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("LoRA latency profiles", func() {
+	It("should override the latencies of the simulator", func() {
+		config, err := createSimConfig([]string{"cmd", "--model", "base", "--time-to-first-token", "100",
+			"--time-to-first-token-std-dev", "20", "--inter-token-latency", "10",
+			"--lora-modules", `{"name":"fast","time_to_first_token":10,"time_to_first_token_std_dev":0}`,
+			`{"name":"slow","inter_token_latency":50,"inter_token_latency_std_dev":5}`, `{"name":"plain"}`})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(config.GetLatencyProfile("base")).To(Equal(LatencyProfile{TimeToFirstToken: 100,
+			TimeToFirstTokenStdDev: 20, InterTokenLatency: 10}))
+		Expect(config.GetLatencyProfile("plain")).To(Equal(config.GetLatencyProfile("base")))
+		Expect(config.GetLatencyProfile("fast")).To(Equal(LatencyProfile{TimeToFirstToken: 10, InterTokenLatency: 10}))
+		Expect(config.GetLatencyProfile("slow")).To(Equal(LatencyProfile{TimeToFirstToken: 100,
+			TimeToFirstTokenStdDev: 20, InterTokenLatency: 50, InterTokenLatencyStdDev: 5}))
+	})
+})
+
+var _ = Describe("Additional models", func() {
+	It("should parse the additional models from the command line", func() {
+		config, err := createSimConfig([]string{"cmd", "--model", "base", "--time-to-first-token", "100",
+			"--inter-token-latency", "10",
+			"--additional-models", `[{"name":"other","served_model_name":["other-1","other-2"],"time_to_first_token":500},
+				{"name":"plain"}]`,
+			"--lora-modules", `{"name":"other-lora","base_model_name":"other","inter_token_latency":20}`})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(config.AdditionalModels).To(HaveLen(2))
+		Expect(config.AdditionalModels[0].ServedModelNames).To(Equal([]string{"other-1", "other-2"}))
+		// the served model name is the model's name by default
+		Expect(config.AdditionalModels[1].ServedModelNames).To(Equal([]string{"plain"}))
+
+		Expect(config.GetAdditionalModel("other-2")).To(Equal(&config.AdditionalModels[0]))
+		Expect(config.GetAdditionalModel("plain")).To(Equal(&config.AdditionalModels[1]))
+		Expect(config.GetAdditionalModel("base")).To(BeNil())
+
+		Expect(config.GetLatencyProfile("other-1")).To(Equal(LatencyProfile{TimeToFirstToken: 500, InterTokenLatency: 10}))
+		Expect(config.GetLatencyProfile("plain")).To(Equal(config.GetLatencyProfile("base")))
+		// an adapter inherits the latencies of its base model
+		Expect(config.GetLatencyProfile("other-lora")).To(Equal(LatencyProfile{TimeToFirstToken: 500, InterTokenLatency: 20}))
+	})
+
+	It("should load the additional models from the configuration file", func() {
+		config, err := ValidateConfigData([]byte(`
+model: base
+additional-models:
+  - name: other
+    served_model_name: [other-1]
+    inter_token_latency: 30
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.AdditionalModels).To(HaveLen(1))
+		Expect(config.AdditionalModels[0].Name).To(Equal("other"))
+		Expect(config.GetLatencyProfile("other-1").InterTokenLatency).To(Equal(30))
+	})
+
+	It("should resolve the context window of every served model name and LoRA", func() {
+		config, err := createSimConfig([]string{"cmd", "--model", "base", "--served-model-name", "base-1", "base-2",
+			"--max-model-len", "1024",
+			"--additional-models", `[{"name":"other","served_model_name":["other-1","other-2"],"max_model_len":8192}]`,
+			"--lora-modules", `{"name":"base-lora"}`, `{"name":"other-lora","base_model_name":"other"}`,
+			`{"name":"small-lora","base_model_name":"other","max_model_len":512}`,
+			"--served-model-max-model-len", `{"base-2":2048,"other-2":4096}`})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(config.GetMaxModelLen("base-1")).To(Equal(1024))
+		Expect(config.GetMaxModelLen("base-2")).To(Equal(2048))
+		Expect(config.GetMaxModelLen("other-1")).To(Equal(8192))
+		Expect(config.GetMaxModelLen("other-2")).To(Equal(4096))
+		// an adapter inherits the context window of the first served model name of its base model
+		Expect(config.GetMaxModelLen("base-lora")).To(Equal(1024))
+		Expect(config.GetMaxModelLen("other-lora")).To(Equal(8192))
+		Expect(config.GetMaxModelLen("small-lora")).To(Equal(512))
+		Expect(config.GetLargestMaxModelLen()).To(Equal(8192))
+	})
+})
+
+var _ = Describe("Runtime configuration update", func() {
+	var config *Configuration
+	BeforeEach(func() {
+		var err error
+		config, err = ValidateConfigData([]byte(`{"model": "test-model", "time-to-first-token": 100}`))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should change the runtime parameters in a copy of the configuration", func() {
+		updated, err := config.WithRuntimeUpdate([]byte(`{"time-to-first-token": 500, "failure-injection-rate": 20,
+			"failure-types": ["rate_limit"]}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.TimeToFirstToken).To(Equal(500))
+		Expect(updated.FailureInjectionRate).To(Equal(20))
+		Expect(updated.FailureTypes).To(Equal([]string{FailureTypeRateLimit}))
+		Expect(updated.Model).To(Equal(model))
+		// the original configuration is not changed
+		Expect(config.TimeToFirstToken).To(Equal(100))
+		Expect(config.FailureInjectionRate).To(Equal(0))
+	})
+
+	It("should change the clock skew by a duration string or nanoseconds", func() {
+		updated, err := config.WithRuntimeUpdate([]byte(`{"clock-skew": "-30s"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.ClockSkew).To(Equal(-30 * time.Second))
+
+		updated, err = config.WithRuntimeUpdate([]byte(`{"clock-skew": 3600000000000}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.ClockSkew).To(Equal(time.Hour))
+	})
+
+	DescribeTable("should reject an invalid update",
+		func(update string, expectedError string) {
+			updated, err := config.WithRuntimeUpdate([]byte(update))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(expectedError))
+			Expect(updated).To(BeNil())
+			Expect(config.TimeToFirstToken).To(Equal(100))
+		},
+		Entry("parameter that cannot be changed", `{"time-to-first-token": 500, "max-num-seqs": 2}`,
+			"parameter 'max-num-seqs' cannot be changed at runtime"),
+		Entry("invalid value", `{"time-to-first-token": -1}`, "time to first token cannot be negative"),
+		Entry("invalid failure type", `{"failure-types": ["unknown"]}`, "unknown"),
+		Entry("wrong type", `{"time-to-first-token": "fast"}`, "failed to parse the configuration update"),
+		Entry("not an object", `[1, 2]`, "failed to parse the configuration update"),
+		Entry("invalid duration", `{"clock-skew": "soon"}`, "invalid value of 'clock-skew'"),
+	)
+})
+
+var _ = Describe("Configuration file reload", func() {
+	var config *Configuration
+	BeforeEach(func() {
+		var err error
+		config, err = ValidateConfigData([]byte(`{"model": "test-model", "time-to-first-token": 100,
+			"fake-metrics": {"running-requests": 5}}`))
+		Expect(err).NotTo(HaveOccurred())
+		config.ConfigFile = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+	})
+
+	It("should reload only the reloadable parameters from the file", func() {
+		Expect(os.WriteFile(config.ConfigFile, []byte(`
+model: other-model
+max-num-seqs: 2
+time-to-first-token: 500
+failure-injection-rate: 20
+lora-modules:
+  - '{"name":"lora1","path":"/path/to/lora1"}'
+fake-metrics:
+  waiting-requests: 7
+  loras:
+    - '{"running":"lora1","waiting":"","timestamp":1257894567}'
+`), 0o600)).To(Succeed())
+
+		updated, err := config.WithReloadedFile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.TimeToFirstToken).To(Equal(500))
+		Expect(updated.FailureInjectionRate).To(Equal(20))
+		Expect(updated.LoraModules).To(HaveLen(1))
+		Expect(updated.LoraModules[0].Name).To(Equal("lora1"))
+		Expect(updated.FakeMetrics.RunningRequests).To(BeZero())
+		Expect(updated.FakeMetrics.WaitingRequests).To(Equal(int64(7)))
+		Expect(updated.FakeMetrics.LoraMetrics).To(HaveLen(1))
+		// the other parameters of the file are ignored
+		Expect(updated.Model).To(Equal("test-model"))
+		Expect(updated.MaxNumSeqs).To(Equal(config.MaxNumSeqs))
+		// the original configuration is not changed
+		Expect(config.TimeToFirstToken).To(Equal(100))
+		Expect(config.LoraModules).To(BeEmpty())
+	})
+
+	It("should reload the clock skew", func() {
+		Expect(os.WriteFile(config.ConfigFile, []byte("clock-skew: 1h\n"), 0o600)).To(Succeed())
+
+		updated, err := config.WithReloadedFile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.ClockSkew).To(Equal(time.Hour))
+	})
+
+	It("should keep the parameters that are missing from the file", func() {
+		Expect(os.WriteFile(config.ConfigFile, []byte("inter-token-latency: 20\n"), 0o600)).To(Succeed())
+
+		updated, err := config.WithReloadedFile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.InterTokenLatency).To(Equal(20))
+		Expect(updated.TimeToFirstToken).To(Equal(100))
+		Expect(updated.FakeMetrics.RunningRequests).To(Equal(int64(5)))
+	})
+
+	DescribeTable("should reject an invalid file",
+		func(data string, expectedError string) {
+			Expect(os.WriteFile(config.ConfigFile, []byte(data), 0o600)).To(Succeed())
+
+			updated, err := config.WithReloadedFile()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(expectedError))
+			Expect(updated).To(BeNil())
+		},
+		Entry("invalid value", "time-to-first-token: -1\n", "time to first token cannot be negative"),
+		Entry("invalid LoRA", "lora-modules:\n  - 'not json'\n", "invalid character"),
+		Entry("invalid yaml", "time-to-first-token: [\n", "failed to unmarshal configuration"),
+	)
+
+	It("should fail if the file cannot be read", func() {
+		updated, err := config.WithReloadedFile()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("failed to read configuration file"))
+		Expect(updated).To(BeNil())
+	})
+})
+
+var _ = Describe("Template kwargs token delta", func() {
+	config := newConfig()
+	config.TemplateKwargsTokenDelta = []TemplateKwargsTokenDeltaRule{
+		{Key: "enable_thinking", Value: true, ExtraTokens: 32},
+		{Key: "level", Value: 2, ExtraTokens: 10},
+		{Key: "style", Value: "short", ExtraTokens: -5},
+	}
+
+	DescribeTable("should add the tokens of the matching rules",
+		func(kwargs map[string]any, expectedDelta int) {
+			Expect(config.PromptTokensDelta(kwargs)).To(Equal(expectedDelta))
+		},
+		Entry("no kwargs", nil, 0),
+		Entry("matching bool", map[string]any{"enable_thinking": true}, 32),
+		Entry("mismatching bool", map[string]any{"enable_thinking": false}, 0),
+		// numbers in the request are parsed as float64
+		Entry("matching number", map[string]any{"level": float64(2)}, 10),
+		Entry("mismatching number", map[string]any{"level": float64(3)}, 0),
+		Entry("negative delta", map[string]any{"style": "short"}, -5),
+		Entry("several matching rules", map[string]any{"enable_thinking": true, "style": "short"}, 27),
+		Entry("unknown kwargs", map[string]any{"unknown": true}, 0),
+	)
+})
+
+var _ = Describe("Data parallel rank overrides", func() {
+	It("should override the parameters of a rank", func() {
+		config, err := ValidateConfigData([]byte(`
+model: test-model
+data-parallel-size: 3
+time-to-first-token: 100
+max-num-seqs: 8
+data-parallel-rank-overrides:
+  2:
+    time-to-first-token: 400
+    max-num-seqs: 2
+    failure-types: ["rate_limit"]
+    failure-injection-rate: 50
+`))
+		Expect(err).NotTo(HaveOccurred())
+
+		rankConfig, err := config.WithDPRankOverrides(2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rankConfig.TimeToFirstToken).To(Equal(400))
+		Expect(rankConfig.MaxNumSeqs).To(Equal(2))
+		Expect(rankConfig.FailureInjectionRate).To(Equal(50))
+		Expect(rankConfig.FailureTypes).To(Equal([]string{"rate_limit"}))
+		// the other parameters and the configuration are not changed
+		Expect(rankConfig.Model).To(Equal("test-model"))
+		Expect(config.TimeToFirstToken).To(Equal(100))
+		Expect(config.MaxNumSeqs).To(Equal(8))
+
+		rankConfig, err = config.WithDPRankOverrides(1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rankConfig.TimeToFirstToken).To(Equal(100))
+		Expect(rankConfig.MaxNumSeqs).To(Equal(8))
+	})
+
+	DescribeTable("should reject invalid overrides",
+		func(data string, expectedErr string) {
+			_, err := ValidateConfigData([]byte("model: test-model\n" + data))
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("rank out of range", "data-parallel-size: 2\ndata-parallel-rank-overrides:\n  2:\n    max-num-seqs: 2\n",
+			"data parallel rank override for rank 2, the rank should be between 0 and 1"),
+		Entry("parameter that cannot be overridden",
+			"data-parallel-size: 2\ndata-parallel-rank-overrides:\n  1:\n    model: other-model\n",
+			"parameter 'model' cannot be overridden for data parallel rank 1"),
+		Entry("invalid rank configuration",
+			"data-parallel-size: 2\ndata-parallel-rank-overrides:\n  0:\n    time-to-first-token: -1\n",
+			"invalid configuration of data parallel rank 0: time to first token cannot be negative"),
+		Entry("single rank", "data-parallel-rank-overrides:\n  0:\n    max-num-seqs: 2\n",
+			"data parallel rank overrides require data parallel size greater than 1"),
+	)
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sort"
+)
+
+// HardwareProfile is a named set of latency and capacity parameters approximating a hardware class.
+// The time to first token is modeled by the prefill overhead and the prefill time per token.
+type HardwareProfile struct {
+	// PrefillOverhead is the fixed part of the prefill time, in milliseconds
+	PrefillOverhead int `json:"prefill-overhead"`
+	// PrefillTimePerToken is the prefill time per prompt token, in milliseconds
+	PrefillTimePerToken int `json:"prefill-time-per-token"`
+	// InterTokenLatency is the time between generated tokens, in milliseconds
+	InterTokenLatency int `json:"inter-token-latency"`
+	// KVCacheTransferTimePerToken is the time to transfer kv-cache per token in P/D mode, in milliseconds
+	KVCacheTransferTimePerToken int `json:"kv-cache-transfer-time-per-token"`
+	// MaxNumSeqs is the maximum number of requests processed at the same time
+	MaxNumSeqs int `json:"max-num-seqs"`
+}
+
+var hardwareProfiles = map[string]HardwareProfile{
+	"a100-40g": {
+		PrefillOverhead:             35,
+		PrefillTimePerToken:         2,
+		InterTokenLatency:           15,
+		KVCacheTransferTimePerToken: 2,
+		MaxNumSeqs:                  128,
+	},
+	"a100-80g": {
+		PrefillOverhead:             30,
+		PrefillTimePerToken:         2,
+		InterTokenLatency:           12,
+		KVCacheTransferTimePerToken: 2,
+		MaxNumSeqs:                  256,
+	},
+	"h100": {
+		PrefillOverhead:             20,
+		PrefillTimePerToken:         1,
+		InterTokenLatency:           8,
+		KVCacheTransferTimePerToken: 1,
+		MaxNumSeqs:                  256,
+	},
+	"l4": {
+		PrefillOverhead:             80,
+		PrefillTimePerToken:         5,
+		InterTokenLatency:           40,
+		KVCacheTransferTimePerToken: 4,
+		MaxNumSeqs:                  32,
+	},
+}
+
+// GetHardwareProfile returns the hardware profile with the given name
+func GetHardwareProfile(name string) (HardwareProfile, bool) {
+	profile, ok := hardwareProfiles[name]
+	return profile, ok
+}
+
+// HardwareProfileNames returns the sorted names of the available hardware profiles
+func HardwareProfileNames() []string {
+	names := make([]string, 0, len(hardwareProfiles))
+	for name := range hardwareProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyHardwareProfile sets the configuration parameters defined by the profile
+func (c *Configuration) applyHardwareProfile(profile HardwareProfile) {
+	c.PrefillOverhead = profile.PrefillOverhead
+	c.PrefillTimePerToken = profile.PrefillTimePerToken
+	c.InterTokenLatency = profile.InterTokenLatency
+	c.KVCacheTransferTimePerToken = profile.KVCacheTransferTimePerToken
+	c.MaxNumSeqs = profile.MaxNumSeqs
+}
+
+// OverriddenHardwareProfileParams returns the names of the hardware profile parameters whose values
+// were overridden by the configuration file or the command line
+func (c *Configuration) OverriddenHardwareProfileParams() []string {
+	profile, ok := GetHardwareProfile(c.HardwareProfile)
+	if !ok {
+		return nil
+	}
+	params := make([]string, 0)
+	if c.PrefillOverhead != profile.PrefillOverhead {
+		params = append(params, "prefill-overhead")
+	}
+	if c.PrefillTimePerToken != profile.PrefillTimePerToken {
+		params = append(params, "prefill-time-per-token")
+	}
+	if c.InterTokenLatency != profile.InterTokenLatency {
+		params = append(params, "inter-token-latency")
+	}
+	if c.KVCacheTransferTimePerToken != profile.KVCacheTransferTimePerToken {
+		params = append(params, "kv-cache-transfer-time-per-token")
+	}
+	if c.MaxNumSeqs != profile.MaxNumSeqs {
+		params = append(params, "max-num-seqs")
+	}
+	return params
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LatencyPoint is a point of a latency table, the latency in milliseconds at a number of tokens
+type LatencyPoint struct {
+	Tokens  int `yaml:"tokens" json:"tokens"`
+	Latency int `yaml:"latency" json:"latency"`
+}
+
+// LatencyTable is a latency profile loaded from a file, the latencies between its points are
+// interpolated linearly, and the latencies outside of its points are the latencies of the nearest points
+type LatencyTable struct {
+	// TimeToFirstToken maps the number of prompt tokens that are not in the kv cache to the time to first token,
+	// optional
+	TimeToFirstToken []LatencyPoint `yaml:"time-to-first-token" json:"time-to-first-token"`
+	// InterTokenLatency maps the number of tokens generated so far to the time until the next token, optional
+	InterTokenLatency []LatencyPoint `yaml:"inter-token-latency" json:"inter-token-latency"`
+}
+
+// LoadLatencyTable loads a latency table from a YAML or JSON file, returns nil if the path is empty
+func LoadLatencyTable(path string) (*LatencyTable, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latency profile: %w", err)
+	}
+	return ParseLatencyTable(data)
+}
+
+// ParseLatencyTable parses and validates a latency table from YAML or JSON data
+func ParseLatencyTable(data []byte) (*LatencyTable, error) {
+	var table LatencyTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal latency profile: %w", err)
+	}
+	if len(table.TimeToFirstToken) == 0 && len(table.InterTokenLatency) == 0 {
+		return nil, errors.New("latency profile must contain time-to-first-token or inter-token-latency points")
+	}
+	if err := validateLatencyPoints(table.TimeToFirstToken); err != nil {
+		return nil, fmt.Errorf("invalid time-to-first-token points: %w", err)
+	}
+	if err := validateLatencyPoints(table.InterTokenLatency); err != nil {
+		return nil, fmt.Errorf("invalid inter-token-latency points: %w", err)
+	}
+	return &table, nil
+}
+
+// validateLatencyPoints checks that the points are sorted by their number of tokens, without duplicates,
+// and that the numbers of tokens and the latencies are not negative
+func validateLatencyPoints(points []LatencyPoint) error {
+	for i, point := range points {
+		if point.Tokens < 0 || point.Latency < 0 {
+			return fmt.Errorf("tokens and latency cannot be negative, point %d", i)
+		}
+		if i > 0 && point.Tokens <= points[i-1].Tokens {
+			return fmt.Errorf("points must be sorted by tokens without duplicates, point %d", i)
+		}
+	}
+	return nil
+}
+
+// HasTimeToFirstToken returns true if the table defines the time to first token
+func (t *LatencyTable) HasTimeToFirstToken() bool {
+	return t != nil && len(t.TimeToFirstToken) > 0
+}
+
+// HasInterTokenLatency returns true if the table defines the inter token latency
+func (t *LatencyTable) HasInterTokenLatency() bool {
+	return t != nil && len(t.InterTokenLatency) > 0
+}
+
+// GetTimeToFirstToken returns the time to first token of the given number of uncached prompt tokens
+func (t *LatencyTable) GetTimeToFirstToken(nPromptTokens int) int {
+	return interpolateLatency(t.TimeToFirstToken, nPromptTokens)
+}
+
+// GetInterTokenLatency returns the time until the next token after the given number of generated tokens
+func (t *LatencyTable) GetInterTokenLatency(nGeneratedTokens int) int {
+	return interpolateLatency(t.InterTokenLatency, nGeneratedTokens)
+}
+
+// interpolateLatency returns the latency at the given number of tokens, interpolated linearly between
+// the points, the points are not empty
+func interpolateLatency(points []LatencyPoint, tokens int) int {
+	if tokens <= points[0].Tokens {
+		return points[0].Latency
+	}
+	for i := 1; i < len(points); i++ {
+		if tokens <= points[i].Tokens {
+			prev, next := points[i-1], points[i]
+			fraction := float64(tokens-prev.Tokens) / float64(next.Tokens-prev.Tokens)
+			return int(math.Round(float64(prev.Latency) + fraction*float64(next.Latency-prev.Latency)))
+		}
+	}
+	return points[len(points)-1].Latency
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Latency table", func() {
+	It("should load a YAML latency profile", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "profile.yaml")
+		data := `time-to-first-token:
+  - tokens: 0
+    latency: 10
+  - tokens: 1000
+    latency: 110
+inter-token-latency:
+  - tokens: 1
+    latency: 8
+`
+		Expect(os.WriteFile(path, []byte(data), 0o644)).To(Succeed())
+		table, err := LoadLatencyTable(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(table.HasTimeToFirstToken()).To(BeTrue())
+		Expect(table.HasInterTokenLatency()).To(BeTrue())
+		Expect(table.GetTimeToFirstToken(500)).To(Equal(60))
+		Expect(table.GetInterTokenLatency(50)).To(Equal(8))
+	})
+
+	It("should parse a JSON latency profile with a single table", func() {
+		table, err := ParseLatencyTable([]byte(`{"inter-token-latency": [{"tokens": 0, "latency": 10},
+			{"tokens": 3, "latency": 11}]}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(table.HasTimeToFirstToken()).To(BeFalse())
+		// interpolated values are rounded
+		Expect(table.GetInterTokenLatency(1)).To(Equal(10))
+		Expect(table.GetInterTokenLatency(2)).To(Equal(11))
+	})
+
+	It("should return no table for an empty path", func() {
+		table, err := LoadLatencyTable("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(table).To(BeNil())
+		Expect(table.HasTimeToFirstToken()).To(BeFalse())
+		Expect(table.HasInterTokenLatency()).To(BeFalse())
+	})
+
+	DescribeTable("should reject an invalid latency profile",
+		func(data string, errMsg string) {
+			_, err := ParseLatencyTable([]byte(data))
+			Expect(err).To(MatchError(ContainSubstring(errMsg)))
+		},
+		Entry("empty", `{}`, "must contain"),
+		Entry("unsorted", `{"time-to-first-token": [{"tokens": 10, "latency": 1}, {"tokens": 5, "latency": 2}]}`,
+			"sorted"),
+		Entry("duplicate tokens", `{"inter-token-latency": [{"tokens": 1, "latency": 1}, {"tokens": 1, "latency": 2}]}`,
+			"sorted"),
+		Entry("negative latency", `{"inter-token-latency": [{"tokens": 1, "latency": -1}]}`, "negative"),
+		Entry("not a table", `[1, 2]`, "failed to unmarshal"),
+	)
+})
@@ -69,32 +69,109 @@ func NewPublisher(endpoint string, retries uint) (*Publisher, error) {
 	)
 }
 
+// zmqCheckID is used to create a unique monitor address for each ZMQ endpoint check
+var zmqCheckID atomic.Uint64
+
+// CheckZMQEndpoint verifies that a ZMQ PUB socket completes a connection handshake with the given
+// endpoint within the timeout. Connecting a ZMQ socket succeeds even if no one listens at the endpoint,
+// the handshake is detected by monitoring the socket.
+func CheckZMQEndpoint(endpoint string, timeout time.Duration) error {
+	socket, err := zmq.NewSocket(zmq.PUB)
+	if err != nil {
+		return fmt.Errorf("failed to create ZMQ PUB socket: %w", err)
+	}
+	defer func() {
+		_ = socket.SetLinger(0)
+		_ = socket.Close()
+	}()
+
+	monitorAddress := fmt.Sprintf("inproc://zmq-check-%d", zmqCheckID.Add(1))
+	if err := socket.Monitor(monitorAddress, zmq.EVENT_CONNECTED); err != nil {
+		return fmt.Errorf("failed to monitor ZMQ socket: %w", err)
+	}
+	monitor, err := zmq.NewSocket(zmq.PAIR)
+	if err != nil {
+		return fmt.Errorf("failed to create ZMQ monitor socket: %w", err)
+	}
+	defer func() {
+		_ = monitor.Close()
+	}()
+	// the monitor must be connected before the events occur, otherwise they are dropped
+	if err := monitor.Connect(monitorAddress); err != nil {
+		return fmt.Errorf("failed to connect ZMQ monitor socket: %w", err)
+	}
+
+	if err := socket.Connect(endpoint); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", endpoint, err)
+	}
+
+	poller := zmq.NewPoller()
+	poller.Add(monitor, zmq.POLLIN)
+	polled, err := poller.Poll(timeout)
+	if err != nil {
+		return fmt.Errorf("failed to wait for connection to %s: %w", endpoint, err)
+	}
+	if len(polled) == 0 {
+		return fmt.Errorf("no connection to %s within %s", endpoint, timeout)
+	}
+	event, _, _, err := monitor.RecvEvent(0)
+	if err != nil {
+		return fmt.Errorf("failed to receive ZMQ socket event: %w", err)
+	}
+	if event != zmq.EVENT_CONNECTED {
+		return fmt.Errorf("unexpected ZMQ socket event %v while connecting to %s", event, endpoint)
+	}
+	return nil
+}
+
 // PublishEvent publishes a KV cache event batch to the ZMQ topic.
 // topic should include the pod identifier (e.g., "kv.pod1").
 func (p *Publisher) PublishEvent(ctx context.Context, topic string, batch interface{}) error {
 	logger := klog.FromContext(ctx).V(0)
 
-	// Use an encoder configured for struct as array
-	var payload bytes.Buffer
-	enc := msgpack.NewEncoder(&payload)
-	enc.UseArrayEncodedStructs(true)
-	err := enc.Encode(batch)
+	payload, err := EncodeEventBatch(batch)
+	if err != nil {
+		return err
+	}
+	seq, err := p.publishPayload(topic, payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal event batch: %w", err)
+		return err
 	}
 
+	logger.Info("Published event batch", "topic", topic, "seq", seq)
+	return nil
+}
+
+// PublishPayload publishes an encoded event batch to the ZMQ topic, e.g. a recorded batch
+func (p *Publisher) PublishPayload(topic string, payload []byte) error {
+	_, err := p.publishPayload(topic, payload)
+	return err
+}
+
+// publishPayload sends the topic, the next sequence number and the payload, returns the sequence number
+func (p *Publisher) publishPayload(topic string, payload []byte) (uint64, error) {
 	// sequence number for ordering
 	seq := atomic.AddUint64(&p.seqNum, 1)
 	seqBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(seqBytes, seq)
 
 	// send topic, sequence, payload
-	if _, err := p.socket.SendMessage(topic, seqBytes, payload.Bytes()); err != nil {
-		return fmt.Errorf("failed to send message to topic %s: %w", topic, err)
+	if _, err := p.socket.SendMessage(topic, seqBytes, payload); err != nil {
+		return seq, fmt.Errorf("failed to send message to topic %s: %w", topic, err)
 	}
+	return seq, nil
+}
 
-	logger.Info("Published event batch", "topic", topic, "seq", seq)
-	return nil
+// EncodeEventBatch encodes an event batch by msgpack, the structs are encoded as arrays of their fields
+func EncodeEventBatch(batch interface{}) ([]byte, error) {
+	// Use an encoder configured for struct as array
+	var payload bytes.Buffer
+	enc := msgpack.NewEncoder(&payload)
+	enc.UseArrayEncodedStructs(true)
+	if err := enc.Encode(batch); err != nil {
+		return nil, fmt.Errorf("failed to marshal event batch: %w", err)
+	}
+	return payload.Bytes(), nil
 }
 
 // Close closes the publisher and cleans up resources.
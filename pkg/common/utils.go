@@ -19,7 +19,10 @@ package common
 import (
 	"math/rand"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -39,75 +42,146 @@ func ValidateContextWindow(promptTokens int, maxCompletionTokens *int64, maxMode
 }
 
 func RandomNumericString(length int) string {
+	return defaultRandom.NumericString(length)
+}
+
+// Random is a seeded random generator, safe for concurrent use.
+// A nil Random uses the generator initialized by InitRandom.
+type Random struct {
+	mutex     sync.Mutex
+	generator *rand.Rand
+}
+
+// NewRandom creates a random generator with the given seed
+func NewRandom(seed int64) *Random {
+	return &Random{generator: rand.New(rand.NewSource(seed))}
+}
+
+var defaultRandom = NewRandom(0)
+
+func InitRandom(seed int64) {
+	defaultRandom = NewRandom(seed)
+}
+
+// orDefault returns the generator to use, the default generator for a nil Random
+func (r *Random) orDefault() *Random {
+	if r == nil {
+		return defaultRandom
+	}
+	return r
+}
+
+// Int returns an integer between min and max (included)
+func (r *Random) Int(min int, max int) int {
+	r = r.orDefault()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Intn(max-min+1) + min
+}
+
+// FlipCoin returns true or false randomly
+func (r *Random) FlipCoin() bool {
+	return r.Int(0, 1) != 0
+}
+
+// Bool returns true with the given probability, probability is an integer between 0 and 100
+func (r *Random) Bool(probability int) bool {
+	r = r.orDefault()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Float64() < float64(probability)/100
+}
+
+// Float returns a random float64 in the range [min, max)
+func (r *Random) Float(min float64, max float64) float64 {
+	r = r.orDefault()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.Float64()*(max-min) + min
+}
+
+// NormFloat64 returns a normally distributed float64 with the given mean and standard deviation
+func (r *Random) NormFloat64(mean float64, stddev float64) float64 {
+	r = r.orDefault()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generator.NormFloat64()*stddev + mean
+}
+
+// Norm returns a normally distributed int
+// If the generated value differs by more than 70% from mean, the returned
+// value will be 70% of mean
+func (r *Random) Norm(mean int, stddev int) int {
+	if stddev == 0 {
+		return mean
+	}
+	mean_ := float64(mean)
+	value := r.NormFloat64(mean_, float64(stddev))
+	if value < 0.3*mean_ {
+		value = 0.3 * mean_
+	} else if value > 1.7*mean_ {
+		value = 1.7 * mean_
+	}
+	return int(value)
+}
+
+// NumericString returns a random string of digits of the given length
+func (r *Random) NumericString(length int) string {
 	digits := "0123456789"
 	result := make([]byte, length)
 	for i := 0; i < length; i++ {
-		num := RandomInt(0, 9)
+		num := r.Int(0, 9)
 		result[i] = digits[num]
 	}
 	return string(result)
 }
 
-var randomGenerator *rand.Rand
-var randMutex sync.Mutex
-
-func InitRandom(seed int64) {
-	src := rand.NewSource(seed)
-	randomGenerator = rand.New(src)
-	uuid.SetRand(randomGenerator)
+// UUIDString generates a UUID string
+func (r *Random) UUIDString() string {
+	r = r.orDefault()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return uuid.Must(uuid.NewRandomFromReader(r.generator)).String()
 }
 
 // Returns an integer between min and max (included)
 func RandomInt(min int, max int) int {
-	randMutex.Lock()
-	defer randMutex.Unlock()
-	return randomGenerator.Intn(max-min+1) + min
+	return defaultRandom.Int(min, max)
 }
 
 // Returns true or false randomly
 func FlipCoin() bool {
-	return RandomInt(0, 1) != 0
+	return defaultRandom.FlipCoin()
 }
 
 // probability is an integer between 0 and 100
 func RandomBool(probability int) bool {
-	randMutex.Lock()
-	defer randMutex.Unlock()
-	return randomGenerator.Float64() < float64(probability)/100
+	return defaultRandom.Bool(probability)
 }
 
 // Returns a random float64 in the range [min, max)
 func RandomFloat(min float64, max float64) float64 {
-	randMutex.Lock()
-	defer randMutex.Unlock()
-	return randomGenerator.Float64()*(max-min) + min
+	return defaultRandom.Float(min, max)
 }
 
 // Returns a normally distributed int
 // If the generated value differs by more than 70% from mean, the returned
 // value will be 70% of mean
 func RandomNorm(mean int, stddev int) int {
-	if stddev == 0 {
-		return mean
-	}
-	randMutex.Lock()
-	defer randMutex.Unlock()
-	mean_ := float64(mean)
-	stddev_ := float64(stddev)
-	value := randomGenerator.NormFloat64()*stddev_ + mean_
-	if value < 0.3*mean_ {
-		value = 0.3 * mean_
-	} else if value > 1.7*mean_ {
-		value = 1.7 * mean_
-	}
-	return int(value)
+	return defaultRandom.Norm(mean, stddev)
 }
 
 // GenerateUUIDString generates a UUID string under a lock
 func GenerateUUIDString() string {
-	randMutex.Lock()
-	defer randMutex.Unlock()
-	return uuid.NewString()
+	return defaultRandom.UUIDString()
+}
+
+// DPRankSeed returns the random seed of the given data parallel rank, derived from the base seed.
+// Rank 0 uses the base seed, so that it behaves as a single rank run with the same seed.
+func DPRankSeed(baseSeed int64, rank int) int64 {
+	// spread the seeds of consecutive ranks with the 64-bit golden ratio constant
+	const golden = uint64(0x9E3779B97F4A7C15)
+	return int64(uint64(baseSeed) + uint64(rank)*golden)
 }
 
 // Regular expression for the response tokenization
@@ -120,3 +194,92 @@ func init() {
 func Tokenize(text string) []string {
 	return re.FindAllString(text, -1)
 }
+
+// Regular expression for the lossless tokenization, leading spaces and every character that is
+// not matched by the response tokenization (e.g. emoji, CJK text, '|') are tokens as well
+var losslessRe = regexp.MustCompile(`^\s+|(?:\{|\}|:|,|-|\.|\?|\!|;|@|#|\$|%|\^|&|\*|\(|\)|\+|\-|_|~|/|\\|>|<|\[|\]|=|"|\w+|\S)\s*`)
+
+// TokenizeLossless splits the text into tokens like Tokenize, but the concatenation of the tokens
+// always equals the text, every token is a sequence of whole runes
+func TokenizeLossless(text string) []string {
+	return losslessRe.FindAllString(text, -1)
+}
+
+// RuneSafeTokens returns the tokens with the bytes of a UTF-8 sequence that is split between tokens
+// moved to the token in which the sequence ends, so that no token ends in the middle of a rune.
+// The number of tokens is preserved, a token that contains only a part of a rune becomes empty
+func RuneSafeTokens(tokens []string) []string {
+	result := make([]string, len(tokens))
+	carry := ""
+	for i, token := range tokens {
+		token = carry + token
+		cut := incompleteRuneStart(token)
+		if i == len(tokens)-1 {
+			// nothing follows the last token
+			cut = len(token)
+		}
+		result[i], carry = token[:cut], token[cut:]
+	}
+	return result
+}
+
+// incompleteRuneStart returns the index of the incomplete UTF-8 sequence at the end of the text,
+// or the length of the text if the text doesn't end with an incomplete sequence
+func incompleteRuneStart(text string) int {
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRuneInString(text[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(text)
+}
+
+// AcceptsMediaType checks if the given Accept header value accepts the given media type (e.g. application/json).
+// The most specific matching media range (type/subtype, then type/*, then */*) defines the quality value of
+// the media type, a quality value of 0 means the media type is not acceptable. An empty header accepts everything.
+func AcceptsMediaType(accept string, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	mediaTypeMain, _, _ := strings.Cut(mediaType, "/")
+
+	bestSpecificity := -1
+	bestQuality := 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+		if rangeType == "" {
+			continue
+		}
+
+		specificity := -1
+		switch {
+		case rangeType == mediaType:
+			specificity = 2
+		case rangeType == mediaTypeMain+"/*":
+			specificity = 1
+		case rangeType == "*/*":
+			specificity = 0
+		}
+		if specificity <= bestSpecificity {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(key)) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		bestSpecificity = specificity
+		bestQuality = quality
+	}
+
+	return bestQuality > 0
+}
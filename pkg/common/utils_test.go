@@ -17,7 +17,9 @@ limitations under the License.
 package common
 
 import (
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -72,4 +74,96 @@ var _ = Describe("Utils", Ordered, func() {
 		})
 	})
 
+	Context("Random", func() {
+		It("should generate the same sequence for the same seed", func() {
+			first, second := NewRandom(42), NewRandom(42)
+			for range 10 {
+				Expect(first.Int(0, 1000)).To(Equal(second.Int(0, 1000)))
+			}
+			Expect(first.UUIDString()).To(Equal(second.UUIDString()))
+		})
+
+		It("should derive distinct data parallel rank seeds", func() {
+			Expect(DPRankSeed(42, 0)).To(Equal(int64(42)))
+			seeds := map[int64]struct{}{}
+			for rank := range 8 {
+				seeds[DPRankSeed(42, rank)] = struct{}{}
+			}
+			Expect(seeds).To(HaveLen(8))
+			Expect(DPRankSeed(42, 3)).To(Equal(DPRankSeed(42, 3)))
+		})
+	})
+
+	Context("AcceptsMediaType", func() {
+		DescribeTable("should check the accept header",
+			func(accept string, mediaType string, expected bool) {
+				Expect(AcceptsMediaType(accept, mediaType)).To(Equal(expected))
+			},
+			func(accept string, mediaType string, expected bool) string {
+				return "accept: " + accept + ", media type: " + mediaType
+			},
+			Entry(nil, "", "text/event-stream", true),
+			Entry(nil, "text/event-stream", "text/event-stream", true),
+			Entry(nil, "application/json", "text/event-stream", false),
+			Entry(nil, "application/json, text/event-stream", "text/event-stream", true),
+			Entry(nil, "text/*", "text/event-stream", true),
+			Entry(nil, "*/*", "application/json", true),
+			Entry(nil, "Text/Event-Stream", "text/event-stream", true),
+			Entry(nil, "text/event-stream;q=0", "text/event-stream", false),
+			Entry(nil, "text/event-stream; q=0.0, */*", "text/event-stream", false),
+			Entry(nil, "text/*;q=0, */*;q=1", "text/event-stream", false),
+			Entry(nil, "text/*;q=0, text/event-stream;q=0.5", "text/event-stream", true),
+			Entry(nil, "*/*;q=0.1", "text/event-stream", true),
+			Entry(nil, "*/*;q=0", "application/json", false),
+			Entry(nil, "text/event-stream", "application/json", false),
+			Entry(nil, "application/*;q=0.8, text/event-stream", "application/json", true),
+		)
+	})
+
+	Context("TokenizeLossless", func() {
+		DescribeTable("should split the text into tokens of whole runes",
+			func(text string) {
+				tokens := TokenizeLossless(text)
+				Expect(tokens).NotTo(BeEmpty())
+				for _, token := range tokens {
+					Expect(utf8.ValidString(token)).To(BeTrue())
+				}
+				Expect(strings.Join(tokens, "")).To(Equal(text))
+			},
+			Entry("ascii", "Hello, how are you?"),
+			Entry("leading spaces", "  it's a test"),
+			Entry("emoji", "Hi 👋🏽, I ❤️ 🦙s!"),
+			Entry("cjk", "你好，世界！今天天气很好。"),
+			Entry("special tokens", "<|im_start|>user\nHello<|im_end|>\n<|im_start|>assistant\n"),
+		)
+
+		It("should tokenize like Tokenize the text that Tokenize covers", func() {
+			text := "What is the weather in Haifa? {\"unit\": \"C\"}."
+			Expect(TokenizeLossless(text)).To(Equal(Tokenize(text)))
+		})
+	})
+
+	Context("RuneSafeTokens", func() {
+		It("should move a split UTF-8 sequence to the token in which it ends", func() {
+			text := "a👋b你"
+			// split every byte into a token
+			tokens := make([]string, 0, len(text))
+			for i := range len(text) {
+				tokens = append(tokens, text[i:i+1])
+			}
+			safeTokens := RuneSafeTokens(tokens)
+			Expect(safeTokens).To(HaveLen(len(tokens)))
+			for _, token := range safeTokens {
+				Expect(utf8.ValidString(token)).To(BeTrue())
+			}
+			Expect(strings.Join(safeTokens, "")).To(Equal(text))
+			Expect(safeTokens[:8]).To(Equal([]string{"a", "", "", "", "👋", "b", "", ""}))
+			Expect(safeTokens[8]).To(Equal("你"))
+		})
+
+		It("should not change tokens of whole runes", func() {
+			tokens := []string{"你好", "，", "👋🏽 ", "hello"}
+			Expect(RuneSafeTokens(tokens)).To(Equal(tokens))
+		})
+	})
 })
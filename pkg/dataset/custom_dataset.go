@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -40,6 +41,56 @@ type CustomDataset struct {
 	BaseDataset
 	db        *sql.DB
 	hasWarned bool
+	// maxInMemoryBytes is the maximum estimated size of a dataset loaded into memory,
+	// larger datasets are used from the database file, 0 means no limit
+	maxInMemoryBytes int64
+	// inMemoryBatchSize is the number of records copied in one transaction when loading
+	// the dataset into memory, inMemoryLoadBatchSize is used if not set
+	inMemoryBatchSize int
+	// recordsCount is the number of records in the connected database
+	recordsCount int
+	// inMemory is true if the connected database is loaded into memory
+	inMemory bool
+	// format is the format of the dataset file, selected by the file extension if empty
+	format string
+	// the number of responses by the source of their tokens
+	hashHits   atomic.Int64
+	lengthHits atomic.Int64
+	fallbacks  atomic.Int64
+}
+
+// Sources of the tokens of the responses generated by a custom dataset
+const (
+	// SourceHash - the tokens of a record with the hash of the request's prompt
+	SourceHash = "hash"
+	// SourceLength - the tokens of a record with the required number of tokens
+	SourceLength = "length"
+	// SourceFallback - random preset tokens, no matching record was found or the query failed
+	SourceFallback = "fallback"
+)
+
+// Database modes of a custom dataset
+const (
+	DBModeFile     = "file"
+	DBModeInMemory = "in-memory"
+)
+
+// Stats contains the statistics of a custom dataset
+type Stats struct {
+	// Responses is the number of generated responses by the source of their tokens
+	Responses map[string]int64 `json:"responses"`
+	// RecordsCount is the number of records in the database
+	RecordsCount int `json:"records_count"`
+	// DBMode is the mode of the database, file or in-memory
+	DBMode string `json:"db_mode"`
+}
+
+// NewCustomDataset creates a new CustomDataset, maxInMemoryBytes limits the estimated size of
+// a dataset loaded into memory, 0 means no limit, format is the format of the dataset file, if empty
+// it is selected by the file extension, random is the random generator of the responses
+func NewCustomDataset(maxInMemoryBytes int64, format string, random *common.Random) *CustomDataset {
+	return &CustomDataset{BaseDataset: BaseDataset{random: random}, maxInMemoryBytes: maxInMemoryBytes,
+		format: format}
 }
 
 // use constants for expected column names and types
@@ -55,6 +106,10 @@ const (
 	nGenTokensColType          = "INTEGER"
 	progressLogTimeInterval    = 5 * time.Second
 	progressLogPercentInterval = 10
+	// the number of records copied in one transaction when loading the dataset into memory
+	inMemoryLoadBatchSize = 50000
+	// the estimated per record memory overhead of the id and n_gen_tokens columns and the indexes
+	recordOverheadBytes = 64
 )
 
 func (d *CustomDataset) downloadDataset(ctx context.Context, url string, path string) error {
@@ -247,7 +302,12 @@ func (d *CustomDataset) getRecordsCount() (int, error) {
 	return count, nil
 }
 
-func (d *CustomDataset) loadDatabaseInMemory(path string) error {
+// errDatasetExceedsMemoryCap is returned when the dataset doesn't fit in the in-memory size limit
+var errDatasetExceedsMemoryCap = errors.New("dataset exceeds the in-memory size limit")
+
+// loadDatabaseInMemory creates an in-memory database and fills it from the given file with load
+func (d *CustomDataset) loadDatabaseInMemory(ctx context.Context, path string,
+	load func(ctx context.Context, path string) error) error {
 	d.logger.Info("Loading database into memory...")
 	start := time.Now()
 
@@ -257,55 +317,136 @@ func (d *CustomDataset) loadDatabaseInMemory(path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create in-memory database: %w", err)
 	}
+	// every connection to :memory: opens a separate database, use a single connection
+	d.db.SetMaxOpenConns(1)
 
-	// Use ATTACH to copy the database
-	attachSQL := fmt.Sprintf("ATTACH DATABASE '%s' AS source", path)
-	_, err = d.db.Exec(attachSQL)
-	if err != nil {
+	if err := load(ctx, path); err != nil {
 		if closeErr := d.db.Close(); closeErr != nil {
-			d.logger.Error(closeErr, "failed to close in-memory database after attach failure")
+			d.logger.Error(closeErr, "failed to close in-memory database after load failure")
 		}
 		d.db = nil
+		return err
+	}
+
+	loadTime := time.Since(start)
+	d.logger.Info("Database loaded into memory", "load_time", loadTime.String())
+	return nil
+}
+
+// copyDatabase copies the dataset table of the given database file into the in-memory database
+// in batches, each batch is inserted in a separate transaction
+func (d *CustomDataset) copyDatabase(ctx context.Context, path string) error {
+	// Use ATTACH to copy the database
+	attachSQL := fmt.Sprintf("ATTACH DATABASE '%s' AS source", path)
+	if _, err := d.db.ExecContext(ctx, attachSQL); err != nil {
 		return fmt.Errorf("failed to attach source database: %w", err)
 	}
+	defer func() {
+		// Detach the source database
+		if _, err := d.db.Exec("DETACH DATABASE source"); err != nil {
+			d.logger.Error(err, "failed to detach source database")
+		}
+	}()
 
 	// Copy the table structure first
-	_, err = d.db.Exec(`CREATE TABLE llmd (
+	if err := d.createTable(ctx); err != nil {
+		return err
+	}
+
+	var total int64
+	if err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM source."+tableName).Scan(&total); err != nil {
+		return fmt.Errorf("failed to count source records: %w", err)
+	}
+
+	batchSize := d.inMemoryBatchSize
+	if batchSize <= 0 {
+		batchSize = inMemoryLoadBatchSize
+	}
+	progress := loadProgress{total: total, logger: d.logger, startTime: time.Now()}
+	var copied, estimatedBytes, lastID int64
+	for copied < total {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("in-memory database load cancelled: %w", err)
+		}
+
+		// Copy the data
+		result, err := d.db.ExecContext(ctx, "INSERT INTO "+tableName+" SELECT * FROM source."+tableName+
+			" WHERE "+idCol+" > ? ORDER BY "+idCol+" LIMIT ?", lastID, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to copy data: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to copy data: %w", err)
+		}
+		if rows == 0 {
+			break
+		}
+
+		var batchBytes int64
+		err = d.db.QueryRowContext(ctx, "SELECT MAX("+idCol+"), COALESCE(SUM(LENGTH("+promptHashCol+") + LENGTH("+
+			genTokensCol+")), 0) FROM "+tableName+" WHERE "+idCol+" > ?", lastID).Scan(&lastID, &batchBytes)
+		if err != nil {
+			return fmt.Errorf("failed to estimate copied data size: %w", err)
+		}
+		copied += rows
+		estimatedBytes += batchBytes + rows*recordOverheadBytes
+		if d.maxInMemoryBytes > 0 && estimatedBytes > d.maxInMemoryBytes {
+			return fmt.Errorf("%w: more than %d bytes after loading %d of %d records", errDatasetExceedsMemoryCap,
+				d.maxInMemoryBytes, copied, total)
+		}
+		progress.update(copied)
+	}
+
+	var count int64
+	if err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName).Scan(&count); err != nil {
+		return fmt.Errorf("failed to count in-memory records: %w", err)
+	}
+	if count != total {
+		return fmt.Errorf("in-memory database has %d records, source database has %d records", count, total)
+	}
+	return nil
+}
+
+// createTable creates the dataset table in the in-memory database
+func (d *CustomDataset) createTable(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, `CREATE TABLE llmd (
 		id INTEGER PRIMARY KEY,
 		prompt_hash BLOB,
 		gen_tokens JSON,
 		n_gen_tokens INTEGER
 	)`)
 	if err != nil {
-		if closeErr := d.db.Close(); closeErr != nil {
-			d.logger.Error(closeErr, "failed to close in-memory database after create table failure")
-		}
-		d.db = nil
 		return fmt.Errorf("failed to create table: %w", err)
 	}
+	return nil
+}
 
-	// Copy the data
-	_, err = d.db.Exec("INSERT INTO llmd SELECT * FROM source.llmd")
-	if err != nil {
-		if closeErr := d.db.Close(); closeErr != nil {
-			d.logger.Error(closeErr, "failed to close in-memory database after copy failure")
-		}
-		d.db = nil
-		return fmt.Errorf("failed to copy data: %w", err)
-	}
+// loadProgress logs the progress of loading the dataset into memory
+type loadProgress struct {
+	total       int64
+	startTime   time.Time
+	lastPct     int
+	lastLogTime time.Time
+	logger      logr.Logger
+}
 
-	// Detach the source database
-	_, err = d.db.Exec("DETACH DATABASE source")
-	if err != nil {
-		d.logger.Error(err, "failed to detach source database")
+func (lp *loadProgress) update(copied int64) {
+	if lp.total <= 0 {
+		return
+	}
+	pct := int(float64(copied) * 100 / float64(lp.total))
+	now := time.Now()
+	if now.Sub(lp.lastLogTime) >= progressLogTimeInterval || pct-lp.lastPct >= progressLogPercentInterval || pct == 100 {
+		// progress will be shown every interval seconds or every interval percent of progress
+		lp.logger.Info(fmt.Sprintf("Loading progress: %d%%, Records: %d/%d, Elapsed time: %.2fs", pct, copied, lp.total,
+			time.Since(lp.startTime).Seconds()))
+		lp.lastPct = pct
+		lp.lastLogTime = now
 	}
-
-	loadTime := time.Since(start)
-	d.logger.Info("Database loaded into memory", "load_time", loadTime.String())
-	return nil
 }
 
-func (d *CustomDataset) connectToDB(path string, useInMemory bool) error {
+func (d *CustomDataset) connectToDB(ctx context.Context, path string, useInMemory bool) error {
 	if d.db != nil {
 		err := d.db.Close()
 		if err != nil {
@@ -319,12 +460,29 @@ func (d *CustomDataset) connectToDB(path string, useInMemory bool) error {
 		return fmt.Errorf("database file does not exist: %w", err)
 	}
 
-	if useInMemory {
-		err = d.loadDatabaseInMemory(path)
-		if err != nil {
+	format, err := d.fileFormat(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case format == common.DatasetFormatJSONL:
+		// a jsonl file cannot be queried, it is always loaded into memory
+		if err := d.loadDatabaseInMemory(ctx, path, d.copyJSONL); err != nil {
 			return err
 		}
-	} else {
+		useInMemory = true
+	case useInMemory:
+		err = d.loadDatabaseInMemory(ctx, path, d.copyDatabase)
+		if errors.Is(err, errDatasetExceedsMemoryCap) {
+			d.logger.Info("Warning: the dataset is too large to be loaded into memory, using the database file instead",
+				"reason", err.Error())
+			useInMemory = false
+		} else if err != nil {
+			return err
+		}
+	}
+	if !useInMemory {
 		// Use file-based database (original behavior)
 		d.db, err = sql.Open("sqlite3", path)
 		if err != nil {
@@ -353,6 +511,8 @@ func (d *CustomDataset) connectToDB(path string, useInMemory bool) error {
 		return fmt.Errorf("failed to query database: %w", err)
 	}
 
+	d.recordsCount = count
+	d.inMemory = useInMemory
 	if useInMemory {
 		d.logger.Info("In-memory database connected successfully", "path", path, "records count", count)
 	} else {
@@ -369,7 +529,7 @@ func (d *CustomDataset) Init(ctx context.Context, logger logr.Logger, path strin
 	d.hasWarned = false
 	if url == "" {
 		d.logger.Info("Using dataset from", "path", path)
-		return d.connectToDB(path, useInMemory)
+		return d.connectToDB(ctx, path, useInMemory)
 	}
 	_, err := os.Stat(path)
 	if err != nil {
@@ -388,7 +548,7 @@ func (d *CustomDataset) Init(ctx context.Context, logger logr.Logger, path strin
 	}
 	d.logger.Info("Using dataset path", "dataset-path", path)
 
-	return d.connectToDB(path, useInMemory)
+	return d.connectToDB(ctx, path, useInMemory)
 }
 
 func (d *CustomDataset) Close() error {
@@ -434,14 +594,26 @@ func (d *CustomDataset) GetPromptHashHex(hashBytes []byte) string {
 	return hex.EncodeToString(hashBytes)
 }
 
-// GetTokens returns tokens and finishReason for the given request and mode (echo or random)
-func (d *CustomDataset) GetTokens(req openaiserverapi.CompletionRequest, mode string) ([]string, string, error) {
+// GetTokens returns tokens and finishReason for the given request and mode (echo or random),
+// random is the random generator of the request, the generator of the dataset is used if it is nil
+func (d *CustomDataset) GetTokens(req openaiserverapi.CompletionRequest, mode string,
+	random *common.Random) ([]string, string, error) {
 	if mode == common.ModeEcho {
 		return d.echo(req)
 	}
-	nTokensToGen, finishReason := howManyTokensToGen(d.extractMaxTokens(req), req.GetIgnoreEOS())
-	tokens, err := d.GenerateTokens(req, nTokensToGen, finishReason)
-	return tokens, finishReason, err
+	random = d.requestRandom(random)
+	nTokensToGen, finishReason := d.howManyTokensToGen(random, req)
+	// a response that is stopped by the content filter is chosen like a response that ends by stop
+	genFinishReason := finishReason
+	if genFinishReason == ContentFilterFinishReason {
+		genFinishReason = StopFinishReason
+	}
+	tokens, err := d.GenerateTokens(req, nTokensToGen, genFinishReason, random)
+	if err != nil {
+		return nil, "", err
+	}
+	tokens, finishReason = applyStopSequences(tokens, finishReason, req.GetStop(), req.GetMinTokens())
+	return tokens, finishReason, nil
 }
 
 func (d *CustomDataset) query(query string, nTokens int) ([][]string, error) {
@@ -451,7 +623,7 @@ func (d *CustomDataset) query(query string, nTokens int) ([][]string, error) {
 			d.logger.Error(err, "Failed to query database. Ensure dataset file is still valid. Will generate random tokens instead.")
 			d.hasWarned = true
 		}
-		return [][]string{GenPresetRandomTokens(nTokens)}, nil
+		return nil, err
 	}
 	defer func() {
 		if cerr := rows.Close(); cerr != nil {
@@ -461,7 +633,11 @@ func (d *CustomDataset) query(query string, nTokens int) ([][]string, error) {
 	return unmarshalAllRecords(rows)
 }
 
-func (d *CustomDataset) GenerateTokens(req openaiserverapi.CompletionRequest, nTokens int, finishReason string) ([]string, error) {
+// GenerateTokens returns the tokens of a response to the given request from the dataset, random is the
+// random generator of the request, the generator of the dataset is used if it is nil
+func (d *CustomDataset) GenerateTokens(req openaiserverapi.CompletionRequest, nTokens int, finishReason string,
+	random *common.Random) ([]string, error) {
+	random = d.requestRandom(random)
 	// query by prompt hash first
 	promptHash := d.GetPromptHash(req)
 	promptHashHex := d.GetPromptHashHex(promptHash)
@@ -473,33 +649,78 @@ func (d *CustomDataset) GenerateTokens(req openaiserverapi.CompletionRequest, nT
 	if finishReason != LengthFinishReason && finishReason != StopFinishReason {
 		d.logger.Error(errors.New("unknown finish reason"), "Unexpected finish reason", "reason", finishReason)
 	}
+	// the responses with fewer than min_tokens tokens are not used
+	minTokens := int(req.GetMinTokens())
 	for _, tokens := range tokensList {
-		if finishReason == StopFinishReason && len(tokens) <= nTokens {
+		if finishReason == StopFinishReason && len(tokens) <= nTokens && len(tokens) >= minTokens {
 			filteredTokensList = append(filteredTokensList, tokens)
 		} else if finishReason == LengthFinishReason && len(tokens) == nTokens {
 			filteredTokensList = append(filteredTokensList, tokens)
 		}
 	}
 	tokensList = filteredTokensList
+	source := SourceHash
 
 	if err != nil || len(filteredTokensList) == 0 {
+		source = SourceLength
 		switch finishReason {
 		case LengthFinishReason:
 			query = "SELECT " + genTokensCol + " FROM " + tableName + " WHERE " + nGenTokensCol + "=" + strconv.Itoa(nTokens) + ";"
 			tokensList, err = d.query(query, nTokens)
 		case StopFinishReason:
-			query = "SELECT " + genTokensCol + " FROM " + tableName + " WHERE " + nGenTokensCol + "<=" + strconv.Itoa(nTokens) + ";"
+			query = "SELECT " + genTokensCol + " FROM " + tableName + " WHERE " + nGenTokensCol + "<=" + strconv.Itoa(nTokens) +
+				" AND " + nGenTokensCol + ">=" + strconv.Itoa(minTokens) + ";"
 			tokensList, err = d.query(query, nTokens)
 		}
 	}
 
 	if err != nil || len(tokensList) == 0 {
 		// if both queries fail or return no results, generate random tokens
-		return GenPresetRandomTokens(nTokens), nil
+		d.fallbacks.Add(1)
+		return GenPresetRandomTokens(random, nTokens), nil
+	}
+	if source == SourceHash {
+		d.hashHits.Add(1)
+	} else {
+		d.lengthHits.Add(1)
 	}
 	if d.hasWarned {
 		d.hasWarned = false
 	}
-	randIndex := common.RandomInt(0, len(tokensList)-1)
+	randIndex := random.Int(0, len(tokensList)-1)
 	return tokensList[randIndex], nil
 }
+
+// Check verifies that the database can be queried and that its records can be parsed
+func (d *CustomDataset) Check(ctx context.Context) error {
+	var tokensJSON string
+	err := d.db.QueryRowContext(ctx, "SELECT "+genTokensCol+" FROM "+tableName+" LIMIT 1;").Scan(&tokensJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	var tokens []string
+	if err := json.Unmarshal([]byte(tokensJSON), &tokens); err != nil {
+		return fmt.Errorf("failed to unmarshal tokens JSON: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the statistics of the dataset
+func (d *CustomDataset) Stats() Stats {
+	dbMode := DBModeFile
+	if d.inMemory {
+		dbMode = DBModeInMemory
+	}
+	return Stats{
+		Responses: map[string]int64{
+			SourceHash:     d.hashHits.Load(),
+			SourceLength:   d.lengthHits.Load(),
+			SourceFallback: d.fallbacks.Load(),
+		},
+		RecordsCount: d.recordsCount,
+		DBMode:       dbMode,
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dataset
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// maxJSONLLineBytes is the maximum length of a record in a jsonl dataset
+const maxJSONLLineBytes = 16 * 1024 * 1024
+
+// jsonlRecord is a record of a jsonl dataset, a line of the file
+type jsonlRecord struct {
+	// PromptHash is the hex encoded sha256 hash of the prompt
+	PromptHash string `json:"prompt_hash"`
+	// GenTokens are the tokens of the response to the prompt
+	GenTokens []string `json:"gen_tokens"`
+}
+
+// fileFormat returns the format of the dataset file, the configured format if set, otherwise
+// the format is selected by the file extension
+func (d *CustomDataset) fileFormat(path string) (string, error) {
+	format := d.format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".ndjson":
+			format = common.DatasetFormatJSONL
+		case ".parquet":
+			format = common.DatasetFormatParquet
+		default:
+			format = common.DatasetFormatSQLite
+		}
+	}
+	switch format {
+	case common.DatasetFormatSQLite, common.DatasetFormatJSONL:
+		return format, nil
+	case common.DatasetFormatParquet:
+		return "", errors.New("parquet datasets are not supported, convert the dataset to jsonl or sqlite")
+	default:
+		return "", fmt.Errorf("unknown dataset format %s", format)
+	}
+}
+
+// copyJSONL inserts the records of the given jsonl file into the in-memory database in batches,
+// each batch is inserted in a separate transaction
+func (d *CustomDataset) copyJSONL(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dataset file: %w", err)
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			d.logger.Error(cerr, "failed to close dataset file")
+		}
+	}()
+
+	if err := d.createTable(ctx); err != nil {
+		return err
+	}
+
+	batchSize := d.inMemoryBatchSize
+	if batchSize <= 0 {
+		batchSize = inMemoryLoadBatchSize
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLLineBytes)
+	var lineNum, copied, estimatedBytes int64
+	done := false
+	for !done {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("in-memory database load cancelled: %w", err)
+		}
+
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		rows, batchBytes, err := insertJSONLBatch(ctx, tx, scanner, batchSize, copied, &lineNum)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				d.logger.Error(rbErr, "failed to rollback transaction")
+			}
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		done = rows < int64(batchSize)
+
+		copied += rows
+		estimatedBytes += batchBytes + rows*recordOverheadBytes
+		if d.maxInMemoryBytes > 0 && estimatedBytes > d.maxInMemoryBytes {
+			return fmt.Errorf("%w: more than %d bytes after loading %d records", errDatasetExceedsMemoryCap,
+				d.maxInMemoryBytes, copied)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dataset file: %w", err)
+	}
+	d.logger.Info("Dataset file loaded", "path", path, "records", copied)
+	return nil
+}
+
+// insertJSONLBatch inserts up to batchSize records read by the scanner, the ids of the records
+// follow firstID, returns the number of inserted records and their estimated size
+func insertJSONLBatch(ctx context.Context, tx *sql.Tx, scanner *bufio.Scanner, batchSize int, firstID int64,
+	lineNum *int64) (int64, int64, error) {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO "+tableName+" ("+idCol+", "+promptHashCol+", "+
+		genTokensCol+", "+nGenTokensCol+") VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	var rows, batchBytes int64
+	for rows < int64(batchSize) && scanner.Scan() {
+		*lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash, tokensJSON, nTokens, err := parseJSONLRecord(line)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid record at line %d: %w", *lineNum, err)
+		}
+		if _, err := stmt.ExecContext(ctx, firstID+rows+1, hash, tokensJSON, nTokens); err != nil {
+			return 0, 0, fmt.Errorf("failed to insert record at line %d: %w", *lineNum, err)
+		}
+		rows++
+		batchBytes += int64(len(hash) + len(tokensJSON))
+	}
+	return rows, batchBytes, nil
+}
+
+// parseJSONLRecord parses a line of a jsonl dataset, returns the prompt hash, the tokens
+// as JSON and the number of tokens
+func parseJSONLRecord(line string) ([]byte, string, int, error) {
+	var record jsonlRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, "", 0, err
+	}
+	hash, err := hex.DecodeString(record.PromptHash)
+	if err != nil || len(hash) == 0 {
+		return nil, "", 0, fmt.Errorf("prompt_hash must be a hex encoded hash, got '%s'", record.PromptHash)
+	}
+	if record.GenTokens == nil {
+		return nil, "", 0, errors.New("gen_tokens is missing")
+	}
+	tokensJSON, err := json.Marshal(record.GenTokens)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return hash, string(tokensJSON), len(record.GenTokens), nil
+}
@@ -18,8 +18,14 @@ package dataset
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
@@ -74,7 +80,7 @@ var _ = Describe("CustomDataset", Ordered, func() {
 	})
 
 	It("should return error for invalid DB path", func() {
-		err := dataset.connectToDB("/invalid/path/to/db.sqlite", false)
+		err := dataset.connectToDB(context.Background(), "/invalid/path/to/db.sqlite", false)
 		Expect(err).To(HaveOccurred())
 	})
 
@@ -123,30 +129,30 @@ var _ = Describe("CustomDataset", Ordered, func() {
 	})
 
 	It("should return error for non-existing DB path", func() {
-		err := dataset.connectToDB(pathNotExist, false)
+		err := dataset.connectToDB(context.Background(), pathNotExist, false)
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("database file does not exist"))
 	})
 
 	It("should return error for invalid DB file", func() {
-		err := dataset.connectToDB(pathToInvalidDB, false)
+		err := dataset.connectToDB(context.Background(), pathToInvalidDB, false)
 		Expect(err).To(HaveOccurred())
 	})
 
 	It("should return error for DB with invalid table", func() {
-		err := dataset.connectToDB(pathToInvalidTableDB, false)
+		err := dataset.connectToDB(context.Background(), pathToInvalidTableDB, false)
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("failed to verify database"))
 	})
 
 	It("should return error for DB with invalid column", func() {
-		err := dataset.connectToDB(pathToInvalidColumnDB, false)
+		err := dataset.connectToDB(context.Background(), pathToInvalidColumnDB, false)
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("missing expected column"))
 	})
 
 	It("should return error for DB with invalid column type", func() {
-		err := dataset.connectToDB(pathToInvalidTypeDB, false)
+		err := dataset.connectToDB(context.Background(), pathToInvalidTypeDB, false)
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("incorrect type"))
 	})
@@ -182,7 +188,7 @@ var _ = Describe("CustomDataset", Ordered, func() {
 		req := &openaiserverapi.TextCompletionRequest{
 			Prompt: testPrompt,
 		}
-		tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+		tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(finishReason).To(Equal(StopFinishReason))
 		Expect(tokens).To(Equal([]string{"Hello", " llm-d ", "world", "!"}))
@@ -196,7 +202,7 @@ var _ = Describe("CustomDataset", Ordered, func() {
 			Prompt:    testPrompt,
 			MaxTokens: &n,
 		}
-		tokens, _, err := dataset.GetTokens(req, common.ModeRandom)
+		tokens, _, err := dataset.GetTokens(req, common.ModeRandom, nil)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(len(tokens)).To(BeNumerically("<=", 2))
 	})
@@ -208,9 +214,306 @@ var _ = Describe("CustomDataset", Ordered, func() {
 		req := &openaiserverapi.TextCompletionRequest{
 			Prompt: testPrompt,
 		}
-		tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+		tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(finishReason).To(Equal(StopFinishReason))
 		Expect(tokens).To(Equal([]string{"Hello", " llm-d ", "world", "!"}))
 	})
 })
+
+// createGeneratedDB creates a dataset database with the given number of records
+func createGeneratedDB(path string, nRecords int) {
+	db, err := sql.Open("sqlite3", path)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(db.Close()).To(Succeed())
+	}()
+
+	_, err = db.Exec(`CREATE TABLE llmd (
+		id INTEGER PRIMARY KEY,
+		prompt_hash BLOB,
+		gen_tokens JSON,
+		n_gen_tokens INTEGER
+	)`)
+	Expect(err).NotTo(HaveOccurred())
+
+	tx, err := db.Begin()
+	Expect(err).NotTo(HaveOccurred())
+	stmt, err := tx.Prepare("INSERT INTO llmd (id, prompt_hash, gen_tokens, n_gen_tokens) VALUES (?, ?, ?, ?)")
+	Expect(err).NotTo(HaveOccurred())
+	for i := range nRecords {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("prompt %d", i)))
+		tokens, err := json.Marshal([]string{"token", fmt.Sprintf(" %d", i)})
+		Expect(err).NotTo(HaveOccurred())
+		// leave gaps in the ids, the batches should not depend on consecutive ids
+		_, err = stmt.Exec(2*i+1, hash[:], string(tokens), 2)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(stmt.Close()).To(Succeed())
+	Expect(tx.Commit()).To(Succeed())
+}
+
+// isInMemory checks whether the dataset's main database is an in-memory database
+func isInMemory(dataset *CustomDataset) bool {
+	var seq int
+	var name, file string
+	err := dataset.db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file)
+	Expect(err).NotTo(HaveOccurred())
+	return file == ""
+}
+
+var _ = Describe("CustomDataset in-memory load", Ordered, func() {
+	const nRecords = 5000
+	var (
+		dataset *CustomDataset
+		dbPath  string
+	)
+
+	BeforeAll(func() {
+		dbPath = filepath.Join(GinkgoT().TempDir(), "generated.sqlite3")
+		createGeneratedDB(dbPath, nRecords)
+	})
+
+	AfterEach(func() {
+		if dataset.db != nil {
+			Expect(dataset.db.Close()).To(Succeed())
+		}
+	})
+
+	DescribeTable("should copy all the records in batches",
+		func(batchSize int) {
+			dataset = NewCustomDataset(0, "", nil)
+			dataset.inMemoryBatchSize = batchSize
+			err := dataset.Init(context.Background(), klog.Background(), dbPath, "", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(isInMemory(dataset)).To(BeTrue())
+
+			count, err := dataset.getRecordsCount()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(nRecords))
+
+			var minID, maxID int
+			err = dataset.db.QueryRow("SELECT MIN(id), MAX(id) FROM llmd").Scan(&minID, &maxID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(minID).To(Equal(1))
+			Expect(maxID).To(Equal(2*nRecords - 1))
+		},
+		Entry("default batch size", 0),
+		Entry("batch size that divides the records", 1000),
+		Entry("batch size that doesn't divide the records", 777),
+		Entry("single record batches", 1),
+	)
+
+	It("should stop loading when the context is cancelled", func() {
+		dataset = NewCustomDataset(0, "", nil)
+		dataset.inMemoryBatchSize = 100
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := dataset.Init(ctx, klog.Background(), dbPath, "", true)
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		Expect(dataset.db).To(BeNil())
+	})
+
+	It("should fall back to the database file when the dataset exceeds the memory limit", func() {
+		dataset = NewCustomDataset(10*1024, "", nil)
+		dataset.inMemoryBatchSize = 100
+		err := dataset.Init(context.Background(), klog.Background(), dbPath, "", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isInMemory(dataset)).To(BeFalse())
+
+		count, err := dataset.getRecordsCount()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(nRecords))
+		Expect(dataset.Close()).To(Succeed())
+		dataset.db = nil
+	})
+
+	It("should load the dataset into memory when it is within the memory limit", func() {
+		dataset = NewCustomDataset(100*1024*1024, "", nil)
+		err := dataset.Init(context.Background(), klog.Background(), dbPath, "", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isInMemory(dataset)).To(BeTrue())
+	})
+})
+
+var _ = Describe("CustomDataset statistics", Ordered, func() {
+	const nRecords = 10
+	var (
+		dataset *CustomDataset
+		dbPath  string
+		// the request whose prompt hash is in the database, with 3 tokens
+		knownReq = &openaiserverapi.TextCompletionRequest{Prompt: testPrompt}
+		// a request whose prompt hash is not in the database
+		unknownReq = &openaiserverapi.TextCompletionRequest{Prompt: "unknown prompt"}
+	)
+
+	BeforeAll(func() {
+		dbPath = filepath.Join(GinkgoT().TempDir(), "stats.sqlite3")
+		// all the generated records have 2 tokens
+		createGeneratedDB(dbPath, nRecords)
+
+		db, err := sql.Open("sqlite3", dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		hash := sha256.Sum256([]byte(knownReq.GetFullPrompt()))
+		_, err = db.Exec("INSERT INTO llmd (id, prompt_hash, gen_tokens, n_gen_tokens) VALUES (?, ?, ?, ?)",
+			1000, hash[:], `["Hello", " world", "!"]`, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if dataset.db != nil {
+			Expect(dataset.db.Close()).To(Succeed())
+		}
+	})
+
+	expectResponses := func(hash, length, fallback int64) {
+		Expect(dataset.Stats().Responses).To(Equal(map[string]int64{
+			SourceHash:     hash,
+			SourceLength:   length,
+			SourceFallback: fallback,
+		}))
+	}
+
+	DescribeTable("should attribute the responses to the source of their tokens",
+		func(useInMemory bool, expectedMode string) {
+			dataset = NewCustomDataset(0, "", common.NewRandom(100))
+			err := dataset.Init(context.Background(), klog.Background(), dbPath, "", useInMemory)
+			Expect(err).NotTo(HaveOccurred())
+
+			stats := dataset.Stats()
+			Expect(stats.RecordsCount).To(Equal(nRecords + 1))
+			Expect(stats.DBMode).To(Equal(expectedMode))
+			expectResponses(0, 0, 0)
+
+			tokens, err := dataset.GenerateTokens(knownReq, 5, StopFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(Equal([]string{"Hello", " world", "!"}))
+			expectResponses(1, 0, 0)
+
+			// the record of the prompt is too long, a record with 2 tokens is used
+			tokens, err = dataset.GenerateTokens(knownReq, 2, LengthFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(2))
+			expectResponses(1, 1, 0)
+
+			_, err = dataset.GenerateTokens(unknownReq, 2, StopFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			expectResponses(1, 2, 0)
+
+			// there are no records with a single token
+			tokens, err = dataset.GenerateTokens(unknownReq, 1, LengthFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(1))
+			expectResponses(1, 2, 1)
+		},
+		Entry("database file", false, DBModeFile),
+		Entry("in-memory database", true, DBModeInMemory),
+	)
+
+	It("should count a failed query as a fallback", func() {
+		dataset = NewCustomDataset(0, "", common.NewRandom(100))
+		err := dataset.Init(context.Background(), klog.Background(), dbPath, "", true)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(dataset.db.Close()).To(Succeed())
+		for range 2 {
+			tokens, err := dataset.GenerateTokens(knownReq, 5, StopFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(5))
+		}
+		Expect(dataset.hasWarned).To(BeTrue())
+		expectResponses(0, 0, 2)
+		dataset.db = nil
+	})
+})
+
+var _ = Describe("CustomDataset jsonl", func() {
+	var (
+		dataset  *CustomDataset
+		knownReq = &openaiserverapi.TextCompletionRequest{Prompt: testPrompt}
+	)
+
+	// writeJSONL writes a jsonl dataset with the record of the known prompt and nRecords generated records
+	writeJSONL := func(name string, nRecords int) string {
+		hash := sha256.Sum256([]byte(knownReq.GetFullPrompt()))
+		lines := []string{fmt.Sprintf(`{"prompt_hash": "%x", "gen_tokens": ["Hello", " world", "!"]}`, hash), ""}
+		for i := range nRecords {
+			hash := sha256.Sum256([]byte(fmt.Sprintf("prompt %d", i)))
+			lines = append(lines, fmt.Sprintf(`{"prompt_hash": "%x", "gen_tokens": ["token", " %d"]}`, hash, i))
+		}
+		path := filepath.Join(GinkgoT().TempDir(), name)
+		Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)).To(Succeed())
+		return path
+	}
+
+	AfterEach(func() {
+		if dataset.db != nil {
+			Expect(dataset.db.Close()).To(Succeed())
+		}
+	})
+
+	DescribeTable("should load the records into memory",
+		func(name string, format string, batchSize int) {
+			path := writeJSONL(name, 10)
+			dataset = NewCustomDataset(0, format, common.NewRandom(100))
+			dataset.inMemoryBatchSize = batchSize
+			err := dataset.Init(context.Background(), klog.Background(), path, "", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(isInMemory(dataset)).To(BeTrue())
+
+			stats := dataset.Stats()
+			Expect(stats.RecordsCount).To(Equal(11))
+			Expect(stats.DBMode).To(Equal(DBModeInMemory))
+
+			tokens, err := dataset.GenerateTokens(knownReq, 5, StopFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(Equal([]string{"Hello", " world", "!"}))
+
+			tokens, err = dataset.GenerateTokens(knownReq, 2, LengthFinishReason, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(2))
+			Expect(tokens[0]).To(Equal("token"))
+			Expect(dataset.Stats().Responses).To(Equal(map[string]int64{
+				SourceHash:     1,
+				SourceLength:   1,
+				SourceFallback: 0,
+			}))
+		},
+		Entry("selected by the jsonl extension", "dataset.jsonl", "", 0),
+		Entry("selected by the ndjson extension", "dataset.ndjson", "", 0),
+		Entry("selected by the format", "dataset.txt", common.DatasetFormatJSONL, 0),
+		Entry("batch size that divides the records", "dataset.jsonl", "", 11),
+		Entry("batch size that doesn't divide the records", "dataset.jsonl", "", 3),
+	)
+
+	It("should report the line of an invalid record", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "invalid.jsonl")
+		content := `{"prompt_hash": "00ff", "gen_tokens": ["a"]}` + "\n" + `{"prompt_hash": "xyz", "gen_tokens": ["a"]}`
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		dataset = NewCustomDataset(0, "", nil)
+		err := dataset.Init(context.Background(), klog.Background(), path, "", false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("line 2"))
+		Expect(dataset.db).To(BeNil())
+	})
+
+	It("should fail when the dataset exceeds the memory limit", func() {
+		path := writeJSONL("large.jsonl", 1000)
+		dataset = NewCustomDataset(10*1024, "", nil)
+		dataset.inMemoryBatchSize = 100
+		err := dataset.Init(context.Background(), klog.Background(), path, "", false)
+		Expect(errors.Is(err, errDatasetExceedsMemoryCap)).To(BeTrue())
+		Expect(dataset.db).To(BeNil())
+	})
+
+	It("should reject a parquet dataset", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "dataset.parquet")
+		Expect(os.WriteFile(path, []byte("PAR1"), 0644)).To(Succeed())
+		dataset = NewCustomDataset(0, "", nil)
+		err := dataset.Init(context.Background(), klog.Background(), path, "", false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("parquet datasets are not supported"))
+	})
+})
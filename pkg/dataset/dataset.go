@@ -20,7 +20,8 @@ import (
 	"context"
 	"errors"
 	"math"
-	"math/rand"
+	"slices"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
@@ -34,15 +35,15 @@ const (
 )
 
 const (
-	ResponseLenMax              = 128
-	responseLenMean             = 40
-	responseLenStddev           = 20
-	stopFinishReasonProbability = 0.8
-
-	StopFinishReason         = "stop"
-	LengthFinishReason       = "length"
-	ToolsFinishReason        = "tool_calls"
-	RemoteDecodeFinishReason = "remote_decode"
+	ResponseLenMax    = 128
+	responseLenMean   = 40
+	responseLenStddev = 20
+
+	StopFinishReason          = "stop"
+	LengthFinishReason        = "length"
+	ToolsFinishReason         = "tool_calls"
+	ContentFilterFinishReason = "content_filter"
+	RemoteDecodeFinishReason  = "remote_decode"
 )
 
 // this array defines the probabilities for the buckets to be used for the generation of number of tokens in response
@@ -54,6 +55,10 @@ const (
 	maxFixedBucketSize = 20
 )
 
+// maxLogitBiasRounds is the maximal number of times tokens are generated to replace the tokens that were
+// removed by a negative logit bias, the response is shorter if most of the generated tokens are removed
+const maxLogitBiasRounds = 100
+
 // list of responses to use in random mode for completion requests
 var chatCompletionFakeResponses = []string{
 	`Testing@, #testing 1$ ,2%,3^, [4&*5], 6~, 7-_ + (8 : 9) / \ < > .`,
@@ -74,8 +79,9 @@ type Dataset interface {
 	Init(ctx context.Context, logger logr.Logger, path string, url string, useInMemory bool) error
 	// Close closes the dataset
 	Close() error
-	// GetTokens returns tokens for the given request and mode (echo or random)
-	GetTokens(req openaiserverapi.CompletionRequest, mode string) ([]string, string, error)
+	// GetTokens returns tokens for the given request and mode (echo or random), random is the random
+	// generator of the request, the generator of the dataset is used if it is nil
+	GetTokens(req openaiserverapi.CompletionRequest, mode string, random *common.Random) ([]string, string, error)
 }
 
 func init() {
@@ -90,9 +96,9 @@ func init() {
 
 // GetRandomResponseLen returns int in range [1, responseLenMax]
 // numbers are chosen according a gaussian distribution with mean responseLenMean, and standard deviation responseLenStddev
-func GetRandomResponseLen() int {
+func GetRandomResponseLen(random *common.Random) int {
 	for {
-		val := rand.NormFloat64()*responseLenStddev + responseLenMean
+		val := random.NormFloat64(responseLenMean, responseLenStddev)
 		if val >= 1 && val <= ResponseLenMax {
 			return int(math.Round(val))
 		}
@@ -100,23 +106,35 @@ func GetRandomResponseLen() int {
 	}
 }
 
-// GetRandomFinishReason returns finish reason with the probability for 'stop' as defined by stopFinishReasonProbability
-func GetRandomFinishReason() string {
-	if rand.Float64() < stopFinishReasonProbability {
-		return StopFinishReason
+// DrawFinishReason draws one of the given finish reasons with a probability proportional to its share in the
+// distribution, returns an empty string if none of them has a share
+func DrawFinishReason(random *common.Random, distribution map[string]int, reasons ...string) string {
+	total := 0
+	for _, reason := range reasons {
+		total += distribution[reason]
+	}
+	if total == 0 {
+		return ""
 	}
-	return LengthFinishReason
+	value := random.Int(1, total)
+	for _, reason := range reasons {
+		if value <= distribution[reason] {
+			return reason
+		}
+		value -= distribution[reason]
+	}
+	return reasons[len(reasons)-1]
 }
 
 // GenPresetRandomTokens generates random tokens for the required number of tokens,
 // select randomly a sentence from chatCompletionFakeResponses,
 // if number of tokens is lower than required - select another sentence,
 // continue until the required number of tokens is achieved
-func GenPresetRandomTokens(numOfTokens int) []string {
+func GenPresetRandomTokens(random *common.Random, numOfTokens int) []string {
 	allTokens := make([]string, 0)
 
 	for len(allTokens) < numOfTokens {
-		index := common.RandomInt(0, len(chatCompletionFakeResponses)-1)
+		index := random.Int(0, len(chatCompletionFakeResponses)-1)
 		// create tokens from text, splitting by spaces and special characters
 		tokens := common.Tokenize(chatCompletionFakeResponses[index])
 		remaining := numOfTokens - len(allTokens)
@@ -137,6 +155,102 @@ func GenPresetRandomTokens(numOfTokens int) []string {
 	return allTokens
 }
 
+// genSampledRandomTokens generates random tokens like GenPresetRandomTokens, the sentences are chosen according
+// to the sampling parameters, if they are not nil: top_p limits the choice to the first top_p part of the sentences,
+// a positive penalty (the sum of the presence and frequency penalties) prevents repeating a sentence until all the
+// allowed sentences are used, and a negative penalty repeats the first sentence
+func genSampledRandomTokens(random *common.Random, numOfTokens int, params *openaiserverapi.SamplingParams) []string {
+	if params == nil {
+		return GenPresetRandomTokens(random, numOfTokens)
+	}
+	nSentences := max(1, int(math.Ceil(params.GetTopP()*float64(len(chatCompletionFakeResponses)))))
+	penalty := params.GetPenalty()
+	allTokens := make([]string, 0)
+	used := make(map[int]bool)
+	index := -1
+	for len(allTokens) < numOfTokens {
+		switch {
+		case penalty < 0 && index >= 0:
+			// keep repeating the first sentence
+		case penalty > 0:
+			if len(used) == nSentences {
+				clear(used)
+			}
+			index = random.Int(0, nSentences-1)
+			for used[index] {
+				index = (index + 1) % nSentences
+			}
+			used[index] = true
+		default:
+			index = random.Int(0, nSentences-1)
+		}
+		tokens := common.Tokenize(chatCompletionFakeResponses[index])
+		if remaining := numOfTokens - len(allTokens); len(tokens) > remaining {
+			tokens = tokens[:remaining]
+		}
+		if len(allTokens) > 0 {
+			tokens[0] = " " + tokens[0]
+		}
+		allTokens = append(allTokens, tokens...)
+	}
+	return allTokens
+}
+
+// genBiasedRandomTokens generates random tokens like genSampledRandomTokens, biased by the logit bias of the request:
+// every token is replaced by one of the tokens with a positive bias with the probability of the sum of the positive
+// biases divided by 100, chosen by the shares of their biases, so that a bias of 100 makes its token the only
+// generated token. Other tokens with a negative bias are removed with the probability of the bias divided by -100,
+// a bias of -100 bans the token. The biased tokens are compared with the generated tokens without their whitespace
+func genBiasedRandomTokens(random *common.Random, numOfTokens int, params *openaiserverapi.SamplingParams,
+	logitBias map[string]float64) []string {
+	if len(logitBias) == 0 {
+		return genSampledRandomTokens(random, numOfTokens, params)
+	}
+	// the favored tokens are sorted, so that the same seed generates the same tokens
+	favored := make([]string, 0)
+	totalFavor := 0.0
+	for token, bias := range logitBias {
+		if bias > 0 {
+			favored = append(favored, token)
+			totalFavor += bias
+		}
+	}
+	slices.Sort(favored)
+	pickFavored := func() string {
+		value := random.Float(0, totalFavor)
+		for _, token := range favored {
+			if value -= logitBias[token]; value < 0 {
+				return token
+			}
+		}
+		return favored[len(favored)-1]
+	}
+
+	allTokens := make([]string, 0, numOfTokens)
+	for round := 0; len(allTokens) < numOfTokens && round < maxLogitBiasRounds; round++ {
+		tokens := genSampledRandomTokens(random, numOfTokens-len(allTokens), params)
+		if len(allTokens) > 0 {
+			tokens[0] = " " + tokens[0]
+		}
+		for _, token := range tokens {
+			if totalFavor > 0 && random.Float(0, 100) < totalFavor {
+				token = replaceTokenText(token, pickFavored())
+			} else if bias := logitBias[strings.TrimSpace(token)]; bias < 0 && random.Float(0, 100) < -bias {
+				continue
+			}
+			allTokens = append(allTokens, token)
+		}
+	}
+	return allTokens
+}
+
+// replaceTokenText replaces the text of a token, keeping its leading and trailing whitespace
+func replaceTokenText(token string, text string) string {
+	trimmed := strings.TrimLeft(token, " \t\n")
+	leading := token[:len(token)-len(trimmed)]
+	return leading + text + trimmed[len(strings.TrimRight(trimmed, " \t\n")):]
+}
+
 // howManyTokensToGen generates the number of tokens to be returned in a response, and the finish reason (see constants)
 // if maxCompletionTokens is defined
 // - currently, the generated number of words in the text will be equal to it value
@@ -147,13 +261,16 @@ func GenPresetRandomTokens(numOfTokens int) []string {
 // - finish reason is stop
 // if ignore_eos is true - the response will be generated with exactly maxCompletionTokens tokens
 // - request was validated so that when ignore_eos is true, maxCompletionTokens must be defined
-func howManyTokensToGen(maxCompletionTokens *int64, ignore_eos bool) (int, string) {
+// the response has at least minTokens tokens, the request was validated so that minTokens is not
+// greater than maxCompletionTokens
+func howManyTokensToGen(random *common.Random, maxCompletionTokens *int64, minTokens int64,
+	ignore_eos bool) (int, string) {
 	numOfTokens := 0
 	finishReason := StopFinishReason
 
 	// no max completion tokens, return text with random length
 	if maxCompletionTokens == nil {
-		numOfTokens = GetRandomResponseLen()
+		numOfTokens = max(GetRandomResponseLen(random), int(minTokens))
 	} else {
 		maxTokens := int(*maxCompletionTokens)
 		if ignore_eos {
@@ -161,7 +278,7 @@ func howManyTokensToGen(maxCompletionTokens *int64, ignore_eos bool) (int, strin
 			finishReason = LengthFinishReason
 		} else {
 			// max tokens is defined - generate real length of the response based on it
-			numOfTokens = getResponseLengthByHistogram(maxTokens)
+			numOfTokens = max(getResponseLengthByHistogram(random, maxTokens), int(minTokens))
 			if numOfTokens == maxTokens {
 				// if response should be create with maximum number of tokens - finish reason will be 'length'
 				finishReason = LengthFinishReason
@@ -178,17 +295,17 @@ func howManyTokensToGen(maxCompletionTokens *int64, ignore_eos bool) (int, strin
 // The last element of respLenBucketsProbabilities defines the probability of a reposnse with maxToken tokens.
 // Other values define probabilities for the equally sized buckets.
 // If maxToken is small (smaller than number of buckets) - the response length is randomly selected from the range [1, maxTokens]
-func getResponseLengthByHistogram(maxTokens int) int {
+func getResponseLengthByHistogram(random *common.Random, maxTokens int) int {
 	if maxTokens <= 1 {
 		return maxTokens
 	}
 	// maxTokens is small - no need to use the histogram of probabilities, just select a random value in the range [1, maxTokens]
 	if maxTokens <= len(cumulativeBucketsProbabilities) {
-		res := common.RandomInt(1, maxTokens)
+		res := random.Int(1, maxTokens)
 		return res
 	}
 
-	r := common.RandomFloat(0, 1)
+	r := random.Float(0, 1)
 
 	// check if r is in the last bucket, then maxTokens should be returned
 	if r > cumulativeBucketsProbabilities[len(cumulativeBucketsProbabilities)-2] {
@@ -209,7 +326,7 @@ func getResponseLengthByHistogram(maxTokens int) int {
 	start, end := calcBucketBoundaries(maxTokens, bucketIndex)
 
 	// pick uniformly within the bucket’s range
-	return common.RandomInt(start, end)
+	return random.Int(start, end)
 }
 
 // calcBucketBoundaries calculates boundaries of a bucket with the given index.
@@ -262,23 +379,90 @@ func calcBucketBoundaries(maxTokens int, bucketIndex int) (start int, end int) {
 }
 
 // EchoResponseTokens returns needed tokens, from a given text
-// considering max completion tokens if it is not nil, and a finish reason (stop or length)
-func EchoResponseTokens(maxCompletionTokens *int64, text string) ([]string, string) {
-	tokens := common.Tokenize(text)
-	// no max completion tokens, return entire text
-	if maxCompletionTokens == nil {
-		return tokens, StopFinishReason
+// considering max completion tokens if it is not nil and the stop sequences, and a finish reason (stop or length)
+func EchoResponseTokens(maxCompletionTokens *int64, stop []string, text string) ([]string, string) {
+	return echoTokens(maxCompletionTokens, stop, common.Tokenize(text))
+}
+
+// echoTokens returns needed tokens from the given prompt tokens
+func echoTokens(maxCompletionTokens *int64, stop []string, tokens []string) ([]string, string) {
+	finishReason := StopFinishReason
+	if maxCompletionTokens != nil && *maxCompletionTokens < int64(len(tokens)) {
+		// return truncated text
+		tokens = tokens[0:*maxCompletionTokens]
+		finishReason = LengthFinishReason
+	}
+	return applyStopSequences(tokens, finishReason, stop, 0)
+}
+
+// applyStopSequences truncates the tokens before the first occurrence of any of the stop sequences,
+// the token in which the stop sequence starts is cut at the beginning of the stop sequence.
+// The stop sequences are not searched in the first minTokens tokens.
+// Returns the tokens and the finish reason, which is stop if a stop sequence was found
+func applyStopSequences(tokens []string, finishReason string, stop []string, minTokens int64) ([]string, string) {
+	if len(stop) == 0 {
+		return tokens, finishReason
+	}
+	text := strings.Join(tokens, "")
+	minOffset := len(strings.Join(tokens[:min(int(minTokens), len(tokens))], ""))
+	stopIndex := -1
+	for _, sequence := range stop {
+		if sequence == "" {
+			continue
+		}
+		index := strings.Index(text[minOffset:], sequence)
+		if index < 0 {
+			continue
+		}
+		if index += minOffset; stopIndex < 0 || index < stopIndex {
+			stopIndex = index
+		}
+	}
+	if stopIndex < 0 {
+		return tokens, finishReason
 	}
 
-	if *maxCompletionTokens >= int64(len(tokens)) {
-		return tokens, StopFinishReason
+	result := make([]string, 0)
+	offset := 0
+	for _, token := range tokens {
+		if offset+len(token) > stopIndex {
+			if stopIndex > offset {
+				result = append(result, token[:stopIndex-offset])
+			}
+			break
+		}
+		result = append(result, token)
+		offset += len(token)
 	}
-	// return truncated text
-	return tokens[0:*maxCompletionTokens], LengthFinishReason
+	return result, StopFinishReason
 }
 
 type BaseDataset struct {
 	logger logr.Logger
+	// random is the random generator of the responses, the default generator is used if not set
+	random *common.Random
+	// finishReasons maps the finish reasons to their shares in percents of the generated responses,
+	// the finish reason follows from the length of the response if it is empty
+	finishReasons map[string]int
+}
+
+// NewBaseDataset creates a new BaseDataset that generates random responses with the given generator
+func NewBaseDataset(random *common.Random) *BaseDataset {
+	return &BaseDataset{random: random}
+}
+
+// SetFinishReasonDistribution sets the shares in percents of the finish reasons of the generated responses,
+// the share of tool_calls is ignored, a response with tool calls is not generated by the dataset
+func (d *BaseDataset) SetFinishReasonDistribution(distribution map[string]int) {
+	d.finishReasons = distribution
+}
+
+// requestRandom returns the given random generator of a request, or the generator of the dataset if it is nil
+func (d *BaseDataset) requestRandom(random *common.Random) *common.Random {
+	if random != nil {
+		return random
+	}
+	return d.random
 }
 
 func (d *BaseDataset) Init(ctx context.Context, logger logr.Logger, path string, url string, useInMemory bool) error {
@@ -292,21 +476,79 @@ func (d *BaseDataset) Close() error {
 
 func (d *BaseDataset) echo(req openaiserverapi.CompletionRequest) ([]string, string, error) {
 	nMaxTokens := d.extractMaxTokens(req)
-	prompt, err := d.extractPrompt(req)
+	tokens, err := d.extractPromptTokens(req)
 	if err != nil {
 		return nil, "", err
 	}
-	tokens, finishReason := EchoResponseTokens(nMaxTokens, prompt)
+	// the model "sees" only the trailing visible part of the prompt
+	if visible := req.GetVisibleContextTokens(); visible > 0 && len(tokens) > visible {
+		tokens = tokens[len(tokens)-visible:]
+	}
+	tokens, finishReason := echoTokens(nMaxTokens, req.GetStop(), tokens)
 	return tokens, finishReason, nil
 }
 
-// GetTokens returns tokens and finishReason for the given request and mode (echo or random)
-func (d *BaseDataset) GetTokens(req openaiserverapi.CompletionRequest, mode string) ([]string, string, error) {
+// GetTokens returns tokens and finishReason for the given request and mode (echo or random),
+// random is the random generator of the request, the generator of the dataset is used if it is nil
+func (d *BaseDataset) GetTokens(req openaiserverapi.CompletionRequest, mode string,
+	random *common.Random) ([]string, string, error) {
 	if mode == common.ModeEcho {
 		return d.echo(req)
 	}
-	nTokensToGen, finishReason := howManyTokensToGen(d.extractMaxTokens(req), req.GetIgnoreEOS())
-	return GenPresetRandomTokens(nTokensToGen), finishReason, nil
+	random = d.requestRandom(random)
+	nTokensToGen, finishReason := d.howManyTokensToGen(random, req)
+	// the logit bias is set explicitly by the clients, it applies even if the sampling parameters don't
+	// influence the generation
+	tokens := genBiasedRandomTokens(random, nTokensToGen, req.GetSamplingParams(), req.GetRawSamplingParams().LogitBias)
+	tokens, finishReason = applyStopSequences(tokens, finishReason, req.GetStop(), req.GetMinTokens())
+	return tokens, finishReason, nil
+}
+
+// howManyTokensToGen generates the number of tokens of a response to the given request and the finish reason,
+// the deviation of the number of tokens from the center of its range is scaled by the request's temperature
+// when the sampling parameters influence the generation, the response has at least min_tokens tokens
+func (d *BaseDataset) howManyTokensToGen(random *common.Random, req openaiserverapi.CompletionRequest) (int, string) {
+	maxCompletionTokens := d.extractMaxTokens(req)
+	nTokens, finishReason := howManyTokensToGen(random, maxCompletionTokens, req.GetMinTokens(), req.GetIgnoreEOS())
+	if req.GetIgnoreEOS() {
+		return nTokens, finishReason
+	}
+	if params := req.GetSamplingParams(); params != nil {
+		center, maxTokens := float64(responseLenMean), ResponseLenMax
+		if maxCompletionTokens != nil {
+			maxTokens = int(*maxCompletionTokens)
+			center = float64(maxTokens+1) / 2
+		}
+		scaled := int(math.Round(center + (float64(nTokens)-center)*params.GetTemperature()))
+		nTokens = max(1, int(req.GetMinTokens()), min(scaled, maxTokens))
+		finishReason = StopFinishReason
+		if maxCompletionTokens != nil && nTokens == maxTokens {
+			finishReason = LengthFinishReason
+		}
+	}
+	return d.applyFinishReasonDistribution(random, nTokens, finishReason, maxCompletionTokens, req.GetMinTokens())
+}
+
+// applyFinishReasonDistribution draws the finish reason of a response by the finish reason distribution, a response
+// that ends by length has max tokens tokens, a response that ends by another reason is shorter than max tokens
+// when min tokens allows it. Without max tokens the response keeps its random length
+func (d *BaseDataset) applyFinishReasonDistribution(random *common.Random, nTokens int, finishReason string,
+	maxCompletionTokens *int64, minTokens int64) (int, string) {
+	reason := DrawFinishReason(random, d.finishReasons, StopFinishReason, LengthFinishReason, ContentFilterFinishReason)
+	if reason == "" {
+		return nTokens, finishReason
+	}
+	if maxCompletionTokens == nil {
+		return nTokens, reason
+	}
+	maxTokens := int(*maxCompletionTokens)
+	if reason == LengthFinishReason {
+		return maxTokens, reason
+	}
+	if nTokens == maxTokens && nTokens > max(1, int(minTokens)) {
+		nTokens--
+	}
+	return nTokens, reason
 }
 
 // extractMaxTokens extracts the max tokens from the request
@@ -321,14 +563,21 @@ func (d *BaseDataset) extractMaxTokens(req openaiserverapi.CompletionRequest) *i
 	return nil
 }
 
-// extractPrompt extracts the prompt from the request
-// for chat completion - the last user message is used as the prompt
-// for text completion - the prompt field is used
-func (d *BaseDataset) extractPrompt(req openaiserverapi.CompletionRequest) (string, error) {
+// extractPromptTokens extracts the tokens of the prompt from the request
+// for chat completion - the tokens of the last user message are used
+// for text completion - the tokens of the prompt field followed by the tokens of the suffix are used,
+// a prompt sent as token ids has a placeholder token for each id
+// the text is tokenized losslessly, so that the echoed text equals the prompt
+func (d *BaseDataset) extractPromptTokens(req openaiserverapi.CompletionRequest) ([]string, error) {
 	if chatReq, ok := req.(*openaiserverapi.ChatCompletionRequest); ok {
-		return chatReq.GetLastUserMsg(), nil
+		return common.TokenizeLossless(chatReq.GetLastUserMsg()), nil
 	} else if textReq, ok := req.(*openaiserverapi.TextCompletionRequest); ok {
-		return textReq.GetPrompt(), nil
+		// the suffix of a fill-in-the-middle request follows the prompt
+		suffixTokens := common.TokenizeLossless(textReq.Suffix)
+		if textReq.PromptTokenIDs == nil {
+			return append(common.TokenizeLossless(textReq.GetPrompt()), suffixTokens...), nil
+		}
+		return append(textReq.GetPromptTokens(), suffixTokens...), nil
 	}
-	return "", errors.New("unknown request type")
+	return nil, errors.New("unknown request type")
 }
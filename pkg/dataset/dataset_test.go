@@ -44,7 +44,7 @@ var _ = Describe("Dataset", Ordered, func() {
 
 		It("should return complete text", func() {
 			req := &openaiserverapi.ChatCompletionRequest{}
-			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 			Expect(err).ShouldNot(HaveOccurred())
 			text := strings.Join(tokens, "")
 			Expect(IsValidText(text)).To(BeTrue())
@@ -56,7 +56,7 @@ var _ = Describe("Dataset", Ordered, func() {
 			req := &openaiserverapi.ChatCompletionRequest{
 				MaxCompletionTokens: &maxCompletionTokens,
 			}
-			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 			Expect(err).ShouldNot(HaveOccurred())
 			tokensCnt := int64(len(tokens))
 			Expect(tokensCnt).Should(BeNumerically("<=", maxCompletionTokens))
@@ -74,7 +74,7 @@ var _ = Describe("Dataset", Ordered, func() {
 			req := &openaiserverapi.ChatCompletionRequest{
 				MaxTokens: &maxCompletionTokens,
 			}
-			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 			Expect(err).ShouldNot(HaveOccurred())
 			tokensCnt := int64(len(tokens))
 			Expect(tokensCnt).Should(BeNumerically("<=", maxCompletionTokens))
@@ -88,6 +88,58 @@ var _ = Describe("Dataset", Ordered, func() {
 			}
 		})
 
+		It("should stop before the stop sequence", func() {
+			maxTokens := int64(ResponseLenMax)
+			req := &openaiserverapi.TextCompletionRequest{
+				BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{
+					IgnoreEOS: true,
+					Stop:      openaiserverapi.StopSequences{" "},
+				},
+				MaxTokens: &maxTokens,
+			}
+			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			// the preset sentences contain spaces
+			Expect(finishReason).To(Equal(StopFinishReason))
+			Expect(len(tokens)).To(BeNumerically("<", maxTokens))
+			Expect(strings.Join(tokens, "")).NotTo(ContainSubstring(" "))
+		})
+
+		It("should return at least min tokens", func() {
+			maxTokens := int64(ResponseLenMax)
+			for _, minTokens := range []int64{1, 40, ResponseLenMax} {
+				req := &openaiserverapi.TextCompletionRequest{
+					BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{MinTokens: minTokens},
+				}
+				for range 20 {
+					tokens, _, err := dataset.GetTokens(req, common.ModeRandom, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(len(tokens)).To(BeNumerically(">=", minTokens))
+
+					req.MaxTokens = &maxTokens
+					tokens, _, err = dataset.GetTokens(req, common.ModeRandom, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(len(tokens)).To(BeNumerically(">=", minTokens))
+					Expect(len(tokens)).To(BeNumerically("<=", maxTokens))
+					req.MaxTokens = nil
+				}
+			}
+		})
+
+		It("should not stop before min tokens", func() {
+			req := &openaiserverapi.TextCompletionRequest{
+				BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{
+					MinTokens: 30,
+					Stop:      openaiserverapi.StopSequences{" "},
+				},
+			}
+			tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(finishReason).To(Equal(StopFinishReason))
+			Expect(len(tokens)).To(BeNumerically(">=", 30))
+			Expect(strings.Join(tokens[30:], "")).NotTo(ContainSubstring(" "))
+		})
+
 		DescribeTable("should return exact num of tokens",
 			func(maxCompletionTokens int) {
 				n := int64(maxCompletionTokens)
@@ -97,7 +149,7 @@ var _ = Describe("Dataset", Ordered, func() {
 					},
 					MaxTokens: &n,
 				}
-				tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom)
+				tokens, finishReason, err := dataset.GetTokens(req, common.ModeRandom, nil)
 				Expect(err).ShouldNot(HaveOccurred())
 				nGenTokens := int64(len(tokens))
 				Expect(nGenTokens).Should(Equal(n))
@@ -118,22 +170,37 @@ var _ = Describe("Dataset", Ordered, func() {
 		theTokens := common.Tokenize(theText)
 
 		It("should return the same text since max tokens is not defined", func() {
-			tokens, finishReason := EchoResponseTokens(nil, theText)
+			tokens, finishReason := EchoResponseTokens(nil, nil, theText)
 			Expect(tokens).Should(Equal(theTokens))
 			Expect(finishReason).Should(Equal(StopFinishReason))
 		})
 		It("should return the same text since max tokens is higher than the text length", func() {
 			maxCompletionTokens := int64(1000)
-			tokens, finishReason := EchoResponseTokens(&maxCompletionTokens, theText)
+			tokens, finishReason := EchoResponseTokens(&maxCompletionTokens, nil, theText)
 			Expect(tokens).Should(Equal(theTokens))
 			Expect(finishReason).Should(Equal(StopFinishReason))
 		})
 		It("should return partial text", func() {
 			maxCompletionTokens := int64(2)
-			tokens, finishReason := EchoResponseTokens(&maxCompletionTokens, theText)
+			tokens, finishReason := EchoResponseTokens(&maxCompletionTokens, nil, theText)
 			Expect(int64(len(tokens))).Should(Equal(maxCompletionTokens))
 			Expect(finishReason).Should(Equal(LengthFinishReason))
 		})
+		DescribeTable("should stop before the stop sequence",
+			func(maxTokens int64, stop []string, expectedText string, expectedFinishReason string) {
+				tokens, finishReason := EchoResponseTokens(&maxTokens, stop, theText)
+				Expect(strings.Join(tokens, "")).To(Equal(expectedText))
+				Expect(finishReason).To(Equal(expectedFinishReason))
+			},
+			Entry("stop at a token boundary", int64(100), []string{"fish"}, "Give a man a ", StopFinishReason),
+			Entry("stop in the middle of a token", int64(100), []string{"ish and"}, "Give a man a f", StopFinishReason),
+			Entry("the first of several stop sequences", int64(100), []string{"day", "feed", "unknown"},
+				"Give a man a fish and you ", StopFinishReason),
+			Entry("stop sequence at the beginning", int64(100), []string{"Give"}, "", StopFinishReason),
+			Entry("stop sequence after max tokens", int64(3), []string{"fish"}, "Give a man ", LengthFinishReason),
+			Entry("stop sequence not found", int64(3), []string{"cat"}, "Give a man ", LengthFinishReason),
+			Entry("empty stop sequence", int64(100), []string{""}, theText, StopFinishReason),
+		)
 	})
 
 	Context("GetRandomTokens", func() {
@@ -142,12 +209,151 @@ var _ = Describe("Dataset", Ordered, func() {
 		for _, len := range lenArr {
 			name := fmt.Sprintf("should return text with %d tokens", len)
 			It(name, func() {
-				tokens := GenPresetRandomTokens(len)
+				tokens := GenPresetRandomTokens(nil, len)
 				Expect(tokens).Should(HaveLen(len))
 			})
 		}
 	})
 
+	Context("sampling parameters", func() {
+		newReq := func(maxTokens *int64, params openaiserverapi.SamplingParams) *openaiserverapi.ChatCompletionRequest {
+			req := &openaiserverapi.ChatCompletionRequest{MaxCompletionTokens: maxTokens}
+			req.SamplingParams = params
+			req.SetUseSamplingParams(true)
+			return req
+		}
+		zero := 0.0
+
+		It("should generate responses of the same length with a zero temperature", func() {
+			maxTokens := int64(41)
+			for range 20 {
+				nTokens, finishReason := dataset.howManyTokensToGen(common.NewRandom(time.Now().UnixNano()),
+					newReq(nil, openaiserverapi.SamplingParams{Temperature: &zero}))
+				Expect(nTokens).To(Equal(responseLenMean))
+				Expect(finishReason).To(Equal(StopFinishReason))
+
+				nTokens, _ = dataset.howManyTokensToGen(common.NewRandom(time.Now().UnixNano()),
+					newReq(&maxTokens, openaiserverapi.SamplingParams{Temperature: &zero}))
+				Expect(nTokens).To(Equal(21))
+			}
+		})
+
+		It("should spread the response lengths with a higher temperature", func() {
+			low, high := 0.5, 2.0
+			spread := func(temperature *float64) int {
+				random := common.NewRandom(42)
+				minLen, maxLen := ResponseLenMax, 0
+				for range 200 {
+					nTokens, _ := dataset.howManyTokensToGen(random, newReq(nil, openaiserverapi.SamplingParams{Temperature: temperature}))
+					Expect(nTokens).To(BeNumerically(">=", 1))
+					Expect(nTokens).To(BeNumerically("<=", ResponseLenMax))
+					minLen, maxLen = min(minLen, nTokens), max(maxLen, nTokens)
+				}
+				return maxLen - minLen
+			}
+			Expect(spread(&low)).To(BeNumerically("<", spread(nil)))
+			Expect(spread(nil)).To(BeNumerically("<", spread(&high)))
+		})
+
+		It("should choose the sentences by top_p and the penalties", func() {
+			// only the first sentence is in the top_p part of the sentences
+			topP := 0.05
+			tokens := genSampledRandomTokens(common.NewRandom(42), 60, &openaiserverapi.SamplingParams{TopP: &topP})
+			Expect(tokens).To(HaveLen(60))
+			Expect(strings.Join(tokens, "")).To(HavePrefix(chatCompletionFakeResponses[0]))
+
+			// a negative penalty repeats the first sentence
+			penalty := -1.0
+			tokens = genSampledRandomTokens(common.NewRandom(42), 100, &openaiserverapi.SamplingParams{PresencePenalty: &penalty})
+			text := strings.Join(tokens, "")
+			var first string
+			for _, sentence := range chatCompletionFakeResponses {
+				if strings.HasPrefix(text, strings.TrimSpace(sentence)) && len(sentence) > len(first) {
+					first = strings.TrimSpace(sentence)
+				}
+			}
+			Expect(strings.Count(text, first)).To(BeNumerically(">", 1))
+
+			// a positive penalty uses every sentence before repeating a sentence
+			penalty = 2.0
+			nTokens := 0
+			for _, sentence := range chatCompletionFakeResponses {
+				nTokens += len(common.Tokenize(sentence))
+			}
+			tokens = genSampledRandomTokens(common.NewRandom(42), nTokens, &openaiserverapi.SamplingParams{FrequencyPenalty: &penalty})
+			text = strings.Join(tokens, "")
+			for _, sentence := range chatCompletionFakeResponses {
+				Expect(text).To(ContainSubstring(strings.TrimSpace(sentence)))
+			}
+		})
+
+		It("should include and exclude the tokens by the logit bias", func() {
+			countToken := func(tokens []string, text string) int {
+				count := 0
+				for _, token := range tokens {
+					if strings.TrimSpace(token) == text {
+						count++
+					}
+				}
+				return count
+			}
+
+			// a bias of -100 bans the token
+			tokens := genBiasedRandomTokens(common.NewRandom(42), 500, nil, map[string]float64{"a": -100, ",": -100})
+			Expect(tokens).To(HaveLen(500))
+			Expect(countToken(tokens, "a")).To(BeZero())
+			Expect(countToken(tokens, ",")).To(BeZero())
+			Expect(countToken(GenPresetRandomTokens(common.NewRandom(42), 500), "a")).To(BeNumerically(">", 0))
+
+			// a bias of 100 makes the token the only token
+			tokens = genBiasedRandomTokens(common.NewRandom(42), 50, nil, map[string]float64{"hello": 100})
+			Expect(countToken(tokens, "hello")).To(Equal(50))
+			Expect(strings.Join(tokens, "")).To(ContainSubstring("hello hello"))
+
+			// a positive bias includes the token in a part of the tokens
+			tokens = genBiasedRandomTokens(common.NewRandom(42), 500, nil, map[string]float64{"hello": 20})
+			Expect(countToken(tokens, "hello")).To(BeNumerically("~", 100, 40))
+		})
+	})
+
+	Context("finish reason distribution", func() {
+		It("should end the responses by the finish reasons of the distribution", func() {
+			maxTokens := int64(20)
+			req := &openaiserverapi.ChatCompletionRequest{MaxCompletionTokens: &maxTokens}
+			random := common.NewRandom(42)
+
+			dataset.SetFinishReasonDistribution(map[string]int{LengthFinishReason: 100})
+			for range 20 {
+				nTokens, finishReason := dataset.howManyTokensToGen(random, req)
+				Expect(nTokens).To(Equal(int(maxTokens)))
+				Expect(finishReason).To(Equal(LengthFinishReason))
+			}
+
+			// a response that doesn't end by length is shorter than max tokens, the share of tool_calls is ignored
+			dataset.SetFinishReasonDistribution(map[string]int{ContentFilterFinishReason: 50, ToolsFinishReason: 50})
+			for range 20 {
+				nTokens, finishReason := dataset.howManyTokensToGen(random, req)
+				Expect(nTokens).To(BeNumerically("<", maxTokens))
+				Expect(finishReason).To(Equal(ContentFilterFinishReason))
+			}
+		})
+
+		It("should draw the finish reasons by their shares", func() {
+			distribution := map[string]int{StopFinishReason: 70, LengthFinishReason: 20, ContentFilterFinishReason: 10}
+			random := common.NewRandom(42)
+			counts := make(map[string]int)
+			for range 10000 {
+				counts[DrawFinishReason(random, distribution, StopFinishReason, LengthFinishReason,
+					ContentFilterFinishReason)]++
+			}
+			Expect(counts[StopFinishReason]).To(BeNumerically("~", 7000, 300))
+			Expect(counts[LengthFinishReason]).To(BeNumerically("~", 2000, 300))
+			Expect(counts[ContentFilterFinishReason]).To(BeNumerically("~", 1000, 300))
+
+			Expect(DrawFinishReason(random, nil, StopFinishReason)).To(BeEmpty())
+		})
+	})
+
 	Context("IsValidText", func() {
 		validTxts := make([]string, 0)
 		invalidTxts := make([]string, 0)
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -34,14 +35,18 @@ const (
 // blockCache represents a thread-safe cache for blocks with eviction policy
 type blockCache struct {
 	mu              sync.RWMutex
-	requestToBlocks map[string][]uint64  // request id -> array of it blocks (block hashes)
-	usedBlocks      map[uint64]int       // block hash -> reference count
-	unusedBlocks    map[uint64]time.Time // block hash -> last usage timestamp
-	maxBlocks       int                  // maximum number of blocks in the cache
-	eventSender     *KVEventSender       // emmits kv events
-	eventChan       chan EventData       // channel for asynchronous event processing
-	usageChan       chan float64         // channel for usage reporting
-	logger          logr.Logger
+	requestToBlocks map[string][]uint64      // request id -> array of it blocks (block hashes)
+	usedBlocks      map[uint64]int           // block hash -> reference count
+	unusedBlocks    map[uint64]time.Time     // block hash -> last usage timestamp
+	retainedUntil   map[uint64]time.Time     // block hash -> end of the block's retention period
+	retentions      map[string]time.Duration // request id -> retention period of its blocks after the request ends
+	maxBlocks       int                      // maximum number of blocks in the cache
+	eventSender     *KVEventSender           // emmits kv events
+	eventChan       chan EventData           // channel for asynchronous event processing
+	usageChan       chan float64             // channel for usage reporting
+	// retentionOverrides is the number of retained blocks that were evicted because of capacity pressure
+	retentionOverrides atomic.Int64
+	logger             logr.Logger
 }
 
 // newBlockCache creates a new blockCache with the specified maximum number of blocks
@@ -57,15 +62,25 @@ func newBlockCache(config *common.Configuration, logger logr.Logger, usageChan c
 			return nil, err
 		}
 	}
+	var recorder *kvEventsRecorder
+	if config.KVEventsRecordFile != "" {
+		recorder, err = newKVEventsRecorder(config.KVEventsRecordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kv-cache events record file: %w", err)
+		}
+		logger.Info("Recording kv-cache events", "file", config.KVEventsRecordFile)
+	}
 
 	return &blockCache{
 		requestToBlocks: make(map[string][]uint64),
 		usedBlocks:      make(map[uint64]int),
 		unusedBlocks:    make(map[uint64]time.Time),
+		retainedUntil:   make(map[uint64]time.Time),
+		retentions:      make(map[string]time.Duration),
 		maxBlocks:       config.KVCacheSize,
 		eventChan:       eChan,
 		usageChan:       usageChan,
-		eventSender:     NewKVEventSender(publisher, createTopic(config), eChan, config.EventBatchSize, delay, logger),
+		eventSender:     NewKVEventSender(publisher, recorder, createTopic(config), eChan, config.EventBatchSize, delay, logger),
 		logger:          logger,
 	}, nil
 }
@@ -78,8 +93,9 @@ func (b *blockCache) start(ctx context.Context) {
 }
 
 // startRequest adds a request with its associated block hashes to the cache
-// and returns the number of blocks that were already in the cache
-func (bc *blockCache) startRequest(requestID string, blocks []uint64) (int, error) {
+// and returns the number of blocks that were already in the cache,
+// the blocks are protected from eviction for the retention period after the request ends
+func (bc *blockCache) startRequest(requestID string, blocks []uint64, retention time.Duration) (int, error) {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
@@ -113,33 +129,28 @@ func (bc *blockCache) startRequest(requestID string, blocks []uint64) (int, erro
 		return 0, errors.New(capacityError)
 	}
 
+	now := time.Now()
 	// for blocks that are already in use - update the reference
 	for _, block := range blockAreadyInUse {
 		bc.usedBlocks[block] += 1
+		bc.dropExpiredRetention(block, now)
 	}
 
 	// for block used in the past - move them to the used blocks collection
 	for _, block := range blockToMoveToUsed {
 		bc.usedBlocks[block] = 1
 		delete(bc.unusedBlocks, block)
+		bc.dropExpiredRetention(block, now)
 	}
 
 	// for new block - add them, if there is no empty slots - evict the oldest block
 	for _, block := range blocksToAdd {
 		if len(bc.usedBlocks)+len(bc.unusedBlocks) == bc.maxBlocks {
 			// cache is full but contains unused blocks - evict the oldest
-			var oldestUnusedHash uint64
-			oldestUnusedTime := time.Now()
-
-			for hash, t := range bc.unusedBlocks {
-				if t.Before(oldestUnusedTime) {
-					oldestUnusedHash = hash
-					oldestUnusedTime = t
-				}
-			}
-
-			delete(bc.unusedBlocks, oldestUnusedHash)
-			bc.eventChan <- EventData{action: eventActionRemove, hashValues: []uint64{oldestUnusedHash}}
+			evictedHash := bc.selectBlockToEvict()
+			delete(bc.unusedBlocks, evictedHash)
+			delete(bc.retainedUntil, evictedHash)
+			bc.eventChan <- EventData{action: eventActionRemove, hashValues: []uint64{evictedHash}}
 		}
 
 		// Add the new block
@@ -150,6 +161,9 @@ func (bc *blockCache) startRequest(requestID string, blocks []uint64) (int, erro
 	// store the request mapping
 	bc.requestToBlocks[requestID] = make([]uint64, len(blocks))
 	copy(bc.requestToBlocks[requestID], blocks)
+	if retention > 0 {
+		bc.retentions[requestID] = retention
+	}
 
 	if bc.usageChan != nil {
 		bc.usageChan <- float64(len(bc.usedBlocks)) / float64(bc.maxBlocks)
@@ -169,6 +183,7 @@ func (bc *blockCache) finishRequest(requestID string) error {
 	}
 
 	now := time.Now()
+	retention := bc.retentions[requestID]
 
 	// Decrease reference count for each block
 	errBlocks := make([]uint64, 0)
@@ -182,6 +197,13 @@ func (bc *blockCache) finishRequest(requestID string) error {
 				bc.unusedBlocks[blockHash] = now
 				delete(bc.usedBlocks, blockHash)
 			}
+			bc.dropExpiredRetention(blockHash, now)
+			if retention > 0 {
+				// protect the block from eviction, a longer retention of another request is kept
+				if until := now.Add(retention); until.After(bc.retainedUntil[blockHash]) {
+					bc.retainedUntil[blockHash] = until
+				}
+			}
 		} else {
 			errBlocks = append(errBlocks, blockHash)
 		}
@@ -193,6 +215,7 @@ func (bc *blockCache) finishRequest(requestID string) error {
 
 	// Remove the request mapping
 	delete(bc.requestToBlocks, requestID)
+	delete(bc.retentions, requestID)
 
 	if len(errBlocks) > 0 {
 		errMsg := "Not existing blocks "
@@ -205,6 +228,49 @@ func (bc *blockCache) finishRequest(requestID string) error {
 	return nil
 }
 
+// dropExpiredRetention removes the retention of the given block if its retention period is over
+func (bc *blockCache) dropExpiredRetention(blockHash uint64, now time.Time) {
+	if until, retained := bc.retainedUntil[blockHash]; retained && !until.After(now) {
+		delete(bc.retainedUntil, blockHash)
+	}
+}
+
+// selectBlockToEvict returns the least recently used unused block that is not retained,
+// if all the unused blocks are retained, the block whose retention ends first is returned
+// as a last resort and the retention override is counted
+func (bc *blockCache) selectBlockToEvict() uint64 {
+	now := time.Now()
+	var oldestUnusedHash, firstExpiringHash uint64
+	var oldestUnusedTime, firstExpiringTime time.Time
+	foundUnretained := false
+
+	for hash, t := range bc.unusedBlocks {
+		if until, retained := bc.retainedUntil[hash]; retained {
+			if until.After(now) {
+				if firstExpiringTime.IsZero() || until.Before(firstExpiringTime) {
+					firstExpiringHash = hash
+					firstExpiringTime = until
+				}
+				continue
+			}
+			// the retention period is over
+			delete(bc.retainedUntil, hash)
+		}
+		if !foundUnretained || t.Before(oldestUnusedTime) {
+			oldestUnusedHash = hash
+			oldestUnusedTime = t
+			foundUnretained = true
+		}
+	}
+
+	if foundUnretained {
+		return oldestUnusedHash
+	}
+	bc.retentionOverrides.Add(1)
+	bc.logger.Info("KV cache - evicting a retained block because of capacity pressure", "block", firstExpiringHash)
+	return firstExpiringHash
+}
+
 // GetStats returns current cache statistics (for testing/debugging)
 func (bc *blockCache) getStats() (int, int, int) {
 	bc.mu.RLock()
@@ -19,6 +19,7 @@ package kvcache
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/go-logr/logr"
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
@@ -33,6 +34,9 @@ type KVCacheHelper struct {
 	logger          logr.Logger
 	blockCache      *blockCache
 	blockSize       int
+	// tokenizationFallbacks is the number of requests processed without the kv cache because
+	// their prompt tokenization failed
+	tokenizationFallbacks atomic.Int64
 }
 
 func NewKVCacheHelper(config *common.Configuration, logger logr.Logger, usageChan chan float64,
@@ -65,14 +69,21 @@ func (h *KVCacheHelper) Run(ctx context.Context) {
 func (h *KVCacheHelper) OnRequestStart(vllmReq openaiserverapi.CompletionRequest) error {
 	h.logger.Info("KV cache - process request")
 
-	prompt := vllmReq.GetPrompt()
+	// the messages of a chat completion are rendered by the chat template, so the prompts of
+	// the turns of a conversation share their prefix
+	prompt := vllmReq.GetRenderedPrompt()
 	modelName := vllmReq.GetModel()
 	requestID := vllmReq.GetRequestID()
 
 	// tokenize the input
 	tokens, _, err := h.tokenizer.Encode(prompt, modelName)
 	if err != nil {
-		h.logger.Info("Prompt tokenization failed", "error", err.Error())
+		// fall back to processing the request without cached blocks, the request is still
+		// registered in order to be finished normally
+		h.logger.Info("Prompt tokenization failed, the request is processed without the kv cache", "error", err.Error())
+		h.tokenizationFallbacks.Add(1)
+		_, err = h.blockCache.startRequest(requestID, []uint64{}, vllmReq.GetRetainKVDuration())
+		vllmReq.SetNumberOfCachedPromptTokens(0)
 		return err
 	}
 
@@ -85,7 +96,7 @@ func (h *KVCacheHelper) OnRequestStart(vllmReq openaiserverapi.CompletionRequest
 		blockHashes[i] = key.ChunkHash
 	}
 
-	nBlocksAlreadyInCache, err := h.blockCache.startRequest(requestID, blockHashes)
+	nBlocksAlreadyInCache, err := h.blockCache.startRequest(requestID, blockHashes, vllmReq.GetRetainKVDuration())
 	vllmReq.SetNumberOfCachedPromptTokens(nBlocksAlreadyInCache * h.blockSize)
 	return err
 }
@@ -93,3 +104,37 @@ func (h *KVCacheHelper) OnRequestStart(vllmReq openaiserverapi.CompletionRequest
 func (h *KVCacheHelper) OnRequestEnd(requestID string) error {
 	return h.blockCache.finishRequest(requestID)
 }
+
+// GetTokenizationFallbacks returns the number of requests processed without the kv cache because
+// their prompt tokenization failed
+func (h *KVCacheHelper) GetTokenizationFallbacks() int64 {
+	return h.tokenizationFallbacks.Load()
+}
+
+// GetRetentionOverrides returns the number of retained blocks that were evicted because of capacity pressure
+func (h *KVCacheHelper) GetRetentionOverrides() int64 {
+	return h.blockCache.retentionOverrides.Load()
+}
+
+// BlockUsage is the occupancy of the kv cache blocks
+type BlockUsage struct {
+	// ActiveRequests is the number of requests that use blocks of the kv cache
+	ActiveRequests int `json:"active_requests"`
+	// UsedBlocks is the number of blocks used by the active requests
+	UsedBlocks int `json:"used_blocks"`
+	// UnusedBlocks is the number of blocks in the cache that are not used by any request
+	UnusedBlocks int `json:"unused_blocks"`
+	// MaxBlocks is the capacity of the kv cache in blocks
+	MaxBlocks int `json:"max_blocks"`
+}
+
+// GetBlockUsage returns the current occupancy of the kv cache blocks
+func (h *KVCacheHelper) GetBlockUsage() BlockUsage {
+	activeRequests, totalBlocks, unusedBlocks := h.blockCache.getStats()
+	return BlockUsage{
+		ActiveRequests: activeRequests,
+		UsedBlocks:     totalBlocks - unusedBlocks,
+		UnusedBlocks:   unusedBlocks,
+		MaxBlocks:      h.blockCache.maxBlocks,
+	}
+}
@@ -39,7 +39,9 @@ type EventData struct {
 }
 
 type KVEventSender struct {
-	publisher    *common.Publisher
+	publisher *common.Publisher
+	// recorder writes the event batches to the record file, nil if the events are not recorded
+	recorder     *kvEventsRecorder
 	topic        string
 	eventChan    chan EventData
 	maxBatchSize int
@@ -48,10 +50,11 @@ type KVEventSender struct {
 	logger       logr.Logger
 }
 
-func NewKVEventSender(publisher *common.Publisher, topic string, ch chan EventData, maxBatchSize int,
-	delay time.Duration, logger logr.Logger) *KVEventSender {
+func NewKVEventSender(publisher *common.Publisher, recorder *kvEventsRecorder, topic string, ch chan EventData,
+	maxBatchSize int, delay time.Duration, logger logr.Logger) *KVEventSender {
 	return &KVEventSender{
 		publisher:    publisher,
+		recorder:     recorder,
 		topic:        topic,
 		eventChan:    ch,
 		maxBatchSize: maxBatchSize,
@@ -64,6 +67,13 @@ func NewKVEventSender(publisher *common.Publisher, topic string, ch chan EventDa
 func (s *KVEventSender) Run(ctx context.Context) error {
 	timer := time.NewTimer(s.delay)
 	defer timer.Stop()
+	if s.recorder != nil {
+		defer func() {
+			if err := s.recorder.close(); err != nil {
+				s.logger.Error(err, "failed to close kv-cache events record file")
+			}
+		}()
+	}
 
 	for {
 		select {
@@ -83,7 +93,7 @@ func (s *KVEventSender) Run(ctx context.Context) error {
 				return nil
 			}
 
-			if s.publisher == nil {
+			if s.publisher == nil && s.recorder == nil {
 				continue
 			}
 
@@ -119,7 +129,7 @@ func (s *KVEventSender) Run(ctx context.Context) error {
 			}
 
 		case <-timer.C:
-			if s.publisher == nil {
+			if s.publisher == nil && s.recorder == nil {
 				continue
 			}
 			if err := s.publishHelper(ctx); err != nil {
@@ -143,7 +153,20 @@ func (s *KVEventSender) publishHelper(ctx context.Context) error {
 		DataParallelRank: &dpRank,
 	}
 
-	err := s.publisher.PublishEvent(ctx, s.topic, eventBatch)
+	var err error
+	if s.publisher != nil {
+		err = s.publisher.PublishEvent(ctx, s.topic, eventBatch)
+	}
+	if s.recorder != nil {
+		// the batch is recorded as it is published, the encoding is deterministic
+		payload, recordErr := common.EncodeEventBatch(eventBatch)
+		if recordErr == nil {
+			recordErr = s.recorder.record(s.topic, payload, time.Now())
+		}
+		if recordErr != nil {
+			s.logger.Error(recordErr, "failed to record kv-cache event batch")
+		}
+	}
 
 	// reset batch
 	s.batch = make([]msgpack.RawMessage, 0, s.maxBatchSize)
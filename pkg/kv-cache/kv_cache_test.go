@@ -19,14 +19,20 @@ package kvcache
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/daulet/tokenizers"
 	zmq "github.com/pebbe/zmq4"
 	"github.com/vmihailenco/msgpack/v5"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 	"github.com/llm-d/llm-d-kv-cache-manager/pkg/kvcache/kvevents"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -237,7 +243,7 @@ var _ = Describe("KV cache", Ordered, func() {
 						var err error
 						switch action.action {
 						case actionStartRequest:
-							_, err = blockCache.startRequest(action.request.id, action.request.blocks)
+							_, err = blockCache.startRequest(action.request.id, action.request.blocks, 0)
 						case actionFinishRequest:
 							err = blockCache.finishRequest(action.request.id)
 						}
@@ -344,19 +350,19 @@ var _ = Describe("KV cache", Ordered, func() {
 				req4 := testRequest{"req4", []uint64{5, 6}}
 
 				// blocks 1 and 2 stored
-				alreadyInCache, err := blockCache.startRequest(req1.id, req1.blocks)
+				alreadyInCache, err := blockCache.startRequest(req1.id, req1.blocks, 0)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(alreadyInCache).To(Equal(0))
 				// blocks 3 and 4 stored
-				alreadyInCache, err = blockCache.startRequest(req2.id, req2.blocks)
+				alreadyInCache, err = blockCache.startRequest(req2.id, req2.blocks, 0)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(alreadyInCache).To(Equal(0))
 				// no new blocks stored, reuse of 1 and 3
-				alreadyInCache, err = blockCache.startRequest(req3.id, req3.blocks)
+				alreadyInCache, err = blockCache.startRequest(req3.id, req3.blocks, 0)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(alreadyInCache).To(Equal(2))
 				// no space left - should fail
-				alreadyInCache, err = blockCache.startRequest(req4.id, req4.blocks)
+				alreadyInCache, err = blockCache.startRequest(req4.id, req4.blocks, 0)
 				Expect(err).To(HaveOccurred())
 				Expect(alreadyInCache).To(Equal(0))
 
@@ -367,7 +373,7 @@ var _ = Describe("KV cache", Ordered, func() {
 				// now 2 and 4 are not in use
 
 				// blocks 2 and 4 should be removed, and 5 and 6 stored
-				alreadyInCache, err = blockCache.startRequest(req4.id, req4.blocks)
+				alreadyInCache, err = blockCache.startRequest(req4.id, req4.blocks, 0)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(alreadyInCache).To(Equal(0))
 			}()
@@ -393,6 +399,101 @@ var _ = Describe("KV cache", Ordered, func() {
 
 	})
 
+	Context("retention", func() {
+		newRetentionCache := func(cacheSize int) *blockCache {
+			config := &common.Configuration{
+				Port:           1234,
+				Model:          "model",
+				KVCacheSize:    cacheSize,
+				EventBatchSize: 1,
+			}
+			blockCache, err := newBlockCache(config, GinkgoLogr, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return blockCache
+		}
+
+		runRequest := func(blockCache *blockCache, id string, blocks []uint64, retention time.Duration) {
+			_, err := blockCache.startRequest(id, blocks, retention)
+			Expect(err).NotTo(HaveOccurred())
+			err = blockCache.finishRequest(id)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		expectBlocksInCache := func(blockCache *blockCache, blocks []uint64, inCache bool) {
+			for _, block := range blocks {
+				_, exists := blockCache.getBlockInfo(block)
+				Expect(exists).To(Equal(inCache), fmt.Sprintf("block %d", block))
+			}
+		}
+
+		It("should evict unretained blocks before retained ones", func() {
+			blockCache := newRetentionCache(4)
+			// the retained blocks are the least recently used
+			runRequest(blockCache, req1ID, []uint64{1, 2}, time.Minute)
+			runRequest(blockCache, req2ID, []uint64{3, 4}, 0)
+
+			runRequest(blockCache, req3ID, []uint64{5, 6}, 0)
+			expectBlocksInCache(blockCache, []uint64{1, 2, 5, 6}, true)
+			expectBlocksInCache(blockCache, []uint64{3, 4}, false)
+
+			// more requests keep replacing the unretained blocks
+			runRequest(blockCache, "req4", []uint64{7, 8}, 0)
+			expectBlocksInCache(blockCache, []uint64{1, 2, 7, 8}, true)
+			expectBlocksInCache(blockCache, []uint64{5, 6}, false)
+			Expect(blockCache.retentionOverrides.Load()).To(BeZero())
+		})
+
+		It("should make the blocks evictable when the retention period is over", func() {
+			blockCache := newRetentionCache(4)
+			runRequest(blockCache, req1ID, []uint64{1, 2}, 100*time.Millisecond)
+			runRequest(blockCache, req2ID, []uint64{3, 4}, 0)
+
+			time.Sleep(200 * time.Millisecond)
+			runRequest(blockCache, req3ID, []uint64{5, 6}, 0)
+			expectBlocksInCache(blockCache, []uint64{3, 4, 5, 6}, true)
+			expectBlocksInCache(blockCache, []uint64{1, 2}, false)
+			Expect(blockCache.retentionOverrides.Load()).To(BeZero())
+		})
+
+		It("should keep the longest retention of a block shared by several requests", func() {
+			blockCache := newRetentionCache(4)
+			runRequest(blockCache, req1ID, []uint64{1, 2}, time.Minute)
+			runRequest(blockCache, req2ID, []uint64{1, 2}, 50*time.Millisecond)
+			runRequest(blockCache, req3ID, []uint64{3, 4}, 0)
+
+			time.Sleep(100 * time.Millisecond)
+			runRequest(blockCache, "req4", []uint64{5, 6}, 0)
+			expectBlocksInCache(blockCache, []uint64{1, 2, 5, 6}, true)
+			expectBlocksInCache(blockCache, []uint64{3, 4}, false)
+		})
+
+		It("should drop the expired retentions of the reused blocks", func() {
+			blockCache := newRetentionCache(4)
+			runRequest(blockCache, req1ID, []uint64{1, 2}, 50*time.Millisecond)
+			Expect(blockCache.retainedUntil).To(HaveLen(2))
+
+			time.Sleep(100 * time.Millisecond)
+			// the blocks are reused while the cache is not full, so there is no eviction scan
+			runRequest(blockCache, req2ID, []uint64{1}, 0)
+			Expect(blockCache.retainedUntil).To(HaveLen(1))
+			_, err := blockCache.startRequest(req3ID, []uint64{2}, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(blockCache.retainedUntil).To(BeEmpty())
+			Expect(blockCache.finishRequest(req3ID)).To(Succeed())
+		})
+
+		It("should evict retained blocks under capacity pressure and count the overrides", func() {
+			blockCache := newRetentionCache(3)
+			runRequest(blockCache, req1ID, []uint64{1, 2, 3}, time.Minute)
+
+			runRequest(blockCache, req2ID, []uint64{4, 5}, 0)
+			expectBlocksInCache(blockCache, []uint64{4, 5}, true)
+			_, totalBlocks, _ := blockCache.getStats()
+			Expect(totalBlocks).To(Equal(3))
+			Expect(blockCache.retentionOverrides.Load()).To(Equal(int64(2)))
+		})
+	})
+
 	Context("thread safety", func() {
 		testCases := []threadTestCase{{
 			name:              "run add/remove requests in parallel, use partial cache",
@@ -436,7 +537,7 @@ var _ = Describe("KV cache", Ordered, func() {
 							reqID := fmt.Sprintf("req_%d_%d", id, j)
 							blocks := createRandomArray(testCase.minBlockLen, testCase.maxBlockLen, testCase.maxHashValue)
 
-							_, err := blockCache.startRequest(reqID, blocks)
+							_, err := blockCache.startRequest(reqID, blocks, 0)
 							if err != nil {
 								// some operations may fail due to cache being full, which is expected
 								Expect(err.Error()).To(Equal(capacityError))
@@ -531,6 +632,78 @@ func parseEvent(parts [][]byte, expectedTopic string, expectedSeq uint64) ([]uin
 	return stored, removed
 }
 
+// failingTokenizer is a tokenizer that always fails
+type failingTokenizer struct{}
+
+func (failingTokenizer) Encode(_, _ string) ([]uint32, []tokenizers.Offset, error) {
+	return nil, nil, errors.New("tokenizer is unavailable")
+}
+
+// wordTokenizer is a tokenizer that returns a token for every word of the input
+type wordTokenizer struct{}
+
+func (wordTokenizer) Encode(input, _ string) ([]uint32, []tokenizers.Offset, error) {
+	words := strings.Fields(input)
+	tokens := make([]uint32, len(words))
+	for i, word := range words {
+		tokens[i] = uint32(len(word))
+	}
+	return tokens, nil, nil
+}
+
+var _ = Describe("KV cache helper", func() {
+	It("should process the request without cached blocks when the tokenization fails", func() {
+		config := &common.Configuration{
+			Port:           1234,
+			Model:          "model",
+			KVCacheSize:    16,
+			TokenBlockSize: 16,
+			EventBatchSize: 1,
+		}
+		helper, err := NewKVCacheHelper(config, GinkgoLogr, nil, failingTokenizer{})
+		Expect(err).NotTo(HaveOccurred())
+
+		req := &openaiserverapi.TextCompletionRequest{Prompt: "This is a test"}
+		req.RequestID = req1ID
+		Expect(helper.OnRequestStart(req)).To(Succeed())
+		Expect(req.GetNumberOfCachedPromptTokens()).To(BeZero())
+		Expect(helper.GetTokenizationFallbacks()).To(Equal(int64(1)))
+		Expect(helper.OnRequestEnd(req1ID)).To(Succeed())
+	})
+
+	It("should reuse the blocks of the previous turns of a chat conversation", func() {
+		config := &common.Configuration{
+			Port:           1234,
+			Model:          "model",
+			KVCacheSize:    16,
+			TokenBlockSize: 4,
+			EventBatchSize: 1,
+		}
+		helper, err := NewKVCacheHelper(config, GinkgoLogr, nil, wordTokenizer{})
+		Expect(err).NotTo(HaveOccurred())
+
+		firstTurn := []openaiserverapi.Message{
+			{Role: openaiserverapi.RoleSystem, Content: openaiserverapi.Content{Raw: "You are a helpful assistant"}},
+			{Role: openaiserverapi.RoleUser, Content: openaiserverapi.Content{Raw: "What is the capital of France"}},
+		}
+		req1 := &openaiserverapi.ChatCompletionRequest{Messages: firstTurn}
+		req1.RequestID = req1ID
+		Expect(helper.OnRequestStart(req1)).To(Succeed())
+		Expect(req1.GetNumberOfCachedPromptTokens()).To(BeZero())
+		Expect(helper.OnRequestEnd(req1ID)).To(Succeed())
+
+		secondTurn := append(firstTurn,
+			openaiserverapi.Message{Role: openaiserverapi.RoleAssistant, Content: openaiserverapi.Content{Raw: "Paris"}},
+			openaiserverapi.Message{Role: openaiserverapi.RoleUser, Content: openaiserverapi.Content{Raw: "And of Italy"}})
+		req2 := &openaiserverapi.ChatCompletionRequest{Messages: secondTurn}
+		req2.RequestID = req2ID
+		Expect(helper.OnRequestStart(req2)).To(Succeed())
+		Expect(req2.GetNumberOfCachedPromptTokens()).To(BeNumerically(">", 0))
+		Expect(req2.GetNumberOfCachedPromptTokens() % config.TokenBlockSize).To(BeZero())
+		Expect(helper.OnRequestEnd(req2ID)).To(Succeed())
+	})
+})
+
 func createSub(config *common.Configuration) (*zmq.Socket, string) {
 	zctx, err := zmq.NewContext()
 	Expect(err).NotTo(HaveOccurred())
@@ -547,3 +720,96 @@ func createSub(config *common.Configuration) (*zmq.Socket, string) {
 	Expect(err).NotTo(HaveOccurred())
 	return sub, topic
 }
+
+// fakeKVEventsPublisher keeps the published topics and payloads
+type fakeKVEventsPublisher struct {
+	topics   []string
+	payloads [][]byte
+}
+
+func (p *fakeKVEventsPublisher) PublishPayload(topic string, payload []byte) error {
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+var _ = Describe("KV cache events record", func() {
+	It("should record the event batches and replay them", func() {
+		recordFile := filepath.Join(GinkgoT().TempDir(), "kv-events.jsonl")
+		config := &common.Configuration{
+			Port:               1234,
+			Model:              "model",
+			KVCacheSize:        4,
+			EventBatchSize:     1,
+			KVEventsRecordFile: recordFile,
+		}
+		topic := createTopic(config)
+		blockCache, err := newBlockCache(config, GinkgoLogr, nil)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			blockCache.start(ctx)
+			close(done)
+		}()
+
+		_, err = blockCache.startRequest(req1ID, []uint64{1, 2}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(blockCache.finishRequest(req1ID)).To(Succeed())
+		_, err = blockCache.startRequest(req2ID, []uint64{3, 4, 5}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() []string {
+			data, err := os.ReadFile(recordFile)
+			Expect(err).NotTo(HaveOccurred())
+			return strings.Fields(string(data))
+		}, time.Second).Should(HaveLen(6))
+		cancel()
+		<-done
+
+		records, err := LoadKVEventsRecordFile(recordFile)
+		Expect(err).NotTo(HaveOccurred())
+		// every block is stored or removed in its own event
+		Expect(records).To(HaveLen(6))
+		var stored, removed []uint64
+		for i, record := range records {
+			Expect(record.Topic).To(Equal(topic))
+			Expect(record.Timestamp).NotTo(BeEmpty())
+			if i > 0 {
+				Expect(record.OffsetMs).To(BeNumerically(">=", records[i-1].OffsetMs))
+			}
+			seqBytes := make([]byte, 8)
+			binary.BigEndian.PutUint64(seqBytes, uint64(i+1))
+			batchStored, batchRemoved := parseEvent([][]byte{[]byte(record.Topic), seqBytes, record.Payload},
+				topic, uint64(i+1))
+			stored = append(stored, batchStored...)
+			removed = append(removed, batchRemoved...)
+		}
+		// one of the unused blocks of the first request is evicted for the second request
+		Expect(stored).To(Equal([]uint64{1, 2, 3, 4, 5}))
+		Expect(removed).To(HaveLen(1))
+		Expect(removed[0]).To(BeElementOf(uint64(1), uint64(2)))
+
+		publisher := &fakeKVEventsPublisher{}
+		published, err := ReplayKVEvents(context.Background(), publisher, records, 100, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(published).To(Equal(6))
+		Expect(publisher.topics).To(HaveLen(6))
+		Expect(publisher.topics).To(HaveEach(topic))
+		for i, record := range records {
+			Expect(publisher.payloads[i]).To(Equal(record.Payload))
+		}
+
+		publisher = &fakeKVEventsPublisher{}
+		_, err = ReplayKVEvents(context.Background(), publisher, records[:1], 1, "kv@other")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(publisher.topics).To(Equal([]string{"kv@other"}))
+	})
+
+	It("should reject an invalid record file", func() {
+		recordFile := filepath.Join(GinkgoT().TempDir(), "kv-events.jsonl")
+		Expect(os.WriteFile(recordFile, []byte(`{"offset_ms":0,"topic":"kv","payload":"AQ=="}`+"\n"+
+			`{"offset_ms":10,"topic":"kv"}`+"\n"), 0o600)).To(Succeed())
+		_, err := LoadKVEventsRecordFile(recordFile)
+		Expect(err).To(MatchError(ContainSubstring("invalid line 2")))
+	})
+})
@@ -0,0 +1,152 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Recording of the published kv-cache events and their replay
+package kvcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// KVEventsRecord is a recorded kv-cache event batch, a line of the kv events record file
+type KVEventsRecord struct {
+	// OffsetMs is the time since the start of the recording at which the batch was published, in milliseconds
+	OffsetMs int64 `json:"offset_ms"`
+	// Timestamp is the time the batch was published, it is not used by the replay
+	Timestamp string `json:"timestamp,omitempty"`
+	// Topic is the topic the batch was published to
+	Topic string `json:"topic"`
+	// Payload is the msgpack encoded batch as it was published, base64 encoded in the file
+	Payload []byte `json:"payload"`
+}
+
+// KVEventsPublisher publishes encoded event batches to a topic
+type KVEventsPublisher interface {
+	PublishPayload(topic string, payload []byte) error
+}
+
+// kvEventsRecorder writes the published event batches to the record file, it is used by the
+// goroutine of the event sender only
+type kvEventsRecorder struct {
+	file  *os.File
+	start time.Time
+}
+
+// newKVEventsRecorder creates the record file, an existing file is overwritten
+func newKVEventsRecorder(path string) (*kvEventsRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &kvEventsRecorder{file: file, start: time.Now()}, nil
+}
+
+// record writes the encoded batch published to the topic at the given time
+func (r *kvEventsRecorder) record(topic string, payload []byte, now time.Time) error {
+	data, err := json.Marshal(KVEventsRecord{
+		OffsetMs:  now.Sub(r.start).Milliseconds(),
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+		Topic:     topic,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+func (r *kvEventsRecorder) close() error {
+	return r.file.Close()
+}
+
+// LoadKVEventsRecordFile reads the event batches of a record file sorted by their offset
+func LoadKVEventsRecordFile(path string) ([]KVEventsRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	records := make([]KVEventsRecord, 0)
+	scanner := bufio.NewScanner(file)
+	// a line contains a whole batch
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record KVEventsRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("invalid line %d of %s: %w", lineNumber, path, err)
+		}
+		if record.OffsetMs < 0 || record.Topic == "" || len(record.Payload) == 0 {
+			return nil, fmt.Errorf("invalid line %d of %s: offset_ms cannot be negative, topic and payload are required",
+				lineNumber, path)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].OffsetMs < records[j].OffsetMs
+	})
+	return records, nil
+}
+
+// ReplayKVEvents publishes the recorded event batches at their offsets from now divided by the speed,
+// to their recorded topic or to the given topic if it is not empty. Returns the number of published
+// batches, the replay stops when the context is done or a batch cannot be published
+func ReplayKVEvents(ctx context.Context, publisher KVEventsPublisher, records []KVEventsRecord, speed float64,
+	topic string) (int, error) {
+	if speed <= 0 {
+		return 0, errors.New("replay speed must be positive")
+	}
+	start := time.Now()
+	for i, record := range records {
+		offset := time.Duration(float64(record.OffsetMs) / speed * float64(time.Millisecond))
+		timer := time.NewTimer(time.Until(start.Add(offset)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return i, ctx.Err()
+		case <-timer.C:
+		}
+		recordTopic := record.Topic
+		if topic != "" {
+			recordTopic = topic
+		}
+		if err := publisher.PublishPayload(recordTopic, record.Payload); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Structured access log of the HTTP requests
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// accessLogEntryKey is the user value of the request context that holds the access log entry of a logged request
+const accessLogEntryKey = "accessLogEntry"
+
+// accessLogger writes the access log entries, a JSON object per line
+type accessLogger struct {
+	mutex sync.Mutex
+	// writer is the access log file or the standard output, nil after the access log stopped
+	writer io.Writer
+}
+
+// accessLogEntry is the access log entry of an HTTP request
+type accessLogEntry struct {
+	// mutex protects the entry, the completion fields are set by the goroutine that sends a streamed response
+	mutex   sync.Mutex
+	start   time.Time
+	written bool
+
+	// Timestamp is the time the request was received
+	Timestamp     string  `json:"timestamp"`
+	Method        string  `json:"method"`
+	Endpoint      string  `json:"endpoint"`
+	Status        int     `json:"status"`
+	RequestBytes  int     `json:"request_bytes"`
+	ResponseBytes int64   `json:"response_bytes"`
+	DurationMs    float64 `json:"duration_ms"`
+	// the fields of a completion request are added when the request ends, nil if the request was not processed
+	*accessLogCompletion
+}
+
+// accessLogCompletion are the fields of the access log entry of a processed completion request
+type accessLogCompletion struct {
+	RequestID        string `json:"request_id"`
+	Model            string `json:"model"`
+	Stream           bool   `json:"stream"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	// QueueTimeMs and TTFTMs are simulated times, zero if the request didn't start or generated no token
+	QueueTimeMs   float64  `json:"queue_time_ms"`
+	TTFTMs        float64  `json:"ttft_ms"`
+	FinishReasons []string `json:"finish_reasons"`
+	// ServiceTier is the service tier the request was processed in, empty if the request didn't ask for one
+	ServiceTier string `json:"service_tier,omitempty"`
+}
+
+// accessLogStream is the body stream of a logged streamed response, it counts the streamed bytes
+// and writes the access log entry when the server closes it after the response was sent
+type accessLogStream struct {
+	io.ReadCloser
+	nBytes  int64
+	onClose func(nBytes int64)
+}
+
+func (a *accessLogStream) Read(p []byte) (int, error) {
+	n, err := a.ReadCloser.Read(p)
+	a.nBytes += int64(n)
+	return n, err
+}
+
+func (a *accessLogStream) Close() error {
+	err := a.ReadCloser.Close()
+	a.onClose(a.nBytes)
+	return err
+}
+
+// startAccessLog opens the access log, if enabled, the access log stops when the context is done
+func (s *VllmSimulator) startAccessLog(ctx context.Context) error {
+	if !s.config.EnableAccessLog {
+		return nil
+	}
+	if s.config.AccessLogFile == "" {
+		s.accessLog = &accessLogger{writer: os.Stdout}
+		s.logger.Info("Writing the access log to the standard output")
+		return nil
+	}
+	file, err := os.OpenFile(s.config.AccessLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	logger := &accessLogger{writer: file}
+	s.accessLog = logger
+	s.logger.Info("Writing the access log", "file", s.config.AccessLogFile)
+
+	go func() {
+		<-ctx.Done()
+		logger.mutex.Lock()
+		defer logger.mutex.Unlock()
+		if err := file.Close(); err != nil {
+			s.logger.Error(err, "failed to close access log file")
+		}
+		logger.writer = nil
+	}()
+	return nil
+}
+
+// withAccessLog returns the handler of the given endpoint that writes the access log entries of its requests,
+// a request is logged with the probability of the access log sample rate
+func (s *VllmSimulator) withAccessLog(endpoint string, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if s.accessLog == nil || (s.config.AccessLogSampleRate < 100 && !s.random.Bool(s.config.AccessLogSampleRate)) {
+			handler(ctx)
+			return
+		}
+		entry := &accessLogEntry{
+			start:        time.Now(),
+			Timestamp:    s.externalNow().UTC().Format(time.RFC3339Nano),
+			Method:       string(ctx.Method()),
+			Endpoint:     endpoint,
+			RequestBytes: len(ctx.Request.Body()),
+		}
+		ctx.SetUserValue(accessLogEntryKey, entry)
+		handler(ctx)
+
+		// the entry of a streamed response is written when the stream ends
+		if _, streamed := ctx.Response.BodyStream().(*accessLogStream); !streamed {
+			s.writeAccessLog(entry, ctx.Response.StatusCode(), int64(len(ctx.Response.Body())))
+		}
+	}
+}
+
+// getAccessLogEntry returns the access log entry of the request, nil if the request is not logged
+func getAccessLogEntry(ctx *fasthttp.RequestCtx) *accessLogEntry {
+	entry, _ := ctx.UserValue(accessLogEntryKey).(*accessLogEntry)
+	return entry
+}
+
+// setBodyStreamWriter sets the writer of a streamed response, the streamed bytes are counted
+// for the access log if the request is logged and reach the client after the network latency
+func (s *VllmSimulator) setBodyStreamWriter(ctx *fasthttp.RequestCtx, sw fasthttp.StreamWriter) {
+	sw = s.withNetworkDelay(sw)
+	entry := getAccessLogEntry(ctx)
+	if entry == nil {
+		ctx.SetBodyStreamWriter(sw)
+		return
+	}
+	ctx.Response.SetBodyStream(&accessLogStream{
+		ReadCloser: fasthttp.NewStreamReader(sw),
+		onClose: func(nBytes int64) {
+			s.writeAccessLog(entry, ctx.Response.StatusCode(), nBytes)
+		},
+	}, -1)
+}
+
+// logRequestEnd adds the fields of a completion request that has just ended to its access log entry
+func (s *VllmSimulator) logRequestEnd(requestID string, nCompletionTokens int, finishReasons []string) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	if req.accessLog == nil {
+		return
+	}
+	completion := &accessLogCompletion{
+		RequestID:        requestID,
+		Model:            s.getDisplayedModelName(req.model),
+		Stream:           req.stream,
+		PromptTokens:     req.promptTokens,
+		CompletionTokens: nCompletionTokens,
+		FinishReasons:    finishReasons,
+		ServiceTier:      req.serviceTier,
+	}
+	req.mutex.RLock()
+	if !req.startTime.IsZero() {
+		completion.QueueTimeMs = durationMs(s.virtualDuration(req.startTime.Sub(req.enqueueTime)))
+	}
+	if !req.firstTokenTime.IsZero() {
+		completion.TTFTMs = durationMs(s.virtualDuration(req.firstTokenTime.Sub(req.enqueueTime)))
+	}
+	req.mutex.RUnlock()
+
+	req.accessLog.mutex.Lock()
+	defer req.accessLog.mutex.Unlock()
+	req.accessLog.accessLogCompletion = completion
+}
+
+// writeAccessLog completes the access log entry with the status and the size of the response and writes it,
+// an entry is written once
+func (s *VllmSimulator) writeAccessLog(entry *accessLogEntry, status int, responseBytes int64) {
+	entry.mutex.Lock()
+	if entry.written {
+		entry.mutex.Unlock()
+		return
+	}
+	entry.written = true
+	entry.Status = status
+	entry.ResponseBytes = responseBytes
+	entry.DurationMs = durationMs(time.Since(entry.start))
+	data, err := json.Marshal(entry)
+	entry.mutex.Unlock()
+	if err != nil {
+		s.logger.Error(err, "failed to marshal access log entry")
+		return
+	}
+
+	s.accessLog.mutex.Lock()
+	defer s.accessLog.mutex.Unlock()
+	if s.accessLog.writer == nil {
+		return
+	}
+	if _, err := s.accessLog.writer.Write(append(data, '\n')); err != nil {
+		s.logger.Error(err, "failed to write access log entry")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// readAccessLog returns the entries of the access log file
+func readAccessLog(accessLogFile string) []map[string]any {
+	data, err := os.ReadFile(accessLogFile)
+	Expect(err).NotTo(HaveOccurred())
+	entries := make([]map[string]any, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+var _ = Describe("Access log", func() {
+	It("should log the requests with their sizes, tokens and latencies", func() {
+		ctx := context.TODO()
+		accessLogFile := filepath.Join(GinkgoT().TempDir(), "access.log")
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-access-log",
+			"--access-log-file", accessLogFile, "--time-to-first-token", "50", "--flex-tier-auto-rate", "100"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.ServiceTier = openaiserverapi.ServiceTierAuto
+		resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithHeader(requestIDHeader, "logged-request"))
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params = getOpenAIClentAndChatParams(client, model, userMessage, true)
+		params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+		stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+		var streamUsage openai.CompletionUsage
+		for stream.Next() {
+			if chunk := stream.Current(); chunk.Usage.TotalTokens != 0 {
+				streamUsage = chunk.Usage
+			}
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(stream.Close()).To(Succeed())
+
+		modelsResp, err := client.Get(baseURL + "/models")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(modelsResp.Body.Close()).To(Succeed())
+
+		// the entry of the streamed response is written after the stream ended
+		Eventually(func() []map[string]any { return readAccessLog(accessLogFile) }, time.Second).Should(HaveLen(3))
+		entries := readAccessLog(accessLogFile)
+		var chatEntries []map[string]any
+		for _, entry := range entries {
+			Expect(entry["status"]).To(BeNumerically("==", http.StatusOK))
+			Expect(entry["timestamp"]).NotTo(BeEmpty())
+			Expect(entry["response_bytes"]).To(BeNumerically(">", 0))
+			if entry["endpoint"] == "/v1/chat/completions" {
+				chatEntries = append(chatEntries, entry)
+				continue
+			}
+			Expect(entry["endpoint"]).To(Equal("/v1/models"))
+			Expect(entry["method"]).To(Equal(http.MethodGet))
+			Expect(entry).NotTo(HaveKey("model"))
+		}
+		Expect(chatEntries).To(HaveLen(2))
+
+		for _, entry := range chatEntries {
+			Expect(entry["method"]).To(Equal(http.MethodPost))
+			Expect(entry["model"]).To(Equal(model))
+			Expect(entry["request_bytes"]).To(BeNumerically(">", 0))
+			Expect(entry["ttft_ms"]).To(BeNumerically(">=", 50))
+			Expect(entry["duration_ms"]).To(BeNumerically(">=", 50))
+			Expect(entry["finish_reasons"]).NotTo(BeEmpty())
+			usage := resp.Usage
+			if entry["stream"] == true {
+				usage = streamUsage
+				Expect(entry).NotTo(HaveKey("service_tier"))
+			} else {
+				Expect(entry["request_id"]).To(Equal("logged-request"))
+				// the resolved service tier is logged
+				Expect(entry["service_tier"]).To(Equal(openaiserverapi.ServiceTierFlex))
+			}
+			Expect(entry["prompt_tokens"]).To(BeNumerically("==", usage.PromptTokens))
+			Expect(entry["completion_tokens"]).To(BeNumerically("==", usage.CompletionTokens))
+		}
+	})
+
+	It("should log a rejected request without the completion fields", func() {
+		ctx := context.TODO()
+		accessLogFile := filepath.Join(GinkgoT().TempDir(), "access.log")
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-access-log",
+			"--access-log-file", accessLogFile}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, "unknown-model", userMessage, false)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+
+		entries := readAccessLog(accessLogFile)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0]["endpoint"]).To(Equal("/v1/chat/completions"))
+		Expect(entries[0]["status"]).To(BeNumerically("==", http.StatusNotFound))
+		Expect(entries[0]).NotTo(HaveKey("model"))
+		Expect(entries[0]).NotTo(HaveKey("prompt_tokens"))
+	})
+
+	It("should not log the requests that are not sampled", func() {
+		ctx := context.TODO()
+		accessLogFile := filepath.Join(GinkgoT().TempDir(), "access.log")
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-access-log",
+			"--access-log-file", accessLogFile, "--access-log-sample-rate", "0"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(readAccessLog(accessLogFile)).To(BeEmpty())
+	})
+})
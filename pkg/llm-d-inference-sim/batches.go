@@ -0,0 +1,516 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Batch API, the requests of an uploaded JSONL file are processed asynchronously
+package llmdinferencesim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	batchEmbeddingsEndpoint = "/v1/embeddings"
+	// batchesListDefaultLimit is the number of batches in the list of the batches if limit is not set
+	batchesListDefaultLimit = 20
+	// batchesListMaxLimit is the maximal number of batches in the list of the batches
+	batchesListMaxLimit = 100
+	// batchExpiredCode is the error code of the requests that did not run before the batch expired
+	batchExpiredCode = "batch_expired"
+)
+
+// batchEndpoints are the endpoints of the requests of the batches
+var batchEndpoints = []string{replayChatEndpoint, replayTextEndpoint, replayResponsesEndpoint, batchEmbeddingsEndpoint}
+
+// batchJob is a batch and the state of its processing
+type batchJob struct {
+	// batch is the batch as returned by the API, guarded by the mutex of the batch store
+	batch openaiserverapi.Batch
+	// deadline is the end of the completion window of the batch, the requests that did not start
+	// before it don't run
+	deadline time.Time
+	// cancelled is closed when the batch is cancelled
+	cancelled chan struct{}
+}
+
+// batchStore keeps the batches of the batch API in memory
+type batchStore struct {
+	mutex sync.Mutex
+	// ctx is the context of the processing of the batches, the batches stop when it is done
+	ctx context.Context
+	// batches are the batches, the key is the batch id
+	batches map[string]*batchJob
+	// batchIDs are the ids of the batches in the order of their creation
+	batchIDs []string
+}
+
+func newBatchStore(ctx context.Context) *batchStore {
+	return &batchStore{
+		ctx:     ctx,
+		batches: make(map[string]*batchJob),
+	}
+}
+
+// addBatch stores a batch
+func (b *batchStore) addBatch(job *batchJob) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.batches[job.batch.ID] = job
+	b.batchIDs = append(b.batchIDs, job.batch.ID)
+}
+
+// getBatch returns the batch with the given id, nil if it does not exist
+func (b *batchStore) getBatch(id string) *batchJob {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.batches[id]
+}
+
+// snapshot returns a copy of the batch of a job
+func (b *batchStore) snapshot(job *batchJob) openaiserverapi.Batch {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return job.batch
+}
+
+// updateBatch changes a batch while holding the mutex, returns the changed batch
+func (b *batchStore) updateBatch(job *batchJob, update func(batch *openaiserverapi.Batch)) openaiserverapi.Batch {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	update(&job.batch)
+	return job.batch
+}
+
+// listBatches returns up to limit batches, the most recent first, that were created before the batch with
+// the id after, or from the most recent if after is empty, and whether there are more batches
+func (b *batchStore) listBatches(after string, limit int) ([]openaiserverapi.Batch, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	start := len(b.batchIDs) - 1
+	if after != "" {
+		for i, id := range b.batchIDs {
+			if id == after {
+				start = i - 1
+				break
+			}
+		}
+	}
+	batches := make([]openaiserverapi.Batch, 0)
+	for i := start; i >= 0 && len(batches) < limit; i-- {
+		batches = append(batches, b.batches[b.batchIDs[i]].batch)
+	}
+	return batches, start-len(batches) >= 0
+}
+
+// cancelBatch moves a validating or in progress batch to the cancelling status, the processing of the batch
+// finishes the cancellation. Returns the batch and false if the batch cannot be cancelled in its status
+func (b *batchStore) cancelBatch(job *batchJob, now int64) (openaiserverapi.Batch, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if job.batch.Status != openaiserverapi.BatchStatusValidating &&
+		job.batch.Status != openaiserverapi.BatchStatusInProgress {
+		return job.batch, false
+	}
+	job.batch.Status = openaiserverapi.BatchStatusCancelling
+	job.batch.CancellingAt = now
+	close(job.cancelled)
+	return job.batch, true
+}
+
+// HandleCreateBatch http handler for POST /v1/batches, creates a batch of the requests of an uploaded file
+// and starts its processing
+func (s *VllmSimulator) HandleCreateBatch(ctx *fasthttp.RequestCtx) {
+	var req openaiserverapi.BatchCreateRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.sendAPIError(ctx, "Failed to read and parse request body, "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	input := s.files.get(req.InputFileID)
+	if input == nil {
+		s.sendAPIError(ctx, fmt.Sprintf("No such file: '%s'", req.InputFileID), fasthttp.StatusBadRequest)
+		return
+	}
+	if input.file.Purpose != openaiserverapi.FilePurposeBatch {
+		s.sendAPIError(ctx, fmt.Sprintf("The purpose of the input file must be '%s'", openaiserverapi.FilePurposeBatch),
+			fasthttp.StatusBadRequest)
+		return
+	}
+	if !isBatchEndpoint(req.Endpoint) {
+		s.sendAPIError(ctx, fmt.Sprintf("Invalid endpoint '%s', must be one of %v", req.Endpoint, batchEndpoints),
+			fasthttp.StatusBadRequest)
+		return
+	}
+	window, err := time.ParseDuration(req.CompletionWindow)
+	if err != nil || window <= 0 {
+		s.sendAPIError(ctx, fmt.Sprintf("Invalid completion window '%s', must be a positive duration, e.g. 24h",
+			req.CompletionWindow), fasthttp.StatusBadRequest)
+		return
+	}
+
+	now := s.externalNow()
+	job := &batchJob{
+		batch: openaiserverapi.Batch{
+			ID:               "batch_" + s.random.UUIDString(),
+			Object:           "batch",
+			Endpoint:         req.Endpoint,
+			InputFileID:      req.InputFileID,
+			CompletionWindow: req.CompletionWindow,
+			Status:           openaiserverapi.BatchStatusValidating,
+			CreatedAt:        now.Unix(),
+			ExpiresAt:        now.Add(window).Unix(),
+			Metadata:         req.Metadata,
+		},
+		deadline:  time.Now().Add(window),
+		cancelled: make(chan struct{}),
+	}
+	s.batches.addBatch(job)
+	s.logger.Info("Batch created", "id", job.batch.ID, "endpoint", job.batch.Endpoint, "input file", req.InputFileID)
+	go s.processBatch(job, input.content)
+	s.sendAPIResponse(ctx, job.batch)
+}
+
+// HandleGetBatch http handler for GET /v1/batches/:batch_id
+func (s *VllmSimulator) HandleGetBatch(ctx *fasthttp.RequestCtx) {
+	if job := s.getRequestedBatch(ctx); job != nil {
+		s.sendAPIResponse(ctx, s.batches.snapshot(job))
+	}
+}
+
+// getRequestedBatch returns the batch of the batch_id path parameter, sends an error and returns nil
+// if the batch does not exist
+func (s *VllmSimulator) getRequestedBatch(ctx *fasthttp.RequestCtx) *batchJob {
+	id, _ := ctx.UserValue("batch_id").(string)
+	job := s.batches.getBatch(id)
+	if job == nil {
+		s.sendAPIError(ctx, fmt.Sprintf("No such batch: '%s'", id), fasthttp.StatusNotFound)
+	}
+	return job
+}
+
+// HandleListBatches http handler for GET /v1/batches, lists the batches from the most recent,
+// paginated by the after and limit query parameters
+func (s *VllmSimulator) HandleListBatches(ctx *fasthttp.RequestCtx) {
+	limit := batchesListDefaultLimit
+	if value := ctx.QueryArgs().Peek("limit"); len(value) > 0 {
+		var err error
+		limit, err = strconv.Atoi(string(value))
+		if err != nil || limit < 1 || limit > batchesListMaxLimit {
+			s.sendAPIError(ctx, fmt.Sprintf("Invalid limit '%s', must be between 1 and %d", value,
+				batchesListMaxLimit), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	batches, hasMore := s.batches.listBatches(string(ctx.QueryArgs().Peek("after")), limit)
+	list := openaiserverapi.BatchList{Object: "list", Data: batches, HasMore: hasMore}
+	if len(batches) > 0 {
+		list.FirstID = batches[0].ID
+		list.LastID = batches[len(batches)-1].ID
+	}
+	s.sendAPIResponse(ctx, list)
+}
+
+// HandleCancelBatch http handler for /v1/batches/:batch_id/cancel, the requests of the batch that
+// are running finish, the others don't run
+func (s *VllmSimulator) HandleCancelBatch(ctx *fasthttp.RequestCtx) {
+	job := s.getRequestedBatch(ctx)
+	if job == nil {
+		return
+	}
+	batch, ok := s.batches.cancelBatch(job, s.externalNow().Unix())
+	if !ok {
+		s.sendAPIError(ctx, fmt.Sprintf("Cannot cancel a batch with status '%s'", batch.Status),
+			fasthttp.StatusBadRequest)
+		return
+	}
+	s.logger.Info("Batch cancelled", "id", batch.ID)
+	s.sendAPIResponse(ctx, batch)
+}
+
+// processBatch validates the input of a batch and runs its requests, at most batch-max-concurrent-requests
+// at the same time, until all of them ran, the batch was cancelled or its completion window ended
+func (s *VllmSimulator) processBatch(job *batchJob, content []byte) {
+	lines, errs := parseBatchInput(content, job.batch.Endpoint)
+	if len(errs) > 0 {
+		s.logger.Info("Batch failed, invalid input file", "id", job.batch.ID, "number of errors", len(errs))
+		s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+			batch.Status = openaiserverapi.BatchStatusFailed
+			batch.FailedAt = s.externalNow().Unix()
+			batch.Errors = &openaiserverapi.BatchErrors{Object: "list", Data: errs}
+		})
+		return
+	}
+	s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+		batch.RequestCounts.Total = len(lines)
+	})
+
+	runCtx, cancel := context.WithDeadline(s.batches.ctx, job.deadline)
+	defer cancel()
+	stopped := func() bool {
+		select {
+		case <-job.cancelled:
+			return true
+		case <-runCtx.Done():
+			return true
+		default:
+			return false
+		}
+	}
+
+	// the batch is validating for the start delay
+	select {
+	case <-time.After(s.config.BatchStartDelay):
+	case <-job.cancelled:
+	case <-runCtx.Done():
+	}
+	if !stopped() {
+		s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+			batch.Status = openaiserverapi.BatchStatusInProgress
+			batch.InProgressAt = s.externalNow().Unix()
+		})
+	}
+
+	results := make([]*openaiserverapi.BatchOutputLine, len(lines))
+	slots := make(chan struct{}, s.config.BatchMaxConcurrentRequests)
+	var wg sync.WaitGroup
+	for i, line := range lines {
+		if stopped() {
+			break
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-job.cancelled:
+		case <-runCtx.Done():
+		}
+		if stopped() {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = s.runBatchRequest(job.batch.Endpoint, line)
+			s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+				if isSuccessStatus(results[i].Response.StatusCode) {
+					batch.RequestCounts.Completed++
+				} else {
+					batch.RequestCounts.Failed++
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	if s.batches.ctx.Err() != nil {
+		// the simulator stops
+		return
+	}
+	s.finalizeBatch(job, lines, results)
+}
+
+// finalizeBatch creates the output and the error files of a batch and moves it to its final status,
+// completed, expired if some requests did not run before the end of the completion window, or cancelled
+func (s *VllmSimulator) finalizeBatch(job *batchJob, lines []openaiserverapi.BatchInputLine,
+	results []*openaiserverapi.BatchOutputLine) {
+	cancelled := false
+	select {
+	case <-job.cancelled:
+		cancelled = true
+	default:
+	}
+	s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+		if !cancelled {
+			batch.Status = openaiserverapi.BatchStatusFinalizing
+		}
+		batch.FinalizingAt = s.externalNow().Unix()
+	})
+
+	var output, errorOutput bytes.Buffer
+	nExpired := 0
+	for i, result := range results {
+		if result == nil {
+			if cancelled {
+				continue
+			}
+			nExpired++
+			result = &openaiserverapi.BatchOutputLine{
+				ID:       "batch_req_" + s.random.UUIDString(),
+				CustomID: lines[i].CustomID,
+				Error: &openaiserverapi.BatchError{Code: batchExpiredCode,
+					Message: "This request could not be executed before the completion window expired"},
+			}
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			s.logger.Error(err, "failed to marshal the result of a batch request", "batch id", job.batch.ID)
+			continue
+		}
+		if result.Response != nil && isSuccessStatus(result.Response.StatusCode) {
+			output.Write(append(data, '\n'))
+		} else {
+			errorOutput.Write(append(data, '\n'))
+		}
+	}
+
+	var outputFileID, errorFileID string
+	if output.Len() > 0 {
+		file := s.newStoredFile(job.batch.ID+"_output.jsonl", openaiserverapi.FilePurposeBatchOutput, output.Bytes())
+		s.files.add(file)
+		outputFileID = file.file.ID
+	}
+	if errorOutput.Len() > 0 {
+		file := s.newStoredFile(job.batch.ID+"_error.jsonl", openaiserverapi.FilePurposeBatchOutput, errorOutput.Bytes())
+		s.files.add(file)
+		errorFileID = file.file.ID
+	}
+
+	batch := s.batches.updateBatch(job, func(batch *openaiserverapi.Batch) {
+		batch.OutputFileID = outputFileID
+		batch.ErrorFileID = errorFileID
+		batch.RequestCounts.Failed += nExpired
+		now := s.externalNow().Unix()
+		switch {
+		case cancelled:
+			batch.Status = openaiserverapi.BatchStatusCancelled
+			batch.CancelledAt = now
+		case nExpired > 0:
+			batch.Status = openaiserverapi.BatchStatusExpired
+			batch.ExpiredAt = now
+		default:
+			batch.Status = openaiserverapi.BatchStatusCompleted
+			batch.CompletedAt = now
+		}
+	})
+	s.logger.Info("Batch ended", "id", batch.ID, "status", batch.Status, "completed", batch.RequestCounts.Completed,
+		"failed", batch.RequestCounts.Failed)
+}
+
+// runBatchRequest processes a request of a batch like a request received by the server, the request is never streamed
+func (s *VllmSimulator) runBatchRequest(endpoint string, line openaiserverapi.BatchInputLine) *openaiserverapi.BatchOutputLine {
+	var req fasthttp.Request
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI(endpoint)
+	req.SetBody(nonStreamingBody(line.Body))
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, s)
+	// a request of a batch is written to the access log like a request received by the server
+	s.withAccessLog(endpoint, func(ctx *fasthttp.RequestCtx) {
+		if endpoint == batchEmbeddingsEndpoint {
+			s.HandleEmbeddings(ctx)
+		} else {
+			isResponses := endpoint == replayResponsesEndpoint
+			s.handleCompletions(ctx, endpoint == replayChatEndpoint || isResponses, isResponses)
+		}
+	})(&ctx)
+
+	body := ctx.Response.Body()
+	if !json.Valid(body) {
+		// the errors that are not in the error schema are plain text
+		body, _ = json.Marshal(string(body))
+	}
+	return &openaiserverapi.BatchOutputLine{
+		ID:       "batch_req_" + s.random.UUIDString(),
+		CustomID: line.CustomID,
+		Response: &openaiserverapi.BatchResponse{
+			StatusCode: ctx.Response.StatusCode(),
+			RequestID:  s.random.UUIDString(),
+			Body:       body,
+		},
+	}
+}
+
+// nonStreamingBody removes the streaming parameters from the body of a request, the body is returned
+// as is if it is not a JSON object
+func nonStreamingBody(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	delete(fields, "stream")
+	delete(fields, "stream_options")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// parseBatchInput parses the requests of the input file of a batch, returns the errors of the invalid lines
+func parseBatchInput(content []byte, endpoint string) ([]openaiserverapi.BatchInputLine, []openaiserverapi.BatchError) {
+	lines := make([]openaiserverapi.BatchInputLine, 0)
+	errs := make([]openaiserverapi.BatchError, 0)
+	customIDs := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var line openaiserverapi.BatchInputLine
+		lineError := func(code string, message string) {
+			errs = append(errs, openaiserverapi.BatchError{Code: code, Message: message, Line: lineNumber})
+		}
+		switch err := json.Unmarshal(data, &line); {
+		case err != nil:
+			lineError("invalid_json_line", "The line is not a valid JSON object, "+err.Error())
+		case line.CustomID == "":
+			lineError("missing_custom_id", "The custom_id of the request is missing")
+		case customIDs[line.CustomID]:
+			lineError("duplicate_custom_id", fmt.Sprintf("The custom_id '%s' is not unique", line.CustomID))
+		case line.Method != fasthttp.MethodPost:
+			lineError("invalid_method", fmt.Sprintf("Invalid method '%s', must be POST", line.Method))
+		case line.URL != endpoint:
+			lineError("mismatched_url", fmt.Sprintf("The url '%s' is not the endpoint of the batch '%s'",
+				line.URL, endpoint))
+		case len(line.Body) == 0:
+			lineError("missing_body", "The body of the request is missing")
+		default:
+			customIDs[line.CustomID] = true
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, openaiserverapi.BatchError{Code: "invalid_file", Message: err.Error()})
+	} else if len(lines) == 0 && len(errs) == 0 {
+		errs = append(errs, openaiserverapi.BatchError{Code: "empty_file", Message: "The input file has no requests"})
+	}
+	return lines, errs
+}
+
+func isBatchEndpoint(endpoint string) bool {
+	for _, batchEndpoint := range batchEndpoints {
+		if endpoint == batchEndpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func isSuccessStatus(statusCode int) bool {
+	return statusCode >= fasthttp.StatusOK && statusCode < fasthttp.StatusMultipleChoices
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// batchChatLine returns a line of a batch input file of a chat completion request
+func batchChatLine(customID string, model string, extra string) string {
+	return fmt.Sprintf(`{"custom_id": "%s", "method": "POST", "url": "/v1/chat/completions", `+
+		`"body": {"model": "%s", "messages": [{"role": "user", "content": "%s"}]%s}}`, customID, model, userMessage, extra)
+}
+
+// startBatch uploads the input file and creates a batch of its chat completion requests
+func startBatch(ctx context.Context, client openai.Client, lines []string, window string) *openai.Batch {
+	file, err := client.Files.New(ctx, openai.FileNewParams{
+		File:    openai.File(strings.NewReader(strings.Join(lines, "\n")), "input.jsonl", "application/jsonl"),
+		Purpose: openai.FilePurposeBatch,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(file.Filename).To(Equal("input.jsonl"))
+
+	batch, err := client.Batches.New(ctx, openai.BatchNewParams{
+		CompletionWindow: openai.BatchNewParamsCompletionWindow(window),
+		Endpoint:         openai.BatchNewParamsEndpointV1ChatCompletions,
+		InputFileID:      file.ID,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(batch.InputFileID).To(Equal(file.ID))
+	return batch
+}
+
+// waitForBatchStatus waits until the batch has the given status
+func waitForBatchStatus(ctx context.Context, client openai.Client, id string, status string) *openai.Batch {
+	var batch *openai.Batch
+	Eventually(func() string {
+		var err error
+		batch, err = client.Batches.Get(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		return string(batch.Status)
+	}, 5*time.Second, 20*time.Millisecond).Should(Equal(status))
+	return batch
+}
+
+// getBatchOutput returns the lines of an output or error file of a batch by their custom ids
+func getBatchOutput(ctx context.Context, client openai.Client, fileID string) map[string]openaiserverapi.BatchOutputLine {
+	resp, err := client.Files.Content(ctx, fileID)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(resp.Body.Close()).To(Succeed())
+	}()
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	output := make(map[string]openaiserverapi.BatchOutputLine)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var result openaiserverapi.BatchOutputLine
+		Expect(json.Unmarshal([]byte(line), &result)).To(Succeed())
+		output[result.CustomID] = result
+	}
+	return output
+}
+
+var _ = Describe("Batch API", func() {
+	It("should run the requests of a batch and return their responses", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		lines := []string{
+			batchChatLine("request-1", model, ""),
+			// the batch requests are not streamed
+			batchChatLine("request-2", model, `, "stream": true`),
+			batchChatLine("request-3", "unknown", ""),
+		}
+		batch := startBatch(ctx, client, lines, "24h")
+		Expect(batch.Endpoint).To(Equal("/v1/chat/completions"))
+
+		batch = waitForBatchStatus(ctx, client, batch.ID, openaiserverapi.BatchStatusCompleted)
+		Expect(batch.RequestCounts.Total).To(Equal(int64(3)))
+		Expect(batch.RequestCounts.Completed).To(Equal(int64(2)))
+		Expect(batch.RequestCounts.Failed).To(Equal(int64(1)))
+		Expect(batch.InProgressAt).To(BeNumerically(">=", batch.CreatedAt))
+		Expect(batch.CompletedAt).To(BeNumerically(">=", batch.InProgressAt))
+
+		output := getBatchOutput(ctx, client, batch.OutputFileID)
+		Expect(output).To(HaveLen(2))
+		for _, customID := range []string{"request-1", "request-2"} {
+			Expect(output[customID].Response.StatusCode).To(Equal(http.StatusOK))
+			var resp openai.ChatCompletion
+			Expect(json.Unmarshal(output[customID].Response.Body, &resp)).To(Succeed())
+			Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+		}
+		errors := getBatchOutput(ctx, client, batch.ErrorFileID)
+		Expect(errors["request-3"].Response.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(string(errors["request-3"].Response.Body)).To(ContainSubstring("does not exist"))
+
+		list, err := client.Batches.List(ctx, openai.BatchListParams{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Data).To(HaveLen(1))
+		Expect(list.Data[0].ID).To(Equal(batch.ID))
+	})
+
+	It("should fail a batch with an invalid input file", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		lines := []string{
+			batchChatLine("request-1", model, ""),
+			batchChatLine("request-1", model, ""),
+			`{"custom_id": "request-2", "method": "POST", "url": "/v1/completions", "body": {}}`,
+			"not json",
+		}
+		batch := startBatch(ctx, client, lines, "24h")
+		batch = waitForBatchStatus(ctx, client, batch.ID, openaiserverapi.BatchStatusFailed)
+		Expect(batch.Errors.Data).To(HaveLen(3))
+		Expect(batch.Errors.Data[0].Code).To(Equal("duplicate_custom_id"))
+		Expect(batch.Errors.Data[0].Line).To(Equal(int64(2)))
+		Expect(batch.Errors.Data[1].Code).To(Equal("mismatched_url"))
+		Expect(batch.Errors.Data[2].Code).To(Equal("invalid_json_line"))
+		Expect(batch.OutputFileID).To(BeEmpty())
+
+		_, err = client.Batches.New(ctx, openai.BatchNewParams{
+			CompletionWindow: openai.BatchNewParamsCompletionWindow24h,
+			Endpoint:         openai.BatchNewParamsEndpointV1ChatCompletions,
+			InputFileID:      "file-unknown",
+		})
+		var openaiError *openai.Error
+		Expect(err).To(BeAssignableToTypeOf(openaiError))
+		Expect(err.Error()).To(ContainSubstring("No such file"))
+	})
+
+	It("should cancel a batch", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--batch-start-delay", "10s"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		batch := startBatch(ctx, client, []string{batchChatLine("request-1", model, "")}, "24h")
+		Expect(string(batch.Status)).To(Equal(openaiserverapi.BatchStatusValidating))
+		batch, err = client.Batches.Cancel(ctx, batch.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(batch.Status)).To(Equal(openaiserverapi.BatchStatusCancelling))
+
+		batch = waitForBatchStatus(ctx, client, batch.ID, openaiserverapi.BatchStatusCancelled)
+		Expect(batch.CancelledAt).To(BeNumerically(">", 0))
+		Expect(batch.RequestCounts.Completed).To(BeZero())
+		Expect(batch.OutputFileID).To(BeEmpty())
+
+		_, err = client.Batches.Cancel(ctx, batch.ID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should expire the requests that did not start in the completion window", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--batch-max-concurrent-requests", "1",
+				"--time-to-first-token", "300"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		lines := []string{batchChatLine("request-1", model, ""), batchChatLine("request-2", model, ""),
+			batchChatLine("request-3", model, "")}
+		batch := startBatch(ctx, client, lines, "500ms")
+		batch = waitForBatchStatus(ctx, client, batch.ID, openaiserverapi.BatchStatusExpired)
+		Expect(batch.RequestCounts.Completed).To(Equal(int64(2)))
+		Expect(batch.RequestCounts.Failed).To(Equal(int64(1)))
+
+		errors := getBatchOutput(ctx, client, batch.ErrorFileID)
+		Expect(errors["request-3"].Response).To(BeNil())
+		Expect(errors["request-3"].Error.Code).To(Equal(batchExpiredCode))
+	})
+})
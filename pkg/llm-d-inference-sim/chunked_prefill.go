@@ -0,0 +1,65 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+// startPrefill marks the start of the prefill of nTokens prompt tokens that are not in kv cache,
+// returns a function that marks its end
+func (s *VllmSimulator) startPrefill(nTokens int) func() {
+	s.prefillTokens.Add(int64(nTokens))
+	return func() {
+		s.prefillTokens.Add(-int64(nTokens))
+	}
+}
+
+// localPrefillTokens returns the number of prompt tokens prefilled by this instance,
+// none if the prefill is done remotely
+func localPrefillTokens(nPromptTokens int, nCachedPromptTokens int, doRemotePrefill bool) int {
+	if doRemotePrefill {
+		return 0
+	}
+	return nPromptTokens - nCachedPromptTokens
+}
+
+// getStepPrefillBudget returns the number of prefill tokens that fit in a scheduling step,
+// the decoding requests take one token each from the max-num-batched-tokens budget
+func (s *VllmSimulator) getStepPrefillBudget() int {
+	return max(s.getRuntimeConfig().MaxNumBatchedTokens-s.numDecodingRequests(), 1)
+}
+
+// getPrefillChunkSize returns the number of prompt tokens of a request prefilled in a scheduling step,
+// the step's prefill budget is shared by the requests in the prefill phase
+func (s *VllmSimulator) getPrefillChunkSize() int {
+	return max(s.getStepPrefillBudget()/int(max(s.activePrefills.Load(), 1)), 1)
+}
+
+// getChunkedPrefillTime returns the prefill time of nTokens prompt tokens, each scheduling step
+// adds the prefill overhead
+func (s *VllmSimulator) getChunkedPrefillTime(nTokens int) int {
+	chunkSize := s.getPrefillChunkSize()
+	nChunks := max((nTokens+chunkSize-1)/chunkSize, 1)
+	return nChunks*s.getPrefillOverhead() + nTokens*s.getPrefillTimePerToken()
+}
+
+// getChunkedPrefillStall returns the time a decode step is delayed by the prefill chunks
+// that are scheduled in the same step
+func (s *VllmSimulator) getChunkedPrefillStall() int {
+	nTokens := int(s.prefillTokens.Load())
+	if nTokens <= 0 {
+		return 0
+	}
+	return min(nTokens, s.getStepPrefillBudget()) * s.getPrefillTimePerToken()
+}
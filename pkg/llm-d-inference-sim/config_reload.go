@@ -0,0 +1,167 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Reloading the configuration file of a running simulator on SIGHUP or when the file changes
+package llmdinferencesim
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// configReloader reloads the configuration file when the process receives SIGHUP, and when the
+// modification time of the file changes if the config reload interval is set
+func (s *VllmSimulator) configReloader(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var check <-chan time.Time
+	if s.config.ConfigReloadInterval > 0 {
+		ticker := time.NewTicker(s.config.ConfigReloadInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	modTime := s.configFileModTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			modTime = s.configFileModTime()
+			_ = s.reloadConfig()
+		case <-check:
+			if current := s.configFileModTime(); !current.Equal(modTime) {
+				modTime = current
+				_ = s.reloadConfig()
+			}
+		}
+	}
+}
+
+// configFileModTime returns the modification time of the configuration file, the zero time if
+// the file cannot be read
+func (s *VllmSimulator) configFileModTime() time.Time {
+	info, err := os.Stat(s.config.ConfigFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloadConfig applies the reloadable parameters of the configuration file to the runtime configuration,
+// the new values are used by the requests that start after the reload. The reload is rejected as a
+// whole, and the current configuration is kept, if the file cannot be read or the result is invalid
+func (s *VllmSimulator) reloadConfig() error {
+	s.runtimeConfigMutex.Lock()
+	defer s.runtimeConfigMutex.Unlock()
+
+	current := s.getRuntimeConfig()
+	updated, err := current.WithReloadedFile()
+	if err == nil && s.config.DPSize > 1 {
+		// the reloaded parameters of the file are overridden again for the rank
+		updated, err = updated.WithDPRankOverrides(s.dpRank)
+	}
+	if err != nil {
+		s.logger.Error(err, "Configuration file reload rejected", "file", s.config.ConfigFile)
+		return err
+	}
+	s.runtimeConfig.Store(updated)
+	s.setClockSkew(updated.ClockSkew)
+	if !reflect.DeepEqual(current.LoraModules, updated.LoraModules) {
+		s.reloadLoras(current.LoraModules, updated.LoraModules)
+	}
+	if !reflect.DeepEqual(current.FakeMetrics, updated.FakeMetrics) {
+		s.reloadFakeMetrics(updated.FakeMetrics)
+	}
+	s.logger.Info("Configuration file reloaded", "file", s.config.ConfigFile)
+	return nil
+}
+
+// reloadLoras loads the LoRA modules that were added to the configuration and unloads the ones that
+// were removed from it, the adapters that are still in the configuration are kept as they are. A removed
+// adapter with waiting or running requests is unloaded when its requests end
+func (s *VllmSimulator) reloadLoras(current, updated []common.LoraModule) {
+	now := time.Now()
+	for _, lora := range updated {
+		if !slices.ContainsFunc(current, func(l common.LoraModule) bool { return l.Name == lora.Name }) {
+			s.storeLora(loadedLora{name: lora.Name, path: lora.Path, baseModelName: lora.BaseModelName, loadTime: now})
+		}
+	}
+	for _, lora := range current {
+		if slices.ContainsFunc(updated, func(l common.LoraModule) bool { return l.Name == lora.Name }) {
+			continue
+		}
+		if s.unloadLoraIfIdle(lora.Name) {
+			s.logger.Info("LoRA adapter removed from the configuration unloaded", "lora", lora.Name)
+		} else {
+			s.logger.Info("LoRA adapter removed from the configuration is unloaded when its requests end",
+				"lora", lora.Name)
+			go s.unloadRemovedLora(lora.Name)
+		}
+	}
+
+	s.metricsMutex.Lock()
+	defer s.metricsMutex.Unlock()
+	s.reportLoras()
+}
+
+// unloadRemovedLora unloads the LoRA adapter that was removed from the configuration while it had waiting
+// or running requests, once its requests end. The adapter is kept if it is added back to the configuration
+func (s *VllmSimulator) unloadRemovedLora(lora string) {
+	ticker := time.NewTicker(loraIdleCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.isStaticLora(lora) {
+			return
+		}
+		if s.unloadLoraIfIdle(lora) {
+			s.logger.Info("LoRA adapter removed from the configuration unloaded", "lora", lora)
+			s.metricsMutex.Lock()
+			s.reportLoras()
+			s.metricsMutex.Unlock()
+			return
+		}
+	}
+}
+
+// reloadFakeMetrics sends the reloaded fake metrics to prometheus, if the fake metrics were removed
+// the real values of the requests and loras are sent, the kv cache usage is sent on its next change
+func (s *VllmSimulator) reloadFakeMetrics(fakeMetrics *common.Metrics) {
+	if s.runningRequests == nil {
+		// Happens in the tests
+		return
+	}
+
+	s.metricsMutex.Lock()
+	defer s.metricsMutex.Unlock()
+	if fakeMetrics != nil {
+		s.setFakeMetrics(fakeMetrics)
+		return
+	}
+	modelName := s.getDisplayedModelName(s.config.Model)
+	s.reportRunningRequests(modelName)
+	s.reportWaitingRequests(modelName)
+	s.reportLoras()
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+// writeConfigFile writes the configuration file, the modification time is moved forward
+// so that the change is detected even on file systems with a coarse time resolution
+func writeConfigFile(configFile string, data string, modTime time.Time) {
+	Expect(os.WriteFile(configFile, []byte(data), 0o600)).To(Succeed())
+	Expect(os.Chtimes(configFile, modTime, modTime)).To(Succeed())
+}
+
+var _ = Describe("Configuration file reload", func() {
+	getMetrics := func(client *http.Client) string {
+		resp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		return string(data)
+	}
+	getModelIDs := func(client *http.Client) []string {
+		resp, err := client.Get(baseURL + "/models")
+		Expect(err).NotTo(HaveOccurred())
+		var modelsResp vllmapi.ModelsResponse
+		Expect(json.NewDecoder(resp.Body).Decode(&modelsResp)).To(Succeed())
+		Expect(resp.Body.Close()).To(Succeed())
+		ids := make([]string, 0, len(modelsResp.Data))
+		for _, m := range modelsResp.Data {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	It("should apply the changes of the configuration file to a running simulator", func() {
+		ctx := context.TODO()
+		configFile := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfigFile(configFile, `
+model: `+model+`
+lora-modules:
+  - '{"name":"lora1","path":"/path/to/lora1"}'
+fake-metrics:
+  running-requests: 3
+`, time.Now())
+		args := []string{"cmd", "--mode", common.ModeRandom, "--config", configFile,
+			"--config-reload-interval", "50ms"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(getModelIDs(client)).To(ConsistOf(model, "lora1"))
+		Expect(getMetrics(client)).To(ContainSubstring("vllm:num_requests_running{model_name=\"my_model\"} 3"))
+
+		writeConfigFile(configFile, `
+model: `+model+`
+time-to-first-token: 300
+lora-modules:
+  - '{"name":"lora2","path":"/path/to/lora2"}'
+fake-metrics:
+  running-requests: 8
+`, time.Now().Add(time.Hour))
+
+		Eventually(func() []string { return getModelIDs(client) }, 2*time.Second, 50*time.Millisecond).
+			Should(ConsistOf(model, "lora2"))
+		Expect(getMetrics(client)).To(ContainSubstring("vllm:num_requests_running{model_name=\"my_model\"} 8"))
+		Expect(getConfig(client)["time-to-first-token"]).To(BeNumerically("==", 300))
+	})
+
+	It("should unload a removed LoRA adapter only when its running request ends", func() {
+		ctx := context.TODO()
+		configFile := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfigFile(configFile, `
+model: `+model+`
+time-to-first-token: 1000
+lora-modules:
+  - '{"name":"lora1","path":"/path/to/lora1"}'
+`, time.Now())
+		args := []string{"cmd", "--mode", common.ModeRandom, "--config", configFile,
+			"--config-reload-interval", "50ms"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		done := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := client.Post(baseURL+"/chat/completions", "application/json", strings.NewReader(
+				`{"messages": [{"role": "user", "content": "Hello"}], "model": "lora1", "max_tokens": 2}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			done <- resp.StatusCode
+		}()
+		Eventually(func() float64 {
+			return getGaugeValue(getMetrics(client), `vllm:num_requests_running{model_name="my_model"}`)
+		}, time.Second, 20*time.Millisecond).Should(Equal(1.0))
+
+		writeConfigFile(configFile, `
+model: `+model+`
+time-to-first-token: 1000
+lora-modules:
+  - '{"name":"lora2","path":"/path/to/lora2"}'
+`, time.Now().Add(time.Hour))
+		// the removed adapter is kept while its request runs
+		Eventually(func() []string { return getModelIDs(client) }, time.Second, 50*time.Millisecond).
+			Should(ConsistOf(model, "lora1", "lora2"))
+
+		Eventually(done, 2*time.Second).Should(Receive(Equal(http.StatusOK)))
+		Eventually(func() []string { return getModelIDs(client) }, time.Second, 50*time.Millisecond).
+			Should(ConsistOf(model, "lora2"))
+	})
+
+	It("should keep the configuration if the reloaded file is invalid", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		configFile := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		writeConfigFile(configFile, "model: "+model+"\ntime-to-first-token: 100\n", time.Now())
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--mode", common.ModeRandom, "--config", configFile}
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config, err = common.ParseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+		client, err := startSimulator(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+
+		writeConfigFile(configFile, "model: "+model+"\ntime-to-first-token: -1\n", time.Now())
+		Expect(s.reloadConfig()).To(MatchError(ContainSubstring("time to first token cannot be negative")))
+		Expect(getConfig(client)["time-to-first-token"]).To(BeNumerically("==", 100))
+
+		writeConfigFile(configFile, "model: "+model+"\ntime-to-first-token: 200\n", time.Now())
+		Expect(s.reloadConfig()).To(Succeed())
+		Expect(getConfig(client)["time-to-first-token"]).To(BeNumerically("==", 200))
+	})
+})
@@ -0,0 +1,280 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// inFlightRequest holds the metadata of a waiting or running request
+type inFlightRequest struct {
+	requestID    string
+	model        string
+	apiKey       string
+	promptTokens int
+	maxTokens    *int64
+	stream       bool
+	priority     int
+	// serviceTier is the service tier the request is processed in, empty if the request
+	// didn't ask for a service tier
+	serviceTier string
+	enqueueTime time.Time
+	// traceParent is the span context of the client's trace, invalid if the request is not traced by the client
+	traceParent trace.SpanContext
+	// accessLog is the access log entry of the request, nil if the request is not logged
+	accessLog *accessLogEntry
+	// mutex protects the fields that are set when the request starts running
+	mutex sync.RWMutex
+	// workerID is the id of the worker processing the request, 0 while the request is waiting
+	workerID  int
+	startTime time.Time
+	// prefillStartTime is the time the prefill of the request started, zero until then
+	prefillStartTime time.Time
+	// remotePrefill is true if the kv cache of the prompt is transferred from a remote prefill
+	remotePrefill bool
+	// firstTokenTime is the time the first token of the request was generated, zero until then
+	firstTokenTime time.Time
+}
+
+// waitingRequestInfo describes a request in the waiting queue
+type waitingRequestInfo struct {
+	RequestID    string `json:"request_id"`
+	Model        string `json:"model"`
+	PromptTokens int    `json:"prompt_tokens"`
+	MaxTokens    *int64 `json:"max_tokens"`
+	Stream       bool   `json:"stream"`
+	Priority     int    `json:"priority"`
+	// EnqueueAgeMs is the time since the request was added to the queue, in milliseconds
+	EnqueueAgeMs int64 `json:"enqueue_age_ms"`
+}
+
+// runningRequestInfo describes a request that is being processed by a worker
+type runningRequestInfo struct {
+	RequestID string `json:"request_id"`
+	Model     string `json:"model"`
+	WorkerID  int    `json:"worker_id"`
+	// ElapsedMs is the time since the worker started processing the request, in milliseconds
+	ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+// queueResponse is the response of /debug/queue
+type queueResponse struct {
+	// Waiting requests in the order they are taken by the workers, by their priority and then the oldest first
+	Waiting []waitingRequestInfo `json:"waiting"`
+	// Running requests, the longest running first
+	Running []runningRequestInfo `json:"running"`
+}
+
+// addInFlightRequest starts tracking a request that is added to the waiting queue,
+// traceParent is the span context of the client's trace, apiKey is the API key of the request,
+// accessLog is the access log entry of the request
+func (s *VllmSimulator) addInFlightRequest(req openaiserverapi.CompletionRequest, serviceTier string,
+	traceParent trace.SpanContext, apiKey string, accessLog *accessLogEntry) {
+	s.inFlightRequests.Store(req.GetRequestID(), &inFlightRequest{
+		requestID:    req.GetRequestID(),
+		model:        req.GetModel(),
+		apiKey:       apiKey,
+		promptTokens: req.GetNumberOfPromptTokens(),
+		maxTokens:    req.GetMaxCompletionTokens(),
+		stream:       req.IsStream(),
+		priority:     req.GetPriority(),
+		serviceTier:  serviceTier,
+		enqueueTime:  time.Now(),
+		traceParent:  traceParent,
+		accessLog:    accessLog,
+	})
+}
+
+// startInFlightRequest marks a tracked request as running by the given worker,
+// returns the simulated time the request spent in the waiting queue
+func (s *VllmSimulator) startInFlightRequest(requestID string, workerID int) time.Duration {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return 0
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.Lock()
+	defer req.mutex.Unlock()
+	req.workerID = workerID
+	req.startTime = time.Now()
+	return s.virtualDuration(req.startTime.Sub(req.enqueueTime))
+}
+
+// markPrefillStart records that the prefill of a tracked request started, remotePrefill is true
+// if the kv cache of the prompt is transferred from a remote prefill
+func (s *VllmSimulator) markPrefillStart(requestID string, remotePrefill bool) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.Lock()
+	defer req.mutex.Unlock()
+	req.prefillStartTime = time.Now()
+	req.remotePrefill = remotePrefill
+}
+
+// markFirstToken records that the first token of a tracked request was generated
+func (s *VllmSimulator) markFirstToken(requestID string) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.Lock()
+	defer req.mutex.Unlock()
+	req.firstTokenTime = time.Now()
+}
+
+// requestLatencies are the simulated latencies of a tracked request until now
+type requestLatencies struct {
+	// e2e is the time since the request was added to the waiting queue
+	e2e time.Duration
+	// ttft is the time from the arrival of the request to its first token, zero if no token was generated
+	ttft time.Duration
+	// prefill is the time from the start of the prefill to the first token, zero if no token was generated
+	prefill time.Duration
+	// decode is the time since the first token, zero if no token was generated
+	decode time.Duration
+	// inference is the time since the start of the prefill, zero if the prefill didn't start
+	inference time.Duration
+}
+
+// getRequestLatencies returns the simulated latencies of a tracked request until now,
+// returns false if the request is not tracked
+func (s *VllmSimulator) getRequestLatencies(requestID string) (requestLatencies, bool) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return requestLatencies{}, false
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.RLock()
+	defer req.mutex.RUnlock()
+	now := time.Now()
+	latencies := requestLatencies{e2e: s.virtualDuration(now.Sub(req.enqueueTime))}
+	if !req.prefillStartTime.IsZero() {
+		latencies.inference = s.virtualDuration(now.Sub(req.prefillStartTime))
+	}
+	if !req.firstTokenTime.IsZero() {
+		latencies.ttft = s.virtualDuration(req.firstTokenTime.Sub(req.enqueueTime))
+		latencies.decode = s.virtualDuration(now.Sub(req.firstTokenTime))
+		if !req.prefillStartTime.IsZero() {
+			latencies.prefill = s.virtualDuration(req.firstTokenTime.Sub(req.prefillStartTime))
+		}
+	}
+	return latencies, true
+}
+
+// getRequestTokens returns the number of prompt tokens and the max tokens of a tracked request,
+// returns false if the request is not tracked
+func (s *VllmSimulator) getRequestTokens(requestID string) (int, *int64, bool) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return 0, nil, false
+	}
+	req := value.(*inFlightRequest)
+	return req.promptTokens, req.maxTokens, true
+}
+
+// removeInFlightRequest stops tracking a request
+func (s *VllmSimulator) removeInFlightRequest(requestID string) {
+	s.inFlightRequests.Delete(requestID)
+}
+
+// getQueueSnapshot returns the waiting and running requests, it doesn't block the waiting queue
+func (s *VllmSimulator) getQueueSnapshot() queueResponse {
+	now := time.Now()
+	resp := queueResponse{
+		Waiting: make([]waitingRequestInfo, 0),
+		Running: make([]runningRequestInfo, 0),
+	}
+	s.inFlightRequests.Range(func(_, value any) bool {
+		req := value.(*inFlightRequest)
+		req.mutex.RLock()
+		workerID, startTime := req.workerID, req.startTime
+		req.mutex.RUnlock()
+
+		if workerID == 0 {
+			resp.Waiting = append(resp.Waiting, waitingRequestInfo{
+				RequestID:    req.requestID,
+				Model:        req.model,
+				PromptTokens: req.promptTokens,
+				MaxTokens:    req.maxTokens,
+				Stream:       req.stream,
+				Priority:     req.priority,
+				EnqueueAgeMs: now.Sub(req.enqueueTime).Milliseconds(),
+			})
+		} else {
+			resp.Running = append(resp.Running, runningRequestInfo{
+				RequestID: req.requestID,
+				Model:     req.model,
+				WorkerID:  workerID,
+				ElapsedMs: now.Sub(startTime).Milliseconds(),
+			})
+		}
+		return true
+	})
+
+	sort.SliceStable(resp.Waiting, func(i, j int) bool {
+		if resp.Waiting[i].Priority != resp.Waiting[j].Priority {
+			return resp.Waiting[i].Priority < resp.Waiting[j].Priority
+		}
+		return resp.Waiting[i].EnqueueAgeMs > resp.Waiting[j].EnqueueAgeMs
+	})
+	sort.SliceStable(resp.Running, func(i, j int) bool {
+		return resp.Running[i].ElapsedMs > resp.Running[j].ElapsedMs
+	})
+	return resp
+}
+
+// HandleDebugQueue http handler for /debug/queue
+func (s *VllmSimulator) HandleDebugQueue(ctx *fasthttp.RequestCtx) {
+	data, err := json.Marshal(s.getQueueSnapshot())
+	if err != nil {
+		ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// HandleDebugDataset http handler for /debug/dataset
+func (s *VllmSimulator) HandleDebugDataset(ctx *fasthttp.RequestCtx) {
+	custDataset, ok := s.dataset.(*dataset.CustomDataset)
+	if !ok {
+		ctx.Error("The simulator does not use a dataset", fasthttp.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(custDataset.Stats())
+	if err != nil {
+		ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+)
+
+const (
+	debugQueueURL      = "http://localhost/debug/queue"
+	validateConfigURL  = "http://localhost/admin/validate-config"
+	invalidConfigYAML  = "model: \"my_model\"\nmode: \"unknown\"\nport: -1\nfailure-injection-rate: 150\n"
+	validConfigJSONFmt = `{"model": "my_model", "max-num-seqs": %d}`
+	pprofHeapURL       = "http://localhost/debug/pprof/heap"
+	debugDatasetURL    = "http://localhost/debug/dataset"
+	testDatasetPath    = "../dataset/.llm-d/test.valid.sqlite3"
+)
+
+func getConfig(client *http.Client) map[string]any {
+	resp, err := client.Get("http://localhost/v1/config")
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.Body.Close()).To(Succeed())
+
+	var config map[string]any
+	Expect(json.Unmarshal(data, &config)).To(Succeed())
+	return config
+}
+
+func postValidateConfig(client *http.Client, body string) validateConfigResponse {
+	resp, err := client.Post(validateConfigURL, "application/yaml", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	var result validateConfigResponse
+	err = json.Unmarshal(data, &result)
+	Expect(err).NotTo(HaveOccurred())
+	return result
+}
+
+func getDebugQueue(client *http.Client) queueResponse {
+	resp, err := client.Get(debugQueueURL)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	var queue queueResponse
+	err = json.Unmarshal(data, &queue)
+	Expect(err).NotTo(HaveOccurred())
+	return queue
+}
+
+var _ = Describe("Debug endpoints", func() {
+	It("should not serve /debug/queue when the admin API is disabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Get(debugQueueURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should list the waiting and running requests", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--max-num-seqs", "1", "--time-to-first-token", "3000"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		queue := getDebugQueue(client)
+		Expect(queue.Waiting).To(BeEmpty())
+		Expect(queue.Running).To(BeEmpty())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(5)
+		// the first request occupies the only worker, the other two wait in the queue
+		for range 3 {
+			go func() {
+				defer GinkgoRecover()
+				_, _ = openaiclient.Chat.Completions.New(ctx, params)
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		Eventually(func() []int {
+			queue = getDebugQueue(client)
+			return []int{len(queue.Running), len(queue.Waiting)}
+		}).WithTimeout(time.Second).Should(Equal([]int{1, 2}))
+
+		Expect(queue.Running[0].WorkerID).To(Equal(1))
+		Expect(queue.Running[0].Model).To(Equal(model))
+		for _, waiting := range queue.Waiting {
+			Expect(waiting.RequestID).NotTo(BeEmpty())
+			Expect(waiting.Model).To(Equal(model))
+			Expect(waiting.PromptTokens).To(BeNumerically("==", userMsgTokens))
+			Expect(waiting.MaxTokens).NotTo(BeNil())
+			Expect(*waiting.MaxTokens).To(BeNumerically("==", 5))
+			Expect(waiting.Stream).To(BeFalse())
+		}
+		// the oldest request is listed first
+		Expect(queue.Waiting[0].EnqueueAgeMs).To(BeNumerically(">=", queue.Waiting[1].EnqueueAgeMs))
+
+		time.Sleep(200 * time.Millisecond)
+		laterQueue := getDebugQueue(client)
+		Expect(laterQueue.Running).To(HaveLen(1))
+		Expect(laterQueue.Running[0].RequestID).To(Equal(queue.Running[0].RequestID))
+		Expect(laterQueue.Running[0].ElapsedMs).To(BeNumerically(">", queue.Running[0].ElapsedMs))
+		Expect(laterQueue.Waiting).To(HaveLen(2))
+		for i := range laterQueue.Waiting {
+			Expect(laterQueue.Waiting[i].RequestID).To(Equal(queue.Waiting[i].RequestID))
+			Expect(laterQueue.Waiting[i].EnqueueAgeMs).To(BeNumerically(">", queue.Waiting[i].EnqueueAgeMs))
+		}
+	})
+
+	It("should not serve /admin/validate-config when the admin API is disabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Post(validateConfigURL, "application/yaml", strings.NewReader(invalidConfigYAML))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should validate a configuration without applying it", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		result := postValidateConfig(client, invalidConfigYAML)
+		Expect(result.Valid).To(BeFalse())
+		Expect(result.Errors).To(HaveLen(3))
+		Expect(result.Errors).To(ContainElements(
+			ContainSubstring("invalid mode 'unknown'"),
+			ContainSubstring("invalid port '-1'"),
+			ContainSubstring("failure injection rate should be between 0 and 100")))
+		Expect(result.Config).NotTo(BeNil())
+		Expect(result.Config["mode"]).To(Equal("unknown"))
+
+		result = postValidateConfig(client, fmt.Sprintf(validConfigJSONFmt, 17))
+		Expect(result.Valid).To(BeTrue())
+		Expect(result.Errors).To(BeEmpty())
+		Expect(result.Config["max-num-seqs"]).To(BeNumerically("==", 17))
+
+		result = postValidateConfig(client, "model: [unterminated")
+		Expect(result.Valid).To(BeFalse())
+		Expect(result.Errors).To(HaveLen(1))
+		Expect(result.Config).To(BeNil())
+
+		// the running configuration is not changed
+		configResp := getConfig(client)
+		Expect(configResp["mode"]).To(Equal(common.ModeRandom))
+		Expect(configResp["max-num-seqs"]).To(BeNumerically("==", 5))
+	})
+
+	It("should not serve /debug/pprof/ when profiling is disabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Get(pprofHeapURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should serve the pprof profiles when profiling is enabled", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-pprof"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Get(pprofHeapURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		data, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		// the profile is a gzip compressed protocol buffer
+		Expect(len(data)).To(BeNumerically(">", 2))
+		Expect(data[:2]).To(Equal([]byte{0x1f, 0x8b}))
+
+		resp, err = client.Get("http://localhost/debug/pprof/")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		data, err = io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(string(data)).To(ContainSubstring("heap"))
+	})
+
+	It("should not serve /debug/dataset without a dataset", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Get(debugDatasetURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should report the dataset statistics", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--dataset-path", testDatasetPath, "--dataset-in-memory"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		for range 3 {
+			resp, err := client.Post("http://localhost/v1/completions", "application/json",
+				strings.NewReader(fmt.Sprintf(`{"model": "%s", "prompt": "Hello world!"}`, model)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+		}
+
+		resp, err := client.Get(debugDatasetURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		data, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+
+		var stats dataset.Stats
+		Expect(json.Unmarshal(data, &stats)).To(Succeed())
+		Expect(stats.DBMode).To(Equal(dataset.DBModeInMemory))
+		Expect(stats.RecordsCount).To(BeNumerically(">", 0))
+		Expect(stats.Responses).To(HaveLen(3))
+		total := int64(0)
+		for _, count := range stats.Responses {
+			total += count
+		}
+		Expect(total).To(Equal(int64(3)))
+
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err = io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		metrics := string(data)
+		for source, count := range stats.Responses {
+			metric := fmt.Sprintf(`sim_dataset_responses_total{source="%s"}`, source)
+			Expect(getGaugeValue(metrics, metric)).To(Equal(float64(count)))
+		}
+	})
+})
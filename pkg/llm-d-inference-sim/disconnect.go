@@ -0,0 +1,285 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
+	"github.com/valyala/fasthttp"
+)
+
+// disconnectPollInterval is the interval between the checks of the connection of a request
+const disconnectPollInterval = 50 * time.Millisecond
+
+// errRequestAborted is returned when the processing of a request was aborted because its client disconnected
+// or the request was cancelled
+var errRequestAborted = errors.New("the client disconnected or the request was cancelled, the request is aborted")
+
+// requestAbort signals that the processing of a request must be aborted, because its client disconnected
+// or the request was cancelled by its id
+type requestAbort struct {
+	// aborted is closed when the request is aborted
+	aborted   chan struct{}
+	abortOnce sync.Once
+	// cancelled is true if the request was cancelled by its id
+	cancelled atomic.Bool
+	// watcher watches the connection of the request, nil if the request was not received on a connection
+	watcher *disconnectWatcher
+}
+
+// abort closes the aborted channel, the request may be aborted more than once
+func (a *requestAbort) abort() {
+	a.abortOnce.Do(func() {
+		close(a.aborted)
+	})
+}
+
+// disconnectWatcher detects that the client of a request closed the connection before the response was sent.
+// The connection is checked by peeks with a short deadline, the server doesn't read the connection while
+// the request is processed. The peeked data stays in the connection's buffer for the server, a client
+// that pipelines its requests is not watched beyond its next request
+type disconnectWatcher struct {
+	conn *peekableConn
+	// requestAbort is aborted when the client closed the connection
+	requestAbort *requestAbort
+	// stopCh is closed when the watching should stop
+	stopCh chan struct{}
+	// stopped is closed when the watching goroutine exits
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// watch checks the connection until the client disconnects or the watching is stopped
+func (w *disconnectWatcher) watch() {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		default:
+		}
+		if err := w.conn.SetReadDeadline(time.Now().Add(disconnectPollInterval)); err != nil {
+			return
+		}
+		err := w.conn.peek()
+		if err == nil {
+			// the client already sent its next request, the connection is alive
+			return
+		}
+		var timeoutErr interface{ Timeout() bool }
+		if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+			continue
+		}
+		w.requestAbort.abort()
+		return
+	}
+}
+
+// stop ends the watching, the connection may be read by the server after stop returns
+func (w *disconnectWatcher) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		// interrupt the pending read, a deadline in the past doesn't interrupt a pending read of
+		// all connection types, so a near deadline is set until the read returns
+		for {
+			_ = w.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+			select {
+			case <-w.stopped:
+				_ = w.conn.SetReadDeadline(time.Time{})
+				return
+			case <-time.After(disconnectPollInterval):
+			}
+		}
+	})
+}
+
+// peekableListener is a listener whose connections can be peeked by the disconnect watchers
+type peekableListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection and returns it as a peekableConn
+func (l *peekableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &peekableConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// peekableConn is a connection whose incoming data can be peeked without taking it from the server,
+// the server reads the connection through the buffer of the peeked data. The connection is peeked
+// only while the server doesn't read it
+type peekableConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads the buffered peeked data and then the connection
+func (c *peekableConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// peek waits until the client sends data or the read deadline of the connection passes, returns nil
+// if the client sent data, the data is kept for the server
+func (c *peekableConn) peek() error {
+	_, err := c.reader.Peek(1)
+	return err
+}
+
+// getPeekableConn returns the peekable connection of a request, nil if the request was not received
+// on a connection of a peekableListener
+func getPeekableConn(conn net.Conn) *peekableConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// the TLS records are peeked on the underlying connection
+		conn = tlsConn.NetConn()
+	}
+	peekable, _ := conn.(*peekableConn)
+	return peekable
+}
+
+// watchDisconnect starts watching the connection of the request with the given id, returns a channel
+// that is closed when the client disconnects or the request is cancelled by cancelRequest. The connection
+// of a request that was not received on a connection, e.g. a replayed request, is not watched.
+// The watching is stopped by stopDisconnectWatch
+func (s *VllmSimulator) watchDisconnect(ctx *fasthttp.RequestCtx, requestID string) <-chan struct{} {
+	abort := &requestAbort{aborted: make(chan struct{})}
+	if conn := getPeekableConn(ctx.Conn()); conn != nil && ctx.ConnRequestNum() != 0 {
+		abort.watcher = &disconnectWatcher{
+			conn:         conn,
+			requestAbort: abort,
+			stopCh:       make(chan struct{}),
+			stopped:      make(chan struct{}),
+		}
+		go abort.watcher.watch()
+	}
+	s.requestAborts.Store(requestID, abort)
+	return abort.aborted
+}
+
+// stopDisconnectWatch stops watching the connection of the request with the given id,
+// the request cannot be cancelled afterwards
+func (s *VllmSimulator) stopDisconnectWatch(requestID string) {
+	if value, ok := s.requestAborts.LoadAndDelete(requestID); ok {
+		if watcher := value.(*requestAbort).watcher; watcher != nil {
+			watcher.stop()
+		}
+	}
+}
+
+// cancelRequest aborts the waiting or running request with the given id, returns false if there is
+// no such request
+func (s *VllmSimulator) cancelRequest(requestID string) bool {
+	value, ok := s.requestAborts.Load(requestID)
+	if !ok {
+		return false
+	}
+	abort := value.(*requestAbort)
+	abort.cancelled.Store(true)
+	abort.abort()
+	return true
+}
+
+// isCancelled returns true if the request with the given id was cancelled by cancelRequest,
+// false if its client disconnected
+func (s *VllmSimulator) isCancelled(requestID string) bool {
+	value, ok := s.requestAborts.Load(requestID)
+	return ok && value.(*requestAbort).cancelled.Load()
+}
+
+// abortWaitingRequest drops a request that left the waiting queue after its client disconnected or it
+// was cancelled, the request never runs, so it holds no running slot, prefill slot or kv cache blocks
+func (s *VllmSimulator) abortWaitingRequest(reqCtx *openaiserverapi.CompletionReqCtx) {
+	req := reqCtx.CompletionReq
+	requestID := req.GetRequestID()
+	s.logger.Info("The request is aborted while waiting", "request id", requestID)
+	// decrement waiting requests count
+	s.reportRequestTransition(req.GetModel(), abortedRequestState)
+	s.reportRequestAborted(s.getDisplayedModelName(req.GetModel()))
+	finishReasons := abortFinishReasons(len(req.GetPromptRequests()) * req.GetN())
+	s.traceRequest(requestID, 0, finishReasons)
+	s.publishRequestEnd(requestID, 0, finishReasons, errRequestAborted.Error())
+	s.logRequestEnd(requestID, 0, finishReasons)
+	s.sendCancelledError(reqCtx.HTTPReqCtx, requestID)
+	s.removeInFlightRequest(requestID)
+	s.stopDisconnectWatch(requestID)
+	// the dequeue counted the request in the running sequences of the waiting queue
+	s.waitingQueue.finishRequest(requestID)
+	// the blocks of the prompt if the request got them before the client disconnected
+	s.freeMemory(requestID)
+	reqCtx.Wg.Done()
+}
+
+// sendCancelledError sends the error response of a non-streaming request that was cancelled, no response
+// is sent to a client that disconnected
+func (s *VllmSimulator) sendCancelledError(ctx *fasthttp.RequestCtx, requestID string) {
+	if s.isCancelled(requestID) {
+		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(
+			fmt.Sprintf("The request '%s' was cancelled", requestID), fasthttp.StatusBadRequest, nil), "")
+	}
+}
+
+// HandleCancelRequest http handler for POST /v1/chat/completions/:request_id/cancel and
+// /v1/completions/:request_id/cancel, aborts a waiting or running completion request by its id,
+// the id is returned in the X-Request-Id header of the completion responses
+func (s *VllmSimulator) HandleCancelRequest(ctx *fasthttp.RequestCtx) {
+	requestID, _ := ctx.UserValue("request_id").(string)
+	if !s.cancelRequest(requestID) {
+		s.sendAPIError(ctx, fmt.Sprintf("No such in-flight request: '%s'", requestID), fasthttp.StatusNotFound)
+		return
+	}
+	s.logger.Info("Request cancelled", "request id", requestID)
+	s.sendAPIResponse(ctx, cancelledRequest{RequestID: requestID, Cancelled: true})
+}
+
+// cancelledRequest is the response of the cancellation of a request
+type cancelledRequest struct {
+	// RequestID is the id of the cancelled request
+	RequestID string `json:"request_id"`
+	// Cancelled is true if the request was cancelled
+	Cancelled bool `json:"cancelled"`
+}
+
+// isDisconnected returns true if the given channel of a disconnect watcher is closed,
+// a nil channel is never closed
+func isDisconnected(disconnected <-chan struct{}) bool {
+	select {
+	case <-disconnected:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportRequestAborted increments the counter of the requests of the given model that were aborted
+// because their clients disconnected or they were cancelled
+func (s *VllmSimulator) reportRequestAborted(model string) {
+	if s.requestAborted == nil {
+		// Happens in the tests
+		return
+	}
+	s.requestAborted.With(s.modelLabelValues(vllmapi.VllmRequestAborted, model)).Inc()
+}
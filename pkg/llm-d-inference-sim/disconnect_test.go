@@ -0,0 +1,324 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client disconnect", func() {
+	const (
+		abortedMetric = `vllm:request_aborted_total{model_name="my_model"}`
+		runningMetric = `vllm:num_requests_running{model_name="my_model"}`
+		waitingMetric = `vllm:num_requests_waiting{model_name="my_model"}`
+		// the number of requests that left the waiting queue and started running
+		queueTimeCountMetric = `vllm:request_queue_time_seconds_count{model_name="my_model"}`
+		chatBody             = `{"messages": [{"role": "user", "content": "Hello, how are you?"}], "model": "my_model",
+			"max_tokens": 50, "ignore_eos": true}`
+		chatStreamBody = `{"messages": [{"role": "user", "content": "Hello, how are you?"}], "model": "my_model",
+			"max_tokens": 50, "ignore_eos": true, "stream": true}`
+	)
+
+	getMetric := func(client *http.Client, metric string) float64 {
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return getGaugeValue(string(data), metric)
+	}
+
+	// sendCancelledRequest sends the given request and closes the connection after the given time
+	sendCancelledRequest := func(client *http.Client, body string, cancelAfter time.Duration) {
+		ctx, cancel := context.WithTimeout(context.Background(), cancelAfter)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/v1/chat/completions",
+			strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err == nil {
+			// a streaming response is read until the connection is closed
+			_, err = io.Copy(io.Discard, resp.Body)
+			Expect(resp.Body.Close()).To(Succeed())
+		}
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	}
+
+	DescribeTable("should abort a request whose client disconnected",
+		func(body string, extraArgs []string) {
+			ctx := context.TODO()
+			args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-num-seqs", "1"},
+				extraArgs...)
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			sendCancelledRequest(client, body, 300*time.Millisecond)
+
+			// the request is aborted long before its response would have been sent
+			Eventually(func() float64 {
+				return getMetric(client, abortedMetric)
+			}, time.Second, 50*time.Millisecond).Should(Equal(1.0))
+			Expect(getMetric(client, runningMetric)).To(Equal(0.0))
+			Expect(time.Since(start)).To(BeNumerically("<", 1500*time.Millisecond))
+
+			// the worker is free for the next request
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json",
+				strings.NewReader(`{"messages": [{"role": "user", "content": "Hi"}], "model": "my_model", "max_tokens": 1}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+		},
+		Entry("non-streaming, during the time to first token", chatBody,
+			[]string{"--time-to-first-token", "2000"}),
+		Entry("non-streaming, during the decode", chatBody,
+			[]string{"--inter-token-latency", "100"}),
+		Entry("streaming, during the time to first token", chatStreamBody,
+			[]string{"--time-to-first-token", "2000"}),
+		Entry("streaming, during the decode", chatStreamBody,
+			[]string{"--inter-token-latency", "100"}),
+	)
+
+	It("should not process a waiting request whose client disconnected", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-num-seqs", "1",
+			"--time-to-first-token", "1000"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json",
+				strings.NewReader(chatBody))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		// the second request waits for the worker of the first one
+		sendCancelledRequest(client, chatBody, 300*time.Millisecond)
+		Eventually(done, 2*time.Second).Should(BeClosed())
+		Eventually(func() float64 {
+			return getMetric(client, abortedMetric)
+		}, time.Second, 50*time.Millisecond).Should(Equal(1.0))
+		Expect(getMetric(client, runningMetric)).To(Equal(0.0))
+		Expect(getMetric(client, waitingMetric)).To(Equal(0.0))
+		// the aborted request never started running
+		Expect(getMetric(client, queueTimeCountMetric)).To(Equal(1.0))
+	})
+
+	Describe("cancellation by the request id", func() {
+		// cancelRequest cancels the request with the given id, returns the status code of the cancellation
+		cancelRequest := func(client *http.Client, requestID string) int {
+			resp, err := client.Post("http://localhost/v1/chat/completions/"+requestID+"/cancel", "application/json", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return resp.StatusCode
+		}
+
+		// sendRequest sends the given chat completion request with the given request id
+		sendRequest := func(client *http.Client, body string, requestID string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Request-Id", requestID)
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			return resp
+		}
+
+		It("should cancel a running non-streaming request", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-num-seqs", "1",
+				"--time-to-first-token", "3000", "--enable-admin-api"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				resp := sendRequest(client, chatBody, "request-to-cancel")
+				Expect(resp.Header.Get("X-Request-Id")).To(Equal("request-to-cancel"))
+				data, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Body.Close()).To(Succeed())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(string(data)).To(ContainSubstring("The request 'request-to-cancel' was cancelled"))
+			}()
+
+			start := time.Now()
+			Eventually(func() int {
+				return cancelRequest(client, "request-to-cancel")
+			}, time.Second, 20*time.Millisecond).Should(Equal(http.StatusOK))
+			Eventually(done, time.Second).Should(BeClosed())
+			Expect(time.Since(start)).To(BeNumerically("<", 1500*time.Millisecond))
+			Expect(getMetric(client, abortedMetric)).To(Equal(1.0))
+			Expect(getMetric(client, runningMetric)).To(Equal(0.0))
+			Expect(cancelRequest(client, "request-to-cancel")).To(Equal(http.StatusNotFound))
+		})
+
+		It("should cancel a waiting request without running it", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-num-seqs", "1",
+				"--time-to-first-token", "500", "--enable-admin-api"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			runningDone := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(runningDone)
+				resp := sendRequest(client, chatBody, "running-request")
+				Expect(resp.Body.Close()).To(Succeed())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			}()
+			Eventually(func() float64 {
+				return getMetric(client, runningMetric)
+			}, time.Second, 20*time.Millisecond).Should(Equal(1.0))
+
+			// the request waits for the worker of the running one
+			waitingDone := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(waitingDone)
+				resp := sendRequest(client, chatBody, "waiting-request")
+				Expect(resp.Body.Close()).To(Succeed())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			}()
+			Eventually(func() int {
+				return cancelRequest(client, "waiting-request")
+			}, time.Second, 20*time.Millisecond).Should(Equal(http.StatusOK))
+
+			Eventually(runningDone, 2*time.Second).Should(BeClosed())
+			Eventually(waitingDone, time.Second).Should(BeClosed())
+			Expect(getMetric(client, abortedMetric)).To(Equal(1.0))
+			Expect(getMetric(client, waitingMetric)).To(Equal(0.0))
+			Expect(getMetric(client, runningMetric)).To(Equal(0.0))
+			// only the first request started running
+			Expect(getMetric(client, queueTimeCountMetric)).To(Equal(1.0))
+		})
+
+		It("should cancel a stream and reject a duplicate request id", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--inter-token-latency", "100",
+				"--enable-admin-api"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp := sendRequest(client, chatStreamBody, "stream-to-cancel")
+			Expect(resp.Header.Get("X-Request-Id")).To(Equal("stream-to-cancel"))
+			reader := bufio.NewReader(resp.Body)
+			line, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(line).To(HavePrefix("data: "))
+
+			duplicate := sendRequest(client, chatBody, "stream-to-cancel")
+			Expect(duplicate.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(duplicate.Body.Close()).To(Succeed())
+
+			Expect(cancelRequest(client, "stream-to-cancel")).To(Equal(http.StatusOK))
+			data, err := io.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			// the stream ends without the done sentinel
+			Expect(string(data)).NotTo(ContainSubstring("[DONE]"))
+			Eventually(func() float64 {
+				return getMetric(client, abortedMetric)
+			}, time.Second, 50*time.Millisecond).Should(Equal(1.0))
+		})
+	})
+
+	It("should send the whole stream to a connected client", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--inter-token-latency", "10"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Post("http://localhost/v1/chat/completions", "application/json",
+			strings.NewReader(chatStreamBody))
+		Expect(err).NotTo(HaveOccurred())
+		reader := bufio.NewReader(resp.Body)
+		lastLine := ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			if strings.TrimSpace(line) != "" {
+				lastLine = strings.TrimSpace(line)
+			}
+		}
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(lastLine).To(Equal("data: [DONE]"))
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring(abortedMetric))
+	})
+
+	It("should keep the next request that a client sends on the connection while its request is processed", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--time-to-first-token", "300"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		conn, err := client.Transport.(*http.Transport).DialContext(ctx, "tcp", "localhost")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			Expect(conn.Close()).To(Succeed())
+		}()
+		request := "POST /v1/chat/completions HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(chatBody), chatBody)
+		_, err = conn.Write([]byte(request))
+		Expect(err).NotTo(HaveOccurred())
+		// the next request arrives while the connection of the first one is watched
+		time.Sleep(100 * time.Millisecond)
+		_, err = conn.Write([]byte(request))
+		Expect(err).NotTo(HaveOccurred())
+
+		reader := bufio.NewReader(conn)
+		for range 2 {
+			resp, err := http.ReadResponse(reader, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			_, err = io.Copy(io.Discard, resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+		}
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring(abortedMetric))
+	})
+})
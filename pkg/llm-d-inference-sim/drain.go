@@ -0,0 +1,98 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// drainingMessage is the error message of the completion requests received while the simulator drains
+	drainingMessage = "The server is shutting down and does not accept new requests."
+	// drainPollInterval is the interval of checking whether the in-flight requests finished
+	drainPollInterval = 50 * time.Millisecond
+)
+
+// requestDrain starts the drain of the simulator, the server stops when the drain ends
+func (s *VllmSimulator) requestDrain() {
+	s.drainOnce.Do(func() {
+		close(s.drainRequested)
+	})
+}
+
+// drain rejects new completion requests and waits until the in-flight requests finish, at most
+// drain-timeout, returns false if there are still requests in flight at the timeout
+func (s *VllmSimulator) drain() bool {
+	s.draining.Store(true)
+	s.logger.Info("Draining", "in-flight requests", s.countInFlightRequests(), "timeout", s.config.DrainTimeout)
+
+	deadline := time.Now().Add(s.config.DrainTimeout)
+	for {
+		inFlight := s.countInFlightRequests()
+		if inFlight == 0 {
+			s.logger.Info("Drained, all the in-flight requests finished")
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			s.logger.Info("Drain timeout, requests are still in flight", "in-flight requests", inFlight)
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// drainAndShutdown drains the simulator and shuts the server down, the connections of the requests
+// that are still in flight after the drain timeout are not waited for
+func (s *VllmSimulator) drainAndShutdown(server *fasthttp.Server) error {
+	if s.drain() {
+		if err := server.Shutdown(); err != nil {
+			s.logger.Error(err, "Error during server shutdown")
+			return err
+		}
+	} else {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// closes the listener and the idle connections without waiting for the open ones
+		_ = server.ShutdownWithContext(ctx)
+	}
+
+	s.logger.Info("Server stopped")
+	return nil
+}
+
+// countInFlightRequests returns the number of waiting and running requests
+func (s *VllmSimulator) countInFlightRequests() int {
+	count := 0
+	s.inFlightRequests.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// HandleDrain http handler for POST /_sim/drain, starts the drain of the simulator, the simulator
+// stops when its in-flight requests finish or the drain timeout elapses
+func (s *VllmSimulator) HandleDrain(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("drain request received")
+	s.requestDrain()
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusAccepted)
+	ctx.Response.SetBody([]byte(`{"status":"` + readinessDraining + `"}`))
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Drain", func() {
+	const (
+		drainURL      = "http://localhost/_sim/drain"
+		readyURL      = "http://localhost/ready?verbose=true"
+		chatURL       = "http://localhost/v1/chat/completions"
+		longChatBody  = `{"messages": [{"role": "user", "content": "Hello"}], "model": "my_model", "max_tokens": 10, "ignore_eos": true}`
+		shortChatBody = `{"messages": [{"role": "user", "content": "Hello"}], "model": "my_model", "max_tokens": 1}`
+	)
+
+	// sendChat sends a chat completion request in the background, the returned channel receives
+	// the status code of its response, or 0 if the request failed
+	sendChat := func(client *http.Client, body string) <-chan int {
+		statusCode := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := client.Post(chatURL, "application/json", strings.NewReader(body))
+			if err != nil {
+				statusCode <- 0
+				return
+			}
+			_, err = io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			statusCode <- resp.StatusCode
+		}()
+		return statusCode
+	}
+
+	It("should finish the in-flight requests, reject new ones and stop", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--inter-token-latency", "50", "--drain-timeout", "5s"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		inFlight := sendChat(client, longChatBody)
+		Eventually(func() int64 {
+			return getSimStatus(client).Requests.Running
+		}, time.Second, 10*time.Millisecond).Should(Equal(int64(1)))
+
+		resp, err := client.Post(drainURL, "application/json", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Expect(resp.Body.Close()).To(Succeed())
+
+		// while draining, the simulator is not ready and rejects new completions
+		Eventually(func() int {
+			resp, err := client.Get(readyURL)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(resp.Body.Close()).To(Succeed())
+			}()
+			return resp.StatusCode
+		}, time.Second, 10*time.Millisecond).Should(Equal(http.StatusServiceUnavailable))
+		resp, err = client.Get(readyURL)
+		Expect(err).NotTo(HaveOccurred())
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		var readiness readinessResponse
+		Expect(json.Unmarshal(body, &readiness)).To(Succeed())
+		Expect(readiness.Status).To(Equal(readinessDraining))
+
+		Expect(<-sendChat(client, shortChatBody)).To(Equal(http.StatusServiceUnavailable))
+
+		// the in-flight request is completed, then the server stops
+		Eventually(inFlight, 2*time.Second).Should(Receive(Equal(http.StatusOK)))
+		Eventually(func() error {
+			_, err := client.Get(readyURL)
+			return err
+		}, time.Second, 50*time.Millisecond).Should(HaveOccurred())
+	})
+
+	It("should wait for an in-flight embedding request and reject new ones", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--time-to-first-token", "500", "--drain-timeout", "5s"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		embeddingBody := `{"model": "my_model", "input": "Hello"}`
+		inFlight := make(chan int, 1)
+		go func() {
+			defer GinkgoRecover()
+			statusCode, _ := postEmbeddings(client, embeddingBody)
+			inFlight <- statusCode
+		}()
+		Eventually(func() int64 {
+			return getSimStatus(client).Requests.Running
+		}, time.Second, 10*time.Millisecond).Should(Equal(int64(1)))
+
+		resp, err := client.Post(drainURL, "application/json", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+
+		Eventually(func() int {
+			statusCode, _ := postEmbeddings(client, embeddingBody)
+			return statusCode
+		}, time.Second, 10*time.Millisecond).Should(Equal(http.StatusServiceUnavailable))
+		Eventually(inFlight, 2*time.Second).Should(Receive(Equal(http.StatusOK)))
+	})
+
+	It("should stop at the drain timeout when requests are still in flight", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--time-to-first-token", "5000", "--drain-timeout", "200ms"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		sendChat(client, shortChatBody)
+		Eventually(func() int64 {
+			return getSimStatus(client).Requests.Running
+		}, time.Second, 10*time.Millisecond).Should(Equal(int64(1)))
+
+		start := time.Now()
+		resp, err := client.Post(drainURL, "application/json", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+
+		Eventually(func() error {
+			_, err := client.Get(readyURL)
+			return err
+		}, 2*time.Second, 50*time.Millisecond).Should(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+	})
+})
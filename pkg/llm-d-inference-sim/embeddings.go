@@ -0,0 +1,246 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Handling of the /v1/embeddings API
+package llmdinferencesim
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+const (
+	embeddingIDPrefix = "embd-"
+	embeddingObject   = "embedding"
+	listObject        = "list"
+)
+
+// HandleEmbeddings http handler for /v1/embeddings, the request waits in the waiting queue and is
+// processed by a worker like a completion request
+func (s *VllmSimulator) HandleEmbeddings(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("embedding request received")
+	if s.draining.Load() {
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(drainingMessage,
+			fasthttp.StatusServiceUnavailable, nil), "")
+		return
+	}
+	if s.isModelLoading() {
+		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(modelLoadingMessage,
+			fasthttp.StatusServiceUnavailable, nil), "")
+		return
+	}
+	failureType, injectedBy, ok := s.getInjectedFailure(ctx)
+	if !ok {
+		return
+	}
+	switch failureType {
+	case "", common.FailureTypeMissingDone, common.FailureTypeMissingContentType, common.FailureTypeWrongContentType,
+		common.FailureTypeStreamError, common.FailureTypeStreamMalformed:
+		// the failures of the completion responses are not injected in the embeddings
+	default:
+		s.reportInjectedFailure(failureType)
+		s.sendCompletionFailure(ctx, getFailure(s.config, failureType), injectedBy)
+		return
+	}
+
+	var req openaiserverapi.EmbeddingRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError("Failed to read and parse request body, "+
+			err.Error(), fasthttp.StatusBadRequest, nil), "")
+		return
+	}
+
+	// the inputs are scheduled and processed together like the prompts of a batch
+	completionReq := req.ToTextCompletionRequest()
+	completionReq.RequestID = s.newRequestID(ctx)
+	errMsg, errCode := s.validateEmbeddingRequest(&req, completionReq.RequestID)
+	if errMsg != "" {
+		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(errMsg, errCode, nil), "")
+		return
+	}
+	// the request can be cancelled by its id
+	ctx.Response.Header.Set(requestIDHeader, completionReq.RequestID)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	reqCtx := &openaiserverapi.CompletionReqCtx{
+		CompletionReq: completionReq,
+		HTTPReqCtx:    ctx,
+		Wg:            &wg,
+		EmbeddingReq:  &req,
+		Disconnected:  s.watchDisconnect(ctx, completionReq.RequestID),
+	}
+	if !s.enqueueRequest(reqCtx) {
+		return
+	}
+	wg.Wait()
+}
+
+// processEmbeddingRequest simulates the prefill of the inputs of an embedding request that was taken
+// from the waiting queue and sends its response, modelName is the display name of the model
+func (s *VllmSimulator) processEmbeddingRequest(reqCtx *openaiserverapi.CompletionReqCtx, modelName string) {
+	req := reqCtx.EmbeddingReq
+	requestID := reqCtx.CompletionReq.GetRequestID()
+	nPromptTokens := reqCtx.CompletionReq.GetNumberOfPromptTokens()
+	nCachedPromptTokens := reqCtx.CompletionReq.GetNumberOfCachedPromptTokens()
+	prefillTime := s.getWaitTimeToFirstToken(req.Model, nPromptTokens, nCachedPromptTokens, false)
+	nPrefillTokens := localPrefillTokens(nPromptTokens, nCachedPromptTokens, false)
+	s.reportIterationTokens(modelName, nPrefillTokens)
+	endPrefill := s.startPrefill(nPrefillTokens)
+	s.markPrefillStart(requestID, false)
+	sleepBefore(prefillTime, s.getTimeScale(), time.Time{}, reqCtx.Disconnected)
+	endPrefill()
+	s.releasePrefillSlot()
+
+	if isDisconnected(reqCtx.Disconnected) {
+		// no response is sent to a client that disconnected, a cancelled request gets an error
+		s.logger.Info("The request is aborted", "request id", requestID)
+		s.reportRequestAborted(modelName)
+		s.traceRequest(requestID, 0, nil)
+		s.publishRequestEnd(requestID, 0, nil, errRequestAborted.Error())
+		s.logRequestEnd(requestID, 0, nil)
+		s.sendCancelledError(reqCtx.HTTPReqCtx, requestID)
+		s.responseSentCallback(modelName, requestID)
+		return
+	}
+
+	dimensions := s.config.EmbeddingDim
+	if req.Dimensions != nil {
+		dimensions = *req.Dimensions
+	}
+	resp := openaiserverapi.EmbeddingResponse{
+		ID:      embeddingIDPrefix + s.random.UUIDString(),
+		Object:  listObject,
+		Created: s.externalNow().Unix(),
+		Model:   modelName,
+		Data:    make([]openaiserverapi.EmbeddingData, 0, len(req.Inputs)),
+		Usage:   openaiserverapi.EmbeddingUsage{PromptTokens: nPromptTokens, TotalTokens: nPromptTokens},
+	}
+	for i, input := range req.Inputs {
+		embedding := createEmbedding(input, dimensions)
+		data := openaiserverapi.EmbeddingData{Object: embeddingObject, Index: i, Embedding: embedding}
+		if req.EncodingFormat == openaiserverapi.EncodingFormatBase64 {
+			data.Embedding = encodeEmbedding(embedding)
+		}
+		resp.Data = append(resp.Data, data)
+	}
+
+	s.sendEmbeddingResponse(reqCtx.HTTPReqCtx, &resp)
+	s.recordUsage(requestID, modelName, 0)
+	s.traceRequest(requestID, 0, nil)
+	s.publishRequestEnd(requestID, 0, nil, "")
+	s.logRequestEnd(requestID, 0, nil)
+	s.responseSentCallback(modelName, requestID)
+}
+
+// validateEmbeddingRequest validates the given embedding request with the given request id, returns an
+// error message and status code, the message is empty if the request is valid
+func (s *VllmSimulator) validateEmbeddingRequest(req *openaiserverapi.EmbeddingRequest, requestID string) (string, int) {
+	if !s.isValidModel(req.Model) {
+		return fmt.Sprintf("The model `%s` does not exist.", req.Model), fasthttp.StatusNotFound
+	}
+	if _, inFlight := s.inFlightRequests.Load(requestID); inFlight {
+		return fmt.Sprintf("A request with id '%s' is already in flight", requestID), fasthttp.StatusBadRequest
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != openaiserverapi.EncodingFormatFloat &&
+		req.EncodingFormat != openaiserverapi.EncodingFormatBase64 {
+		return fmt.Sprintf("Invalid encoding_format '%s', valid values are: %s, %s", req.EncodingFormat,
+			openaiserverapi.EncodingFormatFloat, openaiserverapi.EncodingFormatBase64), fasthttp.StatusBadRequest
+	}
+	if req.Dimensions != nil && (*req.Dimensions <= 0 || *req.Dimensions > s.config.EmbeddingDim) {
+		return fmt.Sprintf("Dimensions must be between 1 and %d", s.config.EmbeddingDim), fasthttp.StatusBadRequest
+	}
+	// every input is embedded separately, so each one must fit in the context window
+	maxModelLen := s.getMaxModelLen(req.Model)
+	for i, input := range req.Inputs {
+		if nTokens := input.NumberOfTokens(); nTokens > maxModelLen {
+			return fmt.Sprintf("This model's maximum context length is %d tokens. However, input %d has %d tokens. "+
+				"Please reduce the length of the input", maxModelLen, i, nTokens), fasthttp.StatusBadRequest
+		}
+	}
+	return "", fasthttp.StatusOK
+}
+
+// createEmbedding returns a unit length embedding of the given input with the given number of dimensions,
+// the embedding depends only on the input, so the same input always gets the same embedding
+func createEmbedding(input openaiserverapi.EmbeddingInput, dimensions int) []float32 {
+	hash := sha256.New()
+	if input.TokenIDs != nil {
+		for _, id := range input.TokenIDs {
+			hash.Write([]byte(strconv.FormatInt(id, 10) + ","))
+		}
+	} else {
+		hash.Write([]byte(input.Text))
+	}
+	seed := int64(binary.BigEndian.Uint64(hash.Sum(nil)[:8]))
+	generator := rand.New(rand.NewSource(seed))
+
+	embedding := make([]float32, dimensions)
+	sumOfSquares := 0.0
+	for i := range embedding {
+		value := generator.NormFloat64()
+		embedding[i] = float32(value)
+		sumOfSquares += value * value
+	}
+	norm := math.Sqrt(sumOfSquares)
+	if norm > 0 {
+		for i := range embedding {
+			embedding[i] = float32(float64(embedding[i]) / norm)
+		}
+	}
+	return embedding
+}
+
+// encodeEmbedding returns the base64 encoding of the little-endian float32 values of the given embedding
+func encodeEmbedding(embedding []float32) string {
+	data := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// sendEmbeddingResponse sends the given embedding response
+func (s *VllmSimulator) sendEmbeddingResponse(ctx *fasthttp.RequestCtx, resp *openaiserverapi.EmbeddingResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError("Response body creation failed, "+err.Error(),
+			fasthttp.StatusInternalServerError, nil), "")
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	if s.pod != "" {
+		ctx.Response.Header.Add(podHeader, s.pod)
+	}
+	if s.namespace != "" {
+		ctx.Response.Header.Add(namespaceHeader, s.namespace)
+	}
+	ctx.Response.SetBody(data)
+}
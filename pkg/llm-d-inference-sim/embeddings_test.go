@@ -0,0 +1,276 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const embeddingsURL = "http://localhost/v1/embeddings"
+
+// postEmbeddings sends the given embedding request body, returns the status code and the response body
+func postEmbeddings(client *http.Client, body string) (int, []byte) {
+	resp, err := client.Post(embeddingsURL, "application/json", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.Body.Close()).To(Succeed())
+	return resp.StatusCode, data
+}
+
+func expectUnitLength(embedding []float64) {
+	sumOfSquares := 0.0
+	for _, value := range embedding {
+		sumOfSquares += value * value
+	}
+	Expect(math.Sqrt(sumOfSquares)).To(BeNumerically("~", 1.0, 1e-5))
+}
+
+var _ = Describe("Embeddings", func() {
+	It("should return the embedding of a text", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		resp, err := openaiclient.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Model:          model,
+			Input:          openai.EmbeddingNewParamsInputUnion{OfString: openai.String(userMessage)},
+			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Model).To(Equal(model))
+		Expect(string(resp.Object)).To(Equal("list"))
+		Expect(resp.Data).To(HaveLen(1))
+		Expect(resp.Data[0].Index).To(Equal(int64(0)))
+		Expect(resp.Data[0].Embedding).To(HaveLen(384))
+		expectUnitLength(resp.Data[0].Embedding)
+		nTokens := int64(len(common.Tokenize(userMessage)))
+		Expect(resp.Usage.PromptTokens).To(Equal(nTokens))
+		Expect(resp.Usage.TotalTokens).To(Equal(nTokens))
+	})
+
+	It("should return deterministic embeddings of a batch", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--embedding-dim", "16"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		inputs := []string{"first input", "second input", "first input"}
+		openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		resp, err := openaiclient.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Model:          model,
+			Input:          openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: inputs},
+			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Data).To(HaveLen(3))
+		expectedTokens := 0
+		for i, data := range resp.Data {
+			Expect(data.Index).To(Equal(int64(i)))
+			Expect(data.Embedding).To(HaveLen(16))
+			expectUnitLength(data.Embedding)
+			expectedTokens += len(common.Tokenize(inputs[i]))
+		}
+		Expect(resp.Data[0].Embedding).To(Equal(resp.Data[2].Embedding))
+		Expect(resp.Data[0].Embedding).NotTo(Equal(resp.Data[1].Embedding))
+		Expect(resp.Usage.PromptTokens).To(Equal(int64(expectedTokens)))
+
+		// the same input gets the same embedding in another request
+		again, err := openaiclient.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Model:          model,
+			Input:          openai.EmbeddingNewParamsInputUnion{OfString: openai.String(inputs[1])},
+			EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again.Data[0].Embedding).To(Equal(resp.Data[1].Embedding))
+	})
+
+	It("should count the tokens of token ids inputs", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, body := postEmbeddings(client,
+			fmt.Sprintf(`{"model": "%s", "input": [[1, 2, 3], [4, 5]], "dimensions": 8}`, model))
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var resp openai.CreateEmbeddingResponse
+		Expect(json.Unmarshal(body, &resp)).To(Succeed())
+		Expect(resp.Data).To(HaveLen(2))
+		Expect(resp.Data[0].Embedding).To(HaveLen(8))
+		Expect(resp.Usage.PromptTokens).To(Equal(int64(5)))
+	})
+
+	It("should encode the embeddings in base64", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, body := postEmbeddings(client,
+			fmt.Sprintf(`{"model": "%s", "input": "%s", "encoding_format": "float"}`, model, userMessage))
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var floatResp openai.CreateEmbeddingResponse
+		Expect(json.Unmarshal(body, &floatResp)).To(Succeed())
+
+		statusCode, body = postEmbeddings(client,
+			fmt.Sprintf(`{"model": "%s", "input": "%s", "encoding_format": "base64"}`, model, userMessage))
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var base64Resp struct {
+			Data []struct {
+				Embedding string `json:"embedding"`
+			} `json:"data"`
+		}
+		Expect(json.Unmarshal(body, &base64Resp)).To(Succeed())
+		Expect(base64Resp.Data).To(HaveLen(1))
+		data, err := base64.StdEncoding.DecodeString(base64Resp.Data[0].Embedding)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(HaveLen(4 * len(floatResp.Data[0].Embedding)))
+		for i, value := range floatResp.Data[0].Embedding {
+			decoded := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+			Expect(decoded).To(Equal(float32(value)))
+		}
+	})
+
+	DescribeTable("should reject invalid requests",
+		func(body string, expectedCode int, expectedMessage string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-model-len", "10"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			statusCode, data := postEmbeddings(client, body)
+			Expect(statusCode).To(Equal(expectedCode))
+			// every rejection is an error response, including a body that cannot be parsed
+			var errorResp openaiserverapi.ErrorResponse
+			Expect(json.Unmarshal(data, &errorResp)).To(Succeed())
+			Expect(errorResp.Error.Code).To(Equal(expectedCode))
+			Expect(errorResp.Error.Message).To(ContainSubstring(expectedMessage))
+		},
+		Entry("unknown model", `{"model": "unknown", "input": "hi"}`, http.StatusNotFound, "does not exist"),
+		Entry("missing input", `{"model": "my_model"}`, http.StatusBadRequest, "input is required"),
+		Entry("empty input", `{"model": "my_model", "input": []}`, http.StatusBadRequest, "input cannot be an empty array"),
+		Entry("invalid input", `{"model": "my_model", "input": {"text": "hi"}}`, http.StatusBadRequest, "input must be"),
+		Entry("invalid encoding format", `{"model": "my_model", "input": "hi", "encoding_format": "int8"}`,
+			http.StatusBadRequest, "Invalid encoding_format"),
+		Entry("too many dimensions", `{"model": "my_model", "input": "hi", "dimensions": 1000}`,
+			http.StatusBadRequest, "Dimensions must be between 1 and 384"),
+		Entry("input longer than the context", `{"model": "my_model", "input": [[1], [1,2,3,4,5,6,7,8,9,10,11]]}`,
+			http.StatusBadRequest, "input 1 has 11 tokens"),
+	)
+
+	It("should simulate the latency and count the request in the metrics", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--time-to-first-token", "1000"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			statusCode, _ := postEmbeddings(client, fmt.Sprintf(`{"model": "%s", "input": "hi"}`, model))
+			Expect(statusCode).To(Equal(http.StatusOK))
+		}()
+
+		runningMetric := fmt.Sprintf(`vllm:num_requests_running{model_name="%s"}`, model)
+		getRunning := func() float64 {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return getGaugeValue(string(data), runningMetric)
+		}
+		Eventually(getRunning, 500*time.Millisecond, 20*time.Millisecond).Should(Equal(1.0))
+
+		Eventually(done, 2*time.Second).Should(BeClosed())
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		Eventually(getRunning, 500*time.Millisecond, 20*time.Millisecond).Should(Equal(0.0))
+	})
+
+	It("should wait in the waiting queue for a free worker", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-num-seqs", "1",
+			"--time-to-first-token", "500", "--enable-admin-api"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		done := make(chan struct{}, 2)
+		for range 2 {
+			go func() {
+				defer GinkgoRecover()
+				statusCode, _ := postEmbeddings(client, fmt.Sprintf(`{"model": "%s", "input": "hi"}`, model))
+				Expect(statusCode).To(Equal(http.StatusOK))
+				done <- struct{}{}
+			}()
+		}
+
+		Eventually(func() []int {
+			queue := getDebugQueue(client)
+			return []int{len(queue.Running), len(queue.Waiting)}
+		}, 300*time.Millisecond, 20*time.Millisecond).Should(Equal([]int{1, 1}))
+
+		Eventually(done, 2*time.Second).Should(Receive())
+		Eventually(done, 2*time.Second).Should(Receive())
+		// the second request is processed after the first one
+		Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+	})
+
+	It("should inject failures", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--failure-injection-rate", "100", "--failure-types", common.FailureTypeRateLimit}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, data := postEmbeddings(client, fmt.Sprintf(`{"model": "%s", "input": "hi"}`, model))
+		Expect(statusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(string(data)).To(ContainSubstring("Rate limit reached"))
+	})
+})
+
+var _ = Describe("Embedding request", func() {
+	DescribeTable("should parse the input",
+		func(input string, expected []openaiserverapi.EmbeddingInput) {
+			var req openaiserverapi.EmbeddingRequest
+			Expect(json.Unmarshal([]byte(`{"model": "my_model", "input": `+input+`}`), &req)).To(Succeed())
+			Expect(req.Inputs).To(Equal(expected))
+		},
+		Entry("text", `"hello"`, []openaiserverapi.EmbeddingInput{{Text: "hello"}}),
+		Entry("texts", `["hello", "world"]`, []openaiserverapi.EmbeddingInput{{Text: "hello"}, {Text: "world"}}),
+		Entry("token ids", `[1, 2]`, []openaiserverapi.EmbeddingInput{{TokenIDs: []int64{1, 2}}}),
+		Entry("batch of token ids", `[[1], [2, 3]]`,
+			[]openaiserverapi.EmbeddingInput{{TokenIDs: []int64{1}}, {TokenIDs: []int64{2, 3}}}),
+	)
+})
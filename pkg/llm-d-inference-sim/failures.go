@@ -18,15 +18,28 @@ package llmdinferencesim
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 )
 
+const (
+	// injectedByRate means the failure was injected according to the failure injection rate
+	injectedByRate = "rate"
+	// injectedByHeader means the failure was requested in the request's header
+	injectedByHeader = "header"
+	// injectedByScenario means the failure was injected by a targeted scenario according to the request's
+	// prompt size or model
+	injectedByScenario = "scenario"
+)
+
 const (
 	// Error message templates
 	rateLimitMessageTemplate     = "Rate limit reached for %s in organization org-xxx on requests per min (RPM): Limit 3, Used 3, Requested 1."
 	modelNotFoundMessageTemplate = "The model '%s-nonexistent' does not exist"
+	// the message of a model not found failure of a model in model-not-found-failure-models
+	targetedModelNotFoundMessageTemplate = "The model '%s' does not exist"
 )
 
 var predefinedFailures = map[string]openaiserverapi.CompletionError{
@@ -44,16 +57,16 @@ var predefinedFailures = map[string]openaiserverapi.CompletionError{
 }
 
 // shouldInjectFailure determines whether to inject a failure based on configuration
-func shouldInjectFailure(config *common.Configuration) bool {
+func shouldInjectFailure(config *common.Configuration, random *common.Random) bool {
 	if config.FailureInjectionRate == 0 {
 		return false
 	}
 
-	return common.RandomInt(1, 100) <= config.FailureInjectionRate
+	return random.Int(1, 100) <= config.FailureInjectionRate
 }
 
-// getRandomFailure returns a random failure from configured types or all types if none specified
-func getRandomFailure(config *common.Configuration) openaiserverapi.CompletionError {
+// getRandomFailureType returns a random failure type from configured types or all error types if none specified
+func getRandomFailureType(config *common.Configuration, random *common.Random) string {
 	var availableFailures []string
 	if len(config.FailureTypes) == 0 {
 		// Use all failure types if none specified
@@ -66,23 +79,55 @@ func getRandomFailure(config *common.Configuration) openaiserverapi.CompletionEr
 
 	if len(availableFailures) == 0 {
 		// Fallback to server_error if no valid types
-		return predefinedFailures[common.FailureTypeServerError]
+		return common.FailureTypeServerError
 	}
 
-	randomIndex := common.RandomInt(0, len(availableFailures)-1)
-	randomType := availableFailures[randomIndex]
+	randomIndex := random.Int(0, len(availableFailures)-1)
+	return availableFailures[randomIndex]
+}
+
+// getRandomFailure returns a random failure from configured types or all types if none specified
+func getRandomFailure(config *common.Configuration, random *common.Random) openaiserverapi.CompletionError {
+	return getFailure(config, getRandomFailureType(config, random))
+}
 
+// getFailure returns the error of the given failure type
+func getFailure(config *common.Configuration, failureType string) openaiserverapi.CompletionError {
 	// Customize message with current model name
-	failure := predefinedFailures[randomType]
-	if randomType == common.FailureTypeRateLimit && config.Model != "" {
+	failure := predefinedFailures[failureType]
+	if failureType == common.FailureTypeRateLimit && config.Model != "" {
 		failure.Message = fmt.Sprintf(rateLimitMessageTemplate, config.Model)
-	} else if randomType == common.FailureTypeModelNotFound && config.Model != "" {
+	} else if failureType == common.FailureTypeModelNotFound && config.Model != "" {
 		failure.Message = fmt.Sprintf(modelNotFoundMessageTemplate, config.Model)
 	}
 
 	return failure
 }
 
+// getTargetedFailureType returns the failure type injected by the targeted scenarios into a request
+// to the given model with the given number of prompt tokens, empty if no failure is injected
+func getTargetedFailureType(config *common.Configuration, random *common.Random, model string,
+	nPromptTokens int) string {
+	if slices.Contains(config.ModelNotFoundFailureModels, model) {
+		return common.FailureTypeModelNotFound
+	}
+	if config.ContextLengthFailureRate > 0 && nPromptTokens >= config.ContextLengthFailureMinPromptTokens &&
+		random.Int(1, 100) <= config.ContextLengthFailureRate {
+		return common.FailureTypeContextLength
+	}
+	return ""
+}
+
+// getTargetedFailure returns the error of a failure type injected by the targeted scenarios into
+// a request to the given model
+func getTargetedFailure(config *common.Configuration, failureType string, model string) openaiserverapi.CompletionError {
+	failure := getFailure(config, failureType)
+	if failureType == common.FailureTypeModelNotFound {
+		failure.Message = fmt.Sprintf(targetedModelNotFoundMessageTemplate, model)
+	}
+	return failure
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
@@ -18,7 +18,9 @@ package llmdinferencesim
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -26,6 +28,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
@@ -33,8 +36,10 @@ import (
 
 var _ = Describe("Failures", func() {
 	Describe("getRandomFailure", Ordered, func() {
+		var random *common.Random
+
 		BeforeAll(func() {
-			common.InitRandom(time.Now().UnixNano())
+			random = common.NewRandom(time.Now().UnixNano())
 		})
 
 		It("should return a failure from all types when none specified", func() {
@@ -42,7 +47,7 @@ var _ = Describe("Failures", func() {
 				Model:        "test-model",
 				FailureTypes: []string{},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(BeNumerically(">=", 400))
 			Expect(failure.Message).ToNot(BeEmpty())
 			Expect(failure.Type).ToNot(BeEmpty())
@@ -53,7 +58,7 @@ var _ = Describe("Failures", func() {
 				Model:        "test-model",
 				FailureTypes: []string{common.FailureTypeRateLimit},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(Equal(429))
 			Expect(failure.Type).To(Equal(openaiserverapi.ErrorCodeToType(429)))
 			Expect(strings.Contains(failure.Message, "test-model")).To(BeTrue())
@@ -63,7 +68,7 @@ var _ = Describe("Failures", func() {
 			config := &common.Configuration{
 				FailureTypes: []string{common.FailureTypeInvalidAPIKey},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(Equal(401))
 			Expect(failure.Type).To(Equal(openaiserverapi.ErrorCodeToType(401)))
 			Expect(failure.Message).To(Equal("Incorrect API key provided."))
@@ -73,7 +78,7 @@ var _ = Describe("Failures", func() {
 			config := &common.Configuration{
 				FailureTypes: []string{common.FailureTypeContextLength},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(Equal(400))
 			Expect(failure.Type).To(Equal(openaiserverapi.ErrorCodeToType(400)))
 			Expect(failure.Param).ToNot(BeNil())
@@ -84,7 +89,7 @@ var _ = Describe("Failures", func() {
 			config := &common.Configuration{
 				FailureTypes: []string{common.FailureTypeServerError},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(Equal(503))
 			Expect(failure.Type).To(Equal(openaiserverapi.ErrorCodeToType(503)))
 		})
@@ -94,7 +99,7 @@ var _ = Describe("Failures", func() {
 				Model:        "test-model",
 				FailureTypes: []string{common.FailureTypeModelNotFound},
 			}
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(Equal(404))
 			Expect(failure.Type).To(Equal(openaiserverapi.ErrorCodeToType(404)))
 			Expect(strings.Contains(failure.Message, "test-model-nonexistent")).To(BeTrue())
@@ -105,7 +110,7 @@ var _ = Describe("Failures", func() {
 				FailureTypes: []string{},
 			}
 			// This test is probabilistic since it randomly selects, but we can test structure
-			failure := getRandomFailure(config)
+			failure := getRandomFailure(config, random)
 			Expect(failure.Code).To(BeNumerically(">=", 400))
 			Expect(failure.Type).ToNot(BeEmpty())
 		})
@@ -270,5 +275,228 @@ var _ = Describe("Failures", func() {
 				Entry("model_not_found", common.FailureTypeModelNotFound, 404, openaiserverapi.ErrorCodeToType(404)),
 			)
 		})
+
+		Context("with failure injection headers", func() {
+			DescribeTable("should fail only the request with the header",
+				func(failureType string, expectedStatusCode int) {
+					ctx := context.Background()
+					client, err := startServerWithArgs(ctx, "", []string{
+						"cmd", "--model", model, "--allow-injection-headers",
+					}, nil)
+					Expect(err).ToNot(HaveOccurred())
+
+					openaiClient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+					for i := range 5 {
+						if i != 2 {
+							_, err = openaiClient.Chat.Completions.New(ctx, params)
+							Expect(err).ToNot(HaveOccurred())
+							continue
+						}
+						_, err = openaiClient.Chat.Completions.New(ctx, params,
+							option.WithHeader(injectFailureHeader, failureType), option.WithMaxRetries(0))
+						Expect(err).To(HaveOccurred())
+						var openaiError *openai.Error
+						Expect(errors.As(err, &openaiError)).To(BeTrue())
+						Expect(openaiError.StatusCode).To(Equal(expectedStatusCode))
+						Expect(openaiError.Type).To(Equal(openaiserverapi.ErrorCodeToType(expectedStatusCode)))
+					}
+				},
+				Entry("rate_limit", common.FailureTypeRateLimit, 429),
+				Entry("server_error", common.FailureTypeServerError, 503),
+				Entry("model_not_found", common.FailureTypeModelNotFound, 404),
+			)
+
+			It("should inject the header failure regardless of the configured failure types", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, "", []string{
+					"cmd", "--model", model, "--allow-injection-headers",
+					"--failure-injection-rate", "100", "--failure-types", common.FailureTypeRateLimit,
+				}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+				_, err = openaiClient.Chat.Completions.New(ctx, params,
+					option.WithHeader(injectFailureHeader, common.FailureTypeInvalidAPIKey))
+				Expect(err).To(HaveOccurred())
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(401))
+			})
+
+			It("should reject an invalid failure type in the header", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, "", []string{
+					"cmd", "--model", model, "--allow-injection-headers",
+				}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+				_, err = openaiClient.Chat.Completions.New(ctx, params, option.WithHeader(injectFailureHeader, "timeout"))
+				Expect(err).To(HaveOccurred())
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(400))
+				Expect(openaiError.Message).To(ContainSubstring("Invalid failure type 'timeout'"))
+			})
+
+			It("should ignore the header when injection headers are not allowed", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, "", []string{"cmd", "--model", model}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+				_, err = openaiClient.Chat.Completions.New(ctx, params,
+					option.WithHeader(injectFailureHeader, common.FailureTypeServerError))
+				Expect(err).ToNot(HaveOccurred())
+				_, err = openaiClient.Chat.Completions.New(ctx, params, option.WithHeader(injectFailureHeader, "timeout"))
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("with content type failures", func() {
+			const chatBody = `{"messages": [{"role": "user", "content": "Hello"}], "model": "my_model"}`
+
+			// postChat sends a chat completion request and returns the response's content type and parsed body
+			postChat := func(client *http.Client, body string) (string, bool, map[string]any) {
+				resp, err := client.Post("http://localhost/v1/chat/completions", "application/json",
+					strings.NewReader(body))
+				Expect(err).ToNot(HaveOccurred())
+				defer func() {
+					Expect(resp.Body.Close()).To(Succeed())
+				}()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				data, err := io.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				var parsed map[string]any
+				if !strings.HasPrefix(string(data), "data: ") {
+					Expect(json.Unmarshal(data, &parsed)).To(Succeed())
+				}
+				_, hasContentType := resp.Header["Content-Type"]
+				return resp.Header.Get("Content-Type"), hasContentType, parsed
+			}
+
+			DescribeTable("should corrupt the content type of a non-streaming response",
+				func(failureType string, expectedContentType string) {
+					ctx := context.Background()
+					client, err := startServerWithArgs(ctx, "", []string{
+						"cmd", "--model", model,
+						"--failure-injection-rate", "100",
+						"--failure-types", failureType,
+					}, nil)
+					Expect(err).ToNot(HaveOccurred())
+
+					for range 3 {
+						contentType, hasContentType, parsed := postChat(client, chatBody)
+						Expect(hasContentType).To(Equal(expectedContentType != ""))
+						Expect(contentType).To(Equal(expectedContentType))
+						// the body is a correct response
+						Expect(parsed["object"]).To(Equal(chatCompletionObject))
+						Expect(parsed["choices"]).To(HaveLen(1))
+					}
+
+					metricsResp, err := client.Get(metricsUrl)
+					Expect(err).ToNot(HaveOccurred())
+					data, err := io.ReadAll(metricsResp.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(data)).To(ContainSubstring(
+						`sim_injected_failures_total{failure_type="` + failureType + `"} 3`))
+				},
+				Entry("missing_content_type", common.FailureTypeMissingContentType, ""),
+				Entry("wrong_content_type", common.FailureTypeWrongContentType, "text/plain"),
+			)
+
+			DescribeTable("should not corrupt the content type of a streaming response",
+				func(failureType string) {
+					ctx := context.Background()
+					client, err := startServerWithArgs(ctx, "", []string{
+						"cmd", "--model", model,
+						"--failure-injection-rate", "100",
+						"--failure-types", failureType,
+					}, nil)
+					Expect(err).ToNot(HaveOccurred())
+
+					streamBody := strings.Replace(chatBody, `"model"`, `"stream": true, "model"`, 1)
+					contentType, _, _ := postChat(client, streamBody)
+					Expect(contentType).To(HavePrefix("text/event-stream"))
+
+					metricsResp, err := client.Get(metricsUrl)
+					Expect(err).ToNot(HaveOccurred())
+					data, err := io.ReadAll(metricsResp.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(data)).ToNot(ContainSubstring(`failure_type="` + failureType + `"`))
+				},
+				Entry("missing_content_type", common.FailureTypeMissingContentType),
+				Entry("wrong_content_type", common.FailureTypeWrongContentType),
+			)
+
+			It("should send the json content type at 0% failure injection rate", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, "", []string{
+					"cmd", "--model", model,
+					"--failure-injection-rate", "0",
+					"--failure-types", common.FailureTypeMissingContentType, common.FailureTypeWrongContentType,
+				}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				contentType, _, parsed := postChat(client, chatBody)
+				Expect(contentType).To(Equal("application/json"))
+				Expect(parsed["choices"]).To(HaveLen(1))
+			})
+		})
+
+		Context("with targeted failure scenarios", func() {
+			It("should inject context length failures only into long prompts", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, common.ModeEcho, []string{
+					"cmd", "--model", model, "--mode", common.ModeEcho,
+					"--context-length-failure-rate", "100",
+					"--context-length-failure-min-prompt-tokens", "10",
+				}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params := getOpenAIClentAndCompletionParams(client, model, "Hello world", false)
+				_, err = openaiClient.Completions.New(ctx, params)
+				Expect(err).ToNot(HaveOccurred())
+
+				longPrompt := strings.Repeat("Hello world, how are you? ", 5)
+				openaiClient, params = getOpenAIClentAndCompletionParams(client, model, longPrompt, false)
+				_, err = openaiClient.Completions.New(ctx, params)
+				Expect(err).To(HaveOccurred())
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(400))
+				Expect(openaiError.Message).To(ContainSubstring("maximum context length"))
+
+				metricsResp, err := client.Get(metricsUrl)
+				Expect(err).ToNot(HaveOccurred())
+				data, err := io.ReadAll(metricsResp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring(
+					`sim_injected_failures_total{failure_type="` + common.FailureTypeContextLength + `"} 1`))
+			})
+
+			It("should inject model not found failures only into the requests of the listed models", func() {
+				ctx := context.Background()
+				client, err := startServerWithArgs(ctx, common.ModeEcho, []string{
+					"cmd", "--model", model, "--mode", common.ModeEcho,
+					"--served-model-name", model, "retired-model",
+					"--model-not-found-failure-models", "retired-model",
+				}, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+				_, err = openaiClient.Chat.Completions.New(ctx, params)
+				Expect(err).ToNot(HaveOccurred())
+
+				openaiClient, params = getOpenAIClentAndChatParams(client, "retired-model", userMessage, false)
+				_, err = openaiClient.Chat.Completions.New(ctx, params)
+				Expect(err).To(HaveOccurred())
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(404))
+				Expect(openaiError.Message).To(Equal("The model 'retired-model' does not exist"))
+			})
+		})
 	})
 })
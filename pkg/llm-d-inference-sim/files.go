@@ -0,0 +1,262 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Files API, the uploaded files and the files created by the batches are kept in memory
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// filesListMaxLimit is the maximal number of files in the list of the files, and the default
+	filesListMaxLimit = 10000
+	// multipartFormOverheadBytes is the size of the multipart form of an upload in addition to its file,
+	// the maximal request body size of the server allows the upload of a file of the maximal size
+	multipartFormOverheadBytes = 1024 * 1024
+)
+
+// storedFile is a file uploaded to the files API or created by a batch
+type storedFile struct {
+	file    openaiserverapi.File
+	content []byte
+}
+
+// fileStore keeps the files of the files API in memory
+type fileStore struct {
+	mutex sync.Mutex
+	// files are the uploaded and the created files, the key is the file id
+	files map[string]*storedFile
+	// fileIDs are the ids of the files in the order of their creation
+	fileIDs []string
+}
+
+func newFileStore() *fileStore {
+	return &fileStore{files: make(map[string]*storedFile)}
+}
+
+// add stores a file
+func (f *fileStore) add(file *storedFile) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.files[file.file.ID] = file
+	f.fileIDs = append(f.fileIDs, file.file.ID)
+}
+
+// get returns the file with the given id, nil if it does not exist
+func (f *fileStore) get(id string) *storedFile {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.files[id]
+}
+
+// delete deletes the file with the given id, returns false if it does not exist
+func (f *fileStore) delete(id string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.files[id]; !ok {
+		return false
+	}
+	delete(f.files, id)
+	f.fileIDs = slices.DeleteFunc(f.fileIDs, func(fileID string) bool { return fileID == id })
+	return true
+}
+
+// list returns up to limit files of the given purpose, or of all the purposes if purpose is empty, in the order
+// of their creation or in the reverse order, after the file with the id after, and whether there are more files
+func (f *fileStore) list(purpose string, after string, limit int, ascending bool) ([]openaiserverapi.File, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ids := slices.Clone(f.fileIDs)
+	if !ascending {
+		slices.Reverse(ids)
+	}
+	if index := slices.Index(ids, after); index >= 0 {
+		ids = ids[index+1:]
+	}
+	files := make([]openaiserverapi.File, 0)
+	for _, id := range ids {
+		file := f.files[id].file
+		if purpose != "" && file.Purpose != purpose {
+			continue
+		}
+		if len(files) == limit {
+			return files, true
+		}
+		files = append(files, file)
+	}
+	return files, false
+}
+
+// maxRequestBodySize returns the maximal size of the body of the requests of the server, large enough
+// for the upload of a file of the maximal size
+func (s *VllmSimulator) maxRequestBodySize() int {
+	return max(fasthttp.DefaultMaxRequestBodySize, int(s.config.FileMaxBytes)+multipartFormOverheadBytes)
+}
+
+// HandleUploadFile http handler for POST /v1/files, uploads the file of the multipart form
+func (s *VllmSimulator) HandleUploadFile(ctx *fasthttp.RequestCtx) {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		s.sendAPIError(ctx, "Failed to read the multipart form, "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	var purpose string
+	if purposes := form.Value["purpose"]; len(purposes) > 0 {
+		purpose = purposes[0]
+	}
+	if !slices.Contains(openaiserverapi.UploadFilePurposes, purpose) {
+		s.sendAPIError(ctx, fmt.Sprintf("Invalid purpose '%s', must be one of %v", purpose,
+			openaiserverapi.UploadFilePurposes), fasthttp.StatusBadRequest)
+		return
+	}
+	headers := form.File["file"]
+	if len(headers) == 0 {
+		s.sendAPIError(ctx, "The file is missing", fasthttp.StatusBadRequest)
+		return
+	}
+	if headers[0].Size > s.config.FileMaxBytes {
+		s.sendAPIError(ctx, fmt.Sprintf("The file has %d bytes, the maximal size of a file is %d bytes",
+			headers[0].Size, s.config.FileMaxBytes), fasthttp.StatusBadRequest)
+		return
+	}
+	reader, err := headers[0].Open()
+	if err != nil {
+		s.sendAPIError(ctx, "Failed to open the file, "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		s.sendAPIError(ctx, "Failed to read the file, "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	file := s.newStoredFile(headers[0].Filename, purpose, content)
+	s.files.add(file)
+	s.logger.V(4).Info("File uploaded", "id", file.file.ID, "name", file.file.Filename, "bytes", file.file.Bytes)
+	s.sendAPIResponse(ctx, file.file)
+}
+
+// newStoredFile creates a file with a new id
+func (s *VllmSimulator) newStoredFile(filename string, purpose string, content []byte) *storedFile {
+	return &storedFile{
+		file: openaiserverapi.File{
+			ID:        "file-" + s.random.UUIDString(),
+			Object:    "file",
+			Bytes:     len(content),
+			CreatedAt: s.externalNow().Unix(),
+			Filename:  filename,
+			Purpose:   purpose,
+			Status:    "processed",
+		},
+		content: content,
+	}
+}
+
+// HandleListFiles http handler for GET /v1/files, lists the files filtered by the purpose query parameter,
+// paginated by the after and limit query parameters, the most recent first unless order is asc
+func (s *VllmSimulator) HandleListFiles(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	limit := filesListMaxLimit
+	if value := args.Peek("limit"); len(value) > 0 {
+		var err error
+		limit, err = strconv.Atoi(string(value))
+		if err != nil || limit < 1 || limit > filesListMaxLimit {
+			s.sendAPIError(ctx, fmt.Sprintf("Invalid limit '%s', must be between 1 and %d", value,
+				filesListMaxLimit), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	order := string(args.Peek("order"))
+	if order != "" && order != "asc" && order != "desc" {
+		s.sendAPIError(ctx, fmt.Sprintf("Invalid order '%s', must be asc or desc", order), fasthttp.StatusBadRequest)
+		return
+	}
+
+	files, hasMore := s.files.list(string(args.Peek("purpose")), string(args.Peek("after")), limit, order == "asc")
+	list := openaiserverapi.FileList{Object: "list", Data: files, HasMore: hasMore}
+	if len(files) > 0 {
+		list.FirstID = files[0].ID
+		list.LastID = files[len(files)-1].ID
+	}
+	s.sendAPIResponse(ctx, list)
+}
+
+// HandleGetFile http handler for GET /v1/files/:file_id
+func (s *VllmSimulator) HandleGetFile(ctx *fasthttp.RequestCtx) {
+	if file := s.getRequestedFile(ctx); file != nil {
+		s.sendAPIResponse(ctx, file.file)
+	}
+}
+
+// HandleGetFileContent http handler for GET /v1/files/:file_id/content
+func (s *VllmSimulator) HandleGetFileContent(ctx *fasthttp.RequestCtx) {
+	if file := s.getRequestedFile(ctx); file != nil {
+		ctx.Response.Header.SetContentType(octetStreamMediaType)
+		ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+		ctx.Response.SetBody(file.content)
+	}
+}
+
+// HandleDeleteFile http handler for DELETE /v1/files/:file_id
+func (s *VllmSimulator) HandleDeleteFile(ctx *fasthttp.RequestCtx) {
+	id, _ := ctx.UserValue("file_id").(string)
+	if !s.files.delete(id) {
+		s.sendAPIError(ctx, fmt.Sprintf("No such file: '%s'", id), fasthttp.StatusNotFound)
+		return
+	}
+	s.sendAPIResponse(ctx, openaiserverapi.DeletedFile{ID: id, Object: "file", Deleted: true})
+}
+
+// getRequestedFile returns the file of the file_id path parameter, sends an error and returns nil
+// if the file does not exist
+func (s *VllmSimulator) getRequestedFile(ctx *fasthttp.RequestCtx) *storedFile {
+	id, _ := ctx.UserValue("file_id").(string)
+	file := s.files.get(id)
+	if file == nil {
+		s.sendAPIError(ctx, fmt.Sprintf("No such file: '%s'", id), fasthttp.StatusNotFound)
+	}
+	return file
+}
+
+// sendAPIError sends an error of the files or the batch API in the configured error schema
+func (s *VllmSimulator) sendAPIError(ctx *fasthttp.RequestCtx, message string, code int) {
+	s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(message, code, nil), "")
+}
+
+// sendAPIResponse sends a response of the files or the batch API
+func (s *VllmSimulator) sendAPIResponse(ctx *fasthttp.RequestCtx, response any) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal response")
+		ctx.Error("Failed to marshal response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
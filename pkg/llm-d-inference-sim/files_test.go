@@ -0,0 +1,109 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+)
+
+var _ = Describe("Files API", func() {
+	// uploadFile uploads a file with the given content and purpose
+	uploadFile := func(ctx context.Context, client openai.Client, name string, content string,
+		purpose openai.FilePurpose) (*openai.FileObject, error) {
+		return client.Files.New(ctx, openai.FileNewParams{
+			File:    openai.File(strings.NewReader(content), name, "application/jsonl"),
+			Purpose: purpose,
+		})
+	}
+
+	It("should upload, list, download and delete files", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		batchFile, err := uploadFile(ctx, client, "batch.jsonl", "batch content", openai.FilePurposeBatch)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batchFile.Bytes).To(Equal(int64(len("batch content"))))
+		Expect(string(batchFile.Purpose)).To(Equal("batch"))
+		tuneFile, err := uploadFile(ctx, client, "train.jsonl", "train content", openai.FilePurposeFineTune)
+		Expect(err).NotTo(HaveOccurred())
+
+		file, err := client.Files.Get(ctx, tuneFile.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Filename).To(Equal("train.jsonl"))
+		resp, err := client.Files.Content(ctx, batchFile.ID)
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(string(content)).To(Equal("batch content"))
+
+		// the most recent first by default
+		list, err := client.Files.List(ctx, openai.FileListParams{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Data).To(HaveLen(2))
+		Expect(list.Data[0].ID).To(Equal(tuneFile.ID))
+		list, err = client.Files.List(ctx, openai.FileListParams{Order: openai.FileListParamsOrderAsc,
+			Limit: param.NewOpt(int64(1))})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Data).To(HaveLen(1))
+		Expect(list.Data[0].ID).To(Equal(batchFile.ID))
+		Expect(list.HasMore).To(BeTrue())
+		list, err = client.Files.List(ctx, openai.FileListParams{Purpose: param.NewOpt("fine-tune")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list.Data).To(HaveLen(1))
+		Expect(list.Data[0].ID).To(Equal(tuneFile.ID))
+
+		deleted, err := client.Files.Delete(ctx, batchFile.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted.Deleted).To(BeTrue())
+		_, err = client.Files.Get(ctx, batchFile.ID)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("No such file"))
+		_, err = client.Files.Delete(ctx, batchFile.ID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject the files of an invalid purpose or larger than the maximal size", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		httpClient, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--file-max-bytes", "10"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		client := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(httpClient))
+
+		_, err = uploadFile(ctx, client, "small.jsonl", "small", openai.FilePurposeBatch)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = uploadFile(ctx, client, "large.jsonl", "larger than the limit", openai.FilePurposeBatch)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("the maximal size of a file is 10 bytes"))
+		_, err = uploadFile(ctx, client, "output.jsonl", "output", "batch_output")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Invalid purpose"))
+	})
+})
@@ -0,0 +1,393 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	kservev2api "github.com/llm-d/llm-d-inference-sim/pkg/kserve-v2-api"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the tensors of the requests and responses, like in the vLLM backend of Triton
+	grpcTextInput          = "text_input"
+	grpcSamplingParameters = "sampling_parameters"
+	grpcTextOutput         = "text_output"
+	grpcBytesDatatype      = "BYTES"
+
+	grpcServerName    = "llm-d-inference-sim"
+	grpcModelPlatform = "vllm"
+
+	grpcFinishReasonParameter     = "finish_reason"
+	grpcPromptTokensParameter     = "prompt_tokens"
+	grpcCompletionTokensParameter = "completion_tokens"
+)
+
+// kserveServer implements the KServe v2 gRPC inference service, the inference requests are
+// processed as text completion requests
+type kserveServer struct {
+	kservev2api.UnimplementedGRPCInferenceServiceServer
+	sim *VllmSimulator
+}
+
+// startGRPCServer starts the KServe v2 gRPC server on the gRPC port if it is configured
+func (s *VllmSimulator) startGRPCServer(ctx context.Context) error {
+	if s.config.GRPCPort == 0 {
+		return nil
+	}
+	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", s.config.GRPCPort))
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Server starting", "protocol", "gRPC", "port", s.config.GRPCPort)
+	s.serveGRPC(ctx, listener)
+	return nil
+}
+
+// serveGRPC serves the KServe v2 gRPC inference service on the given listener until ctx is done
+func (s *VllmSimulator) serveGRPC(ctx context.Context, listener net.Listener) {
+	server := grpc.NewServer()
+	kservev2api.RegisterGRPCInferenceServiceServer(server, &kserveServer{sim: s})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			s.logger.Error(err, "gRPC server failed")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+}
+
+// ServerLive returns true while the server runs
+func (k *kserveServer) ServerLive(context.Context, *kservev2api.ServerLiveRequest) (*kservev2api.ServerLiveResponse, error) {
+	return &kservev2api.ServerLiveResponse{Live: true}, nil
+}
+
+// ServerReady returns the readiness of the simulator, like /ready
+func (k *kserveServer) ServerReady(context.Context, *kservev2api.ServerReadyRequest) (*kservev2api.ServerReadyResponse, error) {
+	return &kservev2api.ServerReadyResponse{Ready: k.sim.isReady()}, nil
+}
+
+// ModelReady returns true if the simulator is ready and serves the model
+func (k *kserveServer) ModelReady(_ context.Context,
+	req *kservev2api.ModelReadyRequest) (*kservev2api.ModelReadyResponse, error) {
+	if !k.sim.isValidModel(req.GetName()) {
+		return nil, status.Errorf(codes.NotFound, "The model `%s` does not exist.", req.GetName())
+	}
+	return &kservev2api.ModelReadyResponse{Ready: k.sim.isReady()}, nil
+}
+
+// ServerMetadata returns the name of the server
+func (k *kserveServer) ServerMetadata(context.Context,
+	*kservev2api.ServerMetadataRequest) (*kservev2api.ServerMetadataResponse, error) {
+	return &kservev2api.ServerMetadataResponse{Name: grpcServerName}, nil
+}
+
+// ModelMetadata returns the tensors of the requests and responses of a served model
+func (k *kserveServer) ModelMetadata(_ context.Context,
+	req *kservev2api.ModelMetadataRequest) (*kservev2api.ModelMetadataResponse, error) {
+	if !k.sim.isValidModel(req.GetName()) {
+		return nil, status.Errorf(codes.NotFound, "The model `%s` does not exist.", req.GetName())
+	}
+	tensor := func(name string) *kservev2api.ModelMetadataResponse_TensorMetadata {
+		return &kservev2api.ModelMetadataResponse_TensorMetadata{Name: name, Datatype: grpcBytesDatatype, Shape: []int64{1}}
+	}
+	return &kservev2api.ModelMetadataResponse{
+		Name:     req.GetName(),
+		Platform: grpcModelPlatform,
+		Inputs:   []*kservev2api.ModelMetadataResponse_TensorMetadata{tensor(grpcTextInput), tensor(grpcSamplingParameters)},
+		Outputs:  []*kservev2api.ModelMetadataResponse_TensorMetadata{tensor(grpcTextOutput)},
+	}, nil
+}
+
+// ModelInfer processes the inference request as a text completion request
+func (k *kserveServer) ModelInfer(ctx context.Context,
+	req *kservev2api.ModelInferRequest) (*kservev2api.ModelInferResponse, error) {
+	httpCtx, err := k.sim.handleInferRequest(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	body := httpCtx.Response.Body()
+	if err := getGRPCError(httpCtx.Response.StatusCode(), body); err != nil {
+		return nil, err
+	}
+	var resp openaiserverapi.TextCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to parse the completion response: %v", err)
+	}
+	inferResp := newInferResponse(req, &resp)
+	if resp.Usage != nil {
+		inferResp.Parameters[grpcPromptTokensParameter] = int64Parameter(resp.Usage.PromptTokens)
+		inferResp.Parameters[grpcCompletionTokensParameter] = int64Parameter(resp.Usage.CompletionTokens)
+	}
+	return inferResp, nil
+}
+
+// ModelStreamInfer processes the inference requests of the stream as streaming text completion requests,
+// a response is sent for every generated chunk. Failed requests are reported in the error message of a
+// response, the stream stays open for the next requests
+func (k *kserveServer) ModelStreamInfer(
+	stream grpc.BidiStreamingServer[kservev2api.ModelInferRequest, kservev2api.ModelStreamInferResponse]) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := k.streamInfer(stream, req); err != nil {
+			return err
+		}
+	}
+}
+
+// streamInfer sends the responses of a single request of the stream, returns an error if the stream failed
+func (k *kserveServer) streamInfer(
+	stream grpc.BidiStreamingServer[kservev2api.ModelInferRequest, kservev2api.ModelStreamInferResponse],
+	req *kservev2api.ModelInferRequest) error {
+	sendError := func(err error) error {
+		return stream.Send(&kservev2api.ModelStreamInferResponse{ErrorMessage: status.Convert(err).Message()})
+	}
+	httpCtx, err := k.sim.handleInferRequest(stream.Context(), req, true)
+	if err != nil {
+		return sendError(err)
+	}
+	bodyStream := httpCtx.Response.BodyStream()
+	if bodyStream == nil {
+		// the request failed before the streaming started
+		if err := getGRPCError(httpCtx.Response.StatusCode(), httpCtx.Response.Body()); err != nil {
+			return sendError(err)
+		}
+		return sendError(status.Error(codes.Internal, "the completion response is not streamed"))
+	}
+	defer func() {
+		_ = httpCtx.Response.CloseBodyStream()
+	}()
+
+	reader := bufio.NewReader(bodyStream)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if data, found := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data: ")); found {
+			if string(data) == "[DONE]" {
+				return nil
+			}
+			var chunk openaiserverapi.TextCompletionResponse
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return sendError(status.Errorf(codes.Internal, "failed to parse the completion chunk: %v", err))
+			}
+			if len(chunk.Choices) > 0 {
+				if err := stream.Send(&kservev2api.ModelStreamInferResponse{
+					InferResponse: newInferResponse(req, &chunk)}); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			// the stream ends without the done sentinel if it is omitted
+			return nil
+		}
+	}
+}
+
+// handleInferRequest processes the inference request as a text completion request, the metadata of the
+// gRPC request are passed as headers, returns the request context with the completion response
+func (s *VllmSimulator) handleInferRequest(ctx context.Context, req *kservev2api.ModelInferRequest,
+	stream bool) (*fasthttp.RequestCtx, error) {
+	body, err := createCompletionRequestBody(req, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var httpReq fasthttp.Request
+	httpReq.Header.SetMethod(fasthttp.MethodPost)
+	httpReq.Header.SetContentType("application/json")
+	httpReq.SetRequestURI("/v1/completions")
+	httpReq.SetBody(body)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || len(values) == 0 {
+				continue
+			}
+			httpReq.Header.Set(key, values[0])
+		}
+	}
+
+	var httpCtx fasthttp.RequestCtx
+	httpCtx.Init(&httpReq, nil, s)
+	s.handleCompletions(&httpCtx, false, false)
+	return &httpCtx, nil
+}
+
+// createCompletionRequestBody creates the body of the text completion request of an inference request,
+// the prompt is the text input, the sampling parameters and the parameters of the inference request
+// are the fields of the completion request
+func createCompletionRequestBody(req *kservev2api.ModelInferRequest, stream bool) ([]byte, error) {
+	completion := make(map[string]any)
+	for name, param := range req.GetParameters() {
+		completion[name] = parameterValue(param)
+	}
+
+	var prompt *string
+	for i, input := range req.GetInputs() {
+		switch input.GetName() {
+		case grpcTextInput:
+			text, err := getBytesInput(req, i)
+			if err != nil {
+				return nil, err
+			}
+			prompt = &text
+		case grpcSamplingParameters:
+			params, err := getBytesInput(req, i)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal([]byte(params), &completion); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s input: %v", grpcSamplingParameters, err)
+			}
+		}
+	}
+	if prompt == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "the request has no %s input", grpcTextInput)
+	}
+
+	completion["model"] = req.GetModelName()
+	completion["prompt"] = *prompt
+	completion["stream"] = stream
+	return json.Marshal(completion)
+}
+
+// getBytesInput returns the first element of the BYTES input tensor with the given index, either from
+// the tensor contents or from the raw input contents, where every element has a 4 bytes length prefix
+func getBytesInput(req *kservev2api.ModelInferRequest, index int) (string, error) {
+	input := req.GetInputs()[index]
+	if input.GetDatatype() != grpcBytesDatatype {
+		return "", status.Errorf(codes.InvalidArgument, "the datatype of the %s input must be %s, got %s",
+			input.GetName(), grpcBytesDatatype, input.GetDatatype())
+	}
+	if len(req.GetRawInputContents()) > 0 {
+		if index >= len(req.GetRawInputContents()) {
+			return "", status.Errorf(codes.InvalidArgument, "the %s input has no raw contents", input.GetName())
+		}
+		raw := req.GetRawInputContents()[index]
+		if len(raw) < 4 || int(binary.LittleEndian.Uint32(raw)) > len(raw)-4 {
+			return "", status.Errorf(codes.InvalidArgument, "invalid raw contents of the %s input", input.GetName())
+		}
+		return string(raw[4 : 4+binary.LittleEndian.Uint32(raw)]), nil
+	}
+	contents := input.GetContents().GetBytesContents()
+	if len(contents) == 0 {
+		return "", status.Errorf(codes.InvalidArgument, "the %s input is empty", input.GetName())
+	}
+	return string(contents[0]), nil
+}
+
+// parameterValue returns the value of an inference parameter
+func parameterValue(param *kservev2api.InferParameter) any {
+	switch choice := param.GetParameterChoice().(type) {
+	case *kservev2api.InferParameter_BoolParam:
+		return choice.BoolParam
+	case *kservev2api.InferParameter_Int64Param:
+		return choice.Int64Param
+	case *kservev2api.InferParameter_StringParam:
+		return choice.StringParam
+	case *kservev2api.InferParameter_DoubleParam:
+		return choice.DoubleParam
+	case *kservev2api.InferParameter_Uint64Param:
+		return choice.Uint64Param
+	}
+	return nil
+}
+
+func int64Parameter(value int) *kservev2api.InferParameter {
+	return &kservev2api.InferParameter{ParameterChoice: &kservev2api.InferParameter_Int64Param{Int64Param: int64(value)}}
+}
+
+// newInferResponse creates the inference response of a text completion response or chunk
+func newInferResponse(req *kservev2api.ModelInferRequest,
+	resp *openaiserverapi.TextCompletionResponse) *kservev2api.ModelInferResponse {
+	inferResp := &kservev2api.ModelInferResponse{
+		ModelName:    req.GetModelName(),
+		ModelVersion: req.GetModelVersion(),
+		Id:           req.GetId(),
+		Parameters:   make(map[string]*kservev2api.InferParameter),
+	}
+	text := ""
+	if len(resp.Choices) > 0 {
+		text = resp.Choices[0].Text
+		if finishReason := resp.Choices[0].FinishReason; finishReason != nil {
+			inferResp.Parameters[grpcFinishReasonParameter] = &kservev2api.InferParameter{
+				ParameterChoice: &kservev2api.InferParameter_StringParam{StringParam: *finishReason}}
+		}
+	}
+	inferResp.Outputs = []*kservev2api.ModelInferResponse_InferOutputTensor{{
+		Name:     grpcTextOutput,
+		Datatype: grpcBytesDatatype,
+		Shape:    []int64{1},
+		Contents: &kservev2api.InferTensorContents{BytesContents: [][]byte{[]byte(text)}},
+	}}
+	return inferResp
+}
+
+// getGRPCError returns the gRPC error of a failed completion response, nil if the request succeeded
+func getGRPCError(statusCode int, body []byte) error {
+	if statusCode == fasthttp.StatusOK {
+		return nil
+	}
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := string(body)
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Error.Message != "" {
+		message = resp.Error.Message
+	}
+	code := codes.Internal
+	switch statusCode {
+	case fasthttp.StatusBadRequest:
+		code = codes.InvalidArgument
+	case fasthttp.StatusNotFound:
+		code = codes.NotFound
+	case fasthttp.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case fasthttp.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case fasthttp.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case fasthttp.StatusForbidden:
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, message)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	kservev2api "github.com/llm-d/llm-d-inference-sim/pkg/kserve-v2-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"k8s.io/klog/v2"
+)
+
+// startGRPCServerWithArgs starts the simulator with the given arguments, returns a client of its
+// KServe v2 gRPC server that is served on an in-memory listener
+func startGRPCServerWithArgs(ctx context.Context, args []string) kservev2api.GRPCInferenceServiceClient {
+	oldArgs := os.Args
+	defer func() {
+		os.Args = oldArgs
+	}()
+	os.Args = args
+
+	s, err := New(klog.Background())
+	Expect(err).NotTo(HaveOccurred())
+	s.config, err = common.ParseCommandParamsAndLoadConfig()
+	Expect(err).NotTo(HaveOccurred())
+	_, err = startSimulator(ctx, s)
+	Expect(err).NotTo(HaveOccurred())
+
+	listener := bufconn.Listen(1024 * 1024)
+	s.serveGRPC(ctx, listener)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(conn.Close)
+	return kservev2api.NewGRPCInferenceServiceClient(conn)
+}
+
+// newInferRequest creates an inference request of the prompt with the given sampling parameters
+func newInferRequest(prompt string, samplingParams string) *kservev2api.ModelInferRequest {
+	req := &kservev2api.ModelInferRequest{
+		ModelName: model,
+		Id:        "request-1",
+		Inputs: []*kservev2api.ModelInferRequest_InferInputTensor{{
+			Name:     grpcTextInput,
+			Datatype: grpcBytesDatatype,
+			Shape:    []int64{1},
+			Contents: &kservev2api.InferTensorContents{BytesContents: [][]byte{[]byte(prompt)}},
+		}},
+	}
+	if samplingParams != "" {
+		req.Inputs = append(req.Inputs, &kservev2api.ModelInferRequest_InferInputTensor{
+			Name:     grpcSamplingParameters,
+			Datatype: grpcBytesDatatype,
+			Shape:    []int64{1},
+			Contents: &kservev2api.InferTensorContents{BytesContents: [][]byte{[]byte(samplingParams)}},
+		})
+	}
+	return req
+}
+
+// getTextOutput returns the text output of an inference response
+func getTextOutput(resp *kservev2api.ModelInferResponse) string {
+	Expect(resp.GetOutputs()).To(HaveLen(1))
+	Expect(resp.GetOutputs()[0].GetName()).To(Equal(grpcTextOutput))
+	Expect(resp.GetOutputs()[0].GetContents().GetBytesContents()).To(HaveLen(1))
+	return string(resp.GetOutputs()[0].GetContents().GetBytesContents()[0])
+}
+
+var _ = Describe("KServe v2 gRPC frontend", func() {
+	prompt := "Hello world, how are you?"
+
+	It("should report the health and the metadata of the server and the model", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := startGRPCServerWithArgs(ctx, []string{"cmd", "--model", model, "--mode", common.ModeEcho})
+
+		live, err := client.ServerLive(ctx, &kservev2api.ServerLiveRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(live.GetLive()).To(BeTrue())
+		ready, err := client.ServerReady(ctx, &kservev2api.ServerReadyRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready.GetReady()).To(BeTrue())
+		modelReady, err := client.ModelReady(ctx, &kservev2api.ModelReadyRequest{Name: model})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(modelReady.GetReady()).To(BeTrue())
+		_, err = client.ModelReady(ctx, &kservev2api.ModelReadyRequest{Name: "unknown"})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+
+		metadata, err := client.ModelMetadata(ctx, &kservev2api.ModelMetadataRequest{Name: model})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata.GetName()).To(Equal(model))
+		Expect(metadata.GetInputs()[0].GetName()).To(Equal(grpcTextInput))
+		Expect(metadata.GetOutputs()[0].GetName()).To(Equal(grpcTextOutput))
+	})
+
+	It("should generate the response of an inference request", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := startGRPCServerWithArgs(ctx, []string{"cmd", "--model", model, "--mode", common.ModeEcho})
+
+		resp, err := client.ModelInfer(ctx, newInferRequest(prompt, ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetModelName()).To(Equal(model))
+		Expect(resp.GetId()).To(Equal("request-1"))
+		Expect(getTextOutput(resp)).To(Equal(prompt))
+		nTokens := int64(len(common.Tokenize(prompt)))
+		Expect(resp.GetParameters()[grpcPromptTokensParameter].GetInt64Param()).To(Equal(nTokens))
+		Expect(resp.GetParameters()[grpcCompletionTokensParameter].GetInt64Param()).To(Equal(nTokens))
+		Expect(resp.GetParameters()[grpcFinishReasonParameter].GetStringParam()).To(Equal("stop"))
+
+		// the sampling parameters are the parameters of the completion request
+		resp, err = client.ModelInfer(ctx, newInferRequest(prompt, `{"max_tokens": 2}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.GetParameters()[grpcCompletionTokensParameter].GetInt64Param()).To(Equal(int64(2)))
+		Expect(resp.GetParameters()[grpcFinishReasonParameter].GetStringParam()).To(Equal("length"))
+
+		// the text input in the raw input contents
+		req := newInferRequest("", "")
+		req.Inputs[0].Contents = nil
+		raw := binary.LittleEndian.AppendUint32(nil, uint32(len(prompt)))
+		req.RawInputContents = [][]byte{append(raw, prompt...)}
+		resp, err = client.ModelInfer(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getTextOutput(resp)).To(Equal(prompt))
+	})
+
+	It("should return the errors of the inference requests", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := startGRPCServerWithArgs(ctx, []string{"cmd", "--model", model, "--mode", common.ModeEcho})
+
+		req := newInferRequest(prompt, "")
+		req.ModelName = "unknown"
+		_, err := client.ModelInfer(ctx, req)
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+
+		_, err = client.ModelInfer(ctx, newInferRequest(prompt, `{"max_tokens": -1}`))
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = client.ModelInfer(ctx, &kservev2api.ModelInferRequest{ModelName: model})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(err.Error()).To(ContainSubstring("no text_input input"))
+	})
+
+	It("should stream the responses of the inference requests", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := startGRPCServerWithArgs(ctx, []string{"cmd", "--model", model, "--mode", common.ModeEcho})
+
+		stream, err := client.ModelStreamInfer(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Send(newInferRequest(prompt, ""))).To(Succeed())
+		failing := newInferRequest(prompt, "")
+		failing.ModelName = "unknown"
+		Expect(stream.Send(failing)).To(Succeed())
+		Expect(stream.CloseSend()).To(Succeed())
+
+		var text strings.Builder
+		var finishReason, errorMessage string
+		nResponses := 0
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			if resp.GetErrorMessage() != "" {
+				errorMessage = resp.GetErrorMessage()
+				continue
+			}
+			nResponses++
+			Expect(resp.GetInferResponse().GetId()).To(Equal("request-1"))
+			text.WriteString(getTextOutput(resp.GetInferResponse()))
+			if reason := resp.GetInferResponse().GetParameters()[grpcFinishReasonParameter]; reason != nil {
+				finishReason = reason.GetStringParam()
+			}
+		}
+		Expect(text.String()).To(Equal(prompt))
+		Expect(nResponses).To(BeNumerically(">", 1))
+		Expect(finishReason).To(Equal("stop"))
+		// the failed request doesn't end the stream
+		Expect(errorMessage).To(ContainSubstring("does not exist"))
+	})
+})
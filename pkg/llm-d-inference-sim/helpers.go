@@ -20,15 +20,25 @@ package llmdinferencesim
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 )
 
-// isValidModel checks if the given model is the base model or one of "loaded" LoRAs
+// isValidModel checks if the given model is one of the base models or one of "loaded" LoRAs
 func (s *VllmSimulator) isValidModel(model string) bool {
 	for _, name := range s.config.ServedModelNames {
 		if model == name {
 			return true
 		}
 	}
+	for _, base := range s.config.AdditionalModels {
+		for _, name := range base.ServedModelNames {
+			if model == name {
+				return true
+			}
+		}
+	}
 	for _, lora := range s.getLoras() {
 		if model == lora {
 			return true
@@ -49,30 +59,54 @@ func (s *VllmSimulator) isLora(model string) bool {
 	return false
 }
 
+// getMaxModelLen returns the context window of the given served model name or LoRA adapter, an adapter
+// loaded without its own context window inherits the context window of its base model
+func (s *VllmSimulator) getMaxModelLen(model string) int {
+	config := s.getRuntimeConfig()
+	_, defined := config.ServedModelMaxModelLen[model]
+	if !defined && s.isLora(model) && !slices.ContainsFunc(config.LoraModules,
+		func(lora common.LoraModule) bool { return lora.Name == model }) {
+		model = s.getDisplayedBaseModelName(model)
+	}
+	return config.GetMaxModelLen(model)
+}
+
 // getDisplayedModelName returns the model name that must appear in API
 // responses.  LoRA adapters keep their explicit name, while all base-model
-// requests are surfaced as the first alias from --served-model-name.
+// requests are surfaced as the first alias of their model, from --served-model-name
+// or from the served model names of the additional model.
 func (s *VllmSimulator) getDisplayedModelName(reqModel string) string {
 	if s.isLora(reqModel) {
 		return reqModel
 	}
+	return s.getDisplayedBaseModelName(reqModel)
+}
+
+// getDisplayedBaseModelName returns the displayed name of the base model of the given model,
+// the base model of a LoRA adapter is its base_model_name, the simulator's model by default
+func (s *VllmSimulator) getDisplayedBaseModelName(model string) string {
+	if value, ok := s.loraAdaptors.Load(model); ok {
+		if lora, ok := value.(loadedLora); ok {
+			model = lora.baseModelName
+		}
+	}
+	if base := s.config.GetAdditionalModel(model); base != nil {
+		return base.ServedModelNames[0]
+	}
 	return s.config.ServedModelNames[0]
 }
 
-func (s *VllmSimulator) showConfig(dp bool) error {
-	cfgJSON, err := json.Marshal(s.config)
+// configMap returns the configuration as a map of the parameters names to their values
+func configMap(config *common.Configuration) (map[string]interface{}, error) {
+	cfgJSON, err := json.Marshal(config)
 	if err != nil {
-		return fmt.Errorf("failed to marshal configuration to JSON: %w", err)
+		return nil, fmt.Errorf("failed to marshal configuration to JSON: %w", err)
 	}
 
 	var m map[string]interface{}
 	err = json.Unmarshal(cfgJSON, &m)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON to map: %w", err)
-	}
-	if dp {
-		// remove the port
-		delete(m, "port")
+		return nil, fmt.Errorf("failed to unmarshal JSON to map: %w", err)
 	}
 	// clean LoraModulesString field
 	m["lora-modules"] = m["LoraModules"]
@@ -83,12 +117,37 @@ func (s *VllmSimulator) showConfig(dp bool) error {
 	if field, ok := m["fake-metrics"].(map[string]interface{}); ok {
 		delete(field, "LorasString")
 	}
+	return m, nil
+}
+
+// dataParallelSeeds returns the random seeds of all the data parallel ranks
+func (s *VllmSimulator) dataParallelSeeds() []int64 {
+	if s.dpSeeds == nil {
+		return []int64{s.config.Seed}
+	}
+	return s.dpSeeds
+}
+
+func (s *VllmSimulator) showConfig(dp bool) error {
+	m, err := configMap(s.config)
+	if err != nil {
+		return err
+	}
+	if dp {
+		// remove the port
+		delete(m, "port")
+	}
 
 	// show in JSON
-	cfgJSON, err = json.MarshalIndent(m, "", "  ")
+	cfgJSON, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal configuration to JSON: %w", err)
 	}
 	s.logger.Info("Configuration:", "", string(cfgJSON))
+
+	if profile, ok := common.GetHardwareProfile(s.config.HardwareProfile); ok {
+		s.logger.Info("Hardware profile", "name", s.config.HardwareProfile, "values", profile,
+			"overridden", s.config.OverriddenHardwareProfileParams())
+	}
 	return nil
 }
@@ -0,0 +1,133 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Replay of recorded kv-cache events to the ZMQ endpoint
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	kvcache "github.com/llm-d/llm-d-inference-sim/pkg/kv-cache"
+	"github.com/valyala/fasthttp"
+)
+
+// kvEventsReplayRequest is the body of a kv-cache events replay request
+type kvEventsReplayRequest struct {
+	// File is the path to the kv-cache events record file
+	File string `json:"file"`
+	// Speed is the speed of the replay, the offsets of the batches are divided by it, default is 1
+	Speed *float64 `json:"speed"`
+	// Topic is the topic the batches are published to, optional, by default the recorded topics are used
+	Topic string `json:"topic"`
+}
+
+// kvEventsReplayResponse is the response of a kv-cache events replay request
+type kvEventsReplayResponse struct {
+	// Batches is the number of the published batches
+	Batches int `json:"batches"`
+	// DurationMs is the duration of the replay in milliseconds
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// parseKVEventsReplayRequest parses the body of a kv-cache events replay request and loads its record file
+func parseKVEventsReplayRequest(body []byte) (*kvEventsReplayRequest, []kvcache.KVEventsRecord, error) {
+	var req kvEventsReplayRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, nil, errors.New("failed to parse the kv-cache events replay request: " + err.Error())
+	}
+	if req.File == "" {
+		return nil, nil, errors.New("file is required")
+	}
+	if req.Speed == nil {
+		speed := 1.0
+		req.Speed = &speed
+	} else if *req.Speed <= 0 {
+		return nil, nil, errors.New("speed must be positive")
+	}
+	records, err := kvcache.LoadKVEventsRecordFile(req.File)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &req, records, nil
+}
+
+// HandleReplayKVEvents http handler for /_sim/kv-events/replay, publishes the kv-cache event batches
+// of a record file to the ZMQ endpoint at their recorded pace, or faster, the response is sent when
+// the replay ends
+func (s *VllmSimulator) HandleReplayKVEvents(ctx *fasthttp.RequestCtx) {
+	req, records, err := parseKVEventsReplayRequest(ctx.Request.Body())
+	if err != nil {
+		s.logger.Error(err, "kv-cache events replay rejected")
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if s.config.ZMQEndpoint == "" {
+		ctx.Error("kv-cache events cannot be replayed without a zmq endpoint", fasthttp.StatusBadRequest)
+		return
+	}
+	if !s.kvEventsReplaying.CompareAndSwap(false, true) {
+		ctx.Error("a kv-cache events replay is in progress", fasthttp.StatusConflict)
+		return
+	}
+	defer s.kvEventsReplaying.Store(false)
+
+	publisher, err := common.NewPublisher(s.config.ZMQEndpoint, s.config.ZMQMaxConnectAttempts)
+	if err != nil {
+		s.logger.Error(err, "failed to create the kv-cache events replay publisher")
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			s.logger.Error(err, "failed to close the kv-cache events replay publisher")
+		}
+	}()
+
+	// the replay stops when the server stops
+	resp, err := s.replayKVEvents(ctx, publisher, req, records)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal kv-cache events replay response")
+		ctx.Error("Failed to marshal kv-cache events replay response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// replayKVEvents publishes the recorded batches with the given publisher
+func (s *VllmSimulator) replayKVEvents(ctx context.Context, publisher kvcache.KVEventsPublisher,
+	req *kvEventsReplayRequest, records []kvcache.KVEventsRecord) (*kvEventsReplayResponse, error) {
+	s.logger.Info("Replaying kv-cache events", "file", req.File, "batches", len(records), "speed", *req.Speed)
+	start := time.Now()
+	published, err := kvcache.ReplayKVEvents(ctx, publisher, records, *req.Speed, req.Topic)
+	duration := time.Since(start)
+	if err != nil {
+		s.logger.Error(err, "kv-cache events replay stopped", "file", req.File, "published batches", published)
+		return nil, err
+	}
+	s.logger.Info("kv-cache events replay finished", "file", req.File, "batches", published, "duration", duration)
+	return &kvEventsReplayResponse{Batches: published, DurationMs: durationMs(duration)}, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+const kvEventsReplayURL = "http://localhost/_sim/kv-events/replay"
+
+// fakeKVEventsPublisher keeps the published topics and payloads
+type fakeKVEventsPublisher struct {
+	mutex    sync.Mutex
+	topics   []string
+	payloads []string
+}
+
+func (p *fakeKVEventsPublisher) PublishPayload(topic string, payload []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.topics = append(p.topics, topic)
+	p.payloads = append(p.payloads, string(payload))
+	return nil
+}
+
+var _ = Describe("KV cache events replay", func() {
+	// writeKVEventsRecordFile writes a record file with two batches, "a" and "b" encoded as base64
+	writeKVEventsRecordFile := func() string {
+		recordFile := filepath.Join(GinkgoT().TempDir(), "kv-events.jsonl")
+		Expect(os.WriteFile(recordFile, []byte(`{"offset_ms":100,"topic":"kv@b","payload":"Yg=="}`+"\n"+
+			`{"offset_ms":0,"topic":"kv@a","payload":"YQ=="}`+"\n"), 0o600)).To(Succeed())
+		return recordFile
+	}
+
+	It("should publish the recorded batches in the order of their offsets", func() {
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		req, records, err := parseKVEventsReplayRequest([]byte(`{"file":"` + writeKVEventsRecordFile() +
+			`","speed":10}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		publisher := &fakeKVEventsPublisher{}
+		resp, err := s.replayKVEvents(context.Background(), publisher, req, records)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Batches).To(Equal(2))
+		// the offset of the last batch is divided by the speed
+		Expect(resp.DurationMs).To(BeNumerically(">=", 10))
+		Expect(resp.DurationMs).To(BeNumerically("<", 100))
+		Expect(publisher.topics).To(Equal([]string{"kv@a", "kv@b"}))
+		Expect(publisher.payloads).To(Equal([]string{"a", "b"}))
+	})
+
+	DescribeTable("should reject an invalid replay request",
+		func(body string, zmqEndpoint string, expectedMsg string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+				"--zmq-endpoint", zmqEndpoint}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			body = strings.ReplaceAll(body, "RECORD_FILE", writeKVEventsRecordFile())
+			resp, err := client.Post(kvEventsReplayURL, "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(string(data)).To(ContainSubstring(expectedMsg))
+		},
+		Entry("missing file", `{}`, "tcp://localhost:5557", "file is required"),
+		Entry("not existing file", `{"file":"/not/existing.jsonl"}`, "tcp://localhost:5557", "no such file"),
+		Entry("invalid speed", `{"file":"RECORD_FILE","speed":0}`, "tcp://localhost:5557", "speed must be positive"),
+		Entry("no zmq endpoint", `{"file":"RECORD_FILE"}`, "",
+			"kv-cache events cannot be replayed without a zmq endpoint"),
+	)
+})
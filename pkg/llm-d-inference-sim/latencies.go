@@ -17,49 +17,135 @@ limitations under the License.
 // Package vllmsim implements the vLLM simulator.
 package llmdinferencesim
 
-import "github.com/llm-d/llm-d-inference-sim/pkg/common"
+import (
+	"math"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// getTimeScale returns the factor the simulated delays are divided by, 1 if the clock is not accelerated
+func (s *VllmSimulator) getTimeScale() float64 {
+	if s.config.TimeScale <= 0 {
+		// Happens in the tests
+		return 1
+	}
+	return s.config.TimeScale
+}
+
+// realDuration returns the real time the given simulated duration takes
+func (s *VllmSimulator) realDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / s.getTimeScale())
+}
+
+// virtualDuration returns the simulated duration of the given real time, the latencies are
+// reported in the simulated time
+func (s *VllmSimulator) virtualDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * s.getTimeScale())
+}
+
+// sleep waits for the real time of the given simulated duration
+func (s *VllmSimulator) sleep(d time.Duration) {
+	time.Sleep(s.realDuration(d))
+}
 
 func (s *VllmSimulator) getCurrLoadFactor() float64 {
-	if s.config.MaxNumSeqs <= 1 {
+	config := s.getRuntimeConfig()
+	if config.MaxNumSeqs <= 1 {
 		return 1.0
 	}
-	return 1 + (s.config.TimeFactorUnderLoad-1)*float64(s.nRunningReqs-1)/float64(s.config.MaxNumSeqs-1)
+	return 1 + (config.TimeFactorUnderLoad-1)*float64(s.nRunningReqs-1)/float64(config.MaxNumSeqs-1)
 }
 
-func (s *VllmSimulator) getTimeToFirstToken() int {
-	return int(float64(s.config.TimeToFirstToken) * s.getCurrLoadFactor())
+func (s *VllmSimulator) getTimeToFirstToken(profile common.LatencyProfile) int {
+	return int(float64(profile.TimeToFirstToken) * s.getCurrLoadFactor())
 }
 
 func (s *VllmSimulator) getPrefillOverhead() int {
-	return int(float64(s.config.PrefillOverhead) * s.getCurrLoadFactor())
+	return int(float64(s.getRuntimeConfig().PrefillOverhead) * s.getCurrLoadFactor())
 }
 
 func (s *VllmSimulator) getPrefillTimePerToken() int {
-	return int(float64(s.config.PrefillTimePerToken) * s.getCurrLoadFactor())
+	return int(float64(s.getRuntimeConfig().PrefillTimePerToken) * s.getCurrLoadFactor())
 }
 
-// returns time to first token based on the current request's doRemotePrefill
-func (s *VllmSimulator) getWaitTimeToFirstToken(nPromptTokens int, nCachedPromptTokens int, doRemotePrefill bool) int {
+// returns time to first token of the given model based on the current request's doRemotePrefill
+func (s *VllmSimulator) getWaitTimeToFirstToken(model string, nPromptTokens int, nCachedPromptTokens int,
+	doRemotePrefill bool) int {
+	config := s.getRuntimeConfig()
 	if doRemotePrefill {
-		if s.config.KVCacheTransferLatency == 0 && s.config.KVCacheTransferLatencyStdDev == 0 {
+		if config.KVCacheTransferLatency == 0 && config.KVCacheTransferLatencyStdDev == 0 {
 			// is disaggregated PD and ttft is calculated using number of prompt tokens
-			kvCacheTransT := s.config.KVCacheTransferTimePerToken * nPromptTokens
-			return common.RandomNorm(kvCacheTransT, s.config.KVCacheTransferTimeStdDev)
+			kvCacheTransT := config.KVCacheTransferTimePerToken * nPromptTokens
+			return s.random.Norm(kvCacheTransT, config.KVCacheTransferTimeStdDev)
 		}
 		// is disaggregated PD and *not* using number of prompt tokens
-		return common.RandomNorm(s.config.KVCacheTransferLatency, s.config.KVCacheTransferLatencyStdDev)
+		return s.random.Norm(config.KVCacheTransferLatency, config.KVCacheTransferLatencyStdDev)
 	}
-	if s.config.TimeToFirstToken == 0 && s.config.TimeToFirstTokenStdDev == 0 {
+	if s.latencyTable.HasTimeToFirstToken() {
+		// the latency table replaces the fixed time to first token and the prefill formula,
+		// it is looked up by the number of prompt tokens that are not in kv cache
+		ttft := s.latencyTable.GetTimeToFirstToken(nPromptTokens - nCachedPromptTokens)
+		return s.random.Norm(int(float64(ttft)*s.getCurrLoadFactor()), config.PrefillTimeStdDev)
+	}
+	profile := config.GetLatencyProfile(model)
+	if profile.TimeToFirstToken == 0 && profile.TimeToFirstTokenStdDev == 0 {
 		// is aggregated PD and ttft is calculated using number of prompt tokens that are not in kv cache
 		prefillTime := s.getPrefillOverhead() + (nPromptTokens-nCachedPromptTokens)*s.getPrefillTimePerToken()
-		return common.RandomNorm(prefillTime, s.config.PrefillTimeStdDev)
+		if config.EnableChunkedPrefill {
+			prefillTime = s.getChunkedPrefillTime(nPromptTokens - nCachedPromptTokens)
+		}
+		return s.random.Norm(prefillTime, config.PrefillTimeStdDev)
 	}
 	// is aggregated PD and *not* using number of prompt tokens
-	return common.RandomNorm(s.getTimeToFirstToken(), s.config.TimeToFirstTokenStdDev)
+	return s.random.Norm(s.getTimeToFirstToken(profile), profile.TimeToFirstTokenStdDev)
+}
+
+// getImagePrefillTime returns the time to encode the given number of images in milliseconds, the images
+// are encoded by the local prefill only
+func (s *VllmSimulator) getImagePrefillTime(nImages int, doRemotePrefill bool) int {
+	if doRemotePrefill {
+		return 0
+	}
+	return int(float64(nImages*s.config.PrefillTimePerImage) * s.getCurrLoadFactor())
+}
+
+// returns inter token latency of the given model, the time until the next token after nGeneratedTokens tokens
+func (s *VllmSimulator) getInterTokenLatency(model string, nGeneratedTokens int) int {
+	config := s.getRuntimeConfig()
+	profile := config.GetLatencyProfile(model)
+	latency := profile.InterTokenLatency
+	if s.latencyTable.HasInterTokenLatency() {
+		latency = s.latencyTable.GetInterTokenLatency(nGeneratedTokens)
+	}
+	latency = int(float64(latency) * s.getCurrLoadFactor() * getDecodeSlowdownFactor(config, nGeneratedTokens))
+	latency += s.getBatchDecodeTime()
+	if config.EnableChunkedPrefill {
+		// the decode step waits for the prefill chunks scheduled in the same step
+		latency += s.getChunkedPrefillStall()
+	}
+	return s.random.Norm(latency, profile.InterTokenLatencyStdDev)
+}
+
+// getDecodeSlowdownFactor returns the factor of the inter token latency after nGeneratedTokens tokens,
+// decoding slows down as the sequence grows according to the decode slowdown model
+func getDecodeSlowdownFactor(config *common.Configuration, nGeneratedTokens int) float64 {
+	switch config.DecodeSlowdownModel {
+	case common.DecodeSlowdownLinear:
+		return 1 + config.DecodeSlowdownCoefficient*float64(nGeneratedTokens)
+	case common.DecodeSlowdownLog:
+		return 1 + config.DecodeSlowdownCoefficient*math.Log1p(float64(nGeneratedTokens))
+	}
+	return 1
+}
+
+// numDecodingRequests returns the number of running requests that are not in the prefill phase
+func (s *VllmSimulator) numDecodingRequests() int {
+	return int(max(s.nRunningReqs-s.activePrefills.Load(), 0))
 }
 
-// returns inter token latency
-func (s *VllmSimulator) getInterTokenLatency() int {
-	latency := int(float64(s.config.InterTokenLatency) * s.getCurrLoadFactor())
-	return common.RandomNorm(latency, s.config.InterTokenLatencyStdDev)
+// getBatchDecodeTime returns the time the other sequences decoded in the same scheduling step
+// add to a decode step
+func (s *VllmSimulator) getBatchDecodeTime() int {
+	return max(s.numDecodingRequests()-1, 0) * s.getRuntimeConfig().DecodeTimePerSequence
 }
@@ -48,7 +48,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		func(interTokenLatency int, stddev int) {
 			simulator.config.InterTokenLatency = interTokenLatency
 			simulator.config.InterTokenLatencyStdDev = stddev
-			interToken := simulator.getInterTokenLatency()
+			interToken := simulator.getInterTokenLatency(model, 1)
 			Expect(interToken).To(BeNumerically(">=", int(float32(interTokenLatency)*0.3)))
 			Expect(interToken).To(BeNumerically("<=", int(float32(interTokenLatency)*1.7)))
 		},
@@ -70,7 +70,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 
 			latency := 0
 			for range numberOfTokens - 1 {
-				latency += simulator.getInterTokenLatency()
+				latency += simulator.getInterTokenLatency(model, 1)
 			}
 
 			Expect(latency).To(BeNumerically(">=", int(float32(interTokenLatency)*0.3*float32(numberOfTokens))))
@@ -93,7 +93,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.TimeToFirstTokenStdDev = timeToFirstTokenStdDev
 			simulator.config.KVCacheTransferLatency = kvCacheLatency
 			simulator.config.KVCacheTransferLatencyStdDev = kvCacheLatencyStdDev
-			timeToFirst := simulator.getWaitTimeToFirstToken(1, 0, doREmotePrefill)
+			timeToFirst := simulator.getWaitTimeToFirstToken(model, 1, 0, doREmotePrefill)
 			if doREmotePrefill {
 				Expect(timeToFirst).To(BeNumerically(">=", int(float32(kvCacheLatency)*0.3)))
 				Expect(timeToFirst).To(BeNumerically("<=", int(float32(kvCacheLatency)*1.7)))
@@ -124,7 +124,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.PrefillTimePerToken = 200
 		simulator.config.PrefillTimeStdDev = 80
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 
 		Expect(ttft).To(BeNumerically("==", timeToFirstToken))
 	})
@@ -137,7 +137,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.PrefillTimePerToken = 200
 		simulator.config.PrefillTimeStdDev = 80
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 		Expect(ttft).NotTo(BeNumerically("==", 0))
 	})
 
@@ -148,7 +148,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.PrefillTimePerToken = prefillTimePerToken
 			simulator.config.PrefillTimeStdDev = stdDev
 
-			ttft := simulator.getWaitTimeToFirstToken(nTokens, nCachedTokens, false)
+			ttft := simulator.getWaitTimeToFirstToken(model, nTokens, nCachedTokens, false)
 
 			expectedTTFT := prefillOverhead + prefillTimePerToken*(nTokens-nCachedTokens)
 			Expect(ttft).To(BeNumerically(">=", int(float64(expectedTTFT)*0.3)))
@@ -176,7 +176,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.PrefillTimePerToken = prefillTimePerToken
 			simulator.config.PrefillTimeStdDev = 0
 
-			ttft := simulator.getWaitTimeToFirstToken(nTokens, nCachedTokens, false)
+			ttft := simulator.getWaitTimeToFirstToken(model, nTokens, nCachedTokens, false)
 			expectedTTFT := prefillOverhead + prefillTimePerToken*(nTokens-nCachedTokens)
 			Expect(ttft).To(Equal(expectedTTFT))
 		},
@@ -200,7 +200,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.KVCacheTransferTimePerToken = 100
 		simulator.config.KVCacheTransferTimeStdDev = 0
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, true)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, true)
 		Expect(ttft).To(BeNumerically("==", 200))
 	})
 
@@ -211,7 +211,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.KVCacheTransferTimePerToken = 100
 		simulator.config.KVCacheTransferTimeStdDev = 0
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, true)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, true)
 		Expect(ttft).To(BeNumerically("==", 12800))
 	})
 
@@ -222,7 +222,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.KVCacheTransferTimePerToken = kvCacheTransTPT
 			simulator.config.KVCacheTransferTimeStdDev = stddev
 
-			ttft := simulator.getWaitTimeToFirstToken(nTokens, 0, true)
+			ttft := simulator.getWaitTimeToFirstToken(model, nTokens, 0, true)
 
 			expectedTTFT := kvCacheTransTPT * nTokens
 			Expect(ttft).To(BeNumerically(">=", int(float64(expectedTTFT)*0.3)))
@@ -245,9 +245,9 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.TimeToFirstTokenStdDev = 0
 		simulator.config.TimeFactorUnderLoad = 1.0
 
-		simulator.runReqChan <- 100
+		simulator.nRunningReqs = 100
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 		Expect(ttft).To(Equal(42))
 	})
 
@@ -257,13 +257,9 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		simulator.config.TimeFactorUnderLoad = 100.0
 		simulator.config.MaxNumSeqs = 1
 
-		for len(simulator.runReqChan) > 0 {
-			<-simulator.runReqChan
-		}
-
-		simulator.runReqChan <- 1
+		simulator.nRunningReqs = 1
 
-		ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+		ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 		Expect(ttft).To(Equal(42))
 	})
 
@@ -275,7 +271,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.MaxNumSeqs = maxNumOfReq
 			simulator.nRunningReqs = int64(maxNumOfReq)
 
-			ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+			ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 			Expect(ttft).To(Equal(int(float64(42) * timeFactorUnderLoad)))
 
 		},
@@ -298,7 +294,7 @@ var _ = Describe("Check random latencies", Ordered, func() {
 			simulator.config.MaxNumSeqs = maxNumOfReq
 			simulator.nRunningReqs = int64(nCurrNumOfReq)
 
-			ttft := simulator.getWaitTimeToFirstToken(128, 0, false)
+			ttft := simulator.getWaitTimeToFirstToken(model, 128, 0, false)
 			max := timeFactorUnderLoad * float64(42)
 			Expect(ttft).To(BeNumerically(">=", 42))
 			Expect(ttft).To(BeNumerically("<=", max))
@@ -343,4 +339,163 @@ var _ = Describe("Check random latencies", Ordered, func() {
 		Expect(factor).To(BeNumerically(">", 1.0))
 		Expect(factor).To(BeNumerically("<", simulator.config.TimeFactorUnderLoad))
 	})
+
+	It("should use the latencies of a LoRA adapter", func() {
+		ttft := 50
+		itl := 5
+		simulator.config.TimeFactorUnderLoad = 1.0
+		simulator.config.MaxNumSeqs = 1
+		simulator.config.TimeToFirstToken = 1000
+		simulator.config.TimeToFirstTokenStdDev = 0
+		simulator.config.InterTokenLatency = 100
+		simulator.config.InterTokenLatencyStdDev = 0
+		simulator.config.LoraModules = []common.LoraModule{
+			{Name: "fast-lora", TimeToFirstToken: &ttft, InterTokenLatency: &itl},
+			{Name: "lora"},
+		}
+		defer func() {
+			simulator.config.LoraModules = nil
+		}()
+
+		Expect(simulator.getWaitTimeToFirstToken("fast-lora", 128, 0, false)).To(Equal(ttft))
+		Expect(simulator.getInterTokenLatency("fast-lora", 1)).To(Equal(itl))
+		Expect(simulator.getWaitTimeToFirstToken("lora", 128, 0, false)).To(Equal(1000))
+		Expect(simulator.getInterTokenLatency("lora", 1)).To(Equal(100))
+		Expect(simulator.getWaitTimeToFirstToken(model, 128, 0, false)).To(Equal(1000))
+	})
+
+	It("should use the latency table", func() {
+		simulator.config.TimeFactorUnderLoad = 1.0
+		simulator.config.MaxNumSeqs = 1
+		simulator.config.TimeToFirstToken = 1000
+		simulator.config.PrefillTimeStdDev = 0
+		simulator.config.InterTokenLatency = 100
+		simulator.config.InterTokenLatencyStdDev = 0
+		simulator.config.KVCacheTransferLatency = 500
+		simulator.config.KVCacheTransferLatencyStdDev = 0
+		simulator.latencyTable = &common.LatencyTable{
+			TimeToFirstToken:  []common.LatencyPoint{{Tokens: 100, Latency: 20}, {Tokens: 1100, Latency: 120}},
+			InterTokenLatency: []common.LatencyPoint{{Tokens: 1, Latency: 10}, {Tokens: 11, Latency: 20}},
+		}
+		defer func() {
+			simulator.latencyTable = nil
+		}()
+
+		// the table replaces the fixed time to first token, by the number of uncached prompt tokens
+		Expect(simulator.getWaitTimeToFirstToken(model, 600, 0, false)).To(Equal(70))
+		Expect(simulator.getWaitTimeToFirstToken(model, 600, 500, false)).To(Equal(20))
+		Expect(simulator.getWaitTimeToFirstToken(model, 5000, 0, false)).To(Equal(120))
+		// the transfer of a remote prefill is not affected
+		Expect(simulator.getWaitTimeToFirstToken(model, 600, 0, true)).To(Equal(500))
+
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(10))
+		Expect(simulator.getInterTokenLatency(model, 6)).To(Equal(15))
+		Expect(simulator.getInterTokenLatency(model, 100)).To(Equal(20))
+
+		// the load factor is applied to the latencies of the table
+		simulator.config.TimeFactorUnderLoad = 2.0
+		simulator.config.MaxNumSeqs = 11
+		simulator.nRunningReqs = 11
+		Expect(simulator.getWaitTimeToFirstToken(model, 600, 0, false)).To(Equal(140))
+		Expect(simulator.getInterTokenLatency(model, 6)).To(Equal(30))
+	})
+
+	It("should simulate chunked prefill", func() {
+		simulator.config.TimeFactorUnderLoad = 1.0
+		simulator.config.MaxNumSeqs = 1
+		simulator.config.TimeToFirstToken = 0
+		simulator.config.TimeToFirstTokenStdDev = 0
+		simulator.config.PrefillOverhead = 10
+		simulator.config.PrefillTimePerToken = 1
+		simulator.config.PrefillTimeStdDev = 0
+		simulator.config.InterTokenLatency = 20
+		simulator.config.InterTokenLatencyStdDev = 0
+		simulator.config.EnableChunkedPrefill = true
+		simulator.config.MaxNumBatchedTokens = 512
+		defer func() {
+			simulator.config.EnableChunkedPrefill = false
+			simulator.nRunningReqs = 0
+			simulator.activePrefills.Store(0)
+		}()
+
+		// a single request in the prefill phase is prefilled in chunks of 512 tokens
+		simulator.nRunningReqs = 1
+		simulator.activePrefills.Store(1)
+		Expect(simulator.getWaitTimeToFirstToken(model, 1000, 0, false)).To(Equal(2*10 + 1000))
+		Expect(simulator.getWaitTimeToFirstToken(model, 1000, 600, false)).To(Equal(10 + 400))
+		// the decoding requests take a token each from the budget of a step
+		simulator.nRunningReqs = 113
+		Expect(simulator.getWaitTimeToFirstToken(model, 1000, 0, false)).To(Equal(3*10 + 1000))
+		// the requests in the prefill phase share the budget of a step
+		simulator.nRunningReqs = 2
+		simulator.activePrefills.Store(2)
+		Expect(simulator.getWaitTimeToFirstToken(model, 1000, 0, false)).To(Equal(4*10 + 1000))
+		// the transfer of a remote prefill is not affected
+		simulator.config.KVCacheTransferLatency = 500
+		simulator.config.KVCacheTransferLatencyStdDev = 0
+		Expect(simulator.getWaitTimeToFirstToken(model, 1000, 0, true)).To(Equal(500))
+
+		// a decode step is delayed by the prefill chunks scheduled with it
+		simulator.activePrefills.Store(1)
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20))
+		endPrefill := simulator.startPrefill(1000)
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20 + 511))
+		endPrefill()
+		endPrefill = simulator.startPrefill(100)
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20 + 100))
+		endPrefill()
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20))
+	})
+
+	It("should add the decode time of the concurrently decoding sequences", func() {
+		simulator.config.TimeFactorUnderLoad = 1.0
+		simulator.config.MaxNumSeqs = 10
+		simulator.config.InterTokenLatency = 20
+		simulator.config.InterTokenLatencyStdDev = 0
+		simulator.config.DecodeTimePerSequence = 3
+		defer func() {
+			simulator.config.DecodeTimePerSequence = 0
+			simulator.nRunningReqs = 0
+			simulator.activePrefills.Store(0)
+		}()
+
+		// a single decoding sequence is not slowed down
+		simulator.nRunningReqs = 1
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20))
+		// every other decoding sequence adds to the decode step
+		simulator.nRunningReqs = 5
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20 + 4*3))
+		// the requests in the prefill phase are not decoded
+		simulator.activePrefills.Store(2)
+		Expect(simulator.getInterTokenLatency(model, 1)).To(Equal(20 + 2*3))
+	})
+
+	It("should slow down the decoding as the sequence grows", func() {
+		simulator.config.TimeFactorUnderLoad = 1.0
+		simulator.config.MaxNumSeqs = 1
+		simulator.config.InterTokenLatency = 20
+		simulator.config.InterTokenLatencyStdDev = 0
+		simulator.config.DecodeSlowdownModel = common.DecodeSlowdownLinear
+		simulator.config.DecodeSlowdownCoefficient = 0.01
+		defer func() {
+			simulator.config.DecodeSlowdownModel = common.DecodeSlowdownNone
+			simulator.config.DecodeSlowdownCoefficient = 0
+		}()
+
+		Expect(simulator.getInterTokenLatency(model, 0)).To(Equal(20))
+		Expect(simulator.getInterTokenLatency(model, 50)).To(Equal(30))
+		Expect(simulator.getInterTokenLatency(model, 500)).To(Equal(120))
+
+		simulator.config.DecodeSlowdownModel = common.DecodeSlowdownLog
+		simulator.config.DecodeSlowdownCoefficient = 0.5
+		Expect(simulator.getInterTokenLatency(model, 0)).To(Equal(20))
+		// 20 * (1 + 0.5 * ln(100))
+		Expect(simulator.getInterTokenLatency(model, 99)).To(Equal(66))
+		// 20 * (1 + 0.5 * ln(1000))
+		Expect(simulator.getInterTokenLatency(model, 999)).To(Equal(89))
+
+		// no slowdown by default
+		simulator.config.DecodeSlowdownModel = common.DecodeSlowdownNone
+		Expect(simulator.getInterTokenLatency(model, 999)).To(Equal(20))
+	})
 })
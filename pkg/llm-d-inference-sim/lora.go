@@ -18,8 +18,14 @@ limitations under the License.
 package llmdinferencesim
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
 
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 	"github.com/valyala/fasthttp"
 )
 
@@ -32,6 +38,63 @@ type unloadLoraRequest struct {
 	LoraName string `json:"lora_name"`
 }
 
+const (
+	// the operations of the LoRA adapter requests, used as metric labels
+	loraOperationLoad   = "load"
+	loraOperationUnload = "unload"
+)
+
+// loraIdleCheckInterval is the interval between the checks for idle LoRA adapters
+const loraIdleCheckInterval = 100 * time.Millisecond
+
+// loadedLora is a LoRA adapter and the time it was loaded
+type loadedLora struct {
+	name string
+	// path is the path the adapter was loaded from, empty if unknown
+	path string
+	// baseModelName is the name of the adapter's base model, empty if not set
+	baseModelName string
+	loadTime      time.Time
+}
+
+// storeLora adds a LoRA adapter, the load time and path of an already loaded adapter are kept
+func (s *VllmSimulator) storeLora(lora loadedLora) {
+	if _, loaded := s.loraAdaptors.LoadOrStore(lora.name, lora); !loaded {
+		s.loraLastUsed.Store(lora.name, lora.loadTime)
+	}
+}
+
+// deleteLora removes a LoRA adapter
+func (s *VllmSimulator) deleteLora(name string) {
+	s.loraAdaptors.Delete(name)
+	s.loraLastUsed.Delete(name)
+}
+
+// getLoadedLoras returns the LoRA adapters sorted by their load time, adapters loaded at the same time
+// are sorted by name
+func (s *VllmSimulator) getLoadedLoras() []loadedLora {
+	loras := make([]loadedLora, 0)
+
+	s.loraAdaptors.Range(func(key, value any) bool {
+		_, nameOk := key.(string)
+		lora, loraOk := value.(loadedLora)
+		if nameOk && loraOk {
+			loras = append(loras, lora)
+		} else {
+			s.logger.Info("Stored LoRA is invalid", "key", key, "value", value)
+		}
+		return true
+	})
+
+	sort.Slice(loras, func(i, j int) bool {
+		if loras[i].loadTime.Equal(loras[j].loadTime) {
+			return loras[i].name < loras[j].name
+		}
+		return loras[i].loadTime.Before(loras[j].loadTime)
+	})
+	return loras
+}
+
 func (s *VllmSimulator) getLoras() []string {
 	loras := make([]string, 0)
 
@@ -53,10 +116,70 @@ func (s *VllmSimulator) loadLora(ctx *fasthttp.RequestCtx) {
 	if err != nil {
 		s.logger.Error(err, "failed to read and parse load lora request body")
 		ctx.Error("failed to read and parse load lora request body, "+err.Error(), fasthttp.StatusBadRequest)
+		s.reportLoraAdapterRequest(loraOperationLoad, fasthttp.StatusBadRequest)
+		return
+	}
+
+	if compErr := s.startLoraLoad(req); compErr != nil {
+		s.sendLoraAdapterError(ctx, loraOperationLoad, *compErr)
 		return
 	}
+	s.sleep(s.config.LoraLoadLatency)
+	s.finishLoraLoad(req)
+
+	s.logger.Info("LoRA adapter loaded", "lora", req.LoraName, "path", req.LoraPath)
+	s.reportLoraAdapterRequest(loraOperationLoad, fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("Success: LoRA adapter '%s' added successfully.", req.LoraName))
+}
 
-	s.loraAdaptors.Store(req.LoraName, "")
+// startLoraLoad checks whether the LoRA adapter can be loaded and marks it as loading,
+// returns the error to send if it can't be loaded
+func (s *VllmSimulator) startLoraLoad(req loadLoraRequest) *openaiserverapi.CompletionError {
+	s.loraLoadMutex.Lock()
+	defer s.loraLoadMutex.Unlock()
+
+	var compErr openaiserverapi.CompletionError
+	_, loaded := s.loraAdaptors.Load(req.LoraName)
+	switch {
+	case req.LoraName == "" || req.LoraPath == "":
+		compErr = openaiserverapi.NewCompletionError("Both 'lora_name' and 'lora_path' must be provided.",
+			fasthttp.StatusBadRequest, nil)
+	case loaded || s.loadingLoras[req.LoraName]:
+		compErr = openaiserverapi.NewCompletionError(
+			fmt.Sprintf("The lora adapter '%s' has already been loaded.", req.LoraName), fasthttp.StatusBadRequest, nil)
+	case s.config.MaxDynamicLoras > 0 && s.numDynamicLoras() >= s.config.MaxDynamicLoras:
+		compErr = openaiserverapi.NewCompletionError(
+			fmt.Sprintf("Loading lora %s failed: the maximum number of dynamically loaded LoRA adapters (%d) "+
+				"has been reached.", req.LoraName, s.config.MaxDynamicLoras), fasthttp.StatusBadRequest, nil)
+	case s.config.LoraLoadFailureRate > 0 && s.random.Int(1, 100) <= s.config.LoraLoadFailureRate:
+		compErr = openaiserverapi.NewCompletionError(
+			fmt.Sprintf("Loading lora %s failed: No adapter found for %s", req.LoraName, req.LoraPath),
+			fasthttp.StatusNotFound, nil)
+	default:
+		s.loadingLoras[req.LoraName] = true
+		return nil
+	}
+	return &compErr
+}
+
+// finishLoraLoad stores the LoRA adapter that was being loaded
+func (s *VllmSimulator) finishLoraLoad(req loadLoraRequest) {
+	s.loraLoadMutex.Lock()
+	defer s.loraLoadMutex.Unlock()
+	delete(s.loadingLoras, req.LoraName)
+	s.storeLora(loadedLora{name: req.LoraName, path: req.LoraPath, loadTime: time.Now()})
+}
+
+// numDynamicLoras returns the number of the LoRA adapters that are loaded or being loaded
+// by /v1/load_lora_adapter
+func (s *VllmSimulator) numDynamicLoras() int {
+	count := len(s.loadingLoras)
+	for _, lora := range s.getLoras() {
+		if !s.isStaticLora(lora) {
+			count++
+		}
+	}
+	return count
 }
 
 func (s *VllmSimulator) unloadLora(ctx *fasthttp.RequestCtx) {
@@ -65,8 +188,131 @@ func (s *VllmSimulator) unloadLora(ctx *fasthttp.RequestCtx) {
 	if err != nil {
 		s.logger.Error(err, "failed to read and parse unload lora request body")
 		ctx.Error("failed to read and parse unload lora request body, "+err.Error(), fasthttp.StatusBadRequest)
+		s.reportLoraAdapterRequest(loraOperationUnload, fasthttp.StatusBadRequest)
 		return
 	}
 
-	s.loraAdaptors.Delete(req.LoraName)
+	if req.LoraName == "" {
+		s.sendLoraAdapterError(ctx, loraOperationUnload, openaiserverapi.NewCompletionError(
+			"'lora_name' needs to be provided to unload a LoRA adapter.", fasthttp.StatusBadRequest, nil))
+		return
+	}
+	if _, loaded := s.loraAdaptors.Load(req.LoraName); !loaded {
+		s.sendLoraAdapterError(ctx, loraOperationUnload, openaiserverapi.NewCompletionError(
+			fmt.Sprintf("The lora adapter '%s' cannot be found.", req.LoraName), fasthttp.StatusNotFound, nil))
+		return
+	}
+	s.sleep(s.config.LoraUnloadLatency)
+	s.deleteLora(req.LoraName)
+
+	s.logger.Info("LoRA adapter unloaded", "lora", req.LoraName)
+	s.reportLoraAdapterRequest(loraOperationUnload, fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf("Success: LoRA adapter '%s' removed successfully.", req.LoraName))
+}
+
+// sendLoraAdapterError sends the error response of a failed LoRA adapter load or unload request
+func (s *VllmSimulator) sendLoraAdapterError(ctx *fasthttp.RequestCtx, operation string,
+	compErr openaiserverapi.CompletionError) {
+	s.reportLoraAdapterRequest(operation, compErr.Code)
+	s.sendCompletionError(ctx, compErr, "")
+}
+
+// markLoraUsed updates the last used time of the given model if it is a LoRA adapter
+func (s *VllmSimulator) markLoraUsed(model string, now time.Time) {
+	if s.isLora(model) {
+		s.loraLastUsed.Store(model, now)
+	}
+}
+
+// isStaticLora returns true if the given LoRA adapter is defined in the configuration's LoRA modules
+func (s *VllmSimulator) isStaticLora(name string) bool {
+	for _, lora := range s.getRuntimeConfig().LoraModules {
+		if lora.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasInFlightRequests returns true if there are waiting or running requests to the given model
+func (s *VllmSimulator) hasInFlightRequests(model string) bool {
+	found := false
+	s.inFlightRequests.Range(func(_, value any) bool {
+		found = value.(*inFlightRequest).model == model
+		return !found
+	})
+	return found
+}
+
+// unloadLoraIfIdle unloads the given LoRA adapter if it has no waiting or running requests, returns false
+// if the adapter is kept. No request to the adapter is admitted between the check and the unload
+func (s *VllmSimulator) unloadLoraIfIdle(lora string) bool {
+	s.loraAdmissionMutex.Lock()
+	defer s.loraAdmissionMutex.Unlock()
+	if s.hasInFlightRequests(lora) {
+		return false
+	}
+	s.deleteLora(lora)
+	return true
+}
+
+// loraIdleUnloader periodically unloads the LoRA adapters that were idle longer than the
+// configured LoRA idle unload time
+func (s *VllmSimulator) loraIdleUnloader(ctx context.Context) {
+	ticker := time.NewTicker(loraIdleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.unloadIdleLoras(now)
+		}
+	}
+}
+
+// unloadIdleLoras unloads the LoRA adapters that were idle longer than the configured LoRA idle unload time
+// at the given time, adapters with waiting or running requests and static adapters (unless configured
+// otherwise) are kept
+func (s *VllmSimulator) unloadIdleLoras(now time.Time) {
+	for _, lora := range s.getLoras() {
+		if !s.config.LoraIdleUnloadStatic && s.isStaticLora(lora) {
+			continue
+		}
+		value, ok := s.loraLastUsed.Load(lora)
+		if !ok {
+			continue
+		}
+		idle := now.Sub(value.(time.Time))
+		if idle < s.config.LoraIdleUnloadAfter || !s.unloadLoraIfIdle(lora) {
+			continue
+		}
+		s.logger.Info("Idle LoRA adapter unloaded", "lora", lora, "idle time", idle)
+		s.reportLoraAutoUnload()
+	}
+}
+
+// reportLoraAdapterRequest increments the counter of the LoRA adapter requests of the given operation
+// and status code, and updates the LoRA metric after a successful request
+func (s *VllmSimulator) reportLoraAdapterRequest(operation string, statusCode int) {
+	if statusCode == fasthttp.StatusOK {
+		s.metricsMutex.Lock()
+		s.reportLoras()
+		s.metricsMutex.Unlock()
+	}
+	if s.loraAdapterRequests == nil {
+		// Happens in the tests
+		return
+	}
+	s.loraAdapterRequests.WithLabelValues(operation, strconv.Itoa(statusCode)).Inc()
+}
+
+// reportLoraAutoUnload increments the counter of the idle LoRA adapters that were unloaded automatically
+func (s *VllmSimulator) reportLoraAutoUnload() {
+	if s.loraAutoUnloads == nil {
+		// Happens in the tests
+		return
+	}
+	s.loraAutoUnloads.Inc()
 }
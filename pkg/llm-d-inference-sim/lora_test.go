@@ -20,13 +20,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 )
 
@@ -116,5 +123,346 @@ var _ = Describe("LoRAs", func() {
 			Expect(modelsResp).NotTo(BeNil())
 			Expect(modelsResp.Data).To(HaveLen(3))
 		})
+
+		It("Should return LoRA metadata in /models", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--max-model-len", "2048",
+					"--served-model-name", "base1", "base2",
+					"--lora-modules", "{\"name\":\"lora4\",\"path\":\"/path/to/lora4\",\"base_model_name\":\"" + model + "\"}",
+					"{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+			options := option.WithHeader("Content-Type", "application/json")
+			loadLora := func(name string) {
+				loraParams, err := json.Marshal(map[string]string{"lora_name": name, "lora_path": "/path/to/" + name})
+				Expect(err).ToNot(HaveOccurred())
+				err = openaiclient.Post(ctx, "/load_lora_adapter", loraParams, nil, options)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			getModels := func() []vllmapi.ModelsResponseModelInfo {
+				var modelsResp vllmapi.ModelsResponse
+				err := openaiclient.Get(ctx, "/models", nil, &modelsResp)
+				Expect(err).ToNot(HaveOccurred())
+				return modelsResp.Data
+			}
+			ids := func(models []vllmapi.ModelsResponseModelInfo) []string {
+				result := make([]string, 0, len(models))
+				for _, m := range models {
+					result = append(result, m.ID)
+				}
+				return result
+			}
+
+			beforeLoad := time.Now().Unix()
+			// the created time has a resolution of seconds
+			time.Sleep(time.Second)
+			loadLora("lora2")
+			time.Sleep(time.Second)
+			loadLora("lora1")
+
+			// base models first, then the adapters sorted by load time,
+			// the adapters from the configuration are sorted by name
+			models := getModels()
+			Expect(ids(models)).To(Equal([]string{"base1", "base2", "lora3", "lora4", "lora2", "lora1"}))
+			for _, m := range models[:2] {
+				Expect(m.Parent).To(BeNil())
+				// the root of a base model is the model's path
+				Expect(m.Root).To(Equal(model))
+				Expect(m.OwnedBy).To(Equal("vllm"))
+				Expect(m.MaxModelLen).To(Equal(2048))
+			}
+			for _, m := range models[2:] {
+				Expect(m.Parent).NotTo(BeNil())
+				// the base model of lora4 is set in the configuration
+				if m.ID == "lora4" {
+					Expect(*m.Parent).To(Equal(model))
+				} else {
+					Expect(*m.Parent).To(Equal("base1"))
+				}
+				Expect(m.Root).To(Equal("/path/to/" + m.ID))
+				Expect(m.OwnedBy).To(Equal("vllm"))
+				Expect(m.Object).To(Equal(vllmapi.ObjectModel))
+				Expect(m.MaxModelLen).To(Equal(2048))
+			}
+			for _, m := range models {
+				Expect(m.Permission).To(HaveLen(1))
+				Expect(m.Permission[0].ID).To(HavePrefix("modelperm-"))
+				Expect(m.Permission[0].Object).To(Equal(vllmapi.ObjectModelPermission))
+				Expect(m.Permission[0].Created).To(Equal(m.Created))
+				Expect(m.Permission[0].AllowSampling).To(BeTrue())
+				Expect(m.Permission[0].AllowFineTuning).To(BeFalse())
+				Expect(m.Permission[0].Organization).To(Equal("*"))
+			}
+			Expect(models[2].Created).To(BeNumerically("<=", beforeLoad))
+			Expect(models[3].Created).To(Equal(models[2].Created))
+			Expect(models[4].Created).To(BeNumerically(">", beforeLoad))
+			Expect(models[5].Created).To(BeNumerically(">", models[4].Created))
+
+			// the created time of the adapters doesn't change between calls,
+			// the permission ids are generated for every call
+			time.Sleep(time.Second)
+			for i, m := range getModels()[2:] {
+				Expect(m.ID).To(Equal(models[2+i].ID))
+				Expect(m.Created).To(Equal(models[2+i].Created))
+			}
+
+			// unloaded adapters disappear immediately
+			loraParams, err := json.Marshal(map[string]string{"lora_name": "lora4"})
+			Expect(err).ToNot(HaveOccurred())
+			err = openaiclient.Post(ctx, "/unload_lora_adapter", loraParams, nil, options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids(getModels())).To(Equal([]string{"base1", "base2", "lora3", "lora2", "lora1"}))
+		})
+	})
+
+	Context("LoRAs idle unload", func() {
+		startIdleUnloadServer := func(ctx context.Context, extraArgs ...string) (*http.Client, openai.Client) {
+			args := append([]string{"cmd", "--model", model, "--mode", common.ModeEcho,
+				"--lora-modules", "{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}",
+				"--lora-idle-unload-after", "1s"}, extraArgs...)
+			client, err := startServerWithArgs(ctx, "", args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return client, openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		}
+		getModelIDs := func(ctx context.Context, openaiclient openai.Client) []string {
+			var modelsResp vllmapi.ModelsResponse
+			err := openaiclient.Get(ctx, "/models", nil, &modelsResp)
+			Expect(err).ToNot(HaveOccurred())
+			result := make([]string, 0, len(modelsResp.Data))
+			for _, m := range modelsResp.Data {
+				result = append(result, m.ID)
+			}
+			return result
+		}
+		getAutoUnloads := func(client *http.Client) float64 {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return getGaugeValue(string(data), "sim_lora_auto_unloads_total")
+		}
+
+		It("Should unload an idle loaded LoRA and keep the configured LoRA", func() {
+			ctx := context.TODO()
+			client, openaiclient := startIdleUnloadServer(ctx)
+
+			loraParams, err := json.Marshal(map[string]string{"lora_name": "lora1", "lora_path": "/path/to/lora1"})
+			Expect(err).ToNot(HaveOccurred())
+			err = openaiclient.Post(ctx, "/load_lora_adapter", loraParams, nil,
+				option.WithHeader("Content-Type", "application/json"))
+			Expect(err).ToNot(HaveOccurred())
+
+			params := openai.ChatCompletionNewParams{
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+				Model:    "lora1",
+			}
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).ToNot(HaveOccurred())
+			lastUsed := time.Now()
+			Expect(getModelIDs(ctx, openaiclient)).To(Equal([]string{model, "lora3", "lora1"}))
+
+			Eventually(func() []string {
+				return getModelIDs(ctx, openaiclient)
+			}, 3*time.Second, 50*time.Millisecond).Should(Equal([]string{model, "lora3"}))
+			// the idle time is counted from the last request
+			Expect(time.Since(lastUsed)).To(BeNumerically(">=", 900*time.Millisecond))
+			Expect(getAutoUnloads(client)).To(Equal(1.0))
+
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			var openaiError *openai.Error
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(404))
+
+			// the configured LoRA is never unloaded
+			Consistently(func() []string {
+				return getModelIDs(ctx, openaiclient)
+			}, time.Second, 100*time.Millisecond).Should(Equal([]string{model, "lora3"}))
+		})
+
+		It("Should unload an idle configured LoRA with lora-idle-unload-static", func() {
+			ctx := context.TODO()
+			client, openaiclient := startIdleUnloadServer(ctx, "--lora-idle-unload-static")
+
+			Eventually(func() []string {
+				return getModelIDs(ctx, openaiclient)
+			}, 3*time.Second, 50*time.Millisecond).Should(Equal([]string{model}))
+			Expect(getAutoUnloads(client)).To(Equal(1.0))
+		})
+
+		It("Should not unload a LoRA with running requests", func() {
+			ctx := context.TODO()
+			_, openaiclient := startIdleUnloadServer(ctx, "--lora-idle-unload-static", "--time-to-first-token", "2000")
+
+			done := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				params := openai.ChatCompletionNewParams{
+					Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(userMessage)},
+					Model:    "lora3",
+				}
+				_, err := openaiclient.Chat.Completions.New(ctx, params)
+				done <- err
+			}()
+
+			// the request runs longer than the idle time
+			Consistently(func() []string {
+				return getModelIDs(ctx, openaiclient)
+			}, 1500*time.Millisecond, 100*time.Millisecond).Should(Equal([]string{model, "lora3"}))
+			Eventually(done, 2*time.Second).Should(Receive(BeNil()))
+			Eventually(func() []string {
+				return getModelIDs(ctx, openaiclient)
+			}, 3*time.Second, 50*time.Millisecond).Should(Equal([]string{model}))
+		})
+		It("Should not unload a LoRA while a request to it is admitted", func() {
+			s := &VllmSimulator{config: &common.Configuration{LoraIdleUnloadAfter: time.Second},
+				logger: klog.Background()}
+			s.loraAdaptors.Store("lora1", loadedLora{name: "lora1"})
+			s.loraLastUsed.Store("lora1", time.Now().Add(-time.Minute))
+
+			// the request passed the validation of its model and is not in flight yet
+			s.loraAdmissionMutex.RLock()
+			unloadChecked := make(chan struct{})
+			go func() {
+				s.unloadIdleLoras(time.Now())
+				close(unloadChecked)
+			}()
+			Consistently(unloadChecked, 200*time.Millisecond).ShouldNot(BeClosed())
+			req := &openaiserverapi.TextCompletionRequest{
+				BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{RequestID: "lora-request", Model: "lora1"},
+				Prompt:                userMessage,
+			}
+			s.addInFlightRequest(req, "", trace.SpanContext{}, "", nil)
+			s.loraAdmissionMutex.RUnlock()
+
+			Eventually(unloadChecked, time.Second).Should(BeClosed())
+			Expect(s.getLoras()).To(Equal([]string{"lora1"}))
+
+			s.removeInFlightRequest("lora-request")
+			s.unloadIdleLoras(time.Now())
+			Expect(s.getLoras()).To(BeEmpty())
+		})
+	})
+
+	Context("LoRA adapter requests", func() {
+		postLoraRequest := func(client *http.Client, path string, body string) (int, string) {
+			resp, err := client.Post("http://localhost/v1/"+path, "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return resp.StatusCode, string(data)
+		}
+
+		It("Should fail the load and unload requests like vLLM", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--max-dynamic-loras", "1",
+					"--lora-modules", "{\"name\":\"lora3\",\"path\":\"/path/to/lora3\"}"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			statusCode, body := postLoraRequest(client, "load_lora_adapter", `{"lora_name": "lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("Both 'lora_name' and 'lora_path' must be provided."))
+
+			statusCode, body = postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora1", "lora_path": "/path/to/lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("Success: LoRA adapter 'lora1' added successfully."))
+
+			statusCode, body = postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora1", "lora_path": "/path/to/lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("The lora adapter 'lora1' has already been loaded."))
+
+			// the adapters from the configuration are not counted
+			statusCode, body = postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora2", "lora_path": "/path/to/lora2"}`)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("maximum number of dynamically loaded LoRA adapters (1)"))
+
+			statusCode, body = postLoraRequest(client, "unload_lora_adapter", `{"lora_name": "lora2"}`)
+			Expect(statusCode).To(Equal(http.StatusNotFound))
+			Expect(body).To(ContainSubstring("The lora adapter 'lora2' cannot be found."))
+
+			statusCode, _ = postLoraRequest(client, "unload_lora_adapter", `{"lora_name": "lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			statusCode, _ = postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora2", "lora_path": "/path/to/lora2"}`)
+			Expect(statusCode).To(Equal(http.StatusOK))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(getGaugeValue(metrics, `sim_lora_adapter_requests_total{operation="load",status_code="200"}`)).
+				To(Equal(2.0))
+			Expect(getGaugeValue(metrics, `sim_lora_adapter_requests_total{operation="load",status_code="400"}`)).
+				To(Equal(3.0))
+			Expect(getGaugeValue(metrics, `sim_lora_adapter_requests_total{operation="unload",status_code="404"}`)).
+				To(Equal(1.0))
+		})
+
+		It("Should load the adapters with the load latency and fail by the failure rate", func() {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--lora-load-latency", "300ms"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			statusCode, _ := postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora1", "lora_path": "/path/to/lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusOK))
+			Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+
+			client, err = startServerWithArgs(ctx, "",
+				[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--lora-load-failure-rate", "100"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			statusCode, body := postLoraRequest(client, "load_lora_adapter",
+				`{"lora_name": "lora1", "lora_path": "/path/to/lora1"}`)
+			Expect(statusCode).To(Equal(http.StatusNotFound))
+			Expect(body).To(ContainSubstring("Loading lora lora1 failed: No adapter found for /path/to/lora1"))
+		})
+	})
+})
+
+var _ = Describe("Model retrieval", func() {
+	It("Should return a served model or a loaded LoRA adapter by its id", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, "",
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho,
+				"--served-model-name", "org/base1", "base2",
+				"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		openaiclient, _ := getOpenAIClentAndChatParams(client, model, userMessage, false)
+
+		// the id of a model may contain a slash
+		resp, err := openaiclient.Models.Get(ctx, "org/base1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.ID).To(Equal("org/base1"))
+		Expect(string(resp.Object)).To(Equal("model"))
+
+		var modelInfo vllmapi.ModelsResponseModelInfo
+		Expect(openaiclient.Get(ctx, "/models/lora1", nil, &modelInfo)).To(Succeed())
+		Expect(modelInfo.ID).To(Equal("lora1"))
+		Expect(modelInfo.Root).To(Equal("/path/to/lora1"))
+		Expect(*modelInfo.Parent).To(Equal("org/base1"))
+
+		modelInfo = vllmapi.ModelsResponseModelInfo{}
+		Expect(openaiclient.Get(ctx, "/models/base2", nil, &modelInfo)).To(Succeed())
+		Expect(modelInfo.ID).To(Equal("base2"))
+		Expect(modelInfo.Root).To(Equal(model))
+		Expect(modelInfo.Parent).To(BeNil())
+
+		_, err = openaiclient.Models.Get(ctx, model)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(openaiError.Message).To(Equal("The model `" + model + "` does not exist."))
 	})
 })
@@ -0,0 +1,212 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"sync"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// memoryModel simulates the pool of kv cache blocks in the GPU memory, every running request holds
+// the blocks of its prompt and of the tokens it generated so far
+type memoryModel struct {
+	mutex sync.Mutex
+	// nBlocks is the number of blocks in the pool
+	nBlocks int
+	// blockSize is the number of tokens in a block
+	blockSize int
+	// nUsedBlocks is the number of blocks held by the requests
+	nUsedBlocks int
+	// requestBlocks is the number of blocks held by every request
+	requestBlocks map[string]int
+	// preempted are the requests that were preempted because the pool was exhausted and wait for
+	// blocks to resume, new requests don't get blocks while preempted requests wait
+	preempted map[string]bool
+	// released is closed when blocks are released or a preempted request resumes, it is replaced
+	// by a new channel for the next change
+	released chan struct{}
+}
+
+// newMemoryModel returns the memory model of the given number of blocks, nil if the memory model is disabled
+func newMemoryModel(enabled bool, nBlocks int, blockSize int) *memoryModel {
+	if !enabled {
+		return nil
+	}
+	return &memoryModel{
+		nBlocks:       nBlocks,
+		blockSize:     blockSize,
+		requestBlocks: make(map[string]int),
+		preempted:     make(map[string]bool),
+		released:      make(chan struct{}),
+	}
+}
+
+// allocate makes the request hold the blocks of the given number of tokens. The blocks are allocated if
+// they are free, or if the request is the only one that holds blocks, so that a request larger than the pool
+// still runs. Returns false and a channel that is closed on the next release if the blocks are not available
+func (m *memoryModel) allocate(requestID string, nTokens int) (bool, <-chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	held := m.requestBlocks[requestID]
+	needed := (nTokens+m.blockSize-1)/m.blockSize - held
+	if needed <= 0 {
+		return true, nil
+	}
+	if len(m.preempted) > 0 && !m.preempted[requestID] {
+		// the preempted requests get the released blocks first
+		return false, m.released
+	}
+	if m.nUsedBlocks+needed > m.nBlocks && m.nUsedBlocks > held {
+		return false, m.released
+	}
+	m.requestBlocks[requestID] = held + needed
+	m.nUsedBlocks += needed
+	if m.preempted[requestID] {
+		delete(m.preempted, requestID)
+		m.notify()
+	}
+	return true, nil
+}
+
+// preempt frees the blocks of a request that is preempted, the request waits for the blocks
+// before the new requests
+func (m *memoryModel) preempt(requestID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.release(requestID)
+	m.preempted[requestID] = true
+}
+
+// free frees the blocks of a request
+func (m *memoryModel) free(requestID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.release(requestID)
+	if m.preempted[requestID] {
+		delete(m.preempted, requestID)
+		m.notify()
+	}
+}
+
+// release frees the blocks of a request, must be called while holding the mutex
+func (m *memoryModel) release(requestID string) {
+	held, ok := m.requestBlocks[requestID]
+	if !ok {
+		return
+	}
+	delete(m.requestBlocks, requestID)
+	m.nUsedBlocks -= held
+	m.notify()
+}
+
+// notify wakes the requests that wait for blocks, must be called while holding the mutex
+func (m *memoryModel) notify() {
+	close(m.released)
+	m.released = make(chan struct{})
+}
+
+// usage returns the fraction of the blocks in use, at most 1
+func (m *memoryModel) usage() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return min(float64(m.nUsedBlocks)/float64(m.nBlocks), 1)
+}
+
+// waitForMemory waits until the request that leaves the waiting queue gets the blocks of its prompt,
+// the request is counted as waiting meanwhile. Returns when the client disconnects as well
+func (s *VllmSimulator) waitForMemory(reqCtx *openaiserverapi.CompletionReqCtx) {
+	if s.memory == nil {
+		return
+	}
+	req := reqCtx.CompletionReq
+	s.waitForBlocks(req.GetRequestID(), req.GetNumberOfPromptTokens(), reqCtx.Disconnected)
+}
+
+// reserveMemory makes the running request hold the blocks of the given number of tokens, its prompt and the tokens
+// generated so far. If the pool is exhausted the request is preempted: it frees its blocks, waits until the blocks
+// of all its tokens are free and resumes. Returns false if the client disconnected while the request was preempted
+func (s *VllmSimulator) reserveMemory(model string, requestID string, nTokens int, disconnected <-chan struct{}) bool {
+	if s.memory == nil {
+		return true
+	}
+	if ok, _ := s.memory.allocate(requestID, nTokens); ok {
+		s.reportMemoryUsage()
+		return true
+	}
+	s.logger.V(4).Info("Request preempted, the kv cache is full", "request id", requestID, "tokens", nTokens)
+	s.memory.preempt(requestID)
+	s.reportMemoryUsage()
+	s.reportPreemption(model)
+	// decrement running and increment waiting requests count
+	s.reportRequestTransition(model, preemptedRequestState)
+	resumed := s.waitForBlocks(requestID, nTokens, disconnected)
+	s.reportRequestTransition(model, startedRequestState)
+	return resumed
+}
+
+// waitForBlocks waits until the request gets the blocks of the given number of tokens,
+// returns false if the client disconnected before
+func (s *VllmSimulator) waitForBlocks(requestID string, nTokens int, disconnected <-chan struct{}) bool {
+	for {
+		ok, released := s.memory.allocate(requestID, nTokens)
+		if ok {
+			s.reportMemoryUsage()
+			return true
+		}
+		select {
+		case <-released:
+		case <-disconnected:
+			return false
+		}
+	}
+}
+
+// freeMemory frees the blocks of a request that ended or returned to the waiting queue
+func (s *VllmSimulator) freeMemory(requestID string) {
+	if s.memory == nil {
+		return
+	}
+	s.memory.free(requestID)
+	s.reportMemoryUsage()
+}
+
+// reportMemoryUsage reports the occupancy of the memory model as the kv cache usage
+func (s *VllmSimulator) reportMemoryUsage() {
+	s.metricsMutex.Lock()
+	defer s.metricsMutex.Unlock()
+	s.reportKVCacheUsage(s.memory.usage())
+}
+
+// choicesTokens returns the number of tokens of the choices after the given number of decode steps
+func choicesTokens(choices []responseChoice, nSteps int) int {
+	nTokens := 0
+	for _, choice := range choices {
+		nTokens += min(nSteps, choice.nTokens)
+	}
+	return nTokens
+}
+
+// kvCacheUsageChannel returns the channel of the kv cache usage updates of the kv cache, nil if the memory model
+// is enabled, the kv cache usage metric reports the occupancy of the memory model then
+func (s *VllmSimulator) kvCacheUsageChannel() chan float64 {
+	if s.config.EnableMemoryModel {
+		return nil
+	}
+	return s.kvCacheUsageChan
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+)
+
+var _ = Describe("Memory model", func() {
+	const (
+		usageMetric       = `vllm:gpu_cache_usage_perc{model_name="my_model"}`
+		preemptionsMetric = `vllm:num_preemptions_total{model_name="my_model"}`
+		// 7 tokens, a single block of 8 tokens, the echoed response fills the second block
+		prompt = "Hello world, how are you?"
+	)
+
+	It("should allocate the blocks of the tokens of the requests", func() {
+		memory := newMemoryModel(true, 4, 8)
+		ok, _ := memory.allocate("request-1", 10)
+		Expect(ok).To(BeTrue())
+		Expect(memory.usage()).To(Equal(0.5))
+		// the blocks of the held tokens are not allocated again
+		ok, _ = memory.allocate("request-1", 16)
+		Expect(ok).To(BeTrue())
+		Expect(memory.usage()).To(Equal(0.5))
+
+		ok, _ = memory.allocate("request-2", 17)
+		Expect(ok).To(BeFalse())
+		ok, released := memory.allocate("request-2", 16)
+		Expect(ok).To(BeTrue())
+		Expect(released).To(BeNil())
+		Expect(memory.usage()).To(Equal(1.0))
+
+		ok, released = memory.allocate("request-3", 1)
+		Expect(ok).To(BeFalse())
+		memory.free("request-1")
+		Expect(released).To(BeClosed())
+		Expect(memory.usage()).To(Equal(0.5))
+
+		// a request that holds all the used blocks gets blocks beyond the pool
+		memory.free("request-2")
+		ok, _ = memory.allocate("request-3", 100)
+		Expect(ok).To(BeTrue())
+		Expect(memory.usage()).To(Equal(1.0))
+	})
+
+	It("should give the released blocks to the preempted requests first", func() {
+		memory := newMemoryModel(true, 4, 8)
+		ok, _ := memory.allocate("request-1", 16)
+		Expect(ok).To(BeTrue())
+		ok, _ = memory.allocate("request-2", 16)
+		Expect(ok).To(BeTrue())
+		ok, _ = memory.allocate("request-2", 17)
+		Expect(ok).To(BeFalse())
+		memory.preempt("request-2")
+		Expect(memory.usage()).To(Equal(0.5))
+
+		// the free blocks are not allocated to a new request while a preempted request waits
+		ok, released := memory.allocate("request-3", 8)
+		Expect(ok).To(BeFalse())
+		ok, _ = memory.allocate("request-2", 17)
+		Expect(ok).To(BeFalse())
+		memory.free("request-1")
+		ok, _ = memory.allocate("request-2", 17)
+		Expect(ok).To(BeTrue())
+		Expect(released).To(BeClosed())
+		ok, _ = memory.allocate("request-3", 8)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should report the occupancy of the memory model as the kv cache usage", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--enable-memory-model",
+			"--kv-cache-size", "4", "--block-size", "8", "--time-to-first-token", "100", "--inter-token-latency", "50"}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		getMetric := func(metric string) float64 {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return getGaugeValue(string(data), metric)
+		}
+
+		// three requests need six blocks at the end of their decode, the pool has four
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, prompt, false)
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				resp, err := openaiclient.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices).To(HaveLen(1))
+				Expect(resp.Choices[0].Text).To(Equal(prompt))
+				Expect(resp.Choices[0].FinishReason).To(Equal(openai.CompletionChoiceFinishReasonStop))
+			}()
+		}
+		Eventually(func() float64 {
+			return getMetric(usageMetric)
+		}, 2*time.Second, 10*time.Millisecond).Should(Equal(1.0))
+		wg.Wait()
+
+		Expect(getMetric(preemptionsMetric)).To(BeNumerically(">", 0))
+		Expect(getMetric(usageMetric)).To(BeZero())
+	})
+
+	It("should keep a request waiting until the kv cache has room for its prompt", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--enable-memory-model",
+			"--kv-cache-size", "1", "--block-size", "8", "--time-to-first-token", "300"}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, prompt, false)
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				_, err := openaiclient.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+		}
+		Eventually(func() string {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metricsResp.StatusCode).To(Equal(http.StatusOK))
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return string(data)
+		}, 2*time.Second, 10*time.Millisecond).Should(And(
+			ContainSubstring(`vllm:num_requests_running{model_name="my_model"} 1`),
+			ContainSubstring(`vllm:num_requests_waiting{model_name="my_model"} 1`),
+			ContainSubstring(usageMetric+" 1")))
+		wg.Wait()
+	})
+})
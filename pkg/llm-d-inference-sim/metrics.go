@@ -20,26 +20,255 @@ package llmdinferencesim
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 )
 
+const (
+	// workerUtilizationUpdateInterval is the interval between updates of the workers utilization metrics
+	workerUtilizationUpdateInterval = time.Second
+	// workerUtilizationWindowSize is the number of update intervals the workers utilization is computed over
+	workerUtilizationWindowSize = 10
+)
+
+// requestQueueTimeBuckets are the buckets of the request queue time, the request phases times and the e2e
+// request latency histograms, in seconds, same as in vLLM
+var requestQueueTimeBuckets = []float64{0.3, 0.5, 0.8, 1.0, 1.5, 2.0, 2.5, 5.0, 10.0, 15.0, 20.0, 30.0,
+	40.0, 50.0, 60.0, 120.0, 240.0, 480.0, 960.0, 1920.0, 7680.0}
+
+// timeToFirstTokenBuckets are the buckets of the time to first token histogram, in seconds, same as in vLLM
+var timeToFirstTokenBuckets = []float64{0.001, 0.005, 0.01, 0.02, 0.04, 0.06, 0.08, 0.1, 0.25, 0.5, 0.75,
+	1.0, 2.5, 5.0, 7.5, 10.0, 20.0, 40.0, 80.0, 160.0, 640.0, 2560.0}
+
+// timePerOutputTokenBuckets are the buckets of the time per output token histogram, in seconds, same as in vLLM
+var timePerOutputTokenBuckets = []float64{0.01, 0.025, 0.05, 0.075, 0.1, 0.15, 0.2, 0.3, 0.4, 0.5, 0.75,
+	1.0, 2.5, 5.0, 7.5, 10.0, 20.0, 40.0, 80.0}
+
+// iterationTokensBuckets are the buckets of the iteration tokens histogram, same as in vLLM
+var iterationTokensBuckets = []float64{1, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384}
+
+// build125Buckets returns the buckets 1, 2, 5, 10, 20, 50, ... up to the given maximal value,
+// same as the buckets of the token histograms in vLLM
+func build125Buckets(maxValue int) []float64 {
+	buckets := make([]float64, 0)
+	for exponent := 1.0; ; exponent *= 10 {
+		for _, mantissa := range []float64{1, 2, 5} {
+			value := mantissa * exponent
+			if value > float64(maxValue) {
+				return buckets
+			}
+			buckets = append(buckets, value)
+		}
+	}
+}
+
+// metricLabels defines the labels identifying the model in a metric
+type metricLabels struct {
+	// modelLabels are the label keys whose value is the model name
+	modelLabels []string
+	// constLabels are labels with constant values
+	constLabels prometheus.Labels
+}
+
+// predefinedLabelSchemas contains the labels of the model metrics in the predefined schemas,
+// all model metrics have the same labels
+var predefinedLabelSchemas = map[string]metricLabels{
+	common.MetricsLabelSchemaV0: {
+		modelLabels: []string{vllmapi.PromLabelModelName},
+	},
+	common.MetricsLabelSchemaV1: {
+		modelLabels: []string{vllmapi.PromLabelModelName},
+		constLabels: prometheus.Labels{vllmapi.PromLabelEngine: "0"},
+	},
+}
+
+// modelGaugeDefinition defines a gauge labeled by the model
+type modelGaugeDefinition struct {
+	gauge **prometheus.GaugeVec
+	name  string
+	help  string
+	// description is used in the registration failure log message
+	description string
+}
+
+// modelGauges returns the definitions of the gauges labeled by the model
+func (s *VllmSimulator) modelGauges() []modelGaugeDefinition {
+	return []modelGaugeDefinition{
+		{
+			gauge:       &s.runningRequests,
+			name:        vllmapi.VllmNumRequestsRunning,
+			help:        "Number of requests currently running on GPU.",
+			description: "number of running requests gauge",
+		},
+		{
+			// not supported for now, reports constant value
+			gauge:       &s.waitingRequests,
+			name:        vllmapi.VllmNumRequestsWaiting,
+			help:        "Prometheus metric for the number of queued requests.",
+			description: "number of requests in queue gauge",
+		},
+		{
+			// not supported for now, reports constant value
+			gauge:       &s.kvCacheUsagePercentage,
+			name:        vllmapi.VllmGPUCacheUsagePerc,
+			help:        "Prometheus metric for the fraction of KV-cache blocks currently in use (from 0 to 1).",
+			description: "kv cache usage percentage gauge",
+		},
+	}
+}
+
+// modelHistogramDefinition defines a histogram labeled by the model
+type modelHistogramDefinition struct {
+	histogram **prometheus.HistogramVec
+	name      string
+	help      string
+	buckets   []float64
+	// description is used in the registration failure log message
+	description string
+}
+
+// modelHistograms returns the definitions of the histograms labeled by the model
+func (s *VllmSimulator) modelHistograms() []modelHistogramDefinition {
+	return []modelHistogramDefinition{
+		{
+			histogram:   &s.requestQueueTime,
+			name:        vllmapi.VllmRequestQueueTime,
+			help:        "Histogram of time spent in WAITING phase for request.",
+			buckets:     requestQueueTimeBuckets,
+			description: "request queue time histogram",
+		},
+		{
+			histogram:   &s.e2eRequestLatency,
+			name:        vllmapi.VllmE2ERequestLatency,
+			help:        "Histogram of e2e request latency in seconds.",
+			buckets:     requestQueueTimeBuckets,
+			description: "e2e request latency histogram",
+		},
+		{
+			histogram:   &s.requestPrefillTime,
+			name:        vllmapi.VllmRequestPrefillTime,
+			help:        "Histogram of time spent in PREFILL phase for request.",
+			buckets:     requestQueueTimeBuckets,
+			description: "request prefill time histogram",
+		},
+		{
+			histogram:   &s.requestDecodeTime,
+			name:        vllmapi.VllmRequestDecodeTime,
+			help:        "Histogram of time spent in DECODE phase for request.",
+			buckets:     requestQueueTimeBuckets,
+			description: "request decode time histogram",
+		},
+		{
+			histogram:   &s.requestInferenceTime,
+			name:        vllmapi.VllmRequestInferenceTime,
+			help:        "Histogram of time spent in RUNNING phase for request.",
+			buckets:     requestQueueTimeBuckets,
+			description: "request inference time histogram",
+		},
+		{
+			histogram:   &s.timeToFirstToken,
+			name:        vllmapi.VllmTimeToFirstToken,
+			help:        "Histogram of time to first token in seconds.",
+			buckets:     timeToFirstTokenBuckets,
+			description: "time to first token histogram",
+		},
+		{
+			histogram:   &s.timePerOutputToken,
+			name:        vllmapi.VllmTimePerOutputToken,
+			help:        "Histogram of time per output token in seconds.",
+			buckets:     timePerOutputTokenBuckets,
+			description: "time per output token histogram",
+		},
+		{
+			histogram:   &s.requestPromptTokens,
+			name:        vllmapi.VllmRequestPromptTokens,
+			help:        "Number of prefill tokens processed.",
+			buckets:     build125Buckets(s.config.GetLargestMaxModelLen()),
+			description: "request prompt tokens histogram",
+		},
+		{
+			histogram:   &s.requestGenerationTokens,
+			name:        vllmapi.VllmRequestGenerationTokens,
+			help:        "Number of generation tokens processed.",
+			buckets:     build125Buckets(s.config.GetLargestMaxModelLen()),
+			description: "request generation tokens histogram",
+		},
+		{
+			histogram:   &s.requestParamsMaxTokens,
+			name:        vllmapi.VllmRequestParamsMaxTokens,
+			help:        "Histogram of the max_tokens request parameter.",
+			buckets:     build125Buckets(s.config.GetLargestMaxModelLen()),
+			description: "request max tokens histogram",
+		},
+		{
+			histogram:   &s.iterationTokens,
+			name:        vllmapi.VllmIterationTokens,
+			help:        "Histogram of number of tokens per engine_step.",
+			buckets:     iterationTokensBuckets,
+			description: "iteration tokens histogram",
+		},
+	}
+}
+
+// metricLabelsFor returns the model labels of the given metric according to the configured schema
+func (s *VllmSimulator) metricLabelsFor(metric string) metricLabels {
+	if s.config.MetricsLabelSchema != common.MetricsLabelSchemaCustom {
+		if labels, ok := predefinedLabelSchemas[s.config.MetricsLabelSchema]; ok {
+			return labels
+		}
+		return predefinedLabelSchemas[common.MetricsLabelSchemaV0]
+	}
+
+	customLabels, ok := s.config.MetricsCustomLabels[metric]
+	if !ok {
+		// metrics that are not in the custom schema keep the default labels
+		return predefinedLabelSchemas[common.MetricsLabelSchemaV0]
+	}
+	labels := metricLabels{modelLabels: make([]string, 0), constLabels: prometheus.Labels{}}
+	for _, label := range customLabels {
+		if key, value, isConst := strings.Cut(label, "="); isConst {
+			labels.constLabels[key] = value
+		} else {
+			labels.modelLabels = append(labels.modelLabels, key)
+		}
+	}
+	return labels
+}
+
+// modelLabelValues returns the label values of the given model metric for the given model
+func (s *VllmSimulator) modelLabelValues(metric string, model string) prometheus.Labels {
+	values := prometheus.Labels{}
+	for _, label := range s.metricsModelLabels[metric] {
+		values[label] = model
+	}
+	return values
+}
+
 // createAndRegisterPrometheus creates and registers prometheus metrics used by vLLM simulator
 // Metrics reported:
 // - lora_requests_info
 func (s *VllmSimulator) createAndRegisterPrometheus() error {
+	// the registry is read by the aggregated metrics of rank 0 while the other ranks start
+	s.metricsMutex.Lock()
 	s.registry = prometheus.NewRegistry()
+	s.metricsMutex.Unlock()
 
 	s.loraInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: "",
-			Name:      "vllm:lora_requests_info",
+			Name:      vllmapi.VllmLoraRequestInfo,
 			Help:      "Running stats on lora requests.",
 		},
 		[]string{vllmapi.PromLabelMaxLora, vllmapi.PromLabelRunningLoraAdapters, vllmapi.PromLabelWaitingLoraAdapters},
@@ -50,50 +279,439 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 		return err
 	}
 
-	s.runningRequests = prometheus.NewGaugeVec(
+	modelGauges := s.modelGauges()
+	if s.config.MetricsLabelSchema == common.MetricsLabelSchemaCustom {
+		for metric := range s.config.MetricsCustomLabels {
+			if !slices.ContainsFunc(modelGauges, func(def modelGaugeDefinition) bool { return def.name == metric }) {
+				return fmt.Errorf("metrics custom labels contain an unsupported metric %s", metric)
+			}
+		}
+	}
+
+	s.metricsModelLabels = make(map[string][]string)
+	for _, def := range modelGauges {
+		labels := s.metricLabelsFor(def.name)
+		s.metricsModelLabels[def.name] = labels.modelLabels
+		*def.gauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem:   "",
+				Name:        def.name,
+				Help:        def.help,
+				ConstLabels: labels.constLabels,
+			},
+			labels.modelLabels,
+		)
+
+		if err := s.registry.Register(*def.gauge); err != nil {
+			s.logger.Error(err, "Prometheus "+def.description+" register failed")
+			return err
+		}
+	}
+
+	for _, def := range s.modelHistograms() {
+		labels := s.metricLabelsFor(def.name)
+		s.metricsModelLabels[def.name] = labels.modelLabels
+		*def.histogram = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem:   "",
+				Name:        def.name,
+				Help:        def.help,
+				Buckets:     def.buckets,
+				ConstLabels: labels.constLabels,
+			},
+			labels.modelLabels,
+		)
+
+		if err := s.registry.Register(*def.histogram); err != nil {
+			s.logger.Error(err, "Prometheus "+def.description+" register failed")
+			return err
+		}
+	}
+
+	s.toolLimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_tool_limit_rejections_total",
+			Help:      "Number of requests rejected because of exceeding a tools limit.",
+		},
+		[]string{vllmapi.PromLabelLimit},
+	)
+
+	if err := s.registry.Register(s.toolLimitRejections); err != nil {
+		s.logger.Error(err, "Prometheus tool limit rejections counter register failed")
+		return err
+	}
+
+	s.injectedFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_injected_failures_total",
+			Help:      "Number of injected failures.",
+		},
+		[]string{vllmapi.PromLabelFailureType},
+	)
+
+	if err := s.registry.Register(s.injectedFailures); err != nil {
+		s.logger.Error(err, "Prometheus injected failures counter register failed")
+		return err
+	}
+
+	s.retentionOverrides = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_retention_overrides_total",
+			Help:      "Number of retained KV cache blocks evicted because of capacity pressure.",
+		},
+		func() float64 {
+			if s.kvcacheHelper == nil {
+				return 0
+			}
+			return float64(s.kvcacheHelper.GetRetentionOverrides())
+		},
+	)
+
+	if err := s.registry.Register(s.retentionOverrides); err != nil {
+		s.logger.Error(err, "Prometheus retention overrides counter register failed")
+		return err
+	}
+
+	s.tokenizerErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_tokenizer_errors_total",
+			Help:      "Number of requests that failed because the tokenization failed and there is no fallback.",
+		},
+	)
+
+	if err := s.registry.Register(s.tokenizerErrors); err != nil {
+		s.logger.Error(err, "Prometheus tokenizer errors counter register failed")
+		return err
+	}
+
+	s.tokenizerFallbacks = prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_tokenizer_fallbacks_total",
+			Help:      "Number of requests processed without the KV cache because the tokenization failed.",
+		},
+		func() float64 {
+			if s.kvcacheHelper == nil {
+				return 0
+			}
+			return float64(s.kvcacheHelper.GetTokenizationFallbacks())
+		},
+	)
+
+	if err := s.registry.Register(s.tokenizerFallbacks); err != nil {
+		s.logger.Error(err, "Prometheus tokenizer fallbacks counter register failed")
+		return err
+	}
+
+	s.workerBusyRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "",
+			Name:      "sim_worker_busy_ratio",
+			Help:      "Fraction of time the request processing worker was busy in the utilization window.",
+		},
+		[]string{vllmapi.PromLabelWorkerID},
+	)
+
+	if err := s.registry.Register(s.workerBusyRatio); err != nil {
+		s.logger.Error(err, "Prometheus worker busy ratio gauge register failed")
+		return err
+	}
+
+	s.workersBusy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "",
+			Name:      "sim_workers_busy",
+			Help:      "Average number of busy request processing workers in the utilization window.",
+		},
+	)
+
+	if err := s.registry.Register(s.workersBusy); err != nil {
+		s.logger.Error(err, "Prometheus busy workers gauge register failed")
+		return err
+	}
+
+	s.queueWait = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Subsystem:  "",
+			Name:       "sim_queue_wait_seconds",
+			Help:       "Time requests spent in the waiting queue in the queue wait window, in seconds.",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			MaxAge:     queueWaitUpdateInterval * queueWaitWindowSize,
+		},
+		[]string{vllmapi.PromLabelModel},
+	)
+
+	if err := s.registry.Register(s.queueWait); err != nil {
+		s.logger.Error(err, "Prometheus queue wait summary register failed")
+		return err
+	}
+
+	s.starvationDetected = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Subsystem: "",
-			Name:      "vllm:num_requests_running",
-			Help:      "Number of requests currently running on GPU.",
+			Name:      "sim_starvation_detected",
+			Help:      "1 while the average queue wait of the model exceeds the starvation threshold, 0 otherwise.",
 		},
-		[]string{vllmapi.PromLabelModelName},
+		[]string{vllmapi.PromLabelModel},
 	)
 
-	if err := s.registry.Register(s.runningRequests); err != nil {
-		s.logger.Error(err, "Prometheus number of running requests gauge register failed")
+	if err := s.registry.Register(s.starvationDetected); err != nil {
+		s.logger.Error(err, "Prometheus starvation detected gauge register failed")
 		return err
 	}
 
-	// not supported for now, reports constant value
-	s.waitingRequests = prometheus.NewGaugeVec(
+	s.queueOvertakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_queue_overtakes_total",
+			Help:      "Number of times a waiting request was overtaken by a later request with a higher priority, labeled by the model of the overtaking request.",
+		},
+		[]string{vllmapi.PromLabelModel},
+	)
+
+	if err := s.registry.Register(s.queueOvertakes); err != nil {
+		s.logger.Error(err, "Prometheus queue overtakes counter register failed")
+		return err
+	}
+
+	s.activePrefillsGauge = prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
 			Subsystem: "",
-			Name:      "vllm:num_requests_waiting",
-			Help:      "Prometheus metric for the number of queued requests.",
+			Name:      "sim_active_prefills",
+			Help:      "Number of requests in the prefill phase.",
+		},
+		func() float64 {
+			return float64(s.activePrefills.Load())
 		},
-		[]string{vllmapi.PromLabelModelName},
 	)
 
-	if err := s.registry.Register(s.waitingRequests); err != nil {
-		s.logger.Error(err, "Prometheus number of requests in queue gauge register failed")
+	if err := s.registry.Register(s.activePrefillsGauge); err != nil {
+		s.logger.Error(err, "Prometheus active prefills gauge register failed")
 		return err
 	}
 
-	// not supported for now, reports constant value
-	s.kvCacheUsagePercentage = prometheus.NewGaugeVec(
+	s.modelLoadProgressGauge = prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
 			Subsystem: "",
-			Name:      "vllm:gpu_cache_usage_perc",
-			Help:      "Prometheus metric for the fraction of KV-cache blocks currently in use (from 0 to 1).",
+			Name:      "sim_model_load_progress",
+			Help:      "Progress of the model load after the simulator start, between 0 and 1.",
+		},
+		s.getModelLoadProgress,
+	)
+
+	if err := s.registry.Register(s.modelLoadProgressGauge); err != nil {
+		s.logger.Error(err, "Prometheus model load progress gauge register failed")
+		return err
+	}
+
+	s.prefillQueueWait = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: "",
+			Name:      "sim_prefill_queue_wait_seconds",
+			Help:      "Histogram of the time requests waited for a prefill slot, in seconds.",
+			Buckets:   requestQueueTimeBuckets,
+		},
+	)
+
+	if err := s.registry.Register(s.prefillQueueWait); err != nil {
+		s.logger.Error(err, "Prometheus prefill queue wait histogram register failed")
+		return err
+	}
+
+	s.streamDurationTruncations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_max_stream_duration_truncations_total",
+			Help:      "Number of responses cut at the maximal stream duration.",
+		},
+	)
+
+	if err := s.registry.Register(s.streamDurationTruncations); err != nil {
+		s.logger.Error(err, "Prometheus max stream duration truncations counter register failed")
+		return err
+	}
+
+	abortedLabels := s.metricLabelsFor(vllmapi.VllmRequestAborted)
+	s.metricsModelLabels[vllmapi.VllmRequestAborted] = abortedLabels.modelLabels
+	s.requestAborted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem:   "",
+			Name:        vllmapi.VllmRequestAborted,
+			Help:        "Number of requests aborted because their clients disconnected.",
+			ConstLabels: abortedLabels.constLabels,
+		},
+		abortedLabels.modelLabels,
+	)
+
+	if err := s.registry.Register(s.requestAborted); err != nil {
+		s.logger.Error(err, "Prometheus request aborted counter register failed")
+		return err
+	}
+
+	successLabels := s.metricLabelsFor(vllmapi.VllmRequestSuccess)
+	s.metricsModelLabels[vllmapi.VllmRequestSuccess] = successLabels.modelLabels
+	s.requestSuccess = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem:   "",
+			Name:        vllmapi.VllmRequestSuccess,
+			Help:        "Count of successfully processed requests.",
+			ConstLabels: successLabels.constLabels,
+		},
+		append(slices.Clone(successLabels.modelLabels), vllmapi.PromLabelFinishedReason),
+	)
+
+	if err := s.registry.Register(s.requestSuccess); err != nil {
+		s.logger.Error(err, "Prometheus request success counter register failed")
+		return err
+	}
+
+	preemptionsLabels := s.metricLabelsFor(vllmapi.VllmNumPreemptions)
+	s.metricsModelLabels[vllmapi.VllmNumPreemptions] = preemptionsLabels.modelLabels
+	s.numPreemptions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem:   "",
+			Name:        vllmapi.VllmNumPreemptions,
+			Help:        "Cumulative number of preemption from the engine.",
+			ConstLabels: preemptionsLabels.constLabels,
+		},
+		preemptionsLabels.modelLabels,
+	)
+
+	if err := s.registry.Register(s.numPreemptions); err != nil {
+		s.logger.Error(err, "Prometheus preemptions counter register failed")
+		return err
+	}
+
+	s.requestFailure = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      vllmapi.VllmRequestFailure,
+			Help:      "Count of failed completion requests, including the injected failures.",
+		},
+		[]string{vllmapi.PromLabelErrorType},
+	)
+
+	if err := s.registry.Register(s.requestFailure); err != nil {
+		s.logger.Error(err, "Prometheus request failure counter register failed")
+		return err
+	}
+
+	s.loraAutoUnloads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_lora_auto_unloads_total",
+			Help:      "Number of idle LoRA adapters unloaded automatically.",
+		},
+	)
+
+	if err := s.registry.Register(s.loraAutoUnloads); err != nil {
+		s.logger.Error(err, "Prometheus LoRA auto unloads counter register failed")
+		return err
+	}
+
+	s.loraAdapterRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "",
+			Name:      "sim_lora_adapter_requests_total",
+			Help:      "Number of LoRA adapter load and unload requests, labeled by the operation and the response status code.",
 		},
-		[]string{vllmapi.PromLabelModelName},
+		[]string{vllmapi.PromLabelOperation, vllmapi.PromLabelStatusCode},
 	)
 
-	if err := s.registry.Register(s.kvCacheUsagePercentage); err != nil {
-		s.logger.Error(err, "Prometheus kv cache usage percentage gauge register failed")
+	if err := s.registry.Register(s.loraAdapterRequests); err != nil {
+		s.logger.Error(err, "Prometheus LoRA adapter requests counter register failed")
 		return err
 	}
 
+	if s.config.ReplayFile != "" {
+		s.replayRequests = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "",
+				Name:      "sim_replay_requests_total",
+				Help:      "Number of replayed requests, labeled by the response status code.",
+			},
+			[]string{vllmapi.PromLabelStatusCode},
+		)
+
+		if err := s.registry.Register(s.replayRequests); err != nil {
+			s.logger.Error(err, "Prometheus replay requests counter register failed")
+			return err
+		}
+
+		s.replayPromptTokens = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "",
+				Name:      "sim_replay_prompt_tokens_total",
+				Help:      "Number of prompt tokens of the replayed requests.",
+			},
+		)
+
+		if err := s.registry.Register(s.replayPromptTokens); err != nil {
+			s.logger.Error(err, "Prometheus replay prompt tokens counter register failed")
+			return err
+		}
+
+		s.replayCompletionTokens = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "",
+				Name:      "sim_replay_completion_tokens_total",
+				Help:      "Number of completion tokens of the replayed requests.",
+			},
+		)
+
+		if err := s.registry.Register(s.replayCompletionTokens); err != nil {
+			s.logger.Error(err, "Prometheus replay completion tokens counter register failed")
+			return err
+		}
+	}
+
+	if s.config.DatasetPath != "" || s.config.DatasetURL != "" {
+		for _, source := range []string{dataset.SourceHash, dataset.SourceLength, dataset.SourceFallback} {
+			datasetResponses := prometheus.NewCounterFunc(
+				prometheus.CounterOpts{
+					Subsystem:   "",
+					Name:        "sim_dataset_responses_total",
+					Help:        "Number of responses generated by the dataset by the source of their tokens.",
+					ConstLabels: prometheus.Labels{vllmapi.PromLabelSource: source},
+				},
+				func() float64 {
+					custDataset, ok := s.dataset.(*dataset.CustomDataset)
+					if !ok {
+						return 0
+					}
+					return float64(custDataset.Stats().Responses[source])
+				},
+			)
+
+			if err := s.registry.Register(datasetResponses); err != nil {
+				s.logger.Error(err, "Prometheus dataset responses counter register failed")
+				return err
+			}
+		}
+	}
+
+	if s.config.ServiceTierMetrics {
+		s.serviceTierRequests = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "",
+				Name:      "sim_service_tier_requests_total",
+				Help:      "Number of chat completion requests processed in each service tier.",
+			},
+			[]string{vllmapi.PromLabelServiceTier},
+		)
+
+		if err := s.registry.Register(s.serviceTierRequests); err != nil {
+			s.logger.Error(err, "Prometheus service tier requests counter register failed")
+			return err
+		}
+	}
+
+	s.workersBusyTime = make([]workerBusyTime, s.config.MaxNumSeqs)
+
 	s.setInitialPrometheusMetrics()
 
 	return nil
@@ -102,19 +720,37 @@ func (s *VllmSimulator) createAndRegisterPrometheus() error {
 // setInitialPrometheusMetrics sends the default values to prometheus or
 // the fake metrics if set
 func (s *VllmSimulator) setInitialPrometheusMetrics() {
+	for id := 1; id <= len(s.workersBusyTime); id++ {
+		s.workerBusyRatio.WithLabelValues(strconv.Itoa(id)).Set(0)
+	}
+	s.workersBusy.Set(0)
+
+	s.starvationDetected.WithLabelValues(s.getDisplayedModelName(s.config.Model)).Set(0)
+	// the requests of the additional models are reported by model, the fake metrics are of the simulator's model
+	for _, base := range s.config.AdditionalModels {
+		s.runningRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsRunning, base.ServedModelNames[0])).Set(0)
+		s.waitingRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsWaiting, base.ServedModelNames[0])).Set(0)
+	}
+	s.setFakeMetrics(s.config.FakeMetrics)
+}
+
+// setFakeMetrics sends the given fake metrics of the requests, kv cache usage and loras to prometheus,
+// or the default values if the fake metrics are not set
+func (s *VllmSimulator) setFakeMetrics(fakeMetrics *common.Metrics) {
 	var nRunningReqs, nWaitingReqs, kvCacheUsage float64
-	if s.config.FakeMetrics != nil {
-		nRunningReqs = float64(s.config.FakeMetrics.RunningRequests)
-		nWaitingReqs = float64(s.config.FakeMetrics.WaitingRequests)
-		kvCacheUsage = float64(s.config.FakeMetrics.KVCacheUsagePercentage)
+	if fakeMetrics != nil {
+		nRunningReqs = float64(fakeMetrics.RunningRequests)
+		nWaitingReqs = float64(fakeMetrics.WaitingRequests)
+		kvCacheUsage = float64(fakeMetrics.KVCacheUsagePercentage)
 	}
+
 	modelName := s.getDisplayedModelName(s.config.Model)
-	s.runningRequests.WithLabelValues(modelName).Set(nRunningReqs)
-	s.waitingRequests.WithLabelValues(modelName).Set(nWaitingReqs)
-	s.kvCacheUsagePercentage.WithLabelValues(modelName).Set(kvCacheUsage)
+	s.runningRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsRunning, modelName)).Set(nRunningReqs)
+	s.waitingRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsWaiting, modelName)).Set(nWaitingReqs)
+	s.kvCacheUsagePercentage.With(s.modelLabelValues(vllmapi.VllmGPUCacheUsagePerc, modelName)).Set(kvCacheUsage)
 
-	if s.config.FakeMetrics != nil && len(s.config.FakeMetrics.LoraMetrics) != 0 {
-		for _, metrics := range s.config.FakeMetrics.LoraMetrics {
+	if fakeMetrics != nil && len(fakeMetrics.LoraMetrics) != 0 {
+		for _, metrics := range fakeMetrics.LoraMetrics {
 			s.loraInfo.WithLabelValues(
 				strconv.Itoa(s.config.MaxLoras),
 				metrics.RunningLoras,
@@ -124,13 +760,147 @@ func (s *VllmSimulator) setInitialPrometheusMetrics() {
 		s.loraInfo.WithLabelValues(
 			strconv.Itoa(s.config.MaxLoras),
 			"",
-			"").Set(float64(time.Now().Unix()))
+			"").Set(float64(s.externalNow().Unix()))
 	}
 }
 
+// reportRequestQueueTime records the time a request of the given model spent in the waiting queue
+func (s *VllmSimulator) reportRequestQueueTime(model string, queueTime time.Duration) {
+	if s.requestQueueTime == nil {
+		// Happens in the tests
+		return
+	}
+	s.requestQueueTime.With(s.modelLabelValues(vllmapi.VllmRequestQueueTime, model)).Observe(queueTime.Seconds())
+}
+
+// reportRequestLatencies reports the e2e latency, the time to first token and the time per output token of
+// the request with the given id, which has just been sent, nOutputTokens is the number of tokens generated
+// for the longest choice of the request
+func (s *VllmSimulator) reportRequestLatencies(requestID string, model string, nOutputTokens int) {
+	if s.e2eRequestLatency == nil {
+		// Happens in the tests
+		return
+	}
+	latencies, ok := s.getRequestLatencies(requestID)
+	if !ok {
+		return
+	}
+	s.e2eRequestLatency.With(s.modelLabelValues(vllmapi.VllmE2ERequestLatency, model)).Observe(latencies.e2e.Seconds())
+	if latencies.ttft == 0 {
+		// no token was generated
+		return
+	}
+	s.timeToFirstToken.With(s.modelLabelValues(vllmapi.VllmTimeToFirstToken, model)).Observe(latencies.ttft.Seconds())
+	s.requestPrefillTime.With(s.modelLabelValues(vllmapi.VllmRequestPrefillTime, model)).
+		Observe(latencies.prefill.Seconds())
+	s.requestDecodeTime.With(s.modelLabelValues(vllmapi.VllmRequestDecodeTime, model)).
+		Observe(latencies.decode.Seconds())
+	s.requestInferenceTime.With(s.modelLabelValues(vllmapi.VllmRequestInferenceTime, model)).
+		Observe(latencies.inference.Seconds())
+	if nOutputTokens > 1 {
+		tpot := (latencies.e2e - latencies.ttft).Seconds() / float64(nOutputTokens-1)
+		s.timePerOutputToken.With(s.modelLabelValues(vllmapi.VllmTimePerOutputToken, model)).Observe(tpot)
+	}
+}
+
+// reportRequestTokens reports the number of prompt tokens, the number of generated tokens and the max tokens
+// of the request with the given id, which has just been sent. The max tokens of a request that doesn't limit
+// its completion tokens are the rest of the context window, as in vLLM
+func (s *VllmSimulator) reportRequestTokens(requestID string, model string, nGenerationTokens int) {
+	if s.requestPromptTokens == nil {
+		// Happens in the tests
+		return
+	}
+	promptTokens, maxTokens, ok := s.getRequestTokens(requestID)
+	if !ok {
+		return
+	}
+	if maxTokens == nil {
+		remaining := int64(max(s.getMaxModelLen(model)-promptTokens, 0))
+		maxTokens = &remaining
+	}
+	s.requestPromptTokens.With(s.modelLabelValues(vllmapi.VllmRequestPromptTokens, model)).Observe(float64(promptTokens))
+	s.requestGenerationTokens.With(s.modelLabelValues(vllmapi.VllmRequestGenerationTokens, model)).
+		Observe(float64(nGenerationTokens))
+	s.requestParamsMaxTokens.With(s.modelLabelValues(vllmapi.VllmRequestParamsMaxTokens, model)).
+		Observe(float64(*maxTokens))
+}
+
+// reportRequestSuccess increments the success counter of the given model once for every finish reason,
+// a finish reason of each choice of the request
+func (s *VllmSimulator) reportRequestSuccess(model string, finishReasons []string) {
+	if s.requestSuccess == nil {
+		// Happens in the tests
+		return
+	}
+	for _, finishReason := range finishReasons {
+		labels := s.modelLabelValues(vllmapi.VllmRequestSuccess, model)
+		labels[vllmapi.PromLabelFinishedReason] = finishReason
+		s.requestSuccess.With(labels).Inc()
+	}
+}
+
+// reportPreemption increments the preemptions counter of the given model
+func (s *VllmSimulator) reportPreemption(model string) {
+	if s.numPreemptions == nil {
+		// Happens in the tests
+		return
+	}
+	s.numPreemptions.With(s.modelLabelValues(vllmapi.VllmNumPreemptions, model)).Inc()
+}
+
+// reportIterationTokens observes the number of tokens of a scheduling step of the given model,
+// steps without tokens are not reported
+func (s *VllmSimulator) reportIterationTokens(model string, nTokens int) {
+	if s.iterationTokens == nil {
+		// Happens in the tests
+		return
+	}
+	if nTokens <= 0 {
+		return
+	}
+	s.iterationTokens.With(s.modelLabelValues(vllmapi.VllmIterationTokens, model)).Observe(float64(nTokens))
+}
+
+// reportRequestFailure increments the failure counter of the given error type
+func (s *VllmSimulator) reportRequestFailure(errorType string) {
+	if s.requestFailure == nil {
+		// Happens in the tests
+		return
+	}
+	s.requestFailure.WithLabelValues(errorType).Inc()
+}
+
+// reportTokenizerError increments the counter of the requests that failed because the tokenization failed
+func (s *VllmSimulator) reportTokenizerError() {
+	if s.tokenizerErrors == nil {
+		// Happens in the tests
+		return
+	}
+	s.tokenizerErrors.Inc()
+}
+
+// reportToolLimitRejection increments the rejections counter of the given tools limit
+func (s *VllmSimulator) reportToolLimitRejection(limit string) {
+	if s.toolLimitRejections == nil {
+		// Happens in the tests
+		return
+	}
+	s.toolLimitRejections.WithLabelValues(limit).Inc()
+}
+
+// reportInjectedFailure increments the injected failures counter of the given failure type
+func (s *VllmSimulator) reportInjectedFailure(failureType string) {
+	if s.injectedFailures == nil {
+		// Happens in the tests
+		return
+	}
+	s.injectedFailures.WithLabelValues(failureType).Inc()
+}
+
 // reportLoras sets information about loaded LoRA adapters
 func (s *VllmSimulator) reportLoras() {
-	if s.config.FakeMetrics != nil {
+	if s.getRuntimeConfig().FakeMetrics != nil {
 		return
 	}
 	if s.loraInfo == nil {
@@ -156,73 +926,175 @@ func (s *VllmSimulator) reportLoras() {
 	s.loraInfo.WithLabelValues(
 		strconv.Itoa(s.config.MaxLoras),
 		strings.Join(runningLoras, ","),
-		strings.Join(waitingLoras, ",")).Set(float64(time.Now().Unix()))
+		strings.Join(waitingLoras, ",")).Set(float64(s.externalNow().Unix()))
 }
 
-// reportRunningRequests sets information about running completion requests
-func (s *VllmSimulator) reportRunningRequests() {
-	if s.config.FakeMetrics != nil {
+// reportRunningRequests sets information about running completion requests of the given base model
+func (s *VllmSimulator) reportRunningRequests(model string) {
+	if s.getRuntimeConfig().FakeMetrics != nil {
 		return
 	}
 	if s.runningRequests != nil {
-		s.runningRequests.WithLabelValues(
-			s.getDisplayedModelName(s.config.Model)).Set(float64(s.nRunningReqs))
+		s.runningRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsRunning,
+			model)).Set(float64(s.nRunningModelReqs[model]))
 	}
 }
 
-// reportWaitingRequests sets information about waiting completion requests
-func (s *VllmSimulator) reportWaitingRequests() {
-	if s.config.FakeMetrics != nil {
+// reportWaitingRequests sets information about waiting completion requests of the given base model
+func (s *VllmSimulator) reportWaitingRequests(model string) {
+	if s.getRuntimeConfig().FakeMetrics != nil {
 		return
 	}
 	if s.waitingRequests != nil {
-		s.waitingRequests.WithLabelValues(
-			s.getDisplayedModelName(s.config.Model)).Set(float64(s.nWaitingReqs))
+		s.waitingRequests.With(s.modelLabelValues(vllmapi.VllmNumRequestsWaiting,
+			model)).Set(float64(s.nWaitingModelReqs[model]))
 	}
 }
 
 // reportKVCacheUsage sets information about kv cache usage
 func (s *VllmSimulator) reportKVCacheUsage(value float64) {
-	if s.config.FakeMetrics != nil {
+	if s.getRuntimeConfig().FakeMetrics != nil {
 		return
 	}
 	if s.kvCacheUsagePercentage != nil {
-		s.kvCacheUsagePercentage.WithLabelValues(
-			s.getDisplayedModelName(s.config.Model)).Set(value)
+		s.kvCacheUsagePercentage.With(s.modelLabelValues(vllmapi.VllmGPUCacheUsagePerc,
+			s.getDisplayedModelName(s.config.Model))).Set(value)
+	}
+}
+
+// metricsGatherer returns the gatherer of the /metrics endpoint, it collects the metrics while holding
+// the metrics mutex, so that a scrape never observes a partially applied update of the running requests,
+// waiting requests, loras and kv cache usage gauges
+func (s *VllmSimulator) metricsGatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		s.metricsMutex.Lock()
+		defer s.metricsMutex.Unlock()
+		if s.registry == nil {
+			// the rank has not started yet
+			return nil, nil
+		}
+		return s.registry.Gather()
+	})
+}
+
+// aggregatedMetricsGatherer returns the gatherer of the /metrics/aggregated endpoint of rank 0, it merges
+// the metrics of all the data parallel ranks, every metric has a rank label with the rank that reported it
+func (s *VllmSimulator) aggregatedMetricsGatherer() prometheus.Gatherer {
+	gatherers := prometheus.Gatherers{rankMetricsGatherer(s.metricsGatherer(), s.dpRank)}
+	for _, rank := range s.dpRanks {
+		gatherers = append(gatherers, rankMetricsGatherer(rank.metricsGatherer(), rank.dpRank))
 	}
+	return gatherers
+}
+
+// rankMetricsGatherer returns a gatherer that adds the rank label to the metrics of the given gatherer
+func rankMetricsGatherer(gatherer prometheus.Gatherer, rank int) prometheus.Gatherer {
+	rankLabel := vllmapi.PromLabelRank
+	rankValue := strconv.Itoa(rank)
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		if err != nil {
+			return nil, err
+		}
+		for _, family := range families {
+			for _, metric := range family.Metric {
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &rankLabel, Value: &rankValue})
+				slices.SortFunc(metric.Label, func(a, b *dto.LabelPair) int {
+					return strings.Compare(a.GetName(), b.GetName())
+				})
+			}
+		}
+		return families, nil
+	})
 }
 
 // startMetricsUpdaters starts the various metrics updaters
 func (s *VllmSimulator) startMetricsUpdaters(ctx context.Context) {
-	go s.waitingRequestsUpdater(ctx)
-	go s.runningRequestsUpdater(ctx)
-	go s.lorasUpdater(ctx)
+	go s.requestTransitionsUpdater(ctx)
 	go s.kvCacheUsageUpdater(ctx)
+	go s.workerUtilizationUpdater(ctx)
+	if s.config.StarvationThreshold > 0 {
+		go s.starvationUpdater(ctx)
+	}
+}
+
+// reportRequestTransition sends a request state transition to the requests metrics updater
+func (s *VllmSimulator) reportRequestTransition(model string, state requestState) {
+	transition := requestTransition{model: s.getDisplayedBaseModelName(model), state: state}
+	if s.isLora(model) {
+		transition.lora = model
+	}
+	s.reqTransitionChan <- transition
 }
 
-// waitingRequestsUpdater updates the waiting requests metric by listening on the relevant channel
-func (s *VllmSimulator) waitingRequestsUpdater(ctx context.Context) {
+// requestTransitionsUpdater updates the waiting and running requests metrics, and the loras metric,
+// by listening on the request transitions channel. Both requests counters are updated from the same
+// message, so their sum changes only when a request enters or leaves the simulator
+func (s *VllmSimulator) requestTransitionsUpdater(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case inc := <-s.waitingReqChan:
-			s.nWaitingReqs += inc
-			s.reportWaitingRequests()
+		case transition := <-s.reqTransitionChan:
+			s.applyRequestTransition(transition)
 		}
 	}
 }
 
-// runningRequestsUpdater updates the running requests metric by listening on the relevant channel
-func (s *VllmSimulator) runningRequestsUpdater(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case inc := <-s.runReqChan:
-			s.nRunningReqs += inc
-			s.reportRunningRequests()
+// applyRequestTransition updates the requests counters and loras usage according to the given transition
+func (s *VllmSimulator) applyRequestTransition(transition requestTransition) {
+	s.metricsMutex.Lock()
+	defer s.metricsMutex.Unlock()
+
+	isLora := transition.lora != ""
+	model := transition.model
+	switch transition.state {
+	case enqueuedRequestState:
+		s.nWaitingReqs++
+		s.nWaitingModelReqs[model]++
+		s.reportWaitingRequests(model)
+		if isLora {
+			s.incrementLoraRefCount(transition.lora, &s.waitingLoras)
+		}
+	case startedRequestState:
+		s.nWaitingReqs--
+		s.nWaitingModelReqs[model]--
+		s.reportWaitingRequests(model)
+		s.nRunningReqs++
+		s.nRunningModelReqs[model]++
+		s.reportRunningRequests(model)
+		if isLora {
+			s.decrementLoraRefCount(transition.lora, &s.waitingLoras)
+			s.incrementLoraRefCount(transition.lora, &s.runningLoras)
+		}
+	case preemptedRequestState:
+		s.nRunningReqs--
+		s.nRunningModelReqs[model]--
+		s.reportRunningRequests(model)
+		s.nWaitingReqs++
+		s.nWaitingModelReqs[model]++
+		s.reportWaitingRequests(model)
+		if isLora {
+			s.decrementLoraRefCount(transition.lora, &s.runningLoras)
+			s.incrementLoraRefCount(transition.lora, &s.waitingLoras)
 		}
+	case finishedRequestState:
+		s.nRunningReqs--
+		s.nRunningModelReqs[model]--
+		s.reportRunningRequests(model)
+		if isLora {
+			s.decrementLoraRefCount(transition.lora, &s.runningLoras)
+		}
+	case abortedRequestState:
+		s.nWaitingReqs--
+		s.nWaitingModelReqs[model]--
+		s.reportWaitingRequests(model)
+		if isLora {
+			s.decrementLoraRefCount(transition.lora, &s.waitingLoras)
+		}
+	}
+	if isLora {
+		s.reportLoras()
 	}
 }
 
@@ -233,29 +1105,9 @@ func (s *VllmSimulator) kvCacheUsageUpdater(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case value := <-s.kvCacheUsageChan:
+			s.metricsMutex.Lock()
 			s.reportKVCacheUsage(value)
-		}
-	}
-}
-
-// lorasUpdater updates the running loras metric by listening on the relevant channel
-// one function updates both waiting and running loras since they a part of the same prometheus gauge
-func (s *VllmSimulator) lorasUpdater(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case loraUpdate := <-s.lorasChan:
-			switch loraUpdate.state {
-			case waitingUsageState:
-				s.incrementLoraRefCount(loraUpdate.name, &s.waitingLoras)
-			case runningUsageState:
-				s.decrementLoraRefCount(loraUpdate.name, &s.waitingLoras)
-				s.incrementLoraRefCount(loraUpdate.name, &s.runningLoras)
-			case doneUsageState:
-				s.decrementLoraRefCount(loraUpdate.name, &s.runningLoras)
-			}
-			s.reportLoras()
+			s.metricsMutex.Unlock()
 		}
 	}
 }
@@ -282,3 +1134,143 @@ func (s *VllmSimulator) decrementLoraRefCount(lora string, theMap *sync.Map) {
 		s.logger.Error(nil, "Zero model reference", "model", lora)
 	}
 }
+
+// workerBusyTime tracks the time a request processing worker is busy processing requests, a worker is
+// busy from the dequeue of a request until its response is sent, the streams of the worker's requests
+// are sent after the worker takes the next request, so a worker may have several active requests.
+// The requests of a worker are started by the worker only, and may finish concurrently
+type workerBusyTime struct {
+	// busyNanos is the cumulative busy time until the last time the worker became idle, in nanoseconds
+	busyNanos atomic.Int64
+	// busySince is the time the worker became busy in Unix nanoseconds, valid while active is positive
+	busySince atomic.Int64
+	// active is the number of the worker's requests whose responses were not sent yet
+	active atomic.Int64
+}
+
+// start marks the start of a request of the worker at the given time
+func (w *workerBusyTime) start(now time.Time) {
+	if w == nil {
+		return
+	}
+	for {
+		active := w.active.Load()
+		if active == 0 {
+			// the worker becomes busy, the start time is set before the request is counted
+			w.busySince.Store(now.UnixNano())
+		}
+		if w.active.CompareAndSwap(active, active+1) {
+			return
+		}
+	}
+}
+
+// finish marks the end of a request of the worker at the given time, the worker is idle from
+// the given time if it has no other active requests
+func (w *workerBusyTime) finish(now time.Time) {
+	if w == nil {
+		return
+	}
+	for {
+		active := w.active.Load()
+		if active == 0 {
+			return
+		}
+		since := w.busySince.Load()
+		if w.active.CompareAndSwap(active, active-1) {
+			if active == 1 {
+				w.busyNanos.Add(now.UnixNano() - since)
+			}
+			return
+		}
+	}
+}
+
+// total returns the cumulative busy time until the given time, including the current requests, in nanoseconds.
+// A total taken while the worker becomes idle may miss the last busy period, the next total includes it
+func (w *workerBusyTime) total(now time.Time) int64 {
+	total := w.busyNanos.Load()
+	if w.active.Load() > 0 {
+		total += now.UnixNano() - w.busySince.Load()
+	}
+	return total
+}
+
+// getWorkerBusyTime returns the busy time tracker of the worker with the given id, nil if there is none
+func (s *VllmSimulator) getWorkerBusyTime(id int) *workerBusyTime {
+	if id < 1 || id > len(s.workersBusyTime) {
+		// Happens in the tests
+		return nil
+	}
+	return &s.workersBusyTime[id-1]
+}
+
+// finishWorkerBusyTime marks the end of the request in the busy time of the worker that processed it
+func (s *VllmSimulator) finishWorkerBusyTime(requestID string) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.RLock()
+	workerID := req.workerID
+	req.mutex.RUnlock()
+	s.getWorkerBusyTime(workerID).finish(time.Now())
+}
+
+// workersBusySnapshot is the cumulative busy time of all the workers at a point in time
+type workersBusySnapshot struct {
+	time time.Time
+	// busyNanos is the cumulative busy time of each worker in nanoseconds, ordered by worker id
+	busyNanos []int64
+}
+
+// takeWorkersBusySnapshot returns the cumulative busy time of all the workers at the given time
+func (s *VllmSimulator) takeWorkersBusySnapshot(now time.Time) workersBusySnapshot {
+	snapshot := workersBusySnapshot{time: now, busyNanos: make([]int64, len(s.workersBusyTime))}
+	for i := range s.workersBusyTime {
+		snapshot.busyNanos[i] = s.workersBusyTime[i].total(now)
+	}
+	return snapshot
+}
+
+// workerUtilizationUpdater periodically updates the workers utilization metrics, computed over
+// a sliding window of the last workerUtilizationWindowSize update intervals
+func (s *VllmSimulator) workerUtilizationUpdater(ctx context.Context) {
+	ticker := time.NewTicker(workerUtilizationUpdateInterval)
+	defer ticker.Stop()
+
+	window := []workersBusySnapshot{s.takeWorkersBusySnapshot(time.Now())}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			window = append(window, s.takeWorkersBusySnapshot(now))
+			if len(window) > workerUtilizationWindowSize+1 {
+				window = window[1:]
+			}
+			s.reportWorkerUtilization(window[0], window[len(window)-1])
+		}
+	}
+}
+
+// reportWorkerUtilization sets the workers utilization metrics according to the busy time between the two snapshots
+func (s *VllmSimulator) reportWorkerUtilization(first workersBusySnapshot, last workersBusySnapshot) {
+	if s.workerBusyRatio == nil {
+		// Happens in the tests
+		return
+	}
+	elapsed := last.time.Sub(first.time).Nanoseconds()
+	if elapsed <= 0 {
+		return
+	}
+	busyWorkers := 0.0
+	for i := range last.busyNanos {
+		ratio := float64(last.busyNanos[i]-first.busyNanos[i]) / float64(elapsed)
+		ratio = min(max(ratio, 0), 1)
+		s.workerBusyRatio.WithLabelValues(strconv.Itoa(i + 1)).Set(ratio)
+		busyWorkers += ratio
+	}
+	s.workersBusy.Set(busyWorkers)
+}
@@ -19,6 +19,7 @@ package llmdinferencesim
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -27,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
@@ -34,6 +36,8 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -161,7 +165,7 @@ var _ = Describe("Simulator metrics", Ordered, func() {
 	It("Should send correct lora metrics for parallel requests with delay", func() {
 		ctx := context.TODO()
 		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
-			"--time-to-first-token", "3000",
+			"--time-to-first-token", "3000", "--max-loras", "2",
 			"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}",
 			"{\"name\":\"lora2\",\"path\":\"/path/to/lora2\"}"}
 
@@ -237,7 +241,7 @@ var _ = Describe("Simulator metrics", Ordered, func() {
 	It("Should send correct lora metrics for parallel requests without delay", func() {
 		ctx := context.TODO()
 		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
-			"--time-to-first-token", "3000",
+			"--time-to-first-token", "3000", "--max-loras", "2",
 			"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}",
 			"{\"name\":\"lora2\",\"path\":\"/path/to/lora2\"}"}
 
@@ -306,6 +310,198 @@ var _ = Describe("Simulator metrics", Ordered, func() {
 		Expect(bothRunningTimestamp <= emptyTimestamp).To(BeTrue())
 	})
 
+	It("Should queue the requests of LoRA adapters beyond max loras", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--time-to-first-token", "500", "--max-loras", "1",
+			"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}",
+			"{\"name\":\"lora2\",\"path\":\"/path/to/lora2\"}"}
+
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(
+			option.WithBaseURL(baseURL),
+			option.WithHTTPClient(client))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		// the request of lora2 is sent while the request of lora1 is running,
+		// the request to the base model is not limited by max loras
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			defer wg.Done()
+			defer GinkgoRecover()
+			_, err := openaiclient.Chat.Completions.New(ctx, paramsLora2)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			defer wg.Done()
+			defer GinkgoRecover()
+			params := paramsLora1
+			params.Model = model
+			_, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		start := time.Now()
+		_, err = openaiclient.Chat.Completions.New(ctx, paramsLora1)
+		Expect(err).NotTo(HaveOccurred())
+
+		wg.Wait()
+		// the request of lora2 waited for the request of lora1
+		Expect(time.Since(start)).To(BeNumerically(">=", 900*time.Millisecond))
+
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metricsResp.StatusCode).To(Equal(http.StatusOK))
+
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		metrics := strings.Split(string(data), "\n")
+
+		// lora2 waits while lora1 is running, the two adapters never run at the same time
+		Expect(isLoraMetricPresent(metrics, lora1Arr, lora2Arr)).To(BeTrue())
+		Expect(isLoraMetricPresent(metrics, lora2Arr, emptyArray)).To(BeTrue())
+		Expect(isLoraMetricPresent(metrics, []string{lora1, lora2}, emptyArray)).To(BeFalse())
+		Expect(getLoraValidTimestamp(metrics, lora1Arr, lora2Arr) <=
+			getLoraValidTimestamp(metrics, lora2Arr, emptyArray)).To(BeTrue())
+	})
+
+	It("Should keep running and waiting requests consistent with in-flight requests", func() {
+		ctx := context.TODO()
+		const maxNumSeqs = 3
+		const nRequests = 30
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--time-to-first-token", "100", "--inter-token-latency", "5",
+			"--max-num-seqs", strconv.Itoa(maxNumSeqs)}
+
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(5)
+
+		var inFlight atomic.Int64
+		inFlight.Store(nRequests)
+		var wg sync.WaitGroup
+		wg.Add(nRequests)
+		for range nRequests {
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+				_, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				inFlight.Add(-1)
+			}()
+		}
+
+		runningRe := regexp.MustCompile(`vllm:num_requests_running{model_name="my_model"} (\d+)`)
+		waitingRe := regexp.MustCompile(`vllm:num_requests_waiting{model_name="my_model"} (\d+)`)
+		getGauges := func() (int64, int64) {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			var values []int64
+			for _, re := range []*regexp.Regexp{runningRe, waitingRe} {
+				match := re.FindStringSubmatch(string(data))
+				Expect(match).To(HaveLen(2))
+				value, err := strconv.ParseInt(match[1], 10, 64)
+				Expect(err).NotTo(HaveOccurred())
+				values = append(values, value)
+			}
+			return values[0], values[1]
+		}
+
+		// wait for all the requests to reach the simulator
+		Eventually(func() int64 {
+			running, waiting := getGauges()
+			return running + waiting
+		}, time.Second, time.Millisecond).Should(BeNumerically("==", nRequests))
+
+		for inFlight.Load() > 0 {
+			before := inFlight.Load()
+			running, waiting := getGauges()
+			after := inFlight.Load()
+
+			Expect(running).To(BeNumerically("<=", maxNumSeqs))
+			Expect(waiting).To(BeNumerically(">=", 0))
+			// a request leaves the gauges before its response reaches the client, and enters them
+			// after the client sent it, so the sum can lag behind only by requests in transit
+			Expect(running + waiting).To(BeNumerically("<=", before))
+			Expect(running + waiting).To(BeNumerically(">=", after-maxNumSeqs-1))
+			time.Sleep(5 * time.Millisecond)
+		}
+		wg.Wait()
+
+		Eventually(func() []int64 {
+			running, waiting := getGauges()
+			return []int64{running, waiting}
+		}).Should(Equal([]int64{0, 0}))
+	})
+
+	It("Should publish the running and waiting requests from consistent snapshots", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		simulator, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		simulator.config = &common.Configuration{Model: model, ServedModelNames: []string{model}, MaxNumSeqs: 5, MaxLoras: 1}
+		Expect(simulator.createAndRegisterPrometheus()).To(Succeed())
+		gatherer := simulator.metricsGatherer()
+
+		getGauges := func() (float64, float64) {
+			families, err := gatherer.Gather()
+			Expect(err).NotTo(HaveOccurred())
+			values := make(map[string]float64)
+			for _, family := range families {
+				for _, metric := range family.GetMetric() {
+					if metric.GetGauge() != nil {
+						values[family.GetName()] = metric.GetGauge().GetValue()
+					}
+				}
+			}
+			return values["vllm:num_requests_running"], values["vllm:num_requests_waiting"]
+		}
+
+		// submitted is increased before a request is enqueued, completed after it finished or was aborted
+		var submitted, completed atomic.Int64
+		var wg sync.WaitGroup
+		for worker := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ctx.Err() == nil; i++ {
+					submitted.Add(1)
+					simulator.applyRequestTransition(requestTransition{model: model, state: enqueuedRequestState})
+					if (worker+i)%5 == 0 {
+						simulator.applyRequestTransition(requestTransition{model: model, state: abortedRequestState})
+					} else {
+						simulator.applyRequestTransition(requestTransition{model: model, state: startedRequestState})
+						simulator.applyRequestTransition(requestTransition{model: model, state: finishedRequestState})
+					}
+					completed.Add(1)
+				}
+			}()
+		}
+
+		for range 2000 {
+			completedBefore := completed.Load()
+			running, waiting := getGauges()
+			submittedAfter := submitted.Load()
+
+			Expect(running).To(BeNumerically(">=", 0))
+			Expect(waiting).To(BeNumerically(">=", 0))
+			Expect(running + waiting).To(BeNumerically("<=", submittedAfter-completedBefore))
+		}
+		cancel()
+		wg.Wait()
+
+		running, waiting := getGauges()
+		Expect([]float64{running, waiting}).To(Equal([]float64{0, 0}))
+	})
+
 	Context("kv cache metrics", func() {
 		tmpDir := "./tests-tmp/"
 		AfterAll(func() {
@@ -484,8 +680,640 @@ var _ = Describe("Simulator metrics", Ordered, func() {
 			Expect(metrics).To(ContainSubstring("vllm:lora_requests_info{max_lora=\"1\",running_lora_adapters=\"lora4,lora3\",waiting_lora_adapters=\"\"} 1.257894569e+09"))
 		})
 	})
+
+	Context("metrics label schema", func() {
+		fakeMetrics := "{\"running-requests\":10,\"waiting-requests\":30,\"kv-cache-usage\":0.4}"
+
+		DescribeTable("Should label the model metrics according to the schema",
+			func(schemaArgs []string, expectedMetrics []string) {
+				ctx := context.TODO()
+				args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom,
+					"--fake-metrics", fakeMetrics}, schemaArgs...)
+
+				client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				resp, err := client.Get(metricsUrl)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				data, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				metrics := string(data)
+				for _, expected := range expectedMetrics {
+					Expect(metrics).To(ContainSubstring(expected))
+				}
+			},
+			func(schemaArgs []string, expectedMetrics []string) string {
+				return fmt.Sprintf("schema args: %v", schemaArgs)
+			},
+			Entry(nil, []string{"--metrics-label-schema", common.MetricsLabelSchemaV0}, []string{
+				"vllm:num_requests_running{model_name=\"my_model\"} 10",
+				"vllm:num_requests_waiting{model_name=\"my_model\"} 30",
+				"vllm:gpu_cache_usage_perc{model_name=\"my_model\"} 0.4",
+			}),
+			Entry(nil, []string{"--metrics-label-schema", common.MetricsLabelSchemaV1}, []string{
+				"vllm:num_requests_running{engine=\"0\",model_name=\"my_model\"} 10",
+				"vllm:num_requests_waiting{engine=\"0\",model_name=\"my_model\"} 30",
+				"vllm:gpu_cache_usage_perc{engine=\"0\",model_name=\"my_model\"} 0.4",
+			}),
+			Entry(nil, []string{"--metrics-label-schema", common.MetricsLabelSchemaCustom, "--metrics-custom-labels",
+				"{\"vllm:num_requests_running\":[\"served_model_name\",\"engine=1\"],\"vllm:gpu_cache_usage_perc\":[]}"}, []string{
+				"vllm:num_requests_running{engine=\"1\",served_model_name=\"my_model\"} 10",
+				"vllm:num_requests_waiting{model_name=\"my_model\"} 30",
+				"vllm:gpu_cache_usage_perc 0.4",
+			}),
+		)
+
+		It("Should update the real metrics under a custom schema", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--metrics-label-schema", common.MetricsLabelSchemaCustom, "--metrics-custom-labels",
+				"{\"vllm:num_requests_running\":[\"served_model_name\"]}"}
+
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring("vllm:num_requests_running{served_model_name=\"my_model\"} 0"))
+			Expect(metrics).NotTo(ContainSubstring("vllm:num_requests_running{model_name"))
+		})
+
+		It("Should fail on an unsupported metric in the custom schema", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--metrics-label-schema", common.MetricsLabelSchemaCustom, "--metrics-custom-labels",
+				"{\"vllm:unknown_metric\":[\"model_name\"]}"}
+
+			_, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("upload bandwidth", func() {
+		It("Should delay the request by the body read time and exclude it from the queue time", func() {
+			ctx := context.TODO()
+			// 1MiB body at 4MiB per second takes 250 milliseconds to read
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--upload-bandwidth-bytes-per-sec", "4194304"}
+
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			// pad the request with an unknown field to reach the body size
+			bodyTemplate := `{"messages": [{"role": "user", "content": "%s"}], "model": "%s", "padding": "%s"}`
+			padding := 1024*1024 - len(fmt.Sprintf(bodyTemplate, userMessage, model, ""))
+			body := fmt.Sprintf(bodyTemplate, userMessage, model, strings.Repeat("x", padding))
+			Expect(body).To(HaveLen(1024 * 1024))
+
+			start := time.Now()
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			elapsed := time.Since(start)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(elapsed).To(BeNumerically(">=", 250*time.Millisecond))
+
+			serverTiming := strings.Join(resp.Header.Values("Server-Timing"), ",")
+			readMatch := regexp.MustCompile(`read;dur=([0-9.]+)`).FindStringSubmatch(serverTiming)
+			Expect(readMatch).To(HaveLen(2))
+			readMs, err := strconv.ParseFloat(readMatch[1], 64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMs).To(BeNumerically("~", 250, 1))
+			queueMatch := regexp.MustCompile(`queue;dur=([0-9.]+)`).FindStringSubmatch(serverTiming)
+			Expect(queueMatch).To(HaveLen(2))
+			queueMs, err := strconv.ParseFloat(queueMatch[1], 64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queueMs).To(BeNumerically("<", 100))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring("vllm:request_queue_time_seconds_count{model_name=\"my_model\"} 1"))
+			Expect(metrics).To(ContainSubstring("vllm:request_queue_time_seconds_bucket{model_name=\"my_model\",le=\"0.3\"} 1"))
+			sumMatch := regexp.MustCompile(`vllm:request_queue_time_seconds_sum\{model_name="my_model"\} ([0-9.e+-]+)`).
+				FindStringSubmatch(metrics)
+			Expect(sumMatch).To(HaveLen(2))
+			queueSeconds, err := strconv.ParseFloat(sumMatch[1], 64)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queueSeconds).To(BeNumerically("<", 0.1))
+		})
+
+		It("Should not report the read time when the upload bandwidth is not set", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			body := fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "%s"}`, userMessage, model)
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+
+			serverTiming := strings.Join(resp.Header.Values("Server-Timing"), ",")
+			Expect(serverTiming).To(ContainSubstring("queue;dur="))
+			Expect(serverTiming).NotTo(ContainSubstring("read;dur="))
+		})
+		It("Should report the queue time with the displayed model name", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--served-model-name", "alias1", "alias2"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			body := fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "alias2"}`, userMessage)
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body.Close()).To(Succeed())
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring("vllm:request_queue_time_seconds_count{model_name=\"alias1\"} 1"))
+			Expect(metrics).NotTo(ContainSubstring("vllm:request_queue_time_seconds_count{model_name=\"alias2\"}"))
+		})
+	})
+
+	Context("worker utilization", func() {
+		DescribeTable("Should report the workers busy ratio according to the offered load",
+			func(clients int, thinkTime time.Duration, expectedBusyWorkers float64, streaming bool) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				// every request keeps a worker busy for 200 milliseconds
+				args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+					"--max-num-seqs", "2", "--time-to-first-token", "200", "--inter-token-latency", "0"}
+
+				client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+				params.MaxTokens = openai.Int(1)
+				for range clients {
+					go func() {
+						defer GinkgoRecover()
+						for ctx.Err() == nil {
+							if streaming {
+								// the worker is busy until the stream ends
+								stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+								for stream.Next() {
+								}
+								_ = stream.Close()
+							} else {
+								_, _ = openaiclient.Chat.Completions.New(ctx, params)
+							}
+							time.Sleep(thinkTime)
+						}
+					}()
+				}
+
+				// let the metrics updater compute the ratio over a few intervals
+				time.Sleep(3*workerUtilizationUpdateInterval + 200*time.Millisecond)
+
+				metricsResp, err := client.Get(metricsUrl)
+				Expect(err).NotTo(HaveOccurred())
+				data, err := io.ReadAll(metricsResp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				metrics := string(data)
+
+				busyWorkers := getGaugeValue(metrics, `sim_workers_busy`)
+				Expect(busyWorkers).To(BeNumerically("~", expectedBusyWorkers, 0.15))
+				totalRatio := 0.0
+				for _, workerID := range []string{"1", "2"} {
+					ratio := getGaugeValue(metrics, `sim_worker_busy_ratio{worker_id="`+workerID+`"}`)
+					Expect(ratio).To(BeNumerically(">=", 0))
+					Expect(ratio).To(BeNumerically("<=", 1))
+					totalRatio += ratio
+				}
+				Expect(totalRatio).To(BeNumerically("~", busyWorkers, 0.001))
+			},
+			func(clients int, thinkTime time.Duration, expectedBusyWorkers float64, streaming bool) string {
+				return fmt.Sprintf("clients: %d, think time: %v, expected busy workers: %.1f, streaming: %t",
+					clients, thinkTime, expectedBusyWorkers, streaming)
+			},
+			// a single client is served half of the time
+			Entry(nil, 1, 200*time.Millisecond, 0.5, false),
+			Entry(nil, 1, 200*time.Millisecond, 0.5, true),
+			// four clients without think time keep both workers busy
+			Entry(nil, 4, time.Duration(0), 2.0, false),
+			Entry(nil, 4, time.Duration(0), 2.0, true),
+		)
+
+		It("Should count the overlapping requests of a worker once", func() {
+			var busyTime workerBusyTime
+			start := time.Now()
+			busyTime.start(start)
+			busyTime.start(start.Add(time.Second))
+			busyTime.finish(start.Add(2 * time.Second))
+			Expect(busyTime.total(start.Add(3 * time.Second))).To(Equal((3 * time.Second).Nanoseconds()))
+			busyTime.finish(start.Add(4 * time.Second))
+			// the worker is idle
+			Expect(busyTime.total(start.Add(10 * time.Second))).To(Equal((4 * time.Second).Nanoseconds()))
+
+			// the requests finish concurrently
+			busyTime.start(start.Add(10 * time.Second))
+			for range 99 {
+				busyTime.start(start.Add(11 * time.Second))
+			}
+			var wg sync.WaitGroup
+			for range 100 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					busyTime.finish(start.Add(12 * time.Second))
+				}()
+			}
+			wg.Wait()
+			busyTime.finish(start.Add(20 * time.Second))
+			Expect(busyTime.total(start.Add(30 * time.Second))).To(Equal((6 * time.Second).Nanoseconds()))
+		})
+	})
+
+	Context("queue starvation", func() {
+		It("Should compute the average queue wait over the window", func() {
+			var tracker queueWaitTracker
+			now := time.Now()
+			tracker.add(lora1, now.Add(-3*time.Second), 10*time.Second)
+			tracker.add(lora1, now.Add(-time.Second), 2*time.Second)
+			tracker.add(model, now, time.Second)
+
+			averages := tracker.averages(now, 2*time.Second, map[string][]time.Duration{
+				lora1: {4 * time.Second},
+				lora2: {time.Second},
+			})
+			// the lora1 sample that is older than the window is ignored
+			Expect(averages).To(Equal(map[string]time.Duration{
+				lora1: 3 * time.Second,
+				lora2: time.Second,
+				model: time.Second,
+			}))
+
+			// the old samples were removed
+			averages = tracker.averages(now.Add(3*time.Second), 2*time.Second, nil)
+			Expect(averages).To(BeEmpty())
+		})
+
+		It("Should detect the starvation of the base model behind a lora burst and its recovery", func() {
+			ctx := context.TODO()
+			// every request keeps the only worker busy for 400 milliseconds
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--max-num-seqs", "1", "--time-to-first-token", "400",
+				"--starvation-threshold", "1s", "--starvation-duration", "500ms",
+				"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient := openai.NewClient(
+				option.WithBaseURL(baseURL),
+				option.WithHTTPClient(client))
+			loraParams := paramsLora1
+			loraParams.MaxTokens = openai.Int(1)
+			_, baseParams := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			baseParams.MaxTokens = openai.Int(1)
+
+			var wg sync.WaitGroup
+			sendRequest := func(params openai.ChatCompletionNewParams) {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}()
+				time.Sleep(10 * time.Millisecond)
+			}
+			// the base model request waits in the queue behind the lora requests for about 2.4 seconds
+			for range 6 {
+				sendRequest(loraParams)
+			}
+			sendRequest(baseParams)
+
+			starvationMetric := `sim_starvation_detected{model="` + model + `"}`
+			getStarvation := func() float64 {
+				metricsResp, err := client.Get(metricsUrl)
+				Expect(err).NotTo(HaveOccurred())
+				data, err := io.ReadAll(metricsResp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				return getGaugeValue(string(data), starvationMetric)
+			}
+			Expect(getStarvation()).To(Equal(0.0))
+			Eventually(getStarvation).WithTimeout(3 * time.Second).WithPolling(100 * time.Millisecond).Should(Equal(1.0))
+
+			wg.Wait()
+			// the starvation clears when the long queue wait leaves the window
+			Eventually(getStarvation).WithTimeout(4 * time.Second).WithPolling(100 * time.Millisecond).Should(Equal(0.0))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring(`sim_queue_wait_seconds_count{model="lora1"} 6`))
+			Expect(metrics).To(ContainSubstring(`sim_queue_wait_seconds_count{model="` + model + `"} 1`))
+		})
+
+		It("Should remove the samples older than the window when a sample is added", func() {
+			var tracker queueWaitTracker
+			start := time.Now()
+			for i := range 1000 {
+				tracker.add(model, start.Add(time.Duration(i)*100*time.Millisecond), time.Second)
+			}
+			// the samples of the last window and the new sample
+			Expect(tracker.size()).To(Equal(int(queueWaitWindow/(100*time.Millisecond)) + 1))
+		})
+
+		It("Should not keep the queue waits without the starvation detection", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			oldArgs := os.Args
+			defer func() {
+				os.Args = oldArgs
+			}()
+			os.Args = []string{"cmd", "--model", model, "--mode", common.ModeEcho}
+			s, err := New(klog.Background())
+			Expect(err).NotTo(HaveOccurred())
+			s.config, err = common.ParseCommandParamsAndLoadConfig()
+			Expect(err).NotTo(HaveOccurred())
+			client, err := startSimulator(ctx, s)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			for range 100 {
+				_, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(s.queueWaits.size()).To(BeZero())
+		})
+	})
+
+	Context("request latency histograms", func() {
+		DescribeTable("Should report the e2e latency, the time to first token and the time per output token",
+			func(streaming bool) {
+				ctx := context.TODO()
+				args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+					"--time-to-first-token", "200", "--inter-token-latency", "50"}
+				client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+				if streaming {
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					for stream.Next() {
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+				} else {
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				labels := `{model_name="` + model + `"}`
+				getMetrics := func() string {
+					metricsResp, err := client.Get(metricsUrl)
+					Expect(err).NotTo(HaveOccurred())
+					data, err := io.ReadAll(metricsResp.Body)
+					Expect(err).NotTo(HaveOccurred())
+					return string(data)
+				}
+				// the latencies of a stream are reported after its last chunk is sent
+				Eventually(getMetrics).WithTimeout(time.Second).WithPolling(50 * time.Millisecond).
+					Should(ContainSubstring("vllm:e2e_request_latency_seconds_count" + labels + " 1"))
+				metrics := getMetrics()
+				Expect(metrics).To(ContainSubstring("vllm:time_to_first_token_seconds_count" + labels + " 1"))
+				Expect(metrics).To(ContainSubstring("vllm:time_per_output_token_seconds_count" + labels + " 1"))
+
+				nTokens := len(common.Tokenize(userMessage))
+				e2e := getGaugeValue(metrics, "vllm:e2e_request_latency_seconds_sum"+labels)
+				ttft := getGaugeValue(metrics, "vllm:time_to_first_token_seconds_sum"+labels)
+				tpot := getGaugeValue(metrics, "vllm:time_per_output_token_seconds_sum"+labels)
+				Expect(ttft).To(BeNumerically(">=", 0.2))
+				Expect(ttft).To(BeNumerically("<", 0.3))
+				Expect(tpot).To(BeNumerically(">=", 0.05))
+				Expect(tpot).To(BeNumerically("<", 0.07))
+				Expect(e2e).To(BeNumerically(">=", 0.2+0.05*float64(nTokens-1)))
+				Expect(e2e).To(BeNumerically("~", ttft+tpot*float64(nTokens-1), 0.001))
+
+				// the request didn't wait, its prefill started on arrival
+				prefill := getGaugeValue(metrics, "vllm:request_prefill_time_seconds_sum"+labels)
+				decode := getGaugeValue(metrics, "vllm:request_decode_time_seconds_sum"+labels)
+				inference := getGaugeValue(metrics, "vllm:request_inference_time_seconds_sum"+labels)
+				Expect(prefill).To(BeNumerically(">=", 0.2))
+				Expect(prefill).To(BeNumerically("<=", ttft))
+				Expect(decode).To(BeNumerically(">=", 0.05*float64(nTokens-1)))
+				Expect(inference).To(BeNumerically("~", prefill+decode, 0.001))
+				Expect(inference).To(BeNumerically("<=", e2e))
+			},
+			func(streaming bool) string {
+				return fmt.Sprintf("streaming: %t", streaming)
+			},
+			Entry(nil, false),
+			Entry(nil, true),
+		)
+
+		It("Should report the simulated latencies when the clock is accelerated", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+				"--time-to-first-token", "2000", "--inter-token-latency", "500", "--time-scale", "10"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			start := time.Now()
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			nTokens := len(common.Tokenize(userMessage))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Duration(2000+500*(nTokens-1))*time.Millisecond/5))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			labels := `{model_name="` + model + `"}`
+			ttft := getGaugeValue(string(data), "vllm:time_to_first_token_seconds_sum"+labels)
+			tpot := getGaugeValue(string(data), "vllm:time_per_output_token_seconds_sum"+labels)
+			Expect(ttft).To(BeNumerically(">=", 2))
+			Expect(ttft).To(BeNumerically("<", 3))
+			Expect(tpot).To(BeNumerically(">=", 0.5))
+			Expect(tpot).To(BeNumerically("<", 0.7))
+		})
+	})
+
+	Context("request token histograms", func() {
+		DescribeTable("Should report the prompt tokens, the generation tokens and the max tokens",
+			func(streaming bool, maxTokens int64) {
+				ctx := context.TODO()
+				args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--max-model-len", "100"}
+				client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+				// without max tokens, the max tokens are the rest of the context window
+				expectedMaxTokens := 100 - userMsgTokens
+				if maxTokens != 0 {
+					params.MaxTokens = param.NewOpt(maxTokens)
+					expectedMaxTokens = maxTokens
+				}
+				if streaming {
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					for stream.Next() {
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+				} else {
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}
+
+				labels := `{model_name="` + model + `"}`
+				getMetrics := func() string {
+					metricsResp, err := client.Get(metricsUrl)
+					Expect(err).NotTo(HaveOccurred())
+					data, err := io.ReadAll(metricsResp.Body)
+					Expect(err).NotTo(HaveOccurred())
+					return string(data)
+				}
+				// the tokens of a stream are reported after its last chunk is sent
+				Eventually(getMetrics).WithTimeout(time.Second).WithPolling(50 * time.Millisecond).
+					Should(ContainSubstring("vllm:request_prompt_tokens_count" + labels + " 1"))
+				metrics := getMetrics()
+				Expect(metrics).To(ContainSubstring("vllm:request_generation_tokens_count" + labels + " 1"))
+				Expect(metrics).To(ContainSubstring("vllm:request_params_max_tokens_count" + labels + " 1"))
+
+				// the response echoes the prompt
+				Expect(getGaugeValue(metrics, "vllm:request_prompt_tokens_sum"+labels)).To(Equal(float64(userMsgTokens)))
+				Expect(getGaugeValue(metrics, "vllm:request_generation_tokens_sum"+labels)).
+					To(Equal(float64(userMsgTokens)))
+				Expect(getGaugeValue(metrics, "vllm:request_params_max_tokens_sum"+labels)).
+					To(Equal(float64(expectedMaxTokens)))
+				// the buckets are 1, 2, 5, ... up to the context window
+				bucket := `vllm:request_prompt_tokens_bucket{model_name="` + model + `",le="%s"}`
+				Expect(metrics).To(ContainSubstring(fmt.Sprintf(bucket, "50") + " 1"))
+				Expect(metrics).To(ContainSubstring(fmt.Sprintf(bucket, "100") + " 1"))
+				Expect(metrics).NotTo(ContainSubstring(fmt.Sprintf(bucket, "200")))
+			},
+			func(streaming bool, maxTokens int64) string {
+				return fmt.Sprintf("streaming: %t, max tokens: %d", streaming, maxTokens)
+			},
+			Entry(nil, false, int64(0)),
+			Entry(nil, true, int64(0)),
+			Entry(nil, false, int64(50)),
+			Entry(nil, true, int64(50)),
+		)
+	})
+
+	Context("iteration tokens", func() {
+		It("Should observe the prefill and every decode step of a request", func() {
+			ctx := context.TODO()
+			// the time to first token lets the running request be counted before it decodes
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--time-to-first-token", "50"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.MaxTokens = param.NewOpt(int64(3))
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			// the prefill of the prompt and two decode steps of a single decoding request
+			labels := `{model_name="` + model + `"}`
+			Expect(metrics).To(ContainSubstring("vllm:iteration_tokens_total_count" + labels + " 3"))
+			Expect(getGaugeValue(metrics, "vllm:iteration_tokens_total_sum"+labels)).
+				To(Equal(float64(userMsgTokens + 2)))
+		})
+	})
+
+	Context("request success and failure counters", func() {
+		It("Should count the finished requests by finish reason and the failed requests by error type", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--allow-injection-headers",
+				"--max-model-len", "100"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+			for stream.Next() {
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(stream.Close()).To(Succeed())
+			params.MaxTokens = param.NewOpt(int64(2))
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			// an injected failure and an invalid request
+			_, err = openaiclient.Chat.Completions.New(ctx, params,
+				option.WithHeader(injectFailureHeader, common.FailureTypeRateLimit), option.WithMaxRetries(0))
+			Expect(err).To(HaveOccurred())
+			params.MaxTokens = param.NewOpt(int64(1000))
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+
+			getMetrics := func() string {
+				metricsResp, err := client.Get(metricsUrl)
+				Expect(err).NotTo(HaveOccurred())
+				data, err := io.ReadAll(metricsResp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				return string(data)
+			}
+			// the success of a stream is reported after its last chunk is sent
+			Eventually(getMetrics).WithTimeout(time.Second).WithPolling(50 * time.Millisecond).Should(ContainSubstring(
+				`vllm:request_success_total{finished_reason="stop",model_name="` + model + `"} 2`))
+			metrics := getMetrics()
+			Expect(metrics).To(ContainSubstring(
+				`vllm:request_success_total{finished_reason="length",model_name="` + model + `"} 1`))
+			Expect(metrics).To(ContainSubstring(`vllm:request_failure_total{error_type="RateLimitError"} 1`))
+			Expect(metrics).To(ContainSubstring(`vllm:request_failure_total{error_type="BadRequestError"} 1`))
+		})
+
+		It("Should count the streams cut by an injected failure as failed", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--failure-injection-rate", "100",
+				"--failure-types", common.FailureTypeStreamError}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := sendRawStreamingRequest(client, "chat/completions", fmt.Sprintf(chatStreamBody, ""))
+			Expect(events).NotTo(ContainElement(doneEvent))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring(`vllm:request_failure_total{error_type="InternalServerError"} 1`))
+			Expect(metrics).NotTo(ContainSubstring(`vllm:request_success_total{`))
+			Expect(metrics).NotTo(ContainSubstring(`vllm:request_aborted_total{`))
+		})
+	})
 })
 
+// getGaugeValue returns the value of the metric with the given name and labels
+func getGaugeValue(metrics string, metric string) float64 {
+	match := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(metric) + ` ([0-9.e+-]+)$`).FindStringSubmatch(metrics)
+	Expect(match).To(HaveLen(2))
+	value, err := strconv.ParseFloat(match[1], 64)
+	Expect(err).NotTo(HaveOccurred())
+	return value
+}
+
 // isLoraMetricPresent checks if a matching metric exists
 // metrics: the list of metrics
 // running: list of loras in running_lora_adapters, the order does not matter
@@ -563,3 +1391,57 @@ func splitString(str string) []string {
 	}
 	return strings.Split(str, ",")
 }
+
+var _ = Describe("Aggregated metrics of data parallel ranks", func() {
+	getMetrics := func(client *http.Client, url string) (int, string) {
+		resp, err := client.Get(url)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		return resp.StatusCode, string(data)
+	}
+
+	It("should merge the metrics of all the ranks with a rank label", func() {
+		ctx := context.TODO()
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--data-parallel-size", "2"}
+		config, err := common.ParseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config = config
+		ranks, err := s.createDataParallelRanks()
+		Expect(err).NotTo(HaveOccurred())
+		rank0Client, err := startSimulator(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+		rank1Client, err := startSimulator(ctx, ranks[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(rank1Client, model, userMessage, false)
+		params.MaxTokens = param.NewOpt(int64(1))
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		status, metrics := getMetrics(rank0Client, metricsUrl+"/aggregated")
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="` + model + `",rank="0"} 0`))
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="` + model + `",rank="1"} 0`))
+		Expect(metrics).To(ContainSubstring(
+			`vllm:request_success_total{finished_reason="length",model_name="` + model + `",rank="1"} 1`))
+		Expect(metrics).NotTo(MatchRegexp(`vllm:request_success_total\{.*rank="0"\}`))
+		Expect(strings.Count(metrics, "# TYPE vllm:num_requests_running ")).To(Equal(1))
+
+		// the metrics of a rank are not changed
+		_, metrics = getMetrics(rank0Client, metricsUrl)
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="` + model + `"} 0`))
+		Expect(metrics).NotTo(ContainSubstring(`rank="`))
+
+		status, _ = getMetrics(rank1Client, metricsUrl+"/aggregated")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,43 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"time"
+)
+
+// modelLoadingMessage is the error message of the requests received while the model is loading
+const modelLoadingMessage = "The model is loading, please try again later."
+
+// isModelLoading returns true during the startup delay and the model load time after the simulator start
+func (s *VllmSimulator) isModelLoading() bool {
+	return time.Since(s.startTime) < s.config.StartupDelay+s.config.ModelLoadTime
+}
+
+// getModelLoadProgress returns the progress of the model load, between 0 and 1, the progress is 0
+// during the startup delay and grows linearly during the model load time
+func (s *VllmSimulator) getModelLoadProgress() float64 {
+	elapsed := time.Since(s.startTime) - s.config.StartupDelay
+	switch {
+	case elapsed < 0:
+		return 0
+	case elapsed >= s.config.ModelLoadTime:
+		return 1
+	default:
+		return float64(elapsed) / float64(s.config.ModelLoadTime)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Model loading", func() {
+	It("should report the progress of the model load", func() {
+		simulator, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		simulator.config = &common.Configuration{StartupDelay: 10 * time.Second, ModelLoadTime: 20 * time.Second}
+
+		simulator.startTime = time.Now().Add(-5 * time.Second)
+		Expect(simulator.isModelLoading()).To(BeTrue())
+		Expect(simulator.getModelLoadProgress()).To(BeZero())
+
+		simulator.startTime = time.Now().Add(-20 * time.Second)
+		Expect(simulator.isModelLoading()).To(BeTrue())
+		Expect(simulator.getModelLoadProgress()).To(BeNumerically("~", 0.5, 0.01))
+
+		simulator.startTime = time.Now().Add(-30 * time.Second)
+		Expect(simulator.isModelLoading()).To(BeFalse())
+		Expect(simulator.getModelLoadProgress()).To(Equal(1.0))
+
+		simulator.config = &common.Configuration{}
+		Expect(simulator.isModelLoading()).To(BeFalse())
+		Expect(simulator.getModelLoadProgress()).To(Equal(1.0))
+	})
+
+	It("should not be ready and reject the requests until the model is loaded", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--startup-delay", "200ms", "--model-load-time", "800ms"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		sendCompletion := func() (int, string) {
+			resp, err := client.Post("http://localhost/v1/completions", "application/json",
+				strings.NewReader(`{"model": "`+model+`", "prompt": "Hello", "max_tokens": 2}`))
+			Expect(err).NotTo(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return resp.StatusCode, string(body)
+		}
+		getLoadProgress := func() float64 {
+			resp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return getGaugeValue(string(data), "sim_model_load_progress")
+		}
+
+		expectReadiness(client, readinessLoading)
+		statusCode, body := sendCompletion()
+		Expect(statusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(ContainSubstring(modelLoadingMessage))
+		Expect(getLoadProgress()).To(BeNumerically("<", 1))
+
+		Eventually(func() int {
+			statusCode, _ := getReady(client, readyURL)
+			return statusCode
+		}, 3*time.Second, 50*time.Millisecond).Should(Equal(http.StatusOK))
+		expectReadiness(client, readinessReady)
+		statusCode, _ = sendCompletion()
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(getLoadProgress()).To(Equal(1.0))
+	})
+})
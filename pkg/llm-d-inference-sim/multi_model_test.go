@@ -0,0 +1,160 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+var _ = Describe("Multiple base models", func() {
+	const additionalModels = `[{"name":"other-model","served_model_name":["other","other-alias"],
+		"inter_token_latency":100}]`
+
+	getMetrics := func(client *http.Client) string {
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("should list the additional models in /models", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--additional-models", additionalModels}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		var modelsResp vllmapi.ModelsResponse
+		Expect(openaiclient.Get(ctx, "/models", nil, &modelsResp)).To(Succeed())
+		ids := make([]string, 0, len(modelsResp.Data))
+		for _, m := range modelsResp.Data {
+			ids = append(ids, m.ID)
+			Expect(m.Parent).To(BeNil())
+		}
+		Expect(ids).To(Equal([]string{model, "other", "other-alias"}))
+		Expect(modelsResp.Data[0].Root).To(Equal(model))
+		Expect(modelsResp.Data[1].Root).To(Equal("other-model"))
+		Expect(modelsResp.Data[2].Root).To(Equal("other-model"))
+	})
+
+	It("should serve the requests of every model", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--additional-models", additionalModels}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, "other-alias", userMessage, false)
+		params.MaxTokens = openai.Int(5)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		// the first served model name of the model is returned
+		Expect(resp.Model).To(Equal("other"))
+
+		params.Model = model
+		resp, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Model).To(Equal(model))
+
+		params.Model = "other-model"
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		Expect(err).To(BeAssignableToTypeOf(openaiError))
+	})
+
+	It("should report the metrics by model", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--additional-models", additionalModels}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		metrics := getMetrics(client)
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="` + model + `"} 0`))
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="other"} 0`))
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_waiting{model_name="other"} 0`))
+
+		// the inter token latency of the additional model keeps its request running
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			openaiclient, params := getOpenAIClentAndChatParams(client, "other-alias", userMessage, false)
+			params.MaxTokens = openai.Int(5)
+			_, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		Eventually(func() float64 {
+			return getGaugeValue(getMetrics(client), `vllm:num_requests_running{model_name="other"}`)
+		}, 2*time.Second, 20*time.Millisecond).Should(Equal(1.0))
+		Expect(getGaugeValue(getMetrics(client), `vllm:num_requests_running{model_name="`+model+`"}`)).To(Equal(0.0))
+		<-done
+
+		metrics = getMetrics(client)
+		Expect(metrics).To(ContainSubstring(`vllm:num_requests_running{model_name="other"} 0`))
+		Expect(metrics).To(ContainSubstring(`vllm:e2e_request_latency_seconds_count{model_name="other"} 1`))
+		Expect(metrics).NotTo(ContainSubstring(`vllm:e2e_request_latency_seconds_count{model_name="` + model + `"}`))
+	})
+
+	It("should validate the requests against the context window of their model", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, common.ModeEcho,
+			[]string{"cmd", "--model", model, "--mode", common.ModeEcho, "--max-model-len", "20",
+				"--additional-models", `[{"name":"other-model","served_model_name":["other","other-alias"],"max_model_len":1000}]`,
+				"--lora-modules", `{"name":"other-lora","base_model_name":"other-model"}`,
+				`{"name":"small-lora","base_model_name":"other-model","max_model_len":30}`,
+				"--served-model-max-model-len", `{"other-alias":500}`}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		var modelsResp vllmapi.ModelsResponse
+		Expect(openaiclient.Get(ctx, "/models", nil, &modelsResp)).To(Succeed())
+		maxModelLens := make(map[string]int)
+		for _, m := range modelsResp.Data {
+			maxModelLens[m.ID] = m.MaxModelLen
+		}
+		Expect(maxModelLens).To(Equal(map[string]int{model: 20, "other": 1000, "other-alias": 500,
+			"other-lora": 1000, "small-lora": 30}))
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(100)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("maximum context length is 20 tokens"))
+
+		for _, name := range []string{"other", "other-alias", "other-lora"} {
+			params.Model = name
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		params.Model = "small-lora"
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("maximum context length is 30 tokens"))
+	})
+})
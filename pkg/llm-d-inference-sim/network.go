@@ -0,0 +1,180 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Simulation of the network latency of the responses
+package llmdinferencesim
+
+import (
+	"bufio"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// hasNetworkLatency returns true if the responses reach the client after a simulated network latency
+func (s *VllmSimulator) hasNetworkLatency() bool {
+	return s.config.NetworkLatency > 0 || s.config.NetworkJitter > 0
+}
+
+// getNetworkDelay returns the real time a write takes to reach the client, the network latency
+// with a uniformly distributed jitter, never negative
+func (s *VllmSimulator) getNetworkDelay() time.Duration {
+	delay := s.config.NetworkLatency
+	if s.config.NetworkJitter > 0 {
+		jitter := float64(s.config.NetworkJitter)
+		delay += time.Duration(s.random.Float(-jitter, jitter))
+	}
+	return s.realDuration(max(delay, 0))
+}
+
+// withNetworkLatency returns the handler whose responses reach the client after the network latency,
+// the body of a streamed response is delayed by the writer of the stream, see withNetworkDelay
+func (s *VllmSimulator) withNetworkLatency(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		handler(ctx)
+		if s.hasNetworkLatency() {
+			time.Sleep(s.getNetworkDelay())
+		}
+	}
+}
+
+// withNetworkDelay returns the stream writer whose writes reach the client after the network latency,
+// the given writer is returned if there is no network latency
+func (s *VllmSimulator) withNetworkDelay(sw fasthttp.StreamWriter) fasthttp.StreamWriter {
+	if !s.hasNetworkLatency() {
+		return sw
+	}
+	return func(w *bufio.Writer) {
+		delayed := newNetworkDelayWriter(w, s.getNetworkDelay)
+		bw := bufio.NewWriter(delayed)
+		sw(bw)
+		// a failure to deliver the stream was already returned to its writer
+		_ = bw.Flush()
+		_ = delayed.close()
+	}
+}
+
+// delayedWrite is the data of a write and the time it reaches the client
+type delayedWrite struct {
+	data    []byte
+	arrival time.Time
+}
+
+// networkDelayWriter delivers the written data to the underlying writer after the network delay, in
+// the order it was written. The writes are not blocked by the delay, so the delays of the chunks of a
+// stream don't add up
+type networkDelayWriter struct {
+	w        *bufio.Writer
+	getDelay func() time.Duration
+	mutex    sync.Mutex
+	// pending are the writes that were not delivered yet
+	pending []delayedWrite
+	// lastArrival is the arrival time of the last write, a write never overtakes a previous one
+	lastArrival time.Time
+	// err is the error of the last failed delivery, the writes fail after it
+	err    error
+	closed bool
+	// wakeup is signaled when a write is added or the writer is closed
+	wakeup chan struct{}
+	// done is closed when all the writes were delivered after the writer was closed
+	done chan struct{}
+}
+
+func newNetworkDelayWriter(w *bufio.Writer, getDelay func() time.Duration) *networkDelayWriter {
+	writer := &networkDelayWriter{
+		w:        w,
+		getDelay: getDelay,
+		wakeup:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go writer.deliver()
+	return writer
+}
+
+// Write queues a copy of the data to be delivered after the network delay
+func (n *networkDelayWriter) Write(p []byte) (int, error) {
+	n.mutex.Lock()
+	if n.err != nil {
+		n.mutex.Unlock()
+		return 0, n.err
+	}
+	arrival := time.Now().Add(n.getDelay())
+	if arrival.Before(n.lastArrival) {
+		arrival = n.lastArrival
+	}
+	n.lastArrival = arrival
+	n.pending = append(n.pending, delayedWrite{data: append([]byte(nil), p...), arrival: arrival})
+	n.mutex.Unlock()
+	n.signal()
+	return len(p), nil
+}
+
+// close waits until all the writes are delivered, returns the error of the last failed delivery
+func (n *networkDelayWriter) close() error {
+	n.mutex.Lock()
+	n.closed = true
+	n.mutex.Unlock()
+	n.signal()
+	<-n.done
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.err
+}
+
+func (n *networkDelayWriter) signal() {
+	select {
+	case n.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// deliver writes the pending data to the underlying writer at its arrival time, until the writer is closed
+func (n *networkDelayWriter) deliver() {
+	defer close(n.done)
+	for {
+		n.mutex.Lock()
+		if len(n.pending) == 0 {
+			closed := n.closed
+			n.mutex.Unlock()
+			if closed {
+				return
+			}
+			<-n.wakeup
+			continue
+		}
+		next := n.pending[0]
+		n.pending = n.pending[1:]
+		failed := n.err != nil
+		n.mutex.Unlock()
+
+		if failed {
+			// the client is gone, the rest of the data is dropped
+			continue
+		}
+		time.Sleep(time.Until(next.arrival))
+		_, err := n.w.Write(next.data)
+		if err == nil {
+			err = n.w.Flush()
+		}
+		if err != nil {
+			n.mutex.Lock()
+			n.err = err
+			n.mutex.Unlock()
+		}
+	}
+}
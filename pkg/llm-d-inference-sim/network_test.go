@@ -0,0 +1,114 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Network latency", func() {
+	const latency = 300 * time.Millisecond
+
+	startNetworkServer := func(ctx context.Context, extraArgs ...string) *http.Client {
+		args := append([]string{"cmd", "--model", model, "--mode", common.ModeEcho,
+			"--network-latency", latency.String()}, extraArgs...)
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	DescribeTable("should delay the responses",
+		func(path string) {
+			client := startNetworkServer(context.TODO())
+
+			start := time.Now()
+			resp, err := client.Get("http://localhost" + path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(time.Since(start)).To(BeNumerically(">=", latency))
+		},
+		func(path string) string {
+			return fmt.Sprintf("path: %s", path)
+		},
+		Entry(nil, "/health"),
+		Entry(nil, "/metrics"),
+		Entry(nil, "/v1/models"),
+	)
+
+	It("should delay every chunk of a stream without adding up the delays", func() {
+		const itl = 50
+		client := startNetworkServer(context.TODO(), "--time-to-first-token", "0",
+			"--inter-token-latency", fmt.Sprint(itl))
+
+		start := time.Now()
+		resp, err := client.Post("http://localhost/v1/completions", "application/json",
+			strings.NewReader(fmt.Sprintf(textStreamBody, "")))
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			Expect(resp.Body.Close()).To(Succeed())
+		}()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var arrivals []time.Duration
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data: ") {
+				arrivals = append(arrivals, time.Since(start))
+			}
+		}
+		Expect(scanner.Err()).NotTo(HaveOccurred())
+
+		nTokens := len(common.Tokenize("Hello, how are you?"))
+		// the token chunks, the finish reason chunk and the [DONE] sentinel
+		Expect(arrivals).To(HaveLen(nTokens + 2))
+		Expect(arrivals[0]).To(BeNumerically(">=", latency))
+		streamDuration := time.Duration(nTokens-1) * itl * time.Millisecond
+		Expect(arrivals[len(arrivals)-1]).To(BeNumerically(">=", latency+streamDuration))
+		// the latency of a chunk doesn't delay the generation of the next one
+		Expect(arrivals[len(arrivals)-1]).To(BeNumerically("<", 2*latency+streamDuration))
+	})
+
+	It("should add a jitter to the latency", func() {
+		const jitter = 100 * time.Millisecond
+		s := &VllmSimulator{config: &common.Configuration{NetworkLatency: latency, NetworkJitter: jitter},
+			random: common.NewRandom(1)}
+		delays := make(map[time.Duration]bool)
+		for range 100 {
+			delay := s.getNetworkDelay()
+			Expect(delay).To(BeNumerically(">=", latency-jitter))
+			Expect(delay).To(BeNumerically("<=", latency+jitter))
+			delays[delay] = true
+		}
+		Expect(len(delays)).To(BeNumerically(">", 1))
+
+		// a jitter larger than the latency never makes the delay negative
+		s.config.NetworkLatency = 0
+		for range 100 {
+			Expect(s.getNetworkDelay()).To(BeNumerically(">=", 0))
+		}
+	})
+})
@@ -0,0 +1,199 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	openAPITitle   = "llm-d inference simulator"
+	// openAPIDocumentVersion is the version of the simulator's API described by the document
+	openAPIDocumentVersion = "1.0.0"
+
+	// the tags of the endpoints in the OpenAPI document
+	openAPITagOpenAI = "openai"
+	openAPITagVLLM   = "vllm"
+	openAPITagSim    = "simulator"
+	openAPITagAdmin  = "admin"
+
+	textMediaType          = "text/plain"
+	octetStreamMediaType   = "application/octet-stream"
+	multipartFormMediaType = "multipart/form-data"
+)
+
+// routeParamRegex matches the named parameters of the router's paths, :name and *name
+var routeParamRegex = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+// openAPIDocument is an OpenAPI 3.0 document of the HTTP endpoints of the simulator
+type openAPIDocument struct {
+	OpenAPI string      `json:"openapi"`
+	Info    openAPIInfo `json:"info"`
+	// Paths maps the path of an endpoint to its operations by the lower case HTTP method
+	Paths map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	// SimExtension marks the endpoints that are specific to the simulator
+	SimExtension bool `json:"x-sim-extension,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description"`
+	Required    bool          `json:"required"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string        `json:"description"`
+	Schema      openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type string   `json:"type"`
+	Enum []string `json:"enum,omitempty"`
+}
+
+// newOpenAPIDocument creates the OpenAPI document of the given routes
+func (s *VllmSimulator) newOpenAPIDocument(routes []route) openAPIDocument {
+	document := openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title: openAPITitle,
+			Description: "A simulator of the OpenAI-compatible API of vLLM, the endpoints marked with " +
+				"x-sim-extension are specific to the simulator",
+			Version: openAPIDocumentVersion,
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+	}
+	for _, route := range routes {
+		path := routeParamRegex.ReplaceAllString(route.path, "{$1}")
+		if document.Paths[path] == nil {
+			document.Paths[path] = make(map[string]openAPIOperation)
+		}
+		document.Paths[path][strings.ToLower(route.method)] = s.newOpenAPIOperation(route)
+	}
+	return document
+}
+
+// newOpenAPIOperation creates the OpenAPI operation of a route
+func (s *VllmSimulator) newOpenAPIOperation(route route) openAPIOperation {
+	objectSchema := openAPISchema{Type: "object"}
+	operation := openAPIOperation{
+		OperationID:  openAPIOperationID(route.method, route.path),
+		Summary:      route.summary,
+		Tags:         []string{route.tag},
+		SimExtension: route.simExtension,
+	}
+	for _, match := range routeParamRegex.FindAllStringSubmatch(route.path, -1) {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{Name: match[1], In: "path",
+			Required: true, Schema: openAPISchema{Type: "string"}})
+	}
+	if route.isCompletion && s.config.AllowInjectionHeaders {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{
+			Name:        injectFailureHeader,
+			In:          "header",
+			Description: "The failure to inject into the response",
+			Schema:      openAPISchema{Type: "string", Enum: common.ValidFailureTypes()},
+		})
+	}
+	if route.requestType != "" {
+		operation.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMediaType{route.requestType: {Schema: objectSchema}},
+		}
+	}
+
+	responseTypes := route.responseTypes
+	if len(responseTypes) == 0 {
+		responseTypes = []string{jsonMediaType}
+	}
+	success := openAPIResponse{Description: "Success", Content: make(map[string]openAPIMediaType)}
+	for _, mediaType := range responseTypes {
+		schema := objectSchema
+		if mediaType != jsonMediaType {
+			schema = openAPISchema{Type: "string"}
+		}
+		success.Content[mediaType] = openAPIMediaType{Schema: schema}
+	}
+	if route.isCompletion {
+		success.Headers = map[string]openAPIHeader{
+			truncatedPromptHeader: {Description: "The number of prompt tokens outside of the visible context window",
+				Schema: openAPISchema{Type: "integer"}},
+		}
+	}
+	operation.Responses = map[string]openAPIResponse{
+		"200": success,
+		"default": {Description: "Error",
+			Content: map[string]openAPIMediaType{jsonMediaType: {Schema: objectSchema}}},
+	}
+	return operation
+}
+
+// openAPIOperationID returns the id of the operation of the given method and path,
+// e.g. post_v1_chat_completions
+func openAPIOperationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '.' || r == '-' || r == ':' || r == '*'
+	}) {
+		id += "_" + part
+	}
+	return id
+}
+
+// HandleOpenAPI http handler for /openapi.json, returns the OpenAPI document of the HTTP endpoints
+func (s *VllmSimulator) HandleOpenAPI(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.SetContentType(jsonMediaType)
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(s.openAPIDocument)
+}
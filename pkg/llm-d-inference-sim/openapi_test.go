@@ -0,0 +1,104 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const openAPIURL = "http://localhost/openapi.json"
+
+// getOpenAPIDocument returns the OpenAPI document served by the simulator
+func getOpenAPIDocument(client *http.Client) openAPIDocument {
+	resp, err := client.Get(openAPIURL)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(resp.Body.Close()).To(Succeed())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	Expect(resp.Header.Get("Content-Type")).To(Equal(jsonMediaType))
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	var document openAPIDocument
+	Expect(json.Unmarshal(data, &document)).To(Succeed())
+	return document
+}
+
+var _ = Describe("OpenAPI document", func() {
+	It("should describe the endpoints of the simulator", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		document := getOpenAPIDocument(client)
+		Expect(document.OpenAPI).To(Equal(openAPIVersion))
+		Expect(document.Paths).To(HaveKey("/v1/models"))
+		Expect(document.Paths).To(HaveKey("/metrics"))
+		Expect(document.Paths).To(HaveKey("/openapi.json"))
+		// the admin endpoints are not served by default
+		Expect(document.Paths).NotTo(HaveKey("/_sim/config"))
+
+		chat := document.Paths["/v1/chat/completions"]["post"]
+		Expect(chat.OperationID).To(Equal("post_v1_chat_completions"))
+		Expect(chat.Tags).To(Equal([]string{openAPITagOpenAI}))
+		Expect(chat.SimExtension).To(BeFalse())
+		Expect(chat.RequestBody).NotTo(BeNil())
+		Expect(chat.Responses["200"].Content).To(HaveKey(eventStreamMediaType))
+		Expect(chat.Responses["200"].Headers).To(HaveKey(truncatedPromptHeader))
+		Expect(chat.Parameters).To(BeEmpty())
+		Expect(document.Paths["/v1/usage"]["get"].SimExtension).To(BeTrue())
+	})
+
+	It("should describe the endpoints enabled by the configuration", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--enable-pprof", "--allow-injection-headers"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		document := getOpenAPIDocument(client)
+		Expect(document.Paths["/_sim/config"]).To(HaveKey("get"))
+		Expect(document.Paths["/_sim/config"]).To(HaveKey("patch"))
+		Expect(document.Paths["/_sim/config"]["patch"].SimExtension).To(BeTrue())
+
+		pprof := document.Paths["/debug/pprof/{name}"]["get"]
+		Expect(pprof.OperationID).To(Equal("get_debug_pprof_name"))
+		Expect(pprof.Parameters).To(ConsistOf(HaveField("In", "path")))
+
+		completion := document.Paths["/v1/completions"]["post"]
+		Expect(completion.Parameters).To(HaveLen(1))
+		Expect(completion.Parameters[0].Name).To(Equal(injectFailureHeader))
+		Expect(completion.Parameters[0].Schema.Enum).To(ContainElement(common.FailureTypeRateLimit))
+
+		// every registered endpoint is described
+		s := &VllmSimulator{config: &common.Configuration{EnableAdminAPI: true, EnablePprof: true}}
+		nOperations := 0
+		for _, operations := range document.Paths {
+			nOperations += len(operations)
+		}
+		Expect(nOperations).To(Equal(len(s.routes())))
+	})
+})
@@ -0,0 +1,138 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+// remotePrefillTimeout is the maximal duration of a request to the prefill pod
+const remotePrefillTimeout = 5 * time.Minute
+
+var prefillClient = &http.Client{Timeout: remotePrefillTimeout}
+
+// getPrefillTransferParams returns the kv transfer parameters of a prefill response with the given id,
+// a block id for every block of the prompt tokens, this instance is the remote engine
+func (s *VllmSimulator) getPrefillTransferParams(responseID string, nPromptTokens int) openaiserverapi.KVTransferParams {
+	blockSize := max(s.config.TokenBlockSize, 1)
+	nBlocks := (nPromptTokens + blockSize - 1) / blockSize
+	blockIDs := make([]string, 0, nBlocks)
+	for i := range nBlocks {
+		blockIDs = append(blockIDs, fmt.Sprintf("%s-%d", responseID, i))
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return openaiserverapi.KVTransferParams{
+		DoRemoteDecode: true,
+		RemoteBlockIds: blockIDs,
+		RemoteEngineId: s.engineID,
+		RemoteHost:     host,
+		RemotePort:     s.config.Port,
+	}
+}
+
+// getTransferredPromptTokens returns the number of prompt tokens whose kv cache is transferred from the
+// prefill pod, in the decode role these are the tokens of the remote blocks, otherwise all the prompt tokens
+func (s *VllmSimulator) getTransferredPromptTokens(nPromptTokens int, nRemoteBlocks int, doRemotePrefill bool) int {
+	if !doRemotePrefill || s.config.PDRole != common.PDRoleDecode || nRemoteBlocks == 0 {
+		return nPromptTokens
+	}
+	return min(nRemoteBlocks*s.config.TokenBlockSize, nPromptTokens)
+}
+
+// shouldPrefillRemotely returns true if the request should be sent to the prefill pod before it is decoded
+func (s *VllmSimulator) shouldPrefillRemotely(req openaiserverapi.CompletionRequest) bool {
+	return s.config.PDRole == common.PDRoleDecode && s.config.PrefillEndpoint != "" && !req.IsDoRemotePrefill()
+}
+
+// prefillRemotely sends the request to the prefill pod as a prefill only request, and sets the kv transfer
+// parameters of its response in the request, so that the request is decoded after a remote prefill
+func (s *VllmSimulator) prefillRemotely(ctx *fasthttp.RequestCtx, req openaiserverapi.CompletionRequest) error {
+	var body map[string]any
+	if err := json.Unmarshal(ctx.Request.Body(), &body); err != nil {
+		return fmt.Errorf("failed to parse request body: %w", err)
+	}
+	// the prefill pod generates a single token and returns the kv transfer parameters
+	body["do_remote_decode"] = true
+	body["do_remote_prefill"] = false
+	body["stream"] = false
+	body["max_tokens"] = 1
+	body["n"] = 1
+	delete(body, "stream_options")
+	delete(body, "max_completion_tokens")
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to create prefill request: %w", err)
+	}
+
+	url := strings.TrimSuffix(s.config.PrefillEndpoint, "/") + string(ctx.Path())
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create prefill request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if auth := ctx.Request.Header.Peek("Authorization"); len(auth) > 0 {
+		httpReq.Header.Set("Authorization", string(auth))
+	}
+
+	start := time.Now()
+	resp, err := prefillClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("prefill request failed: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			s.logger.Error(err, "failed to close prefill response body")
+		}
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read prefill response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("prefill request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var prefillResp openaiserverapi.BaseCompletionResponse
+	if err := json.Unmarshal(respBody, &prefillResp); err != nil {
+		return fmt.Errorf("failed to parse prefill response: %w", err)
+	}
+	params := prefillResp.GetKVTransferParams()
+	if !params.DoRemoteDecode {
+		return fmt.Errorf("prefill response from %s has no kv transfer parameters", url)
+	}
+	params.DoRemoteDecode = false
+	params.DoRemotePrefill = true
+	req.SetKVTransferParams(params)
+	s.logger.V(4).Info("Remote prefill done", "request id", req.GetRequestID(), "prefill endpoint", url,
+		"remote engine id", params.RemoteEngineId, "remote blocks", len(params.RemoteBlockIds),
+		"duration", time.Since(start).String())
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Prefill/decode disaggregation", func() {
+	const (
+		completionsURL = "http://localhost/v1/completions"
+		prompt         = "The quick brown fox jumps over the lazy dog, and then it runs away into the forest"
+	)
+
+	postCompletion := func(client *http.Client, body string) (int, []byte) {
+		resp, err := client.Post(completionsURL, "application/json", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		respBody, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		return resp.StatusCode, respBody
+	}
+
+	It("should return the kv transfer parameters of the prompt blocks in the prefill role", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--pd-role", common.PDRolePrefill,
+			"--block-size", "8"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, body := postCompletion(client, `{"model": "`+model+`", "prompt": "`+prompt+`", "max_tokens": 10}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var resp openaiserverapi.TextCompletionResponse
+		Expect(json.Unmarshal(body, &resp)).To(Succeed())
+		Expect(resp.Choices[0].FinishReason).To(HaveValue(Equal(dataset.RemoteDecodeFinishReason)))
+
+		params := resp.GetKVTransferParams()
+		Expect(params.DoRemoteDecode).To(BeTrue())
+		Expect(params.DoRemotePrefill).To(BeFalse())
+		Expect(params.RemoteBlockIds).To(HaveLen((resp.Usage.PromptTokens + 7) / 8))
+		Expect(params.RemoteBlockIds[0]).To(HavePrefix(resp.ID))
+		Expect(params.RemoteEngineId).NotTo(BeEmpty())
+		Expect(params.RemoteHost).NotTo(BeEmpty())
+
+		// a prefill pod doesn't stream
+		statusCode, _ = postCompletion(client, `{"model": "`+model+`", "prompt": "`+prompt+`", "stream": true}`)
+		Expect(statusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should decode the request after its prefill on the prefill pod", func() {
+		ctx := context.TODO()
+		prefillArgs := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--pd-role", common.PDRolePrefill}
+		prefillClient, err := startServerWithArgs(ctx, common.ModeRandom, prefillArgs, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the prefill pod is reached through a proxy that records the prefill requests
+		var mutex sync.Mutex
+		var prefillRequests []map[string]any
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			body, err := io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			var request map[string]any
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			mutex.Lock()
+			prefillRequests = append(prefillRequests, request)
+			mutex.Unlock()
+
+			resp, err := prefillClient.Post("http://localhost"+r.URL.Path, "application/json", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			respBody, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			w.WriteHeader(resp.StatusCode)
+			_, err = w.Write(respBody)
+			Expect(err).NotTo(HaveOccurred())
+		}))
+		defer proxy.Close()
+
+		decodeArgs := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--pd-role", common.PDRoleDecode,
+			"--prefill-endpoint", proxy.URL}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, decodeArgs, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, body := postCompletion(client, `{"model": "`+model+`", "prompt": "`+prompt+
+			`", "max_tokens": 5, "ignore_eos": true, "stream": false}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var resp openaiserverapi.TextCompletionResponse
+		Expect(json.Unmarshal(body, &resp)).To(Succeed())
+		Expect(resp.Usage.CompletionTokens).To(Equal(5))
+		Expect(resp.Choices[0].FinishReason).To(HaveValue(Equal(dataset.LengthFinishReason)))
+		Expect(resp.DoRemoteDecode).To(BeFalse())
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(prefillRequests).To(HaveLen(1))
+		Expect(prefillRequests[0]).To(HaveKeyWithValue("do_remote_decode", true))
+		Expect(prefillRequests[0]).To(HaveKeyWithValue("stream", false))
+		Expect(prefillRequests[0]).To(HaveKeyWithValue("max_tokens", BeNumerically("==", 1)))
+		Expect(prefillRequests[0]).To(HaveKeyWithValue("prompt", prompt))
+
+		// a request that was already prefilled remotely is not sent to the prefill pod
+		statusCode, _ = postCompletion(client, `{"model": "`+model+`", "prompt": "`+prompt+
+			`", "max_tokens": 5, "do_remote_prefill": true, "remote_block_ids": ["1", "2"]}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(prefillRequests).To(HaveLen(1))
+	})
+
+	It("should fail the request when its prefill fails", func() {
+		ctx := context.TODO()
+		prefill := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer prefill.Close()
+
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--pd-role", common.PDRoleDecode,
+			"--prefill-endpoint", prefill.URL}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, body := postCompletion(client, `{"model": "`+model+`", "prompt": "`+prompt+`"}`)
+		Expect(statusCode).To(Equal(http.StatusBadGateway))
+		Expect(string(body)).To(ContainSubstring("Remote prefill failed"))
+	})
+
+	It("should transfer the kv cache of the remote blocks in the decode role", func() {
+		simulator, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		simulator.config = &common.Configuration{TokenBlockSize: 16}
+
+		Expect(simulator.getTransferredPromptTokens(100, 2, true)).To(Equal(100))
+		simulator.config.PDRole = common.PDRoleDecode
+		Expect(simulator.getTransferredPromptTokens(100, 2, true)).To(Equal(32))
+		Expect(simulator.getTransferredPromptTokens(100, 10, true)).To(Equal(100))
+		Expect(simulator.getTransferredPromptTokens(100, 0, true)).To(Equal(100))
+		Expect(simulator.getTransferredPromptTokens(100, 2, false)).To(Equal(100))
+	})
+})
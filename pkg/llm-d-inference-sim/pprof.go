@@ -0,0 +1,50 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"net/http/pprof"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+var (
+	pprofIndexHandler   = fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Index)
+	pprofCmdlineHandler = fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Cmdline)
+	pprofProfileHandler = fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Profile)
+	pprofSymbolHandler  = fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Symbol)
+	pprofTraceHandler   = fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Trace)
+)
+
+// handlePprof dispatches a /debug/pprof/ request to the matching pprof handler,
+// the named profiles (e.g. heap, goroutine) are served by the index handler
+func handlePprof(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	switch name {
+	case "/cmdline":
+		pprofCmdlineHandler(ctx)
+	case "/profile":
+		pprofProfileHandler(ctx)
+	case "/symbol":
+		pprofSymbolHandler(ctx)
+	case "/trace":
+		pprofTraceHandler(ctx)
+	default:
+		pprofIndexHandler(ctx)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"math"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// getPreemptionProbability returns the probability (0-100) of preempting a request that starts running,
+// the preemption rate at max-num-seqs running requests, decreasing linearly with the number of running requests
+func (s *VllmSimulator) getPreemptionProbability() int {
+	config := s.getRuntimeConfig()
+	if config.PreemptionRate == 0 || config.MaxNumSeqs <= 0 {
+		return 0
+	}
+	load := math.Min(float64(s.nRunningReqs)/float64(config.MaxNumSeqs), 1)
+	return int(float64(config.PreemptionRate) * load)
+}
+
+// shouldPreempt returns true if the request that starts running should be preempted,
+// a request is preempted at most once
+func (s *VllmSimulator) shouldPreempt(reqCtx *openaiserverapi.CompletionReqCtx) bool {
+	if reqCtx.Preempted {
+		return false
+	}
+	probability := s.getPreemptionProbability()
+	return probability > 0 && s.random.Int(1, 100) <= probability
+}
+
+// preempt returns the running request to the waiting queue, it runs again when a worker takes it
+func (s *VllmSimulator) preempt(reqCtx *openaiserverapi.CompletionReqCtx) {
+	req := reqCtx.CompletionReq
+	lora := ""
+	if s.isLora(req.GetModel()) {
+		lora = req.GetModel()
+	}
+	s.logger.V(4).Info("Request preempted", "request id", req.GetRequestID())
+	reqCtx.Preempted = true
+	s.reportPreemption(s.getDisplayedModelName(req.GetModel()))
+	// decrement running and increment waiting requests count
+	s.reportRequestTransition(req.GetModel(), preemptedRequestState)
+	s.freeMemory(req.GetRequestID())
+	s.waitingQueue.requeue(reqCtx, req.GetPriority(), lora)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Preemption", func() {
+	var simulator *VllmSimulator
+
+	BeforeEach(func() {
+		var err error
+		simulator, err = New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		simulator.config = &common.Configuration{MaxNumSeqs: 4, PreemptionRate: 80}
+		simulator.random = common.NewRandom(100)
+	})
+
+	It("should preempt with a probability that grows with the number of running requests", func() {
+		Expect(simulator.getPreemptionProbability()).To(BeZero())
+		simulator.nRunningReqs = 1
+		Expect(simulator.getPreemptionProbability()).To(Equal(20))
+		simulator.nRunningReqs = 4
+		Expect(simulator.getPreemptionProbability()).To(Equal(80))
+		// embeddings requests are counted as running too, the probability is capped at the rate
+		simulator.nRunningReqs = 6
+		Expect(simulator.getPreemptionProbability()).To(Equal(80))
+
+		simulator.config.PreemptionRate = 0
+		Expect(simulator.getPreemptionProbability()).To(BeZero())
+	})
+
+	It("should preempt a request at most once", func() {
+		simulator.config.PreemptionRate = 100
+		simulator.nRunningReqs = 4
+		reqCtx := &openaiserverapi.CompletionReqCtx{}
+		Expect(simulator.shouldPreempt(reqCtx)).To(BeTrue())
+		reqCtx.Preempted = true
+		Expect(simulator.shouldPreempt(reqCtx)).To(BeFalse())
+	})
+})
@@ -0,0 +1,58 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"time"
+)
+
+// newPrefillSlots returns the semaphore of the prefill slots, nil if the number of concurrent prefills is unlimited
+func newPrefillSlots(maxConcurrentPrefills int) chan struct{} {
+	if maxConcurrentPrefills == 0 {
+		return nil
+	}
+	return make(chan struct{}, maxConcurrentPrefills)
+}
+
+// acquirePrefillSlot waits for a free prefill slot, returns the simulated time the request waited for the slot
+func (s *VllmSimulator) acquirePrefillSlot() time.Duration {
+	start := time.Now()
+	if s.prefillSlots != nil {
+		s.prefillSlots <- struct{}{}
+	}
+	wait := s.virtualDuration(time.Since(start))
+	s.activePrefills.Add(1)
+	s.reportPrefillQueueWait(wait)
+	return wait
+}
+
+// releasePrefillSlot frees the prefill slot of a request whose prefill ended
+func (s *VllmSimulator) releasePrefillSlot() {
+	s.activePrefills.Add(-1)
+	if s.prefillSlots != nil {
+		<-s.prefillSlots
+	}
+}
+
+// reportPrefillQueueWait records the time a request waited for a prefill slot
+func (s *VllmSimulator) reportPrefillQueueWait(wait time.Duration) {
+	if s.prefillQueueWait == nil {
+		// Happens in the tests
+		return
+	}
+	s.prefillQueueWait.Observe(wait.Seconds())
+}
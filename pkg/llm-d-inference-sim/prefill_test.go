@@ -0,0 +1,130 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrent prefills", func() {
+	const (
+		ttft    = 200
+		itl     = 75
+		workers = 4
+	)
+
+	// runRequests sends a request to each worker at the same time, returns the time until all the responses
+	// were received, the maximal number of active prefills observed meanwhile and the metrics at the end
+	runRequests := func(maxConcurrentPrefills int, stream bool) (time.Duration, float64, string) {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+			"--max-num-seqs", fmt.Sprint(workers), "--max-concurrent-prefills", fmt.Sprint(maxConcurrentPrefills),
+			"--time-to-first-token", fmt.Sprint(ttft), "--inter-token-latency", fmt.Sprint(itl)}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		getMetrics := func() string {
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return string(data)
+		}
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, stream)
+		var done atomic.Bool
+		var wg sync.WaitGroup
+		start := time.Now()
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				if stream {
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					for stream.Next() {
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+				} else {
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			done.Store(true)
+		}()
+
+		maxActivePrefills := 0.0
+		for !done.Load() {
+			maxActivePrefills = max(maxActivePrefills, getGaugeValue(getMetrics(), "sim_active_prefills"))
+			time.Sleep(10 * time.Millisecond)
+		}
+		elapsed := time.Since(start)
+
+		metrics := getMetrics()
+		Expect(getGaugeValue(metrics, "sim_active_prefills")).To(Equal(0.0))
+		return elapsed, maxActivePrefills, metrics
+	}
+
+	// getDecodeTime returns the decode time of a request, userMsgTokens is set when the server starts
+	getDecodeTime := func() time.Duration {
+		return time.Duration(userMsgTokens-1) * itl * time.Millisecond
+	}
+
+	DescribeTable("should serialize the prefills while the decodes overlap",
+		func(stream bool) {
+			elapsed, maxActivePrefills, metrics := runRequests(1, stream)
+			decode := getDecodeTime()
+			Expect(maxActivePrefills).To(Equal(1.0))
+			// the last prefill starts after the other three ended, the decodes don't wait for each other
+			Expect(elapsed).To(BeNumerically(">=", workers*ttft*time.Millisecond+decode))
+			Expect(elapsed).To(BeNumerically("<", workers*ttft*time.Millisecond+2*decode))
+
+			Expect(getGaugeValue(metrics, "sim_prefill_queue_wait_seconds_count")).To(Equal(float64(workers)))
+			// the requests waited 0, 1, 2 and 3 prefills for their slots
+			Expect(getGaugeValue(metrics, "sim_prefill_queue_wait_seconds_sum")).To(BeNumerically("~", 1.2, 0.15))
+			// the prefill wait counts as queue time
+			Expect(getGaugeValue(metrics, `vllm:request_queue_time_seconds_sum{model_name="`+model+`"}`)).
+				To(BeNumerically("~", 1.2, 0.15))
+		},
+		func(stream bool) string {
+			return fmt.Sprintf("stream: %t", stream)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	It("should not limit the prefills by default", func() {
+		elapsed, maxActivePrefills, metrics := runRequests(0, false)
+		decode := getDecodeTime()
+		Expect(maxActivePrefills).To(Equal(float64(workers)))
+		Expect(elapsed).To(BeNumerically("<", 2*ttft*time.Millisecond+decode))
+		Expect(getGaugeValue(metrics, "sim_prefill_queue_wait_seconds_sum")).To(BeNumerically("<", 0.05))
+	})
+})
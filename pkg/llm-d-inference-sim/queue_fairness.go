@@ -0,0 +1,224 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// queueWaitUpdateInterval is the interval between checks of the models' average queue wait
+	queueWaitUpdateInterval = 500 * time.Millisecond
+	// queueWaitWindowSize is the number of update intervals the average queue wait is computed over
+	queueWaitWindowSize = 4
+	// queueWaitWindow is the window the average queue wait is computed over
+	queueWaitWindow = queueWaitUpdateInterval * queueWaitWindowSize
+)
+
+// queueWaitSample is the queue wait of a request that started running
+type queueWaitSample struct {
+	// time is the time the request started running
+	time time.Time
+	wait time.Duration
+}
+
+// queueWaitTracker keeps the queue waits of the requests that started running in the queue wait window
+type queueWaitTracker struct {
+	mutex sync.Mutex
+	// samples maps a model to the queue waits of its requests, the oldest first
+	samples map[string][]queueWaitSample
+}
+
+// add records the queue wait of a request of the given model that started running at the given time,
+// the samples of the model that are older than the queue wait window are removed
+func (t *queueWaitTracker) add(model string, now time.Time, wait time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.samples == nil {
+		t.samples = make(map[string][]queueWaitSample)
+	}
+	samples := t.samples[model]
+	first := 0
+	for first < len(samples) && now.Sub(samples[first].time) > queueWaitWindow {
+		first++
+	}
+	t.samples[model] = append(samples[first:], queueWaitSample{time: now, wait: wait})
+}
+
+// size returns the number of the recorded samples
+func (t *queueWaitTracker) size() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	size := 0
+	for _, samples := range t.samples {
+		size += len(samples)
+	}
+	return size
+}
+
+// averages returns the average queue wait of each model, computed over the samples in the window
+// ending at the given time and the current waits of the requests that are still in the queue,
+// the samples older than the window are removed
+func (t *queueWaitTracker) averages(now time.Time, window time.Duration,
+	waiting map[string][]time.Duration) map[string]time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	totals := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	for model, samples := range t.samples {
+		first := 0
+		for first < len(samples) && now.Sub(samples[first].time) > window {
+			first++
+		}
+		if first == len(samples) {
+			delete(t.samples, model)
+			continue
+		}
+		t.samples[model] = samples[first:]
+		for _, sample := range samples[first:] {
+			totals[model] += sample.wait
+			counts[model]++
+		}
+	}
+	for model, waits := range waiting {
+		for _, wait := range waits {
+			totals[model] += wait
+			counts[model]++
+		}
+	}
+
+	averages := make(map[string]time.Duration, len(totals))
+	for model, total := range totals {
+		averages[model] = total / time.Duration(counts[model])
+	}
+	return averages
+}
+
+// starvationState is the starvation state of a model
+type starvationState struct {
+	// since is the time the model's average queue wait exceeded the threshold
+	since time.Time
+	// detected is true while the starvation is reported
+	detected bool
+}
+
+// getWaitingQueueWaits returns the current queue waits of the waiting requests, grouped by the model
+func (s *VllmSimulator) getWaitingQueueWaits(now time.Time) map[string][]time.Duration {
+	waiting := make(map[string][]time.Duration)
+	s.inFlightRequests.Range(func(_, value any) bool {
+		req := value.(*inFlightRequest)
+		req.mutex.RLock()
+		workerID := req.workerID
+		req.mutex.RUnlock()
+
+		if workerID == 0 {
+			model := s.getDisplayedModelName(req.model)
+			waiting[model] = append(waiting[model], now.Sub(req.enqueueTime))
+		}
+		return true
+	})
+	return waiting
+}
+
+// starvationUpdater periodically checks the models' average queue wait, computed over a sliding
+// window of the last queueWaitWindowSize update intervals, and reports the starving models
+func (s *VllmSimulator) starvationUpdater(ctx context.Context) {
+	ticker := time.NewTicker(queueWaitUpdateInterval)
+	defer ticker.Stop()
+
+	states := make(map[string]*starvationState)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			averages := s.queueWaits.averages(now, queueWaitWindow, s.getWaitingQueueWaits(now))
+			s.updateStarvation(now, averages, states)
+		}
+	}
+}
+
+// updateStarvation updates the models' starvation states according to their average queue wait
+func (s *VllmSimulator) updateStarvation(now time.Time, averages map[string]time.Duration,
+	states map[string]*starvationState) {
+	for model, average := range averages {
+		if average <= s.config.StarvationThreshold {
+			continue
+		}
+		state, ok := states[model]
+		if !ok {
+			state = &starvationState{since: now}
+			states[model] = state
+		}
+		if !state.detected && now.Sub(state.since) >= s.config.StarvationDuration {
+			state.detected = true
+			s.logger.Info("Warning: queue starvation detected", "model", model, "average queue wait", average,
+				"threshold", s.config.StarvationThreshold)
+			s.reportStarvation(model, true)
+		}
+	}
+
+	// models without requests in the window are not starving
+	for model, state := range states {
+		if average, ok := averages[model]; ok && average > s.config.StarvationThreshold {
+			continue
+		}
+		if state.detected {
+			s.logger.Info("Queue starvation recovered", "model", model)
+			s.reportStarvation(model, false)
+		}
+		delete(states, model)
+	}
+}
+
+// reportQueueWait records the time a request of the given model spent in the waiting queue
+func (s *VllmSimulator) reportQueueWait(model string, queueWait time.Duration) {
+	if s.config.StarvationThreshold > 0 {
+		// the queue waits are used by the starvation detection only
+		s.queueWaits.add(model, time.Now(), queueWait)
+	}
+	if s.queueWait == nil {
+		// Happens in the tests
+		return
+	}
+	s.queueWait.WithLabelValues(model).Observe(queueWait.Seconds())
+}
+
+// reportQueueOvertakes adds the number of waiting requests overtaken by a request of the given model
+func (s *VllmSimulator) reportQueueOvertakes(model string, overtaken int) {
+	if s.queueOvertakes == nil {
+		// Happens in the tests
+		return
+	}
+	s.queueOvertakes.WithLabelValues(model).Add(float64(overtaken))
+}
+
+// reportStarvation sets the starvation gauge of the given model
+func (s *VllmSimulator) reportStarvation(model string, starving bool) {
+	if s.starvationDetected == nil {
+		// Happens in the tests
+		return
+	}
+	value := 0.0
+	if starving {
+		value = 1
+	}
+	s.starvationDetected.WithLabelValues(model).Set(value)
+}
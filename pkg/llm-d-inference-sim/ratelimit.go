@@ -0,0 +1,237 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// OpenAI rate limit headers
+	rateLimitLimitRequestsHeader     = "x-ratelimit-limit-requests"
+	rateLimitLimitTokensHeader       = "x-ratelimit-limit-tokens"
+	rateLimitRemainingRequestsHeader = "x-ratelimit-remaining-requests"
+	rateLimitRemainingTokensHeader   = "x-ratelimit-remaining-tokens"
+	rateLimitResetRequestsHeader     = "x-ratelimit-reset-requests"
+	rateLimitResetTokensHeader       = "x-ratelimit-reset-tokens"
+	retryAfterHeader                 = "Retry-After"
+
+	rateLimitExceededMessageTemplate = "Rate limit reached for %s on %s per min (%s): Limit %d, Remaining %d, " +
+		"Requested %d. Please try again in %s."
+)
+
+// tokenBucket is a bucket of a rate limit, it holds up to capacity tokens and is refilled
+// at capacity tokens per minute
+type tokenBucket struct {
+	capacity   float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(capacity int, now time.Time) *tokenBucket {
+	return &tokenBucket{capacity: float64(capacity), tokens: float64(capacity), lastRefill: now}
+}
+
+// refill adds the tokens refilled since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+b.capacity*elapsed.Minutes())
+		b.lastRefill = now
+	}
+}
+
+// timeUntil returns the time until the bucket holds n tokens
+func (b *tokenBucket) timeUntil(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.capacity * float64(time.Minute))
+}
+
+// remaining returns the number of whole tokens in the bucket
+func (b *tokenBucket) remaining() int {
+	return int(b.tokens)
+}
+
+// rateLimitBuckets are the buckets of a rate limit key, a bucket is nil if its limit is not set
+type rateLimitBuckets struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// rateLimitResult is the result of a rate limit check of a request
+type rateLimitResult struct {
+	// allowed is true if the request is within the limits
+	allowed bool
+	// limitRequests and limitTokens are the limits, 0 if not set
+	limitRequests int
+	limitTokens   int
+	// remainingRequests and remainingTokens are the requests and tokens left after the check
+	remainingRequests int
+	remainingTokens   int
+	// resetRequests and resetTokens are the times until the limits are fully restored
+	resetRequests time.Duration
+	resetTokens   time.Duration
+	// retryAfter is the time until the rejected request is within the limits
+	retryAfter time.Duration
+	// exceeded is the exceeded limit, requests or tokens, empty if the request is allowed
+	exceeded string
+	// nTokens is the number of tokens of the request
+	nTokens int
+}
+
+// rateLimiter applies the requests per minute and tokens per minute limits, a pair of
+// token buckets per rate limit key
+type rateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*rateLimitBuckets
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateLimitBuckets)}
+}
+
+// take checks whether a request with nTokens tokens of the given key is within the limits, the request
+// and its tokens are taken from the buckets only if it is within both limits
+func (l *rateLimiter) take(key string, rpm int, tpm int, nTokens int, now time.Time) rateLimitResult {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	buckets, ok := l.buckets[key]
+	if !ok {
+		buckets = &rateLimitBuckets{}
+		if rpm > 0 {
+			buckets.requests = newTokenBucket(rpm, now)
+		}
+		if tpm > 0 {
+			buckets.tokens = newTokenBucket(tpm, now)
+		}
+		l.buckets[key] = buckets
+	}
+
+	result := rateLimitResult{allowed: true, limitRequests: rpm, limitTokens: tpm, nTokens: nTokens}
+	if buckets.requests != nil {
+		buckets.requests.refill(now)
+		if wait := buckets.requests.timeUntil(1); wait > 0 {
+			result.allowed = false
+			result.exceeded = "requests"
+			result.retryAfter = wait
+		}
+	}
+	if buckets.tokens != nil {
+		buckets.tokens.refill(now)
+		if wait := buckets.tokens.timeUntil(float64(nTokens)); wait > 0 && wait > result.retryAfter {
+			result.allowed = false
+			result.exceeded = "tokens"
+			result.retryAfter = wait
+		}
+	}
+
+	if result.allowed {
+		if buckets.requests != nil {
+			buckets.requests.tokens--
+		}
+		if buckets.tokens != nil {
+			buckets.tokens.tokens -= float64(nTokens)
+		}
+	}
+	if buckets.requests != nil {
+		result.remainingRequests = buckets.requests.remaining()
+		result.resetRequests = buckets.requests.timeUntil(buckets.requests.capacity)
+	}
+	if buckets.tokens != nil {
+		result.remainingTokens = buckets.tokens.remaining()
+		result.resetTokens = buckets.tokens.timeUntil(buckets.tokens.capacity)
+	}
+	return result
+}
+
+// rateLimitKey returns the key the rate limits of the request are applied by
+func rateLimitKey(config *common.Configuration, ctx *fasthttp.RequestCtx, model string) string {
+	apiKey := getAPIKey(ctx)
+	switch config.RateLimitBy {
+	case common.RateLimitByAPIKey:
+		return apiKey
+	case common.RateLimitByModelAndAPIKey:
+		return model + "/" + apiKey
+	default:
+		return model
+	}
+}
+
+// isRateLimited returns true if rate limits are configured
+func isRateLimited(config *common.Configuration) bool {
+	return config.RateLimitRequestsPerMinute > 0 || config.RateLimitTokensPerMinute > 0
+}
+
+// checkRateLimits applies the rate limits to the request and sets the rate limit headers of its response,
+// returns the error to send if the request exceeds a limit, nil otherwise
+func (s *VllmSimulator) checkRateLimits(ctx *fasthttp.RequestCtx,
+	req openaiserverapi.CompletionRequest) *openaiserverapi.CompletionError {
+	nTokens := req.GetNumberOfPromptTokens()
+	if maxTokens := req.GetMaxCompletionTokens(); maxTokens != nil {
+		nTokens += int(*maxTokens)
+	}
+	result := s.rateLimiter.take(rateLimitKey(s.config, ctx, req.GetModel()), s.config.RateLimitRequestsPerMinute,
+		s.config.RateLimitTokensPerMinute, nTokens, time.Now())
+	setRateLimitHeaders(ctx, result)
+	if result.allowed {
+		return nil
+	}
+
+	s.logger.V(4).Info("Rate limit exceeded", "request id", req.GetRequestID(), "limit", result.exceeded,
+		"retry after", result.retryAfter.String())
+	ctx.Response.Header.Set(retryAfterHeader, strconv.Itoa(int(math.Ceil(result.retryAfter.Seconds()))))
+	limit, remaining, requested, unit := result.limitRequests, result.remainingRequests, 1, "RPM"
+	if result.exceeded == "tokens" {
+		limit, remaining, requested, unit = result.limitTokens, result.remainingTokens, result.nTokens, "TPM"
+	}
+	compErr := openaiserverapi.NewCompletionError(fmt.Sprintf(rateLimitExceededMessageTemplate, req.GetModel(),
+		result.exceeded, unit, limit, remaining, requested, formatRateLimitDuration(result.retryAfter)),
+		fasthttp.StatusTooManyRequests, nil)
+	return &compErr
+}
+
+// setRateLimitHeaders sets the OpenAI rate limit headers of the limits that are set
+func setRateLimitHeaders(ctx *fasthttp.RequestCtx, result rateLimitResult) {
+	if result.limitRequests > 0 {
+		ctx.Response.Header.Set(rateLimitLimitRequestsHeader, strconv.Itoa(result.limitRequests))
+		ctx.Response.Header.Set(rateLimitRemainingRequestsHeader, strconv.Itoa(result.remainingRequests))
+		ctx.Response.Header.Set(rateLimitResetRequestsHeader, formatRateLimitDuration(result.resetRequests))
+	}
+	if result.limitTokens > 0 {
+		ctx.Response.Header.Set(rateLimitLimitTokensHeader, strconv.Itoa(result.limitTokens))
+		ctx.Response.Header.Set(rateLimitRemainingTokensHeader, strconv.Itoa(result.remainingTokens))
+		ctx.Response.Header.Set(rateLimitResetTokensHeader, formatRateLimitDuration(result.resetTokens))
+	}
+}
+
+// formatRateLimitDuration formats the duration like the OpenAI reset headers, e.g. 1s, 6m0s, 20ms
+func formatRateLimitDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rate limits", func() {
+	Context("token buckets", func() {
+		start := time.Now()
+
+		It("should limit the requests per minute", func() {
+			limiter := newRateLimiter()
+			for i := range 2 {
+				result := limiter.take("model", 2, 0, 10, start)
+				Expect(result.allowed).To(BeTrue())
+				Expect(result.remainingRequests).To(Equal(1 - i))
+			}
+			result := limiter.take("model", 2, 0, 10, start)
+			Expect(result.allowed).To(BeFalse())
+			Expect(result.exceeded).To(Equal("requests"))
+			Expect(result.retryAfter).To(Equal(30 * time.Second))
+			Expect(result.resetRequests).To(Equal(time.Minute))
+
+			// another key has its own buckets
+			Expect(limiter.take("other", 2, 0, 10, start).allowed).To(BeTrue())
+
+			// a request is refilled every 30 seconds
+			result = limiter.take("model", 2, 0, 10, start.Add(30*time.Second))
+			Expect(result.allowed).To(BeTrue())
+			Expect(result.remainingRequests).To(Equal(0))
+		})
+
+		It("should limit the tokens per minute", func() {
+			limiter := newRateLimiter()
+			result := limiter.take("model", 10, 100, 60, start)
+			Expect(result.allowed).To(BeTrue())
+			Expect(result.remainingTokens).To(Equal(40))
+
+			result = limiter.take("model", 10, 100, 60, start)
+			Expect(result.allowed).To(BeFalse())
+			Expect(result.exceeded).To(Equal("tokens"))
+			Expect(result.retryAfter).To(Equal(12 * time.Second))
+			// a rejected request takes nothing from the buckets
+			Expect(result.remainingRequests).To(Equal(9))
+			Expect(result.remainingTokens).To(Equal(40))
+
+			Expect(limiter.take("model", 10, 100, 60, start.Add(12*time.Second)).allowed).To(BeTrue())
+		})
+
+		It("should format the reset durations like OpenAI", func() {
+			Expect(formatRateLimitDuration(20 * time.Millisecond)).To(Equal("20ms"))
+			Expect(formatRateLimitDuration(1500 * time.Millisecond)).To(Equal("2s"))
+			Expect(formatRateLimitDuration(6 * time.Minute)).To(Equal("6m0s"))
+		})
+	})
+
+	It("should reject the requests over the limit of an api key with status 429", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--rate-limit-rpm", "2",
+			"--rate-limit-by", common.RateLimitByAPIKey}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		send := func(apiKey string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, "http://localhost/v1/completions",
+				strings.NewReader(`{"model": "`+model+`", "prompt": "Hello", "max_tokens": 2}`))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			if resp.StatusCode == http.StatusTooManyRequests {
+				Expect(string(body)).To(ContainSubstring("Rate limit reached"))
+			}
+			return resp
+		}
+
+		resp := send("key-1")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get(rateLimitLimitRequestsHeader)).To(Equal("2"))
+		Expect(resp.Header.Get(rateLimitRemainingRequestsHeader)).To(Equal("1"))
+		Expect(resp.Header.Get(rateLimitLimitTokensHeader)).To(BeEmpty())
+
+		Expect(send("key-1").StatusCode).To(Equal(http.StatusOK))
+		resp = send("key-1")
+		Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(resp.Header.Get(retryAfterHeader)).To(Equal("30"))
+		Expect(resp.Header.Get(rateLimitRemainingRequestsHeader)).To(Equal("0"))
+
+		Expect(send("key-2").StatusCode).To(Equal(http.StatusOK))
+	})
+})
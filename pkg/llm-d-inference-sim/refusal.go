@@ -0,0 +1,46 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// defaultRefusals are used when no refusal messages are configured
+var defaultRefusals = []string{
+	"I am sorry, but I cannot help with that.",
+	"I am unable to assist with this request.",
+	"I cannot provide that information.",
+}
+
+// shouldRefuse determines whether to refuse a chat completion request based on the refusal rate
+func shouldRefuse(config *common.Configuration, random *common.Random) bool {
+	if config.RefusalRate == 0 {
+		return false
+	}
+
+	return random.Int(1, 100) <= config.RefusalRate
+}
+
+// getRandomRefusal returns a random refusal from the configured refusal messages or the default refusals
+func getRandomRefusal(config *common.Configuration, random *common.Random) string {
+	refusals := config.RefusalMessages
+	if len(refusals) == 0 {
+		refusals = defaultRefusals
+	}
+	return refusals[random.Int(0, len(refusals)-1)]
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+)
+
+const testRefusal = "I cannot help with that request."
+
+func startRefusingServer(ctx context.Context) (openai.Client, openai.ChatCompletionNewParams) {
+	args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+		"--refusal-rate", "100", "--refusal-messages", testRefusal}
+	client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+	Expect(err).NotTo(HaveOccurred())
+	openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+	return openaiclient, params
+}
+
+var _ = Describe("Refusals", func() {
+	DescribeTable("should refuse non-streaming chat completions",
+		func(withTools bool) {
+			ctx := context.TODO()
+			openaiclient, params := startRefusingServer(ctx)
+			if withTools {
+				params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+				params.Tools = tools
+			}
+
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(1))
+			message := resp.Choices[0].Message
+			Expect(message.Refusal).To(Equal(testRefusal))
+			Expect(message.Content).To(BeEmpty())
+			Expect(message.ToolCalls).To(BeEmpty())
+			Expect(resp.Choices[0].FinishReason).To(Equal(dataset.StopFinishReason))
+			Expect(resp.Usage.CompletionTokens).To(BeNumerically("==", len(common.Tokenize(testRefusal))))
+		},
+		func(withTools bool) string {
+			return fmt.Sprintf("with tools: %t", withTools)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	DescribeTable("should refuse streaming chat completions",
+		func(withTools bool) {
+			ctx := context.TODO()
+			openaiclient, params := startRefusingServer(ctx)
+			params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: param.NewOpt(true)}
+			if withTools {
+				params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+				params.Tools = tools
+			}
+
+			stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+			defer func() {
+				err := stream.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			var refusal strings.Builder
+			var finishReason string
+			var completionTokens int64
+			for stream.Next() {
+				chunk := stream.Current()
+				for _, choice := range chunk.Choices {
+					Expect(choice.Delta.Content).To(BeEmpty())
+					Expect(choice.Delta.ToolCalls).To(BeEmpty())
+					refusal.WriteString(choice.Delta.Refusal)
+					if choice.FinishReason != "" {
+						finishReason = choice.FinishReason
+					}
+				}
+				if chunk.Usage.CompletionTokens != 0 {
+					completionTokens = chunk.Usage.CompletionTokens
+				}
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(refusal.String()).To(Equal(testRefusal))
+			Expect(finishReason).To(Equal(dataset.StopFinishReason))
+			Expect(completionTokens).To(BeNumerically("==", len(common.Tokenize(testRefusal))))
+		},
+		func(withTools bool) string {
+			return fmt.Sprintf("with tools: %t", withTools)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	It("should not refuse text completions", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--refusal-rate", "100"}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+		resp, err := openaiclient.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices).To(HaveLen(1))
+		Expect(resp.Choices[0].Text).To(Equal(userMessage))
+	})
+
+	It("should use the default refusals", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--refusal-rate", "100"}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defaultRefusals).To(ContainElement(resp.Choices[0].Message.Refusal))
+		Expect(resp.Choices[0].Message.Content).To(BeEmpty())
+	})
+})
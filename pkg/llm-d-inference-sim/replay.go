@@ -0,0 +1,227 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Replay of the requests of a replay file at startup
+package llmdinferencesim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	replayChatEndpoint      = "/v1/chat/completions"
+	replayTextEndpoint      = "/v1/completions"
+	replayResponsesEndpoint = "/v1/responses"
+)
+
+// replayRecord is a request of the replay file
+type replayRecord struct {
+	// OffsetMs is the time since the start of the replay at which the request is issued, in milliseconds
+	OffsetMs int64 `json:"offset_ms"`
+	// Endpoint is the path of the request, /v1/completions, /v1/chat/completions or /v1/responses
+	Endpoint string `json:"endpoint"`
+	// Body is the body of the request
+	Body json.RawMessage `json:"body"`
+	// Timestamp is the time a recorded request was received, it is not used by the replay
+	Timestamp string `json:"timestamp,omitempty"`
+	// line is the line number of the request in the replay file
+	line int
+}
+
+// parseReplayRecord parses a line of the replay file
+func parseReplayRecord(data []byte) (replayRecord, error) {
+	var record replayRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, err
+	}
+	if record.Endpoint != replayChatEndpoint && record.Endpoint != replayTextEndpoint &&
+		record.Endpoint != replayResponsesEndpoint {
+		return record, fmt.Errorf("unsupported endpoint '%s', must be %s, %s or %s", record.Endpoint,
+			replayTextEndpoint, replayChatEndpoint, replayResponsesEndpoint)
+	}
+	if record.OffsetMs < 0 {
+		return record, errors.New("offset_ms cannot be negative")
+	}
+	if len(record.Body) == 0 {
+		return record, errors.New("body is missing")
+	}
+	return record, nil
+}
+
+// loadReplayFile reads the requests of the replay file sorted by their offset, invalid lines are logged and skipped
+func (s *VllmSimulator) loadReplayFile(path string) ([]replayRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			s.logger.Error(err, "failed to close replay file")
+		}
+	}()
+
+	records := make([]replayRecord, 0)
+	scanner := bufio.NewScanner(file)
+	// a line contains a whole request body
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		record, err := parseReplayRecord(line)
+		if err != nil {
+			s.logger.Error(err, "Invalid replay file line, the line is skipped", "file", path, "line", lineNumber)
+			continue
+		}
+		record.line = lineNumber
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].OffsetMs < records[j].OffsetMs
+	})
+	return records, nil
+}
+
+// startReplay loads the replay file, if configured, and starts replaying its requests
+func (s *VllmSimulator) startReplay(ctx context.Context) error {
+	if s.config.ReplayFile == "" {
+		return nil
+	}
+	records, err := s.loadReplayFile(s.config.ReplayFile)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Replaying requests", "file", s.config.ReplayFile, "requests", len(records),
+		"speed", s.config.ReplaySpeed)
+	go s.replay(ctx, records)
+	return nil
+}
+
+// replay issues the given requests at their offsets from now, divided by the replay speed
+func (s *VllmSimulator) replay(ctx context.Context, records []replayRecord) {
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, record := range records {
+		offset := time.Duration(float64(record.OffsetMs) / s.config.ReplaySpeed * float64(time.Millisecond))
+		timer := time.NewTimer(time.Until(start.Add(offset)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		wg.Add(1)
+		go func(record replayRecord) {
+			defer wg.Done()
+			s.replayRequest(record)
+		}(record)
+	}
+	wg.Wait()
+	s.logger.Info("Replay finished", "file", s.config.ReplayFile, "requests", len(records),
+		"duration", time.Since(start))
+}
+
+// replayRequest processes the given request like a request received by the server and records its outcome
+func (s *VllmSimulator) replayRequest(record replayRecord) {
+	var req fasthttp.Request
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetRequestURI(record.Endpoint)
+	req.SetBody(record.Body)
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, s)
+	isResponses := record.Endpoint == replayResponsesEndpoint
+	isChatCompletion := record.Endpoint == replayChatEndpoint || isResponses
+	// a replayed request is written to the access log like a request received by the server
+	s.withAccessLog(record.Endpoint, func(ctx *fasthttp.RequestCtx) {
+		s.handleCompletions(ctx, isChatCompletion, isResponses)
+	})(&ctx)
+
+	// reading the body of a streaming response waits for the end of the stream
+	body := ctx.Response.Body()
+	statusCode := ctx.Response.StatusCode()
+	usage := getResponseUsage(body, ctx.Response.Header.ContentType())
+	if usage != nil {
+		s.logger.Info("Replayed request", "line", record.line, "endpoint", record.Endpoint,
+			"status code", statusCode, "prompt tokens", usage.PromptTokens,
+			"completion tokens", usage.CompletionTokens)
+	} else {
+		s.logger.Info("Replayed request", "line", record.line, "endpoint", record.Endpoint,
+			"status code", statusCode)
+	}
+	s.reportReplayedRequest(statusCode, usage)
+}
+
+// getResponseUsage returns the usage of a completion response with the given body and content type,
+// nil if the response has no usage
+func getResponseUsage(body []byte, contentType []byte) *openaiserverapi.Usage {
+	var resp struct {
+		Usage *openaiserverapi.Usage `json:"usage"`
+	}
+	if !bytes.HasPrefix(contentType, []byte("text/event-stream")) {
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil
+		}
+		return resp.Usage
+	}
+	// the usage is sent in a separate chunk at the end of the stream
+	var usage *openaiserverapi.Usage
+	for _, event := range bytes.Split(body, []byte("\n\n")) {
+		data, found := bytes.CutPrefix(bytes.TrimSpace(event), []byte("data: "))
+		if !found {
+			continue
+		}
+		resp.Usage = nil
+		if err := json.Unmarshal(data, &resp); err == nil && resp.Usage != nil {
+			usage = resp.Usage
+		}
+	}
+	return usage
+}
+
+// reportReplayedRequest updates the replay metrics with the outcome of a replayed request
+func (s *VllmSimulator) reportReplayedRequest(statusCode int, usage *openaiserverapi.Usage) {
+	if s.replayRequests == nil {
+		// Happens in the tests
+		return
+	}
+	s.replayRequests.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	if usage != nil {
+		s.replayPromptTokens.Add(float64(usage.PromptTokens))
+		s.replayCompletionTokens.Add(float64(usage.CompletionTokens))
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	writeReplayFile := func(lines ...string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "replay.jsonl")
+		err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+		Expect(err).NotTo(HaveOccurred())
+		return path
+	}
+
+	getMetrics := func(client *http.Client) string {
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	getReplayedRequests := func(client *http.Client, statusCode int) float64 {
+		metrics := getMetrics(client)
+		metric := fmt.Sprintf(`sim_replay_requests_total{status_code="%d"}`, statusCode)
+		if !strings.Contains(metrics, metric) {
+			return 0
+		}
+		return getGaugeValue(metrics, metric)
+	}
+
+	// every request is a single line of the replay file
+	chatLine := func(offsetMs int, content string, stream bool) string {
+		return fmt.Sprintf(`{"offset_ms": %d, "endpoint": "/v1/chat/completions", "body": {"model": "%s", `+
+			`"messages": [{"role": "user", "content": "%s"}], "stream": %t, `+
+			`"stream_options": {"include_usage": true}}}`, offsetMs, model, content, stream)
+	}
+	textLine := func(offsetMs int, prompt string, stream bool, maxTokens int) string {
+		return fmt.Sprintf(`{"offset_ms": %d, "endpoint": "/v1/completions", "body": {"model": "%s", `+
+			`"prompt": "%s", "stream": %t, "max_tokens": %d, "stream_options": {"include_usage": true}}}`,
+			offsetMs, model, prompt, stream, maxTokens)
+	}
+
+	It("should replay the requests of the replay file", func() {
+		prompts := []string{"Hello world", "How are you today?", "This is a test.", "What is the weather in Haifa?",
+			"one two three four five"}
+		path := writeReplayFile(
+			// the lines are not sorted by their offset
+			textLine(200, prompts[2], true, 100),
+			chatLine(0, prompts[0], false),
+			textLine(100, prompts[1], false, 100),
+			chatLine(300, prompts[3], true),
+			textLine(400, prompts[4], false, 2),
+		)
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--replay-file", path}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() float64 {
+			return getReplayedRequests(client, http.StatusOK)
+		}, 3*time.Second, 50*time.Millisecond).Should(Equal(5.0))
+
+		expectedPromptTokens := 0
+		for _, prompt := range prompts {
+			expectedPromptTokens += len(common.Tokenize(prompt))
+		}
+		// the last request is limited to 2 tokens
+		expectedCompletionTokens := expectedPromptTokens - len(common.Tokenize(prompts[4])) + 2
+
+		metrics := getMetrics(client)
+		Expect(getGaugeValue(metrics, "sim_replay_prompt_tokens_total")).To(Equal(float64(expectedPromptTokens)))
+		Expect(getGaugeValue(metrics, "sim_replay_completion_tokens_total")).To(Equal(float64(expectedCompletionTokens)))
+	})
+
+	It("should write the replayed requests to the access log", func() {
+		path := writeReplayFile(
+			chatLine(0, "Hello world", false),
+			chatLine(50, "How are you today?", true),
+			textLine(100, "This is a test.", false, 100),
+		)
+		accessLogFile := filepath.Join(GinkgoT().TempDir(), "access.log")
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--replay-file", path,
+			"--enable-access-log", "--access-log-file", accessLogFile}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() float64 {
+			return getReplayedRequests(client, http.StatusOK)
+		}, 3*time.Second, 50*time.Millisecond).Should(Equal(3.0))
+
+		// the metrics requests of the test are logged as well
+		var replayed []map[string]any
+		for _, entry := range readAccessLog(accessLogFile) {
+			if entry["method"] == http.MethodPost {
+				replayed = append(replayed, entry)
+			}
+		}
+		Expect(replayed).To(HaveLen(3))
+		for _, entry := range replayed {
+			Expect(entry["endpoint"]).To(BeElementOf("/v1/chat/completions", "/v1/completions"))
+			Expect(entry["status"]).To(BeNumerically("==", http.StatusOK))
+			Expect(entry["model"]).To(Equal(model))
+			Expect(entry["prompt_tokens"]).To(BeNumerically(">", 0))
+		}
+	})
+
+	It("should skip invalid lines and record failed requests", func() {
+		path := writeReplayFile(
+			`{"offset_ms": 0, "endpoint": "/v1/chat/completions", "body": `,
+			`{"offset_ms": 0, "endpoint": "/v1/embeddings", "body": {"model": "my_model", "input": "hi"}}`,
+			`{"offset_ms": -10, "endpoint": "/v1/completions", "body": {"model": "my_model", "prompt": "hi"}}`,
+			`{"offset_ms": 0, "endpoint": "/v1/completions", "body": {"model": "unknown", "prompt": "hi"}}`,
+			textLine(0, userMessage, false, 100),
+		)
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--replay-file", path}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() float64 {
+			return getReplayedRequests(client, http.StatusOK) + getReplayedRequests(client, http.StatusNotFound)
+		}, 3*time.Second, 50*time.Millisecond).Should(Equal(2.0))
+		Consistently(func() float64 {
+			return getReplayedRequests(client, http.StatusOK)
+		}, 500*time.Millisecond, 100*time.Millisecond).Should(Equal(1.0))
+		Expect(getReplayedRequests(client, http.StatusNotFound)).To(Equal(1.0))
+	})
+
+	It("should scale the offsets by the replay speed", func() {
+		path := writeReplayFile(textLine(0, userMessage, false, 100), textLine(4000, userMessage, false, 100))
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--replay-file", path,
+			"--replay-speed", "10"}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() float64 {
+			return getReplayedRequests(client, http.StatusOK)
+		}, 1500*time.Millisecond, 50*time.Millisecond).Should(Equal(2.0))
+	})
+
+	It("should produce the same metrics totals with the same seed", func() {
+		lines := make([]string, 0)
+		for i := range 5 {
+			lines = append(lines, chatLine(i*100, userMessage, i%2 == 0))
+		}
+		path := writeReplayFile(lines...)
+
+		replay := func() string {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--seed", "100",
+				"--replay-file", path}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() float64 {
+				return getReplayedRequests(client, http.StatusOK)
+			}, 3*time.Second, 50*time.Millisecond).Should(Equal(5.0))
+			metrics := getMetrics(client)
+			return fmt.Sprintf("%f %f", getGaugeValue(metrics, "sim_replay_prompt_tokens_total"),
+				getGaugeValue(metrics, "sim_replay_completion_tokens_total"))
+		}
+		Expect(replay()).To(Equal(replay()))
+	})
+
+	It("should record the requests and replay the recorded trace", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "trace.jsonl")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--record-trace", path}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		bodies := []string{
+			`{"model": "` + model + `", "prompt": "Hello world", "max_tokens": 100}`,
+			`{"model": "` + model + `", "messages": [{"role": "user", "content": "How are you?"}]}`,
+		}
+		for i, endpoint := range []string{replayTextEndpoint, replayChatEndpoint} {
+			time.Sleep(200 * time.Millisecond)
+			resp, err := client.Post("http://localhost"+endpoint, "application/json",
+				strings.NewReader(strings.ReplaceAll(bodies[i], ", ", ",\n  ")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		Expect(lines).To(HaveLen(2))
+		first, err := parseReplayRecord([]byte(lines[0]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Endpoint).To(Equal(replayTextEndpoint))
+		Expect(first.Body).To(MatchJSON(bodies[0]))
+		Expect(first.Timestamp).NotTo(BeEmpty())
+		second, err := parseReplayRecord([]byte(lines[1]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Endpoint).To(Equal(replayChatEndpoint))
+		Expect(second.Body).To(MatchJSON(bodies[1]))
+		// the offsets keep the inter-arrival time of the requests
+		Expect(second.OffsetMs - first.OffsetMs).To(BeNumerically(">=", 200))
+
+		args = []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--replay-file", path}
+		replayClient, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() float64 {
+			return getReplayedRequests(replayClient, http.StatusOK)
+		}, 3*time.Second, 50*time.Millisecond).Should(Equal(2.0))
+	})
+
+	It("should add the rank to the output files of a data parallel rank", func() {
+		Expect(rankFilePath("/tmp/trace.jsonl", 2)).To(Equal("/tmp/trace-rank2.jsonl"))
+		Expect(rankFilePath("trace", 1)).To(Equal("trace-rank1"))
+	})
+
+	It("should fail to start with a missing replay file", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+			"--replay-file", filepath.Join(GinkgoT().TempDir(), "missing.jsonl")}
+		_, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
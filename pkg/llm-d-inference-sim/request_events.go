@@ -0,0 +1,225 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+const (
+	// the types of the request lifecycle events
+	RequestEventQueued   = "queued"
+	RequestEventStarted  = "started"
+	RequestEventFinished = "finished"
+	RequestEventFailed   = "failed"
+
+	// requestEventsChanSize is the number of events that wait to be published, newer events are dropped
+	requestEventsChanSize = 10000
+	// streamFailureMessage is the failure of a request whose stream failed by an injected failure
+	streamFailureMessage = "the stream failed"
+)
+
+// RequestEvent is a lifecycle event of a completion request: the request was added to the waiting queue,
+// started running, or ended with a response or with a failure. The events are encoded by msgpack as arrays
+// of their fields in this order
+type RequestEvent struct {
+	// Type is the type of the event, queued, started, finished or failed
+	Type string
+	// RequestID is the id of the request
+	RequestID string
+	// Model is the model of the request, as reported in the metrics
+	Model string
+	// Timestamp is the time of the event in seconds since the epoch
+	Timestamp float64
+	// PromptTokens is the number of tokens in the prompt
+	PromptTokens int
+	// CompletionTokens is the number of generated tokens, set in the finished and failed events
+	CompletionTokens int
+	// QueueTimeMs is the time the request waited in the queue, set from the started event on,
+	// zero if the request didn't start
+	QueueTimeMs float64
+	// TTFTMs is the time from the enqueue of the request to its first token, set in the finished
+	// and failed events, zero if no token was generated
+	TTFTMs float64
+	// E2ELatencyMs is the time from the enqueue of the request to its end, set in the finished and failed events
+	E2ELatencyMs float64
+	// FinishReasons are the finish reasons of the choices, set in the finished and failed events
+	FinishReasons []string
+	// Error is the reason of the failure, set in the failed event
+	Error string
+}
+
+// RequestEventBatch is a batch of request lifecycle events, published to the request events topic
+type RequestEventBatch struct {
+	// TS is the time the batch was published in seconds since the epoch
+	TS float64
+	// Events are the events in the order they happened
+	Events []RequestEvent
+	// DataParallelRank is the rank of the simulator that published the events
+	DataParallelRank int
+}
+
+// requestEventPublisher publishes the batches of events to a topic
+type requestEventPublisher interface {
+	PublishEvent(ctx context.Context, topic string, batch interface{}) error
+}
+
+// startRequestEvents starts the publishing of the request lifecycle events to the ZMQ endpoint if it is enabled
+func (s *VllmSimulator) startRequestEvents(ctx context.Context) error {
+	if !s.config.EnableRequestEvents {
+		return nil
+	}
+	publisher, err := common.NewPublisher(s.config.ZMQEndpoint, s.config.ZMQMaxConnectAttempts)
+	if err != nil {
+		return err
+	}
+	s.requestEvents = make(chan RequestEvent, requestEventsChanSize)
+	topic := requestEventsTopic(s.config)
+	s.logger.Info("Publishing request events", "endpoint", s.config.ZMQEndpoint, "topic", topic)
+	go func() {
+		s.sendRequestEvents(ctx, publisher, topic)
+		if err := publisher.Close(); err != nil {
+			s.logger.Error(err, "failed to close the request events publisher")
+		}
+	}()
+	return nil
+}
+
+// requestEventsTopic returns the topic of the request lifecycle events
+func requestEventsTopic(config *common.Configuration) string {
+	return fmt.Sprintf("requests@localhost:%d@%s", config.Port, config.Model)
+}
+
+// sendRequestEvents publishes the request events until ctx is done, the events that are available
+// together are published in a batch of up to event-batch-size events
+func (s *VllmSimulator) sendRequestEvents(ctx context.Context, publisher requestEventPublisher, topic string) {
+	publish := func(events []RequestEvent) {
+		batch := RequestEventBatch{
+			TS:               float64(s.externalNow().UnixNano()) / 1e9,
+			Events:           events,
+			DataParallelRank: s.dpRank,
+		}
+		if err := publisher.PublishEvent(ctx, topic, batch); err != nil {
+			s.logger.Error(err, "failed to publish request events", "number of events", len(events))
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.requestEvents:
+			events := []RequestEvent{event}
+		collect:
+			for len(events) < s.config.EventBatchSize {
+				select {
+				case event := <-s.requestEvents:
+					events = append(events, event)
+				default:
+					break collect
+				}
+			}
+			publish(events)
+		}
+	}
+}
+
+// publishRequestEvent publishes an event of the given type of a tracked request
+func (s *VllmSimulator) publishRequestEvent(eventType string, requestID string) {
+	if s.requestEvents == nil {
+		return
+	}
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	s.sendRequestEvent(s.newRequestEvent(eventType, value.(*inFlightRequest)))
+}
+
+// publishRequestEnd publishes the end of a tracked request, a finished event if the request has no failure,
+// otherwise a failed event
+func (s *VllmSimulator) publishRequestEnd(requestID string, nCompletionTokens int, finishReasons []string,
+	failure string) {
+	if s.requestEvents == nil {
+		return
+	}
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	eventType := RequestEventFinished
+	if failure != "" {
+		eventType = RequestEventFailed
+	}
+	event := s.newRequestEvent(eventType, req)
+	event.CompletionTokens = nCompletionTokens
+	event.FinishReasons = finishReasons
+	event.Error = failure
+	req.mutex.RLock()
+	if !req.firstTokenTime.IsZero() {
+		event.TTFTMs = durationMs(s.virtualDuration(req.firstTokenTime.Sub(req.enqueueTime)))
+	}
+	req.mutex.RUnlock()
+	event.E2ELatencyMs = durationMs(s.virtualDuration(time.Since(req.enqueueTime)))
+	s.sendRequestEvent(event)
+}
+
+// newRequestEvent creates an event of the given type of the request with the fields that are common to all the types
+func (s *VllmSimulator) newRequestEvent(eventType string, req *inFlightRequest) RequestEvent {
+	event := RequestEvent{
+		Type:         eventType,
+		RequestID:    req.requestID,
+		Model:        s.getDisplayedModelName(req.model),
+		Timestamp:    float64(s.externalNow().UnixNano()) / 1e9,
+		PromptTokens: req.promptTokens,
+	}
+	req.mutex.RLock()
+	defer req.mutex.RUnlock()
+	if !req.startTime.IsZero() {
+		event.QueueTimeMs = durationMs(s.virtualDuration(req.startTime.Sub(req.enqueueTime)))
+	}
+	return event
+}
+
+// sendRequestEvent queues the event for publishing, the event is dropped if too many events wait
+func (s *VllmSimulator) sendRequestEvent(event RequestEvent) {
+	select {
+	case s.requestEvents <- event:
+	default:
+		s.logger.V(4).Info("Too many request events wait to be published, the event is dropped",
+			"type", event.Type, "request id", event.RequestID)
+	}
+}
+
+// streamFailure returns the failure of a stream that has just ended, empty if the stream succeeded
+func streamFailure(context *streamingContext) string {
+	switch {
+	case context.failed:
+		return streamFailureMessage
+	case context.aborted:
+		return errRequestAborted.Error()
+	}
+	return ""
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// fakeEventPublisher keeps the published batches of request events
+type fakeEventPublisher struct {
+	mutex   sync.Mutex
+	topics  []string
+	batches []RequestEventBatch
+}
+
+func (p *fakeEventPublisher) PublishEvent(_ context.Context, topic string, batch interface{}) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.topics = append(p.topics, topic)
+	p.batches = append(p.batches, batch.(RequestEventBatch))
+	return nil
+}
+
+// getEvents returns the published events of the given request
+func (p *fakeEventPublisher) getEvents(requestID string) []RequestEvent {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	events := make([]RequestEvent, 0)
+	for _, batch := range p.batches {
+		for _, event := range batch.Events {
+			if event.RequestID == requestID {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// getRequestIDs returns the ids of the requests with published events in the order of their first event
+func (p *fakeEventPublisher) getRequestIDs() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ids := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, batch := range p.batches {
+		for _, event := range batch.Events {
+			if !seen[event.RequestID] {
+				seen[event.RequestID] = true
+				ids = append(ids, event.RequestID)
+			}
+		}
+	}
+	return ids
+}
+
+var _ = Describe("Request events", func() {
+	It("should publish the lifecycle events of the requests", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--time-to-first-token", "50"}
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config, err = common.ParseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+		client, err := startSimulator(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+
+		publisher := &fakeEventPublisher{}
+		s.requestEvents = make(chan RequestEvent, requestEventsChanSize)
+		go s.sendRequestEvents(ctx, publisher, requestEventsTopic(s.config))
+
+		for _, stream := range []bool{false, true} {
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, stream)
+			if stream {
+				chatStream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+				for chatStream.Next() {
+				}
+				Expect(chatStream.Err()).NotTo(HaveOccurred())
+			} else {
+				_, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+
+		var ids []string
+		Eventually(func() []string {
+			ids = publisher.getRequestIDs()
+			return ids
+		}, 2*time.Second, 10*time.Millisecond).Should(HaveLen(2))
+		for _, id := range ids {
+			var events []RequestEvent
+			Eventually(func() []RequestEvent {
+				events = publisher.getEvents(id)
+				return events
+			}, 2*time.Second, 10*time.Millisecond).Should(HaveLen(3))
+			Expect(events[0].Type).To(Equal(RequestEventQueued))
+			Expect(events[1].Type).To(Equal(RequestEventStarted))
+			finished := events[2]
+			Expect(finished.Type).To(Equal(RequestEventFinished))
+			Expect(finished.Model).To(Equal(model))
+			Expect(finished.PromptTokens).To(BeNumerically(">", 0))
+			Expect(finished.CompletionTokens).To(Equal(int(userMsgTokens)))
+			Expect(finished.FinishReasons).To(Equal([]string{"stop"}))
+			Expect(finished.TTFTMs).To(BeNumerically(">=", 50))
+			Expect(finished.E2ELatencyMs).To(BeNumerically(">=", finished.TTFTMs))
+			Expect(finished.Error).To(BeEmpty())
+		}
+		Expect(publisher.topics[0]).To(Equal("requests@localhost:8000@" + model))
+	})
+
+	It("should publish a failed event and batch the available events", func() {
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config = &common.Configuration{Model: model, ServedModelNames: []string{model}, EventBatchSize: 2}
+		s.requestEvents = make(chan RequestEvent, requestEventsChanSize)
+
+		req := &openaiserverapi.TextCompletionRequest{BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{
+			RequestID: "request-1", Model: model}}
+		s.addInFlightRequest(req, "", trace.SpanContext{}, "", nil)
+		s.publishRequestEvent(RequestEventQueued, "request-1")
+		s.startInFlightRequest("request-1", 1)
+		s.publishRequestEvent(RequestEventStarted, "request-1")
+		s.publishRequestEnd("request-1", 3, abortFinishReasons(1), errRequestAborted.Error())
+
+		publisher := &fakeEventPublisher{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.sendRequestEvents(ctx, publisher, "topic")
+		Eventually(func() int {
+			return len(publisher.getEvents("request-1"))
+		}, time.Second, 10*time.Millisecond).Should(Equal(3))
+		Expect(publisher.batches).To(HaveLen(2))
+		Expect(publisher.batches[0].Events).To(HaveLen(2))
+
+		failed := publisher.batches[1].Events[0]
+		Expect(failed.Type).To(Equal(RequestEventFailed))
+		Expect(failed.CompletionTokens).To(Equal(3))
+		Expect(failed.FinishReasons).To(Equal([]string{abortFinishReason}))
+		Expect(failed.Error).To(Equal(errRequestAborted.Error()))
+		Expect(failed.TTFTMs).To(BeZero())
+	})
+})
@@ -0,0 +1,149 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// responseGeneratorTimeout is the maximal duration of a request to the response generator webhook
+const responseGeneratorTimeout = 30 * time.Second
+
+// ErrNoGeneratedResponse is returned by a response generator that doesn't generate the response
+// of a request, the response is generated by the dataset
+var ErrNoGeneratedResponse = errors.New("no generated response")
+
+// ResponseGenerator generates the response tokens of the requests instead of the dataset
+type ResponseGenerator interface {
+	// GenerateTokens returns the tokens and the finish reason of the response to the given request,
+	// or ErrNoGeneratedResponse to leave the request to the dataset
+	GenerateTokens(ctx context.Context, req openaiserverapi.CompletionRequest) ([]string, string, error)
+}
+
+// SetResponseGenerator sets a custom response generator, it must be called before Start.
+// The generator replaces the response-generator-url webhook
+func (s *VllmSimulator) SetResponseGenerator(generator ResponseGenerator) {
+	s.responseGenerator = generator
+}
+
+// initResponseGenerator creates the webhook response generator if its URL is configured
+// and no custom generator was set
+func (s *VllmSimulator) initResponseGenerator() {
+	if s.responseGenerator == nil && s.config.ResponseGeneratorURL != "" {
+		s.responseGenerator = newWebhookResponseGenerator(s.config.ResponseGeneratorURL)
+		s.logger.Info("Responses are generated by a webhook", "url", s.config.ResponseGeneratorURL)
+	}
+}
+
+// getResponseTokens returns the tokens and the finish reason of the response to the request, from the
+// response generator if there is one and it generates the response, otherwise from the dataset.
+// The generated response is cut at the maximal number of completion tokens of the request
+func (s *VllmSimulator) getResponseTokens(ctx context.Context, req openaiserverapi.CompletionRequest,
+	random *common.Random) ([]string, string, error) {
+	if s.responseGenerator != nil {
+		tokens, finishReason, err := s.responseGenerator.GenerateTokens(ctx, req)
+		switch {
+		case err == nil:
+			if maxTokens := req.GetMaxCompletionTokens(); maxTokens != nil && int64(len(tokens)) > *maxTokens {
+				return tokens[:*maxTokens], dataset.LengthFinishReason, nil
+			}
+			if finishReason == "" {
+				finishReason = dataset.StopFinishReason
+			}
+			return tokens, finishReason, nil
+		case !errors.Is(err, ErrNoGeneratedResponse):
+			return nil, "", fmt.Errorf("response generator failed: %w", err)
+		}
+	}
+	return s.dataset.GetTokens(req, s.config.Mode, random)
+}
+
+// webhookResponse is the response of the response generator webhook, the response tokens are
+// either the tokens or the tokenized text
+type webhookResponse struct {
+	Tokens       []string `json:"tokens"`
+	Text         *string  `json:"text"`
+	FinishReason string   `json:"finish_reason"`
+}
+
+// webhookResponseGenerator generates the responses by a webhook, the request is posted to the
+// webhook as is, the webhook returns a webhookResponse, or status 204 to leave the request to the dataset
+type webhookResponseGenerator struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookResponseGenerator(url string) *webhookResponseGenerator {
+	return &webhookResponseGenerator{url: url, client: &http.Client{Timeout: responseGeneratorTimeout}}
+}
+
+// GenerateTokens posts the request to the webhook and returns the tokens of its response
+func (g *webhookResponseGenerator) GenerateTokens(ctx context.Context,
+	req openaiserverapi.CompletionRequest) ([]string, string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		return nil, "", fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read webhook response: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, "", ErrNoGeneratedResponse
+	default:
+		return nil, "", fmt.Errorf("webhook request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var generated webhookResponse
+	if err := json.Unmarshal(respBody, &generated); err != nil {
+		return nil, "", fmt.Errorf("failed to parse webhook response: %w", err)
+	}
+	if generated.Tokens == nil && generated.Text == nil {
+		return nil, "", errors.New("webhook response has neither tokens nor text")
+	}
+	tokens := generated.Tokens
+	if tokens == nil {
+		tokens = common.Tokenize(*generated.Text)
+	}
+	return tokens, generated.FinishReason, nil
+}
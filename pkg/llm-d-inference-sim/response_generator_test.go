@@ -0,0 +1,131 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"k8s.io/klog/v2"
+)
+
+// fixedResponseGenerator answers the prompts that contain its keyword with its text
+type fixedResponseGenerator struct {
+	keyword string
+	text    string
+}
+
+func (g *fixedResponseGenerator) GenerateTokens(_ context.Context,
+	req openaiserverapi.CompletionRequest) ([]string, string, error) {
+	if !strings.Contains(req.GetFullPrompt(), g.keyword) {
+		return nil, "", ErrNoGeneratedResponse
+	}
+	return common.Tokenize(g.text), "", nil
+}
+
+// failingResponseGenerator fails every request
+type failingResponseGenerator struct{}
+
+func (g *failingResponseGenerator) GenerateTokens(_ context.Context,
+	_ openaiserverapi.CompletionRequest) ([]string, string, error) {
+	return nil, "", errors.New("generator is down")
+}
+
+var _ = Describe("Response generator", func() {
+	It("should generate the responses by the webhook", func() {
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			body, err := io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			var request map[string]any
+			Expect(json.Unmarshal(body, &request)).To(Succeed())
+			Expect(request).To(HaveKey("messages"))
+			switch {
+			case strings.Contains(string(body), "weather"):
+				_, err = w.Write([]byte(`{"text": "It is sunny today."}`))
+				Expect(err).NotTo(HaveOccurred())
+			case strings.Contains(string(body), "fail"):
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		defer webhook.Close()
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--response-generator-url", webhook.URL}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, "What is the weather?", false)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("It is sunny today."))
+		Expect(resp.Choices[0].FinishReason).To(Equal("stop"))
+
+		// the generated response is cut at the max tokens
+		params.MaxTokens = openai.Int(2)
+		resp, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("It is "))
+		Expect(resp.Choices[0].FinishReason).To(Equal("length"))
+
+		// the requests the webhook doesn't answer are echoed
+		openaiclient, params = getOpenAIClentAndChatParams(client, model, userMessage, false)
+		resp, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal(userMessage))
+
+		openaiclient, params = getOpenAIClentAndChatParams(client, model, "Please fail", false)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should use a custom response generator before the dataset", func() {
+		simulator, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		simulator.config = &common.Configuration{Mode: common.ModeEcho}
+		simulator.dataset = dataset.NewBaseDataset(simulator.random)
+		simulator.SetResponseGenerator(&fixedResponseGenerator{keyword: "hello", text: "Hi there!"})
+
+		req := &openaiserverapi.TextCompletionRequest{Prompt: "hello simulator"}
+		tokens, finishReason, err := simulator.getResponseTokens(context.TODO(), req, simulator.random)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Join(tokens, "")).To(Equal("Hi there!"))
+		Expect(finishReason).To(Equal(dataset.StopFinishReason))
+
+		req = &openaiserverapi.TextCompletionRequest{Prompt: "goodbye simulator"}
+		tokens, _, err = simulator.getResponseTokens(context.TODO(), req, simulator.random)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Join(tokens, "")).To(Equal("goodbye simulator"))
+
+		simulator.SetResponseGenerator(&failingResponseGenerator{})
+		_, _, err = simulator.getResponseTokens(context.TODO(), req, simulator.random)
+		Expect(err).To(MatchError(ContainSubstring("response generator failed")))
+	})
+})
@@ -0,0 +1,324 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	responseIDPrefix   = "resp_"
+	responseItemPrefix = "msg_"
+	responseObject     = "response"
+
+	// the types of the events of a streamed response
+	responseCreatedEvent         = "response.created"
+	responseInProgressEvent      = "response.in_progress"
+	responseCompletedEvent       = "response.completed"
+	responseIncompleteEvent      = "response.incomplete"
+	responseItemAddedEvent       = "response.output_item.added"
+	responseItemDoneEvent        = "response.output_item.done"
+	responsePartAddedEvent       = "response.content_part.added"
+	responsePartDoneEvent        = "response.content_part.done"
+	responseOutputTextDeltaEvent = "response.output_text.delta"
+	responseOutputTextDoneEvent  = "response.output_text.done"
+	responseRefusalDeltaEvent    = "response.refusal.delta"
+	responseRefusalDoneEvent     = "response.refusal.done"
+)
+
+// readResponsesRequest reads and parses the body of the given responses API request, returns the request
+// and the chat completion request that generates its response
+func (s *VllmSimulator) readResponsesRequest(ctx *fasthttp.RequestCtx) (*openaiserverapi.ResponsesRequest,
+	openaiserverapi.CompletionRequest, error) {
+	var req openaiserverapi.ResponsesRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		s.logger.Error(err, "failed to unmarshal responses request body")
+		return nil, nil, err
+	}
+	chatReq := req.ToChatCompletionRequest()
+	chatReq.RequestID = s.newRequestID(ctx)
+	return &req, chatReq, nil
+}
+
+// getResponseStatus returns the status of a response and its incomplete details according to the
+// finish reason of its generation
+func getResponseStatus(finishReason string) (string, *openaiserverapi.ResponsesIncompleteDetails) {
+	switch finishReason {
+	case dataset.StopFinishReason, dataset.ToolsFinishReason:
+		return openaiserverapi.ResponsesStatusCompleted, nil
+	case dataset.LengthFinishReason:
+		return openaiserverapi.ResponsesStatusIncomplete,
+			&openaiserverapi.ResponsesIncompleteDetails{Reason: openaiserverapi.ResponsesIncompleteMaxOutputTokens}
+	default:
+		return openaiserverapi.ResponsesStatusIncomplete,
+			&openaiserverapi.ResponsesIncompleteDetails{Reason: finishReason}
+	}
+}
+
+// newResponsesResponse returns a response of the given request in progress, without output and usage
+func (s *VllmSimulator) newResponsesResponse(req *openaiserverapi.ResponsesRequest, modelName string,
+	createdAt int64) *openaiserverapi.ResponsesResponse {
+	resp := &openaiserverapi.ResponsesResponse{
+		ID:              responseIDPrefix + s.random.UUIDString(),
+		Object:          responseObject,
+		CreatedAt:       createdAt,
+		Status:          openaiserverapi.ResponsesStatusInProgress,
+		MaxOutputTokens: req.MaxOutputTokens,
+		Model:           modelName,
+		Output:          []openaiserverapi.ResponsesOutputItem{},
+	}
+	if req.Instructions != "" {
+		resp.Instructions = &req.Instructions
+	}
+	return resp
+}
+
+// newResponsesContentPart returns the content part of an output message with the given text,
+// the text is a refusal if isRefusal is true
+func newResponsesContentPart(text string, isRefusal bool) openaiserverapi.ResponsesContentPart {
+	if isRefusal {
+		return openaiserverapi.ResponsesContentPart{Type: openaiserverapi.ResponsesContentRefusal, Refusal: text}
+	}
+	return openaiserverapi.ResponsesContentPart{Type: openaiserverapi.ResponsesContentOutputText, Text: text,
+		Annotations: []any{}}
+}
+
+// finishResponsesResponse sets the status, the output message and the usage of the given response
+func finishResponsesResponse(resp *openaiserverapi.ResponsesResponse, item openaiserverapi.ResponsesOutputItem,
+	finishReason string, usageData *openaiserverapi.Usage, nCachedPromptTokens int) {
+	resp.Status, resp.IncompleteDetails = getResponseStatus(finishReason)
+	item.Status = resp.Status
+	resp.Output = []openaiserverapi.ResponsesOutputItem{item}
+	resp.Usage = &openaiserverapi.ResponsesUsage{
+		InputTokens:        usageData.PromptTokens,
+		InputTokensDetails: openaiserverapi.ResponsesInputTokensDetails{CachedTokens: nCachedPromptTokens},
+		OutputTokens:       usageData.CompletionTokens,
+		TotalTokens:        usageData.TotalTokens,
+	}
+}
+
+// createResponsesResponse creates the response of a responses API request
+// choice - the generated content of the response, a text or a refusal as defined by isRefusal
+// usageData - usage (tokens statistics) for this response
+// nCachedPromptTokens - the number of the input tokens found in the cache
+// modelName - display name returned to the client and used in metrics
+func (s *VllmSimulator) createResponsesResponse(req *openaiserverapi.ResponsesRequest, choice responseChoice,
+	usageData *openaiserverapi.Usage, nCachedPromptTokens int, modelName string,
+	isRefusal bool) *openaiserverapi.ResponsesResponse {
+	resp := s.newResponsesResponse(req, modelName, s.externalNow().Unix())
+	item := openaiserverapi.ResponsesOutputItem{
+		ID:      responseItemPrefix + s.random.UUIDString(),
+		Type:    openaiserverapi.ResponsesItemMessage,
+		Role:    openaiserverapi.RoleAssistant,
+		Content: []openaiserverapi.ResponsesContentPart{newResponsesContentPart(strings.Join(choice.tokens, ""), isRefusal)},
+	}
+	finishResponsesResponse(resp, item, choice.finishReason, usageData, nCachedPromptTokens)
+	return resp
+}
+
+// responsesStream sends the events of a streamed response, the events are numbered by their order
+type responsesStream struct {
+	w              *bufio.Writer
+	sequenceNumber int
+}
+
+// send sends the given event in the stream, its type is the name of the SSE event
+func (rs *responsesStream) send(event openaiserverapi.ResponsesStreamEvent) error {
+	event.SequenceNumber = rs.sequenceNumber
+	rs.sequenceNumber++
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(rs.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	return rs.w.Flush()
+}
+
+// sendResponsesStreamingResponse creates and sends a streamed response of a responses API request, the events
+// of the response are sent in SSE format. The output message is added after timeToFirstToken milliseconds,
+// every token is sent in a delta event after interTokenLatency milliseconds, the stream ends with the event
+// of the final status of the response, there is no [DONE] sentinel
+func (s *VllmSimulator) sendResponsesStreamingResponse(context *streamingContext, req *openaiserverapi.ResponsesRequest,
+	choice responseChoice, usageData *openaiserverapi.Usage) {
+	context.ctx.SetContentType(eventStreamMediaType)
+	context.ctx.SetStatusCode(fasthttp.StatusOK)
+
+	// Add pod and namespace information to response headers for testing/debugging
+	if s.pod != "" {
+		context.ctx.Response.Header.Add(podHeader, s.pod)
+	}
+	if s.namespace != "" {
+		context.ctx.Response.Header.Add(namespaceHeader, s.namespace)
+	}
+
+	s.setBodyStreamWriter(context.ctx, func(w *bufio.Writer) {
+		w, stopFlush := s.startTimedFlush(w)
+		defer stopFlush()
+		defer s.responseSentCallback(context.model, context.requestID)
+		defer func() {
+			finishReasons := s.streamFinishReasons(context, []responseChoice{choice})
+			s.traceRequest(context.requestID, context.nSentTokens, finishReasons)
+			s.publishRequestEnd(context.requestID, context.nSentTokens, finishReasons, streamFailure(context))
+			s.logRequestEnd(context.requestID, context.nSentTokens, finishReasons)
+			if context.aborted {
+				s.reportRequestAborted(context.model)
+				return
+			}
+			s.reportRequestLatencies(context.requestID, context.model, context.nTokenSteps)
+			s.reportRequestTokens(context.requestID, context.model, context.nSentTokens)
+			s.recordUsage(context.requestID, context.model, context.nSentTokens)
+			s.reportRequestSuccess(context.model, finishReasons)
+		}()
+		defer func() {
+			// the stream ended before its first token was sent
+			if context.holdsPrefillSlot {
+				s.releasePrefillSlot()
+			}
+		}()
+		context.creationTime = s.externalNow().Unix()
+		context.deadline = s.getResponseDeadline(time.Now())
+		context.timeScale = s.getTimeScale()
+
+		if err := s.sendResponsesEvents(context, &responsesStream{w: w}, req, choice, usageData); err != nil {
+			s.logStreamAborted(context, err)
+		}
+	})
+}
+
+// sendResponsesEvents sends the events of a streamed response, returns an error if the stream was aborted
+func (s *VllmSimulator) sendResponsesEvents(context *streamingContext, stream *responsesStream,
+	req *openaiserverapi.ResponsesRequest, choice responseChoice, usageData *openaiserverapi.Usage) error {
+	resp := s.newResponsesResponse(req, context.model, context.creationTime)
+	for _, eventType := range []string{responseCreatedEvent, responseInProgressEvent} {
+		if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: eventType, Response: resp}); err != nil {
+			return err
+		}
+	}
+
+	// time to first token delay
+	ttft := s.getWaitTimeToFirstToken(context.model, context.nPromptTokens, context.nCachedPromptTokens,
+		context.doRemotePrefill)
+	nPrefillTokens := localPrefillTokens(context.nPromptTokens, context.nCachedPromptTokens, context.doRemotePrefill)
+	s.reportIterationTokens(context.model, nPrefillTokens)
+	endPrefill := s.startPrefill(nPrefillTokens)
+	s.markPrefillStart(context.requestID, context.doRemotePrefill)
+	inTime := context.sleep(ttft)
+	endPrefill()
+	s.releasePrefillSlot()
+	context.holdsPrefillSlot = false
+
+	outputIndex, contentIndex := 0, 0
+	item := openaiserverapi.ResponsesOutputItem{
+		ID:      responseItemPrefix + s.random.UUIDString(),
+		Type:    openaiserverapi.ResponsesItemMessage,
+		Status:  openaiserverapi.ResponsesStatusInProgress,
+		Role:    openaiserverapi.RoleAssistant,
+		Content: []openaiserverapi.ResponsesContentPart{},
+	}
+	emptyPart := newResponsesContentPart("", context.isRefusal)
+	addedItem := item
+	if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: responseItemAddedEvent,
+		OutputIndex: &outputIndex, Item: &addedItem}); err != nil {
+		return err
+	}
+	if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: responsePartAddedEvent, ItemID: item.ID,
+		OutputIndex: &outputIndex, ContentIndex: &contentIndex, Part: &emptyPart}); err != nil {
+		return err
+	}
+
+	deltaEvent, doneEvent := responseOutputTextDeltaEvent, responseOutputTextDoneEvent
+	if context.isRefusal {
+		deltaEvent, doneEvent = responseRefusalDeltaEvent, responseRefusalDoneEvent
+	}
+	var sb strings.Builder
+	for i, token := range common.RuneSafeTokens(choice.tokens) {
+		if inTime && !s.reserveMemory(context.model, context.requestID, context.nPromptTokens+i+1, context.disconnected) {
+			return errRequestAborted
+		}
+		if inTime && i != 0 {
+			s.reportIterationTokens(context.model, s.numDecodingRequests())
+		}
+		if !inTime || (i != 0 && !context.sleep(s.getInterTokenLatency(context.model, i))) {
+			inTime = false
+			break
+		}
+		if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: deltaEvent, ItemID: item.ID,
+			OutputIndex: &outputIndex, ContentIndex: &contentIndex, Delta: &token}); err != nil {
+			return err
+		}
+		sb.WriteString(token)
+		if context.nTokenSteps == 0 {
+			s.markFirstToken(context.requestID)
+		}
+		context.nSentTokens++
+		context.nTokenSteps++
+	}
+
+	if isDisconnected(context.disconnected) {
+		return errRequestAborted
+	}
+	finishReason := choice.finishReason
+	if !inTime {
+		context.truncated = true
+		s.logger.Info("Stream cut at the maximal stream duration", "request id", context.requestID,
+			"sent tokens", context.nSentTokens)
+		s.reportStreamDurationTruncation()
+		finishReason = s.config.MaxStreamDurationFinishReason
+		usageData.CompletionTokens = context.nSentTokens
+		usageData.TotalTokens = usageData.PromptTokens + context.nSentTokens
+	}
+
+	text := sb.String()
+	part := newResponsesContentPart(text, context.isRefusal)
+	doneEventData := openaiserverapi.ResponsesStreamEvent{Type: doneEvent, ItemID: item.ID,
+		OutputIndex: &outputIndex, ContentIndex: &contentIndex}
+	if context.isRefusal {
+		doneEventData.Refusal = &text
+	} else {
+		doneEventData.Text = &text
+	}
+	if err := stream.send(doneEventData); err != nil {
+		return err
+	}
+	if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: responsePartDoneEvent, ItemID: item.ID,
+		OutputIndex: &outputIndex, ContentIndex: &contentIndex, Part: &part}); err != nil {
+		return err
+	}
+
+	item.Content = []openaiserverapi.ResponsesContentPart{part}
+	finishResponsesResponse(resp, item, finishReason, usageData, context.nCachedPromptTokens)
+	if err := stream.send(openaiserverapi.ResponsesStreamEvent{Type: responseItemDoneEvent,
+		OutputIndex: &outputIndex, Item: &resp.Output[0]}); err != nil {
+		return err
+	}
+	finalEvent := responseCompletedEvent
+	if resp.Status == openaiserverapi.ResponsesStatusIncomplete {
+		finalEvent = responseIncompleteEvent
+	}
+	return stream.send(openaiserverapi.ResponsesStreamEvent{Type: finalEvent, Response: resp})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/responses"
+)
+
+// nolint
+func getOpenAIClentAndResponsesParams(client option.HTTPClient, model string,
+	input string) (openai.Client, responses.ResponseNewParams) {
+	openaiclient := openai.NewClient(
+		option.WithBaseURL(baseURL),
+		option.WithHTTPClient(client))
+
+	params := responses.ResponseNewParams{
+		Input: responses.ResponseNewParamsInputUnion{OfString: param.NewOpt(input)},
+		Model: model,
+	}
+	return openaiclient, params
+}
+
+var _ = Describe("Responses API", func() {
+	It("should return the output text", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndResponsesParams(client, model, userMessage)
+		resp, err := openaiclient.Responses.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(resp.ID).To(HavePrefix(responseIDPrefix))
+		Expect(string(resp.Object)).To(Equal(responseObject))
+		Expect(resp.Model).To(Equal(model))
+		Expect(string(resp.Status)).To(Equal("completed"))
+		Expect(resp.Output).To(HaveLen(1))
+		Expect(resp.Output[0].Type).To(Equal("message"))
+		Expect(string(resp.Output[0].Role)).To(Equal("assistant"))
+		Expect(resp.OutputText()).To(Equal(userMessage))
+		Expect(resp.Usage.InputTokens).To(BeNumerically(">", 0))
+		Expect(resp.Usage.OutputTokens).To(Equal(userMsgTokens))
+		Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.InputTokens + userMsgTokens))
+	})
+
+	It("should accept input items and instructions", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndResponsesParams(client, model, "")
+		params.Input = responses.ResponseNewParamsInputUnion{OfInputItemList: responses.ResponseInputParam{
+			responses.ResponseInputItemParamOfMessage("Hello", responses.EasyInputMessageRoleUser),
+			responses.ResponseInputItemParamOfMessage("Hi, how can I help?", responses.EasyInputMessageRoleAssistant),
+			responses.ResponseInputItemParamOfMessage(userMessage, responses.EasyInputMessageRoleUser),
+		}}
+		params.Instructions = param.NewOpt("Be brief.")
+		resp, err := openaiclient.Responses.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the last user message is echoed
+		Expect(resp.OutputText()).To(Equal(userMessage))
+		Expect(resp.Instructions).To(Equal("Be brief."))
+	})
+
+	It("should return an incomplete response when the maximal number of output tokens is reached", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndResponsesParams(client, model, userMessage)
+		params.MaxOutputTokens = param.NewOpt(int64(2))
+		resp, err := openaiclient.Responses.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(string(resp.Status)).To(Equal("incomplete"))
+		Expect(resp.IncompleteDetails.Reason).To(Equal("max_output_tokens"))
+		Expect(resp.Usage.OutputTokens).To(Equal(int64(2)))
+		Expect(resp.OutputText()).To(Equal(strings.Join(common.Tokenize(userMessage)[:2], "")))
+	})
+
+	It("should stream the response events", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeEcho)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndResponsesParams(client, model, userMessage)
+		stream := openaiclient.Responses.NewStreaming(ctx, params)
+		defer func() {
+			Expect(stream.Close()).To(Succeed())
+		}()
+
+		var eventTypes []string
+		var text strings.Builder
+		sequenceNumber := 0
+		for stream.Next() {
+			event := stream.Current()
+			var rawEvent openaiserverapi.ResponsesStreamEvent
+			Expect(json.Unmarshal([]byte(event.RawJSON()), &rawEvent)).To(Succeed())
+			Expect(rawEvent.SequenceNumber).To(Equal(sequenceNumber))
+			sequenceNumber++
+			if len(eventTypes) == 0 || eventTypes[len(eventTypes)-1] != event.Type {
+				eventTypes = append(eventTypes, event.Type)
+			}
+			if event.Type == responseOutputTextDeltaEvent {
+				text.WriteString(event.Delta)
+			}
+			if event.Type == responseCompletedEvent {
+				Expect(event.Response.OutputText()).To(Equal(userMessage))
+				Expect(event.Response.Usage.OutputTokens).To(Equal(userMsgTokens))
+			}
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(text.String()).To(Equal(userMessage))
+		Expect(eventTypes).To(Equal([]string{responseCreatedEvent, responseInProgressEvent, responseItemAddedEvent,
+			responsePartAddedEvent, responseOutputTextDeltaEvent, responseOutputTextDoneEvent, responsePartDoneEvent,
+			responseItemDoneEvent, responseCompletedEvent}))
+	})
+
+	It("should return an error for an unknown model", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndResponsesParams(client, "unknown", userMessage)
+		_, err = openaiclient.Responses.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should reject a request without input", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Post("http://localhost/v1/responses", "application/json",
+			strings.NewReader(`{"model": "`+model+`"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+})
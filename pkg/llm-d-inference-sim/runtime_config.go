@@ -0,0 +1,88 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Changing the configuration of a running simulator through /_sim/config
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// getRuntimeConfig returns the configuration including the changes made at runtime, the latency
+// and failure injection parameters are read from it
+func (s *VllmSimulator) getRuntimeConfig() *common.Configuration {
+	if config := s.runtimeConfig.Load(); config != nil {
+		return config
+	}
+	return s.config
+}
+
+// HandleGetRuntimeConfig http handler for GET /_sim/config, returns the current values of the parameters
+// that can be changed at runtime
+func (s *VllmSimulator) HandleGetRuntimeConfig(ctx *fasthttp.RequestCtx) {
+	s.sendRuntimeConfig(ctx, s.getRuntimeConfig())
+}
+
+// HandleUpdateRuntimeConfig http handler for PATCH /_sim/config, changes the parameters in the JSON object
+// in the request body and returns the new values of the parameters that can be changed at runtime.
+// The update is rejected as a whole if one of the parameters cannot be changed or the result is invalid
+func (s *VllmSimulator) HandleUpdateRuntimeConfig(ctx *fasthttp.RequestCtx) {
+	s.runtimeConfigMutex.Lock()
+	defer s.runtimeConfigMutex.Unlock()
+
+	updated, err := s.getRuntimeConfig().WithRuntimeUpdate(ctx.Request.Body())
+	if err != nil {
+		s.logger.Error(err, "Runtime configuration update rejected")
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	s.runtimeConfig.Store(updated)
+	s.setClockSkew(updated.ClockSkew)
+	s.logger.Info("Runtime configuration updated", "update", string(ctx.Request.Body()))
+	s.sendRuntimeConfig(ctx, updated)
+}
+
+// sendRuntimeConfig sends the values of the parameters that can be changed at runtime in the given configuration
+func (s *VllmSimulator) sendRuntimeConfig(ctx *fasthttp.RequestCtx, config *common.Configuration) {
+	configResp, err := configMap(config)
+	if err != nil {
+		s.logger.Error(err, "Failed to create runtime config response")
+		ctx.Error("Failed to create runtime config response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	runtimeParams := common.RuntimeParams()
+	for param := range configResp {
+		if !slices.Contains(runtimeParams, param) {
+			delete(configResp, param)
+		}
+	}
+
+	data, err := json.Marshal(configResp)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal runtime config response")
+		ctx.Error("Failed to marshal runtime config response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
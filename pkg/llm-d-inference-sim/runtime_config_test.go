@@ -0,0 +1,147 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const runtimeConfigURL = "http://localhost/_sim/config"
+
+// sendRuntimeConfigRequest sends a request to /_sim/config, returns the status code and the response body
+func sendRuntimeConfigRequest(client *http.Client, method string, body string) (int, []byte) {
+	req, err := http.NewRequest(method, runtimeConfigURL, strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	resp, err := client.Do(req)
+	Expect(err).NotTo(HaveOccurred())
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.Body.Close()).To(Succeed())
+	return resp.StatusCode, data
+}
+
+var _ = Describe("Runtime configuration", func() {
+	It("should not serve /_sim/config when the admin API is disabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, _ := sendRuntimeConfigRequest(client, http.MethodGet, "")
+		Expect(statusCode).To(Equal(http.StatusNotFound))
+		statusCode, _ = sendRuntimeConfigRequest(client, http.MethodPatch, `{"time-to-first-token": 10}`)
+		Expect(statusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should change the latencies of a running simulator", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--time-to-first-token", "0", "--inter-token-latency", "0"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, data := sendRuntimeConfigRequest(client, http.MethodGet, "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var params map[string]any
+		Expect(json.Unmarshal(data, &params)).To(Succeed())
+		Expect(params).To(HaveLen(len(common.RuntimeParams())))
+		Expect(params["time-to-first-token"]).To(BeNumerically("==", 0))
+
+		openaiclient, chatParams := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		start := time.Now()
+		_, err = openaiclient.Chat.Completions.New(ctx, chatParams)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 300*time.Millisecond))
+
+		statusCode, data = sendRuntimeConfigRequest(client, http.MethodPatch, `{"time-to-first-token": 300}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(data, &params)).To(Succeed())
+		Expect(params["time-to-first-token"]).To(BeNumerically("==", 300))
+		Expect(getConfig(client)["time-to-first-token"]).To(BeNumerically("==", 300))
+
+		start = time.Now()
+		_, err = openaiclient.Chat.Completions.New(ctx, chatParams)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+	})
+
+	It("should change the failure injection rate of a running simulator", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		statusCode, _ := sendRuntimeConfigRequest(client, http.MethodPatch,
+			`{"failure-injection-rate": 100, "failure-types": ["`+common.FailureTypeServerError+`"]}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		openaiclient, chatParams := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		_, err = openaiclient.Chat.Completions.New(ctx, chatParams)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("503"))
+	})
+
+	It("should change the clock skew of a running simulator", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, chatParams := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		resp, err := openaiclient.Chat.Completions.New(ctx, chatParams)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Created).To(BeNumerically("~", time.Now().Unix(), 5))
+
+		statusCode, data := sendRuntimeConfigRequest(client, http.MethodPatch, `{"clock-skew": "1h"}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		var params map[string]any
+		Expect(json.Unmarshal(data, &params)).To(Succeed())
+		Expect(params["clock-skew"]).To(BeNumerically("==", time.Hour))
+
+		resp, err = openaiclient.Chat.Completions.New(ctx, chatParams)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Created).To(BeNumerically("~", time.Now().Add(time.Hour).Unix(), 5))
+	})
+
+	DescribeTable("should reject an invalid update and keep the configuration",
+		func(update string, expectedError string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+				"--time-to-first-token", "100"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			statusCode, data := sendRuntimeConfigRequest(client, http.MethodPatch, update)
+			Expect(statusCode).To(Equal(http.StatusBadRequest))
+			Expect(string(data)).To(ContainSubstring(expectedError))
+			Expect(getConfig(client)["time-to-first-token"]).To(BeNumerically("==", 100))
+		},
+		Entry("parameter that cannot be changed", `{"time-to-first-token": 10, "model": "other"}`,
+			"parameter 'model' cannot be changed at runtime"),
+		Entry("invalid value", `{"time-to-first-token": 10, "time-factor-under-load": 0.5}`, "time factor under load"),
+		Entry("invalid body", `not json`, "failed to parse the configuration update"),
+		Entry("invalid duration", `{"time-to-first-token": 10, "clock-skew": "soon"}`, "invalid value of 'clock-skew'"),
+	)
+})
@@ -18,11 +18,18 @@ package llmdinferencesim
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/param"
+	"k8s.io/klog/v2"
 )
 
 var _ = Describe("Simulator with seed", func() {
@@ -91,6 +98,203 @@ var _ = Describe("Simulator with seed", func() {
 	)
 })
 
+var _ = Describe("Simulator with data parallel seeds", func() {
+	const baseSeed = "100"
+
+	// startDataParallelServers starts the simulators of two data parallel ranks with the given additional
+	// arguments, returns clients ordered by rank
+	startDataParallelServers := func(ctx context.Context, args ...string) []*http.Client {
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = append([]string{"cmd", "--model", model, "--mode", common.ModeRandom, "--seed", baseSeed,
+			"--data-parallel-size", "2"}, args...)
+		config, err := common.ParseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config = config
+		ranks, err := s.createDataParallelRanks()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ranks).To(HaveLen(1))
+
+		clients := make([]*http.Client, 0)
+		for _, sim := range []*VllmSimulator{s, ranks[0]} {
+			client, err := startSimulator(ctx, sim)
+			Expect(err).NotTo(HaveOccurred())
+			clients = append(clients, client)
+		}
+		return clients
+	}
+
+	// getTexts sends a sequence of text completion requests, returns the responses' texts
+	getTexts := func(ctx context.Context, client *http.Client) []string {
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(10)
+		texts := make([]string, 0)
+		for range 5 {
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).ShouldNot(BeEmpty())
+			texts = append(texts, resp.Choices[0].Text)
+		}
+		return texts
+	}
+
+	It("should generate different sequences in the ranks and reproduce them with the same base seed", func() {
+		ctx := context.TODO()
+		clients := startDataParallelServers(ctx)
+		rank0Texts := getTexts(ctx, clients[0])
+		rank1Texts := getTexts(ctx, clients[1])
+		Expect(rank1Texts).NotTo(Equal(rank0Texts))
+
+		clients = startDataParallelServers(ctx)
+		Expect(getTexts(ctx, clients[0])).To(Equal(rank0Texts))
+		Expect(getTexts(ctx, clients[1])).To(Equal(rank1Texts))
+
+		// rank 0 behaves as a single rank run with the same seed
+		client, err := startServerWithArgs(ctx, common.ModeRandom,
+			[]string{"cmd", "--model", model, "--mode", common.ModeRandom, "--seed", baseSeed}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getTexts(ctx, client)).To(Equal(rank0Texts))
+	})
+
+	It("should expose the ranks' seeds in /v1/config", func() {
+		ctx := context.TODO()
+		clients := startDataParallelServers(ctx)
+		expectedSeeds := []any{float64(100), float64(common.DPRankSeed(100, 1))}
+		for rank, client := range clients {
+			config := getConfig(client)
+			Expect(config["data-parallel-rank"]).To(BeNumerically("==", rank))
+			Expect(config["seed"]).To(Equal(expectedSeeds[rank]))
+			Expect(config["data-parallel-seeds"]).To(Equal(expectedSeeds))
+			Expect(config["model"]).To(Equal(model))
+		}
+	})
+
+	It("should override the parameters of a rank", func() {
+		ctx := context.TODO()
+		configFile := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(configFile, []byte(`
+time-to-first-token: 10
+max-num-seqs: 4
+data-parallel-rank-overrides:
+  1:
+    time-to-first-token: 500
+    max-num-seqs: 1
+    failure-injection-rate: 100
+`), 0o600)).To(Succeed())
+		clients := startDataParallelServers(ctx, "--config", configFile)
+
+		config := getConfig(clients[0])
+		Expect(config["time-to-first-token"]).To(BeNumerically("==", 10))
+		Expect(config["max-num-seqs"]).To(BeNumerically("==", 4))
+		Expect(config["failure-injection-rate"]).To(BeNumerically("==", 0))
+		config = getConfig(clients[1])
+		Expect(config["time-to-first-token"]).To(BeNumerically("==", 500))
+		Expect(config["max-num-seqs"]).To(BeNumerically("==", 1))
+		Expect(config["failure-injection-rate"]).To(BeNumerically("==", 100))
+
+		// only the overridden rank fails the requests
+		openaiclient, params := getOpenAIClentAndCompletionParams(clients[0], model, userMessage, false)
+		_, err := openaiclient.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		openaiclient, params = getOpenAIClentAndCompletionParams(clients[1], model, userMessage, false)
+		_, err = openaiclient.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Simulator with request seed", func() {
+	// getChatResponses sends the same chat completion request several times with the given seed,
+	// returns the responses
+	getChatResponses := func(client *http.Client, seed int64, withTools bool) []*openai.ChatCompletion {
+		ctx := context.TODO()
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.Seed = param.NewOpt(seed)
+		params.N = param.NewOpt(int64(2))
+		if withTools {
+			params.Tools = tools
+		}
+		responses := make([]*openai.ChatCompletion, 0)
+		for range 5 {
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(2))
+			responses = append(responses, resp)
+		}
+		return responses
+	}
+
+	It("should return identical texts for requests with the same seed", func() {
+		client, err := startServer(context.TODO(), common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		responses := getChatResponses(client, 42, false)
+		texts := make([]string, 0)
+		for _, resp := range responses {
+			Expect(resp.Choices[0].Message.Content).To(Equal(responses[0].Choices[0].Message.Content))
+			Expect(resp.Choices[1].Message.Content).To(Equal(responses[0].Choices[1].Message.Content))
+			Expect(resp.SystemFingerprint).To(HavePrefix("fp_"))
+			Expect(resp.SystemFingerprint).To(Equal(responses[0].SystemFingerprint))
+			texts = append(texts, resp.Choices[0].Message.Content)
+		}
+
+		// the choices of a request are generated separately
+		choices := []string{responses[0].Choices[0].Message.Content, responses[0].Choices[1].Message.Content}
+		// other seeds return other texts
+		for _, seed := range []int64{1, 2, 3, 4} {
+			choices = append(choices, getChatResponses(client, seed, false)[0].Choices[0].Message.Content)
+		}
+		Expect(hasAtLeastTwoDifferentTexts(choices)).To(BeTrue())
+	})
+
+	It("should return identical tool calls for requests with the same seed", func() {
+		client, err := startServer(context.TODO(), common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		responses := getChatResponses(client, 7, true)
+		for _, resp := range responses {
+			for i := range resp.Choices {
+				Expect(resp.Choices[i].Message.ToolCalls).To(Equal(responses[0].Choices[i].Message.ToolCalls))
+				Expect(resp.Choices[i].Message.Content).To(Equal(responses[0].Choices[i].Message.Content))
+			}
+		}
+	})
+
+	It("should return identical texts in streaming and text completions with the same seed", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, true)
+		params.Seed = param.NewOpt(int64(42))
+		streamTexts := make([]string, 0)
+		for range 3 {
+			stream := openaiclient.Completions.NewStreaming(ctx, params)
+			text := ""
+			for stream.Next() {
+				chunk := stream.Current()
+				Expect(chunk.SystemFingerprint).To(HavePrefix("fp_"))
+				for _, choice := range chunk.Choices {
+					text += choice.Text
+				}
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(stream.Close()).To(Succeed())
+			streamTexts = append(streamTexts, text)
+		}
+		Expect(streamTexts[1]).To(Equal(streamTexts[0]))
+		Expect(streamTexts[2]).To(Equal(streamTexts[0]))
+
+		resp, err := openaiclient.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].Text).To(Equal(streamTexts[0]))
+	})
+})
+
 func hasAtLeastTwoDifferentTexts(texts []string) bool {
 	unique := make(map[string]struct{})
 	for _, s := range texts {
@@ -101,3 +305,70 @@ func hasAtLeastTwoDifferentTexts(texts []string) bool {
 	}
 	return false
 }
+
+var _ = Describe("Simulator with sampling parameters", func() {
+	It("should generate identical responses to identical requests with a zero temperature", func() {
+		ctx := context.TODO()
+		client, err := startServerWithArgs(ctx, common.ModeRandom,
+			[]string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-sampling-params"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.Temperature = openai.Float(0)
+		texts := make([]string, 0)
+		for range 5 {
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).ShouldNot(BeEmpty())
+			texts = append(texts, resp.Choices[0].Message.Content)
+		}
+		Expect(hasAtLeastTwoDifferentTexts(texts)).To(BeFalse())
+
+		// without the sampling parameters mode the temperature is ignored
+		client, err = startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+		openaiclient, _ = getOpenAIClentAndChatParams(client, model, userMessage, false)
+		texts = make([]string, 0)
+		for range 8 {
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			texts = append(texts, resp.Choices[0].Message.Content)
+		}
+		Expect(hasAtLeastTwoDifferentTexts(texts)).To(BeTrue())
+	})
+
+	It("should reject a temperature out of its range", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.Temperature = openai.Float(3)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(openaiError.Message).To(ContainSubstring("temperature must be between 0 and 2"))
+	})
+
+	It("should bias the generated tokens by the logit bias", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.LogitBias = map[string]int64{"hello": 100}
+		params.MaxCompletionTokens = openai.Int(10)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Fields(strings.ReplaceAll(resp.Choices[0].Message.Content, "hello", " "))).To(BeEmpty())
+
+		params.LogitBias = map[string]int64{"hello": 101}
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		var openaiError *openai.Error
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(openaiError.Message).To(ContainSubstring("logit_bias of token 'hello' must be between -100 and 100"))
+	})
+})
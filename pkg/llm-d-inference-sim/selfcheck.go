@@ -0,0 +1,166 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Startup self-checks of the configured subsystems
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+)
+
+const (
+	selfCheckZMQ       = "zmq"
+	selfCheckDataset   = "dataset"
+	selfCheckTokenizer = "tokenizer"
+
+	selfCheckStatusOK     = "ok"
+	selfCheckStatusFailed = "failed"
+
+	readinessReady    = "ready"
+	readinessDegraded = "degraded"
+	readinessUnready  = "unready"
+	readinessDraining = "draining"
+	readinessLoading  = "loading"
+
+	// zmqCheckTimeout is the maximal time to wait for the ZMQ connection handshake
+	zmqCheckTimeout = time.Second
+	// tokenizerCheckCanary is the text tokenized by the tokenizer self-check
+	tokenizerCheckCanary = "The simulator is ready"
+)
+
+// selfCheckResult is the result of a startup self-check
+type selfCheckResult struct {
+	Name string `json:"name"`
+	// Status is ok or failed
+	Status string `json:"status"`
+	// Critical is true if the simulator is not ready when the check fails
+	Critical bool `json:"critical"`
+	// Error is the reason of the failure
+	Error string `json:"error,omitempty"`
+	// DurationMs is the duration of the check, in milliseconds
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// readinessResponse is the response of /ready?verbose=true
+type readinessResponse struct {
+	// Status is ready, degraded (a non-critical check failed), unready (a critical check failed)
+	// or draining (the simulator is stopping)
+	Status string            `json:"status"`
+	Checks []selfCheckResult `json:"checks"`
+}
+
+// runSelfChecks checks the configured subsystems and stores the results, the checks run only
+// for the subsystems that are in use
+func (s *VllmSimulator) runSelfChecks(ctx context.Context) {
+	checks := make([]selfCheckResult, 0)
+	if (s.config.EnableKVCache || s.config.EnableRequestEvents) && s.config.ZMQEndpoint != "" {
+		checks = append(checks, s.runSelfCheck(selfCheckZMQ, false, func() error {
+			return common.CheckZMQEndpoint(s.config.ZMQEndpoint, zmqCheckTimeout)
+		}))
+	}
+	if s.config.DatasetPath != "" || s.config.DatasetURL != "" {
+		checks = append(checks, s.runSelfCheck(selfCheckDataset, false, func() error {
+			return s.checkDataset(ctx)
+		}))
+	}
+	if s.config.EnableKVCache {
+		// the kv cache cannot process requests without the tokenizer
+		checks = append(checks, s.runSelfCheck(selfCheckTokenizer, true, s.checkTokenizer))
+	}
+	s.selfChecks = checks
+}
+
+// runSelfCheck runs the given check and returns its result
+func (s *VllmSimulator) runSelfCheck(name string, critical bool, check func() error) selfCheckResult {
+	start := time.Now()
+	err := check()
+	result := selfCheckResult{
+		Name:       name,
+		Status:     selfCheckStatusOK,
+		Critical:   critical,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = selfCheckStatusFailed
+		result.Error = err.Error()
+		s.logger.Error(err, "Startup self-check failed", "check", name, "critical", critical)
+	} else {
+		s.logger.V(4).Info("Startup self-check passed", "check", name, "duration", time.Since(start))
+	}
+	return result
+}
+
+// checkDataset runs a query on the configured dataset
+func (s *VllmSimulator) checkDataset(ctx context.Context) error {
+	if s.datasetErr != nil {
+		return s.datasetErr
+	}
+	custDataset, ok := s.dataset.(*dataset.CustomDataset)
+	if !ok {
+		return errors.New("the dataset is not used")
+	}
+	return custDataset.Check(ctx)
+}
+
+// checkTokenizer tokenizes a canary text with the tokenizer of the model
+func (s *VllmSimulator) checkTokenizer() error {
+	tokenizer := s.tokenizer
+	if faulty, ok := tokenizer.(*faultyTokenizer); ok {
+		// the failure rate simulates intermittent failures, the check fails only if
+		// the tokenizer of the model always fails
+		if slices.Contains(s.config.TokenizerFailureModels, s.config.Model) {
+			return errInjectedTokenizerFailure
+		}
+		tokenizer = faulty.Tokenizer
+	}
+	_, _, err := tokenizer.Encode(tokenizerCheckCanary, s.config.Model)
+	return err
+}
+
+// getReadiness returns the readiness status according to the self-checks, a draining simulator
+// and a simulator that loads the model are not ready
+func (s *VllmSimulator) getReadiness() readinessResponse {
+	if s.draining.Load() {
+		return readinessResponse{Status: readinessDraining, Checks: s.selfChecks}
+	}
+	if s.isModelLoading() {
+		return readinessResponse{Status: readinessLoading, Checks: s.selfChecks}
+	}
+	status := readinessReady
+	for _, check := range s.selfChecks {
+		if check.Status == selfCheckStatusOK {
+			continue
+		}
+		if check.Critical {
+			status = readinessUnready
+			break
+		}
+		status = readinessDegraded
+	}
+	return readinessResponse{Status: status, Checks: s.selfChecks}
+}
+
+// isReady returns true if the simulator accepts requests, its readiness is either ready or degraded
+func (s *VllmSimulator) isReady() bool {
+	status := s.getReadiness().Status
+	return status == readinessReady || status == readinessDegraded
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	zmq "github.com/pebbe/zmq4"
+)
+
+const (
+	readyURL        = "http://localhost/ready"
+	verboseReadyURL = "http://localhost/ready?verbose=true"
+)
+
+func getReady(client *http.Client, url string) (int, []byte) {
+	resp, err := client.Get(url)
+	Expect(err).NotTo(HaveOccurred())
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(resp.Body.Close()).To(Succeed())
+	return resp.StatusCode, data
+}
+
+// expectReadiness checks the plain and the verbose readiness responses, returns the self-checks by their names
+func expectReadiness(client *http.Client, expectedStatus string) map[string]selfCheckResult {
+	expectedCode := http.StatusOK
+	if expectedStatus == readinessUnready || expectedStatus == readinessLoading {
+		expectedCode = http.StatusServiceUnavailable
+	}
+
+	statusCode, body := getReady(client, readyURL)
+	Expect(statusCode).To(Equal(expectedCode))
+	Expect(string(body)).To(Equal("{}"))
+
+	statusCode, body = getReady(client, verboseReadyURL)
+	Expect(statusCode).To(Equal(expectedCode))
+	var readiness readinessResponse
+	Expect(json.Unmarshal(body, &readiness)).To(Succeed())
+	Expect(readiness.Status).To(Equal(expectedStatus))
+
+	checks := make(map[string]selfCheckResult)
+	for _, check := range readiness.Checks {
+		Expect(check.DurationMs).To(BeNumerically(">=", 0))
+		checks[check.Name] = check
+	}
+	return checks
+}
+
+var _ = Describe("Startup self-checks", func() {
+	It("should be ready without self-checks", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		checks := expectReadiness(client, readinessReady)
+		Expect(checks).To(BeEmpty())
+	})
+
+	It("should pass the dataset check", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--dataset-path", testDatasetPath, "--dataset-in-memory"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		checks := expectReadiness(client, readinessReady)
+		Expect(checks).To(HaveLen(1))
+		Expect(checks[selfCheckDataset].Status).To(Equal(selfCheckStatusOK))
+		Expect(checks[selfCheckDataset].Critical).To(BeFalse())
+	})
+
+	It("should flag a locked dataset and stay ready", func() {
+		data, err := os.ReadFile(testDatasetPath)
+		Expect(err).NotTo(HaveOccurred())
+		dbPath := filepath.Join(GinkgoT().TempDir(), "locked.sqlite3")
+		Expect(os.WriteFile(dbPath, data, 0o600)).To(Succeed())
+
+		// lock the database by another connection
+		db, err := sql.Open("sqlite3", dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		db.SetMaxOpenConns(1)
+		defer func() {
+			Expect(db.Close()).To(Succeed())
+		}()
+		_, err = db.Exec("BEGIN EXCLUSIVE;")
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--dataset-path", dbPath}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		checks := expectReadiness(client, readinessDegraded)
+		Expect(checks).To(HaveLen(1))
+		Expect(checks[selfCheckDataset].Status).To(Equal(selfCheckStatusFailed))
+		Expect(checks[selfCheckDataset].Error).To(ContainSubstring("database is locked"))
+	})
+
+	It("should not be ready when the tokenizer fails", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-kvcache",
+			"--zmq-endpoint", "", "--tokenizer-failure-models", model}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		checks := expectReadiness(client, readinessUnready)
+		Expect(checks).To(HaveLen(1))
+		Expect(checks[selfCheckTokenizer].Status).To(Equal(selfCheckStatusFailed))
+		Expect(checks[selfCheckTokenizer].Critical).To(BeTrue())
+		Expect(checks[selfCheckTokenizer].Error).To(Equal(errInjectedTokenizerFailure.Error()))
+	})
+
+	Context("zmq", func() {
+		tmpDir := "./tests-tmp/"
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		It("should pass the zmq and tokenizer checks", func() {
+			zctx, err := zmq.NewContext()
+			Expect(err).NotTo(HaveOccurred())
+			sub, err := zctx.NewSocket(zmq.SUB)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(sub.Close()).To(Succeed())
+			}()
+			Expect(sub.Bind("tcp://127.0.0.1:*")).To(Succeed())
+			endpoint, err := sub.GetLastEndpoint()
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", qwenModelName, "--mode", common.ModeRandom, "--enable-kvcache",
+				"--zmq-endpoint", endpoint, "--tokenizers-cache-dir", tmpDir}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			checks := expectReadiness(client, readinessReady)
+			Expect(checks).To(HaveLen(2))
+			Expect(checks[selfCheckZMQ].Status).To(Equal(selfCheckStatusOK))
+			Expect(checks[selfCheckTokenizer].Status).To(Equal(selfCheckStatusOK))
+		})
+
+		It("should flag an unreachable zmq endpoint and stay ready", func() {
+			ctx := context.TODO()
+			// nothing listens on the endpoint
+			args := []string{"cmd", "--model", qwenModelName, "--mode", common.ModeRandom, "--enable-kvcache",
+				"--zmq-endpoint", "tcp://127.0.0.1:1", "--tokenizers-cache-dir", tmpDir}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			checks := expectReadiness(client, readinessDegraded)
+			Expect(checks).To(HaveLen(2))
+			Expect(checks[selfCheckZMQ].Status).To(Equal(selfCheckStatusFailed))
+			Expect(checks[selfCheckZMQ].Error).To(ContainSubstring("no connection"))
+			Expect(checks[selfCheckTokenizer].Status).To(Equal(selfCheckStatusOK))
+		})
+	})
+})
@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/buaazp/fasthttprouter"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -41,35 +43,179 @@ func (s *VllmSimulator) newListener() (net.Listener, error) {
 	return listener, nil
 }
 
+// route is an HTTP endpoint of the simulator, the routes are registered in the router and
+// described in the OpenAPI document served by /openapi.json
+type route struct {
+	method  string
+	path    string
+	handler fasthttp.RequestHandler
+	// summary describes the endpoint in the OpenAPI document
+	summary string
+	// tag groups the endpoints in the OpenAPI document
+	tag string
+	// simExtension is true if the endpoint is specific to the simulator, it is not a part of the OpenAI or vLLM APIs
+	simExtension bool
+	// requestType is the media type of the body of the request, empty if the endpoint receives no body
+	requestType string
+	// responseTypes are the media types of the responses
+	responseTypes []string
+	// isCompletion is true for the completion endpoints, which accept the simulator's request headers
+	isCompletion bool
+}
+
+// routes returns the table of the HTTP endpoints of the simulator according to its configuration
+func (s *VllmSimulator) routes() []route {
+	completionTypes := []string{jsonMediaType, eventStreamMediaType}
+	routes := []route{
+		// support completion APIs
+		{method: fasthttp.MethodPost, path: "/v1/chat/completions", handler: s.HandleChatCompletions,
+			summary: "Creates a chat completion", tag: openAPITagOpenAI, requestType: jsonMediaType,
+			responseTypes: completionTypes, isCompletion: true},
+		{method: fasthttp.MethodPost, path: "/v1/completions", handler: s.HandleTextCompletions,
+			summary: "Creates a text completion", tag: openAPITagOpenAI, requestType: jsonMediaType,
+			responseTypes: completionTypes, isCompletion: true},
+		{method: fasthttp.MethodPost, path: "/v1/responses", handler: s.HandleResponses,
+			summary: "Creates a response of the responses API", tag: openAPITagOpenAI, requestType: jsonMediaType,
+			responseTypes: completionTypes, isCompletion: true},
+		// supports embeddings API
+		{method: fasthttp.MethodPost, path: "/v1/embeddings", handler: s.HandleEmbeddings,
+			summary: "Creates the embeddings of the input", tag: openAPITagOpenAI, requestType: jsonMediaType},
+		// supports files API, the files of the batches and of the fine-tuning workflows
+		{method: fasthttp.MethodPost, path: "/v1/files", handler: s.HandleUploadFile,
+			summary: "Uploads a file", tag: openAPITagOpenAI, requestType: multipartFormMediaType},
+		{method: fasthttp.MethodGet, path: "/v1/files", handler: s.HandleListFiles,
+			summary: "Lists the files", tag: openAPITagOpenAI},
+		{method: fasthttp.MethodGet, path: "/v1/files/:file_id", handler: s.HandleGetFile,
+			summary: "Returns a file", tag: openAPITagOpenAI},
+		{method: fasthttp.MethodDelete, path: "/v1/files/:file_id", handler: s.HandleDeleteFile,
+			summary: "Deletes a file", tag: openAPITagOpenAI},
+		{method: fasthttp.MethodGet, path: "/v1/files/:file_id/content", handler: s.HandleGetFileContent,
+			summary: "Returns the content of a file", tag: openAPITagOpenAI,
+			responseTypes: []string{octetStreamMediaType}},
+		// supports batch API, the requests of an input file are processed asynchronously
+		{method: fasthttp.MethodPost, path: "/v1/batches", handler: s.HandleCreateBatch,
+			summary: "Creates and starts a batch", tag: openAPITagOpenAI, requestType: jsonMediaType},
+		{method: fasthttp.MethodGet, path: "/v1/batches", handler: s.HandleListBatches,
+			summary: "Lists the batches", tag: openAPITagOpenAI},
+		{method: fasthttp.MethodGet, path: "/v1/batches/:batch_id", handler: s.HandleGetBatch,
+			summary: "Returns a batch", tag: openAPITagOpenAI},
+		{method: fasthttp.MethodPost, path: "/v1/batches/:batch_id/cancel", handler: s.HandleCancelBatch,
+			summary: "Cancels a batch", tag: openAPITagOpenAI},
+		// supports /models API
+		{method: fasthttp.MethodGet, path: "/v1/models", handler: s.HandleModels,
+			summary: "Lists the served models and the loaded LoRA adapters", tag: openAPITagOpenAI},
+		// the model ids may contain slashes, e.g. meta-llama/Llama-3.1-8B-Instruct
+		{method: fasthttp.MethodGet, path: "/v1/models/*model", handler: s.HandleModel,
+			summary: "Returns a served model or a loaded LoRA adapter", tag: openAPITagOpenAI},
+		// supports /usage API, returns the tokens used per model and API key
+		{method: fasthttp.MethodGet, path: "/v1/usage", handler: s.HandleUsage,
+			summary: "Returns the tokens used per model and API key", tag: openAPITagSim, simExtension: true},
+		// supports /config API, returns the configuration of the rank
+		{method: fasthttp.MethodGet, path: "/v1/config", handler: s.HandleConfig,
+			summary: "Returns the configuration of the rank", tag: openAPITagSim, simExtension: true},
+		// support load/unload of lora adapter
+		{method: fasthttp.MethodPost, path: "/v1/load_lora_adapter", handler: s.HandleLoadLora,
+			summary: "Loads a LoRA adapter", tag: openAPITagVLLM, requestType: jsonMediaType},
+		{method: fasthttp.MethodPost, path: "/v1/unload_lora_adapter", handler: s.HandleUnloadLora,
+			summary: "Unloads a LoRA adapter", tag: openAPITagVLLM, requestType: jsonMediaType},
+		// supports /metrics prometheus API
+		{method: fasthttp.MethodGet, path: "/metrics",
+			handler: fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(s.metricsGatherer(), promhttp.HandlerOpts{})),
+			summary: "Returns the Prometheus metrics", tag: openAPITagVLLM, responseTypes: []string{textMediaType}},
+		// supports standard Kubernetes health and readiness checks
+		{method: fasthttp.MethodGet, path: "/health", handler: s.HandleHealth,
+			summary: "Returns the health of the simulator", tag: openAPITagVLLM},
+		{method: fasthttp.MethodGet, path: "/ready", handler: s.HandleReady,
+			summary: "Returns the readiness of the simulator and its self-checks", tag: openAPITagVLLM},
+		{method: fasthttp.MethodPost, path: "/tokenize", handler: s.HandleTokenize,
+			summary: "Tokenizes a prompt or chat messages", tag: openAPITagVLLM, requestType: jsonMediaType},
+		// supports discovering the endpoints of the simulator
+		{method: fasthttp.MethodGet, path: "/openapi.json", handler: s.HandleOpenAPI,
+			summary: "Returns the OpenAPI document of the endpoints of the simulator", tag: openAPITagSim,
+			simExtension: true},
+	}
+	if len(s.dpRanks) > 0 {
+		// supports scraping the metrics of all the data parallel ranks from rank 0
+		routes = append(routes, route{method: fasthttp.MethodGet, path: "/metrics/aggregated",
+			handler: fasthttpadaptor.NewFastHTTPHandler(
+				promhttp.HandlerFor(s.aggregatedMetricsGatherer(), promhttp.HandlerOpts{})),
+			summary: "Returns the Prometheus metrics of all the data parallel ranks", tag: openAPITagSim,
+			simExtension: true, responseTypes: []string{textMediaType}})
+	}
+	if s.config.EnableAdminAPI {
+		routes = append(routes,
+			// supports debugging of the simulator's internal state
+			route{method: fasthttp.MethodGet, path: "/debug/queue", handler: s.HandleDebugQueue,
+				summary: "Lists the waiting and the running requests", tag: openAPITagAdmin, simExtension: true},
+			route{method: fasthttp.MethodGet, path: "/debug/dataset", handler: s.HandleDebugDataset,
+				summary: "Returns the statistics of the dataset", tag: openAPITagAdmin, simExtension: true},
+			// supports validating a configuration without applying it
+			route{method: fasthttp.MethodPost, path: "/admin/validate-config", handler: s.HandleValidateConfig,
+				summary: "Validates a configuration without applying it", tag: openAPITagAdmin, simExtension: true,
+				requestType: jsonMediaType},
+			// supports changing the latency and failure injection parameters at runtime
+			route{method: fasthttp.MethodGet, path: "/_sim/config", handler: s.HandleGetRuntimeConfig,
+				summary: "Returns the parameters that can be changed at runtime", tag: openAPITagAdmin,
+				simExtension: true},
+			route{method: fasthttp.MethodPatch, path: "/_sim/config", handler: s.HandleUpdateRuntimeConfig,
+				summary: "Changes parameters at runtime", tag: openAPITagAdmin, simExtension: true, requestType: jsonMediaType},
+			// supports inspecting the simulator's internal state without parsing the metrics
+			route{method: fasthttp.MethodGet, path: "/_sim/status", handler: s.HandleSimStatus,
+				summary: "Returns the internal state of the simulator", tag: openAPITagAdmin, simExtension: true},
+			// supports draining and stopping the simulator like on SIGTERM
+			route{method: fasthttp.MethodPost, path: "/_sim/drain", handler: s.HandleDrain,
+				summary: "Drains and stops the simulator", tag: openAPITagAdmin, simExtension: true},
+			// supports replaying recorded kv-cache events to the ZMQ endpoint
+			route{method: fasthttp.MethodPost, path: "/_sim/kv-events/replay", handler: s.HandleReplayKVEvents,
+				summary: "Replays recorded kv-cache events to the ZMQ endpoint", tag: openAPITagAdmin,
+				simExtension: true, requestType: jsonMediaType},
+			// supports cancelling an in-flight completion request by its id
+			route{method: fasthttp.MethodPost, path: "/v1/chat/completions/:request_id/cancel",
+				handler: s.HandleCancelRequest, summary: "Cancels a waiting or running completion request",
+				tag: openAPITagAdmin, simExtension: true},
+			route{method: fasthttp.MethodPost, path: "/v1/completions/:request_id/cancel",
+				handler: s.HandleCancelRequest, summary: "Cancels a waiting or running completion request",
+				tag: openAPITagAdmin, simExtension: true},
+		)
+	}
+	if s.config.EnablePprof {
+		// supports profiling of the simulator process
+		for _, method := range []string{fasthttp.MethodGet, fasthttp.MethodPost} {
+			routes = append(routes, route{method: method, path: "/debug/pprof/*name", handler: handlePprof,
+				summary: "Serves the Go runtime profiles of net/http/pprof", tag: openAPITagAdmin,
+				simExtension: true, responseTypes: []string{octetStreamMediaType}})
+		}
+	}
+	return routes
+}
+
 // startServer starts http/https server on port defined in command line
 func (s *VllmSimulator) startServer(ctx context.Context, listener net.Listener) error {
 	r := fasthttprouter.New()
-
-	// support completion APIs
-	r.POST("/v1/chat/completions", s.HandleChatCompletions)
-	r.POST("/v1/completions", s.HandleTextCompletions)
-	// supports /models API
-	r.GET("/v1/models", s.HandleModels)
-	// support load/unload of lora adapter
-	r.POST("/v1/load_lora_adapter", s.HandleLoadLora)
-	r.POST("/v1/unload_lora_adapter", s.HandleUnloadLora)
-	// supports /metrics prometheus API
-	r.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{})))
-	// supports standard Kubernetes health and readiness checks
-	r.GET("/health", s.HandleHealth)
-	r.GET("/ready", s.HandleReady)
-	r.POST("/tokenize", s.HandleTokenize)
+	routes := s.routes()
+	for _, route := range routes {
+		r.Handle(route.method, route.path, s.withNetworkLatency(s.withAccessLog(route.path, route.handler)))
+	}
+	document, err := json.Marshal(s.newOpenAPIDocument(routes))
+	if err != nil {
+		return err
+	}
+	s.openAPIDocument = document
 
 	server := &fasthttp.Server{
-		ErrorHandler: s.HandleError,
-		Handler:      r.Handler,
-		Logger:       s,
+		ErrorHandler:       s.HandleError,
+		Handler:            r.Handler,
+		Logger:             s,
+		MaxRequestBodySize: s.maxRequestBodySize(),
 	}
 
 	if err := s.configureSSL(server); err != nil {
 		return err
 	}
 
+	// the connections are peeked to detect the clients that disconnect while their requests are processed
+	listener = &peekableListener{Listener: listener}
+
 	// Start server in a goroutine
 	serverErr := make(chan error, 1)
 	go func() {
@@ -82,19 +228,15 @@ func (s *VllmSimulator) startServer(ctx context.Context, listener net.Listener)
 		}
 	}()
 
-	// Wait for either context cancellation or server error
+	// Wait for either context cancellation, a drain request or server error
 	select {
 	case <-ctx.Done():
 		s.logger.Info("Shutdown signal received, shutting down server gracefully")
+		return s.drainAndShutdown(server)
 
-		// Gracefully shutdown the server
-		if err := server.Shutdown(); err != nil {
-			s.logger.Error(err, "Error during server shutdown")
-			return err
-		}
-
-		s.logger.Info("Server stopped")
-		return nil
+	case <-s.drainRequested:
+		s.logger.Info("Drain requested, shutting down server gracefully")
+		return s.drainAndShutdown(server)
 
 	case err := <-serverErr:
 		if err != nil {
@@ -106,7 +248,7 @@ func (s *VllmSimulator) startServer(ctx context.Context, listener net.Listener)
 
 // readRequest reads and parses data from the body of the given request according the type defined by isChatCompletion
 func (s *VllmSimulator) readRequest(ctx *fasthttp.RequestCtx, isChatCompletion bool) (openaiserverapi.CompletionRequest, error) {
-	requestID := common.GenerateUUIDString()
+	requestID := s.newRequestID(ctx)
 
 	if isChatCompletion {
 		var req openaiserverapi.ChatCompletionRequest
@@ -117,6 +259,15 @@ func (s *VllmSimulator) readRequest(ctx *fasthttp.RequestCtx, isChatCompletion b
 			return nil, err
 		}
 
+		// check the limits before the schema validation, which is expensive for many or deep tools
+		limit, err := s.toolsValidator.ValidateToolsLimits(req.Tools, s.config.MaxToolsPerRequest,
+			s.config.MaxToolSchemaDepth)
+		if err != nil {
+			s.logger.Error(err, "tools limit exceeded")
+			s.reportToolLimitRejection(limit)
+			return nil, err
+		}
+
 		for _, tool := range req.Tools {
 			toolJson, err := json.Marshal(tool.Function)
 			if err != nil {
@@ -129,6 +280,10 @@ func (s *VllmSimulator) readRequest(ctx *fasthttp.RequestCtx, isChatCompletion b
 				return nil, err
 			}
 		}
+		if err := s.toolsValidator.ValidateResponseFormat(req.ResponseFormat); err != nil {
+			s.logger.Error(err, "response format validation failed")
+			return nil, err
+		}
 		req.RequestID = requestID
 
 		return &req, nil
@@ -142,16 +297,33 @@ func (s *VllmSimulator) readRequest(ctx *fasthttp.RequestCtx, isChatCompletion b
 	return &req, err
 }
 
+// newRequestID returns the id of a completion request, the value of its X-Request-Id header if set
+func (s *VllmSimulator) newRequestID(ctx *fasthttp.RequestCtx) string {
+	if requestID := ctx.Request.Header.Peek(requestIDHeader); len(requestID) > 0 {
+		return string(requestID)
+	}
+	return s.random.UUIDString()
+}
+
 // HandleChatCompletions http handler for /v1/chat/completions
 func (s *VllmSimulator) HandleChatCompletions(ctx *fasthttp.RequestCtx) {
 	s.logger.Info("chat completion request received")
-	s.handleCompletions(ctx, true)
+	s.recordRequest(ctx, replayChatEndpoint)
+	s.handleCompletions(ctx, true, false)
 }
 
 // HandleTextCompletions http handler for /v1/completions
 func (s *VllmSimulator) HandleTextCompletions(ctx *fasthttp.RequestCtx) {
 	s.logger.Info("completion request received")
-	s.handleCompletions(ctx, false)
+	s.recordRequest(ctx, replayTextEndpoint)
+	s.handleCompletions(ctx, false, false)
+}
+
+// HandleResponses http handler for /v1/responses
+func (s *VllmSimulator) HandleResponses(ctx *fasthttp.RequestCtx) {
+	s.logger.Info("responses request received")
+	s.recordRequest(ctx, replayResponsesEndpoint)
+	s.handleCompletions(ctx, true, true)
 }
 
 // readTokenizeRequest reads and parses data from the body of the given request
@@ -177,25 +349,26 @@ func (s *VllmSimulator) HandleTokenize(ctx *fasthttp.RequestCtx) {
 	// Check that the request has only one input to tokenize
 	if req.Prompt != "" && req.Messages != nil {
 		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError("both prompt and messages fields in tokenize request",
-			fasthttp.StatusBadRequest, nil), false)
+			fasthttp.StatusBadRequest, nil), "")
 		return
 	}
 	// Model is optional, if not set, the model from the configuration will be used
-	model := req.Model
+	model, maxModelLen := req.Model, s.getMaxModelLen(req.Model)
 	if model == "" {
-		model = s.config.Model
+		model, maxModelLen = s.config.Model, s.getMaxModelLen(s.config.ServedModelNames[0])
 	}
 
 	tokens, _, err := s.tokenizer.Encode(req.GetPrompt(), model)
 	if err != nil {
 		s.logger.Error(err, "failed to tokenize")
+		s.reportTokenizerError()
 		ctx.Error("Failed to tokenize, "+err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
 	resp := vllmapi.TokenizeResponse{
 		Count:       len(tokens),
 		Tokens:      tokens,
-		MaxModelLen: s.config.MaxModelLen,
+		MaxModelLen: maxModelLen,
 	}
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -222,38 +395,126 @@ func (s *VllmSimulator) validateRequest(req openaiserverapi.CompletionRequest) (
 		return fmt.Sprintf("The model `%s` does not exist.", req.GetModel()), fasthttp.StatusNotFound
 	}
 
+	if _, inFlight := s.inFlightRequests.Load(req.GetRequestID()); inFlight {
+		return fmt.Sprintf("A request with id '%s' is already in flight", req.GetRequestID()), fasthttp.StatusBadRequest
+	}
+
 	if req.GetMaxCompletionTokens() != nil && *req.GetMaxCompletionTokens() <= 0 {
 		return "Max completion tokens and max tokens should be positive", fasthttp.StatusBadRequest
 	}
 
+	if errMsg := req.GetRawSamplingParams().Validate(); errMsg != "" {
+		return errMsg, fasthttp.StatusBadRequest
+	}
+
+	if req.GetN() < 1 {
+		return "n must be at least 1", fasthttp.StatusBadRequest
+	}
+	if req.GetBestOf() < req.GetN() {
+		return fmt.Sprintf("best_of must be greater than or equal to n, got n=%d and best_of=%d.", req.GetN(),
+			req.GetBestOf()), fasthttp.StatusBadRequest
+	}
+	if req.GetBestOf() > req.GetN() && req.IsStream() {
+		return "best_of must be equal to n when streaming", fasthttp.StatusBadRequest
+	}
+
+	if req.GetPriority() != 0 && s.config.SchedulingPolicy != common.SchedulingPolicyPriority {
+		return "Priority scheduling is not enabled.", fasthttp.StatusBadRequest
+	}
+
 	if req.IsDoRemoteDecode() && req.IsStream() {
 		return "Prefill does not support streaming", fasthttp.StatusBadRequest
 	}
 
+	if req.GetMinTokens() < 0 {
+		return fmt.Sprintf("min_tokens must be greater than or equal to 0, got %d.", req.GetMinTokens()),
+			fasthttp.StatusBadRequest
+	}
+	if maxTokens := req.GetMaxCompletionTokens(); maxTokens != nil && req.GetMinTokens() > *maxTokens {
+		return fmt.Sprintf("min_tokens must be less than or equal to max_tokens=%d, got %d.", *maxTokens,
+			req.GetMinTokens()), fasthttp.StatusBadRequest
+	}
+
 	if req.GetIgnoreEOS() && req.GetMaxCompletionTokens() == nil {
 		return "Ignore_eos is true but max_completion_tokens (or max_tokens) is not set", fasthttp.StatusBadRequest
 	}
 
-	// Validate context window constraints
-	promptTokens := req.GetNumberOfPromptTokens()
-	completionTokens := req.GetMaxCompletionTokens()
-	isValid, actualCompletionTokens, totalTokens := common.ValidateContextWindow(promptTokens, completionTokens, s.config.MaxModelLen)
-	if !isValid {
-		message := fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion",
-			s.config.MaxModelLen, totalTokens, promptTokens, actualCompletionTokens)
+	if tier := req.GetServiceTier(); tier != "" && !openaiserverapi.IsValidServiceTier(tier) {
+		message := fmt.Sprintf("Invalid service_tier '%s', valid values are: %s, %s, %s", tier,
+			openaiserverapi.ServiceTierAuto, openaiserverapi.ServiceTierDefault, openaiserverapi.ServiceTierFlex)
 		return message, fasthttp.StatusBadRequest
 	}
+
+	if req.GetRetainKVDuration() < 0 {
+		return "x_sim_retain_kv_seconds cannot be negative", fasthttp.StatusBadRequest
+	}
+
+	if name, duplicate := openaiserverapi.FindDuplicateToolName(req.GetTools()); duplicate {
+		return fmt.Sprintf("Duplicate tool name `%s` in `tools`", name), fasthttp.StatusBadRequest
+	}
+
+	if name := req.GetToolChoiceFunctionName(); name != "" {
+		if len(req.GetTools()) == 0 {
+			return "When using `tool_choice`, `tools` must be set.", fasthttp.StatusBadRequest
+		}
+		if _, found := openaiserverapi.FindTool(req.GetTools(), name); !found {
+			return fmt.Sprintf("The tool specified in `tool_choice` (`%s`) does not match any of the specified `tools`", name),
+				fasthttp.StatusBadRequest
+		}
+	}
+
+	// Validate context window constraints, every prompt of a batch is checked separately
+	completionTokens := req.GetMaxCompletionTokens()
+	maxModelLen := s.getMaxModelLen(req.GetModel())
+	for _, promptReq := range req.GetPromptRequests() {
+		promptTokens := promptReq.GetNumberOfPromptTokens()
+		isValid, actualCompletionTokens, totalTokens := common.ValidateContextWindow(promptTokens, completionTokens, maxModelLen)
+		if !isValid {
+			message := fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion",
+				maxModelLen, totalTokens, promptTokens, actualCompletionTokens)
+			return message, fasthttp.StatusBadRequest
+		}
+	}
 	return "", fasthttp.StatusOK
 }
 
+// serverTimingEntry returns a Server-Timing header entry of the given metric with the duration in milliseconds
+func serverTimingEntry(metric string, duration time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", metric, float64(duration.Microseconds())/1000)
+}
+
+// validateAcceptHeader checks that the request's Accept header allows the media type of the response,
+// returns an error message if it doesn't
+func validateAcceptHeader(ctx *fasthttp.RequestCtx, isStream bool) string {
+	mediaType := jsonMediaType
+	if isStream {
+		mediaType = eventStreamMediaType
+	}
+	accept := string(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if !common.AcceptsMediaType(accept, mediaType) {
+		return fmt.Sprintf("The Accept header '%s' does not allow %s, the media type of the response", accept, mediaType)
+	}
+	return ""
+}
+
 // sendCompletionResponse sends a completion response
-func (s *VllmSimulator) sendCompletionResponse(ctx *fasthttp.RequestCtx, resp openaiserverapi.CompletionResponse) {
+// contentTypeFailure is the injected failure of the Content-Type header, empty if none
+func (s *VllmSimulator) sendCompletionResponse(ctx *fasthttp.RequestCtx, resp openaiserverapi.CompletionResponse,
+	contentTypeFailure string) {
 	data, err := json.Marshal(resp)
 	if err != nil {
 		ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
 		return
 	}
-	ctx.Response.Header.SetContentType("application/json")
+	switch contentTypeFailure {
+	case common.FailureTypeMissingContentType:
+		// prevent fasthttp from adding its default content type
+		ctx.Response.Header.SetNoDefaultContentType(true)
+	case common.FailureTypeWrongContentType:
+		ctx.Response.Header.SetContentType("text/plain")
+	default:
+		ctx.Response.Header.SetContentType("application/json")
+	}
 	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
 	// Add pod and namespace information to response headers for testing/debugging
 	if s.pod != "" {
@@ -266,20 +527,16 @@ func (s *VllmSimulator) sendCompletionResponse(ctx *fasthttp.RequestCtx, resp op
 }
 
 // sendCompletionError sends an error response for the current completion request
-// isInjected indicates if this is an injected failure for logging purposes
+// injectedBy is the source of an injected failure (rate or header) for logging purposes, empty if the error is not injected
 func (s *VllmSimulator) sendCompletionError(ctx *fasthttp.RequestCtx,
-	compErr openaiserverapi.CompletionError, isInjected bool) {
-	if isInjected {
-		s.logger.Info("Injecting failure", "type", compErr.Type, "message", compErr.Message)
+	compErr openaiserverapi.CompletionError, injectedBy string) {
+	if injectedBy != "" {
+		s.logger.Info("Injecting failure", "type", compErr.Type, "message", compErr.Message, "injected_by", injectedBy)
 	} else {
 		s.logger.Error(nil, compErr.Message)
 	}
 
-	errorResp := openaiserverapi.ErrorResponse{
-		Error: compErr,
-	}
-
-	data, err := json.Marshal(errorResp)
+	data, err := json.Marshal(s.createErrorResponse(compErr))
 	if err != nil {
 		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
 	} else {
@@ -289,6 +546,21 @@ func (s *VllmSimulator) sendCompletionError(ctx *fasthttp.RequestCtx,
 	}
 }
 
+// sendCompletionFailure sends the error response of a failed completion request and counts the failure
+func (s *VllmSimulator) sendCompletionFailure(ctx *fasthttp.RequestCtx,
+	compErr openaiserverapi.CompletionError, injectedBy string) {
+	s.reportRequestFailure(compErr.Type)
+	s.sendCompletionError(ctx, compErr, injectedBy)
+}
+
+// createErrorResponse wraps the given error in the error response of the configured error schema
+func (s *VllmSimulator) createErrorResponse(compErr openaiserverapi.CompletionError) any {
+	if s.config.ErrorSchema == common.ErrorSchemaAzure {
+		return openaiserverapi.NewAzureErrorResponse(compErr)
+	}
+	return openaiserverapi.ErrorResponse{Error: compErr}
+}
+
 // HandleModels handles /v1/models request according the data stored in the simulator
 func (s *VllmSimulator) HandleModels(ctx *fasthttp.RequestCtx) {
 	modelsResp := s.createModelsResponse()
@@ -305,6 +577,81 @@ func (s *VllmSimulator) HandleModels(ctx *fasthttp.RequestCtx) {
 	ctx.Response.SetBody(data)
 }
 
+// HandleModel http handler for /v1/models/{model}, returns the model like in the /v1/models list,
+// a 404 error if the model is not served and is not a loaded LoRA adapter
+func (s *VllmSimulator) HandleModel(ctx *fasthttp.RequestCtx) {
+	path, _ := ctx.UserValue("model").(string)
+	model := strings.TrimPrefix(path, "/")
+	for _, info := range s.createModelsResponse().Data {
+		if info.ID == model {
+			s.sendAPIResponse(ctx, info)
+			return
+		}
+	}
+	s.sendAPIError(ctx, fmt.Sprintf("The model `%s` does not exist.", model), fasthttp.StatusNotFound)
+}
+
+// HandleConfig http handler for /v1/config
+func (s *VllmSimulator) HandleConfig(ctx *fasthttp.RequestCtx) {
+	configResp, err := configMap(s.getRuntimeConfig())
+	if err != nil {
+		s.logger.Error(err, "Failed to create config response")
+		ctx.Error("Failed to create config response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	configResp["data-parallel-rank"] = s.dpRank
+	configResp["data-parallel-seeds"] = s.dataParallelSeeds()
+
+	data, err := json.Marshal(configResp)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal config response")
+		ctx.Error("Failed to marshal config response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// validateConfigResponse is the response of /admin/validate-config
+type validateConfigResponse struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+	// Config is the configuration that would be in effect, nil if the body cannot be parsed
+	Config map[string]interface{} `json:"config"`
+}
+
+// HandleValidateConfig http handler for /admin/validate-config, validates the YAML or JSON configuration
+// in the request body, the configuration is not applied
+func (s *VllmSimulator) HandleValidateConfig(ctx *fasthttp.RequestCtx) {
+	config, validationErr := common.ValidateConfigData(ctx.Request.Body())
+	resp := validateConfigResponse{
+		Valid:  validationErr == nil,
+		Errors: common.ValidationErrors(validationErr),
+	}
+	if config != nil {
+		var err error
+		resp.Config, err = configMap(config)
+		if err != nil {
+			s.logger.Error(err, "Failed to create validate config response")
+			ctx.Error("Failed to create validate config response, "+err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal validate config response")
+		ctx.Error("Failed to marshal validate config response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
 func (s *VllmSimulator) HandleError(_ *fasthttp.RequestCtx, err error) {
 	s.logger.Error(err, "VLLM server error")
 }
@@ -317,10 +664,25 @@ func (s *VllmSimulator) HandleHealth(ctx *fasthttp.RequestCtx) {
 	ctx.Response.SetBody([]byte("{}"))
 }
 
-// HandleReady http handler for /ready
+// HandleReady http handler for /ready, returns the results of the startup self-checks if verbose=true
 func (s *VllmSimulator) HandleReady(ctx *fasthttp.RequestCtx) {
 	s.logger.V(4).Info("readiness request received")
+	readiness := s.getReadiness()
+	statusCode := fasthttp.StatusOK
+	if readiness.Status == readinessUnready || readiness.Status == readinessDraining ||
+		readiness.Status == readinessLoading {
+		statusCode = fasthttp.StatusServiceUnavailable
+	}
+	body := []byte("{}")
+	if string(ctx.QueryArgs().Peek("verbose")) == "true" {
+		data, err := json.Marshal(readiness)
+		if err != nil {
+			ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+		body = data
+	}
 	ctx.Response.Header.SetContentType("application/json")
-	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
-	ctx.Response.SetBody([]byte("{}"))
+	ctx.Response.Header.SetStatusCode(statusCode)
+	ctx.Response.SetBody(body)
 }
@@ -19,12 +19,14 @@ package llmdinferencesim
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -118,6 +120,52 @@ var _ = Describe("Server", func() {
 		})
 	})
 
+	Context("tokenizer failures", func() {
+		postTokenize := func(client *http.Client) int {
+			reqBody := `{"prompt": "This is a test", "model": "` + qwenModelName + `"}`
+			resp, err := client.Post("http://localhost/tokenize", "application/json", strings.NewReader(reqBody))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			return resp.StatusCode
+		}
+
+		It("Should fail /tokenize and fall back in completions", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", qwenModelName, "--mode", common.ModeEcho,
+				"--tokenizer-failure-rate", "100", "--enable-kvcache", "true"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(postTokenize(client)).To(Equal(http.StatusInternalServerError))
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, qwenModelName, userMessage, false)
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(1))
+			Expect(resp.Choices[0].Text).To(Equal(userMessage))
+			// the prompt tokens are counted with the simple tokenization
+			Expect(resp.Usage.PromptTokens).To(BeNumerically("==", len(common.Tokenize(userMessage))))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			metrics := string(data)
+			Expect(metrics).To(ContainSubstring("sim_tokenizer_errors_total 1"))
+			Expect(metrics).To(ContainSubstring("sim_tokenizer_fallbacks_total 1"))
+		})
+
+		It("Should fail the tokenization of the configured models", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", qwenModelName, "--mode", common.ModeRandom,
+				"--tokenizer-failure-models", qwenModelName}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(postTokenize(client)).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
 	Context("SSL/HTTPS Configuration", func() {
 		It("Should parse SSL certificate configuration correctly", func() {
 			tempDir := GinkgoT().TempDir()
@@ -208,4 +256,128 @@ var _ = Describe("Server", func() {
 		})
 
 	})
+
+	Context("strict accept", func() {
+		DescribeTable("should check the Accept header",
+			func(strictAccept bool, stream bool, accept string, expectedStatus int) {
+				ctx := context.TODO()
+				args := []string{"cmd", "--model", model, "--mode", common.ModeRandom}
+				if strictAccept {
+					args = append(args, "--strict-accept")
+				}
+				client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				body := fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "%s", "stream": %t}`,
+					userMessage, model, stream)
+				req, err := http.NewRequest(http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("Accept", accept)
+
+				resp, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					err := resp.Body.Close()
+					Expect(err).NotTo(HaveOccurred())
+				}()
+				Expect(resp.StatusCode).To(Equal(expectedStatus))
+
+				data, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				if expectedStatus == http.StatusNotAcceptable {
+					var errResp openaiserverapi.ErrorResponse
+					err = json.Unmarshal(data, &errResp)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(errResp.Error.Code).To(Equal(http.StatusNotAcceptable))
+					Expect(errResp.Error.Message).To(ContainSubstring(accept))
+				}
+			},
+			func(strictAccept bool, stream bool, accept string, expectedStatus int) string {
+				return fmt.Sprintf("strict accept: %t, stream: %t, accept: %s, expected status: %d",
+					strictAccept, stream, accept, expectedStatus)
+			},
+			Entry(nil, false, true, "text/event-stream", http.StatusOK),
+			Entry(nil, false, true, "application/json", http.StatusOK),
+			Entry(nil, false, false, "application/json", http.StatusOK),
+			Entry(nil, false, false, "text/event-stream", http.StatusOK),
+			Entry(nil, true, true, "text/event-stream", http.StatusOK),
+			Entry(nil, true, true, "application/json", http.StatusNotAcceptable),
+			Entry(nil, true, false, "application/json", http.StatusOK),
+			Entry(nil, true, false, "text/event-stream", http.StatusNotAcceptable),
+			Entry(nil, true, true, "application/json, text/*;q=0.5", http.StatusOK),
+			Entry(nil, true, true, "*/*, text/event-stream;q=0", http.StatusNotAcceptable),
+			Entry(nil, true, false, "*/*", http.StatusOK),
+		)
+	})
+	Context("error schema", func() {
+		// sendErrorRequest sends a chat completion request with the given body and returns the status code
+		// and the parsed error body
+		sendErrorRequest := func(args []string, body string) (int, map[string]any) {
+			ctx := context.TODO()
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := resp.Body.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			var errorBody map[string]any
+			err = json.Unmarshal(data, &errorBody)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errorBody).To(HaveKey("error"))
+			return resp.StatusCode, errorBody["error"].(map[string]any)
+		}
+
+		validationErrorBody := fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "%s", "max_tokens": 0}`,
+			userMessage, model)
+		validBody := fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "%s"}`, userMessage, model)
+		malformedBody := `{"messages": [{"role": "user", "content": `
+		rateLimitArgs := []string{"--failure-injection-rate", "100", "--failure-types", common.FailureTypeRateLimit}
+
+		DescribeTable("should serialize errors in the OpenAI format",
+			func(extraArgs []string, body string, expectedStatus int, expectedType string) {
+				args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom}, extraArgs...)
+				status, errorBody := sendErrorRequest(args, body)
+				Expect(status).To(Equal(expectedStatus))
+				Expect(errorBody["code"]).To(BeNumerically("==", expectedStatus))
+				Expect(errorBody["type"]).To(Equal(expectedType))
+				Expect(errorBody["message"]).NotTo(BeEmpty())
+				Expect(errorBody).To(HaveKey("param"))
+				Expect(errorBody).NotTo(HaveKey("innererror"))
+			},
+			Entry("validation error", []string{}, validationErrorBody, http.StatusBadRequest, "BadRequestError"),
+			Entry("explicit schema", []string{"--error-schema", common.ErrorSchemaOpenAI}, validationErrorBody,
+				http.StatusBadRequest, "BadRequestError"),
+			Entry("injected rate limit", rateLimitArgs, validBody, http.StatusTooManyRequests, "RateLimitError"),
+			Entry("malformed body", []string{}, malformedBody, http.StatusBadRequest, "BadRequestError"),
+		)
+
+		DescribeTable("should serialize errors in the Azure OpenAI format",
+			func(extraArgs []string, body string, expectedStatus int, expectedCode string, expectedInnerCode string) {
+				args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom,
+					"--error-schema", common.ErrorSchemaAzure}, extraArgs...)
+				status, errorBody := sendErrorRequest(args, body)
+				Expect(status).To(Equal(expectedStatus))
+				Expect(errorBody["code"]).To(Equal(expectedCode))
+				Expect(errorBody["message"]).NotTo(BeEmpty())
+				Expect(errorBody).NotTo(HaveKey("type"))
+				Expect(errorBody).To(HaveKey("innererror"))
+				innerError := errorBody["innererror"].(map[string]any)
+				Expect(innerError["code"]).To(Equal(expectedInnerCode))
+			},
+			Entry("validation error", []string{}, validationErrorBody, http.StatusBadRequest, "BadRequest", "BadRequestError"),
+			Entry("injected rate limit", rateLimitArgs, validBody, http.StatusTooManyRequests, "429", "RateLimitError"),
+			Entry("malformed body", []string{}, malformedBody, http.StatusBadRequest, "BadRequest", "BadRequestError"),
+			Entry("unknown model", []string{},
+				fmt.Sprintf(`{"messages": [{"role": "user", "content": "%s"}], "model": "unknown"}`, userMessage),
+				http.StatusNotFound, "DeploymentNotFound", "NotFoundError"),
+		)
+	})
 })
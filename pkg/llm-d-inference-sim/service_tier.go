@@ -0,0 +1,51 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// resolveServiceTier returns the service tier a request that asked for the given service tier is processed in,
+// a request with service tier auto is processed in the flex tier according to the flex tier auto rate
+func resolveServiceTier(config *common.Configuration, random *common.Random, tier string) string {
+	if tier != openaiserverapi.ServiceTierAuto {
+		return tier
+	}
+	if config.FlexTierAutoRate > 0 && random.Int(1, 100) <= config.FlexTierAutoRate {
+		return openaiserverapi.ServiceTierFlex
+	}
+	return openaiserverapi.ServiceTierDefault
+}
+
+// serviceTierLatencyFactor returns the factor applied to the latencies of a request processed in the given service tier
+func (s *VllmSimulator) serviceTierLatencyFactor(tier string) float64 {
+	if tier == openaiserverapi.ServiceTierFlex {
+		return s.config.FlexTierLatencyFactor
+	}
+	return 1.0
+}
+
+// reportServiceTier increments the requests counter of the given service tier
+func (s *VllmSimulator) reportServiceTier(tier string) {
+	if s.serviceTierRequests == nil {
+		// Happens in the tests, or the service tier metrics are disabled
+		return
+	}
+	s.serviceTierRequests.WithLabelValues(tier).Inc()
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+)
+
+var _ = Describe("Service tier", func() {
+	DescribeTable("should echo the service tier the request was processed in",
+		func(autoRate int, requested string, expected string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--flex-tier-auto-rate", fmt.Sprint(autoRate)}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.ServiceTier = openai.ChatCompletionNewParamsServiceTier(requested)
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(resp.ServiceTier)).To(Equal(expected))
+
+			params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+			stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+			defer func() {
+				err := stream.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			chunks := 0
+			for stream.Next() {
+				Expect(string(stream.Current().ServiceTier)).To(Equal(expected))
+				chunks++
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(chunks).To(BeNumerically(">", 0))
+		},
+		func(autoRate int, requested string, expected string) string {
+			return fmt.Sprintf("flex tier auto rate: %d, requested: '%s', expected: '%s'", autoRate, requested, expected)
+		},
+		Entry(nil, 0, "", ""),
+		Entry(nil, 0, openaiserverapi.ServiceTierDefault, openaiserverapi.ServiceTierDefault),
+		Entry(nil, 0, openaiserverapi.ServiceTierFlex, openaiserverapi.ServiceTierFlex),
+		Entry(nil, 0, openaiserverapi.ServiceTierAuto, openaiserverapi.ServiceTierDefault),
+		Entry(nil, 100, openaiserverapi.ServiceTierAuto, openaiserverapi.ServiceTierFlex),
+	)
+
+	It("should reject an invalid service tier", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.ServiceTier = "premium"
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		ok := errors.As(err, &openaiError)
+		Expect(ok).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(400))
+		Expect(openaiError.Message).To(ContainSubstring("Invalid service_tier 'premium'"))
+	})
+
+	DescribeTable("should slow down the flex tier requests by the flex tier latency factor",
+		func(stream bool) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+				"--time-to-first-token", "100", "--inter-token-latency", "20", "--flex-tier-latency-factor", "3"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, stream)
+			measure := func(tier string) time.Duration {
+				params.ServiceTier = openai.ChatCompletionNewParamsServiceTier(tier)
+				start := time.Now()
+				if stream {
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					for stream.Next() {
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+				} else {
+					_, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				return time.Since(start)
+			}
+
+			defaultLatency := measure(openaiserverapi.ServiceTierDefault)
+			flexLatency := measure(openaiserverapi.ServiceTierFlex)
+			expected := 100*time.Millisecond + time.Duration(userMsgTokens-1)*20*time.Millisecond
+			Expect(defaultLatency).To(BeNumerically(">=", expected))
+			Expect(float64(flexLatency) / float64(defaultLatency)).To(BeNumerically("~", 3, 0.4))
+		},
+		func(stream bool) string {
+			return fmt.Sprintf("stream: %t", stream)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	It("should report the service tiers when the service tier metrics are enabled", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--service-tier-metrics", "--flex-tier-auto-rate", "100"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		for _, tier := range []string{openaiserverapi.ServiceTierAuto, openaiserverapi.ServiceTierFlex,
+			openaiserverapi.ServiceTierDefault, ""} {
+			params.ServiceTier = openai.ChatCompletionNewParamsServiceTier(tier)
+			_, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		metrics := string(data)
+		Expect(getGaugeValue(metrics, `sim_service_tier_requests_total{service_tier="flex"}`)).To(Equal(2.0))
+		Expect(getGaugeValue(metrics, `sim_service_tier_requests_total{service_tier="default"}`)).To(Equal(1.0))
+		Expect(metrics).NotTo(ContainSubstring(`service_tier="auto"`))
+	})
+
+	It("should not report the service tiers by default", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring("sim_service_tier_requests_total"))
+	})
+})
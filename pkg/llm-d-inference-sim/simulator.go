@@ -19,15 +19,22 @@ package llmdinferencesim
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"k8s.io/klog/v2"
 
@@ -41,31 +48,58 @@ import (
 
 const (
 	chatComplIDPrefix         = "chatcmpl-"
+	modelPermissionIDPrefix   = "modelperm-"
+	systemFingerprintPrefix   = "fp_"
 	textCompletionObject      = "text_completion"
 	chatCompletionObject      = "chat.completion"
 	chatCompletionChunkObject = "chat.completion.chunk"
 
-	podHeader       = "x-inference-pod"
-	namespaceHeader = "x-inference-namespace"
-	podNameEnv      = "POD_NAME"
-	podNsEnv        = "POD_NAMESPACE"
+	podHeader             = "x-inference-pod"
+	namespaceHeader       = "x-inference-namespace"
+	truncatedPromptHeader = "x-sim-truncated-prompt-tokens"
+	injectFailureHeader   = "x-sim-inject-failure"
+	serverTimingHeader    = "Server-Timing"
+	requestIDHeader       = "X-Request-Id"
+	podNameEnv            = "POD_NAME"
+	podNsEnv              = "POD_NAMESPACE"
+
+	// media types of the request and response bodies
+	jsonMediaType        = "application/json"
+	eventStreamMediaType = "text/event-stream"
+
+	// Server-Timing metric names
+	serverTimingRead  = "read"
+	serverTimingQueue = "queue"
 
 	maxNumberOfRequests = 1000
 )
 
-type loraUsageState int
+// requestState is the state a request moves to in a request transition
+type requestState int
 
 const (
-	waitingUsageState loraUsageState = iota
-	runningUsageState
-	doneUsageState
+	// the request was added to the waiting queue
+	enqueuedRequestState requestState = iota
+	// the request was taken from the waiting queue and started running
+	startedRequestState
+	// the request finished running
+	finishedRequestState
+	// the request was removed from the waiting queue without running, its client disconnected
+	// or it was cancelled while it was waiting
+	abortedRequestState
+	// the request was preempted and returned from running to the waiting queue
+	preemptedRequestState
 )
 
-type loraUsage struct {
-	// the lora adapter name
-	name string
-	// state of the lora usage - waiting/running/done
-	state loraUsageState
+// requestTransition is a single message describing a change in a request's state,
+// both waiting and running counters (and the loras usage) are updated from it together
+type requestTransition struct {
+	// the displayed name of the request's base model
+	model string
+	// the lora adapter name, empty if the request does not use a lora
+	lora string
+	// the state the request moved to
+	state requestState
 }
 
 // VllmSimulator simulates vLLM server supporting OpenAI API
@@ -74,24 +108,104 @@ type VllmSimulator struct {
 	logger logr.Logger
 	// config is the simulator's configuration
 	config *common.Configuration
-	// loraAdaptors contains list of LoRA available adaptors
+	// runtimeConfig is the configuration with the changes made at runtime through /_sim/config,
+	// nil if the configuration was not changed
+	runtimeConfig atomic.Pointer[common.Configuration]
+	// runtimeConfigMutex serializes the changes of the runtime configuration
+	runtimeConfigMutex sync.Mutex
+	// dpRank is the data parallel rank of the simulator, 0 if data parallel is not used
+	dpRank int
+	// dpSeeds are the random seeds of all the data parallel ranks, ordered by rank
+	dpSeeds []int64
+	// dpRanks are the simulators of the data parallel ranks 1 to data-parallel-size - 1, set in rank 0 only
+	dpRanks []*VllmSimulator
+	// random is the random generator of the simulator, seeded with the seed of its data parallel rank
+	random *common.Random
+	// loraAdaptors contains list of LoRA available adaptors,
+	// the key is lora's name, the value is loadedLora
 	loraAdaptors sync.Map
+	// loraLoadMutex serializes the checks of the LoRA adapter load and unload requests
+	loraLoadMutex sync.Mutex
+	// loadingLoras are the names of the LoRA adapters that are being loaded
+	loadingLoras map[string]bool
+	// loraLastUsed contains the time each LoRA adapter was last used by a request,
+	// the key is lora's name, the value is the time, initially the load time
+	loraLastUsed sync.Map
+	// loraAdmissionMutex makes the admission of a request, the check of its model and its addition to
+	// the in-flight requests, atomic with the unload of an idle LoRA adapter: a request is either admitted
+	// before the unload check sees it, or rejected because its adapter was unloaded
+	loraAdmissionMutex sync.RWMutex
+	// inFlightRequests contains the metadata of the waiting and running requests,
+	// the key is the request id, the value is *inFlightRequest
+	inFlightRequests sync.Map
+	// draining is true while the simulator drains before stopping, new completion requests are rejected
+	draining atomic.Bool
+	// drainRequested is closed when a drain is requested by the admin API
+	drainRequested chan struct{}
+	// drainOnce closes drainRequested once
+	drainOnce sync.Once
+	// openAPIDocument is the OpenAPI document of the HTTP endpoints, generated from the route table
+	// when the server starts
+	openAPIDocument []byte
+	// startTime is the start time of the simulator, the model is loading for the startup delay
+	// and the model load time after it
+	startTime time.Time
+	// traceRecorder records the incoming completion requests, nil if record-trace is not set
+	traceRecorder *traceRecorder
+	// accessLog writes the access log of the HTTP requests, nil if enable-access-log is not set
+	accessLog *accessLogger
+	// usage keeps the tokens used per model and API key in the usage retention period
+	usage *usageAccountant
+	// files keeps the files of the files API
+	files *fileStore
+	// batches keeps the batches of the batch API
+	batches *batchStore
+	// requestEvents is the channel of the request lifecycle events to publish, nil if enable-request-events is not set
+	requestEvents chan RequestEvent
+	// requestAborts contains the abort signals of the waiting and running requests,
+	// the key is the request id, the value is *requestAbort
+	requestAborts sync.Map
 	// runningLoras is a collection of running loras,
 	// the key is lora's name, the value is the number of running requests using this lora
 	runningLoras sync.Map
 	// waitingLoras is a collection of waiting loras,
 	// the key is lora's name, the value is the number of waiting requests using this lora
 	waitingLoras sync.Map
-	// lorasChan is a channel to update waitingLoras and runningLoras
-	lorasChan chan loraUsage
+	// latencyTable contains the latencies loaded from the latency profile file, nil if not set
+	latencyTable *common.LatencyTable
+	// systemFingerprint is the system fingerprint of the completion responses
+	systemFingerprint string
+	// engineID identifies this instance in the kv transfer parameters of the prefill responses
+	engineID string
+	// rateLimiter applies the requests per minute and tokens per minute limits
+	rateLimiter *rateLimiter
+	// prefillSlots is a semaphore that limits the number of requests in the prefill phase,
+	// nil if the number of concurrent prefills is unlimited
+	prefillSlots chan struct{}
+	// memory is the simulated pool of kv cache blocks held by the running requests, nil if the memory model is disabled
+	memory *memoryModel
+	// activePrefills is the number of requests in the prefill phase
+	activePrefills atomic.Int64
+	// prefillTokens is the number of prompt tokens that are not in kv cache of the requests in the prefill phase
+	prefillTokens atomic.Int64
+	// tracer creates the spans of the requests, nil if tracing is disabled
+	tracer trace.Tracer
 	// nRunningReqs is the number of inference requests that are currently being processed
 	nRunningReqs int64
-	// runReqChan is a channel to update nRunningReqs
-	runReqChan chan int64
 	// nWaitingReqs is the number of inference requests that are waiting to be processed
 	nWaitingReqs int64
-	// waitingReqChan is a channel to update nWaitingReqs
-	waitingReqChan chan int64
+	// nRunningModelReqs is the number of running inference requests of each base model, by its displayed name
+	nRunningModelReqs map[string]int64
+	// nWaitingModelReqs is the number of waiting inference requests of each base model, by its displayed name
+	nWaitingModelReqs map[string]int64
+	// clockSkew is the skew of the externally visible timestamps in nanoseconds, changed while the
+	// simulator is running by /_sim/config and by the reload of the configuration file
+	clockSkew atomic.Int64
+	// reqTransitionChan is a channel to update nWaitingReqs, nRunningReqs, waitingLoras and runningLoras
+	reqTransitionChan chan requestTransition
+	// metricsMutex is held while the requests, loras and kv cache usage gauges are updated and while
+	// the metrics are gathered, so that every scrape sees a consistent snapshot of these gauges
+	metricsMutex sync.Mutex
 	// kvCacheUsageChan is a channel to update kvCacheUsagePercentage
 	kvCacheUsageChan chan float64
 	// registry is a Prometheus registry
@@ -104,12 +218,97 @@ type VllmSimulator struct {
 	waitingRequests *prometheus.GaugeVec
 	// kvCacheUsagePercentage is prometheus gauge
 	kvCacheUsagePercentage *prometheus.GaugeVec
-	// channel for requeasts to be passed to workers
-	reqChan chan *openaiserverapi.CompletionReqCtx
+	// metricsModelLabels maps a model metric name to its label keys whose value is the model name
+	metricsModelLabels map[string][]string
+	// requestQueueTime is prometheus histogram of the time requests spent in the waiting queue
+	requestQueueTime *prometheus.HistogramVec
+	// requestPrefillTime is prometheus histogram of the time from the start of the prefill of requests to their first token
+	requestPrefillTime *prometheus.HistogramVec
+	// requestDecodeTime is prometheus histogram of the time from the first token of requests to their last token
+	requestDecodeTime *prometheus.HistogramVec
+	// requestInferenceTime is prometheus histogram of the time from the start of the prefill of requests to their last token
+	requestInferenceTime *prometheus.HistogramVec
+	// e2eRequestLatency is prometheus histogram of the time from the arrival of requests until their responses are sent
+	e2eRequestLatency *prometheus.HistogramVec
+	// timeToFirstToken is prometheus histogram of the time from the arrival of requests until their first tokens
+	timeToFirstToken *prometheus.HistogramVec
+	// timePerOutputToken is prometheus histogram of the average time between the output tokens of requests
+	timePerOutputToken *prometheus.HistogramVec
+	// requestPromptTokens is prometheus histogram of the number of prompt tokens of requests
+	requestPromptTokens *prometheus.HistogramVec
+	// requestGenerationTokens is prometheus histogram of the number of generated tokens of requests
+	requestGenerationTokens *prometheus.HistogramVec
+	// requestParamsMaxTokens is prometheus histogram of the max tokens of requests
+	requestParamsMaxTokens *prometheus.HistogramVec
+	// retentionOverrides is prometheus counter of retained kv cache blocks evicted because of capacity pressure
+	retentionOverrides prometheus.CounterFunc
+	// workerBusyRatio is prometheus gauge of the fraction of time each worker was busy in the utilization window
+	workerBusyRatio *prometheus.GaugeVec
+	// workersBusy is prometheus gauge of the average number of busy workers in the utilization window
+	workersBusy prometheus.Gauge
+	// workersBusyTime tracks the busy time of each request processing worker, the worker with id i is at index i-1
+	workersBusyTime []workerBusyTime
+	// queueWait is prometheus summary of the time requests spent in the waiting queue, labeled by the model
+	queueWait *prometheus.SummaryVec
+	// starvationDetected is prometheus gauge, 1 while the model is starving in the waiting queue
+	starvationDetected *prometheus.GaugeVec
+	// activePrefillsGauge is prometheus gauge of the number of requests in the prefill phase
+	activePrefillsGauge prometheus.GaugeFunc
+	// modelLoadProgressGauge is prometheus gauge of the progress of the model load, between 0 and 1
+	modelLoadProgressGauge prometheus.GaugeFunc
+	// prefillQueueWait is prometheus histogram of the time requests waited for a prefill slot
+	prefillQueueWait prometheus.Histogram
+	// streamDurationTruncations is prometheus counter of the responses cut at the maximal stream duration
+	streamDurationTruncations prometheus.Counter
+	// requestAborted is prometheus counter of the requests aborted because their clients disconnected
+	requestAborted *prometheus.CounterVec
+	// requestSuccess is prometheus counter of the finished requests, labeled by the finish reason
+	requestSuccess *prometheus.CounterVec
+	// numPreemptions is prometheus counter of the running requests that were preempted
+	numPreemptions *prometheus.CounterVec
+	// iterationTokens is prometheus histogram of the number of tokens of the scheduling steps
+	iterationTokens *prometheus.HistogramVec
+	// requestFailure is prometheus counter of the failed completion requests, labeled by the error type
+	requestFailure *prometheus.CounterVec
+	// loraAutoUnloads is prometheus counter of the idle LoRA adapters that were unloaded automatically
+	loraAutoUnloads prometheus.Counter
+	// loraAdapterRequests is prometheus counter of the LoRA adapter load and unload requests,
+	// labeled by the operation and the response status code
+	loraAdapterRequests *prometheus.CounterVec
+	// replayRequests is prometheus counter of the replayed requests, labeled by the response status code,
+	// registered only if a replay file is configured
+	replayRequests *prometheus.CounterVec
+	// replayPromptTokens is prometheus counter of the prompt tokens of the replayed requests,
+	// registered only if a replay file is configured
+	replayPromptTokens prometheus.Counter
+	// replayCompletionTokens is prometheus counter of the completion tokens of the replayed requests,
+	// registered only if a replay file is configured
+	replayCompletionTokens prometheus.Counter
+	// serviceTierRequests is prometheus counter of the requests processed in each service tier,
+	// registered only if the service tier metrics are enabled
+	serviceTierRequests *prometheus.CounterVec
+	// queueWaits tracks the queue waits of the requests that started running in the queue wait window
+	queueWaits queueWaitTracker
+	// tokenizerErrors is prometheus counter of the requests that failed because the tokenization failed
+	tokenizerErrors prometheus.Counter
+	// tokenizerFallbacks is prometheus counter of the requests processed without the kv cache because
+	// the tokenization failed
+	tokenizerFallbacks prometheus.CounterFunc
+	// toolLimitRejections is prometheus counter for requests rejected due to tools limits
+	toolLimitRejections *prometheus.CounterVec
+	// injectedFailures is prometheus counter of the injected failures, labeled by the failure type
+	injectedFailures *prometheus.CounterVec
+	// waitingQueue holds the requests until they are taken by the workers
+	waitingQueue *waitingQueue
+	// queueOvertakes is prometheus counter of the waiting requests that were overtaken by
+	// requests with a higher priority
+	queueOvertakes *prometheus.CounterVec
 	// schema validator for tools parameters
 	toolsValidator *openaiserverapi.Validator
 	// kv cache functionality
 	kvcacheHelper *kvcache.KVCacheHelper
+	// kvEventsReplaying is true while recorded kv-cache events are replayed
+	kvEventsReplaying atomic.Bool
 	// namespace where simulator is running
 	namespace string
 	// pod name of simulator
@@ -118,6 +317,12 @@ type VllmSimulator struct {
 	tokenizer tokenization.Tokenizer
 	// dataset is used for token generation in responses
 	dataset dataset.Dataset
+	// datasetErr is the reason the configured dataset is not used, nil if it is used
+	datasetErr error
+	// responseGenerator generates the response tokens instead of the dataset, nil if not set
+	responseGenerator ResponseGenerator
+	// selfChecks are the results of the startup self-checks
+	selfChecks []selfCheckResult
 }
 
 // New creates a new VllmSimulator instance with the given logger
@@ -128,16 +333,19 @@ func New(logger logr.Logger) (*VllmSimulator, error) {
 	}
 
 	return &VllmSimulator{
-		logger:           logger,
-		reqChan:          make(chan *openaiserverapi.CompletionReqCtx, maxNumberOfRequests),
-		toolsValidator:   toolsValidator,
-		kvcacheHelper:    nil, // kvcache helper will be created only if required after reading configuration
-		namespace:        os.Getenv(podNsEnv),
-		pod:              os.Getenv(podNameEnv),
-		runReqChan:       make(chan int64, maxNumberOfRequests),
-		waitingReqChan:   make(chan int64, maxNumberOfRequests),
-		lorasChan:        make(chan loraUsage, maxNumberOfRequests),
-		kvCacheUsageChan: make(chan float64, maxNumberOfRequests),
+		logger:            logger,
+		waitingQueue:      newWaitingQueue(maxNumberOfRequests),
+		toolsValidator:    toolsValidator,
+		kvcacheHelper:     nil, // kvcache helper will be created only if required after reading configuration
+		namespace:         os.Getenv(podNsEnv),
+		pod:               os.Getenv(podNameEnv),
+		reqTransitionChan: make(chan requestTransition, maxNumberOfRequests),
+		kvCacheUsageChan:  make(chan float64, maxNumberOfRequests),
+		nRunningModelReqs: make(map[string]int64),
+		nWaitingModelReqs: make(map[string]int64),
+		drainRequested:    make(chan struct{}),
+		rateLimiter:       newRateLimiter(),
+		loadingLoras:      make(map[string]bool),
 	}, nil
 }
 
@@ -158,23 +366,17 @@ func (s *VllmSimulator) Start(ctx context.Context) error {
 	// For Data Parallel, start data-parallel-size - 1 additional simulators
 	g, ctx := errgroup.WithContext(ctx)
 	if s.config.DPSize > 1 {
-		for i := 2; i <= s.config.DPSize; i++ {
-			newConfig, err := s.config.Copy()
-			if err != nil {
-				return err
-			}
-			dpRank := i - 1
-			newConfig.Port = s.config.Port + dpRank
-			newSim, err := New(klog.LoggerWithValues(s.logger, "rank", dpRank))
-			if err != nil {
-				return err
-			}
-			newSim.config = newConfig
+		ranks, err := s.createDataParallelRanks()
+		if err != nil {
+			return err
+		}
+		for _, rank := range ranks {
 			g.Go(func() error {
-				return newSim.startSim(ctx)
+				return rank.startSim(ctx)
 			})
 		}
 		s.logger = klog.LoggerWithValues(s.logger, "rank", 0)
+		s.logger.Info("Data parallel rank seed", "seed", s.config.Seed)
 	}
 	g.Go(func() error {
 		return s.startSim(ctx)
@@ -185,12 +387,90 @@ func (s *VllmSimulator) Start(ctx context.Context) error {
 	return nil
 }
 
+// createDataParallelRanks creates the simulators of the data parallel ranks 1 to data-parallel-size - 1.
+// Every rank has its own seed, derived from the base seed, so that the ranks' random behavior
+// is independent but reproducible, rank 0 keeps the base seed. The parameters overridden for a rank
+// in data-parallel-rank-overrides are set in its configuration, including rank 0.
+func (s *VllmSimulator) createDataParallelRanks() ([]*VllmSimulator, error) {
+	s.dpSeeds = make([]int64, s.config.DPSize)
+	for rank := range s.dpSeeds {
+		s.dpSeeds[rank] = common.DPRankSeed(s.config.Seed, rank)
+	}
+
+	ranks := make([]*VllmSimulator, 0, s.config.DPSize-1)
+	for dpRank := 1; dpRank < s.config.DPSize; dpRank++ {
+		newConfig, err := s.config.WithDPRankOverrides(dpRank)
+		if err != nil {
+			return nil, err
+		}
+		newConfig.Port = s.config.Port + dpRank
+		if s.config.GRPCPort != 0 {
+			newConfig.GRPCPort = s.config.GRPCPort + dpRank
+		}
+		newConfig.Seed = s.dpSeeds[dpRank]
+		if s.config.RecordTrace != "" {
+			newConfig.RecordTrace = rankFilePath(s.config.RecordTrace, dpRank)
+		}
+		if s.config.AccessLogFile != "" {
+			newConfig.AccessLogFile = rankFilePath(s.config.AccessLogFile, dpRank)
+		}
+		if s.config.UsageExportFile != "" {
+			newConfig.UsageExportFile = rankFilePath(s.config.UsageExportFile, dpRank)
+		}
+		if s.config.KVEventsRecordFile != "" {
+			newConfig.KVEventsRecordFile = rankFilePath(s.config.KVEventsRecordFile, dpRank)
+		}
+		newSim, err := New(klog.LoggerWithValues(s.logger, "rank", dpRank))
+		if err != nil {
+			return nil, err
+		}
+		newSim.config = newConfig
+		newSim.dpRank = dpRank
+		newSim.dpSeeds = s.dpSeeds
+		newSim.logger.Info("Data parallel rank seed", "seed", newConfig.Seed)
+		if overrides, ok := s.config.DPRankOverrides[dpRank]; ok {
+			newSim.logger.Info("Data parallel rank overrides", "overrides", overrides)
+		}
+		ranks = append(ranks, newSim)
+	}
+	s.dpRanks = ranks
+
+	// rank 0 is overridden after the other ranks copied its configuration
+	if overrides, ok := s.config.DPRankOverrides[0]; ok {
+		config, err := s.config.WithDPRankOverrides(0)
+		if err != nil {
+			return nil, err
+		}
+		s.config = config
+		s.logger.Info("Data parallel rank overrides", "rank", 0, "overrides", overrides)
+	}
+	return ranks, nil
+}
+
+// rankFilePath returns the path of an output file of the given data parallel rank, the rank is added to
+// the name of the file of rank 0, e.g. trace-rank1.jsonl
+func rankFilePath(path string, rank int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-rank%d%s", strings.TrimSuffix(path, ext), rank, ext)
+}
+
 func (s *VllmSimulator) startSim(ctx context.Context) error {
+	// the requests are processed until the server stops, also while the server drains after ctx is done
+	serverCtx := ctx
+	ctx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	defer stop()
+
+	s.setClockSkew(s.config.ClockSkew)
+
+	// the LoRAs from the configuration are loaded together at the simulator start
+	s.startTime = time.Now()
 	for _, lora := range s.config.LoraModules {
-		s.loraAdaptors.Store(lora.Name, "")
+		s.storeLora(loadedLora{name: lora.Name, path: lora.Path, baseModelName: lora.BaseModelName, loadTime: s.startTime})
 	}
 
-	common.InitRandom(s.config.Seed)
+	s.random = common.NewRandom(s.config.Seed)
+	s.systemFingerprint = newSystemFingerprint(s.config)
+	s.engineID = s.random.UUIDString()
 
 	// initialize prometheus metrics
 	err := s.createAndRegisterPrometheus()
@@ -202,13 +482,14 @@ func (s *VllmSimulator) startSim(ctx context.Context) error {
 	if s.config.TokenizersCacheDir != "" {
 		tokenizationConfig.TokenizersCacheDir = s.config.TokenizersCacheDir
 	}
-	s.tokenizer, err = tokenization.NewCachedHFTokenizer(tokenizationConfig.HFTokenizerConfig)
+	tokenizer, err := tokenization.NewCachedHFTokenizer(tokenizationConfig.HFTokenizerConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create tokenizer: %w", err)
 	}
+	s.tokenizer = newTokenizer(tokenizer, s.config, s.random)
 
 	if s.config.EnableKVCache {
-		s.kvcacheHelper, err = kvcache.NewKVCacheHelper(s.config, s.logger, s.kvCacheUsageChan, s.tokenizer)
+		s.kvcacheHelper, err = kvcache.NewKVCacheHelper(s.config, s.logger, s.kvCacheUsageChannel(), s.tokenizer)
 		if err != nil {
 			return err
 		}
@@ -216,10 +497,23 @@ func (s *VllmSimulator) startSim(ctx context.Context) error {
 		go s.kvcacheHelper.Run(ctx)
 	}
 
+	if s.latencyTable, err = common.LoadLatencyTable(s.config.LatencyProfile); err != nil {
+		return fmt.Errorf("latency profile error: %w", err)
+	}
+
 	err = s.initDataset(ctx)
 	if err != nil {
 		return fmt.Errorf("dataset initialization error: %w", err)
 	}
+	s.initResponseGenerator()
+
+	s.prefillSlots = newPrefillSlots(s.config.MaxConcurrentPrefills)
+	s.memory = newMemoryModel(s.config.EnableMemoryModel, s.config.KVCacheSize, s.config.TokenBlockSize)
+	s.waitingQueue.maxLoras = s.config.MaxLoras
+
+	if err := s.startTracing(ctx); err != nil {
+		return fmt.Errorf("tracing error: %w", err)
+	}
 
 	// run request processing workers
 	for i := 1; i <= s.config.MaxNumSeqs; i++ {
@@ -228,6 +522,38 @@ func (s *VllmSimulator) startSim(ctx context.Context) error {
 
 	s.startMetricsUpdaters(ctx)
 
+	if s.config.LoraIdleUnloadAfter > 0 {
+		go s.loraIdleUnloader(ctx)
+	}
+	if s.config.ConfigFile != "" {
+		go s.configReloader(ctx)
+	}
+
+	if err := s.startTraceRecording(ctx); err != nil {
+		return fmt.Errorf("trace recording error: %w", err)
+	}
+	if err := s.startAccessLog(ctx); err != nil {
+		return fmt.Errorf("access log error: %w", err)
+	}
+	if err := s.startRequestEvents(ctx); err != nil {
+		return fmt.Errorf("request events error: %w", err)
+	}
+	s.usage = newUsageAccountant(s.config.UsageRetention)
+	s.startUsageExport(ctx)
+	s.files = newFileStore()
+	s.batches = newBatchStore(ctx)
+
+	if err := s.startReplay(ctx); err != nil {
+		return fmt.Errorf("replay error: %w", err)
+	}
+
+	s.runSelfChecks(ctx)
+
+	if err := s.startGRPCServer(ctx); err != nil {
+		s.logger.Error(err, "Failed to start gRPC server")
+		return fmt.Errorf("gRPC server error: %w", err)
+	}
+
 	listener, err := s.newListener()
 	if err != nil {
 		s.logger.Error(err, "Failed to create listener")
@@ -235,11 +561,12 @@ func (s *VllmSimulator) startSim(ctx context.Context) error {
 	}
 
 	// start the http server with context support
-	return s.startServer(ctx, listener)
+	return s.startServer(serverCtx, listener)
 }
 
 func (s *VllmSimulator) initDataset(ctx context.Context) error {
-	randDataset := &dataset.BaseDataset{}
+	randDataset := dataset.NewBaseDataset(s.random)
+	randDataset.SetFinishReasonDistribution(s.config.FinishReasonDistribution)
 	err := randDataset.Init(ctx, s.logger, "", "", false)
 	if err != nil {
 		return fmt.Errorf("failed to initialize random dataset: %w", err)
@@ -251,7 +578,8 @@ func (s *VllmSimulator) initDataset(ctx context.Context) error {
 		return nil
 	}
 
-	custDataset := &dataset.CustomDataset{}
+	custDataset := dataset.NewCustomDataset(s.config.DatasetMaxMemoryBytes, s.config.DatasetFormat, s.random)
+	custDataset.SetFinishReasonDistribution(s.config.FinishReasonDistribution)
 	err = custDataset.Init(ctx, s.logger, s.config.DatasetPath, s.config.DatasetURL, s.config.DatasetInMemory)
 
 	if err == nil {
@@ -262,6 +590,7 @@ func (s *VllmSimulator) initDataset(ctx context.Context) error {
 	if strings.HasPrefix(err.Error(), "database is locked") {
 		s.logger.Info("Database is locked by another process, will use preset text for responses instead")
 		s.dataset = randDataset
+		s.datasetErr = err
 		return nil
 	}
 
@@ -273,114 +602,347 @@ func (s *VllmSimulator) Printf(format string, args ...interface{}) {
 	s.logger.Info("Server error", "msg", fmt.Sprintf(format, args...))
 }
 
-// handleCompletions general completion requests handler, support both text and chat completion APIs
-func (s *VllmSimulator) handleCompletions(ctx *fasthttp.RequestCtx, isChatCompletion bool) {
-	// Check if we should inject a failure
-	if shouldInjectFailure(s.config) {
-		failure := getRandomFailure(s.config)
-		s.sendCompletionError(ctx, failure, true)
+// getInjectedFailure returns the type of the failure to inject in the request and what injected it,
+// empty if no failure is injected. A failure requested in the request's header is injected regardless
+// of the failure injection rate. Returns false if the header is invalid, after sending the error
+func (s *VllmSimulator) getInjectedFailure(ctx *fasthttp.RequestCtx) (string, string, bool) {
+	if header := ctx.Request.Header.Peek(injectFailureHeader); s.config.AllowInjectionHeaders && len(header) > 0 {
+		failureType := string(header)
+		if !common.IsValidFailureType(failureType) {
+			s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(
+				fmt.Sprintf("Invalid failure type '%s' in %s header, valid types are: %s", failureType,
+					injectFailureHeader, common.ValidFailureTypesString()),
+				fasthttp.StatusBadRequest, nil), "")
+			return "", "", false
+		}
+		return failureType, injectedByHeader, true
+	}
+	if config := s.getRuntimeConfig(); shouldInjectFailure(config, s.random) {
+		return getRandomFailureType(config, s.random), injectedByRate, true
+	}
+	return "", "", true
+}
+
+// handleCompletions general completion requests handler, support both text and chat completion APIs,
+// and the responses API, whose requests are processed as chat completions
+func (s *VllmSimulator) handleCompletions(ctx *fasthttp.RequestCtx, isChatCompletion bool, isResponses bool) {
+	if s.draining.Load() {
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(drainingMessage,
+			fasthttp.StatusServiceUnavailable, nil), "")
+		return
+	}
+	if s.isModelLoading() {
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(modelLoadingMessage,
+			fasthttp.StatusServiceUnavailable, nil), "")
+		return
+	}
+	omitDoneSentinel := s.config.OmitDoneSentinel
+	failureType, injectedBy, ok := s.getInjectedFailure(ctx)
+	if !ok {
+		return
+	}
+	contentTypeFailure, streamFailure := "", ""
+	switch failureType {
+	case "":
+		// no failure is injected
+	case common.FailureTypeMissingDone:
+		// the request is processed, only the [DONE] sentinel is omitted from a streaming response
+		s.logger.Info("Injecting failure", "type", failureType, "injected_by", injectedBy)
+		omitDoneSentinel = true
+	case common.FailureTypeMissingContentType, common.FailureTypeWrongContentType:
+		// the request is processed, only the Content-Type header of a non-streaming response is corrupted
+		contentTypeFailure = failureType
+	case common.FailureTypeStreamError, common.FailureTypeStreamMalformed:
+		// the request is processed, a streaming response is cut in its middle
+		streamFailure = failureType
+	default:
+		s.reportInjectedFailure(failureType)
+		s.sendCompletionFailure(ctx, getFailure(s.config, failureType), injectedBy)
 		return
 	}
 
-	vllmReq, err := s.readRequest(ctx, isChatCompletion)
+	if s.config.UploadBandwidthBytesPerSec > 0 {
+		// simulate a slow upload of the request body, the delay precedes the waiting queue
+		readTime := time.Duration(float64(len(ctx.Request.Body())) /
+			float64(s.config.UploadBandwidthBytesPerSec) * float64(time.Second))
+		s.sleep(readTime)
+		ctx.Response.Header.Add(serverTimingHeader, serverTimingEntry(serverTimingRead, readTime))
+	}
+
+	var vllmReq openaiserverapi.CompletionRequest
+	var responsesReq *openaiserverapi.ResponsesRequest
+	var err error
+	if isResponses {
+		responsesReq, vllmReq, err = s.readResponsesRequest(ctx)
+	} else {
+		vllmReq, err = s.readRequest(ctx, isChatCompletion)
+	}
 	if err != nil {
-		s.logger.Error(err, "failed to read and parse request body")
-		ctx.Error("Failed to read and parse request body, "+err.Error(), fasthttp.StatusBadRequest)
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError("Failed to read and parse request body, "+
+			err.Error(), fasthttp.StatusBadRequest, nil), "")
 		return
 	}
+	if failureType == common.FailureTypeMissingDone && vllmReq.IsStream() {
+		s.reportInjectedFailure(failureType)
+	}
+	if contentTypeFailure != "" {
+		if vllmReq.IsStream() {
+			// streaming responses are excluded, the event stream content type is kept
+			contentTypeFailure = ""
+		} else {
+			s.logger.Info("Injecting failure", "type", failureType, "injected_by", injectedBy)
+			s.reportInjectedFailure(failureType)
+		}
+	}
+	if streamFailure != "" {
+		if vllmReq.IsStream() {
+			s.reportInjectedFailure(failureType)
+		} else {
+			// non-streaming responses are excluded
+			streamFailure = ""
+		}
+	}
+	vllmReq.SetVisibleContextTokens(s.config.VisibleContextTokens)
+	vllmReq.SetUseSamplingParams(s.config.EnableSamplingParams)
+	vllmReq.SetImageTokenCount(s.config.ImageTokenCount)
+	if s.config.PDRole == common.PDRolePrefill && !vllmReq.IsDoRemoteDecode() {
+		// in the prefill role every request is a prefill request
+		params := vllmReq.GetKVTransferParams()
+		params.DoRemoteDecode = true
+		vllmReq.SetKVTransferParams(params)
+	}
+	if kwargs := vllmReq.GetChatTemplateKwargs(); len(kwargs) > 0 {
+		delta := s.config.PromptTokensDelta(kwargs)
+		vllmReq.SetPromptTokensDelta(delta)
+		s.logger.V(4).Info("Chat template kwargs", "request id", vllmReq.GetRequestID(), "kwargs", kwargs,
+			"prompt tokens delta", delta)
+	}
 
 	errMsg, errCode := s.validateRequest(vllmReq)
 	if errMsg != "" {
-		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(errMsg, errCode, nil), false)
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(errMsg, errCode, nil), "")
+		return
+	}
+
+	if failureType := getTargetedFailureType(s.config, s.random, vllmReq.GetModel(),
+		vllmReq.GetNumberOfPromptTokens()); failureType != "" {
+		s.reportInjectedFailure(failureType)
+		s.sendCompletionFailure(ctx, getTargetedFailure(s.config, failureType, vllmReq.GetModel()), injectedByScenario)
 		return
 	}
 
+	if s.config.StrictAccept {
+		if errMsg := validateAcceptHeader(ctx, vllmReq.IsStream()); errMsg != "" {
+			s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(errMsg, fasthttp.StatusNotAcceptable, nil), "")
+			return
+		}
+	}
+
+	if isRateLimited(s.config) {
+		if compErr := s.checkRateLimits(ctx, vllmReq); compErr != nil {
+			s.sendCompletionFailure(ctx, *compErr, "")
+			return
+		}
+	}
+
+	if !isResponses && s.shouldPrefillRemotely(vllmReq) {
+		if err := s.prefillRemotely(ctx, vllmReq); err != nil {
+			s.logger.Error(err, "remote prefill failed", "request id", vllmReq.GetRequestID())
+			s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError("Remote prefill failed: "+err.Error(),
+				fasthttp.StatusBadGateway, nil), "")
+			return
+		}
+	}
+
+	// the request can be cancelled by its id
+	ctx.Response.Header.Set(requestIDHeader, vllmReq.GetRequestID())
+
+	// report the number of prompt tokens outside of the visible context window,
+	// the header is set here so that streaming and non-streaming responses agree
+	if truncated := vllmReq.GetNumberOfTruncatedPromptTokens(); truncated > 0 {
+		ctx.Response.Header.Add(truncatedPromptHeader, strconv.Itoa(truncated))
+	}
+
+	serviceTier := ""
+	if tier := vllmReq.GetServiceTier(); tier != "" {
+		serviceTier = resolveServiceTier(s.config, s.random, tier)
+		s.logger.Info("Service tier", "request id", vllmReq.GetRequestID(), "requested", tier, "resolved", serviceTier)
+		s.reportServiceTier(serviceTier)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	reqCtx := &openaiserverapi.CompletionReqCtx{
-		CompletionReq:    vllmReq,
-		HTTPReqCtx:       ctx,
-		IsChatCompletion: isChatCompletion,
-		Wg:               &wg,
+		CompletionReq:      vllmReq,
+		HTTPReqCtx:         ctx,
+		IsChatCompletion:   isChatCompletion,
+		Wg:                 &wg,
+		OmitDoneSentinel:   omitDoneSentinel,
+		ContentTypeFailure: contentTypeFailure,
+		StreamFailure:      streamFailure,
+		ServiceTier:        serviceTier,
+		ResponsesReq:       responsesReq,
+		Disconnected:       s.watchDisconnect(ctx, vllmReq.GetRequestID()),
 	}
-	// increment the waiting requests metric
-	s.waitingReqChan <- 1
-	if s.isLora(reqCtx.CompletionReq.GetModel()) {
-		// update loraInfo metrics with the new waiting request
-		s.lorasChan <- loraUsage{reqCtx.CompletionReq.GetModel(), waitingUsageState}
+	if !s.enqueueRequest(reqCtx) {
+		return
 	}
-	// send the request to the waiting queue (channel)
-	s.reqChan <- reqCtx
 	wg.Wait()
 }
 
+// enqueueRequest tracks the request as in flight and sends it to the waiting queue, the request
+// is processed by a worker that takes it from the queue. Returns false if the model of the request
+// was unloaded since the request was validated, after sending the error
+func (s *VllmSimulator) enqueueRequest(reqCtx *openaiserverapi.CompletionReqCtx) bool {
+	req := reqCtx.CompletionReq
+	ctx := reqCtx.HTTPReqCtx
+	s.loraAdmissionMutex.RLock()
+	if !s.isValidModel(req.GetModel()) {
+		s.loraAdmissionMutex.RUnlock()
+		s.stopDisconnectWatch(req.GetRequestID())
+		s.sendCompletionFailure(ctx, openaiserverapi.NewCompletionError(
+			fmt.Sprintf("The model `%s` does not exist.", req.GetModel()), fasthttp.StatusNotFound, nil), "")
+		return false
+	}
+	s.addInFlightRequest(req, reqCtx.ServiceTier, s.getTraceParent(ctx), getAPIKey(ctx), getAccessLogEntry(ctx))
+	s.loraAdmissionMutex.RUnlock()
+	s.publishRequestEvent(RequestEventQueued, req.GetRequestID())
+	// increment the waiting requests metric
+	s.reportRequestTransition(req.GetModel(), enqueuedRequestState)
+	// send the request to the waiting queue
+	lora := ""
+	if s.isLora(req.GetModel()) {
+		lora = req.GetModel()
+	}
+	s.waitingQueue.enqueue(reqCtx, req.GetPriority(), lora)
+	return true
+}
+
 func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Info("reqProcessingWorker stopped:", "worker id", id)
 			return
-		case reqCtx, ok := <-s.reqChan:
-			if !ok {
-				s.logger.Info("reqProcessingWorker worker exiting: reqChan closed")
-				return
+		case <-s.waitingQueue.requestAvailable():
+			reqCtx, overtaken := s.waitingQueue.dequeue()
+			if reqCtx == nil {
+				// the waiting requests are of LoRA adapters that wait for a free LoRA slot
+				continue
 			}
-
 			req := reqCtx.CompletionReq
 			model := req.GetModel()
 			displayModel := s.getDisplayedModelName(model)
+			if overtaken > 0 {
+				s.logger.V(4).Info("Request overtook waiting requests", "request id", req.GetRequestID(),
+					"priority", req.GetPriority(), "overtaken", overtaken)
+				s.reportQueueOvertakes(displayModel, overtaken)
+			}
 
-			// decrement waiting and increment running requests count
-			s.waitingReqChan <- -1
-			s.runReqChan <- 1
-
-			if s.isLora(model) {
-				// update loraInfo metric to reflect that
-				// the request has changed its status from waiting to running
-				s.lorasChan <- loraUsage{model, runningUsageState}
+			if isDisconnected(reqCtx.Disconnected) {
+				// the client disconnected or the request was cancelled while it was waiting, it is not processed
+				s.abortWaitingRequest(reqCtx)
+				continue
 			}
 
-			if s.config.EnableKVCache && !reqCtx.IsChatCompletion {
-				// kv cache is currently supported for /completion API only
+			busyTime := s.getWorkerBusyTime(id)
+			busyTime.start(time.Now())
+			s.markLoraUsed(model, time.Now())
+
+			// the request stays waiting until the kv cache has room for its prompt
+			s.waitForMemory(reqCtx)
+			if isDisconnected(reqCtx.Disconnected) {
+				s.abortWaitingRequest(reqCtx)
+				busyTime.finish(time.Now())
+				continue
+			}
+			// decrement waiting and increment running requests count
+			s.reportRequestTransition(model, startedRequestState)
+			if s.shouldPreempt(reqCtx) {
+				s.preempt(reqCtx)
+				busyTime.finish(time.Now())
+				continue
+			}
+			queueTime := s.startInFlightRequest(req.GetRequestID(), id)
+			// the wait for a prefill slot counts as queue time, the slot is released when the prefill ends
+			queueTime += s.acquirePrefillSlot()
+			s.reportRequestQueueTime(displayModel, queueTime)
+			s.reportQueueWait(displayModel, queueTime)
+			s.publishRequestEvent(RequestEventStarted, req.GetRequestID())
+			reqCtx.HTTPReqCtx.Response.Header.Add(serverTimingHeader, serverTimingEntry(serverTimingQueue, queueTime))
+
+			if s.config.EnableKVCache {
 				if err := s.kvcacheHelper.OnRequestStart(req); err != nil {
-					s.sendCompletionError(reqCtx.HTTPReqCtx, openaiserverapi.NewCompletionError(err.Error(), fasthttp.StatusInternalServerError, nil), false)
+					s.sendCompletionError(reqCtx.HTTPReqCtx, openaiserverapi.NewCompletionError(err.Error(), fasthttp.StatusInternalServerError, nil), "")
 				}
 			}
 
-			var responseTokens []string
-			var finishReason string
-			var err error
-			var toolCalls []openaiserverapi.ToolCall
-			var completionTokens int
-			if reqCtx.IsChatCompletion &&
-				req.GetToolChoice() != openaiserverapi.ToolChoiceNone &&
-				req.GetTools() != nil {
-				toolCalls, completionTokens, err =
-					openaiserverapi.CreateToolCalls(req.GetTools(), req.GetToolChoice(), s.config)
-				finishReason = dataset.ToolsFinishReason
+			if reqCtx.EmbeddingReq != nil {
+				s.processEmbeddingRequest(reqCtx, displayModel)
+				reqCtx.Wg.Done()
+				continue
 			}
-			if toolCalls == nil && err == nil {
-				// Either no tool calls were defined, or we randomly chose not to create tool calls,
-				// so we generate a response text.
-				responseTokens, finishReason, err = s.dataset.GetTokens(req, s.config.Mode)
-				completionTokens += len(responseTokens)
+
+			// a refusal is decided for the request, all of its choices are refused
+			random := s.getRequestRandom(req)
+			reqCtx.IsRefusal = reqCtx.IsChatCompletion && shouldRefuse(s.config, random)
+			// the choices of every prompt of a batch are generated separately, the index of
+			// choice j of prompt i is i*n+j. With best_of, best_of candidates are generated for
+			// every prompt and all of them are counted in the usage, the first n are returned
+			promptReqs := req.GetPromptRequests()
+			choices := make([]responseChoice, 0, len(promptReqs)*req.GetN())
+			completionTokens := 0
+			var err error
+		generation:
+			for _, promptReq := range promptReqs {
+				for candidate := range req.GetBestOf() {
+					var choice responseChoice
+					if choice, err = s.createResponseChoice(reqCtx, promptReq, random); err != nil {
+						break generation
+					}
+					if candidate < req.GetN() {
+						choices = append(choices, choice)
+					}
+					completionTokens += choice.nTokens
+				}
 			}
 			if err != nil {
 				prefix := ""
 				if reqCtx.IsChatCompletion {
-					prefix = "failed to create chat response"
+					prefix = "failed to create chat response, "
 				} else {
-					prefix = "failed to create text response"
+					prefix = "failed to create text response, "
 				}
-				s.logger.Error(err, prefix)
-				reqCtx.HTTPReqCtx.Error(prefix+err.Error(), fasthttp.StatusBadRequest)
+				s.releasePrefillSlot()
+				s.publishRequestEnd(req.GetRequestID(), 0, nil, err.Error())
+				s.logRequestEnd(req.GetRequestID(), 0, nil)
+				s.sendCompletionFailure(reqCtx.HTTPReqCtx, openaiserverapi.NewCompletionError(prefix+err.Error(),
+					fasthttp.StatusBadRequest, nil), "")
+				s.responseSentCallback(displayModel, req.GetRequestID())
 			} else {
+				// the prompt is processed once for all the choices
 				usageData := openaiserverapi.Usage{
 					PromptTokens:     req.GetNumberOfPromptTokens(),
 					CompletionTokens: completionTokens,
 					TotalTokens:      req.GetNumberOfPromptTokens() + completionTokens,
 				}
-				if req.IsStream() {
+				if reqCtx.ResponsesReq != nil && req.IsStream() {
+					s.sendResponsesStreamingResponse(
+						&streamingContext{
+							ctx:                 reqCtx.HTTPReqCtx,
+							requestID:           req.GetRequestID(),
+							isChatCompletion:    reqCtx.IsChatCompletion,
+							model:               displayModel,
+							doRemotePrefill:     req.IsDoRemotePrefill(),
+							nPromptTokens:       usageData.PromptTokens,
+							nCachedPromptTokens: reqCtx.CompletionReq.GetNumberOfCachedPromptTokens(),
+							isRefusal:           reqCtx.IsRefusal,
+							holdsPrefillSlot:    true,
+							disconnected:        reqCtx.Disconnected,
+						},
+						reqCtx.ResponsesReq, choices[0], &usageData,
+					)
+				} else if req.IsStream() {
 					var usageDataToSend *openaiserverapi.Usage
 					if req.IncludeUsage() {
 						usageDataToSend = &usageData
@@ -388,39 +950,173 @@ func (s *VllmSimulator) reqProcessingWorker(ctx context.Context, id int) {
 					s.sendStreamingResponse(
 						&streamingContext{
 							ctx:                 reqCtx.HTTPReqCtx,
+							requestID:           req.GetRequestID(),
 							isChatCompletion:    reqCtx.IsChatCompletion,
 							model:               displayModel,
 							doRemotePrefill:     req.IsDoRemotePrefill(),
 							nPromptTokens:       usageData.PromptTokens,
 							nCachedPromptTokens: reqCtx.CompletionReq.GetNumberOfCachedPromptTokens(),
+							nImages:             req.GetNumberOfImages(),
+							nRemoteBlocks:       len(req.GetKVTransferParams().RemoteBlockIds),
+							omitDoneSentinel:    reqCtx.OmitDoneSentinel,
+							streamFailure:       reqCtx.StreamFailure,
+							isRefusal:           reqCtx.IsRefusal,
+							serviceTier:         reqCtx.ServiceTier,
+							holdsPrefillSlot:    true,
+							disconnected:        reqCtx.Disconnected,
 						},
-						responseTokens, toolCalls, finishReason, usageDataToSend,
+						choices, usageDataToSend,
 					)
 				} else {
 					if req.IsDoRemoteDecode() {
 						// in case this is prefill pod processing, return special finish reason
-						finishReason = dataset.RemoteDecodeFinishReason
+						for i := range choices {
+							choices[i].finishReason = dataset.RemoteDecodeFinishReason
+						}
 					}
 
-					s.sendResponse(reqCtx, responseTokens, toolCalls, displayModel, finishReason, &usageData)
+					s.sendResponse(reqCtx, choices, displayModel, &usageData)
 				}
 			}
+			// the worker's busy time of the request ends when its response is sent, a streaming response
+			// is sent after the worker is released
 			reqCtx.Wg.Done()
 		}
 	}
 }
 
-// request processing finished
-func (s *VllmSimulator) responseSentCallback(model string, isChatCompletion bool, requestID string) {
-	// decriment running requests count
-	s.runReqChan <- -1
+// responseChoice is the generated content of a single choice of a completion response
+type responseChoice struct {
+	// tokens is the tokenized text of the choice, or of its refusal
+	tokens []string
+	// toolCalls are the tool calls of the choice, nil if the choice is a text
+	toolCalls []openaiserverapi.ToolCall
+	// finishReason is the finish reason of the choice
+	finishReason string
+	// nTokens is the number of completion tokens of the choice
+	nTokens int
+}
+
+// createResponseChoice generates the content of a single choice of the given request,
+// every choice of a request is generated separately, req is the request of a single prompt
+// of the request of reqCtx, random is the random generator of the request
+func (s *VllmSimulator) createResponseChoice(reqCtx *openaiserverapi.CompletionReqCtx,
+	req openaiserverapi.CompletionRequest, random *common.Random) (responseChoice, error) {
+	var choice responseChoice
+	var err error
+	if reqCtx.IsRefusal {
+		// a refusal takes precedence over the tool calls
+		choice.tokens = common.Tokenize(getRandomRefusal(s.config, random))
+		choice.nTokens = len(choice.tokens)
+		choice.finishReason = dataset.StopFinishReason
+		return choice, nil
+	}
+	if reqCtx.IsChatCompletion &&
+		// an empty tools array is treated as no tools
+		req.GetToolChoice() != openaiserverapi.ToolChoiceNone &&
+		len(req.GetTools()) > 0 {
+		tools := req.GetTools()
+		if name := req.GetToolChoiceFunctionName(); name != "" {
+			// the named function is the only one that can be called, its existence
+			// was checked in the request validation
+			tool, _ := openaiserverapi.FindTool(tools, name)
+			tools = []openaiserverapi.Tool{tool}
+		}
+		toolChoice := req.GetToolChoice()
+		if len(s.config.FinishReasonDistribution) > 0 && toolChoice != openaiserverapi.ToolChoiceRequired {
+			// the tools are called by the share of tool_calls in the finish reason distribution
+			toolChoice = openaiserverapi.ToolChoiceNone
+			if dataset.DrawFinishReason(random, s.config.FinishReasonDistribution, dataset.StopFinishReason,
+				dataset.LengthFinishReason, dataset.ContentFilterFinishReason,
+				dataset.ToolsFinishReason) == dataset.ToolsFinishReason {
+				toolChoice = openaiserverapi.ToolChoiceRequired
+			}
+		}
+		if toolChoice != openaiserverapi.ToolChoiceNone {
+			choice.toolCalls, choice.nTokens, err = openaiserverapi.CreateToolCalls(tools, toolChoice,
+				req.IsParallelToolCalls(), s.config, random)
+			choice.finishReason = dataset.ToolsFinishReason
+		}
+	}
+	if choice.toolCalls == nil && err == nil {
+		// Either no tool calls were defined, or we randomly chose not to create tool calls,
+		// so we generate a response text.
+		if format := req.GetResponseFormat(); format != nil && format.Type == openaiserverapi.ResponseFormatJSONSchema {
+			choice.tokens, choice.finishReason, err = s.createStructuredResponseTokens(req, format.JSONSchema.Schema, random)
+		} else {
+			choice.tokens, choice.finishReason, err = s.getResponseTokens(reqCtx.HTTPReqCtx, req, random)
+		}
+		choice.nTokens += len(choice.tokens)
+	}
+	return choice, err
+}
+
+// createStructuredResponseTokens generates a JSON response that follows the given schema, in every mode,
+// the response is cut if it is longer than the maximal number of completion tokens of the request
+func (s *VllmSimulator) createStructuredResponseTokens(req openaiserverapi.CompletionRequest,
+	schema map[string]any, random *common.Random) ([]string, string, error) {
+	text, err := openaiserverapi.CreateStructuredResponse(schema, s.config, random)
+	if err != nil {
+		return nil, "", err
+	}
+	tokens := common.Tokenize(text)
+	if maxTokens := req.GetMaxCompletionTokens(); maxTokens != nil && int64(len(tokens)) > *maxTokens {
+		return tokens[:*maxTokens], dataset.LengthFinishReason, nil
+	}
+	return tokens, dataset.StopFinishReason, nil
+}
 
-	if s.isLora(model) {
-		// update loraInfo metrics to reflect that the request processing has been finished
-		s.lorasChan <- loraUsage{model, doneUsageState}
+// getRequestRandom returns the random generator of the response content of the given request, a request
+// with a seed gets its own generator, so that identical requests with the same seed get identical responses,
+// as do identical requests with a zero temperature when the sampling parameters influence the generation
+func (s *VllmSimulator) getRequestRandom(req openaiserverapi.CompletionRequest) *common.Random {
+	if seed := req.GetSeed(); seed != nil {
+		return common.NewRandom(*seed)
+	}
+	if params := req.GetSamplingParams(); params != nil && params.GetTemperature() == 0 {
+		// greedy generation, identical requests get identical responses
+		hash := sha256.Sum256([]byte(req.GetModel() + "/" + req.GetFullPrompt()))
+		return common.NewRandom(int64(binary.BigEndian.Uint64(hash[:8])))
 	}
+	return s.random
+}
+
+// newSystemFingerprint returns the system fingerprint of the responses, which identifies the model
+// and the mode of the simulator
+func newSystemFingerprint(config *common.Configuration) string {
+	hash := sha256.Sum256([]byte(config.Model + "/" + config.Mode))
+	return systemFingerprintPrefix + hex.EncodeToString(hash[:])[:10]
+}
+
+// setClockSkew sets the skew of the externally visible timestamps
+func (s *VllmSimulator) setClockSkew(skew time.Duration) {
+	s.clockSkew.Store(int64(skew))
+}
+
+// externalTime returns the given time as reported to the outside world, including the clock skew
+func (s *VllmSimulator) externalTime(t time.Time) time.Time {
+	return t.Add(time.Duration(s.clockSkew.Load()))
+}
+
+// externalNow returns the current time as reported to the outside world, including the clock skew,
+// the real clock is used for scheduling and latencies
+func (s *VllmSimulator) externalNow() time.Time {
+	return s.externalTime(time.Now())
+}
+
+// request processing finished
+func (s *VllmSimulator) responseSentCallback(model string, requestID string) {
+	// decriment running requests count
+	s.reportRequestTransition(model, finishedRequestState)
+	s.finishWorkerBusyTime(requestID)
+	// the lora is idle from the end of its last request
+	s.markLoraUsed(model, time.Now())
+	s.removeInFlightRequest(requestID)
+	s.stopDisconnectWatch(requestID)
+	s.waitingQueue.finishRequest(requestID)
+	s.freeMemory(requestID)
 
-	if s.config.EnableKVCache && !isChatCompletion {
+	if s.config.EnableKVCache {
 		if err := s.kvcacheHelper.OnRequestEnd(requestID); err != nil {
 			s.logger.Error(err, "kv cache failed to process request end")
 		}
@@ -429,111 +1125,237 @@ func (s *VllmSimulator) responseSentCallback(model string, isChatCompletion bool
 
 // createCompletionResponse creates the response for completion requests, supports both completion request types (text and chat)
 // as defined by isChatCompletion
-// respTokens - tokenized content to be sent in the response
-// toolCalls - tool calls to be sent in the response
-// finishReason - a pointer to string that represents finish reason, can be nil or stop or length, ...
+// choices - the content of the choices to be sent in the response, the choices are indexed by their order
 // usageData - usage (tokens statistics) for this response
 // modelName - display name returned to the client and used in metrics. It is either the first alias
 // from --served-model-name (for a base-model request) or the LoRA adapter name (for a LoRA request).
-func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, respTokens []string, toolCalls []openaiserverapi.ToolCall,
-	finishReason *string, usageData *openaiserverapi.Usage, modelName string, doRemoteDecode bool) openaiserverapi.CompletionResponse {
+// isRefusal - the chat completion is refused, the tokens of the choices are sent as the refusal
+// serviceTier - the service tier the request was processed in, empty if the request didn't ask for a service tier
+func (s *VllmSimulator) createCompletionResponse(isChatCompletion bool, choices []responseChoice,
+	usageData *openaiserverapi.Usage, modelName string, doRemoteDecode bool,
+	isRefusal bool, serviceTier string) openaiserverapi.CompletionResponse {
 	baseResp := openaiserverapi.BaseCompletionResponse{
-		ID:      chatComplIDPrefix + common.GenerateUUIDString(),
-		Created: time.Now().Unix(),
-		Model:   modelName,
-		Usage:   usageData,
+		ID:                chatComplIDPrefix + s.random.UUIDString(),
+		Created:           s.externalNow().Unix(),
+		Model:             modelName,
+		Usage:             usageData,
+		ServiceTier:       serviceTier,
+		SystemFingerprint: s.systemFingerprint,
+	}
+	var contentFilterResults *openaiserverapi.ContentFilterResults
+	if s.isAzureFlavor() {
+		baseResp.PromptFilterResults = openaiserverapi.NewSafePromptFilterResults()
+		contentFilterResults = openaiserverapi.NewSafeContentFilterResults()
 	}
 
 	if doRemoteDecode {
-		// add special fields related to the prefill pod special behavior
-		baseResp.DoRemoteDecode = true
-		baseResp.DoRemotePrefill = false
-		// currently remote prefill information is hard-coded
-		baseResp.RemoteBlockIds = []string{"DUMMY_ID"}
-		baseResp.RemoteEngineId = "DUMMY_ID"
-		baseResp.RemoteHost = "DUMMY"
-		baseResp.RemotePort = 1234
+		// add special fields related to the prefill pod special behavior, the parameters
+		// the decode pod needs to transfer the kv cache of the prompt
+		baseResp.SetKVTransferParams(s.getPrefillTransferParams(baseResp.ID, usageData.PromptTokens))
 	}
 
-	baseChoice := openaiserverapi.BaseResponseChoice{Index: 0, FinishReason: finishReason}
-
-	respText := strings.Join(respTokens, "")
 	if isChatCompletion {
 		baseResp.Object = chatCompletionObject
 
-		message := openaiserverapi.Message{Role: openaiserverapi.RoleAssistant}
-		if toolCalls != nil {
-			message.ToolCalls = toolCalls
-		} else {
-			message.Content = openaiserverapi.Content{Raw: respText}
+		respChoices := make([]openaiserverapi.ChatRespChoice, 0, len(choices))
+		for i, choice := range choices {
+			message := openaiserverapi.Message{Role: openaiserverapi.RoleAssistant}
+			respText := strings.Join(choice.tokens, "")
+			if isRefusal {
+				message.Refusal = respText
+			} else if choice.toolCalls != nil {
+				message.ToolCalls = choice.toolCalls
+			} else {
+				message.Content = openaiserverapi.Content{Raw: respText}
+			}
+			respChoices = append(respChoices, openaiserverapi.ChatRespChoice{Message: message,
+				BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: i, FinishReason: &choices[i].finishReason,
+					ContentFilterResults: contentFilterResults}})
 		}
 		return &openaiserverapi.ChatCompletionResponse{
 			BaseCompletionResponse: baseResp,
-			Choices:                []openaiserverapi.ChatRespChoice{{Message: message, BaseResponseChoice: baseChoice}},
+			Choices:                respChoices,
 		}
 	}
 
 	baseResp.Object = textCompletionObject
+	respChoices := make([]openaiserverapi.TextRespChoice, 0, len(choices))
+	for i, choice := range choices {
+		respChoices = append(respChoices, openaiserverapi.TextRespChoice{Text: strings.Join(choice.tokens, ""),
+			BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: i, FinishReason: &choices[i].finishReason,
+				ContentFilterResults: contentFilterResults}})
+	}
 	return &openaiserverapi.TextCompletionResponse{
 		BaseCompletionResponse: baseResp,
-		Choices:                []openaiserverapi.TextRespChoice{{BaseResponseChoice: baseChoice, Text: respText}},
+		Choices:                respChoices,
+	}
+}
+
+// createRequestResponse creates the response of the given request, a responses API response for a request
+// of the responses API, otherwise a completion response
+func (s *VllmSimulator) createRequestResponse(reqCtx *openaiserverapi.CompletionReqCtx, choices []responseChoice,
+	usageData *openaiserverapi.Usage, modelName string) openaiserverapi.CompletionResponse {
+	if reqCtx.ResponsesReq != nil {
+		return s.createResponsesResponse(reqCtx.ResponsesReq, choices[0], usageData,
+			reqCtx.CompletionReq.GetNumberOfCachedPromptTokens(), modelName, reqCtx.IsRefusal)
 	}
+	return s.createCompletionResponse(reqCtx.IsChatCompletion, choices, usageData, modelName,
+		reqCtx.CompletionReq.IsDoRemoteDecode(), reqCtx.IsRefusal, reqCtx.ServiceTier)
 }
 
 // sendResponse sends response for completion API, supports both completions (text and chat)
 // according the value of isChatCompletion in reqCtx
-// respTokens - tokenized content to be sent in the response
-// toolCalls - tool calls to be sent in the response
+// choices - the content of the choices to be sent in the response
 // modelName - display name returned to the client and used in metrics. It is either the first alias
 // from --served-model-name (for a base-model request) or the LoRA adapter name (for a LoRA request).
-// finishReason - a pointer to string that represents finish reason, can be nil, stop, length, or tools
 // usageData - usage (tokens statistics) for this response
-func (s *VllmSimulator) sendResponse(reqCtx *openaiserverapi.CompletionReqCtx, respTokens []string, toolCalls []openaiserverapi.ToolCall,
-	modelName string, finishReason string, usageData *openaiserverapi.Usage) {
-	resp := s.createCompletionResponse(reqCtx.IsChatCompletion, respTokens, toolCalls, &finishReason, usageData, modelName,
-		reqCtx.CompletionReq.IsDoRemoteDecode())
+func (s *VllmSimulator) sendResponse(reqCtx *openaiserverapi.CompletionReqCtx, choices []responseChoice,
+	modelName string, usageData *openaiserverapi.Usage) {
+	resp := s.createRequestResponse(reqCtx, choices, usageData, modelName)
 
 	// calculate how long to wait before returning the response, time is based on number of tokens
+	// and the service tier, the response is cut at the maximal stream duration.
+	// The choices are decoded in parallel, so the time is based on the longest choice
+	nDecodeTokens := 0
+	for _, choice := range choices {
+		nDecodeTokens = max(nDecodeTokens, choice.nTokens)
+	}
+	deadline := s.getResponseDeadline(time.Now())
+	latencyFactor := s.serviceTierLatencyFactor(reqCtx.ServiceTier)
 	nCachedPromptTokens := reqCtx.CompletionReq.GetNumberOfCachedPromptTokens()
-	ttft := s.getWaitTimeToFirstToken(usageData.PromptTokens, nCachedPromptTokens, reqCtx.CompletionReq.IsDoRemotePrefill())
-	time.Sleep(time.Duration(ttft) * time.Millisecond)
-	for range usageData.CompletionTokens - 1 {
-		perTokenLatency := s.getInterTokenLatency()
-		time.Sleep(time.Duration(perTokenLatency) * time.Millisecond)
+	nTransferredTokens := s.getTransferredPromptTokens(usageData.PromptTokens,
+		len(reqCtx.CompletionReq.GetKVTransferParams().RemoteBlockIds), reqCtx.CompletionReq.IsDoRemotePrefill())
+	ttft := s.getWaitTimeToFirstToken(reqCtx.CompletionReq.GetModel(), nTransferredTokens, nCachedPromptTokens,
+		reqCtx.CompletionReq.IsDoRemotePrefill()) +
+		s.getImagePrefillTime(reqCtx.CompletionReq.GetNumberOfImages(), reqCtx.CompletionReq.IsDoRemotePrefill())
+	nPrefillTokens := localPrefillTokens(usageData.PromptTokens, nCachedPromptTokens,
+		reqCtx.CompletionReq.IsDoRemotePrefill())
+	s.reportIterationTokens(modelName, nPrefillTokens)
+	endPrefill := s.startPrefill(nPrefillTokens)
+	s.markPrefillStart(reqCtx.CompletionReq.GetRequestID(), reqCtx.CompletionReq.IsDoRemotePrefill())
+	_, inTime := sleepBefore(int(float64(ttft)*latencyFactor), s.getTimeScale(), deadline, reqCtx.Disconnected)
+	endPrefill()
+	s.releasePrefillSlot()
+	nGeneratedTokens := 0
+	if inTime && !s.reserveMemory(modelName, reqCtx.CompletionReq.GetRequestID(),
+		usageData.PromptTokens+choicesTokens(choices, 1), reqCtx.Disconnected) {
+		inTime = false
+	}
+	if inTime {
+		nGeneratedTokens = min(nDecodeTokens, 1)
+		if nGeneratedTokens > 0 {
+			s.markFirstToken(reqCtx.CompletionReq.GetRequestID())
+		}
+	}
+	for inTime && nGeneratedTokens < nDecodeTokens {
+		if !s.reserveMemory(modelName, reqCtx.CompletionReq.GetRequestID(),
+			usageData.PromptTokens+choicesTokens(choices, nGeneratedTokens+1), reqCtx.Disconnected) {
+			break
+		}
+		s.reportIterationTokens(modelName, s.numDecodingRequests())
+		perTokenLatency := s.getInterTokenLatency(reqCtx.CompletionReq.GetModel(), nGeneratedTokens)
+		if _, inTime = sleepBefore(int(float64(perTokenLatency)*latencyFactor), s.getTimeScale(), deadline,
+			reqCtx.Disconnected); inTime {
+			nGeneratedTokens++
+		}
 	}
 
-	s.sendCompletionResponse(reqCtx.HTTPReqCtx, resp)
+	if isDisconnected(reqCtx.Disconnected) {
+		// no response is sent to a client that disconnected, a cancelled request gets an error
+		s.logger.Info("The request is aborted", "request id", reqCtx.CompletionReq.GetRequestID(),
+			"generated tokens", nGeneratedTokens)
+		s.reportRequestAborted(modelName)
+		generatedTokens := 0
+		for _, choice := range choices {
+			generatedTokens += min(nGeneratedTokens, choice.nTokens)
+		}
+		s.traceRequest(reqCtx.CompletionReq.GetRequestID(), generatedTokens, abortFinishReasons(len(choices)))
+		s.publishRequestEnd(reqCtx.CompletionReq.GetRequestID(), generatedTokens, abortFinishReasons(len(choices)),
+			errRequestAborted.Error())
+		s.logRequestEnd(reqCtx.CompletionReq.GetRequestID(), generatedTokens, abortFinishReasons(len(choices)))
+		s.sendCancelledError(reqCtx.HTTPReqCtx, reqCtx.CompletionReq.GetRequestID())
+		s.responseSentCallback(modelName, reqCtx.CompletionReq.GetRequestID())
+		return
+	}
 
-	s.responseSentCallback(modelName, reqCtx.IsChatCompletion, reqCtx.CompletionReq.GetRequestID())
+	if !inTime {
+		// the response contains the tokens generated before the deadline, partial tool calls are dropped
+		s.logger.Info("Response cut at the maximal stream duration", "request id", reqCtx.CompletionReq.GetRequestID(),
+			"generated tokens", nGeneratedTokens, "completion tokens", usageData.CompletionTokens)
+		s.reportStreamDurationTruncation()
+		cutChoices := make([]responseChoice, 0, len(choices))
+		completionTokens := 0
+		for _, choice := range choices {
+			cutChoices = append(cutChoices, responseChoice{
+				tokens:       choice.tokens[:min(nGeneratedTokens, len(choice.tokens))],
+				finishReason: s.config.MaxStreamDurationFinishReason,
+			})
+			completionTokens += min(nGeneratedTokens, choice.nTokens)
+		}
+		usageData.CompletionTokens = completionTokens
+		usageData.TotalTokens = usageData.PromptTokens + completionTokens
+		resp = s.createRequestResponse(reqCtx, cutChoices, usageData, modelName)
+		choices = cutChoices
+	}
+
+	s.sendCompletionResponse(reqCtx.HTTPReqCtx, resp, reqCtx.ContentTypeFailure)
+
+	s.reportRequestLatencies(reqCtx.CompletionReq.GetRequestID(), modelName, nGeneratedTokens)
+	s.reportRequestTokens(reqCtx.CompletionReq.GetRequestID(), modelName, usageData.CompletionTokens)
+	s.recordUsage(reqCtx.CompletionReq.GetRequestID(), modelName, usageData.CompletionTokens)
+	s.reportRequestSuccess(modelName, choicesFinishReasons(choices))
+	s.traceRequest(reqCtx.CompletionReq.GetRequestID(), usageData.CompletionTokens, choicesFinishReasons(choices))
+	s.publishRequestEnd(reqCtx.CompletionReq.GetRequestID(), usageData.CompletionTokens, choicesFinishReasons(choices), "")
+	s.logRequestEnd(reqCtx.CompletionReq.GetRequestID(), usageData.CompletionTokens, choicesFinishReasons(choices))
+	s.responseSentCallback(modelName, reqCtx.CompletionReq.GetRequestID())
+}
+
+// createModelInfo creates the info of a model in the /models response, with the default permissions of vLLM
+// and the model's context window
+func (s *VllmSimulator) createModelInfo(id string, root string, parent *string,
+	created time.Time) vllmapi.ModelsResponseModelInfo {
+	return vllmapi.ModelsResponseModelInfo{
+		ID:          id,
+		Object:      vllmapi.ObjectModel,
+		Created:     created.Unix(),
+		OwnedBy:     "vllm",
+		Root:        root,
+		Parent:      parent,
+		MaxModelLen: s.getMaxModelLen(id),
+		Permission: []vllmapi.ModelPermission{
+			vllmapi.NewModelPermission(modelPermissionIDPrefix+s.random.UUIDString(), created.Unix()),
+		},
+	}
 }
 
 // createModelsResponse creates and returns ModelResponse for the current state, returned array of models contains the base model + LoRA adapters if exist
 func (s *VllmSimulator) createModelsResponse() *vllmapi.ModelsResponse {
 	modelsResp := vllmapi.ModelsResponse{Object: "list", Data: []vllmapi.ModelsResponseModelInfo{}}
 
-	// Advertise every public model alias
+	// Advertise every public model alias, the root of the aliases is the model's path
 	for _, alias := range s.config.ServedModelNames {
-		modelsResp.Data = append(modelsResp.Data, vllmapi.ModelsResponseModelInfo{
-			ID:      alias,
-			Object:  vllmapi.ObjectModel,
-			Created: time.Now().Unix(),
-			OwnedBy: "vllm",
-			Root:    alias,
-			Parent:  nil,
-		})
-	}
-
-	// add LoRA adapter's info
-	parent := s.config.ServedModelNames[0]
-	for _, lora := range s.getLoras() {
-		modelsResp.Data = append(modelsResp.Data, vllmapi.ModelsResponseModelInfo{
-			ID:      lora,
-			Object:  vllmapi.ObjectModel,
-			Created: time.Now().Unix(),
-			OwnedBy: "vllm",
-			Root:    lora,
-			Parent:  &parent,
-		})
+		modelsResp.Data = append(modelsResp.Data, s.createModelInfo(alias, s.config.Model, nil, s.externalNow()))
+	}
+	for _, base := range s.config.AdditionalModels {
+		for _, alias := range base.ServedModelNames {
+			modelsResp.Data = append(modelsResp.Data, s.createModelInfo(alias, base.Name, nil, s.externalNow()))
+		}
+	}
+
+	// add LoRA adapter's info after the base models, sorted by load time,
+	// an adapter inherits the context window of its base model unless it has its own
+	for _, lora := range s.getLoadedLoras() {
+		// the root of an adapter is its path, and its parent is its base model,
+		// the first served model name by default
+		root, parent := lora.path, lora.baseModelName
+		if root == "" {
+			root = lora.name
+		}
+		if parent == "" {
+			parent = s.config.ServedModelNames[0]
+		}
+		modelsResp.Data = append(modelsResp.Data, s.createModelInfo(lora.name, root, &parent,
+			s.externalTime(lora.loadTime)))
 	}
 
 	return &modelsResp
@@ -19,17 +19,21 @@ package llmdinferencesim
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
 	kvcache "github.com/llm-d/llm-d-inference-sim/pkg/kv-cache"
+	vllmapi "github.com/llm-d/llm-d-inference-sim/pkg/vllm-api"
 	"github.com/llm-d/llm-d-kv-cache-manager/pkg/tokenization"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -90,11 +94,22 @@ func startServerWithArgs(ctx context.Context, mode string, args []string, envs m
 	}
 	s.config = config
 
-	for _, lora := range config.LoraModules {
-		s.loraAdaptors.Store(lora.Name, "")
+	return startSimulator(ctx, s)
+}
+
+// startSimulator starts the configured simulator with an in-memory listener, returns a client to it
+func startSimulator(ctx context.Context, s *VllmSimulator) (*http.Client, error) {
+	var err error
+	s.setClockSkew(s.config.ClockSkew)
+
+	s.startTime = time.Now()
+	for _, lora := range s.config.LoraModules {
+		s.storeLora(loadedLora{name: lora.Name, path: lora.Path, baseModelName: lora.BaseModelName, loadTime: s.startTime})
 	}
 
-	common.InitRandom(s.config.Seed)
+	s.random = common.NewRandom(s.config.Seed)
+	s.systemFingerprint = newSystemFingerprint(s.config)
+	s.engineID = s.random.UUIDString()
 
 	if err := s.createAndRegisterPrometheus(); err != nil {
 		return nil, err
@@ -104,13 +119,14 @@ func startServerWithArgs(ctx context.Context, mode string, args []string, envs m
 	if s.config.TokenizersCacheDir != "" {
 		tokenizationConfig.TokenizersCacheDir = s.config.TokenizersCacheDir
 	}
-	s.tokenizer, err = tokenization.NewCachedHFTokenizer(tokenizationConfig.HFTokenizerConfig)
+	tokenizer, err := tokenization.NewCachedHFTokenizer(tokenizationConfig.HFTokenizerConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tokenizer: %w", err)
 	}
+	s.tokenizer = newTokenizer(tokenizer, s.config, s.random)
 
 	if s.config.EnableKVCache {
-		s.kvcacheHelper, err = kvcache.NewKVCacheHelper(s.config, s.logger, s.kvCacheUsageChan, s.tokenizer)
+		s.kvcacheHelper, err = kvcache.NewKVCacheHelper(s.config, s.logger, s.kvCacheUsageChannel(), s.tokenizer)
 		if err != nil {
 			return nil, err
 		}
@@ -118,15 +134,28 @@ func startServerWithArgs(ctx context.Context, mode string, args []string, envs m
 		go s.kvcacheHelper.Run(ctx)
 	}
 
+	if s.latencyTable, err = common.LoadLatencyTable(s.config.LatencyProfile); err != nil {
+		return nil, fmt.Errorf("latency profile error: %w", err)
+	}
+
 	err = s.initDataset(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("dataset initialization error: %w", err)
 	}
+	s.initResponseGenerator()
 
 	// calculate number of tokens for user message,
 	// must be activated after parseCommandParamsAndLoadConfig since it initializes the random engine
 	userMsgTokens = int64(len(common.Tokenize(userMessage)))
 
+	s.prefillSlots = newPrefillSlots(s.config.MaxConcurrentPrefills)
+	s.memory = newMemoryModel(s.config.EnableMemoryModel, s.config.KVCacheSize, s.config.TokenBlockSize)
+	s.waitingQueue.maxLoras = s.config.MaxLoras
+
+	if err := s.startTracing(ctx); err != nil {
+		return nil, fmt.Errorf("tracing error: %w", err)
+	}
+
 	// run request processing workers
 	for i := 1; i <= s.config.MaxNumSeqs; i++ {
 		go s.reqProcessingWorker(ctx, i)
@@ -134,12 +163,39 @@ func startServerWithArgs(ctx context.Context, mode string, args []string, envs m
 
 	s.startMetricsUpdaters(ctx)
 
+	if s.config.LoraIdleUnloadAfter > 0 {
+		go s.loraIdleUnloader(ctx)
+	}
+	if s.config.ConfigFile != "" {
+		go s.configReloader(ctx)
+	}
+
+	if err := s.startTraceRecording(ctx); err != nil {
+		return nil, fmt.Errorf("trace recording error: %w", err)
+	}
+	if err := s.startAccessLog(ctx); err != nil {
+		return nil, fmt.Errorf("access log error: %w", err)
+	}
+	if err := s.startRequestEvents(ctx); err != nil {
+		return nil, fmt.Errorf("request events error: %w", err)
+	}
+	s.usage = newUsageAccountant(s.config.UsageRetention)
+	s.startUsageExport(ctx)
+	s.files = newFileStore()
+	s.batches = newBatchStore(ctx)
+
+	if err := s.startReplay(ctx); err != nil {
+		return nil, fmt.Errorf("replay error: %w", err)
+	}
+
+	s.runSelfChecks(ctx)
+
 	listener := fasthttputil.NewInmemoryListener()
 
 	// start the http server
 	go func() {
 		if err := s.startServer(ctx, listener); err != nil {
-			logger.Error(err, "error starting server")
+			s.logger.Error(err, "error starting server")
 		}
 	}()
 
@@ -596,6 +652,840 @@ var _ = Describe("Simulator", func() {
 			Expect(string(body)).To(ContainSubstring("BadRequestError"))
 		})
 	})
+
+	Context("visible context window", func() {
+		const visibleTokens = 10
+		// a prompt twice the size of the visible window, each word is a single token
+		words := make([]string, 2*visibleTokens)
+		for i := range words {
+			words[i] = fmt.Sprintf("w%d", i)
+		}
+		longPrompt := strings.Join(words, " ")
+		visibleSuffix := strings.Join(words[visibleTokens:], " ")
+
+		startVisibleWindowServer := func(ctx context.Context) *http.Client {
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho,
+				"--visible-context-tokens", strconv.Itoa(visibleTokens), "--max-model-len", "15"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return client
+		}
+
+		It("Should count and echo only the visible suffix of a text completion prompt", func() {
+			ctx := context.TODO()
+			client := startVisibleWindowServer(ctx)
+
+			// the raw prompt with max tokens exceeds max-model-len, the visible window does not
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, longPrompt, false)
+			params.MaxTokens = openai.Int(5)
+			var httpResp *http.Response
+			resp, err := openaiclient.Completions.New(ctx, params, option.WithResponseInto(&httpResp))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Usage.PromptTokens).To(Equal(int64(visibleTokens)))
+			Expect(httpResp.Header.Get(truncatedPromptHeader)).To(Equal(strconv.Itoa(visibleTokens)))
+			Expect(visibleSuffix).To(HavePrefix(resp.Choices[0].Text))
+
+			params.MaxTokens = openai.Int(visibleTokens)
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should report the same truncation in streaming and non-streaming chat completions", func() {
+			ctx := context.TODO()
+			client := startVisibleWindowServer(ctx)
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, longPrompt, false)
+			var httpResp *http.Response
+			resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithResponseInto(&httpResp))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Usage.PromptTokens).To(Equal(int64(visibleTokens)))
+			Expect(httpResp.Header.Get(truncatedPromptHeader)).To(Equal(strconv.Itoa(visibleTokens)))
+			Expect(resp.Choices[0].Message.Content).To(Equal(visibleSuffix))
+
+			openaiclient, params = getOpenAIClentAndChatParams(client, model, longPrompt, true)
+			var streamHTTPResp *http.Response
+			stream := openaiclient.Chat.Completions.NewStreaming(ctx, params, option.WithResponseInto(&streamHTTPResp))
+			defer func() {
+				err := stream.Close()
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			tokens := []string{}
+			var chunk openai.ChatCompletionChunk
+			for stream.Next() {
+				chunk = stream.Current()
+				for _, choice := range chunk.Choices {
+					if choice.FinishReason == "" {
+						tokens = append(tokens, choice.Delta.Content)
+					}
+				}
+			}
+			Expect(chunk.Usage.PromptTokens).To(Equal(int64(visibleTokens)))
+			Expect(streamHTTPResp.Header.Get(truncatedPromptHeader)).To(Equal(strconv.Itoa(visibleTokens)))
+			Expect(strings.Join(tokens, "")).To(Equal(visibleSuffix))
+		})
+
+		It("Should not add the header when the prompt fits the visible window", func() {
+			ctx := context.TODO()
+			client := startVisibleWindowServer(ctx)
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, visibleSuffix, false)
+			var httpResp *http.Response
+			resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithResponseInto(&httpResp))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Usage.PromptTokens).To(Equal(int64(visibleTokens)))
+			Expect(httpResp.Header.Get(truncatedPromptHeader)).To(BeEmpty())
+			Expect(resp.Choices[0].Message.Content).To(Equal(visibleSuffix))
+		})
+	})
+
+	Context("kv cache retention hint", func() {
+		It("Should accept a retention hint and reject a negative one", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+			_, err = openaiclient.Completions.New(ctx, params, option.WithJSONSet("x_sim_retain_kv_seconds", 30))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = openaiclient.Completions.New(ctx, params, option.WithJSONSet("x_sim_retain_kv_seconds", -1))
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+			Expect(openaiError.Message).To(ContainSubstring("x_sim_retain_kv_seconds"))
+		})
+	})
+
+	Context("finish reason distribution", func() {
+		startDistributionServer := func(ctx context.Context, distribution string) *http.Client {
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--finish-reason-distribution", distribution}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return client
+		}
+
+		It("Should end the responses by the finish reasons of the distribution", func() {
+			ctx := context.TODO()
+			client := startDistributionServer(ctx, `{"content_filter":100}`)
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices[0].FinishReason).To(Equal(dataset.ContentFilterFinishReason))
+
+			openaiclient, params = getOpenAIClentAndChatParams(client, model, userMessage, true)
+			stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+			finishReason := ""
+			for stream.Next() {
+				for _, choice := range stream.Current().Choices {
+					if choice.FinishReason != "" {
+						finishReason = choice.FinishReason
+					}
+				}
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(finishReason).To(Equal(dataset.ContentFilterFinishReason))
+		})
+
+		It("Should call the tools by the share of tool_calls", func() {
+			ctx := context.TODO()
+			client := startDistributionServer(ctx, `{"tool_calls":100}`)
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.Tools = tools
+			for range 5 {
+				resp, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices[0].FinishReason).To(Equal(dataset.ToolsFinishReason))
+				Expect(resp.Choices[0].Message.ToolCalls).NotTo(BeEmpty())
+			}
+
+			client = startDistributionServer(ctx, `{"stop":100}`)
+			openaiclient, params = getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.Tools = tools
+			for range 5 {
+				resp, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices[0].FinishReason).To(Equal(dataset.StopFinishReason))
+				Expect(resp.Choices[0].Message.ToolCalls).To(BeEmpty())
+			}
+		})
+	})
+
+	Context("fill-in-the-middle", func() {
+		const (
+			prefix = "def add(a, b):"
+			suffix = "\n    return result"
+		)
+
+		It("Should count and echo the suffix of a fill-in-the-middle completion", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--max-model-len", "15"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			nPromptTokens := int64(len(common.Tokenize(prefix)) + len(common.Tokenize(suffix)))
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, prefix, false)
+			params.Suffix = openai.String(suffix)
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Usage.PromptTokens).To(Equal(nPromptTokens))
+			// the echo is the prompt followed by the suffix
+			Expect(resp.Choices[0].Text).To(Equal(prefix + suffix))
+
+			// the tokens of the suffix count in the context window
+			params.MaxTokens = openai.Int(15 - nPromptTokens + 1)
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+			params.Suffix = openai.String("")
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("token ids prompt", func() {
+		DescribeTable("Should accept a prompt of token ids and count the ids as the prompt tokens",
+			func(mode string, prompt openai.CompletionNewParamsPromptUnion, expectedPromptTokens int64,
+				expectedEcho string) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, mode)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+				params.Prompt = prompt
+				resp, err := openaiclient.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Choices).To(HaveLen(1))
+				Expect(resp.Usage.PromptTokens).To(Equal(expectedPromptTokens))
+				Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.PromptTokens + resp.Usage.CompletionTokens))
+				if mode == common.ModeEcho {
+					Expect(resp.Choices[0].Text).To(Equal(expectedEcho))
+				}
+			},
+			func(mode string, prompt openai.CompletionNewParamsPromptUnion, expectedPromptTokens int64,
+				expectedEcho string) string {
+				return fmt.Sprintf("mode: %s, tokens: %v %v", mode, prompt.OfArrayOfTokens, prompt.OfArrayOfTokenArrays)
+			},
+			Entry(nil, common.ModeRandom, openai.CompletionNewParamsPromptUnion{OfArrayOfTokens: []int64{101, 7592, 2088}},
+				int64(3), ""),
+			Entry(nil, common.ModeEcho, openai.CompletionNewParamsPromptUnion{OfArrayOfTokens: []int64{101, 7592, 2088}},
+				int64(3), "<101><7592><2088>"),
+			Entry(nil, common.ModeEcho, openai.CompletionNewParamsPromptUnion{OfArrayOfTokenArrays: [][]int64{{1, 2, 3}}},
+				int64(3), "<1><2><3>"),
+		)
+
+		It("Should validate the context window by the number of token ids", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-model-len", "10"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+			params.MaxTokens = openai.Int(2)
+			params.Prompt = openai.CompletionNewParamsPromptUnion{OfArrayOfTokens: []int64{1, 2, 3, 4, 5, 6, 7, 8}}
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			params.Prompt = openai.CompletionNewParamsPromptUnion{OfArrayOfTokens: []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}}
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+		})
+
+		DescribeTable("Should reject an invalid prompt",
+			func(prompt string) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, common.ModeRandom)
+				Expect(err).NotTo(HaveOccurred())
+
+				body := fmt.Sprintf(`{"prompt": %s, "model": "%s"}`, prompt, model)
+				resp, err := client.Post("http://localhost/v1/completions", "application/json", strings.NewReader(body))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(resp.Body.Close()).To(Succeed())
+			},
+			Entry("empty array", `[]`),
+			Entry("empty token ids", `[[1, 2], []]`),
+			Entry("negative token id", `[1, -2]`),
+			Entry("mixed array", `[1, "a"]`),
+			Entry("object", `{"a": 1}`),
+		)
+	})
+
+	Context("prompt batch", func() {
+		DescribeTable("Should return n choices for every prompt of a batch",
+			func(prompt openai.CompletionNewParamsPromptUnion, expectedTexts []string, expectedPromptTokens []int) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, common.ModeEcho)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+				params.Prompt = prompt
+				params.N = openai.Int(2)
+				resp, err := openaiclient.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resp.Choices).To(HaveLen(2 * len(expectedTexts)))
+				promptTokens, completionTokens := 0, 0
+				for _, nTokens := range expectedPromptTokens {
+					promptTokens += nTokens
+					// echo mode, the prompt is the text of its 2 choices
+					completionTokens += 2 * nTokens
+				}
+				for i, choice := range resp.Choices {
+					Expect(choice.Index).To(Equal(int64(i)))
+					Expect(choice.Text).To(Equal(expectedTexts[i/2]))
+				}
+				Expect(resp.Usage.PromptTokens).To(Equal(int64(promptTokens)))
+				Expect(resp.Usage.CompletionTokens).To(Equal(int64(completionTokens)))
+			},
+			Entry("strings", openai.CompletionNewParamsPromptUnion{OfArrayOfStrings: []string{userMessage, "Hello world"}},
+				[]string{userMessage, "Hello world"},
+				[]int{len(common.Tokenize(userMessage)), len(common.Tokenize("Hello world"))}),
+			Entry("token ids", openai.CompletionNewParamsPromptUnion{OfArrayOfTokenArrays: [][]int64{{1, 2}, {3, 4, 5}}},
+				[]string{"<1><2>", "<3><4><5>"}, []int{2, 3}),
+		)
+
+		It("Should interleave the streamed choices of the prompts", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			prompts := []string{userMessage, "Hello world", "How are you today?"}
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, true)
+			params.Prompt = openai.CompletionNewParamsPromptUnion{OfArrayOfStrings: prompts}
+			stream := openaiclient.Completions.NewStreaming(ctx, params)
+			defer func() {
+				Expect(stream.Close()).To(Succeed())
+			}()
+			texts := make([]string, len(prompts))
+			var indices []int64
+			for stream.Next() {
+				for _, choice := range stream.Current().Choices {
+					texts[choice.Index] += choice.Text
+					indices = append(indices, choice.Index)
+				}
+			}
+			Expect(stream.Err()).NotTo(HaveOccurred())
+			Expect(texts).To(Equal(prompts))
+			// the first tokens of all the prompts are sent before their second tokens
+			Expect(indices[:len(prompts)]).To(Equal([]int64{0, 1, 2}))
+		})
+
+		It("Should validate the context window of every prompt separately", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--max-model-len", "10"}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+			params.MaxTokens = openai.Int(2)
+			params.Prompt = openai.CompletionNewParamsPromptUnion{OfArrayOfTokenArrays: [][]int64{
+				{1, 2, 3, 4, 5, 6, 7, 8}, {1, 2, 3, 4, 5, 6, 7, 8}}}
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(2))
+			Expect(resp.Usage.PromptTokens).To(Equal(int64(16)))
+
+			params.Prompt = openai.CompletionNewParamsPromptUnion{OfArrayOfTokenArrays: [][]int64{
+				{1, 2, 3}, {1, 2, 3, 4, 5, 6, 7, 8, 9}}}
+			_, err = openaiclient.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+		})
+	})
+
+	Context("chat template kwargs", func() {
+		const thinkingTokens = 32
+		rules := fmt.Sprintf(`[{"key":"enable_thinking","value":true,"extra_tokens":%d}]`, thinkingTokens)
+
+		startKwargsServer := func(ctx context.Context, extraArgs ...string) *http.Client {
+			args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--template-kwargs-token-delta", rules}, extraArgs...)
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return client
+		}
+
+		DescribeTable("Should adjust the prompt tokens only when the kwargs match a rule",
+			func(kwargs map[string]any, expectedDelta int64) {
+				ctx := context.TODO()
+				client := startKwargsServer(ctx)
+
+				openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+				opts := []option.RequestOption{}
+				if kwargs != nil {
+					opts = append(opts, option.WithJSONSet("chat_template_kwargs", kwargs))
+				}
+				resp, err := openaiclient.Chat.Completions.New(ctx, params, opts...)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Usage.PromptTokens).To(Equal(userMsgTokens + expectedDelta))
+				Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.PromptTokens + resp.Usage.CompletionTokens))
+			},
+			func(kwargs map[string]any, expectedDelta int64) string {
+				return fmt.Sprintf("kwargs: %v, expected delta: %d", kwargs, expectedDelta)
+			},
+			Entry(nil, nil, int64(0)),
+			Entry(nil, map[string]any{"enable_thinking": true}, int64(thinkingTokens)),
+			Entry(nil, map[string]any{"enable_thinking": false}, int64(0)),
+			Entry(nil, map[string]any{"enable_thinking": "true"}, int64(0)),
+			Entry(nil, map[string]any{"unknown": true}, int64(0)),
+			Entry(nil, map[string]any{"enable_thinking": true, "unknown": 1}, int64(thinkingTokens)),
+		)
+
+		It("Should validate the context window including the added tokens", func() {
+			ctx := context.TODO()
+			maxModelLen := userMsgTokens + thinkingTokens
+			client := startKwargsServer(ctx, "--max-model-len", strconv.FormatInt(maxModelLen, 10))
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.MaxTokens = openai.Int(5)
+			_, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = openaiclient.Chat.Completions.New(ctx, params,
+				option.WithJSONSet("chat_template_kwargs", map[string]any{"enable_thinking": true}))
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+		})
+	})
+
+	Context("image inputs", func() {
+		const imageTokens = 100
+		imageContent := `{"type": "image_url", "image_url": {"url": "https://example.com/cat.png", "detail": "low"}}`
+		imageURLContent := `{"type": "image_url", "image_url": "https://example.com/dog.png"}`
+
+		// sendImagesChat sends a chat completion request with the user message and the given content parts,
+		// returns the response's usage
+		sendImagesChat := func(client *http.Client, parts ...string) openai.CompletionUsage {
+			content := append([]string{`{"type": "text", "text": "` + userMessage + `"}`}, parts...)
+			body := `{"model": "` + model + `", "max_tokens": 5, "messages": [{"role": "user", "content": [` +
+				strings.Join(content, ",") + `]}]}`
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(resp.Body.Close()).To(Succeed())
+			}()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			var completion openai.ChatCompletion
+			Expect(json.NewDecoder(resp.Body).Decode(&completion)).To(Succeed())
+			return completion.Usage
+		}
+
+		It("Should add the tokens of the images to the prompt tokens", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--image-token-count", strconv.Itoa(imageTokens)}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(sendImagesChat(client).PromptTokens).To(Equal(userMsgTokens))
+			Expect(sendImagesChat(client, imageContent).PromptTokens).To(Equal(userMsgTokens + imageTokens))
+			Expect(sendImagesChat(client, imageContent, imageURLContent).PromptTokens).
+				To(Equal(userMsgTokens + 2*imageTokens))
+		})
+
+		It("Should add the prefill time of the images to the time to first token", func() {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--prefill-time-per-image", "300", "--image-token-count", strconv.Itoa(imageTokens)}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			sendImagesChat(client)
+			Expect(time.Since(start)).To(BeNumerically("<", 300*time.Millisecond))
+
+			start = time.Now()
+			sendImagesChat(client, imageContent, imageURLContent)
+			Expect(time.Since(start)).To(BeNumerically(">=", 600*time.Millisecond))
+		})
+	})
+
+	Context("clock skew", func() {
+		const ttft = 300
+		skew := time.Hour
+
+		startSkewedServer := func(ctx context.Context) *http.Client {
+			args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+				"--clock-skew", skew.String(), "--time-to-first-token", strconv.Itoa(ttft)}
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return client
+		}
+		expectSkewed := func(created int64) {
+			Expect(created).To(BeNumerically("~", time.Now().Add(skew).Unix(), 2))
+		}
+
+		DescribeTable("Should skew the created timestamp without affecting the latency",
+			func(streaming bool) {
+				ctx := context.TODO()
+				client := startSkewedServer(ctx)
+				openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+
+				start := time.Now()
+				if streaming {
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					defer func() {
+						err := stream.Close()
+						Expect(err).NotTo(HaveOccurred())
+					}()
+					numberOfChunks := 0
+					for stream.Next() {
+						expectSkewed(stream.Current().Created)
+						numberOfChunks++
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(numberOfChunks).To(BeNumerically(">", 0))
+				} else {
+					resp, err := openaiclient.Chat.Completions.New(ctx, params)
+					Expect(err).NotTo(HaveOccurred())
+					expectSkewed(resp.Created)
+				}
+				elapsed := time.Since(start)
+				Expect(elapsed).To(BeNumerically(">=", ttft*time.Millisecond))
+				Expect(elapsed).To(BeNumerically("<", time.Second))
+			},
+			func(streaming bool) string {
+				return fmt.Sprintf("streaming: %t", streaming)
+			},
+			Entry(nil, false),
+			Entry(nil, true),
+		)
+
+		It("Should skew the models and metrics timestamps", func() {
+			ctx := context.TODO()
+			client := startSkewedServer(ctx)
+
+			openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+			var modelsResp vllmapi.ModelsResponse
+			err := openaiclient.Get(ctx, "/models", nil, &modelsResp)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(modelsResp.Data).NotTo(BeEmpty())
+			expectSkewed(modelsResp.Data[0].Created)
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			timestamp := getLoraValidTimestamp(strings.Split(string(data), "\n"), []string{}, []string{})
+			expectSkewed(int64(timestamp))
+		})
+	})
+
+	Context("min tokens", func() {
+		It("Should stream at least min_tokens tokens", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, true)
+			params.MaxCompletionTokens = openai.Int(100)
+			for range 5 {
+				stream := openaiclient.Chat.Completions.NewStreaming(ctx, params, option.WithJSONSet("min_tokens", 90))
+				var chunk openai.ChatCompletionChunk
+				for stream.Next() {
+					chunk = stream.Current()
+				}
+				Expect(stream.Err()).NotTo(HaveOccurred())
+				Expect(stream.Close()).To(Succeed())
+				Expect(chunk.Usage.CompletionTokens).To(BeNumerically(">=", 90))
+				Expect(chunk.Usage.CompletionTokens).To(BeNumerically("<=", 100))
+			}
+		})
+
+		It("Should reject invalid min_tokens", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.MaxCompletionTokens = openai.Int(10)
+			_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("min_tokens", 20))
+			var openaiError *openai.Error
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(openaiError.Message).To(ContainSubstring("min_tokens must be less than or equal to max_tokens=10"))
+
+			_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("min_tokens", -1))
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("multiple choices", func() {
+		const n = 4
+
+		It("Should return n chat completion choices with combined usage", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.N = openai.Int(n)
+			params.MaxCompletionTokens = openai.Int(30)
+			resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("ignore_eos", true))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(n))
+			texts := make(map[string]bool)
+			for i, choice := range resp.Choices {
+				Expect(choice.Index).To(Equal(int64(i)))
+				Expect(choice.FinishReason).NotTo(BeEmpty())
+				Expect(dataset.IsValidText(choice.Message.Content)).To(BeTrue())
+				texts[choice.Message.Content] = true
+			}
+			// every choice is generated separately
+			Expect(len(texts)).To(BeNumerically(">", 1))
+			Expect(resp.Usage.PromptTokens).To(Equal(userMsgTokens))
+			Expect(resp.Usage.CompletionTokens).To(Equal(int64(n * 30)))
+			Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.PromptTokens + resp.Usage.CompletionTokens))
+		})
+
+		It("Should return n text completion choices with combined usage", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+			params.N = openai.Int(n)
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(n))
+			for i, choice := range resp.Choices {
+				Expect(choice.Index).To(Equal(int64(i)))
+				Expect(choice.Text).To(Equal(userMessage))
+				Expect(string(choice.FinishReason)).To(Equal(dataset.StopFinishReason))
+			}
+			Expect(resp.Usage.CompletionTokens).To(Equal(int64(n * len(common.Tokenize(userMessage)))))
+		})
+
+		DescribeTable("Should stream the chunks of all the choices",
+			func(isChat bool) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, common.ModeEcho)
+				Expect(err).NotTo(HaveOccurred())
+
+				texts := make([]string, n)
+				finishReasons := make([]string, n)
+				var usage openai.CompletionUsage
+				if isChat {
+					openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, true)
+					params.N = openai.Int(n)
+					stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+					roles := 0
+					for stream.Next() {
+						chunk := stream.Current()
+						for _, choice := range chunk.Choices {
+							if choice.Delta.Role != "" {
+								roles++
+							}
+							texts[choice.Index] += choice.Delta.Content
+							if choice.FinishReason != "" {
+								finishReasons[choice.Index] = choice.FinishReason
+							}
+						}
+						if chunk.Usage.TotalTokens != 0 {
+							usage = chunk.Usage
+						}
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+					Expect(roles).To(Equal(n))
+				} else {
+					openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, true)
+					params.N = openai.Int(n)
+					stream := openaiclient.Completions.NewStreaming(ctx, params)
+					for stream.Next() {
+						chunk := stream.Current()
+						for _, choice := range chunk.Choices {
+							texts[choice.Index] += choice.Text
+							if choice.FinishReason != "" {
+								finishReasons[choice.Index] = string(choice.FinishReason)
+							}
+						}
+						if chunk.Usage.TotalTokens != 0 {
+							usage = chunk.Usage
+						}
+					}
+					Expect(stream.Err()).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+				}
+
+				for i := range n {
+					Expect(texts[i]).To(Equal(userMessage))
+					Expect(finishReasons[i]).To(Equal(dataset.StopFinishReason))
+				}
+				Expect(usage.PromptTokens).To(Equal(userMsgTokens))
+				Expect(usage.CompletionTokens).To(Equal(int64(n * len(common.Tokenize(userMessage)))))
+			},
+			func(isChat bool) string {
+				return fmt.Sprintf("chat: %t", isChat)
+			},
+			Entry(nil, true),
+			Entry(nil, false),
+		)
+
+		It("Should reject n smaller than 1", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.N = openai.Int(0)
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+			Expect(openaiError.Message).To(ContainSubstring("n must be at least 1"))
+		})
+
+		It("Should count the tokens of all the best_of candidates and return n choices", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+			params.N = openai.Int(2)
+			params.BestOf = openai.Int(5)
+			resp, err := openaiclient.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(2))
+			for i, choice := range resp.Choices {
+				Expect(choice.Index).To(Equal(int64(i)))
+				Expect(choice.Text).To(Equal(userMessage))
+			}
+			Expect(resp.Usage.CompletionTokens).To(Equal(int64(5 * len(common.Tokenize(userMessage)))))
+			Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.PromptTokens + resp.Usage.CompletionTokens))
+		})
+
+		DescribeTable("Should reject an invalid best_of",
+			func(bestOf int64, stream bool, expectedMsg string) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, common.ModeRandom)
+				Expect(err).NotTo(HaveOccurred())
+
+				openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+				params.N = openai.Int(2)
+				params.BestOf = openai.Int(bestOf)
+				_, err = openaiclient.Completions.New(ctx, params, option.WithJSONSet("stream", stream))
+				Expect(err).To(HaveOccurred())
+				var openaiError *openai.Error
+				Expect(errors.As(err, &openaiError)).To(BeTrue())
+				Expect(openaiError.StatusCode).To(Equal(400))
+				Expect(openaiError.Message).To(ContainSubstring(expectedMsg))
+			},
+			Entry("smaller than n", int64(1), false, "best_of must be greater than or equal to n, got n=2 and best_of=1."),
+			Entry("with streaming", int64(3), true, "best_of must be equal to n when streaming"),
+		)
+	})
+
+	Context("stop sequences", func() {
+		// the echoed text is truncated before the first stop sequence
+		const expectedText = "This is "
+		stop := []string{"test", "a test"}
+
+		DescribeTable("Should stop the response before the stop sequence",
+			func(isChat bool, isStreaming bool) {
+				ctx := context.TODO()
+				client, err := startServer(ctx, common.ModeEcho)
+				Expect(err).NotTo(HaveOccurred())
+
+				text := ""
+				finishReason := ""
+				var usage openai.CompletionUsage
+				if isChat {
+					openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, isStreaming)
+					params.Stop = openai.ChatCompletionNewParamsStopUnion{OfChatCompletionNewsStopArray: stop}
+					if isStreaming {
+						stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+						for stream.Next() {
+							chunk := stream.Current()
+							for _, choice := range chunk.Choices {
+								text += choice.Delta.Content
+								if choice.FinishReason != "" {
+									finishReason = choice.FinishReason
+								}
+							}
+							if chunk.Usage.TotalTokens != 0 {
+								usage = chunk.Usage
+							}
+						}
+						Expect(stream.Err()).NotTo(HaveOccurred())
+						Expect(stream.Close()).To(Succeed())
+					} else {
+						resp, err := openaiclient.Chat.Completions.New(ctx, params)
+						Expect(err).NotTo(HaveOccurred())
+						text = resp.Choices[0].Message.Content
+						finishReason = resp.Choices[0].FinishReason
+						usage = resp.Usage
+					}
+				} else {
+					openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, isStreaming)
+					params.Stop = openai.CompletionNewParamsStopUnion{OfString: openai.String(stop[1])}
+					if isStreaming {
+						stream := openaiclient.Completions.NewStreaming(ctx, params)
+						for stream.Next() {
+							chunk := stream.Current()
+							for _, choice := range chunk.Choices {
+								text += choice.Text
+								if choice.FinishReason != "" {
+									finishReason = string(choice.FinishReason)
+								}
+							}
+							if chunk.Usage.TotalTokens != 0 {
+								usage = chunk.Usage
+							}
+						}
+						Expect(stream.Err()).NotTo(HaveOccurred())
+						Expect(stream.Close()).To(Succeed())
+					} else {
+						resp, err := openaiclient.Completions.New(ctx, params)
+						Expect(err).NotTo(HaveOccurred())
+						text = resp.Choices[0].Text
+						finishReason = string(resp.Choices[0].FinishReason)
+						usage = resp.Usage
+					}
+				}
+
+				Expect(text).To(Equal(expectedText))
+				Expect(finishReason).To(Equal(dataset.StopFinishReason))
+				Expect(usage.CompletionTokens).To(Equal(int64(len(common.Tokenize(expectedText)))))
+			},
+			func(isChat bool, isStreaming bool) string {
+				return fmt.Sprintf("chat: %t, streaming: %t", isChat, isStreaming)
+			},
+			Entry(nil, true, false),
+			Entry(nil, true, true),
+			Entry(nil, false, false),
+			Entry(nil, false, true),
+		)
+
+		It("Should reject an invalid stop", func() {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("stop", 5))
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+		})
+	})
 })
 
 func sendSimpleChatRequest(envs map[string]string, streaming bool) *http.Response {
@@ -0,0 +1,152 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Reporting the internal state of the simulator through /_sim/status
+package llmdinferencesim
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	kvcache "github.com/llm-d/llm-d-inference-sim/pkg/kv-cache"
+)
+
+const (
+	datasetTypeRandom = "random"
+	datasetTypeCustom = "custom"
+)
+
+// requestCounts is the number of running and waiting requests
+type requestCounts struct {
+	Running int64 `json:"running"`
+	Waiting int64 `json:"waiting"`
+}
+
+// loraStatus describes a loaded LoRA adapter and its requests
+type loraStatus struct {
+	Name string `json:"name"`
+	requestCounts
+}
+
+// datasetStatus describes the source of the responses
+type datasetStatus struct {
+	// Type is random if the responses are generated from the preset sentences, custom if they are
+	// taken from a dataset
+	Type string `json:"type"`
+	// Stats are the statistics of the custom dataset, nil for the random dataset
+	Stats *dataset.Stats `json:"stats,omitempty"`
+	// Error is the reason the custom dataset is not used, empty if there is no error
+	Error string `json:"error,omitempty"`
+}
+
+// statusResponse is the response of /_sim/status
+type statusResponse struct {
+	// Requests are the running and waiting requests of all the models
+	Requests requestCounts `json:"requests"`
+	// Models are the running and waiting requests of each base model, by its displayed name
+	Models map[string]requestCounts `json:"models"`
+	// Loras are the loaded LoRA adapters, by their load time
+	Loras []loraStatus `json:"loras"`
+	// KVCache is the occupancy of the kv cache, nil if the kv cache is not enabled
+	KVCache *kvcache.BlockUsage `json:"kv_cache"`
+	// Dataset describes the source of the responses
+	Dataset datasetStatus `json:"dataset"`
+	// Config is the active configuration, including the changes made at runtime
+	Config map[string]any `json:"config"`
+}
+
+// getStatus returns the current internal state of the simulator
+func (s *VllmSimulator) getStatus() (statusResponse, error) {
+	config, err := configMap(s.getRuntimeConfig())
+	if err != nil {
+		return statusResponse{}, err
+	}
+	status := statusResponse{
+		Models:  make(map[string]requestCounts),
+		Loras:   make([]loraStatus, 0),
+		Dataset: s.getDatasetStatus(),
+		Config:  config,
+	}
+
+	// the counters are read under the metrics mutex, so they are consistent with each other
+	s.metricsMutex.Lock()
+	status.Requests = requestCounts{Running: s.nRunningReqs, Waiting: s.nWaitingReqs}
+	for model, running := range s.nRunningModelReqs {
+		status.Models[model] = requestCounts{Running: running, Waiting: s.nWaitingModelReqs[model]}
+	}
+	for model, waiting := range s.nWaitingModelReqs {
+		status.Models[model] = requestCounts{Running: s.nRunningModelReqs[model], Waiting: waiting}
+	}
+	for _, lora := range s.getLoadedLoras() {
+		status.Loras = append(status.Loras, loraStatus{
+			Name: lora.name,
+			requestCounts: requestCounts{
+				Running: int64(loraRefCount(lora.name, &s.runningLoras)),
+				Waiting: int64(loraRefCount(lora.name, &s.waitingLoras)),
+			},
+		})
+	}
+	s.metricsMutex.Unlock()
+
+	if s.kvcacheHelper != nil {
+		usage := s.kvcacheHelper.GetBlockUsage()
+		status.KVCache = &usage
+	}
+	return status, nil
+}
+
+// getDatasetStatus returns the description of the source of the responses
+func (s *VllmSimulator) getDatasetStatus() datasetStatus {
+	status := datasetStatus{Type: datasetTypeRandom}
+	if custDataset, ok := s.dataset.(*dataset.CustomDataset); ok {
+		stats := custDataset.Stats()
+		status.Type = datasetTypeCustom
+		status.Stats = &stats
+	}
+	if s.datasetErr != nil {
+		status.Error = s.datasetErr.Error()
+	}
+	return status
+}
+
+// loraRefCount returns the number of requests of the given LoRA adapter in the given collection
+func loraRefCount(lora string, theMap *sync.Map) int {
+	if value, ok := theMap.Load(lora); ok {
+		return value.(int)
+	}
+	return 0
+}
+
+// HandleSimStatus http handler for /_sim/status
+func (s *VllmSimulator) HandleSimStatus(ctx *fasthttp.RequestCtx) {
+	status, err := s.getStatus()
+	if err != nil {
+		s.logger.Error(err, "Failed to create status response")
+		ctx.Error("Failed to create status response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		ctx.Error("Response body creation failed, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
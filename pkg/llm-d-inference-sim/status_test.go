@@ -0,0 +1,117 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const simStatusURL = "http://localhost/_sim/status"
+
+func getSimStatus(client *http.Client) statusResponse {
+	resp, err := client.Get(simStatusURL)
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	var status statusResponse
+	err = json.Unmarshal(data, &status)
+	Expect(err).NotTo(HaveOccurred())
+	return status
+}
+
+var _ = Describe("Status endpoint", func() {
+	It("should not serve /_sim/status when the admin API is disabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := client.Get(simStatusURL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("should report the requests, the LoRA adapters, the dataset and the configuration", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--max-num-seqs", "1", "--time-to-first-token", "500",
+			"--lora-modules", "{\"name\":\"lora1\",\"path\":\"/path/to/lora1\"}",
+			"{\"name\":\"lora2\",\"path\":\"/path/to/lora2\"}"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		status := getSimStatus(client)
+		Expect(status.Requests).To(Equal(requestCounts{}))
+		Expect(status.Loras).To(HaveLen(2))
+		Expect(status.Loras[0].Name).To(Equal("lora1"))
+		Expect(status.Loras[1].Name).To(Equal("lora2"))
+		Expect(status.KVCache).To(BeNil())
+		Expect(status.Dataset.Type).To(Equal(datasetTypeRandom))
+		Expect(status.Dataset.Stats).To(BeNil())
+		Expect(status.Config["model"]).To(Equal(model))
+		Expect(status.Config["max-num-seqs"]).To(BeNumerically("==", 1))
+
+		openaiclient := openai.NewClient(option.WithBaseURL(baseURL), option.WithHTTPClient(client))
+		var wg sync.WaitGroup
+		// the request of lora1 runs on the only worker, the request of the base model waits
+		for _, params := range []openai.ChatCompletionNewParams{paramsLora1, {
+			Messages: paramsLora1.Messages,
+			Model:    model,
+		}} {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := openaiclient.Chat.Completions.New(ctx, params)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		status = getSimStatus(client)
+		Expect(status.Requests).To(Equal(requestCounts{Running: 1, Waiting: 1}))
+		Expect(status.Models).To(HaveKeyWithValue(model, requestCounts{Running: 1, Waiting: 1}))
+		Expect(status.Loras[0].requestCounts).To(Equal(requestCounts{Running: 1}))
+		Expect(status.Loras[1].requestCounts).To(Equal(requestCounts{}))
+
+		// the runtime changes are part of the reported configuration
+		statusCode, _ := sendRuntimeConfigRequest(client, http.MethodPatch, `{"inter-token-latency": 7}`)
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(getSimStatus(client).Config["inter-token-latency"]).To(BeNumerically("==", 7))
+
+		wg.Wait()
+		status = getSimStatus(client)
+		Expect(status.Requests).To(Equal(requestCounts{}))
+		Expect(status.Models).To(HaveKeyWithValue(model, requestCounts{}))
+	})
+})
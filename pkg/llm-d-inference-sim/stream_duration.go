@@ -0,0 +1,64 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"time"
+)
+
+// getResponseDeadline returns the time a response that started at the given time is cut at,
+// zero if the response duration is unlimited
+func (s *VllmSimulator) getResponseDeadline(start time.Time) time.Time {
+	if s.config.MaxStreamDuration == 0 {
+		return time.Time{}
+	}
+	return start.Add(s.realDuration(s.config.MaxStreamDuration))
+}
+
+// sleepBefore waits for the given simulated delay in milliseconds, which takes the delay divided by timeScale
+// in real time. If the delay ends after the deadline it waits until the deadline only, returns the simulated
+// time it waited in milliseconds and false if the deadline was reached. A zero deadline means no deadline.
+// The wait ends early, returning false, when the disconnected channel is closed, a nil channel never ends the wait
+func sleepBefore(delayMs int, timeScale float64, deadline time.Time, disconnected <-chan struct{}) (int, bool) {
+	delay := time.Duration(float64(delayMs) * float64(time.Millisecond) / timeScale)
+	inTime := true
+	if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+		delay = max(time.Until(deadline), 0)
+		inTime = false
+	}
+	start := time.Now()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-disconnected:
+		return int(float64(time.Since(start).Milliseconds()) * timeScale), false
+	}
+	if !inTime {
+		return int(float64(delay.Milliseconds()) * timeScale), false
+	}
+	return delayMs, true
+}
+
+// reportStreamDurationTruncation increments the counter of the responses cut at the maximal stream duration
+func (s *VllmSimulator) reportStreamDurationTruncation() {
+	if s.streamDurationTruncations == nil {
+		// Happens in the tests
+		return
+	}
+	s.streamDurationTruncations.Inc()
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	// longChatStreamBody and longTextStreamBody take 10 seconds with an inter token latency of 20 milliseconds
+	longChatStreamBody = `{"messages": [{"role": "user", "content": "Hello, how are you?"}], "model": "my_model",
+		"stream": true, "max_tokens": 500, "ignore_eos": true, "stream_options": {"include_usage": true}}`
+	longTextStreamBody = `{"prompt": "Hello, how are you?", "model": "my_model",
+		"stream": true, "max_tokens": 500, "ignore_eos": true, "stream_options": {"include_usage": true}}`
+)
+
+var _ = Describe("Max stream duration", func() {
+	startCappedServer := func(ctx context.Context, extraArgs ...string) *http.Client {
+		args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--inter-token-latency", "20", "--max-stream-duration", "1s"}, extraArgs...)
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	getTruncations := func(client *http.Client) float64 {
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return getGaugeValue(string(data), "sim_max_stream_duration_truncations_total")
+	}
+
+	DescribeTable("should cut a stream at the maximal duration",
+		func(path string, body string, extraArgs []string, expectedFinishReason string) {
+			ctx := context.TODO()
+			client := startCappedServer(ctx, extraArgs...)
+
+			start := time.Now()
+			events := sendRawStreamingRequest(client, path, body)
+			Expect(time.Since(start)).To(BeNumerically("~", time.Second, 300*time.Millisecond))
+
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+			var chunks []map[string]any
+			for _, event := range events[:len(events)-1] {
+				Expect(event).To(HavePrefix("data: "))
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				chunks = append(chunks, chunk)
+			}
+
+			// the usage chunk follows the chunk with the finish reason
+			usage := chunks[len(chunks)-1]["usage"].(map[string]any)
+			lastChoice := chunks[len(chunks)-2]["choices"].([]any)[0].(map[string]any)
+			Expect(lastChoice["finish_reason"]).To(Equal(expectedFinishReason))
+
+			tokens := 0
+			for _, chunk := range chunks[:len(chunks)-2] {
+				choice := chunk["choices"].([]any)[0].(map[string]any)
+				Expect(choice["finish_reason"]).To(BeNil())
+				token := choice["text"]
+				if delta, ok := choice["delta"].(map[string]any); ok {
+					token = delta["content"]
+				}
+				if token != nil && token != "" {
+					tokens++
+				}
+			}
+			Expect(tokens).To(BeNumerically(">", 0))
+			Expect(tokens).To(BeNumerically("<", 500))
+			Expect(usage["completion_tokens"]).To(BeNumerically("==", tokens))
+			Expect(usage["total_tokens"]).To(BeNumerically("==", usage["prompt_tokens"].(float64)+float64(tokens)))
+
+			Expect(getTruncations(client)).To(Equal(1.0))
+		},
+		func(path string, body string, extraArgs []string, expectedFinishReason string) string {
+			return fmt.Sprintf("path: %s, args: %v", path, extraArgs)
+		},
+		Entry(nil, "chat/completions", longChatStreamBody, []string{}, dataset.LengthFinishReason),
+		Entry(nil, "completions", longTextStreamBody, []string{}, dataset.LengthFinishReason),
+		Entry(nil, "chat/completions", longChatStreamBody,
+			[]string{"--max-stream-duration-finish-reason", "timeout"}, "timeout"),
+	)
+
+	It("should cut a non-streaming response at the maximal duration", func() {
+		ctx := context.TODO()
+		client := startCappedServer(ctx)
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(500)
+		start := time.Now()
+		resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("ignore_eos", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("~", time.Second, 300*time.Millisecond))
+
+		Expect(resp.Choices[0].FinishReason).To(Equal(dataset.LengthFinishReason))
+		// the first token is generated right away, every other token after 20 milliseconds
+		Expect(resp.Usage.CompletionTokens).To(BeNumerically("~", 50, 5))
+		Expect(resp.Usage.TotalTokens).To(Equal(resp.Usage.PromptTokens + resp.Usage.CompletionTokens))
+		Expect(resp.Choices[0].Message.Content).NotTo(BeEmpty())
+		Expect(getTruncations(client)).To(Equal(1.0))
+	})
+
+	It("should not cut a response shorter than the maximal duration", func() {
+		ctx := context.TODO()
+		client := startCappedServer(ctx)
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(10)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("ignore_eos", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].FinishReason).To(Equal(dataset.LengthFinishReason))
+		Expect(resp.Usage.CompletionTokens).To(BeNumerically("==", 10))
+		Expect(getTruncations(client)).To(Equal(0.0))
+	})
+})
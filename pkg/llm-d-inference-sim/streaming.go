@@ -19,7 +19,9 @@ package llmdinferencesim
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
@@ -28,6 +30,15 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+// streamErrorMessage is the message of the error event of the stream_error failure
+const streamErrorMessage = "The server had an error while generating the response."
+
+// streamFailureErrorType is the error type of the requests whose streams were cut by an injected failure
+var streamFailureErrorType = openaiserverapi.ErrorCodeToType(fasthttp.StatusInternalServerError)
+
+// errStreamFailureInjected is returned when a stream was cut by an injected failure
+var errStreamFailureInjected = errors.New("the stream was cut by an injected failure")
+
 type streamingContext struct {
 	ctx                 *fasthttp.RequestCtx
 	isChatCompletion    bool
@@ -36,15 +47,64 @@ type streamingContext struct {
 	doRemotePrefill     bool
 	nPromptTokens       int
 	nCachedPromptTokens int
-	requestID           string
+	// nImages is the number of images in the prompt
+	nImages int
+	// nRemoteBlocks is the number of kv cache blocks of the prompt on the prefill pod
+	nRemoteBlocks    int
+	requestID        string
+	omitDoneSentinel bool
+	// streamFailure is the failure injected after StreamFailureAfterChunks token chunks, empty if none
+	streamFailure string
+	// isRefusal is true when the chat completion is refused, the tokens are sent in the refusal field
+	isRefusal bool
+	// serviceTier is the service tier the request is processed in, empty if the request
+	// didn't ask for a service tier
+	serviceTier string
+	// elapsedMs is the cumulative delay in milliseconds intended for the tokens sent so far
+	elapsedMs int64
+	// holdsPrefillSlot is true until the prefill slot of the request is released, when the time to first token elapsed
+	holdsPrefillSlot bool
+	// deadline is the time the stream is cut at, zero if the stream duration is unlimited
+	deadline time.Time
+	// timeScale is the factor the delays of the stream are divided by
+	timeScale float64
+	// nSentTokens is the number of tokens sent so far
+	nSentTokens int
+	// nSentChunks is the number of token chunks sent so far
+	nSentChunks int
+	// nTokenSteps is the number of token steps sent so far, a step contains a token of every unfinished choice
+	nTokenSteps int
+	// truncated is true if the stream was cut at its deadline
+	truncated bool
+	// disconnected is closed when the client disconnects, nil if the connection is not watched
+	disconnected <-chan struct{}
+	// aborted is true if the stream was aborted because the client disconnected
+	aborted bool
+	// failed is true if the stream was cut by an injected failure
+	failed bool
+}
+
+// sleep waits for the given delay in milliseconds and adds it to the cumulative delay of the stream,
+// returns false if the stream reached its deadline or the client disconnected
+func (c *streamingContext) sleep(delayMs int) bool {
+	sleptMs, inTime := sleepBefore(delayMs, c.timeScale, c.deadline, c.disconnected)
+	c.elapsedMs += int64(sleptMs)
+	return inTime
+}
+
+// choiceDelta is the content of a single chunk of a streamed choice, either a token or a part of a tool call
+type choiceDelta struct {
+	token    string
+	toolCall *openaiserverapi.ToolCall
 }
 
 // sendStreamingResponse creates and sends a streaming response for completion requests of both types (text and chat)
 // as defined by isChatCompletion
 // response content is wrapped according SSE format
-// First token is send after timeToFirstToken milliseconds, every other token is sent after interTokenLatency milliseconds
-func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, responseTokens []string, toolCalls []openaiserverapi.ToolCall,
-	finishReason string, usageData *openaiserverapi.Usage) {
+// First token is send after timeToFirstToken milliseconds, every other token is sent after interTokenLatency milliseconds,
+// the choices are generated in parallel, so the tokens of all the choices are sent together
+func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, choices []responseChoice,
+	usageData *openaiserverapi.Usage) {
 	context.ctx.SetContentType("text/event-stream")
 	context.ctx.SetStatusCode(fasthttp.StatusOK)
 
@@ -56,62 +116,313 @@ func (s *VllmSimulator) sendStreamingResponse(context *streamingContext, respons
 		context.ctx.Response.Header.Add(namespaceHeader, s.namespace)
 	}
 
-	context.ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
-		context.creationTime = time.Now().Unix()
+	s.setBodyStreamWriter(context.ctx, func(w *bufio.Writer) {
+		w, stopFlush := s.startTimedFlush(w)
+		defer stopFlush()
+		defer s.responseSentCallback(context.model, context.requestID)
+		defer func() {
+			finishReasons := s.streamFinishReasons(context, choices)
+			s.traceRequest(context.requestID, context.nSentTokens, finishReasons)
+			s.publishRequestEnd(context.requestID, context.nSentTokens, finishReasons, streamFailure(context))
+			s.logRequestEnd(context.requestID, context.nSentTokens, finishReasons)
+			if context.failed {
+				s.reportRequestFailure(streamFailureErrorType)
+				return
+			}
+			if context.aborted {
+				s.reportRequestAborted(context.model)
+				return
+			}
+			s.reportRequestLatencies(context.requestID, context.model, context.nTokenSteps)
+			s.reportRequestTokens(context.requestID, context.model, context.nSentTokens)
+			s.recordUsage(context.requestID, context.model, context.nSentTokens)
+			s.reportRequestSuccess(context.model, finishReasons)
+		}()
+		defer func() {
+			// the stream ended before its first token was sent
+			if context.holdsPrefillSlot {
+				s.releasePrefillSlot()
+			}
+		}()
+		context.creationTime = s.externalNow().Unix()
+		context.deadline = s.getResponseDeadline(time.Now())
+		context.timeScale = s.getTimeScale()
+
+		if s.isAzureFlavor() {
+			// Azure OpenAI sends the results of the content filters of the prompt in the first chunk
+			if err := s.sendChunk(w, s.createPromptFilterChunk(context), ""); err != nil {
+				s.logger.Error(err, "Sending stream prompt filter chunk failed, the stream is aborted")
+				context.aborted = true
+				return
+			}
+		}
 
-		if len(responseTokens) > 0 || len(toolCalls) > 0 {
+		hasContent := false
+		for _, choice := range choices {
+			if len(choice.tokens) > 0 || len(choice.toolCalls) > 0 {
+				hasContent = true
+				break
+			}
+		}
+		if hasContent {
 			if context.isChatCompletion {
-				// in chat completion first chunk contains the role
-				chunk := s.createChatCompletionChunk(context, "", nil, openaiserverapi.RoleAssistant, nil)
-				if err := s.sendChunk(w, chunk, ""); err != nil {
-					context.ctx.Error("Sending stream first chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
-					return
+				// in chat completion first chunk of every choice contains the role
+				for i := range choices {
+					chunk := s.createChatCompletionChunk(context, i, "", nil, openaiserverapi.RoleAssistant, nil)
+					if err := s.sendChunk(w, chunk, ""); err != nil {
+						s.logger.Error(err, "Sending stream first chunk failed, the stream is aborted")
+						context.aborted = true
+						return
+					}
 				}
 			}
-			if len(toolCalls) > 0 {
-				s.logger.Info("Going to send tools calls")
-				for _, tc := range toolCalls {
-					s.sendTokenChunks(context, w, tc.Function.TokenizedArguments, &tc, finishReason)
+			s.logger.Info("Going to send choices", "number of choices", len(choices))
+			if err := s.sendChoicesChunks(context, w, choices); err != nil {
+				if !errors.Is(err, errStreamFailureInjected) {
+					s.logStreamAborted(context, err)
 				}
-			} else {
-				s.logger.Info("Going to send text", "number of tokens", len(responseTokens))
-				s.sendTokenChunks(context, w, responseTokens, nil, finishReason)
+				return
 			}
 		}
+		if context.streamFailure != "" {
+			// the response is shorter than the configured number of chunks, the failure is injected at its end
+			if err := s.sendStreamFailure(context, w); !errors.Is(err, errStreamFailureInjected) {
+				s.logStreamAborted(context, err)
+			}
+			return
+		}
 
 		// send usage
 		if usageData != nil {
+			if context.truncated {
+				usageData.CompletionTokens = context.nSentTokens
+				usageData.TotalTokens = usageData.PromptTokens + context.nSentTokens
+			}
 			chunk := s.createUsageChunk(context, usageData)
 			if err := s.sendChunk(w, chunk, ""); err != nil {
-				context.ctx.Error("Sending usage chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
+				s.logger.Error(err, "Sending usage chunk failed, the stream is aborted")
+				context.aborted = true
 				return
 			}
 		}
 
+		if context.omitDoneSentinel {
+			s.logger.V(4).Info("Ending the stream without the [DONE] sentinel")
+			return
+		}
 		// finish sse events stream
 		if err := s.sendChunk(w, nil, "[DONE]"); err != nil {
-			context.ctx.Error("Sending last stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
-			return
+			s.logger.Error(err, "Sending last stream chunk failed")
+			context.aborted = true
 		}
-		s.responseSentCallback(context.model, context.isChatCompletion, context.requestID)
 	})
 }
 
-// sendTokenChunks creates and sends response chunks
-func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writer, genTokens []string,
-	tc *openaiserverapi.ToolCall, finishReason string) {
+// sendChoicesChunks creates and sends the response chunks of the choices, at every token step a token is generated
+// for each choice that is not finished yet, the tokens of a choice are sent in a chunk when stream-tokens-per-chunk
+// tokens were generated or the choice is finished. Returns an error if the stream was aborted
+func (s *VllmSimulator) sendChoicesChunks(context *streamingContext, w *bufio.Writer, choices []responseChoice) error {
+	latencyFactor := s.serviceTierLatencyFactor(context.serviceTier)
 	// time to first token delay
-	ttft := s.getWaitTimeToFirstToken(context.nPromptTokens, context.nCachedPromptTokens, context.doRemotePrefill)
-	time.Sleep(time.Duration(ttft) * time.Millisecond)
+	nTransferredTokens := s.getTransferredPromptTokens(context.nPromptTokens, context.nRemoteBlocks,
+		context.doRemotePrefill)
+	ttft := s.getWaitTimeToFirstToken(context.model, nTransferredTokens, context.nCachedPromptTokens, context.doRemotePrefill) +
+		s.getImagePrefillTime(context.nImages, context.doRemotePrefill)
+	nPrefillTokens := localPrefillTokens(context.nPromptTokens, context.nCachedPromptTokens, context.doRemotePrefill)
+	s.reportIterationTokens(context.model, nPrefillTokens)
+	endPrefill := s.startPrefill(nPrefillTokens)
+	s.markPrefillStart(context.requestID, context.doRemotePrefill)
+	inTime := context.sleep(int(float64(ttft) * latencyFactor))
+	endPrefill()
+	if context.holdsPrefillSlot {
+		s.releasePrefillSlot()
+		context.holdsPrefillSlot = false
+	}
+	finished := make([]bool, len(choices))
+	if isDisconnected(context.disconnected) {
+		return errRequestAborted
+	}
+	if !inTime {
+		return s.sendTruncationChunks(context, w, finished)
+	}
+
+	deltas := make([][]choiceDelta, len(choices))
+	for i, choice := range choices {
+		deltas[i] = s.createChoiceDeltas(choice)
+	}
+	// the generated tokens of every choice that were not sent yet
+	pending := make([][]choiceDelta, len(choices))
+	nFinished := 0
+	for step := 0; nFinished < len(choices); step++ {
+		if !s.reserveMemory(context.model, context.requestID, context.nPromptTokens+choicesTokens(choices, step+1),
+			context.disconnected) {
+			return errRequestAborted
+		}
+		if step != 0 {
+			s.reportIterationTokens(context.model, s.numDecodingRequests())
+		}
+		if step != 0 && !context.sleep(int(float64(s.getInterTokenLatency(context.model, step))*latencyFactor)) {
+			if isDisconnected(context.disconnected) {
+				return errRequestAborted
+			}
+			for i := range pending {
+				if err := s.sendPendingChunk(context, w, i, &pending[i], nil); err != nil {
+					return err
+				}
+			}
+			return s.sendTruncationChunks(context, w, finished)
+		}
+		sentToken := false
+		for i, choice := range choices {
+			if finished[i] {
+				continue
+			}
+			isLast := step >= len(deltas[i])-1
+			if step < len(deltas[i]) {
+				delta := deltas[i][step]
+				if len(pending[i]) > 0 && !canMergeDeltas(pending[i][0], delta) {
+					// a new tool call starts in a new chunk
+					if err := s.sendPendingChunk(context, w, i, &pending[i], nil); err != nil {
+						return err
+					}
+				}
+				pending[i] = append(pending[i], delta)
+				var finishReasonToSend *string
+				if isLast && (choice.finishReason == dataset.LengthFinishReason ||
+					choice.finishReason == dataset.ToolsFinishReason) {
+					finishReasonToSend = &choices[i].finishReason
+				}
+				if isLast || len(pending[i]) >= s.config.StreamTokensPerChunk {
+					if err := s.sendPendingChunk(context, w, i, &pending[i], finishReasonToSend); err != nil {
+						return err
+					}
+				}
+				sentToken = true
+			}
+			if !isLast {
+				continue
+			}
+			// send the last chunk if the finish reason wasn't sent with the last token
+			if choice.finishReason != dataset.LengthFinishReason && choice.finishReason != dataset.ToolsFinishReason {
+				chunk := s.createDeltaChunk(context, i, choiceDelta{}, &choices[i].finishReason)
+				if err := s.sendChunk(w, chunk, ""); err != nil {
+					return err
+				}
+			}
+			finished[i] = true
+			nFinished++
+		}
+		if sentToken {
+			if context.nTokenSteps == 0 {
+				s.markFirstToken(context.requestID)
+			}
+			context.nTokenSteps++
+		}
+	}
+	return nil
+}
+
+// sendPendingChunk sends the pending tokens of the choice with the given index in a single chunk with the given
+// finish reason and empties them, nothing is sent if there are no pending tokens. The stream is cut instead
+// if a stream failure is injected and StreamFailureAfterChunks token chunks were sent
+func (s *VllmSimulator) sendPendingChunk(context *streamingContext, w *bufio.Writer, index int,
+	pending *[]choiceDelta, finishReason *string) error {
+	if len(*pending) == 0 {
+		return nil
+	}
+	if context.streamFailure != "" && context.nSentChunks >= s.config.StreamFailureAfterChunks {
+		return s.sendStreamFailure(context, w)
+	}
+	chunk := s.createDeltaChunk(context, index, mergeDeltas(*pending), finishReason)
+	if err := s.sendChunk(w, chunk, ""); err != nil {
+		return err
+	}
+	context.nSentTokens += len(*pending)
+	context.nSentChunks++
+	*pending = (*pending)[:0]
+	return nil
+}
+
+// canMergeDeltas returns true if the given delta can be sent in the chunk of the pending delta, the tokens
+// of the text are merged, the tokens of the arguments of a tool call are merged only in its own chunks
+func canMergeDeltas(pending choiceDelta, delta choiceDelta) bool {
+	if pending.toolCall == nil || delta.toolCall == nil {
+		return pending.toolCall == nil && delta.toolCall == nil
+	}
+	return pending.toolCall.Index == delta.toolCall.Index
+}
+
+// mergeDeltas returns the content of a single chunk of the given deltas, which can be merged
+func mergeDeltas(deltas []choiceDelta) choiceDelta {
+	merged := deltas[0]
+	if len(deltas) == 1 {
+		return merged
+	}
+	if merged.toolCall != nil {
+		toolCall := *merged.toolCall
+		merged.toolCall = &toolCall
+	}
+	for _, delta := range deltas[1:] {
+		if merged.toolCall != nil {
+			merged.toolCall.Function.Arguments += delta.toolCall.Function.Arguments
+		} else {
+			merged.token += delta.token
+		}
+	}
+	return merged
+}
 
-	for i, token := range genTokens {
-		if i != 0 {
-			time.Sleep(time.Duration(s.getInterTokenLatency()) * time.Millisecond)
+// sendStreamFailure cuts the stream by the injected failure, either an error event or an event whose
+// JSON is cut in its middle, the request is reported as failed. Returns errStreamFailureInjected if
+// the failure was sent
+func (s *VllmSimulator) sendStreamFailure(context *streamingContext, w *bufio.Writer) error {
+	s.logger.Info("Injecting failure", "type", context.streamFailure, "request id", context.requestID,
+		"sent tokens", context.nSentTokens)
+	context.failed = true
+
+	var data []byte
+	var err error
+	if context.streamFailure == common.FailureTypeStreamMalformed {
+		data, err = json.Marshal(s.createDeltaChunk(context, 0, choiceDelta{token: " "}, nil))
+		data = data[:len(data)/2]
+	} else {
+		data, err = json.Marshal(s.createErrorResponse(openaiserverapi.NewCompletionError(
+			streamErrorMessage, fasthttp.StatusInternalServerError, nil)))
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.sendChunk(w, nil, string(data)); err != nil {
+		return err
+	}
+	return errStreamFailureInjected
+}
+
+// logStreamAborted logs the abort of a stream because of the given error and marks the stream as aborted
+func (s *VllmSimulator) logStreamAborted(context *streamingContext, err error) {
+	context.aborted = true
+	if errors.Is(err, errRequestAborted) {
+		s.logger.Info("The stream is aborted", "request id", context.requestID,
+			"sent tokens", context.nSentTokens)
+		return
+	}
+	s.logger.Error(err, "Sending the stream failed, the stream is aborted")
+}
+
+// createChoiceDeltas returns the contents of the chunks of the given choice, a token of the text
+// or of the arguments of a tool call in each chunk
+func (s *VllmSimulator) createChoiceDeltas(choice responseChoice) []choiceDelta {
+	deltas := make([]choiceDelta, 0)
+	// a chunk never ends in the middle of a UTF-8 sequence, whatever the source of the tokens is
+	if len(choice.toolCalls) == 0 {
+		for _, token := range common.RuneSafeTokens(choice.tokens) {
+			deltas = append(deltas, choiceDelta{token: token})
 		}
-		var toolChunkInsert *openaiserverapi.ToolCall
-		if tc != nil {
-			toolChunkInsert = &openaiserverapi.ToolCall{
-				ID:    tc.ID,
+		return deltas
+	}
+	for _, tc := range choice.toolCalls {
+		for i, token := range common.RuneSafeTokens(tc.Function.TokenizedArguments) {
+			toolChunkInsert := &openaiserverapi.ToolCall{
 				Type:  tc.Type,
 				Index: tc.Index,
 				Function: openaiserverapi.FunctionCall{
@@ -121,48 +432,55 @@ func (s *VllmSimulator) sendTokenChunks(context *streamingContext, w *bufio.Writ
 			if i == 0 {
 				toolChunkInsert.Function.Name = tc.Function.Name
 			}
+			if i == 0 || s.config.RepeatToolCallIDsInChunks {
+				toolChunkInsert.ID = tc.ID
+			}
+			deltas = append(deltas, choiceDelta{toolCall: toolChunkInsert})
 		}
+	}
+	return deltas
+}
 
-		var chunk openaiserverapi.CompletionRespChunk
-		var finishReasonToSend *string
-		if i == len(genTokens)-1 && (finishReason == dataset.LengthFinishReason || finishReason == dataset.ToolsFinishReason) {
-			finishReasonToSend = &finishReason
-		}
-		if context.isChatCompletion {
-			chunk = s.createChatCompletionChunk(context, token, toolChunkInsert, "", finishReasonToSend)
-		} else {
-			chunk = s.createTextCompletionChunk(context, token, finishReasonToSend)
-		}
-
-		if err := s.sendChunk(w, chunk, ""); err != nil {
-			context.ctx.Error("Sending stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
-			return
-		}
+// createDeltaChunk creates and returns the chunk of the given choice with the given content
+func (s *VllmSimulator) createDeltaChunk(context *streamingContext, index int, delta choiceDelta,
+	finishReason *string) openaiserverapi.CompletionRespChunk {
+	if context.isChatCompletion {
+		return s.createChatCompletionChunk(context, index, delta.token, delta.toolCall, "", finishReason)
 	}
+	return s.createTextCompletionChunk(context, index, delta.token, finishReason)
+}
 
-	// send the last chunk if finish reason is stop
-	var chunk openaiserverapi.CompletionRespChunk
-	if finishReason == dataset.StopFinishReason {
-		if context.isChatCompletion {
-			chunk = s.createChatCompletionChunk(context, "", nil, "", &finishReason)
-		} else {
-			chunk = s.createTextCompletionChunk(context, "", &finishReason)
+// sendTruncationChunks sends the last chunk of every choice that is not finished in a stream that was
+// cut at its deadline, its finish reason is the configured finish reason of the cut streams
+func (s *VllmSimulator) sendTruncationChunks(context *streamingContext, w *bufio.Writer, finished []bool) error {
+	context.truncated = true
+	s.logger.Info("Stream cut at the maximal stream duration", "request id", context.requestID,
+		"sent tokens", context.nSentTokens)
+	s.reportStreamDurationTruncation()
+
+	finishReason := s.config.MaxStreamDurationFinishReason
+	for i := range finished {
+		if finished[i] {
+			continue
 		}
+		chunk := s.createDeltaChunk(context, i, choiceDelta{}, &finishReason)
 		if err := s.sendChunk(w, chunk, ""); err != nil {
-			context.ctx.Error("Sending last stream chunk failed, "+err.Error(), fasthttp.StatusInternalServerError)
-			return
+			return err
 		}
 	}
+	return nil
 }
 
 // createUsageChunk creates and returns a CompletionRespChunk with usage data, a single chunk of streamed completion API response,
 // supports both modes (text and chat)
 func (s *VllmSimulator) createUsageChunk(context *streamingContext, usageData *openaiserverapi.Usage) openaiserverapi.CompletionRespChunk {
 	baseChunk := openaiserverapi.BaseCompletionResponse{
-		ID:      chatComplIDPrefix + common.GenerateUUIDString(),
-		Created: context.creationTime,
-		Model:   context.model,
-		Usage:   usageData,
+		ID:                chatComplIDPrefix + s.random.UUIDString(),
+		Created:           context.creationTime,
+		Model:             s.getChunkModel(context),
+		Usage:             usageData,
+		ServiceTier:       context.serviceTier,
+		SystemFingerprint: s.systemFingerprint,
 	}
 	if context.isChatCompletion {
 		baseChunk.Object = chatCompletionChunkObject
@@ -180,39 +498,47 @@ func (s *VllmSimulator) createUsageChunk(context *streamingContext, usageData *o
 }
 
 // createTextCompletionChunk creates and returns a CompletionRespChunk, a single chunk of streamed completion API response,
-// for text completion
-func (s *VllmSimulator) createTextCompletionChunk(context *streamingContext, token string, finishReason *string) openaiserverapi.CompletionRespChunk {
+// for text completion, index is the index of the choice of the chunk
+func (s *VllmSimulator) createTextCompletionChunk(context *streamingContext, index int, token string,
+	finishReason *string) openaiserverapi.CompletionRespChunk {
 	return &openaiserverapi.TextCompletionResponse{
 		BaseCompletionResponse: openaiserverapi.BaseCompletionResponse{
-			ID:      chatComplIDPrefix + common.GenerateUUIDString(),
-			Created: context.creationTime,
-			Model:   context.model,
-			Object:  textCompletionObject,
+			ID:                chatComplIDPrefix + s.random.UUIDString(),
+			Created:           context.creationTime,
+			Model:             s.getChunkModel(context),
+			Object:            textCompletionObject,
+			SimElapsedMs:      s.getChunkElapsedMs(context),
+			SystemFingerprint: s.systemFingerprint,
 		},
 		Choices: []openaiserverapi.TextRespChoice{
 			{
-				BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: 0, FinishReason: finishReason},
-				Text:               token,
+				BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: index, FinishReason: finishReason,
+					ContentFilterResults: s.getChunkContentFilterResults(len(token) > 0)},
+				Text: token,
 			},
 		},
 	}
 }
 
 // createChatCompletionChunk creates and returns a CompletionRespChunk, a single chunk of streamed completion
-// API response, for chat completion. It sets either role, or token, or tool call info in the message.
-func (s *VllmSimulator) createChatCompletionChunk(context *streamingContext, token string, tool *openaiserverapi.ToolCall,
-	role string, finishReason *string) openaiserverapi.CompletionRespChunk {
+// API response, for chat completion, index is the index of the choice of the chunk. It sets either role, or token (as content or refusal), or tool call info in the message.
+func (s *VllmSimulator) createChatCompletionChunk(context *streamingContext, index int, token string,
+	tool *openaiserverapi.ToolCall, role string, finishReason *string) openaiserverapi.CompletionRespChunk {
 	chunk := openaiserverapi.ChatCompletionRespChunk{
 		BaseCompletionResponse: openaiserverapi.BaseCompletionResponse{
-			ID:      chatComplIDPrefix + common.GenerateUUIDString(),
-			Created: context.creationTime,
-			Model:   context.model,
-			Object:  chatCompletionChunkObject,
+			ID:                chatComplIDPrefix + s.random.UUIDString(),
+			Created:           context.creationTime,
+			Model:             s.getChunkModel(context),
+			Object:            chatCompletionChunkObject,
+			ServiceTier:       context.serviceTier,
+			SimElapsedMs:      s.getChunkElapsedMs(context),
+			SystemFingerprint: s.systemFingerprint,
 		},
 		Choices: []openaiserverapi.ChatRespChunkChoice{
 			{
-				Delta:              openaiserverapi.Message{},
-				BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: 0, FinishReason: finishReason},
+				Delta: openaiserverapi.Message{},
+				BaseResponseChoice: openaiserverapi.BaseResponseChoice{Index: index, FinishReason: finishReason,
+					ContentFilterResults: s.getChunkContentFilterResults(tool != nil || len(token) > 0)},
 			},
 		},
 	}
@@ -223,12 +549,78 @@ func (s *VllmSimulator) createChatCompletionChunk(context *streamingContext, tok
 	if tool != nil {
 		chunk.Choices[0].Delta.ToolCalls = []openaiserverapi.ToolCall{*tool}
 	} else if len(token) > 0 {
-		chunk.Choices[0].Delta.Content.Raw = token
+		if context.isRefusal {
+			chunk.Choices[0].Delta.Refusal = token
+		} else {
+			chunk.Choices[0].Delta.Content.Raw = token
+		}
 	}
 
 	return &chunk
 }
 
+// getChunkElapsedMs returns the cumulative delay intended for the tokens of the stream sent so far,
+// nil if the chunk timing is not emitted
+func (s *VllmSimulator) getChunkElapsedMs(context *streamingContext) *int64 {
+	if !s.config.EmitChunkTiming {
+		return nil
+	}
+	elapsedMs := context.elapsedMs
+	return &elapsedMs
+}
+
+// timedFlushWriter is the writer of a stream whose data is flushed to the client by a timer
+type timedFlushWriter struct {
+	mutex sync.Mutex
+	w     *bufio.Writer
+}
+
+func (t *timedFlushWriter) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.w.Write(p)
+}
+
+// flush flushes the data that was written since the last flush to the client, an error of the
+// flush is returned by the next write
+func (t *timedFlushWriter) flush() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.w.Buffered() > 0 {
+		_ = t.w.Flush()
+	}
+}
+
+// startTimedFlush returns the writer of a stream whose data is flushed to the client every StreamFlushInterval,
+// and a function that stops the timer and flushes the rest of the data. If StreamFlushInterval is not set,
+// the given writer is returned, every chunk is flushed when it is sent
+func (s *VllmSimulator) startTimedFlush(w *bufio.Writer) (*bufio.Writer, func()) {
+	if s.config.StreamFlushInterval == 0 {
+		return w, func() {}
+	}
+	timed := &timedFlushWriter{w: w}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.config.StreamFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				timed.flush()
+			}
+		}
+	}()
+	return bufio.NewWriter(timed), func() {
+		close(done)
+		<-stopped
+		timed.flush()
+	}
+}
+
 // sendChunk send a single token chunk in a streamed completion API response,
 // receives either a completionRespChunk or a string with the data to send.
 func (s *VllmSimulator) sendChunk(w *bufio.Writer, chunk openaiserverapi.CompletionRespChunk, dataString string) error {
@@ -251,3 +643,46 @@ func (s *VllmSimulator) sendChunk(w *bufio.Writer, chunk openaiserverapi.Complet
 
 	return nil
 }
+
+// isAzureFlavor returns true if the completion responses are sent in the Azure OpenAI format
+func (s *VllmSimulator) isAzureFlavor() bool {
+	return s.config.APIFlavor == common.APIFlavorAzure
+}
+
+// getChunkModel returns the model of the chunks of the stream, Azure OpenAI doesn't send the model in the chunks
+func (s *VllmSimulator) getChunkModel(context *streamingContext) string {
+	if s.isAzureFlavor() {
+		return ""
+	}
+	return context.model
+}
+
+// getChunkContentFilterResults returns the results of the content filters of a chunk's choice, nil if the responses
+// are not in the Azure OpenAI format. Azure OpenAI sends empty results in the chunks without content
+func (s *VllmSimulator) getChunkContentFilterResults(hasContent bool) *openaiserverapi.ContentFilterResults {
+	if !s.isAzureFlavor() {
+		return nil
+	}
+	if !hasContent {
+		return &openaiserverapi.ContentFilterResults{}
+	}
+	return openaiserverapi.NewSafeContentFilterResults()
+}
+
+// createPromptFilterChunk creates and returns the first chunk of a stream in the Azure OpenAI format, it contains
+// only the results of the content filters of the prompt, without choices
+func (s *VllmSimulator) createPromptFilterChunk(context *streamingContext) openaiserverapi.CompletionRespChunk {
+	baseChunk := openaiserverapi.BaseCompletionResponse{
+		PromptFilterResults: openaiserverapi.NewSafePromptFilterResults(),
+	}
+	if context.isChatCompletion {
+		return &openaiserverapi.ChatCompletionRespChunk{
+			BaseCompletionResponse: baseChunk,
+			Choices:                []openaiserverapi.ChatRespChunkChoice{},
+		}
+	}
+	return &openaiserverapi.TextCompletionResponse{
+		BaseCompletionResponse: baseChunk,
+		Choices:                []openaiserverapi.TextRespChoice{},
+	}
+}
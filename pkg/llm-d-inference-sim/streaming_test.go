@@ -0,0 +1,530 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	doneEvent = "data: [DONE]"
+
+	chatStreamBody = `{"messages": [{"role": "user", "content": "Hello, how are you?"}],
+		"model": "my_model", "stream": true%s}`
+	chatToolsStreamBody = `{"messages": [{"role": "user", "content": "What is the weather in Haifa?"}],
+		"model": "my_model", "stream": true, "tool_choice": "required",
+		"tools": [{"type": "function", "function": {"name": "get_weather", "description": "Get weather",
+		"parameters": {"type": "object", "properties": {"location": {"type": "string"}}}}}]%s}`
+	textStreamBody = `{"prompt": "Hello, how are you?", "model": "my_model", "stream": true%s}`
+
+	includeUsageOption = `, "stream_options": {"include_usage": true}`
+)
+
+// sendRawStreamingRequest sends a streaming request and returns the SSE events of the response
+func sendRawStreamingRequest(client *http.Client, path string, body string) []string {
+	resp, err := client.Post("http://localhost/v1/"+path, "application/json", strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		err := resp.Body.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	data, err := io.ReadAll(resp.Body)
+	Expect(err).NotTo(HaveOccurred())
+	// every event is terminated by an empty line
+	Expect(string(data)).To(HaveSuffix("\n\n"))
+	return strings.Split(strings.TrimSuffix(string(data), "\n\n"), "\n\n")
+}
+
+var _ = Describe("Streaming termination", func() {
+	DescribeTable("should end the stream with the [DONE] sentinel",
+		func(path string, bodyTemplate string, includeUsage bool) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			option := ""
+			if includeUsage {
+				option = includeUsageOption
+			}
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, option))
+			Expect(len(events)).To(BeNumerically(">", 1))
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+			Expect(strings.Count(strings.Join(events, "\n"), doneEvent)).To(Equal(1))
+
+			if includeUsage {
+				// the usage chunk is sent right before the sentinel
+				usageEvent := events[len(events)-2]
+				Expect(usageEvent).To(HavePrefix("data: "))
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(usageEvent, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chunk["usage"]).NotTo(BeNil())
+			}
+		},
+		func(path string, bodyTemplate string, includeUsage bool) string {
+			return fmt.Sprintf("path: %s, include usage: %t, body: %s", path, includeUsage, bodyTemplate)
+		},
+		Entry(nil, "chat/completions", chatStreamBody, false),
+		Entry(nil, "chat/completions", chatStreamBody, true),
+		Entry(nil, "chat/completions", chatToolsStreamBody, false),
+		Entry(nil, "chat/completions", chatToolsStreamBody, true),
+		Entry(nil, "completions", textStreamBody, false),
+		Entry(nil, "completions", textStreamBody, true),
+	)
+
+	DescribeTable("should end the stream without the [DONE] sentinel",
+		func(faultArgs []string, path string, bodyTemplate string) {
+			ctx := context.TODO()
+			args := append([]string{"cmd", "--model", model, "--mode", common.ModeRandom}, faultArgs...)
+			client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, includeUsageOption))
+			Expect(events).NotTo(ContainElement(doneEvent))
+			// the stream is otherwise complete, the last event is the usage chunk
+			Expect(events[len(events)-1]).To(ContainSubstring(`"usage"`))
+		},
+		func(faultArgs []string, path string, bodyTemplate string) string {
+			return fmt.Sprintf("args: %v, path: %s", faultArgs, path)
+		},
+		Entry(nil, []string{"--omit-done-sentinel"}, "chat/completions", chatStreamBody),
+		Entry(nil, []string{"--omit-done-sentinel"}, "chat/completions", chatToolsStreamBody),
+		Entry(nil, []string{"--omit-done-sentinel"}, "completions", textStreamBody),
+		Entry(nil, []string{"--failure-injection-rate", "100", "--failure-types", common.FailureTypeMissingDone},
+			"chat/completions", chatStreamBody),
+		Entry(nil, []string{"--failure-injection-rate", "100", "--failure-types", common.FailureTypeMissingDone},
+			"completions", textStreamBody),
+	)
+
+	It("should process non-streaming requests normally with the missing_done failure type", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--failure-injection-rate", "100", "--failure-types", common.FailureTypeMissingDone}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Streaming failures", func() {
+	const failureAfterChunks = 2
+
+	DescribeTable("should cut the stream after the configured number of token chunks",
+		func(failureType string, path string, bodyTemplate string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--failure-injection-rate", "100",
+				"--failure-types", failureType, "--stream-failure-after-chunks", fmt.Sprint(failureAfterChunks)}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, includeUsageOption))
+			nTokenChunks := failureAfterChunks
+			if path == "chat/completions" {
+				// the first chunk contains the role
+				nTokenChunks++
+			}
+			Expect(events).To(HaveLen(nTokenChunks + 1))
+			for _, event := range events[:nTokenChunks] {
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chunk["choices"]).To(HaveLen(1))
+			}
+
+			lastEvent := events[len(events)-1]
+			Expect(lastEvent).To(HavePrefix("data: "))
+			var chunk map[string]any
+			err = json.Unmarshal([]byte(strings.TrimPrefix(lastEvent, "data: ")), &chunk)
+			if failureType == common.FailureTypeStreamMalformed {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunk).To(HaveKey("error"))
+			errorObject := chunk["error"].(map[string]any)
+			Expect(errorObject["message"]).To(Equal(streamErrorMessage))
+			Expect(errorObject["code"]).To(BeNumerically("==", http.StatusInternalServerError))
+		},
+		func(failureType string, path string, bodyTemplate string) string {
+			return fmt.Sprintf("failure type: %s, path: %s", failureType, path)
+		},
+		Entry(nil, common.FailureTypeStreamError, "chat/completions", chatStreamBody),
+		Entry(nil, common.FailureTypeStreamError, "completions", textStreamBody),
+		Entry(nil, common.FailureTypeStreamMalformed, "chat/completions", chatStreamBody),
+		Entry(nil, common.FailureTypeStreamMalformed, "completions", textStreamBody),
+	)
+
+	It("should process non-streaming requests normally with the stream failure types", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--failure-injection-rate", "100",
+			"--failure-types", common.FailureTypeStreamError, common.FailureTypeStreamMalformed}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Streaming chunk timing", func() {
+	const (
+		ttft = 50
+		itl  = 10
+	)
+
+	DescribeTable("should send the intended cumulative delay of every token",
+		func(path string, bodyTemplate string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--emit-chunk-timing",
+				"--time-to-first-token", fmt.Sprint(ttft), "--inter-token-latency", fmt.Sprint(itl)}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, includeUsageOption))
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+
+			var tokenTimes []float64
+			for _, event := range events[:len(events)-1] {
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				if chunk["usage"] != nil {
+					Expect(chunk).NotTo(HaveKey("sim_elapsed_ms"))
+					continue
+				}
+				Expect(chunk).To(HaveKey("sim_elapsed_ms"))
+				elapsed := chunk["sim_elapsed_ms"].(float64)
+
+				choice := chunk["choices"].([]any)[0].(map[string]any)
+				token := choice["text"]
+				if delta, ok := choice["delta"].(map[string]any); ok {
+					token = delta["content"]
+				}
+				if token != nil && token != "" {
+					tokenTimes = append(tokenTimes, elapsed)
+				} else if choice["finish_reason"] != nil {
+					// the last chunk is sent right after the last token
+					Expect(tokenTimes).NotTo(BeEmpty())
+					Expect(elapsed).To(Equal(tokenTimes[len(tokenTimes)-1]))
+				} else {
+					// the role chunk is sent before the first token
+					Expect(elapsed).To(Equal(0.0))
+				}
+			}
+
+			Expect(tokenTimes).To(HaveLen(len(common.Tokenize("Hello, how are you?"))))
+			for i, elapsed := range tokenTimes {
+				Expect(elapsed).To(Equal(float64(ttft + i*itl)))
+			}
+		},
+		func(path string, bodyTemplate string) string {
+			return fmt.Sprintf("path: %s", path)
+		},
+		Entry(nil, "chat/completions", chatStreamBody),
+		Entry(nil, "completions", textStreamBody),
+	)
+
+	It("should not send the chunk timing by default", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		events := sendRawStreamingRequest(client, "chat/completions", fmt.Sprintf(chatStreamBody, includeUsageOption))
+		for _, event := range events {
+			Expect(event).NotTo(ContainSubstring("sim_elapsed_ms"))
+		}
+	})
+})
+
+var _ = Describe("Streaming echo of multi-byte text", func() {
+	DescribeTable("should stream every rune whole and echo the prompt as-is",
+		func(path string, prompt string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeEcho)
+			Expect(err).NotTo(HaveOccurred())
+
+			promptJSON, err := json.Marshal(prompt)
+			Expect(err).NotTo(HaveOccurred())
+			var body string
+			if path == "completions" {
+				body = fmt.Sprintf(`{"prompt": %s, "model": "%s", "stream": true}`, promptJSON, model)
+			} else {
+				body = fmt.Sprintf(`{"messages": [{"role": "user", "content": %s}], "model": "%s", "stream": true}`,
+					promptJSON, model)
+			}
+			events := sendRawStreamingRequest(client, path, body)
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+
+			var echoed strings.Builder
+			for _, event := range events[:len(events)-1] {
+				data := strings.TrimPrefix(event, "data: ")
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(data), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				choice := chunk["choices"].([]any)[0].(map[string]any)
+				token := choice["text"]
+				if delta, ok := choice["delta"].(map[string]any); ok {
+					token = delta["content"]
+				}
+				if token == nil {
+					continue
+				}
+				// an invalid sequence would be replaced by the JSON encoder, check the decoded text too
+				Expect(utf8.ValidString(token.(string))).To(BeTrue())
+				Expect(token.(string)).NotTo(ContainSubstring(string(utf8.RuneError)))
+				echoed.WriteString(token.(string))
+			}
+			Expect(echoed.String()).To(Equal(prompt))
+		},
+		func(path string, prompt string) string {
+			return fmt.Sprintf("path: %s, prompt: %s", path, prompt)
+		},
+		Entry(nil, "chat/completions", "Hi 👋🏽, I ❤️ 🦙s!"),
+		Entry(nil, "completions", "Hi 👋🏽, I ❤️ 🦙s!"),
+		Entry(nil, "chat/completions", "你好，世界！今天天气很好。"),
+		Entry(nil, "completions", "你好，世界！今天天气很好。"),
+		Entry(nil, "chat/completions", "<|im_start|>user\nWhat's up?<|im_end|>\n<|im_start|>assistant\n"),
+		Entry(nil, "completions", "<|im_start|>user\nWhat's up?<|im_end|>\n<|im_start|>assistant\n"),
+	)
+})
+
+var _ = Describe("Streaming chunk size and flush cadence", func() {
+	const prompt = "Hello, how are you?"
+
+	DescribeTable("should send several tokens in a chunk",
+		func(path string, bodyTemplate string) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--stream-tokens-per-chunk", "3"}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, includeUsageOption))
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+
+			var tokenChunks []string
+			var usage map[string]any
+			for _, event := range events[:len(events)-1] {
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				if chunk["usage"] != nil {
+					usage = chunk["usage"].(map[string]any)
+					continue
+				}
+				choice := chunk["choices"].([]any)[0].(map[string]any)
+				token := choice["text"]
+				if delta, ok := choice["delta"].(map[string]any); ok {
+					token = delta["content"]
+				}
+				if token != nil && token != "" {
+					tokenChunks = append(tokenChunks, token.(string))
+				}
+			}
+
+			tokens := common.Tokenize(prompt)
+			Expect(tokenChunks).To(HaveLen((len(tokens) + 2) / 3))
+			Expect(tokenChunks[0]).To(Equal(strings.Join(tokens[:3], "")))
+			Expect(strings.Join(tokenChunks, "")).To(Equal(prompt))
+			Expect(usage["completion_tokens"]).To(Equal(float64(len(tokens))))
+		},
+		func(path string, bodyTemplate string) string {
+			return fmt.Sprintf("path: %s", path)
+		},
+		Entry(nil, "chat/completions", chatStreamBody),
+		Entry(nil, "completions", textStreamBody),
+	)
+
+	It("should send the arguments of every tool call in its own chunks", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--stream-tokens-per-chunk", "4"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		events := sendRawStreamingRequest(client, "chat/completions", fmt.Sprintf(chatToolsStreamBody, ""))
+		arguments := make(map[float64]string)
+		for _, event := range events[:len(events)-1] {
+			var chunk map[string]any
+			err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+			Expect(err).NotTo(HaveOccurred())
+			delta := chunk["choices"].([]any)[0].(map[string]any)["delta"].(map[string]any)
+			toolCalls, ok := delta["tool_calls"].([]any)
+			if !ok {
+				continue
+			}
+			Expect(toolCalls).To(HaveLen(1))
+			toolCall := toolCalls[0].(map[string]any)
+			index := toolCall["index"].(float64)
+			arguments[index] += toolCall["function"].(map[string]any)["arguments"].(string)
+		}
+		Expect(arguments).NotTo(BeEmpty())
+		for _, args := range arguments {
+			Expect(json.Valid([]byte(args))).To(BeTrue())
+		}
+	})
+
+	DescribeTable("should flush the chunks by the flush interval",
+		func(flushInterval string, flushedAtEnd bool) {
+			ctx := context.TODO()
+			args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--inter-token-latency", "100",
+				"--stream-flush-interval", flushInterval}
+			client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			start := time.Now()
+			resp, err := client.Post("http://localhost/v1/completions", "application/json",
+				strings.NewReader(fmt.Sprintf(textStreamBody, "")))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(resp.Body.Close()).To(Succeed())
+			}()
+			_, err = resp.Body.Read(make([]byte, 1))
+			Expect(err).NotTo(HaveOccurred())
+			firstByteTime := time.Since(start)
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(HaveSuffix(doneEvent + "\n\n"))
+
+			streamDuration := time.Duration(len(common.Tokenize(prompt))-1) * 100 * time.Millisecond
+			if flushedAtEnd {
+				Expect(firstByteTime).To(BeNumerically(">=", streamDuration))
+			} else {
+				Expect(firstByteTime).To(BeNumerically("<", streamDuration))
+			}
+		},
+		func(flushInterval string, flushedAtEnd bool) string {
+			return fmt.Sprintf("flush interval: %s", flushInterval)
+		},
+		Entry(nil, "10s", true),
+		Entry(nil, "50ms", false),
+	)
+})
+
+var _ = Describe("Azure OpenAI API flavor", func() {
+	safeFilterResults := map[string]any{
+		"hate":      map[string]any{"filtered": false, "severity": "safe"},
+		"self_harm": map[string]any{"filtered": false, "severity": "safe"},
+		"sexual":    map[string]any{"filtered": false, "severity": "safe"},
+		"violence":  map[string]any{"filtered": false, "severity": "safe"},
+	}
+	safePromptFilterResults := []any{map[string]any{"prompt_index": 0.0, "content_filter_results": safeFilterResults}}
+
+	startAzureServer := func(ctx context.Context, flavor string) *http.Client {
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--api-flavor", flavor}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	DescribeTable("should stream the prompt filter results first and the content filter results in the chunks",
+		func(path string, bodyTemplate string) {
+			client := startAzureServer(context.TODO(), common.APIFlavorAzure)
+
+			events := sendRawStreamingRequest(client, path, fmt.Sprintf(bodyTemplate, includeUsageOption))
+			Expect(events[len(events)-1]).To(Equal(doneEvent))
+
+			var first map[string]any
+			err := json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &first)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first["prompt_filter_results"]).To(Equal(safePromptFilterResults))
+			Expect(first["choices"]).To(BeEmpty())
+
+			text := ""
+			for _, event := range events[1 : len(events)-1] {
+				var chunk map[string]any
+				err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(chunk).NotTo(HaveKey("model"))
+				Expect(chunk).NotTo(HaveKey("prompt_filter_results"))
+				if chunk["usage"] != nil {
+					continue
+				}
+				choice := chunk["choices"].([]any)[0].(map[string]any)
+				token := choice["text"]
+				if delta, ok := choice["delta"].(map[string]any); ok {
+					token = delta["content"]
+				}
+				if token != nil && token != "" {
+					text += token.(string)
+					Expect(choice["content_filter_results"]).To(Equal(safeFilterResults))
+				} else {
+					// the role and the finish reason chunks have empty results
+					Expect(choice["content_filter_results"]).To(BeEmpty())
+				}
+			}
+			Expect(text).To(Equal("Hello, how are you?"))
+		},
+		func(path string, bodyTemplate string) string {
+			return fmt.Sprintf("path: %s", path)
+		},
+		Entry(nil, "chat/completions", chatStreamBody),
+		Entry(nil, "completions", textStreamBody),
+	)
+
+	DescribeTable("should send the filter results in the non-streaming responses",
+		func(path string, body string) {
+			client := startAzureServer(context.TODO(), common.APIFlavorAzure)
+
+			resp, err := client.Post("http://localhost/v1/"+path, "application/json", strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(resp.Body.Close()).To(Succeed())
+			}()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			var response map[string]any
+			Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+			Expect(response["model"]).To(Equal(model))
+			Expect(response["prompt_filter_results"]).To(Equal(safePromptFilterResults))
+			choices := response["choices"].([]any)
+			Expect(choices).To(HaveLen(1))
+			Expect(choices[0].(map[string]any)["content_filter_results"]).To(Equal(safeFilterResults))
+		},
+		func(path string, body string) string {
+			return fmt.Sprintf("path: %s", path)
+		},
+		Entry(nil, "chat/completions", fmt.Sprintf(strings.Replace(chatStreamBody, "true", "false", 1), "")),
+		Entry(nil, "completions", fmt.Sprintf(strings.Replace(textStreamBody, "true", "false", 1), "")),
+	)
+
+	It("should not send the filter results in the OpenAI flavor", func() {
+		client := startAzureServer(context.TODO(), common.APIFlavorOpenAI)
+
+		events := sendRawStreamingRequest(client, "chat/completions", fmt.Sprintf(chatStreamBody, ""))
+		for _, event := range events[:len(events)-1] {
+			Expect(event).NotTo(ContainSubstring("filter_results"))
+			Expect(event).To(ContainSubstring(`"model":"` + model + `"`))
+		}
+	})
+})
@@ -0,0 +1,56 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/daulet/tokenizers"
+	"github.com/llm-d/llm-d-kv-cache-manager/pkg/tokenization"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+// errInjectedTokenizerFailure is returned by the tokenizer when a tokenizer failure is injected
+var errInjectedTokenizerFailure = errors.New("injected tokenizer failure: tokenizer is unavailable")
+
+// faultyTokenizer wraps a tokenizer and fails the tokenization according to the tokenizer failure
+// rate and the tokenizer failure models, simulates a tokenizer that cannot be downloaded
+type faultyTokenizer struct {
+	tokenization.Tokenizer
+	config *common.Configuration
+	random *common.Random
+}
+
+// newTokenizer returns the given tokenizer, wrapped by a faulty tokenizer if tokenizer failures are configured
+func newTokenizer(tokenizer tokenization.Tokenizer, config *common.Configuration,
+	random *common.Random) tokenization.Tokenizer {
+	if config.TokenizerFailureRate == 0 && len(config.TokenizerFailureModels) == 0 {
+		return tokenizer
+	}
+	return &faultyTokenizer{Tokenizer: tokenizer, config: config, random: random}
+}
+
+// Encode tokenizes the input string or returns an error if a failure is injected
+func (t *faultyTokenizer) Encode(input, modelName string) ([]uint32, []tokenizers.Offset, error) {
+	if slices.Contains(t.config.TokenizerFailureModels, modelName) ||
+		(t.config.TokenizerFailureRate > 0 && t.random.Int(1, 100) <= t.config.TokenizerFailureRate) {
+		return nil, nil, errInjectedTokenizerFailure
+	}
+	return t.Tokenizer.Encode(input, modelName)
+}
@@ -19,17 +19,23 @@ package llmdinferencesim
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	"github.com/llm-d/llm-d-inference-sim/pkg/dataset"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/shared"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/valyala/fasthttp"
 )
 
 var tools = []openai.ChatCompletionToolParam{
@@ -376,11 +382,13 @@ var _ = Describe("Simulator for request with tools", func() {
 							lastIndex++
 							args[tc.Function.Name] = []string{tc.Function.Arguments}
 							functionName = tc.Function.Name
+							// the id is sent in the first chunk of the tool call only
+							Expect(tc.ID).NotTo(BeEmpty())
 						} else {
 							Expect(tc.Function.Name).To(BeEmpty())
 							args[functionName] = append(args[functionName], tc.Function.Arguments)
+							Expect(tc.ID).To(BeEmpty())
 						}
-						Expect(tc.ID).NotTo(BeEmpty())
 						Expect(tc.Type).To(Equal("function"))
 					}
 				}
@@ -819,3 +827,470 @@ var _ = Describe("Simulator for request with tools", func() {
 		Entry(nil, 100, 3, 150, 2500),
 	)
 })
+
+// createTrivialTools creates the given number of tools with a single string parameter
+func createTrivialTools(numberOfTools int) []openai.ChatCompletionToolParam {
+	trivialTools := make([]openai.ChatCompletionToolParam, numberOfTools)
+	for i := range numberOfTools {
+		trivialTools[i] = openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        fmt.Sprintf("tool_%d", i),
+				Description: openai.String("A trivial tool"),
+				Parameters: openai.FunctionParameters{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"value": map[string]string{
+							"type": "string",
+						},
+					},
+				},
+			},
+		}
+	}
+	return trivialTools
+}
+
+// createNestedTool creates a tool whose parameters schema is nested to the given depth,
+// if withArrays is true, every other level is an array of objects
+func createNestedTool(depth int, withArrays bool) []openai.ChatCompletionToolParam {
+	var property map[string]interface{}
+	for level := depth; level > 0; level-- {
+		if property == nil {
+			property = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"value": map[string]interface{}{"type": "string"},
+				},
+			}
+		} else if withArrays && level%2 == 0 {
+			property = map[string]interface{}{
+				"type":  "array",
+				"items": property,
+			}
+		} else {
+			property = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"child": property,
+				},
+			}
+		}
+	}
+	return []openai.ChatCompletionToolParam{
+		{
+			Function: openai.FunctionDefinitionParam{
+				Name:        "nested_tool",
+				Description: openai.String("A tool with nested parameters"),
+				Parameters:  openai.FunctionParameters(property),
+			},
+		},
+	}
+}
+
+var _ = Describe("Simulator tools limits", func() {
+	DescribeTable("should enforce the tools limits",
+		func(requestTools []openai.ChatCompletionToolParam, expectedLimit string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+			params.Tools = requestTools
+
+			_, err = openaiclient.Chat.Completions.New(ctx, params)
+			if expectedLimit == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			ok := errors.As(err, &openaiError)
+			Expect(ok).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+			Expect(string(openaiError.DumpResponse(true))).To(ContainSubstring(expectedLimit))
+
+			metricsResp, err := client.Get(metricsUrl)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(metricsResp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(
+				fmt.Sprintf("sim_tool_limit_rejections_total{limit=\"%s\"} 1", expectedLimit)))
+		},
+		func(requestTools []openai.ChatCompletionToolParam, expectedLimit string) string {
+			return fmt.Sprintf("number of tools: %d, expected limit: %s", len(requestTools), expectedLimit)
+		},
+		Entry(nil, createTrivialTools(200), openaiserverapi.LimitMaxToolsPerRequest),
+		Entry(nil, createTrivialTools(129), openaiserverapi.LimitMaxToolsPerRequest),
+		Entry(nil, createTrivialTools(128), ""),
+		Entry(nil, createNestedTool(20, false), openaiserverapi.LimitMaxToolSchemaDepth),
+		Entry(nil, createNestedTool(17, false), openaiserverapi.LimitMaxToolSchemaDepth),
+		Entry(nil, createNestedTool(16, false), ""),
+		Entry(nil, createNestedTool(17, true), openaiserverapi.LimitMaxToolSchemaDepth),
+		Entry(nil, createNestedTool(16, true), ""),
+	)
+
+	It("should respect configured tools limits", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom,
+			"--max-tools-per-request", "2", "--max-tool-schema-depth", "3"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+
+		params.Tools = createTrivialTools(2)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		params.Tools = createTrivialTools(3)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(string(openaiError.DumpResponse(true))).To(ContainSubstring(openaiserverapi.LimitMaxToolsPerRequest))
+
+		params.Tools = createNestedTool(3, true)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		params.Tools = createNestedTool(4, true)
+		_, err = openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).To(HaveOccurred())
+		Expect(errors.As(err, &openaiError)).To(BeTrue())
+		Expect(string(openaiError.DumpResponse(true))).To(ContainSubstring(openaiserverapi.LimitMaxToolSchemaDepth))
+	})
+})
+
+// sendChatRequest sends a chat completion request in streaming or non-streaming mode,
+// and returns the content, the tool calls and the error of the response
+func sendChatRequest(ctx context.Context, openaiclient openai.Client, params openai.ChatCompletionNewParams,
+	streaming bool, opts ...option.RequestOption) (string, []string, error) {
+	var content string
+	toolCalls := make([]string, 0)
+	if !streaming {
+		resp, err := openaiclient.Chat.Completions.New(ctx, params, opts...)
+		if err != nil {
+			return "", nil, err
+		}
+		Expect(resp.Choices).To(HaveLen(1))
+		for _, tc := range resp.Choices[0].Message.ToolCalls {
+			toolCalls = append(toolCalls, tc.Function.Name)
+		}
+		return resp.Choices[0].Message.Content, toolCalls, nil
+	}
+
+	stream := openaiclient.Chat.Completions.NewStreaming(ctx, params, opts...)
+	defer func() {
+		err := stream.Close()
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	for stream.Next() {
+		for _, choice := range stream.Current().Choices {
+			content += choice.Delta.Content
+			for _, tc := range choice.Delta.ToolCalls {
+				if tc.Function.Name != "" {
+					toolCalls = append(toolCalls, tc.Function.Name)
+				}
+			}
+		}
+	}
+	return content, toolCalls, stream.Err()
+}
+
+var _ = Describe("Simulator tools edge cases", func() {
+	DescribeTable("should treat an empty tools array as no tools",
+		func(streaming bool) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+			content, toolCalls, err := sendChatRequest(ctx, openaiclient, params, streaming,
+				option.WithJSONSet("tools", []any{}), option.WithJSONSet("tool_choice", "required"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content).NotTo(BeEmpty())
+			Expect(toolCalls).To(BeEmpty())
+		},
+		func(streaming bool) string {
+			return fmt.Sprintf("streaming: %t", streaming)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	DescribeTable("should reject invalid tools and tool choice",
+		func(streaming bool, requestTools []openai.ChatCompletionToolParam, toolChoice string, expectedMessage string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+			params.Tools = requestTools
+			if toolChoice != "" {
+				params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+					OfChatCompletionNamedToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+						Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: toolChoice},
+					},
+				}
+			}
+
+			_, _, err = sendChatRequest(ctx, openaiclient, params, streaming)
+			Expect(err).To(HaveOccurred())
+			var openaiError *openai.Error
+			Expect(errors.As(err, &openaiError)).To(BeTrue())
+			Expect(openaiError.StatusCode).To(Equal(400))
+			Expect(openaiError.Message).To(ContainSubstring(expectedMessage))
+		},
+		func(streaming bool, requestTools []openai.ChatCompletionToolParam, toolChoice string, expectedMessage string) string {
+			return fmt.Sprintf("streaming: %t, tool choice: %s, expected message: %s", streaming, toolChoice, expectedMessage)
+		},
+		Entry(nil, false, append(tools, tools[0]), "", "Duplicate tool name `get_weather`"),
+		Entry(nil, true, append(tools, tools[0]), "", "Duplicate tool name `get_weather`"),
+		Entry(nil, false, tools, "get_time", "does not match any of the specified `tools`"),
+		Entry(nil, true, tools, "get_time", "does not match any of the specified `tools`"),
+		Entry(nil, false, nil, "get_weather", "`tools` must be set"),
+		Entry(nil, true, nil, "get_weather", "`tools` must be set"),
+	)
+
+	DescribeTable("should call at most one tool when parallel tool calls are disabled",
+		func(streaming bool) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+			params.Tools = tools
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+			for range 10 {
+				_, toolCalls, err := sendChatRequest(ctx, openaiclient, params, streaming,
+					option.WithJSONSet("parallel_tool_calls", false))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(toolCalls).To(HaveLen(1))
+			}
+		},
+		func(streaming bool) string {
+			return fmt.Sprintf("streaming: %t", streaming)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+
+	DescribeTable("should call only the function named in tool choice",
+		func(streaming bool) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+			params.Tools = tools
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+				OfChatCompletionNamedToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+					Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: "get_temperature"},
+				},
+			}
+
+			_, toolCalls, err := sendChatRequest(ctx, openaiclient, params, streaming)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(toolCalls).NotTo(BeEmpty())
+			for _, name := range toolCalls {
+				Expect(name).To(Equal("get_temperature"))
+			}
+		},
+		func(streaming bool) string {
+			return fmt.Sprintf("streaming: %t", streaming)
+		},
+		Entry(nil, false),
+		Entry(nil, true),
+	)
+})
+
+var _ = Describe("Tool call ids", func() {
+	toolCallIDPattern := `^chatcmpl-tool-\d{10}$`
+
+	It("should create unique tool call ids", func() {
+		toolJSON := `{"type": "function", "function": {"name": "get_weather",
+			"parameters": {"type": "object", "properties": {"location": {"type": "string"}}}}}`
+		var tool openaiserverapi.Tool
+		Expect(json.Unmarshal([]byte(toolJSON), &tool)).To(Succeed())
+		// the same tool can be called many times in one response
+		manyTools := make([]openaiserverapi.Tool, 128)
+		for i := range manyTools {
+			manyTools[i] = tool
+		}
+		config := &common.Configuration{ToolCallNotRequiredParamProbability: 50}
+
+		for seed := range int64(50) {
+			random := common.NewRandom(seed)
+			calls, _, err := openaiserverapi.CreateToolCalls(manyTools, openaiserverapi.ToolChoiceRequired, true,
+				config, random)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).NotTo(BeEmpty())
+			Expect(openaiserverapi.ValidateToolCallIDs(calls)).To(Succeed())
+			ids := make(map[string]struct{})
+			for _, call := range calls {
+				Expect(call.ID).To(MatchRegexp(toolCallIDPattern))
+				ids[call.ID] = struct{}{}
+			}
+			Expect(ids).To(HaveLen(len(calls)))
+		}
+	})
+
+	It("should detect duplicate tool call ids", func() {
+		calls := []openaiserverapi.ToolCall{{ID: "chatcmpl-tool-0123456789"}, {ID: "chatcmpl-tool-0123456789"}}
+		Expect(openaiserverapi.ValidateToolCallIDs(calls)).To(MatchError(ContainSubstring("duplicate tool call id")))
+		calls = []openaiserverapi.ToolCall{{ID: "chatcmpl-tool-0123456789"}, {}}
+		Expect(openaiserverapi.ValidateToolCallIDs(calls)).To(MatchError(ContainSubstring("empty tool call id")))
+	})
+
+	It("should repeat the tool call id in every chunk", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--repeat-tool-call-ids-in-chunks"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, true)
+		params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt("required")}
+		params.Tools = tools
+
+		stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+		defer func() {
+			err := stream.Close()
+			Expect(err).NotTo(HaveOccurred())
+		}()
+		// the ids of the tool calls by their index
+		ids := make(map[int64]string)
+		nChunks := 0
+		for stream.Next() {
+			for _, choice := range stream.Current().Choices {
+				for _, tc := range choice.Delta.ToolCalls {
+					nChunks++
+					Expect(tc.ID).To(MatchRegexp(toolCallIDPattern))
+					if id, ok := ids[tc.Index]; ok {
+						Expect(tc.ID).To(Equal(id))
+					} else {
+						ids[tc.Index] = tc.ID
+					}
+				}
+			}
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(nChunks).To(BeNumerically(">", len(ids)))
+		uniqueIDs := make(map[string]struct{})
+		for _, id := range ids {
+			uniqueIDs[id] = struct{}{}
+		}
+		Expect(uniqueIDs).To(HaveLen(len(ids)))
+	})
+})
+
+var _ = Describe("Structured outputs", func() {
+	weatherSchema := `{"type": "object", "properties": {
+		"city": {"type": "string"},
+		"temperature": {"type": "number"},
+		"days": {"type": "array", "items": {"type": "integer"}, "minItems": 1, "maxItems": 3},
+		"unit": {"type": "string", "enum": ["celsius", "fahrenheit"]}},
+		"required": ["city", "temperature", "days", "unit"]}`
+
+	// getStructuredOutputParams returns chat completion params with a json_schema response format of the given schema
+	getStructuredOutputParams := func(client option.HTTPClient, schema string,
+		streaming bool) (openai.Client, openai.ChatCompletionNewParams) {
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, streaming)
+		var schemaMap map[string]any
+		Expect(json.Unmarshal([]byte(schema), &schemaMap)).To(Succeed())
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{Name: "weather", Schema: schemaMap},
+			},
+		}
+		return openaiclient, params
+	}
+
+	// expectValidResponse checks that the given response text is valid JSON that follows the schema
+	expectValidResponse := func(text string) {
+		compiled, err := jsonschema.CompileString("weather.json", weatherSchema)
+		Expect(err).NotTo(HaveOccurred())
+		var value any
+		Expect(json.Unmarshal([]byte(text), &value)).To(Succeed())
+		Expect(compiled.Validate(value)).To(Succeed())
+	}
+
+	DescribeTable("should return a response that follows the schema",
+		func(mode string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, mode)
+			Expect(err).NotTo(HaveOccurred())
+
+			openaiclient, params := getStructuredOutputParams(client, weatherSchema, false)
+			resp, err := openaiclient.Chat.Completions.New(ctx, params)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Choices).To(HaveLen(1))
+			Expect(resp.Choices[0].FinishReason).To(Equal(dataset.StopFinishReason))
+			expectValidResponse(resp.Choices[0].Message.Content)
+			Expect(resp.Usage.CompletionTokens).To(Equal(int64(len(common.Tokenize(resp.Choices[0].Message.Content)))))
+		},
+		func(mode string) string {
+			return "mode: " + mode
+		},
+		Entry(nil, common.ModeEcho),
+		Entry(nil, common.ModeRandom),
+	)
+
+	It("should stream a response that follows the schema", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getStructuredOutputParams(client, weatherSchema, true)
+		stream := openaiclient.Chat.Completions.NewStreaming(ctx, params)
+		defer func() {
+			Expect(stream.Close()).To(Succeed())
+		}()
+		var text strings.Builder
+		for stream.Next() {
+			for _, choice := range stream.Current().Choices {
+				text.WriteString(choice.Delta.Content)
+			}
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		expectValidResponse(text.String())
+	})
+
+	It("should cut the response at the maximal number of tokens", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getStructuredOutputParams(client, weatherSchema, false)
+		params.MaxCompletionTokens = param.NewOpt(int64(3))
+		resp, err := openaiclient.Chat.Completions.New(ctx, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Choices[0].FinishReason).To(Equal(dataset.LengthFinishReason))
+		Expect(resp.Usage.CompletionTokens).To(Equal(int64(3)))
+	})
+
+	DescribeTable("should reject an invalid response format",
+		func(responseFormat string, errMsg string) {
+			ctx := context.TODO()
+			client, err := startServer(ctx, common.ModeRandom)
+			Expect(err).NotTo(HaveOccurred())
+
+			body := `{"model": "` + model + `", "messages": [{"role": "user", "content": "hello"}],
+				"response_format": ` + responseFormat + `}`
+			resp, err := client.Post("http://localhost/v1/chat/completions", "application/json",
+				strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(fasthttp.StatusBadRequest))
+			Expect(string(data)).To(ContainSubstring(errMsg))
+		},
+		Entry("unknown type", `{"type": "xml"}`, "invalid response_format type 'xml'"),
+		Entry("missing schema", `{"type": "json_schema", "json_schema": {"name": "weather"}}`,
+			"requires json_schema.schema"),
+		Entry("object without properties", `{"type": "json_schema", "json_schema": {"name": "weather",
+			"schema": {"type": "object"}}}`, "properties"),
+	)
+})
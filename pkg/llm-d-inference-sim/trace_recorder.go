@@ -0,0 +1,99 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Recording of the incoming requests in a trace file that can be replayed
+package llmdinferencesim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// traceRecorder writes the recorded requests to the trace file, a request per line in the format
+// of the replay file, the offsets are the times since the start of the recording
+type traceRecorder struct {
+	mutex sync.Mutex
+	// file is the trace file, nil after the recording stopped
+	file  *os.File
+	start time.Time
+}
+
+// startTraceRecording creates the trace file, if configured, the recording stops when the context is done
+func (s *VllmSimulator) startTraceRecording(ctx context.Context) error {
+	if s.config.RecordTrace == "" {
+		return nil
+	}
+	file, err := os.Create(s.config.RecordTrace)
+	if err != nil {
+		return err
+	}
+	recorder := &traceRecorder{file: file, start: time.Now()}
+	s.traceRecorder = recorder
+	s.logger.Info("Recording requests", "file", s.config.RecordTrace)
+
+	go func() {
+		<-ctx.Done()
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+		if err := recorder.file.Close(); err != nil {
+			s.logger.Error(err, "failed to close trace file")
+		}
+		recorder.file = nil
+	}()
+	return nil
+}
+
+// recordRequest writes the request to the trace file if the requests are recorded, requests with
+// a body that is not valid JSON are not recorded
+func (s *VllmSimulator) recordRequest(ctx *fasthttp.RequestCtx, endpoint string) {
+	recorder := s.traceRecorder
+	if recorder == nil {
+		return
+	}
+	now := time.Now()
+	// the body is compacted to a single line
+	var body bytes.Buffer
+	if err := json.Compact(&body, ctx.Request.Body()); err != nil {
+		s.logger.V(4).Info("Request with an invalid body is not recorded", "endpoint", endpoint)
+		return
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.file == nil {
+		return
+	}
+	record := replayRecord{
+		OffsetMs:  now.Sub(recorder.start).Milliseconds(),
+		Endpoint:  endpoint,
+		Body:      body.Bytes(),
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		s.logger.Error(err, "failed to marshal recorded request")
+		return
+	}
+	if _, err := recorder.file.Write(append(data, '\n')); err != nil {
+		s.logger.Error(err, "failed to write recorded request", "file", recorder.file.Name())
+	}
+}
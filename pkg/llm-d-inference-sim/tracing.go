@@ -0,0 +1,180 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/llm-d/llm-d-inference-sim"
+	tracingServiceName = "llm-d-inference-sim"
+	// tracingShutdownTimeout is the time the spans that were not exported yet are flushed for, at shutdown
+	tracingShutdownTimeout = 5 * time.Second
+
+	requestSpanName         = "llm_request"
+	queueSpanName           = "queue"
+	prefillSpanName         = "prefill"
+	kvCacheTransferSpanName = "kv_cache_transfer"
+	decodeSpanName          = "decode"
+
+	// abortFinishReason is the finish reason of the requests whose clients disconnected, as in vLLM
+	abortFinishReason = "abort"
+)
+
+// span attributes, as reported by vLLM
+const (
+	spanAttrRequestID        = attribute.Key("gen_ai.request.id")
+	spanAttrModel            = attribute.Key("gen_ai.response.model")
+	spanAttrMaxTokens        = attribute.Key("gen_ai.request.max_tokens")
+	spanAttrPromptTokens     = attribute.Key("gen_ai.usage.prompt_tokens")
+	spanAttrCompletionTokens = attribute.Key("gen_ai.usage.completion_tokens")
+	spanAttrFinishReasons    = attribute.Key("gen_ai.response.finish_reasons")
+)
+
+// startTracing creates the tracer that exports the request traces to the OTLP endpoint, if set,
+// the tracer provider is shut down when the context is done
+func (s *VllmSimulator) startTracing(ctx context.Context) error {
+	if s.config.OTLPEndpoint == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(s.config.OTLPEndpoint))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingServiceName))))
+	s.tracer = provider.Tracer(tracerName)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error(err, "failed to shut down tracing")
+		}
+	}()
+	s.logger.Info("Tracing is enabled", "endpoint", s.config.OTLPEndpoint)
+	return nil
+}
+
+// getTraceParent returns the span context of the client's trace from the W3C trace context headers
+// of the request, the span context is invalid if tracing is disabled or the headers are not set
+func (s *VllmSimulator) getTraceParent(ctx *fasthttp.RequestCtx) trace.SpanContext {
+	if s.tracer == nil {
+		return trace.SpanContext{}
+	}
+	carrier := propagation.MapCarrier{
+		"traceparent": string(ctx.Request.Header.Peek("traceparent")),
+		"tracestate":  string(ctx.Request.Header.Peek("tracestate")),
+	}
+	return trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+}
+
+// traceRequest exports the spans of a tracked request that has just finished, a span of the request
+// and spans of its queue, prefill (or kv cache transfer) and decode phases
+func (s *VllmSimulator) traceRequest(requestID string, nCompletionTokens int, finishReasons []string) {
+	if s.tracer == nil {
+		return
+	}
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	req.mutex.RLock()
+	defer req.mutex.RUnlock()
+
+	end := time.Now()
+	attributes := []attribute.KeyValue{
+		spanAttrRequestID.String(requestID),
+		spanAttrModel.String(s.getDisplayedModelName(req.model)),
+		spanAttrPromptTokens.Int(req.promptTokens),
+		spanAttrCompletionTokens.Int(nCompletionTokens),
+		spanAttrFinishReasons.StringSlice(finishReasons),
+	}
+	if req.maxTokens != nil {
+		attributes = append(attributes, spanAttrMaxTokens.Int64(*req.maxTokens))
+	}
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), req.traceParent)
+	ctx, span := s.tracer.Start(ctx, requestSpanName, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(req.enqueueTime), trace.WithAttributes(attributes...))
+
+	// a phase that didn't start is not traced, a phase that didn't end lasts until the end of the request
+	tracePhase := func(name string, start time.Time, phaseEnd time.Time) {
+		if start.IsZero() {
+			return
+		}
+		if phaseEnd.IsZero() {
+			phaseEnd = end
+		}
+		_, phaseSpan := s.tracer.Start(ctx, name, trace.WithTimestamp(start))
+		phaseSpan.End(trace.WithTimestamp(phaseEnd))
+	}
+	// the wait for a prefill slot is part of the queue phase
+	tracePhase(queueSpanName, req.enqueueTime, req.prefillStartTime)
+	prefillName := prefillSpanName
+	if req.remotePrefill {
+		prefillName = kvCacheTransferSpanName
+	}
+	tracePhase(prefillName, req.prefillStartTime, req.firstTokenTime)
+	tracePhase(decodeSpanName, req.firstTokenTime, end)
+
+	span.End(trace.WithTimestamp(end))
+}
+
+// choicesFinishReasons returns the finish reasons of the given choices
+func choicesFinishReasons(choices []responseChoice) []string {
+	finishReasons := make([]string, 0, len(choices))
+	for _, choice := range choices {
+		finishReasons = append(finishReasons, choice.finishReason)
+	}
+	return finishReasons
+}
+
+// abortFinishReasons returns the finish reasons of the n choices of an aborted request
+func abortFinishReasons(n int) []string {
+	finishReasons := make([]string, n)
+	for i := range finishReasons {
+		finishReasons[i] = abortFinishReason
+	}
+	return finishReasons
+}
+
+// streamFinishReasons returns the finish reasons of the choices of a stream that has just ended
+func (s *VllmSimulator) streamFinishReasons(context *streamingContext, choices []responseChoice) []string {
+	if context.aborted || context.failed {
+		return abortFinishReasons(len(choices))
+	}
+	finishReasons := choicesFinishReasons(choices)
+	if context.truncated {
+		for i := range finishReasons {
+			finishReasons[i] = s.config.MaxStreamDurationFinishReason
+		}
+	}
+	return finishReasons
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/klog/v2"
+)
+
+var _ = Describe("Request tracing", func() {
+	const (
+		traceID      = "0af7651916cd43dd8448eb211c80319c"
+		parentSpanID = "b7ad6b7169203331"
+	)
+
+	// startTracedServer starts a simulator that exports its spans to the returned in-memory exporter
+	startTracedServer := func(ctx context.Context) (*http.Client, *tracetest.InMemoryExporter) {
+		oldArgs := os.Args
+		defer func() {
+			os.Args = oldArgs
+		}()
+		os.Args = []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--time-to-first-token", "100",
+			"--inter-token-latency", "10", "--kv-cache-transfer-latency", "50"}
+		config, err := common.ParseCommandParamsAndLoadConfig()
+		Expect(err).NotTo(HaveOccurred())
+
+		s, err := New(klog.Background())
+		Expect(err).NotTo(HaveOccurred())
+		s.config = config
+		exporter := tracetest.NewInMemoryExporter()
+		s.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracerName)
+		client, err := startSimulator(ctx, s)
+		Expect(err).NotTo(HaveOccurred())
+		return client, exporter
+	}
+
+	// getSpans waits for the spans of a request, returns them by name
+	getSpans := func(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+		Eventually(func() []tracetest.SpanStub {
+			return exporter.GetSpans()
+		}).Should(HaveLen(4))
+		spans := make(map[string]tracetest.SpanStub)
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+		return spans
+	}
+
+	getAttribute := func(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+		for _, kv := range span.Attributes {
+			if kv.Key == key {
+				return kv.Value
+			}
+		}
+		return attribute.Value{}
+	}
+
+	It("should trace the phases of a request", func() {
+		ctx := context.TODO()
+		client, exporter := startTracedServer(ctx)
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(3)
+		resp, err := openaiclient.Chat.Completions.New(ctx, params,
+			option.WithHeader("traceparent", "00-"+traceID+"-"+parentSpanID+"-01"))
+		Expect(err).NotTo(HaveOccurred())
+
+		spans := getSpans(exporter)
+		Expect(spans).To(HaveKey(requestSpanName))
+		Expect(spans).To(HaveKey(queueSpanName))
+		Expect(spans).To(HaveKey(prefillSpanName))
+		Expect(spans).To(HaveKey(decodeSpanName))
+
+		// the request span is a child of the client's span
+		request := spans[requestSpanName]
+		Expect(request.SpanContext.TraceID().String()).To(Equal(traceID))
+		Expect(request.Parent.SpanID().String()).To(Equal(parentSpanID))
+		Expect(getAttribute(request, spanAttrRequestID).AsString()).NotTo(BeEmpty())
+		Expect(getAttribute(request, spanAttrModel).AsString()).To(Equal(model))
+		Expect(getAttribute(request, spanAttrPromptTokens).AsInt64()).To(Equal(resp.Usage.PromptTokens))
+		Expect(getAttribute(request, spanAttrCompletionTokens).AsInt64()).To(Equal(resp.Usage.CompletionTokens))
+		Expect(getAttribute(request, spanAttrMaxTokens).AsInt64()).To(Equal(int64(3)))
+		Expect(getAttribute(request, spanAttrFinishReasons).AsStringSlice()).To(
+			Equal([]string{resp.Choices[0].FinishReason}))
+
+		// the phases follow each other within the request span
+		for _, name := range []string{queueSpanName, prefillSpanName, decodeSpanName} {
+			Expect(spans[name].Parent.SpanID()).To(Equal(request.SpanContext.SpanID()))
+		}
+		Expect(spans[queueSpanName].StartTime).To(Equal(request.StartTime))
+		Expect(spans[prefillSpanName].StartTime).To(Equal(spans[queueSpanName].EndTime))
+		Expect(spans[decodeSpanName].StartTime).To(Equal(spans[prefillSpanName].EndTime))
+		Expect(spans[decodeSpanName].EndTime).To(Equal(request.EndTime))
+		Expect(spans[prefillSpanName].EndTime.Sub(spans[prefillSpanName].StartTime)).To(
+			BeNumerically(">=", 100*time.Millisecond))
+	})
+
+	It("should trace the kv cache transfer of a streamed request", func() {
+		ctx := context.TODO()
+		client, exporter := startTracedServer(ctx)
+
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, true)
+		stream := openaiclient.Completions.NewStreaming(ctx, params, option.WithJSONSet("do_remote_prefill", true))
+		for stream.Next() {
+		}
+		Expect(stream.Err()).NotTo(HaveOccurred())
+		Expect(stream.Close()).To(Succeed())
+
+		spans := getSpans(exporter)
+		Expect(spans).To(HaveKey(kvCacheTransferSpanName))
+		Expect(spans).NotTo(HaveKey(prefillSpanName))
+		// a request without a client trace starts a new trace
+		Expect(spans[requestSpanName].Parent.IsValid()).To(BeFalse())
+		Expect(getAttribute(spans[requestSpanName], spanAttrCompletionTokens).AsInt64()).To(BeNumerically(">", 0))
+	})
+})
@@ -0,0 +1,363 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Accounting of the tokens used by the requests per model and API key, reported by /v1/usage
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// usageGranularity is the granularity of the usage records, the usage is aggregated per minute
+	usageGranularity = time.Minute
+	// usageExportInterval is the interval of writing the usage to the usage export file
+	usageExportInterval = 10 * time.Second
+
+	usageGroupByModel  = "model"
+	usageGroupByAPIKey = "api_key"
+
+	usagePageObject   = "page"
+	usageBucketObject = "bucket"
+	usageResultObject = "usage.result"
+)
+
+// usageBucketWidths are the supported widths of the buckets of the usage response
+var usageBucketWidths = map[string]time.Duration{
+	"1m": time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// usageKey identifies a usage record, the usage of a model by an API key in a minute
+type usageKey struct {
+	model  string
+	apiKey string
+	// minute is the start of the minute in unix seconds
+	minute int64
+}
+
+// usageCounters are the counters of a usage record
+type usageCounters struct {
+	inputTokens  int64
+	outputTokens int64
+	requests     int64
+}
+
+// usageAccountant keeps the usage records of the last retention period in memory
+type usageAccountant struct {
+	mutex     sync.Mutex
+	retention time.Duration
+	records   map[usageKey]*usageCounters
+	// lastPrune is the minute the old records were last removed
+	lastPrune int64
+}
+
+func newUsageAccountant(retention time.Duration) *usageAccountant {
+	return &usageAccountant{retention: retention, records: make(map[usageKey]*usageCounters)}
+}
+
+// add adds a request with the given tokens to the usage of the model by the API key at the given time
+func (a *usageAccountant) add(model string, apiKey string, inputTokens int, outputTokens int, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	minute := now.Truncate(usageGranularity).Unix()
+	if minute != a.lastPrune {
+		a.prune(now)
+		a.lastPrune = minute
+	}
+	key := usageKey{model: model, apiKey: apiKey, minute: minute}
+	counters, ok := a.records[key]
+	if !ok {
+		counters = &usageCounters{}
+		a.records[key] = counters
+	}
+	counters.inputTokens += int64(inputTokens)
+	counters.outputTokens += int64(outputTokens)
+	counters.requests++
+}
+
+// prune removes the records older than the retention period, must be called under the mutex
+func (a *usageAccountant) prune(now time.Time) {
+	oldest := a.oldest(now).Unix()
+	for key := range a.records {
+		if key.minute < oldest {
+			delete(a.records, key)
+		}
+	}
+}
+
+// oldest returns the start of the oldest minute in the retention period
+func (a *usageAccountant) oldest(now time.Time) time.Time {
+	return now.Add(-a.retention).Truncate(usageGranularity)
+}
+
+// usageResult is the usage of a group in a bucket of the usage response, the model and the API key
+// are null if the usage is not grouped by them
+type usageResult struct {
+	Object           string  `json:"object"`
+	Model            *string `json:"model"`
+	APIKey           *string `json:"api_key"`
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	NumModelRequests int64   `json:"num_model_requests"`
+}
+
+// usageBucket is the usage in a time bucket of the usage response
+type usageBucket struct {
+	Object    string        `json:"object"`
+	StartTime int64         `json:"start_time"`
+	EndTime   int64         `json:"end_time"`
+	Results   []usageResult `json:"results"`
+}
+
+// usageResponse is the response of /v1/usage
+type usageResponse struct {
+	Object string        `json:"object"`
+	Data   []usageBucket `json:"data"`
+}
+
+// usageQuery is the query of /v1/usage
+type usageQuery struct {
+	start    time.Time
+	end      time.Time
+	width    time.Duration
+	byModel  bool
+	byAPIKey bool
+}
+
+// aggregate returns the usage buckets of the query, the buckets are aligned to their width since the
+// unix epoch, a bucket without usage has no results
+func (a *usageAccountant) aggregate(query usageQuery, now time.Time) usageResponse {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	start := query.start.Truncate(query.width)
+	if oldest := a.oldest(now).Truncate(query.width); start.Before(oldest) {
+		start = oldest
+	}
+	resp := usageResponse{Object: usagePageObject, Data: make([]usageBucket, 0)}
+	for bucketStart := start; bucketStart.Before(query.end); bucketStart = bucketStart.Add(query.width) {
+		bucketEnd := bucketStart.Add(query.width)
+		resp.Data = append(resp.Data, usageBucket{Object: usageBucketObject, StartTime: bucketStart.Unix(),
+			EndTime: bucketEnd.Unix(), Results: make([]usageResult, 0)})
+	}
+	if len(resp.Data) == 0 {
+		return resp
+	}
+
+	type groupKey struct {
+		bucket int
+		model  string
+		apiKey string
+	}
+	groups := make(map[groupKey]*usageResult)
+	for key, counters := range a.records {
+		minute := time.Unix(key.minute, 0)
+		if minute.Before(start) || !minute.Before(query.end) {
+			continue
+		}
+		group := groupKey{bucket: int(minute.Sub(start) / query.width)}
+		if query.byModel {
+			group.model = key.model
+		}
+		if query.byAPIKey {
+			group.apiKey = key.apiKey
+		}
+		result, ok := groups[group]
+		if !ok {
+			result = &usageResult{Object: usageResultObject}
+			if query.byModel {
+				result.Model = &group.model
+			}
+			if query.byAPIKey {
+				result.APIKey = &group.apiKey
+			}
+			groups[group] = result
+		}
+		result.InputTokens += counters.inputTokens
+		result.OutputTokens += counters.outputTokens
+		result.NumModelRequests += counters.requests
+	}
+	for group, result := range groups {
+		resp.Data[group.bucket].Results = append(resp.Data[group.bucket].Results, *result)
+	}
+	// the results are sorted by the model and the API key
+	for _, bucket := range resp.Data {
+		slices.SortFunc(bucket.Results, func(r1, r2 usageResult) int {
+			if c := strings.Compare(valueOrEmpty(r1.Model), valueOrEmpty(r2.Model)); c != 0 {
+				return c
+			}
+			return strings.Compare(valueOrEmpty(r1.APIKey), valueOrEmpty(r2.APIKey))
+		})
+	}
+	return resp
+}
+
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// getAPIKey returns the API key of the request, the bearer token of its Authorization header
+func getAPIKey(ctx *fasthttp.RequestCtx) string {
+	return strings.TrimPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+}
+
+// recordUsage adds the usage of the request with the given id, which has just been sent,
+// to the usage of the model by the request's API key
+func (s *VllmSimulator) recordUsage(requestID string, model string, nGenerationTokens int) {
+	value, ok := s.inFlightRequests.Load(requestID)
+	if !ok {
+		return
+	}
+	req := value.(*inFlightRequest)
+	s.addUsage(model, req.apiKey, req.promptTokens, nGenerationTokens)
+}
+
+// addUsage adds a request with the given tokens to the usage of the model by the API key
+func (s *VllmSimulator) addUsage(model string, apiKey string, inputTokens int, outputTokens int) {
+	if s.usage == nil {
+		// Happens in the tests
+		return
+	}
+	s.usage.add(model, apiKey, inputTokens, outputTokens, s.externalNow())
+}
+
+// parseUsageQuery parses the query parameters of /v1/usage: start_time and end_time in unix seconds,
+// by default the retention period until now, bucket_width, 1m, 1h or 1d, by default 1d, and group_by,
+// a comma separated list of model and api_key
+func parseUsageQuery(args *fasthttp.Args, now time.Time) (usageQuery, error) {
+	query := usageQuery{start: time.Unix(0, 0), end: now, width: usageBucketWidths["1d"]}
+	if value := args.Peek("start_time"); len(value) > 0 {
+		start, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil || start < 0 {
+			return query, fmt.Errorf("invalid start_time '%s', must be unix seconds", value)
+		}
+		query.start = time.Unix(start, 0)
+	}
+	if value := args.Peek("end_time"); len(value) > 0 {
+		end, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil || end < 0 {
+			return query, fmt.Errorf("invalid end_time '%s', must be unix seconds", value)
+		}
+		query.end = time.Unix(end, 0)
+	}
+	if !query.start.Before(query.end) {
+		return query, errors.New("start_time must be before end_time")
+	}
+	if value := args.Peek("bucket_width"); len(value) > 0 {
+		width, ok := usageBucketWidths[string(value)]
+		if !ok {
+			return query, fmt.Errorf("invalid bucket_width '%s', valid values are: 1m, 1h, 1d", value)
+		}
+		query.width = width
+	}
+	if value := args.Peek("group_by"); len(value) > 0 {
+		for _, group := range strings.Split(string(value), ",") {
+			switch strings.TrimSpace(group) {
+			case usageGroupByModel:
+				query.byModel = true
+			case usageGroupByAPIKey:
+				query.byAPIKey = true
+			default:
+				return query, fmt.Errorf("invalid group_by '%s', valid values are: %s, %s", group,
+					usageGroupByModel, usageGroupByAPIKey)
+			}
+		}
+	}
+	return query, nil
+}
+
+// HandleUsage http handler for /v1/usage, returns the tokens used in the time buckets of the query
+func (s *VllmSimulator) HandleUsage(ctx *fasthttp.RequestCtx) {
+	now := s.externalNow()
+	query, err := parseUsageQuery(ctx.QueryArgs(), now)
+	if err != nil {
+		s.sendCompletionError(ctx, openaiserverapi.NewCompletionError(err.Error(), fasthttp.StatusBadRequest, nil), "")
+		return
+	}
+	data, err := json.Marshal(s.usage.aggregate(query, now))
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal usage response")
+		ctx.Error("Failed to marshal usage response, "+err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SetBody(data)
+}
+
+// startUsageExport writes the usage to the usage export file, if configured, periodically and when
+// the context is done
+func (s *VllmSimulator) startUsageExport(ctx context.Context) {
+	if s.config.UsageExportFile == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(usageExportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.exportUsage()
+				return
+			case <-ticker.C:
+				s.exportUsage()
+			}
+		}
+	}()
+}
+
+// exportUsage writes the usage of the retention period per minute, model and API key to the usage export file
+// in the format of the /v1/usage response without the minutes without usage, the file is replaced atomically
+func (s *VllmSimulator) exportUsage() {
+	query := usageQuery{start: time.Unix(0, 0), end: s.externalNow(), width: usageGranularity,
+		byModel: true, byAPIKey: true}
+	usage := s.usage.aggregate(query, query.end)
+	// only the minutes with usage are exported
+	usage.Data = slices.DeleteFunc(usage.Data, func(bucket usageBucket) bool {
+		return len(bucket.Results) == 0
+	})
+	data, err := json.Marshal(usage)
+	if err != nil {
+		s.logger.Error(err, "Failed to marshal usage export")
+		return
+	}
+	tmpFile := s.config.UsageExportFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0o600); err != nil {
+		s.logger.Error(err, "Failed to write usage export file", "file", tmpFile)
+		return
+	}
+	if err := os.Rename(tmpFile, s.config.UsageExportFile); err != nil {
+		s.logger.Error(err, "Failed to replace usage export file", "file", s.config.UsageExportFile)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/valyala/fasthttp"
+)
+
+var _ = Describe("Usage accounting", func() {
+	Context("usage accountant", func() {
+		// an hour boundary, so that the minutes of the tests are in the same hour
+		start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+		It("should aggregate the usage in buckets by model and api key", func() {
+			accountant := newUsageAccountant(24 * time.Hour)
+			accountant.add("model-a", "key-1", 10, 5, start.Add(10*time.Second))
+			accountant.add("model-a", "key-2", 20, 10, start.Add(30*time.Second))
+			accountant.add("model-b", "key-1", 30, 15, start.Add(90*time.Second))
+			now := start.Add(3 * time.Minute)
+
+			resp := accountant.aggregate(usageQuery{start: start, end: now, width: time.Minute,
+				byModel: true, byAPIKey: true}, now)
+			Expect(resp.Object).To(Equal(usagePageObject))
+			Expect(resp.Data).To(HaveLen(3))
+			Expect(resp.Data[0].StartTime).To(Equal(start.Unix()))
+			Expect(resp.Data[0].EndTime).To(Equal(start.Add(time.Minute).Unix()))
+			Expect(resp.Data[0].Results).To(HaveLen(2))
+			Expect(*resp.Data[0].Results[0].Model).To(Equal("model-a"))
+			Expect(*resp.Data[0].Results[0].APIKey).To(Equal("key-1"))
+			Expect(resp.Data[0].Results[0].InputTokens).To(Equal(int64(10)))
+			Expect(*resp.Data[0].Results[1].APIKey).To(Equal("key-2"))
+			Expect(resp.Data[1].Results).To(HaveLen(1))
+			Expect(*resp.Data[1].Results[0].Model).To(Equal("model-b"))
+			Expect(resp.Data[2].Results).To(BeEmpty())
+
+			// without grouping the usage of a bucket is summed up
+			resp = accountant.aggregate(usageQuery{start: start, end: now, width: time.Hour}, now)
+			Expect(resp.Data).To(HaveLen(1))
+			Expect(resp.Data[0].Results).To(HaveLen(1))
+			result := resp.Data[0].Results[0]
+			Expect(result.Model).To(BeNil())
+			Expect(result.APIKey).To(BeNil())
+			Expect(result.InputTokens).To(Equal(int64(60)))
+			Expect(result.OutputTokens).To(Equal(int64(30)))
+			Expect(result.NumModelRequests).To(Equal(int64(3)))
+
+			resp = accountant.aggregate(usageQuery{start: start, end: now, width: time.Hour, byAPIKey: true}, now)
+			Expect(resp.Data[0].Results).To(HaveLen(2))
+			Expect(resp.Data[0].Results[0].InputTokens).To(Equal(int64(40)))
+			Expect(resp.Data[0].Results[1].InputTokens).To(Equal(int64(20)))
+		})
+
+		It("should keep the usage of the retention period", func() {
+			accountant := newUsageAccountant(10 * time.Minute)
+			accountant.add("model", "", 10, 5, start)
+			later := start.Add(30 * time.Minute)
+			accountant.add("model", "", 20, 10, later)
+
+			resp := accountant.aggregate(usageQuery{start: start, end: later.Add(time.Minute), width: time.Hour}, later)
+			Expect(resp.Data).To(HaveLen(1))
+			Expect(resp.Data[0].Results).To(HaveLen(1))
+			Expect(resp.Data[0].Results[0].InputTokens).To(Equal(int64(20)))
+		})
+	})
+
+	DescribeTable("should reject invalid usage queries",
+		func(query string, expectedError string) {
+			args := fasthttp.Args{}
+			args.Parse(query)
+			_, err := parseUsageQuery(&args, time.Now())
+			Expect(err).To(MatchError(ContainSubstring(expectedError)))
+		},
+		Entry("invalid start time", "start_time=yesterday", "invalid start_time"),
+		Entry("start after end", "start_time=200&end_time=100", "start_time must be before end_time"),
+		Entry("invalid bucket width", "bucket_width=1w", "invalid bucket_width"),
+		Entry("invalid group", "group_by=model,project", "invalid group_by"),
+	)
+
+	It("should report the usage of the requests by model and api key", func() {
+		exportFile := filepath.Join(GinkgoT().TempDir(), "usage.json")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeEcho, "--usage-export-file", exportFile}
+		client, err := startServerWithArgs(ctx, common.ModeEcho, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		post := func(path string, apiKey string, body string) {
+			req, err := http.NewRequest(http.MethodPost, "http://localhost"+path, strings.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			resp, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		}
+		prompt := "Hello world"
+		nTokens := int64(len(common.Tokenize(prompt)))
+		completion := `{"model": "` + model + `", "prompt": "` + prompt + `"}`
+		post("/v1/completions", "key-1", completion)
+		post("/v1/completions", "key-1", `{"model": "`+model+`", "prompt": "`+prompt+`", "stream": true}`)
+		post("/v1/completions", "key-2", completion)
+		post("/v1/embeddings", "key-2", `{"model": "`+model+`", "input": "`+prompt+`"}`)
+
+		getUsage := func(query string) usageResponse {
+			resp, err := client.Get("http://localhost/v1/usage?" + query)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Body.Close()).To(Succeed())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			var usage usageResponse
+			Expect(json.Unmarshal(data, &usage)).To(Succeed())
+			return usage
+		}
+		// sums up the usage of every api key over the buckets
+		sumByAPIKey := func(usage usageResponse) map[string]usageResult {
+			sums := make(map[string]usageResult)
+			for _, bucket := range usage.Data {
+				for _, result := range bucket.Results {
+					Expect(*result.Model).To(Equal(model))
+					sum := sums[*result.APIKey]
+					sum.InputTokens += result.InputTokens
+					sum.OutputTokens += result.OutputTokens
+					sum.NumModelRequests += result.NumModelRequests
+					sums[*result.APIKey] = sum
+				}
+			}
+			return sums
+		}
+
+		sums := sumByAPIKey(getUsage("group_by=model,api_key&bucket_width=1m"))
+		Expect(sums).To(HaveLen(2))
+		Expect(sums["key-1"].InputTokens).To(Equal(2 * nTokens))
+		Expect(sums["key-1"].OutputTokens).To(Equal(2 * nTokens))
+		Expect(sums["key-1"].NumModelRequests).To(Equal(int64(2)))
+		// the embeddings have no output tokens
+		Expect(sums["key-2"].InputTokens).To(Equal(2 * nTokens))
+		Expect(sums["key-2"].OutputTokens).To(Equal(nTokens))
+		Expect(sums["key-2"].NumModelRequests).To(Equal(int64(2)))
+
+		// the usage is exported at shutdown
+		cancel()
+		Eventually(func() map[string]usageResult {
+			data, err := os.ReadFile(exportFile)
+			if err != nil {
+				return nil
+			}
+			var usage usageResponse
+			Expect(json.Unmarshal(data, &usage)).To(Succeed())
+			return sumByAPIKey(usage)
+		}, 2*time.Second, 50*time.Millisecond).Should(Equal(sums))
+	})
+})
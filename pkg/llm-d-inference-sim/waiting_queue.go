@@ -0,0 +1,209 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The queue of the requests waiting for a worker
+package llmdinferencesim
+
+import (
+	"container/heap"
+	"sync"
+
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+)
+
+// waitingRequest is a request in the waiting queue
+type waitingRequest struct {
+	reqCtx   *openaiserverapi.CompletionReqCtx
+	priority int
+	// lora is the LoRA adapter of the request, empty for a base model request
+	lora string
+	// seq is the arrival order of the request
+	seq uint64
+}
+
+// waitingRequestHeap is a min-heap of the waiting requests ordered by their priority
+// and then by their arrival order
+type waitingRequestHeap []*waitingRequest
+
+func (h waitingRequestHeap) Len() int { return len(h) }
+
+func (h waitingRequestHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waitingRequestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *waitingRequestHeap) Push(x any) {
+	*h = append(*h, x.(*waitingRequest))
+}
+
+func (h *waitingRequestHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// waitingQueue is the queue of the requests waiting for a worker, a request with a lower priority
+// value is taken first, requests with the same priority are taken in their arrival order.
+// At most maxLoras LoRA adapters run at the same time, the requests of other adapters
+// wait until a LoRA slot is released
+type waitingQueue struct {
+	mutex    sync.Mutex
+	requests waitingRequestHeap
+	// nextSeq is the arrival order of the next request
+	nextSeq uint64
+	// available has an element for each request in the queue, a worker receives an element
+	// before it takes a request
+	available chan struct{}
+	// maxLoras is the maximum number of LoRA adapters with running requests, 0 means unlimited
+	maxLoras int
+	// runningLoras is the number of running requests of each LoRA adapter with running requests
+	runningLoras map[string]int
+	// runningRequestLoras is the LoRA adapter of each running LoRA request, by request id
+	runningRequestLoras map[string]string
+	// runningSeqs is the arrival order of each running request, by request id
+	runningSeqs map[string]uint64
+	// parked is the number of elements received from available by workers that found no request
+	// they could run, they are returned to available when a LoRA slot is released
+	parked int
+}
+
+// newWaitingQueue creates a waiting queue for up to the given number of requests
+func newWaitingQueue(capacity int) *waitingQueue {
+	return &waitingQueue{
+		available:           make(chan struct{}, capacity),
+		runningLoras:        make(map[string]int),
+		runningRequestLoras: make(map[string]string),
+		runningSeqs:         make(map[string]uint64),
+	}
+}
+
+// enqueue adds the request to the queue with the given priority, lora is the LoRA adapter
+// of the request, empty for a base model request
+func (q *waitingQueue) enqueue(reqCtx *openaiserverapi.CompletionReqCtx, priority int, lora string) {
+	q.mutex.Lock()
+	heap.Push(&q.requests, &waitingRequest{reqCtx: reqCtx, priority: priority, lora: lora, seq: q.nextSeq})
+	q.nextSeq++
+	q.mutex.Unlock()
+	q.available <- struct{}{}
+}
+
+// requestAvailable returns the channel that has an element for each request in the queue,
+// every element received from the channel must be followed by a call to dequeue
+func (q *waitingQueue) requestAvailable() <-chan struct{} {
+	return q.available
+}
+
+// dequeue takes the next request that can run from the queue, returns the request and the number
+// of requests in the queue that arrived before it. A LoRA request can run if its adapter is already
+// running or if a LoRA slot is free, the LoRA slot is taken until finishRequest is called.
+// Returns nil if none of the requests can run
+func (q *waitingQueue) dequeue() (*openaiserverapi.CompletionReqCtx, int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	index := q.nextRunnable()
+	if index < 0 {
+		q.parked++
+		return nil, 0
+	}
+	next := heap.Remove(&q.requests, index).(*waitingRequest)
+	q.runningSeqs[next.reqCtx.CompletionReq.GetRequestID()] = next.seq
+	if next.lora != "" {
+		q.runningLoras[next.lora]++
+		q.runningRequestLoras[next.reqCtx.CompletionReq.GetRequestID()] = next.lora
+	}
+	overtaken := 0
+	for _, req := range q.requests {
+		if req.seq < next.seq {
+			overtaken++
+		}
+	}
+	return next.reqCtx, overtaken
+}
+
+// requeue returns a running request to the queue, the request keeps its arrival order so it runs
+// again before the requests that arrived after it. Its LoRA slot is released like in finishRequest
+func (q *waitingQueue) requeue(reqCtx *openaiserverapi.CompletionReqCtx, priority int, lora string) {
+	requestID := reqCtx.CompletionReq.GetRequestID()
+	q.mutex.Lock()
+	seq, ok := q.runningSeqs[requestID]
+	if !ok {
+		seq = q.nextSeq
+		q.nextSeq++
+	}
+	heap.Push(&q.requests, &waitingRequest{reqCtx: reqCtx, priority: priority, lora: lora, seq: seq})
+	q.mutex.Unlock()
+	q.finishRequest(requestID)
+	q.available <- struct{}{}
+}
+
+// nextRunnable returns the index in the heap of the first request in the queue order that can run,
+// -1 if none of the requests can run
+func (q *waitingQueue) nextRunnable() int {
+	index := -1
+	for i, req := range q.requests {
+		if !q.canRun(req.lora) {
+			continue
+		}
+		if index < 0 || q.requests.Less(i, index) {
+			index = i
+		}
+	}
+	return index
+}
+
+// canRun returns true if a request of the given LoRA adapter can run, base model requests can always run
+func (q *waitingQueue) canRun(lora string) bool {
+	if lora == "" || q.maxLoras == 0 {
+		return true
+	}
+	if _, running := q.runningLoras[lora]; running {
+		return true
+	}
+	return len(q.runningLoras) < q.maxLoras
+}
+
+// finishRequest releases the LoRA slot of the given request when it is the last running request
+// of its adapter, the workers that found no request they could run try again
+func (q *waitingQueue) finishRequest(requestID string) {
+	q.mutex.Lock()
+	delete(q.runningSeqs, requestID)
+	lora, ok := q.runningRequestLoras[requestID]
+	if !ok {
+		q.mutex.Unlock()
+		return
+	}
+	delete(q.runningRequestLoras, requestID)
+	q.runningLoras[lora]--
+	if q.runningLoras[lora] > 0 {
+		q.mutex.Unlock()
+		return
+	}
+	delete(q.runningLoras, lora)
+	parked := q.parked
+	q.parked = 0
+	q.mutex.Unlock()
+
+	for range parked {
+		q.available <- struct{}{}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package llmdinferencesim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+	openaiserverapi "github.com/llm-d/llm-d-inference-sim/pkg/openai-server-api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+var _ = Describe("Waiting queue", func() {
+	newReqCtx := func(requestID string) *openaiserverapi.CompletionReqCtx {
+		return &openaiserverapi.CompletionReqCtx{
+			CompletionReq: &openaiserverapi.TextCompletionRequest{
+				BaseCompletionRequest: openaiserverapi.BaseCompletionRequest{RequestID: requestID},
+			},
+		}
+	}
+
+	It("should take the requests by their priority and then by their arrival order", func() {
+		queue := newWaitingQueue(10)
+		queue.enqueue(newReqCtx("first"), 0, "")
+		queue.enqueue(newReqCtx("low"), 5, "")
+		queue.enqueue(newReqCtx("second"), 0, "")
+		queue.enqueue(newReqCtx("high"), -1, "")
+		queue.enqueue(newReqCtx("third"), 0, "")
+
+		expected := []struct {
+			requestID string
+			overtaken int
+		}{{"high", 3}, {"first", 0}, {"second", 1}, {"third", 1}, {"low", 0}}
+		for _, next := range expected {
+			Eventually(queue.requestAvailable()).Should(Receive())
+			reqCtx, overtaken := queue.dequeue()
+			Expect(reqCtx.CompletionReq.GetRequestID()).To(Equal(next.requestID))
+			Expect(overtaken).To(Equal(next.overtaken))
+		}
+		Consistently(queue.requestAvailable(), 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("should run the requests of at most max loras LoRA adapters at the same time", func() {
+		queue := newWaitingQueue(10)
+		queue.maxLoras = 1
+		queue.enqueue(newReqCtx("lora1-first"), 0, "lora1")
+		queue.enqueue(newReqCtx("lora2"), 0, "lora2")
+		queue.enqueue(newReqCtx("lora1-second"), 0, "lora1")
+		queue.enqueue(newReqCtx("base"), 0, "")
+
+		// lora2 waits for the LoRA slot of lora1, the requests of lora1 and of the base model overtake it
+		for _, requestID := range []string{"lora1-first", "lora1-second", "base"} {
+			Eventually(queue.requestAvailable()).Should(Receive())
+			reqCtx, _ := queue.dequeue()
+			Expect(reqCtx.CompletionReq.GetRequestID()).To(Equal(requestID))
+		}
+		Eventually(queue.requestAvailable()).Should(Receive())
+		reqCtx, _ := queue.dequeue()
+		Expect(reqCtx).To(BeNil())
+
+		// the slot is released when the last running request of lora1 finishes
+		queue.finishRequest("lora1-first")
+		Consistently(queue.requestAvailable(), 50*time.Millisecond).ShouldNot(Receive())
+		queue.finishRequest("lora1-second")
+		Eventually(queue.requestAvailable()).Should(Receive())
+		reqCtx, overtaken := queue.dequeue()
+		Expect(reqCtx.CompletionReq.GetRequestID()).To(Equal("lora2"))
+		Expect(overtaken).To(BeZero())
+	})
+
+	It("should return a preempted request to its place in the queue", func() {
+		queue := newWaitingQueue(10)
+		queue.maxLoras = 1
+		queue.enqueue(newReqCtx("first"), 0, "lora1")
+		queue.enqueue(newReqCtx("second"), 0, "")
+		queue.enqueue(newReqCtx("lora2"), 0, "lora2")
+
+		Eventually(queue.requestAvailable()).Should(Receive())
+		first, _ := queue.dequeue()
+		Expect(first.CompletionReq.GetRequestID()).To(Equal("first"))
+		queue.enqueue(newReqCtx("third"), 0, "")
+
+		// the preempted request releases its LoRA slot and runs before the requests that arrived after it
+		queue.requeue(first, 0, "lora1")
+		for _, requestID := range []string{"first", "second", "third"} {
+			Eventually(queue.requestAvailable()).Should(Receive())
+			reqCtx, _ := queue.dequeue()
+			Expect(reqCtx.CompletionReq.GetRequestID()).To(Equal(requestID))
+		}
+		Eventually(queue.requestAvailable()).Should(Receive())
+		reqCtx, _ := queue.dequeue()
+		Expect(reqCtx).To(BeNil())
+		queue.finishRequest("first")
+		Eventually(queue.requestAvailable()).Should(Receive())
+		reqCtx, _ = queue.dequeue()
+		Expect(reqCtx.CompletionReq.GetRequestID()).To(Equal("lora2"))
+	})
+
+	It("should process the requests with a higher priority first", func() {
+		ctx := context.TODO()
+		args := []string{"cmd", "--model", model, "--mode", common.ModeRandom, "--enable-admin-api",
+			"--scheduling-policy", common.SchedulingPolicyPriority, "--max-num-seqs", "1",
+			"--time-to-first-token", "300"}
+		client, err := startServerWithArgs(ctx, common.ModeRandom, args, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndCompletionParams(client, model, userMessage, false)
+		params.MaxTokens = openai.Int(1)
+		var mutex sync.Mutex
+		finished := make([]int, 0)
+		var wg sync.WaitGroup
+		// the first request occupies the only worker, the others wait in the queue
+		for _, priority := range []int{0, 10, 5, -1} {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := openaiclient.Completions.New(ctx, params, option.WithJSONSet("priority", priority))
+				Expect(err).NotTo(HaveOccurred())
+				mutex.Lock()
+				finished = append(finished, priority)
+				mutex.Unlock()
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		queue := getDebugQueue(client)
+		Expect(queue.Running).To(HaveLen(1))
+		Expect(queue.Waiting).To(HaveLen(3))
+		for i, priority := range []int{-1, 5, 10} {
+			Expect(queue.Waiting[i].Priority).To(Equal(priority))
+		}
+
+		wg.Wait()
+		Expect(finished).To(Equal([]int{0, -1, 5, 10}))
+
+		metricsResp, err := client.Get(metricsUrl)
+		Expect(err).NotTo(HaveOccurred())
+		data, err := io.ReadAll(metricsResp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		// the request with priority -1 overtook two requests and the request with priority 5 overtook one
+		Expect(getGaugeValue(string(data), fmt.Sprintf(`sim_queue_overtakes_total{model="%s"}`, model))).
+			To(Equal(3.0))
+	})
+
+	It("should reject a priority when the priority scheduling is not enabled", func() {
+		ctx := context.TODO()
+		client, err := startServer(ctx, common.ModeRandom)
+		Expect(err).NotTo(HaveOccurred())
+
+		openaiclient, params := getOpenAIClentAndChatParams(client, model, userMessage, false)
+		_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("priority", 1))
+		Expect(err).To(HaveOccurred())
+		var openaiError *openai.Error
+		ok := errors.As(err, &openaiError)
+		Expect(ok).To(BeTrue())
+		Expect(openaiError.StatusCode).To(Equal(400))
+		Expect(openaiError.Message).To(ContainSubstring("Priority scheduling is not enabled"))
+
+		// the default priority is accepted
+		_, err = openaiclient.Chat.Completions.New(ctx, params, option.WithJSONSet("priority", 0))
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openaiserverapi
+
+import "encoding/json"
+
+const (
+	// the statuses of a batch
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// BatchCreateRequest defines the structure of the /v1/batches request
+type BatchCreateRequest struct {
+	// InputFileID is the id of the uploaded JSONL file of the requests of the batch
+	InputFileID string `json:"input_file_id"`
+	// Endpoint is the endpoint of all the requests of the batch
+	Endpoint string `json:"endpoint"`
+	// CompletionWindow is the time frame the batch is processed in, e.g. 24h
+	CompletionWindow string `json:"completion_window"`
+	// Metadata are key-value pairs attached to the batch
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Batch is a batch of requests of the batch API
+type Batch struct {
+	// ID is the id of the batch
+	ID string `json:"id"`
+	// Object is always "batch"
+	Object string `json:"object"`
+	// Endpoint is the endpoint of the requests of the batch
+	Endpoint string `json:"endpoint"`
+	// Errors are the errors of the input file of a failed batch
+	Errors *BatchErrors `json:"errors,omitempty"`
+	// InputFileID is the id of the input file of the batch
+	InputFileID string `json:"input_file_id"`
+	// CompletionWindow is the time frame the batch is processed in
+	CompletionWindow string `json:"completion_window"`
+	// Status is the status of the batch
+	Status string `json:"status"`
+	// OutputFileID is the id of the file of the successful responses
+	OutputFileID string `json:"output_file_id,omitempty"`
+	// ErrorFileID is the id of the file of the failed requests
+	ErrorFileID string `json:"error_file_id,omitempty"`
+	// the times of the changes of the status in seconds since the epoch
+	CreatedAt    int64 `json:"created_at"`
+	InProgressAt int64 `json:"in_progress_at,omitempty"`
+	ExpiresAt    int64 `json:"expires_at"`
+	FinalizingAt int64 `json:"finalizing_at,omitempty"`
+	CompletedAt  int64 `json:"completed_at,omitempty"`
+	FailedAt     int64 `json:"failed_at,omitempty"`
+	ExpiredAt    int64 `json:"expired_at,omitempty"`
+	CancellingAt int64 `json:"cancelling_at,omitempty"`
+	CancelledAt  int64 `json:"cancelled_at,omitempty"`
+	// RequestCounts are the numbers of the requests of the batch
+	RequestCounts BatchRequestCounts `json:"request_counts"`
+	// Metadata are the key-value pairs attached to the batch
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchErrors are the errors of the input file of a batch
+type BatchErrors struct {
+	// Object is always "list"
+	Object string `json:"object"`
+	// Data are the errors
+	Data []BatchError `json:"data"`
+}
+
+// BatchError is an error of a line of the input file of a batch, or of a request of the batch
+type BatchError struct {
+	// Code is the error code
+	Code string `json:"code"`
+	// Message describes the error
+	Message string `json:"message"`
+	// Line is the line number of the error in the input file, zero if the error is not of a line
+	Line int `json:"line,omitempty"`
+}
+
+// BatchRequestCounts are the numbers of the requests of a batch
+type BatchRequestCounts struct {
+	// Total is the number of requests in the batch
+	Total int `json:"total"`
+	// Completed is the number of requests that got a successful response
+	Completed int `json:"completed"`
+	// Failed is the number of requests that failed
+	Failed int `json:"failed"`
+}
+
+// BatchList is the response of the list of the batches
+type BatchList struct {
+	// Object is always "list"
+	Object string `json:"object"`
+	// Data are the batches, the most recent first
+	Data []Batch `json:"data"`
+	// FirstID is the id of the first batch in the list
+	FirstID string `json:"first_id,omitempty"`
+	// LastID is the id of the last batch in the list
+	LastID string `json:"last_id,omitempty"`
+	// HasMore is true if there are more batches after the last one
+	HasMore bool `json:"has_more"`
+}
+
+// BatchInputLine is a request in the input file of a batch
+type BatchInputLine struct {
+	// CustomID identifies the request in the output of the batch, unique in the batch
+	CustomID string `json:"custom_id"`
+	// Method is the HTTP method of the request, always POST
+	Method string `json:"method"`
+	// URL is the endpoint of the request, the endpoint of the batch
+	URL string `json:"url"`
+	// Body is the body of the request
+	Body json.RawMessage `json:"body"`
+}
+
+// BatchOutputLine is the result of a request of a batch in its output or error file
+type BatchOutputLine struct {
+	// ID is the id of the result
+	ID string `json:"id"`
+	// CustomID is the custom id of the request
+	CustomID string `json:"custom_id"`
+	// Response is the response of the request, nil if the request did not run
+	Response *BatchResponse `json:"response"`
+	// Error is the reason the request did not run, nil if it ran
+	Error *BatchError `json:"error"`
+}
+
+// BatchResponse is the response of a request of a batch
+type BatchResponse struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int `json:"status_code"`
+	// RequestID is the id of the request
+	RequestID string `json:"request_id"`
+	// Body is the body of the response
+	Body json.RawMessage `json:"body"`
+}
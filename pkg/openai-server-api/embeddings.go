@@ -0,0 +1,173 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openaiserverapi
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/llm-d/llm-d-inference-sim/pkg/common"
+)
+
+const (
+	// EncodingFormatFloat - the embeddings are returned as arrays of floats
+	EncodingFormatFloat = "float"
+	// EncodingFormatBase64 - the embeddings are returned as base64 encoded little-endian float32 arrays
+	EncodingFormatBase64 = "base64"
+)
+
+// EmbeddingInput is a single input of an embedding request, either a text or an array of token ids
+type EmbeddingInput struct {
+	Text     string
+	TokenIDs []int64
+}
+
+// NumberOfTokens returns the number of tokens of the input
+func (i EmbeddingInput) NumberOfTokens() int {
+	if i.TokenIDs != nil {
+		return len(i.TokenIDs)
+	}
+	return len(common.Tokenize(i.Text))
+}
+
+// EmbeddingRequest defines structure of /embeddings request
+type EmbeddingRequest struct {
+	// Model defines Model name to use for embeddings
+	Model string `json:"model"`
+	// Inputs are the inputs to embed, set from the input field which is a string, an array of strings,
+	// an array of token ids or an array of arrays of token ids
+	Inputs []EmbeddingInput `json:"-"`
+	// EncodingFormat is the format of the returned embeddings, float or base64
+	EncodingFormat string `json:"encoding_format,omitempty"`
+	// Dimensions is the number of dimensions of the returned embeddings, the simulator's
+	// embedding dimension is used if not set
+	Dimensions *int `json:"dimensions,omitempty"`
+	// User is a unique identifier representing the end-user
+	User string `json:"user,omitempty"`
+}
+
+// UnmarshalJSON accepts the input as a string, an array of strings, an array of token ids,
+// or an array of arrays of token ids
+func (r *EmbeddingRequest) UnmarshalJSON(data []byte) error {
+	// embeddingRequest has the fields of EmbeddingRequest without its methods
+	type embeddingRequest EmbeddingRequest
+	aux := struct {
+		*embeddingRequest
+		Input json.RawMessage `json:"input"`
+	}{embeddingRequest: (*embeddingRequest)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.Inputs = nil
+	if len(aux.Input) == 0 || string(aux.Input) == "null" {
+		return errors.New("input is required")
+	}
+	var text string
+	if err := json.Unmarshal(aux.Input, &text); err == nil {
+		r.Inputs = []EmbeddingInput{{Text: text}}
+		return nil
+	}
+	var texts []string
+	if err := json.Unmarshal(aux.Input, &texts); err == nil {
+		for _, text := range texts {
+			r.Inputs = append(r.Inputs, EmbeddingInput{Text: text})
+		}
+	} else {
+		var tokenIDs []int64
+		var batch [][]int64
+		if err := json.Unmarshal(aux.Input, &tokenIDs); err == nil {
+			batch = [][]int64{tokenIDs}
+		} else if err := json.Unmarshal(aux.Input, &batch); err != nil {
+			return errors.New("input must be a string, an array of strings, an array of token ids " +
+				"or an array of arrays of token ids")
+		}
+		for _, ids := range batch {
+			if len(ids) == 0 {
+				return errors.New("input token ids cannot be empty")
+			}
+			for _, id := range ids {
+				if id < 0 {
+					return errors.New("input token ids cannot be negative")
+				}
+			}
+			r.Inputs = append(r.Inputs, EmbeddingInput{TokenIDs: ids})
+		}
+	}
+	if len(r.Inputs) == 0 {
+		return errors.New("input cannot be an empty array")
+	}
+	return nil
+}
+
+// ToTextCompletionRequest returns the text completion request that is scheduled for the embeddings,
+// its prompts are the inputs, a batch of prompts if there are several inputs
+func (r *EmbeddingRequest) ToTextCompletionRequest() *TextCompletionRequest {
+	req := &TextCompletionRequest{BaseCompletionRequest: BaseCompletionRequest{Model: r.Model}}
+	for _, input := range r.Inputs {
+		if input.TokenIDs != nil {
+			req.PromptTokenIDs = append(req.PromptTokenIDs, input.TokenIDs)
+		} else {
+			req.PromptBatch = append(req.PromptBatch, input.Text)
+		}
+	}
+	if req.PromptTokenIDs != nil {
+		req.Prompt = strings.Join(req.GetPromptTokens(), "")
+		return req
+	}
+	req.Prompt = strings.Join(req.PromptBatch, "")
+	if len(req.PromptBatch) == 1 {
+		req.PromptBatch = nil
+	}
+	return req
+}
+
+// EmbeddingUsage contains the token statistics of an embedding request
+type EmbeddingUsage struct {
+	// PromptTokens is the number of tokens in the inputs
+	PromptTokens int `json:"prompt_tokens"`
+	// TotalTokens is the total number of tokens processed for the request, equals to PromptTokens
+	TotalTokens int `json:"total_tokens"`
+}
+
+// EmbeddingData is the embedding of one input
+type EmbeddingData struct {
+	// Object is the object type, "embedding"
+	Object string `json:"object"`
+	// Index is the index of the input in the request
+	Index int `json:"index"`
+	// Embedding is the embedding vector, an array of floats or a base64 encoded string
+	// according to the requested encoding format
+	Embedding any `json:"embedding"`
+}
+
+// EmbeddingResponse defines structure of /embeddings response
+type EmbeddingResponse struct {
+	// ID defines the response ID
+	ID string `json:"id"`
+	// Object is the object type, "list"
+	Object string `json:"object"`
+	// Created defines the response creation timestamp
+	Created int64 `json:"created"`
+	// Model defines the Model name for current request
+	Model string `json:"model"`
+	// Data contains the embeddings, in the order of the inputs
+	Data []EmbeddingData `json:"data"`
+	// Usage contains the token usage statistics for the request
+	Usage EmbeddingUsage `json:"usage"`
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openaiserverapi
+
+const (
+	// the purposes of the uploaded files
+	FilePurposeAssistants = "assistants"
+	FilePurposeBatch      = "batch"
+	FilePurposeFineTune   = "fine-tune"
+	FilePurposeVision     = "vision"
+	FilePurposeUserData   = "user_data"
+	FilePurposeEvals      = "evals"
+	// FilePurposeBatchOutput is the purpose of the output and error files of the batches, the files
+	// of this purpose cannot be uploaded
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// UploadFilePurposes are the purposes of the files that can be uploaded
+var UploadFilePurposes = []string{FilePurposeAssistants, FilePurposeBatch, FilePurposeFineTune, FilePurposeVision,
+	FilePurposeUserData, FilePurposeEvals}
+
+// File is an uploaded file of the files API
+type File struct {
+	// ID is the id of the file
+	ID string `json:"id"`
+	// Object is always "file"
+	Object string `json:"object"`
+	// Bytes is the size of the file
+	Bytes int `json:"bytes"`
+	// CreatedAt is the time the file was created in seconds since the epoch
+	CreatedAt int64 `json:"created_at"`
+	// Filename is the name of the file
+	Filename string `json:"filename"`
+	// Purpose is the purpose of the file, batch for the input files of the batches
+	Purpose string `json:"purpose"`
+	// Status is always "processed", the files are available as soon as they are uploaded
+	Status string `json:"status"`
+}
+
+// FileList is the response of the list of the files
+type FileList struct {
+	// Object is always "list"
+	Object string `json:"object"`
+	// Data are the files in the requested order
+	Data []File `json:"data"`
+	// FirstID is the id of the first file in the list
+	FirstID string `json:"first_id,omitempty"`
+	// LastID is the id of the last file in the list
+	LastID string `json:"last_id,omitempty"`
+	// HasMore is true if there are more files after the last one
+	HasMore bool `json:"has_more"`
+}
+
+// DeletedFile is the response of a file deletion
+type DeletedFile struct {
+	// ID is the id of the deleted file
+	ID string `json:"id"`
+	// Object is always "file"
+	Object string `json:"object"`
+	// Deleted is true if the file was deleted
+	Deleted bool `json:"deleted"`
+}
@@ -18,7 +18,13 @@ limitations under the License.
 package openaiserverapi
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	"github.com/valyala/fasthttp"
@@ -27,8 +33,26 @@ import (
 const (
 	RoleAssistant = "assistant"
 	RoleUser      = "user"
+	RoleSystem    = "system"
 )
 
+// the markers of a message in the rendered chat prompt
+const (
+	chatTemplateMessageStart = "<|im_start|>"
+	chatTemplateMessageEnd   = "<|im_end|>"
+)
+
+const (
+	ServiceTierAuto    = "auto"
+	ServiceTierDefault = "default"
+	ServiceTierFlex    = "flex"
+)
+
+// IsValidServiceTier returns true if the given service tier is supported
+func IsValidServiceTier(tier string) bool {
+	return tier == ServiceTierAuto || tier == ServiceTierDefault || tier == ServiceTierFlex
+}
+
 // CompletionRequest interface representing both completion request types (text and chat)
 type CompletionRequest interface {
 	// GetRequestID returns the unique request id
@@ -49,14 +73,27 @@ type CompletionRequest interface {
 	SetNumberOfCachedPromptTokens(cachedPromptTokens int)
 	// GetPrompt returns the prompt
 	GetPrompt() string
+	// GetRenderedPrompt returns the prompt as it is passed to the model, the messages of a chat
+	// completion are rendered by the chat template
+	GetRenderedPrompt() string
 	// GetTools() returns tools to use (in chat completion)
 	GetTools() []Tool
 	// GetToolChoice() returns tool choice (in chat completion)
 	GetToolChoice() string
+	// GetToolChoiceFunctionName() returns the name of the function named in tool choice,
+	// empty if tool choice doesn't name a function (in chat completion)
+	GetToolChoiceFunctionName() string
+	// IsParallelToolCalls returns true if several tools may be called in a response, false if at most
+	// one tool may be called (in chat completion)
+	IsParallelToolCalls() bool
+	// GetResponseFormat returns the format of the response, nil if not set (in chat completion)
+	GetResponseFormat() *ResponseFormat
 	// GetMaxCompletionTokens returns the maximum completion tokens requested
 	GetMaxCompletionTokens() *int64
 	// GetIgnoreEOS returns true if the end-of-sequence tokens will be ignored
 	GetIgnoreEOS() bool
+	// GetMinTokens returns the minimal number of tokens to generate before the generation can stop
+	GetMinTokens() int64
 	// IsDoRemoteDecode() returns true if do_remote_decode field is true in the request,
 	// when the field is true, the decode phase should be done on remote pod,
 	// whereas prefill phase is done on local pod, thus this is a prefill request
@@ -65,8 +102,56 @@ type CompletionRequest interface {
 	// when the field is true, the prefill phase should be done on remote pod,
 	// whereas decode phase is done on local pod, thus this is a decode request
 	IsDoRemotePrefill() bool
+	// GetKVTransferParams returns the parameters of the transfer of the request's kv cache
+	// between the prefill and the decode pods
+	GetKVTransferParams() KVTransferParams
+	// SetKVTransferParams sets the parameters of the transfer of the request's kv cache
+	SetKVTransferParams(params KVTransferParams)
 	// GetFullPrompt returns the full prompt including system and user prompts
 	GetFullPrompt() string
+	// SetVisibleContextTokens sets the number of trailing prompt tokens the model "sees",
+	// 0 means the whole prompt is visible
+	SetVisibleContextTokens(visibleContextTokens int)
+	// GetVisibleContextTokens returns the number of trailing prompt tokens the model "sees"
+	GetVisibleContextTokens() int
+	// GetNumberOfTruncatedPromptTokens returns the number of prompt tokens dropped because
+	// they are outside of the visible context window
+	GetNumberOfTruncatedPromptTokens() int
+	// GetChatTemplateKwargs returns the additional arguments of the chat template (in chat completion)
+	GetChatTemplateKwargs() map[string]any
+	// GetRetainKVDuration returns the period the kv cache blocks of the request are protected
+	// from eviction after the request ends
+	GetRetainKVDuration() time.Duration
+	// SetPromptTokensDelta sets the number of tokens the chat template arguments add to the prompt,
+	// negative values remove tokens (in chat completion)
+	SetPromptTokensDelta(promptTokensDelta int)
+	// SetImageTokenCount sets the number of prompt tokens of an image (in chat completion)
+	SetImageTokenCount(imageTokenCount int)
+	// GetNumberOfImages returns the number of images in the messages (in chat completion)
+	GetNumberOfImages() int
+	// GetServiceTier returns the requested service tier, empty if not set (in chat completion)
+	GetServiceTier() string
+	// GetN returns the number of choices to generate, 1 if not set
+	GetN() int
+	// GetBestOf returns the number of candidates to generate for every prompt, the n best of them are
+	// returned, n if not set (in text completion)
+	GetBestOf() int
+	// GetStop returns the stop sequences, the generation stops before any of them is produced
+	GetStop() []string
+	// GetPriority returns the scheduling priority of the request, a lower value is processed first
+	GetPriority() int
+	// GetPromptRequests returns a request for every prompt of a batch, in the order of the prompts,
+	// a request with a single prompt returns itself
+	GetPromptRequests() []CompletionRequest
+	// GetSeed returns the seed of the random generation of the response, nil if not set
+	GetSeed() *int64
+	// SetUseSamplingParams sets whether the sampling parameters influence the generated response
+	SetUseSamplingParams(useSamplingParams bool)
+	// GetSamplingParams returns the sampling parameters of the request, nil if they do not influence
+	// the generated response
+	GetSamplingParams() *SamplingParams
+	// GetRawSamplingParams returns the sampling parameters of the request as sent by the client
+	GetRawSamplingParams() *SamplingParams
 }
 
 // BaseCompletionRequest contains base completion request related information
@@ -95,6 +180,114 @@ type BaseCompletionRequest struct {
 	cachedPromptTokens int
 	// IgnoreEOS is a boolean value, true when the model should ignore end-of-sequence tokens
 	IgnoreEOS bool `json:"ignore_eos"`
+	// MinTokens is the minimal number of tokens to generate before the generation can stop, default is 0
+	MinTokens int64 `json:"min_tokens"`
+	// RetainKVSeconds is the number of seconds the kv cache blocks of the request are protected
+	// from eviction after the request ends, a simulator specific field
+	RetainKVSeconds float64 `json:"x_sim_retain_kv_seconds"`
+	// N is the number of choices to generate for the request, 1 if not set
+	N *int `json:"n"`
+	// Stop contains the sequences that stop the generation, the returned text doesn't contain the stop sequence
+	Stop StopSequences `json:"stop"`
+	// Priority is the scheduling priority of the request when the priority scheduling policy is used,
+	// a lower value is processed first, default is 0
+	Priority int `json:"priority"`
+	// Seed is the seed of the random generation of the response, identical requests with the same seed
+	// get identical responses
+	Seed *int64 `json:"seed"`
+	// SamplingParams are the sampling parameters of the request
+	SamplingParams
+	// useSamplingParams is true if the sampling parameters influence the generated response
+	useSamplingParams bool
+	// The number of trailing prompt tokens that are visible to the model, 0 means no limit
+	visibleContextTokens int
+	// The number of tokens added to the prompt by the chat template arguments
+	promptTokensDelta int
+	// The number of prompt tokens of an image
+	imageTokenCount int
+}
+
+// StopSequences are the stop sequences of a request, sent as a string or an array of strings
+type StopSequences []string
+
+// SamplingParams are the sampling parameters of a completion request, nil fields are not set
+type SamplingParams struct {
+	// Temperature scales the randomness of the generation, 0 means greedy generation
+	Temperature *float64 `json:"temperature"`
+	// TopP limits the generation to the most probable tokens with this cumulative probability
+	TopP *float64 `json:"top_p"`
+	// PresencePenalty penalizes tokens that already appeared in the generated text
+	PresencePenalty *float64 `json:"presence_penalty"`
+	// FrequencyPenalty penalizes tokens by the number of times they appeared in the generated text
+	FrequencyPenalty *float64 `json:"frequency_penalty"`
+	// LogitBias maps tokens to biases between -100 and 100 that are added to their logits,
+	// the keys are compared with the text of the generated tokens
+	LogitBias map[string]float64 `json:"logit_bias"`
+}
+
+// GetTemperature returns the temperature, 1 if not set
+func (p *SamplingParams) GetTemperature() float64 {
+	if p.Temperature == nil {
+		return 1
+	}
+	return *p.Temperature
+}
+
+// GetTopP returns top_p, 1 if not set
+func (p *SamplingParams) GetTopP() float64 {
+	if p.TopP == nil {
+		return 1
+	}
+	return *p.TopP
+}
+
+// GetPenalty returns the sum of the presence and the frequency penalties, 0 if not set
+func (p *SamplingParams) GetPenalty() float64 {
+	penalty := 0.0
+	if p.PresencePenalty != nil {
+		penalty += *p.PresencePenalty
+	}
+	if p.FrequencyPenalty != nil {
+		penalty += *p.FrequencyPenalty
+	}
+	return penalty
+}
+
+// Validate returns an error message if one of the parameters is out of its range, empty if they are valid
+func (p *SamplingParams) Validate() string {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return "temperature must be between 0 and 2"
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return "top_p must be in (0, 1]"
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2) {
+		return "presence_penalty must be between -2 and 2"
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2) {
+		return "frequency_penalty must be between -2 and 2"
+	}
+	for token, bias := range p.LogitBias {
+		if bias < -100 || bias > 100 {
+			return fmt.Sprintf("logit_bias of token '%s' must be between -100 and 100", token)
+		}
+	}
+	return ""
+}
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var sequence string
+	if err := json.Unmarshal(data, &sequence); err == nil {
+		*s = StopSequences{sequence}
+		return nil
+	}
+
+	var sequences []string
+	if err := json.Unmarshal(data, &sequences); err != nil {
+		return errors.New("stop must be a string or an array of strings")
+	}
+	*s = sequences
+	return nil
 }
 
 // StreamOptions defines streaming options for streaming requests
@@ -127,6 +320,26 @@ func (b *BaseCompletionRequest) IsDoRemotePrefill() bool {
 	return b.DoRemotePrefill
 }
 
+func (b *BaseCompletionRequest) GetKVTransferParams() KVTransferParams {
+	return KVTransferParams{
+		DoRemoteDecode:  b.DoRemoteDecode,
+		DoRemotePrefill: b.DoRemotePrefill,
+		RemoteBlockIds:  b.RemoteBlockIds,
+		RemoteEngineId:  b.RemoteEngineId,
+		RemoteHost:      b.RemoteHost,
+		RemotePort:      b.RemotePort,
+	}
+}
+
+func (b *BaseCompletionRequest) SetKVTransferParams(params KVTransferParams) {
+	b.DoRemoteDecode = params.DoRemoteDecode
+	b.DoRemotePrefill = params.DoRemotePrefill
+	b.RemoteBlockIds = params.RemoteBlockIds
+	b.RemoteEngineId = params.RemoteEngineId
+	b.RemoteHost = params.RemoteHost
+	b.RemotePort = params.RemotePort
+}
+
 // GetNumberOfCachedPromptTokens returns the number of tokens in the prompt that are
 // in the local KV Cache
 func (b *BaseCompletionRequest) GetNumberOfCachedPromptTokens() int {
@@ -138,12 +351,90 @@ func (b *BaseCompletionRequest) GetIgnoreEOS() bool {
 	return b.IgnoreEOS
 }
 
+// GetMinTokens returns the value of MinTokens
+func (b *BaseCompletionRequest) GetMinTokens() int64 {
+	return b.MinTokens
+}
+
+// GetRetainKVDuration returns the period the kv cache blocks of the request are protected
+// from eviction after the request ends
+func (b *BaseCompletionRequest) GetRetainKVDuration() time.Duration {
+	return time.Duration(b.RetainKVSeconds * float64(time.Second))
+}
+
+// GetN returns the number of choices to generate, 1 if not set
+func (b *BaseCompletionRequest) GetN() int {
+	if b.N == nil {
+		return 1
+	}
+	return *b.N
+}
+
+// GetStop returns the stop sequences of the request
+func (b *BaseCompletionRequest) GetStop() []string {
+	return b.Stop
+}
+
+// GetPriority returns the scheduling priority of the request
+func (b *BaseCompletionRequest) GetPriority() int {
+	return b.Priority
+}
+
+// GetSeed returns the seed of the random generation of the response, nil if not set
+func (b *BaseCompletionRequest) GetSeed() *int64 {
+	return b.Seed
+}
+
 // SetNumberOfCachedPromptTokens sets the number of tokens in the prompt that are
 // in the local KV Cache
+func (b *BaseCompletionRequest) SetUseSamplingParams(useSamplingParams bool) {
+	b.useSamplingParams = useSamplingParams
+}
+
+func (b *BaseCompletionRequest) GetSamplingParams() *SamplingParams {
+	if !b.useSamplingParams {
+		return nil
+	}
+	return &b.SamplingParams
+}
+
+func (b *BaseCompletionRequest) GetRawSamplingParams() *SamplingParams {
+	return &b.SamplingParams
+}
+
 func (b *BaseCompletionRequest) SetNumberOfCachedPromptTokens(cachedPromptTokens int) {
 	b.cachedPromptTokens = cachedPromptTokens
 }
 
+// SetVisibleContextTokens sets the number of trailing prompt tokens the model "sees"
+func (b *BaseCompletionRequest) SetVisibleContextTokens(visibleContextTokens int) {
+	b.visibleContextTokens = visibleContextTokens
+}
+
+// GetVisibleContextTokens returns the number of trailing prompt tokens the model "sees"
+func (b *BaseCompletionRequest) GetVisibleContextTokens() int {
+	return b.visibleContextTokens
+}
+
+// SetPromptTokensDelta sets the number of tokens the chat template arguments add to the prompt
+func (b *BaseCompletionRequest) SetPromptTokensDelta(promptTokensDelta int) {
+	b.promptTokensDelta = promptTokensDelta
+}
+
+// SetImageTokenCount sets the number of prompt tokens of an image
+func (b *BaseCompletionRequest) SetImageTokenCount(imageTokenCount int) {
+	b.imageTokenCount = imageTokenCount
+}
+
+// visiblePromptTokens returns the number of tokens in the visible part of a prompt
+// with the given number of tokens
+func (b *BaseCompletionRequest) visiblePromptTokens(rawPromptTokens int) int {
+	if b.visibleContextTokens > 0 && rawPromptTokens > b.visibleContextTokens {
+		return b.visibleContextTokens
+	}
+	return rawPromptTokens
+}
+
 // CompletionReqCtx is a context passed in the simulator's flow, it contains the request data needed
 // to generate the simulator's response
 type CompletionReqCtx struct {
@@ -151,6 +442,29 @@ type CompletionReqCtx struct {
 	HTTPReqCtx       *fasthttp.RequestCtx
 	IsChatCompletion bool
 	Wg               *sync.WaitGroup
+	// OmitDoneSentinel is true when a streaming response should end without the [DONE] sentinel
+	OmitDoneSentinel bool
+	// ContentTypeFailure is the injected failure of the Content-Type header of a non-streaming response,
+	// empty if none
+	ContentTypeFailure string
+	// StreamFailure is the failure injected in the middle of a streaming response, empty if none
+	StreamFailure string
+	// IsRefusal is true when the chat completion is refused, the response tokens are sent as the refusal
+	IsRefusal bool
+	// ServiceTier is the service tier the request is processed in, empty if the request
+	// didn't ask for a service tier
+	ServiceTier string
+	// ResponsesReq is the responses API request the completion request was created from,
+	// nil if the request was sent to a completions API
+	ResponsesReq *ResponsesRequest
+	// EmbeddingReq is the embeddings API request the completion request was created from,
+	// nil if the request was sent to a completions API
+	EmbeddingReq *EmbeddingRequest
+	// Disconnected is closed when the client closes the connection before the response is sent,
+	// nil if the connection is not watched
+	Disconnected <-chan struct{}
+	// Preempted is true when the request was preempted and returned to the waiting queue
+	Preempted bool
 }
 
 // ChatCompletionRequest defines structure of /chat/completion request
@@ -175,9 +489,84 @@ type ChatCompletionRequest struct {
 	Tools []Tool `json:"tools,omitempty"`
 
 	// ToolChoice controls which (if any) tool is called by the model,
-	// possible values: none, auto, required.
-	// Sending an object with a specific tool, is currently not supported.
-	ToolChoice string `json:"tool_choice,omitempty"`
+	// possible values: none, auto, required, or an object naming a specific function.
+	ToolChoice ToolChoice `json:"tool_choice,omitzero"`
+
+	// ParallelToolCalls defines whether several tools may be called in a response, true if not set
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+
+	// ChatTemplateKwargs are additional arguments passed to the chat template renderer,
+	// e.g. {"enable_thinking": false}
+	ChatTemplateKwargs map[string]any `json:"chat_template_kwargs,omitempty"`
+
+	// ServiceTier is the requested processing tier, possible values: auto, default, or flex
+	ServiceTier string `json:"service_tier,omitempty"`
+
+	// ResponseFormat is the format the response has to follow, the response is text if not set
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat defines the format of the response of a chat completion
+type ResponseFormat struct {
+	// Type is text, json_object or json_schema
+	Type string `json:"type"`
+	// JSONSchema is the schema the response has to follow, required if the type is json_schema
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is a named JSON schema of a structured response
+type JSONSchema struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Schema is the JSON schema of the response, it supports the same types as the parameters of a tool
+	Schema map[string]any `json:"schema"`
+	Strict *bool          `json:"strict,omitempty"`
+}
+
+// ToolChoice defines which (if any) tool is called by the model
+type ToolChoice struct {
+	// Mode is none, auto or required, in case a specific function is named the mode is required
+	Mode string
+	// FunctionName is the name of the function the model has to call, empty if no function is named
+	FunctionName string
+}
+
+// namedToolChoice is the object form of tool choice
+type namedToolChoice struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+func (t *ToolChoice) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*t = ToolChoice{Mode: mode}
+		return nil
+	}
+
+	var named namedToolChoice
+	if err := json.Unmarshal(data, &named); err != nil {
+		return errors.New("tool_choice must be a string or an object naming a function")
+	}
+	*t = ToolChoice{Mode: ToolChoiceRequired, FunctionName: named.Function.Name}
+	return nil
+}
+
+func (t ToolChoice) MarshalJSON() ([]byte, error) {
+	if t.FunctionName == "" {
+		return json.Marshal(t.Mode)
+	}
+	named := namedToolChoice{Type: "function"}
+	named.Function.Name = t.FunctionName
+	return json.Marshal(named)
 }
 
 // function defines a tool
@@ -207,8 +596,53 @@ func (c *ChatCompletionRequest) GetPrompt() string {
 	return messages
 }
 
+// GetRenderedPrompt returns the messages rendered by a ChatML like chat template followed by the
+// assistant generation prompt, a conversation that continues a previous one shares its prefix
+func (c *ChatCompletionRequest) GetRenderedPrompt() string {
+	var prompt strings.Builder
+	for _, message := range c.Messages {
+		prompt.WriteString(chatTemplateMessageStart + message.Role + "\n")
+		prompt.WriteString(message.Content.PlainText())
+		prompt.WriteString(chatTemplateMessageEnd + "\n")
+	}
+	prompt.WriteString(chatTemplateMessageStart + RoleAssistant + "\n")
+	return prompt.String()
+}
+
 func (c *ChatCompletionRequest) GetNumberOfPromptTokens() int {
-	return len(common.Tokenize(c.GetPrompt()))
+	return c.visiblePromptTokens(c.getNumberOfRenderedPromptTokens())
+}
+
+func (c *ChatCompletionRequest) GetNumberOfTruncatedPromptTokens() int {
+	rawPromptTokens := c.getNumberOfRenderedPromptTokens()
+	return rawPromptTokens - c.visiblePromptTokens(rawPromptTokens)
+}
+
+// getNumberOfRenderedPromptTokens returns the number of tokens in the prompt including
+// the tokens added by the chat template arguments and the tokens of the images
+func (c *ChatCompletionRequest) getNumberOfRenderedPromptTokens() int {
+	return max(len(common.Tokenize(c.GetPrompt()))+c.promptTokensDelta, 0) + c.GetNumberOfImages()*c.imageTokenCount
+}
+
+// GetNumberOfImages returns the number of image_url content parts in the messages
+func (c *ChatCompletionRequest) GetNumberOfImages() int {
+	nImages := 0
+	for _, message := range c.Messages {
+		nImages += message.Content.NumberOfImages()
+	}
+	return nImages
+}
+
+func (c *ChatCompletionRequest) GetChatTemplateKwargs() map[string]any {
+	return c.ChatTemplateKwargs
+}
+
+func (c *ChatCompletionRequest) GetServiceTier() string {
+	return c.ServiceTier
+}
+
+func (c *ChatCompletionRequest) GetBestOf() int {
+	return c.GetN()
 }
 
 func (c *ChatCompletionRequest) GetTools() []Tool {
@@ -216,7 +650,23 @@ func (c *ChatCompletionRequest) GetTools() []Tool {
 }
 
 func (c *ChatCompletionRequest) GetToolChoice() string {
-	return c.ToolChoice
+	return c.ToolChoice.Mode
+}
+
+func (c *ChatCompletionRequest) GetToolChoiceFunctionName() string {
+	return c.ToolChoice.FunctionName
+}
+
+func (c *ChatCompletionRequest) IsParallelToolCalls() bool {
+	return c.ParallelToolCalls == nil || *c.ParallelToolCalls
+}
+
+func (c *ChatCompletionRequest) GetResponseFormat() *ResponseFormat {
+	return c.ResponseFormat
+}
+
+func (c *ChatCompletionRequest) GetPromptRequests() []CompletionRequest {
+	return []CompletionRequest{c}
 }
 
 func (c *ChatCompletionRequest) GetMaxCompletionTokens() *int64 {
@@ -257,8 +707,17 @@ func (req *ChatCompletionRequest) GetFullPrompt() string {
 // TextCompletionRequest defines structure of /completion request
 type TextCompletionRequest struct {
 	BaseCompletionRequest
-	// Prompt defines request's content
+	// Prompt defines request's content, when the prompt is sent as token ids it contains
+	// a placeholder token for each id
 	Prompt string `json:"prompt"`
+	// PromptTokenIDs are the token ids of the prompt when it is sent as an array of token ids,
+	// one array for each prompt of a batch, nil when the prompt is sent as a string
+	PromptTokenIDs [][]int64 `json:"-"`
+	// PromptBatch are the prompts of a batch sent as an array of strings, nil when a single prompt is sent
+	PromptBatch []string `json:"-"`
+	// Suffix is the text that comes after the completion in a fill-in-the-middle (FIM) request, its tokens
+	// are counted as prompt tokens
+	Suffix string `json:"suffix"`
 
 	// The maximum number of [tokens](/tokenizer) that can be generated in the
 	// completion.
@@ -266,14 +725,161 @@ type TextCompletionRequest struct {
 	// The token count of your prompt plus `max_tokens` cannot exceed the model's
 	// context length.
 	MaxTokens *int64 `json:"max_tokens"`
+	// BestOf is the number of candidates to generate for every prompt, the n best of them are returned,
+	// the tokens of all the candidates are counted in the usage, n if not set
+	BestOf *int `json:"best_of"`
+}
+
+// UnmarshalJSON accepts the prompt as a string, an array of token ids, or a batch of strings or of arrays
+// of token ids. The choices of every prompt of a batch are generated separately.
+func (t *TextCompletionRequest) UnmarshalJSON(data []byte) error {
+	// textCompletionRequest has the fields of TextCompletionRequest without its methods
+	type textCompletionRequest TextCompletionRequest
+	aux := struct {
+		*textCompletionRequest
+		Prompt json.RawMessage `json:"prompt"`
+	}{textCompletionRequest: (*textCompletionRequest)(t)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	t.Prompt = ""
+	t.PromptTokenIDs = nil
+	t.PromptBatch = nil
+	if len(aux.Prompt) == 0 || string(aux.Prompt) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(aux.Prompt, &t.Prompt); err == nil {
+		return nil
+	}
+
+	var prompts []string
+	if err := json.Unmarshal(aux.Prompt, &prompts); err == nil && len(prompts) > 0 {
+		if len(prompts) > 1 {
+			t.PromptBatch = prompts
+		}
+		t.Prompt = strings.Join(prompts, "")
+		return nil
+	}
+
+	var tokenIDs []int64
+	if err := json.Unmarshal(aux.Prompt, &tokenIDs); err == nil {
+		t.PromptTokenIDs = [][]int64{tokenIDs}
+	} else if err := json.Unmarshal(aux.Prompt, &t.PromptTokenIDs); err != nil {
+		return errors.New("prompt must be a string, an array of strings, an array of token ids or an array of arrays of token ids")
+	}
+	if len(t.PromptTokenIDs) == 0 {
+		return errors.New("prompt cannot be an empty array")
+	}
+	for _, ids := range t.PromptTokenIDs {
+		if len(ids) == 0 {
+			return errors.New("prompt token ids cannot be empty")
+		}
+		for _, id := range ids {
+			if id < 0 {
+				return errors.New("prompt token ids cannot be negative")
+			}
+		}
+	}
+	t.Prompt = strings.Join(t.GetPromptTokens(), "")
+	return nil
 }
 
 func (t *TextCompletionRequest) GetPrompt() string {
 	return t.Prompt
 }
 
+// GetRenderedPrompt returns the prompt followed by the suffix of a fill-in-the-middle request
+func (t *TextCompletionRequest) GetRenderedPrompt() string {
+	return t.Prompt + t.Suffix
+}
+
+// GetNumberOfImages returns 0, a text completion prompt contains no images
+func (t *TextCompletionRequest) GetNumberOfImages() int {
+	return 0
+}
+
+// GetPromptTokens returns the tokens of the prompt, a placeholder token "<id>" for each token id
+// when the prompt is sent as token ids
+func (t *TextCompletionRequest) GetPromptTokens() []string {
+	if t.PromptBatch != nil {
+		tokens := make([]string, 0)
+		for _, prompt := range t.PromptBatch {
+			tokens = append(tokens, common.Tokenize(prompt)...)
+		}
+		return tokens
+	}
+	if t.PromptTokenIDs == nil {
+		return common.Tokenize(t.Prompt)
+	}
+	tokens := make([]string, 0)
+	for _, ids := range t.PromptTokenIDs {
+		for _, id := range ids {
+			tokens = append(tokens, "<"+strconv.FormatInt(id, 10)+">")
+		}
+	}
+	return tokens
+}
+
+// GetPromptRequests returns a request for every prompt of a batch, each request is a copy of this
+// request with a single prompt
+func (t *TextCompletionRequest) GetPromptRequests() []CompletionRequest {
+	switch {
+	case len(t.PromptBatch) > 1:
+		reqs := make([]CompletionRequest, 0, len(t.PromptBatch))
+		for _, prompt := range t.PromptBatch {
+			req := *t
+			req.PromptBatch = nil
+			req.Prompt = prompt
+			reqs = append(reqs, &req)
+		}
+		return reqs
+	case len(t.PromptTokenIDs) > 1:
+		reqs := make([]CompletionRequest, 0, len(t.PromptTokenIDs))
+		for _, ids := range t.PromptTokenIDs {
+			req := *t
+			req.PromptTokenIDs = [][]int64{ids}
+			req.Prompt = strings.Join(req.GetPromptTokens(), "")
+			reqs = append(reqs, &req)
+		}
+		return reqs
+	default:
+		return []CompletionRequest{t}
+	}
+}
+
+// GetNumberOfPromptTokens returns the number of tokens in the prompt, the tokens of the prompts
+// of a batch are counted separately and summed
 func (t *TextCompletionRequest) GetNumberOfPromptTokens() int {
-	return len(common.Tokenize(t.GetPrompt()))
+	if promptReqs := t.GetPromptRequests(); len(promptReqs) > 1 {
+		return sumPromptRequests(promptReqs, CompletionRequest.GetNumberOfPromptTokens)
+	}
+	return t.visiblePromptTokens(len(t.GetPromptTokens()) + len(t.GetSuffixTokens()))
+}
+
+func (t *TextCompletionRequest) GetNumberOfTruncatedPromptTokens() int {
+	if promptReqs := t.GetPromptRequests(); len(promptReqs) > 1 {
+		return sumPromptRequests(promptReqs, CompletionRequest.GetNumberOfTruncatedPromptTokens)
+	}
+	rawPromptTokens := len(t.GetPromptTokens()) + len(t.GetSuffixTokens())
+	return rawPromptTokens - t.visiblePromptTokens(rawPromptTokens)
+}
+
+// GetSuffixTokens returns the tokens of the suffix of a fill-in-the-middle request, nil if the request has no suffix
+func (t *TextCompletionRequest) GetSuffixTokens() []string {
+	if t.Suffix == "" {
+		return nil
+	}
+	return common.Tokenize(t.Suffix)
+}
+
+// sumPromptRequests returns the sum of the given count over the requests of the prompts of a batch
+func sumPromptRequests(promptReqs []CompletionRequest, count func(CompletionRequest) int) int {
+	sum := 0
+	for _, req := range promptReqs {
+		sum += count(req)
+	}
+	return sum
 }
 
 func (c *TextCompletionRequest) GetTools() []Tool {
@@ -284,6 +890,33 @@ func (c *TextCompletionRequest) GetToolChoice() string {
 	return ""
 }
 
+func (c *TextCompletionRequest) GetToolChoiceFunctionName() string {
+	return ""
+}
+
+func (c *TextCompletionRequest) IsParallelToolCalls() bool {
+	return false
+}
+
+func (c *TextCompletionRequest) GetResponseFormat() *ResponseFormat {
+	return nil
+}
+
+func (c *TextCompletionRequest) GetChatTemplateKwargs() map[string]any {
+	return nil
+}
+
+func (c *TextCompletionRequest) GetServiceTier() string {
+	return ""
+}
+
+func (c *TextCompletionRequest) GetBestOf() int {
+	if c.BestOf == nil {
+		return c.GetN()
+	}
+	return *c.BestOf
+}
+
 func (c *TextCompletionRequest) GetMaxCompletionTokens() *int64 {
 	return c.MaxTokens
 }
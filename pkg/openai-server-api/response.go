@@ -20,6 +20,7 @@ package openaiserverapi
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/valyala/fasthttp"
@@ -34,12 +35,21 @@ type BaseCompletionResponse struct {
 	ID string `json:"id"`
 	// Created defines the response creation timestamp
 	Created int64 `json:"created"`
-	// Model defines the Model name for current request
-	Model string `json:"model"`
+	// Model defines the Model name for current request, empty in the chunks of the Azure OpenAI flavor
+	Model string `json:"model,omitempty"`
 	// Usage contains the token usage statistics for the request
 	Usage *Usage `json:"usage"`
 	// Object is the Object type, "text_completion", "chat.completion", or "chat.completion.chunk"
 	Object string `json:"object"`
+	// ServiceTier is the service tier the request was processed in, set if the request asked for a service tier
+	ServiceTier string `json:"service_tier,omitempty"`
+	// SystemFingerprint identifies the configuration of the simulator that generated the response
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+	// PromptFilterResults are the results of the content filters of the prompts, set in the Azure OpenAI flavor
+	PromptFilterResults []PromptFilterResult `json:"prompt_filter_results,omitempty"`
+	// SimElapsedMs is the cumulative delay in milliseconds the simulator intended for the token of a streamed chunk,
+	// a simulator specific field, set only if the chunk timing is emitted
+	SimElapsedMs *int64 `json:"sim_elapsed_ms,omitempty"`
 	// DoRemoteDecode boolean value, true when request's decode will be done on remote pod
 	DoRemoteDecode bool `json:"do_remote_decode"`
 	// DoRemotePrefill boolean value, true when request's prefill was done on remote pod
@@ -54,6 +64,45 @@ type BaseCompletionResponse struct {
 	RemotePort int `json:"remote_port"`
 }
 
+// KVTransferParams are the parameters of the transfer of the kv cache of a request between
+// the pod that does its prefill and the pod that does its decode
+type KVTransferParams struct {
+	// DoRemoteDecode is true when the decode of the request is done on a remote pod
+	DoRemoteDecode bool `json:"do_remote_decode"`
+	// DoRemotePrefill is true when the prefill of the request was done on a remote pod
+	DoRemotePrefill bool `json:"do_remote_prefill"`
+	// RemoteBlockIds are the identifiers of the kv cache blocks of the prompt on the prefill pod
+	RemoteBlockIds []string `json:"remote_block_ids"`
+	// RemoteEngineId is the identifier of the inference engine of the prefill pod
+	RemoteEngineId string `json:"remote_engine_id"`
+	// RemoteHost is the hostname or IP address of the prefill pod
+	RemoteHost string `json:"remote_host"`
+	// RemotePort is the port of the prefill pod
+	RemotePort int `json:"remote_port"`
+}
+
+// SetKVTransferParams sets the kv cache transfer fields of the response
+func (b *BaseCompletionResponse) SetKVTransferParams(params KVTransferParams) {
+	b.DoRemoteDecode = params.DoRemoteDecode
+	b.DoRemotePrefill = params.DoRemotePrefill
+	b.RemoteBlockIds = params.RemoteBlockIds
+	b.RemoteEngineId = params.RemoteEngineId
+	b.RemoteHost = params.RemoteHost
+	b.RemotePort = params.RemotePort
+}
+
+// GetKVTransferParams returns the kv cache transfer fields of the response
+func (b *BaseCompletionResponse) GetKVTransferParams() KVTransferParams {
+	return KVTransferParams{
+		DoRemoteDecode:  b.DoRemoteDecode,
+		DoRemotePrefill: b.DoRemotePrefill,
+		RemoteBlockIds:  b.RemoteBlockIds,
+		RemoteEngineId:  b.RemoteEngineId,
+		RemoteHost:      b.RemoteHost,
+		RemotePort:      b.RemotePort,
+	}
+}
+
 // Usage contains token Usage statistics
 type Usage struct {
 	// PromptTokens is the number of tokens in the prompt
@@ -77,6 +126,49 @@ type BaseResponseChoice struct {
 	Index int `json:"index"`
 	// FinishReason defines finish reason for response or for chunks, for not last chinks is defined as null
 	FinishReason *string `json:"finish_reason"`
+	// ContentFilterResults are the results of the content filters of the choice, set in the Azure OpenAI flavor
+	ContentFilterResults *ContentFilterResults `json:"content_filter_results,omitempty"`
+}
+
+// ContentFilterSeveritySafe is the severity of the content that passed a content filter
+const ContentFilterSeveritySafe = "safe"
+
+// ContentFilterResult is the result of a single content filter of Azure OpenAI
+type ContentFilterResult struct {
+	// Filtered is true if the content was filtered
+	Filtered bool `json:"filtered"`
+	// Severity is the severity of the content, safe, low, medium or high
+	Severity string `json:"severity"`
+}
+
+// ContentFilterResults are the results of the content filters of Azure OpenAI, empty in the chunks
+// without content
+type ContentFilterResults struct {
+	Hate     *ContentFilterResult `json:"hate,omitempty"`
+	SelfHarm *ContentFilterResult `json:"self_harm,omitempty"`
+	Sexual   *ContentFilterResult `json:"sexual,omitempty"`
+	Violence *ContentFilterResult `json:"violence,omitempty"`
+}
+
+// PromptFilterResult contains the results of the content filters of a prompt
+type PromptFilterResult struct {
+	// PromptIndex is the index of the prompt in the request
+	PromptIndex int `json:"prompt_index"`
+	// ContentFilterResults are the results of the content filters of the prompt
+	ContentFilterResults ContentFilterResults `json:"content_filter_results"`
+}
+
+// NewSafeContentFilterResults creates the results of the content filters of a content that passed them all
+func NewSafeContentFilterResults() *ContentFilterResults {
+	safe := func() *ContentFilterResult {
+		return &ContentFilterResult{Filtered: false, Severity: ContentFilterSeveritySafe}
+	}
+	return &ContentFilterResults{Hate: safe(), SelfHarm: safe(), Sexual: safe(), Violence: safe()}
+}
+
+// NewSafePromptFilterResults creates the results of the content filters of a prompt that passed them all
+func NewSafePromptFilterResults() []PromptFilterResult {
+	return []PromptFilterResult{{PromptIndex: 0, ContentFilterResults: *NewSafeContentFilterResults()}}
 }
 
 // v1/chat/completion
@@ -88,21 +180,50 @@ type Message struct {
 	Content Content `json:"content,omitempty"`
 	// ToolCalls are the tool calls created by the model
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Refusal is the refusal message generated by the model instead of the content
+	Refusal string `json:"refusal,omitempty"`
 }
 
+const (
+	// ContentTypeText is the type of a text content block
+	ContentTypeText = "text"
+	// ContentTypeImageURL is the type of an image content block
+	ContentTypeImageURL = "image_url"
+)
+
 type Content struct {
 	Raw        string
 	Structured []ContentBlock
 }
 
 type ContentBlock struct {
-	Type     string     `json:"type"`
-	Text     string     `json:"text,omitempty"`
-	ImageURL ImageBlock `json:"image_url,omitempty"`
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	ImageURL *ImageBlock `json:"image_url,omitempty"`
 }
 
+// ImageBlock is the image of an image_url content block
 type ImageBlock struct {
 	Url string `json:"url,omitempty"`
+	// Detail is the requested detail level of the image, low, high or auto
+	Detail string `json:"detail,omitempty"`
+}
+
+// UnmarshalJSON allows the image to be sent as an object or as its URL string
+func (ib *ImageBlock) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*ib = ImageBlock{Url: url}
+		return nil
+	}
+
+	type imageBlock ImageBlock
+	var block imageBlock
+	if err := json.Unmarshal(data, &block); err != nil {
+		return errors.New("image_url must be a string or an object with a url")
+	}
+	*ib = ImageBlock(block)
+	return nil
 }
 
 // UnmarshalJSON allow use both format
@@ -134,13 +255,24 @@ func (mc Content) MarshalJSON() ([]byte, error) {
 	return json.Marshal("")
 }
 
+// NumberOfImages returns the number of image blocks in the content
+func (mc Content) NumberOfImages() int {
+	nImages := 0
+	for _, block := range mc.Structured {
+		if block.Type == ContentTypeImageURL {
+			nImages++
+		}
+	}
+	return nImages
+}
+
 func (mc Content) PlainText() string {
 	if mc.Raw != "" {
 		return mc.Raw
 	}
 	var sb strings.Builder
 	for _, block := range mc.Structured {
-		if block.Type == "text" {
+		if block.Type == ContentTypeText {
 			sb.WriteString(block.Text)
 			sb.WriteString(" ")
 		}
@@ -162,8 +294,8 @@ type FunctionCall struct {
 type ToolCall struct {
 	// Function is a tool call generated by the model
 	Function FunctionCall `json:"function"`
-	// ID is the ID of the tool call
-	ID string `json:"id"`
+	// ID is the ID of the tool call, in streaming can be empty in not the first chunk
+	ID string `json:"id,omitempty"`
 	// Type is the type of the tool, only functions are supported
 	Type string `json:"type"`
 	// Index is the index of the tool in the sequence of tools generated by the model
@@ -235,6 +367,67 @@ type ErrorResponse struct {
 	Error CompletionError `json:"error"`
 }
 
+// AzureErrorResponse wraps the error in the Azure OpenAI format
+type AzureErrorResponse struct {
+	Error AzureError `json:"error"`
+}
+
+// AzureError defines the simulator's response in case of an error in the Azure OpenAI format
+type AzureError struct {
+	// Code is the Azure error code, see ErrorCodeToAzureCode
+	Code string `json:"code"`
+	// Message is an error Message
+	Message string `json:"message"`
+	// Target is the error's parameter
+	Target *string `json:"target,omitempty"`
+	// InnerError contains more specific information about the error
+	InnerError AzureInnerError `json:"innererror"`
+}
+
+// AzureInnerError contains more specific information about the error
+type AzureInnerError struct {
+	// Code is the type of the error
+	Code string `json:"code"`
+}
+
+// NewAzureErrorResponse creates the Azure OpenAI format of the given error
+func NewAzureErrorResponse(compErr CompletionError) AzureErrorResponse {
+	return AzureErrorResponse{
+		Error: AzureError{
+			Code:       ErrorCodeToAzureCode(compErr.Code),
+			Message:    compErr.Message,
+			Target:     compErr.Param,
+			InnerError: AzureInnerError{Code: compErr.Type},
+		},
+	}
+}
+
+// ErrorCodeToAzureCode maps error code to the error code string returned by Azure OpenAI
+func ErrorCodeToAzureCode(code int) string {
+	switch code {
+	case fasthttp.StatusBadRequest:
+		return "BadRequest"
+	case fasthttp.StatusUnauthorized:
+		return "Unauthorized"
+	case fasthttp.StatusForbidden:
+		return "Forbidden"
+	case fasthttp.StatusNotFound:
+		return "DeploymentNotFound"
+	case fasthttp.StatusNotAcceptable:
+		return "NotAcceptable"
+	case fasthttp.StatusUnprocessableEntity:
+		return "UnprocessableEntity"
+	case fasthttp.StatusServiceUnavailable:
+		return "ServiceUnavailable"
+	default:
+		// Azure OpenAI returns the status code itself, e.g. "429" for rate limits
+		if code >= fasthttp.StatusInternalServerError {
+			return "InternalServerError"
+		}
+		return strconv.Itoa(code)
+	}
+}
+
 // ErrorCodeToType maps error code to error type according to https://www.npmjs.com/package/openai
 func ErrorCodeToType(code int) string {
 	errorType := ""
@@ -0,0 +1,256 @@
+/*
+Copyright 2025 The llm-d-inference-sim Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openaiserverapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ResponsesItemMessage is the type of a message item of the responses API
+	ResponsesItemMessage = "message"
+	// ResponsesContentInputText is the type of a text content part of an input message
+	ResponsesContentInputText = "input_text"
+	// ResponsesContentOutputText is the type of a text content part of an output message
+	ResponsesContentOutputText = "output_text"
+	// ResponsesContentRefusal is the type of a refusal content part of an output message
+	ResponsesContentRefusal = "refusal"
+
+	// ResponsesStatusCompleted - the response was generated completely
+	ResponsesStatusCompleted = "completed"
+	// ResponsesStatusIncomplete - the generation of the response was stopped, see the incomplete details
+	ResponsesStatusIncomplete = "incomplete"
+	// ResponsesStatusInProgress - the response is being generated
+	ResponsesStatusInProgress = "in_progress"
+
+	// ResponsesIncompleteMaxOutputTokens - the response reached the maximal number of output tokens
+	ResponsesIncompleteMaxOutputTokens = "max_output_tokens"
+)
+
+// ResponsesContentPart is a part of the content of a message of the responses API
+type ResponsesContentPart struct {
+	// Type is input_text or output_text for a text, or refusal
+	Type string `json:"type"`
+	// Text is the text of a text part
+	Text string `json:"text,omitempty"`
+	// Refusal is the refusal message of a refusal part
+	Refusal string `json:"refusal,omitempty"`
+	// Annotations are the annotations of an output text, always empty
+	Annotations []any `json:"annotations,omitempty"`
+}
+
+// ResponsesInputItem is an item of the input of a responses request, only messages are supported
+type ResponsesInputItem struct {
+	// Type is the type of the item, message if not set
+	Type string `json:"type,omitempty"`
+	// Role is the role of the message: user, assistant, system or developer
+	Role string `json:"role"`
+	// Content is the text of the message
+	Content string `json:"-"`
+}
+
+// UnmarshalJSON accepts the content of the message as a string or an array of content parts
+func (i *ResponsesInputItem) UnmarshalJSON(data []byte) error {
+	// responsesInputItem has the fields of ResponsesInputItem without its methods
+	type responsesInputItem ResponsesInputItem
+	aux := struct {
+		*responsesInputItem
+		Content json.RawMessage `json:"content"`
+	}{responsesInputItem: (*responsesInputItem)(i)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if i.Type != "" && i.Type != ResponsesItemMessage {
+		return fmt.Errorf("input item type '%s' is not supported, only messages are supported", i.Type)
+	}
+
+	i.Content = ""
+	if len(aux.Content) == 0 || string(aux.Content) == "null" {
+		return errors.New("input message content is required")
+	}
+	if err := json.Unmarshal(aux.Content, &i.Content); err == nil {
+		return nil
+	}
+	var parts []ResponsesContentPart
+	if err := json.Unmarshal(aux.Content, &parts); err != nil {
+		return errors.New("input message content must be a string or an array of content parts")
+	}
+	var sb strings.Builder
+	for _, part := range parts {
+		if part.Type == ResponsesContentInputText || part.Type == ResponsesContentOutputText {
+			sb.WriteString(part.Text)
+		}
+	}
+	i.Content = sb.String()
+	return nil
+}
+
+// ResponsesRequest defines structure of /responses request
+type ResponsesRequest struct {
+	// Model defines Model name to use for the response
+	Model string `json:"model"`
+	// Input is the input of the model, set from the input field which is a string or an array of input items,
+	// a string is a single user message
+	Input []ResponsesInputItem `json:"-"`
+	// Instructions is a system message inserted before the input
+	Instructions string `json:"instructions,omitempty"`
+	// MaxOutputTokens is an upper bound for the number of tokens generated for the response
+	MaxOutputTokens *int64 `json:"max_output_tokens,omitempty"`
+	// Stream defines whether the response is streamed as server-sent events
+	Stream bool `json:"stream"`
+	// User is a unique identifier representing the end-user
+	User string `json:"user,omitempty"`
+}
+
+// UnmarshalJSON accepts the input as a string or an array of input items
+func (r *ResponsesRequest) UnmarshalJSON(data []byte) error {
+	// responsesRequest has the fields of ResponsesRequest without its methods
+	type responsesRequest ResponsesRequest
+	aux := struct {
+		*responsesRequest
+		Input json.RawMessage `json:"input"`
+	}{responsesRequest: (*responsesRequest)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.Input = nil
+	if len(aux.Input) == 0 || string(aux.Input) == "null" {
+		return errors.New("input is required")
+	}
+	var text string
+	if err := json.Unmarshal(aux.Input, &text); err == nil {
+		r.Input = []ResponsesInputItem{{Type: ResponsesItemMessage, Role: RoleUser, Content: text}}
+		return nil
+	}
+	if err := json.Unmarshal(aux.Input, &r.Input); err != nil {
+		return err
+	}
+	if len(r.Input) == 0 {
+		return errors.New("input cannot be an empty array")
+	}
+	return nil
+}
+
+// ToChatCompletionRequest returns the chat completion request that generates the response,
+// the instructions are the first message, with the system role
+func (r *ResponsesRequest) ToChatCompletionRequest() *ChatCompletionRequest {
+	req := &ChatCompletionRequest{
+		BaseCompletionRequest: BaseCompletionRequest{Model: r.Model, Stream: r.Stream},
+		MaxCompletionTokens:   r.MaxOutputTokens,
+		Messages:              make([]Message, 0, len(r.Input)+1),
+	}
+	if r.Instructions != "" {
+		req.Messages = append(req.Messages, Message{Role: RoleSystem, Content: Content{Raw: r.Instructions}})
+	}
+	for _, item := range r.Input {
+		req.Messages = append(req.Messages, Message{Role: item.Role, Content: Content{Raw: item.Content}})
+	}
+	return req
+}
+
+// ResponsesOutputItem is an item of the output of a response, a message of the assistant
+type ResponsesOutputItem struct {
+	ID string `json:"id"`
+	// Type is the type of the item, always message
+	Type string `json:"type"`
+	// Status is the status of the item: in_progress, completed or incomplete
+	Status string `json:"status"`
+	// Role is the role of the message, always assistant
+	Role string `json:"role"`
+	// Content contains the output text or the refusal
+	Content []ResponsesContentPart `json:"content"`
+}
+
+// ResponsesIncompleteDetails contains the reason a response is incomplete
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+// ResponsesInputTokensDetails contains the details of the input tokens of a response
+type ResponsesInputTokensDetails struct {
+	// CachedTokens is the number of input tokens that were found in the cache
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// ResponsesOutputTokensDetails contains the details of the output tokens of a response
+type ResponsesOutputTokensDetails struct {
+	// ReasoningTokens is the number of reasoning tokens
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ResponsesUsage contains the token statistics of a response
+type ResponsesUsage struct {
+	InputTokens         int                          `json:"input_tokens"`
+	InputTokensDetails  ResponsesInputTokensDetails  `json:"input_tokens_details"`
+	OutputTokens        int                          `json:"output_tokens"`
+	OutputTokensDetails ResponsesOutputTokensDetails `json:"output_tokens_details"`
+	TotalTokens         int                          `json:"total_tokens"`
+}
+
+// ResponsesResponse defines structure of /responses response
+type ResponsesResponse struct {
+	// ID defines the response ID
+	ID string `json:"id"`
+	// Object is the object type, always response
+	Object string `json:"object"`
+	// CreatedAt is the response creation timestamp
+	CreatedAt int64 `json:"created_at"`
+	// Status is the status of the response: in_progress, completed or incomplete
+	Status string `json:"status"`
+	// IncompleteDetails contains the reason the response is incomplete, nil if it is not
+	IncompleteDetails *ResponsesIncompleteDetails `json:"incomplete_details"`
+	// Instructions are the instructions of the request
+	Instructions *string `json:"instructions"`
+	// MaxOutputTokens is the maximal number of output tokens of the request
+	MaxOutputTokens *int64 `json:"max_output_tokens"`
+	// Model defines the Model name for current request
+	Model string `json:"model"`
+	// Output contains the generated message, empty while the response is in progress
+	Output []ResponsesOutputItem `json:"output"`
+	// Usage contains the token usage statistics, nil while the response is in progress
+	Usage *ResponsesUsage `json:"usage"`
+}
+
+// ResponsesStreamEvent is an event of a streamed response, only the fields of the event's type are set
+type ResponsesStreamEvent struct {
+	// Type is the type of the event, e.g. response.output_text.delta
+	Type string `json:"type"`
+	// SequenceNumber is the order of the event in the stream
+	SequenceNumber int `json:"sequence_number"`
+	// Response is the response, in the events of the response's status
+	Response *ResponsesResponse `json:"response,omitempty"`
+	// OutputIndex is the index of the output item of the event
+	OutputIndex *int `json:"output_index,omitempty"`
+	// Item is the output item, in the events of the item's status
+	Item *ResponsesOutputItem `json:"item,omitempty"`
+	// ItemID is the id of the output item of a content event
+	ItemID string `json:"item_id,omitempty"`
+	// ContentIndex is the index of the content part of a content event
+	ContentIndex *int `json:"content_index,omitempty"`
+	// Part is the content part, in the events of the part's status
+	Part *ResponsesContentPart `json:"part,omitempty"`
+	// Delta is the text added to the content part
+	Delta *string `json:"delta,omitempty"`
+	// Text is the full text of a finished output text part
+	Text *string `json:"text,omitempty"`
+	// Refusal is the full refusal of a finished refusal part
+	Refusal *string `json:"refusal,omitempty"`
+}
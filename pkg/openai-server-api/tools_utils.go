@@ -18,7 +18,9 @@ package openaiserverapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/llm-d/llm-d-inference-sim/pkg/common"
 	"github.com/santhosh-tekuri/jsonschema/v5"
@@ -30,6 +32,14 @@ const (
 	ToolChoiceRequired = "required"
 )
 
+// toolCallIDPrefix is the prefix of the tool call ids, followed by 10 random digits
+const toolCallIDPrefix = "chatcmpl-tool-"
+
+const (
+	LimitMaxToolsPerRequest = "max-tools-per-request"
+	LimitMaxToolSchemaDepth = "max-tool-schema-depth"
+)
+
 func CountTokensForToolCalls(toolCalls []ToolCall) int {
 	numberOfTokens := 0
 	for _, tc := range toolCalls {
@@ -52,10 +62,34 @@ var fakeStringArguments = []string{
 	`lifetime`,
 }
 
+// FindDuplicateToolName returns the first function name that appears more than once in the given tools
+func FindDuplicateToolName(tools []Tool) (string, bool) {
+	names := make(map[string]struct{}, len(tools))
+	for _, tool := range tools {
+		if _, exists := names[tool.Function.Name]; exists {
+			return tool.Function.Name, true
+		}
+		names[tool.Function.Name] = struct{}{}
+	}
+	return "", false
+}
+
+// FindTool returns the tool with the given function name
+func FindTool(tools []Tool, name string) (Tool, bool) {
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
 // CreateToolCalls creates and returns response payload based on this request
 // (tool calls or nothing in case we randomly choose not to generate calls),
-// and the number of generated completion token sand the finish reason
-func CreateToolCalls(tools []Tool, toolChoice string, config *common.Configuration) ([]ToolCall, int, error) {
+// and the number of generated completion token sand the finish reason.
+// If parallel is false at most one tool call is created
+func CreateToolCalls(tools []Tool, toolChoice string, parallel bool, config *common.Configuration,
+	random *common.Random) ([]ToolCall, int, error) {
 	// This function is called if tool choice is either 'required' or 'auto'.
 	// In case of 'required' at least one tool call has to be created, and we randomly choose
 	// the number of calls starting from one. Otherwise, we start from 0, and in case we randomly
@@ -64,16 +98,21 @@ func CreateToolCalls(tools []Tool, toolChoice string, config *common.Configurati
 	if toolChoice == ToolChoiceRequired {
 		min = 1
 	}
-	numberOfCalls := common.RandomInt(min, len(tools))
+	maxCalls := len(tools)
+	if !parallel {
+		maxCalls = 1
+	}
+	numberOfCalls := random.Int(min, maxCalls)
 	if numberOfCalls == 0 {
 		return nil, 0, nil
 	}
 
 	calls := make([]ToolCall, 0)
+	ids := make(map[string]struct{})
 	for i := range numberOfCalls {
 		// Randomly choose which tools to call. We may call the same tool more than once.
-		index := common.RandomInt(0, len(tools)-1)
-		args, err := GenerateToolArguments(tools[index], config)
+		index := random.Int(0, len(tools)-1)
+		args, err := GenerateToolArguments(tools[index], config, random)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -88,16 +127,46 @@ func CreateToolCalls(tools []Tool, toolChoice string, config *common.Configurati
 				TokenizedArguments: common.Tokenize(string(argsJson)),
 				Name:               &tools[index].Function.Name,
 			},
-			ID:    "chatcmpl-tool-" + common.RandomNumericString(10),
+			ID:    newToolCallID(random, ids),
 			Type:  "function",
 			Index: i,
 		}
 		calls = append(calls, call)
 	}
 
+	if err := ValidateToolCallIDs(calls); err != nil {
+		return nil, 0, err
+	}
 	return calls, CountTokensForToolCalls(calls), nil
 }
 
+// newToolCallID returns a random tool call id that is not in the given set of ids,
+// and adds it to the set
+func newToolCallID(random *common.Random, ids map[string]struct{}) string {
+	for {
+		id := toolCallIDPrefix + random.NumericString(10)
+		if _, exists := ids[id]; !exists {
+			ids[id] = struct{}{}
+			return id
+		}
+	}
+}
+
+// ValidateToolCallIDs checks that the ids of the tool calls are not empty and unique
+func ValidateToolCallIDs(calls []ToolCall) error {
+	ids := make(map[string]struct{}, len(calls))
+	for _, call := range calls {
+		if call.ID == "" {
+			return errors.New("empty tool call id")
+		}
+		if _, exists := ids[call.ID]; exists {
+			return fmt.Errorf("duplicate tool call id '%s'", call.ID)
+		}
+		ids[call.ID] = struct{}{}
+	}
+	return nil
+}
+
 func GetRequiredAsMap(property map[string]any) map[string]struct{} {
 	required := make(map[string]struct{})
 	requiredParams, ok := property["required"]
@@ -111,7 +180,7 @@ func GetRequiredAsMap(property map[string]any) map[string]struct{} {
 	return required
 }
 
-func GenerateToolArguments(tool Tool, config *common.Configuration) (map[string]any, error) {
+func GenerateToolArguments(tool Tool, config *common.Configuration, random *common.Random) (map[string]any, error) {
 	arguments := make(map[string]any)
 	properties, _ := tool.Function.Parameters["properties"].(map[string]any)
 
@@ -119,10 +188,10 @@ func GenerateToolArguments(tool Tool, config *common.Configuration) (map[string]
 
 	for param, property := range properties {
 		_, paramIsRequired := required[param]
-		if !paramIsRequired && !common.RandomBool(config.ToolCallNotRequiredParamProbability) {
+		if !paramIsRequired && !random.Bool(config.ToolCallNotRequiredParamProbability) {
 			continue
 		}
-		arg, err := CreateArgument(property, config)
+		arg, err := CreateArgument(property, config, random)
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +201,7 @@ func GenerateToolArguments(tool Tool, config *common.Configuration) (map[string]
 	return arguments, nil
 }
 
-func CreateArgument(property any, config *common.Configuration) (any, error) {
+func CreateArgument(property any, config *common.Configuration, random *common.Random) (any, error) {
 	propertyMap, _ := property.(map[string]any)
 	paramType := propertyMap["type"]
 
@@ -141,20 +210,20 @@ func CreateArgument(property any, config *common.Configuration) (any, error) {
 	if ok {
 		enumArray, ok := enum.([]any)
 		if ok && len(enumArray) > 0 {
-			index := common.RandomInt(0, len(enumArray)-1)
+			index := random.Int(0, len(enumArray)-1)
 			return enumArray[index], nil
 		}
 	}
 
 	switch paramType {
 	case "string":
-		return GetStringArgument(), nil
+		return GetStringArgument(random), nil
 	case "integer":
-		return common.RandomInt(config.MinToolCallIntegerParam, config.MaxToolCallIntegerParam), nil
+		return random.Int(config.MinToolCallIntegerParam, config.MaxToolCallIntegerParam), nil
 	case "number":
-		return common.RandomFloat(config.MinToolCallNumberParam, config.MaxToolCallNumberParam), nil
+		return random.Float(config.MinToolCallNumberParam, config.MaxToolCallNumberParam), nil
 	case "boolean":
-		return common.FlipCoin(), nil
+		return random.FlipCoin(), nil
 	case "array":
 		items := propertyMap["items"]
 		itemsMap := items.(map[string]any)
@@ -169,10 +238,10 @@ func CreateArgument(property any, config *common.Configuration) (any, error) {
 		if minItems > maxItems {
 			return nil, fmt.Errorf("minItems (%d) is greater than maxItems(%d)", minItems, maxItems)
 		}
-		numberOfElements := common.RandomInt(minItems, maxItems)
+		numberOfElements := random.Int(minItems, maxItems)
 		array := make([]any, numberOfElements)
 		for i := range numberOfElements {
-			elem, err := CreateArgument(itemsMap, config)
+			elem, err := CreateArgument(itemsMap, config, random)
 			if err != nil {
 				return nil, err
 			}
@@ -185,10 +254,10 @@ func CreateArgument(property any, config *common.Configuration) (any, error) {
 		object := make(map[string]interface{})
 		for fieldName, fieldProperties := range objectProperties {
 			_, fieldIsRequired := required[fieldName]
-			if !fieldIsRequired && !common.RandomBool(config.ObjectToolCallNotRequiredParamProbability) {
+			if !fieldIsRequired && !random.Bool(config.ObjectToolCallNotRequiredParamProbability) {
 				continue
 			}
-			fieldValue, err := CreateArgument(fieldProperties, config)
+			fieldValue, err := CreateArgument(fieldProperties, config, random)
 			if err != nil {
 				return nil, err
 			}
@@ -200,21 +269,46 @@ func CreateArgument(property any, config *common.Configuration) (any, error) {
 	}
 }
 
-func GetStringArgument() string {
-	index := common.RandomInt(0, len(fakeStringArguments)-1)
+// CreateStructuredResponse creates a JSON value that follows the given schema, with the same
+// generator as the arguments of the tool calls, and returns it as text
+func CreateStructuredResponse(schema map[string]any, config *common.Configuration,
+	random *common.Random) (string, error) {
+	value, err := CreateArgument(schema, config, random)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func GetStringArgument(random *common.Random) string {
+	index := random.Int(0, len(fakeStringArguments)-1)
 	return fakeStringArguments[index]
 }
 
 type Validator struct {
 	schema *jsonschema.Schema
+	// paramSchema validates the schema of a single parameter, and the schema of a structured response
+	paramSchema *jsonschema.Schema
 }
 
 func CreateValidator() (*Validator, error) {
-	sch, err := jsonschema.CompileString("schema.json", schema)
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("schema.json", strings.NewReader(schema)); err != nil {
+		return nil, err
+	}
+	sch, err := compiler.Compile("schema.json")
+	if err != nil {
+		return nil, err
+	}
+	paramSch, err := compiler.Compile("schema.json#/$defs/param_definition")
 	if err != nil {
 		return nil, err
 	}
-	return &Validator{schema: sch}, nil
+	return &Validator{schema: sch, paramSchema: paramSch}, nil
 }
 
 func (v *Validator) ValidateTool(tool []byte) error {
@@ -226,6 +320,78 @@ func (v *Validator) ValidateTool(tool []byte) error {
 	return v.schema.Validate(value)
 }
 
+// ValidateResponseFormat checks the type of the given response format, and that its JSON schema is supported
+// by the generator of the structured responses, a nil response format is valid
+func (v *Validator) ValidateResponseFormat(format *ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+	switch format.Type {
+	case ResponseFormatText, ResponseFormatJSONObject:
+		return nil
+	case ResponseFormatJSONSchema:
+		if format.JSONSchema == nil || format.JSONSchema.Schema == nil {
+			return errors.New("response_format of type json_schema requires json_schema.schema")
+		}
+		return v.paramSchema.Validate(format.JSONSchema.Schema)
+	default:
+		return fmt.Errorf("invalid response_format type '%s', valid types are: %s, %s, %s", format.Type,
+			ResponseFormatText, ResponseFormatJSONObject, ResponseFormatJSONSchema)
+	}
+}
+
+// ToolSchemaDepth returns the nesting depth of the given tool parameters schema.
+// Every object or array level counts as one, so an array of objects adds two
+// levels: one for the array and one for its items. Primitive types add nothing.
+func ToolSchemaDepth(parameters map[string]any) int {
+	return schemaDepth(parameters)
+}
+
+func schemaDepth(property any) int {
+	propertyMap, ok := property.(map[string]any)
+	if !ok {
+		return 0
+	}
+	switch propertyMap["type"] {
+	case "object":
+		maxChildDepth := 0
+		if properties, ok := propertyMap["properties"].(map[string]any); ok {
+			for _, child := range properties {
+				maxChildDepth = max(maxChildDepth, schemaDepth(child))
+			}
+		}
+		return 1 + maxChildDepth
+	case "array":
+		// items may be a single schema or a list of schemas
+		if itemsList, ok := propertyMap["items"].([]any); ok {
+			maxItemDepth := 0
+			for _, item := range itemsList {
+				maxItemDepth = max(maxItemDepth, schemaDepth(item))
+			}
+			return 1 + maxItemDepth
+		}
+		return 1 + schemaDepth(propertyMap["items"])
+	default:
+		return 0
+	}
+}
+
+// ValidateToolsLimits checks the number of tools and the depth of their parameters schemas
+// against the given limits, returns the name of the exceeded limit and an error if any
+func (v *Validator) ValidateToolsLimits(tools []Tool, maxTools int, maxDepth int) (string, error) {
+	if len(tools) > maxTools {
+		return LimitMaxToolsPerRequest, fmt.Errorf("number of tools %d exceeds the limit %s=%d",
+			len(tools), LimitMaxToolsPerRequest, maxTools)
+	}
+	for _, tool := range tools {
+		if depth := ToolSchemaDepth(tool.Function.Parameters); depth > maxDepth {
+			return LimitMaxToolSchemaDepth, fmt.Errorf("parameters schema depth %d of tool %s exceeds the limit %s=%d",
+				depth, tool.Function.Name, LimitMaxToolSchemaDepth, maxDepth)
+		}
+	}
+	return "", nil
+}
+
 const schema = `{
   "type": "object",
   "properties": {
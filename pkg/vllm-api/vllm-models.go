@@ -17,7 +17,8 @@ limitations under the License.
 package vllmapi
 
 const (
-	ObjectModel = "model"
+	ObjectModel           = "model"
+	ObjectModelPermission = "model_permission"
 )
 
 const (
@@ -25,9 +26,39 @@ const (
 	PromLabelRunningLoraAdapters = "running_lora_adapters"
 	PromLabelMaxLora             = "max_lora"
 	PromLabelModelName           = "model_name"
+	PromLabelLimit               = "limit"
+	PromLabelEngine              = "engine"
+	PromLabelWorkerID            = "worker_id"
+	PromLabelFailureType         = "failure_type"
+	PromLabelModel               = "model"
+	PromLabelServiceTier         = "service_tier"
+	PromLabelStatusCode          = "status_code"
+	PromLabelSource              = "source"
+	PromLabelFinishedReason      = "finished_reason"
+	PromLabelErrorType           = "error_type"
+	PromLabelOperation           = "operation"
+	PromLabelRank                = "rank"
 
-	VllmLoraRequestInfo    = "vllm:lora_requests_info"
-	VllmNumRequestsRunning = "vllm:num_requests_running"
+	VllmLoraRequestInfo      = "vllm:lora_requests_info"
+	VllmNumRequestsRunning   = "vllm:num_requests_running"
+	VllmNumRequestsWaiting   = "vllm:num_requests_waiting"
+	VllmGPUCacheUsagePerc    = "vllm:gpu_cache_usage_perc"
+	VllmRequestQueueTime     = "vllm:request_queue_time_seconds"
+	VllmRequestPrefillTime   = "vllm:request_prefill_time_seconds"
+	VllmRequestDecodeTime    = "vllm:request_decode_time_seconds"
+	VllmRequestInferenceTime = "vllm:request_inference_time_seconds"
+	VllmE2ERequestLatency    = "vllm:e2e_request_latency_seconds"
+	VllmTimeToFirstToken     = "vllm:time_to_first_token_seconds"
+	VllmTimePerOutputToken   = "vllm:time_per_output_token_seconds"
+	VllmRequestAborted       = "vllm:request_aborted_total"
+	VllmRequestSuccess       = "vllm:request_success_total"
+	VllmRequestFailure       = "vllm:request_failure_total"
+	VllmNumPreemptions       = "vllm:num_preemptions_total"
+	VllmIterationTokens      = "vllm:iteration_tokens_total"
+
+	VllmRequestPromptTokens     = "vllm:request_prompt_tokens"
+	VllmRequestGenerationTokens = "vllm:request_generation_tokens"
+	VllmRequestParamsMaxTokens  = "vllm:request_params_max_tokens"
 )
 
 // modelInfo defines data about model returned by /models API
@@ -36,14 +67,51 @@ type ModelsResponseModelInfo struct {
 	ID string `json:"id"`
 	// Object is the Object type, "model"
 	Object string `json:"object"`
-	// Created is model creation type - in simulator contains "now" timestamp
+	// Created is model creation time - in simulator contains "now" timestamp for base models
+	// and the load time for LoRA adapters
 	Created int64 `json:"created"`
 	// OwnedBy is "vllm"
 	OwnedBy string `json:"owned_by"`
-	// Root is the model path
+	// Root is the model path, for LoRA adapters the adapter's path, or its name if the path is unknown
 	Root string `json:"root"`
 	// Parent is name of base model when the model is LoRA adapter, if the model is not a LoRA - null
 	Parent *string `json:"parent"`
+	// MaxModelLen is the model's context window, LoRA adapters inherit it from the base model
+	MaxModelLen int `json:"max_model_len"`
+	// Permission contains the permissions of the model, a single block with the default permissions of vLLM
+	Permission []ModelPermission `json:"permission"`
+}
+
+// ModelPermission is a permission block of a model in the /models API
+type ModelPermission struct {
+	// ID is the ID of the permission, "modelperm-" followed by a random id
+	ID string `json:"id"`
+	// Object is the Object type, "model_permission"
+	Object string `json:"object"`
+	// Created is the creation time of the permission, the creation time of its model
+	Created            int64   `json:"created"`
+	AllowCreateEngine  bool    `json:"allow_create_engine"`
+	AllowSampling      bool    `json:"allow_sampling"`
+	AllowLogprobs      bool    `json:"allow_logprobs"`
+	AllowSearchIndices bool    `json:"allow_search_indices"`
+	AllowView          bool    `json:"allow_view"`
+	AllowFineTuning    bool    `json:"allow_fine_tuning"`
+	Organization       string  `json:"organization"`
+	Group              *string `json:"group"`
+	IsBlocking         bool    `json:"is_blocking"`
+}
+
+// NewModelPermission returns a permission block with the default permissions of vLLM
+func NewModelPermission(id string, created int64) ModelPermission {
+	return ModelPermission{
+		ID:            id,
+		Object:        ObjectModelPermission,
+		Created:       created,
+		AllowSampling: true,
+		AllowLogprobs: true,
+		AllowView:     true,
+		Organization:  "*",
+	}
 }
 
 // modelsResponse is the response of /models API